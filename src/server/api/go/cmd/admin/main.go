@@ -93,8 +93,14 @@ func main() {
 	sandboxHandler := do.MustInvoke[*handler.SandboxHandler](inj)
 	learningSpaceHandler := do.MustInvoke[*handler.LearningSpaceHandler](inj)
 	sessionEventHandler := do.MustInvoke[*handler.SessionEventHandler](inj)
+	messageEmbeddingHandler := do.MustInvoke[*handler.MessageEmbeddingHandler](inj)
+	enrichmentHandler := do.MustInvoke[*handler.EnrichmentHandler](inj)
+	messageStreamHandler := do.MustInvoke[*handler.MessageStreamHandler](inj)
 	projectHandler := do.MustInvoke[*handler.ProjectHandler](inj)
 	materialHandler := do.MustInvoke[*handler.MaterialHandler](inj)
+	assetUploadHandler := do.MustInvoke[*handler.AssetUploadHandler](inj)
+	assetContentHandler := do.MustInvoke[*handler.AssetContentHandler](inj)
+	healthHandler := do.MustInvoke[*handler.HealthHandler](inj)
 
 	// build admin-specific handlers
 	adminHandler := do.MustInvoke[*handler.AdminHandler](inj)
@@ -102,20 +108,26 @@ func main() {
 
 	engine := router.NewAdminRouter(router.AdminRouterDeps{
 		RouterDeps: router.RouterDeps{
-			Config:               cfg,
-			DB:                   db,
-			Log:                  log,
-			SessionHandler:       sessionHandler,
-			DiskHandler:          diskHandler,
-			ArtifactHandler:      artifactHandler,
-			TaskHandler:          taskHandler,
-			AgentSkillsHandler:   agentSkillsHandler,
-			UserHandler:          userHandler,
-			SandboxHandler:       sandboxHandler,
-			LearningSpaceHandler: learningSpaceHandler,
-			SessionEventHandler:  sessionEventHandler,
-			ProjectHandler:       projectHandler,
-			MaterialHandler:      materialHandler,
+			Config:                  cfg,
+			DB:                      db,
+			Log:                     log,
+			SessionHandler:          sessionHandler,
+			DiskHandler:             diskHandler,
+			ArtifactHandler:         artifactHandler,
+			TaskHandler:             taskHandler,
+			AgentSkillsHandler:      agentSkillsHandler,
+			UserHandler:             userHandler,
+			SandboxHandler:          sandboxHandler,
+			LearningSpaceHandler:    learningSpaceHandler,
+			SessionEventHandler:     sessionEventHandler,
+			MessageEmbeddingHandler: messageEmbeddingHandler,
+			EnrichmentHandler:       enrichmentHandler,
+			MessageStreamHandler:    messageStreamHandler,
+			ProjectHandler:          projectHandler,
+			MaterialHandler:         materialHandler,
+			AssetUploadHandler:      assetUploadHandler,
+			AssetContentHandler:     assetContentHandler,
+			HealthHandler:           healthHandler,
 		},
 		AdminHandler:   adminHandler,
 		MetricsHandler: metricsHandler,
@@ -134,6 +146,10 @@ func main() {
 	assetRefBuffer := do.MustInvoke[repo.AssetRefBuffer](inj)
 	assetRefBuffer.Start()
 
+	// Start the LISTEN/NOTIFY listener feeding session streams.
+	listener := do.MustInvoke[*dbpkg.Listener](inj)
+	listener.Start()
+
 	go func() {
 		log.Sugar().Infow("starting admin http server", "addr", addr)
 		log.Sugar().Infow("swagger url", "url", addr+"/swagger/index.html")
@@ -149,6 +165,7 @@ func main() {
 
 	// Stop the asset reference buffer first (final flush to DB).
 	assetRefBuffer.Stop()
+	listener.Stop()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -15,6 +15,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,16 +25,22 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/memodb-io/Acontext/internal/bootstrap"
 	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/grpcserver"
 	"github.com/memodb-io/Acontext/internal/infra/cache"
 	dbpkg "github.com/memodb-io/Acontext/internal/infra/db"
+	"github.com/memodb-io/Acontext/internal/infra/ratelimit"
+	"github.com/memodb-io/Acontext/internal/middleware"
 	"github.com/memodb-io/Acontext/internal/modules/handler"
 	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/memodb-io/Acontext/internal/pkg/metrics"
 	"github.com/memodb-io/Acontext/internal/pkg/tokenizer"
 	"github.com/memodb-io/Acontext/internal/router"
 	"github.com/memodb-io/Acontext/internal/telemetry"
 	"github.com/redis/go-redis/v9"
 	"github.com/samber/do"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 	"gorm.io/gorm"
 )
 
@@ -93,24 +100,38 @@ func main() {
 	sandboxHandler := do.MustInvoke[*handler.SandboxHandler](inj)
 	learningSpaceHandler := do.MustInvoke[*handler.LearningSpaceHandler](inj)
 	sessionEventHandler := do.MustInvoke[*handler.SessionEventHandler](inj)
+	messageEmbeddingHandler := do.MustInvoke[*handler.MessageEmbeddingHandler](inj)
+	enrichmentHandler := do.MustInvoke[*handler.EnrichmentHandler](inj)
+	messageStreamHandler := do.MustInvoke[*handler.MessageStreamHandler](inj)
 	projectHandler := do.MustInvoke[*handler.ProjectHandler](inj)
 	materialHandler := do.MustInvoke[*handler.MaterialHandler](inj)
+	assetUploadHandler := do.MustInvoke[*handler.AssetUploadHandler](inj)
+	assetContentHandler := do.MustInvoke[*handler.AssetContentHandler](inj)
+	healthHandler := do.MustInvoke[*handler.HealthHandler](inj)
+	messageLimiter := do.MustInvoke[ratelimit.Limiter](inj)
 	engine := router.NewRouter(router.RouterDeps{
-		Config:               cfg,
-		DB:                   db,
-		Redis:                rdb,
-		Log:                  log,
-		SessionHandler:       sessionHandler,
-		DiskHandler:          diskHandler,
-		ArtifactHandler:      artifactHandler,
-		TaskHandler:          taskHandler,
-		AgentSkillsHandler:   agentSkillsHandler,
-		UserHandler:          userHandler,
-		SandboxHandler:       sandboxHandler,
-		LearningSpaceHandler: learningSpaceHandler,
-		SessionEventHandler:  sessionEventHandler,
-		ProjectHandler:       projectHandler,
-		MaterialHandler:      materialHandler,
+		Config:                  cfg,
+		DB:                      db,
+		Redis:                   rdb,
+		Log:                     log,
+		SessionHandler:          sessionHandler,
+		DiskHandler:             diskHandler,
+		ArtifactHandler:         artifactHandler,
+		TaskHandler:             taskHandler,
+		AgentSkillsHandler:      agentSkillsHandler,
+		UserHandler:             userHandler,
+		SandboxHandler:          sandboxHandler,
+		LearningSpaceHandler:    learningSpaceHandler,
+		SessionEventHandler:     sessionEventHandler,
+		MessageEmbeddingHandler: messageEmbeddingHandler,
+		EnrichmentHandler:       enrichmentHandler,
+		MessageStreamHandler:    messageStreamHandler,
+		ProjectHandler:          projectHandler,
+		MaterialHandler:         materialHandler,
+		AssetUploadHandler:      assetUploadHandler,
+		AssetContentHandler:     assetContentHandler,
+		HealthHandler:           healthHandler,
+		MessageLimiter:          messageLimiter,
 	})
 
 	addr := fmt.Sprintf("%s:%d", cfg.App.Host, cfg.App.Port)
@@ -122,10 +143,59 @@ func main() {
 		IdleTimeout:       120 * time.Second,
 	}
 
+	// The gRPC surface serves the same services as the REST session and message endpoints.
+	var grpcSrv *grpc.Server
+	if cfg.App.GRPCPort > 0 {
+		grpcSrv = grpcserver.NewServer(grpcserver.ServerDeps{
+			Authenticate: func(ctx context.Context, token string) (*middleware.Principal, error) {
+				return middleware.AuthenticateProject(ctx, cfg, db, rdb, token)
+			},
+			SessionService:       do.MustInvoke[service.SessionService](inj),
+			UserService:          do.MustInvoke[service.UserService](inj),
+			MessageStreamService: do.MustInvoke[service.MessageStreamService](inj),
+			Config:               cfg,
+			MessageLimiter:       messageLimiter,
+			Log:                  log,
+		})
+	}
+
 	// Start the Redis-buffered asset reference writer.
 	assetRefBuffer := do.MustInvoke[repo.AssetRefBuffer](inj)
 	assetRefBuffer.Start()
 
+	// Start the LISTEN/NOTIFY listener feeding session streams.
+	listener := do.MustInvoke[*dbpkg.Listener](inj)
+	listener.Start()
+
+	// Start the purger for soft-deleted sessions and messages.
+	deletedPurger := do.MustInvoke[service.DeletedPurger](inj)
+	deletedPurger.Start()
+
+	// Start the purger for presigned uploads that expired unconfirmed.
+	uploadPurger := do.MustInvoke[service.PendingUploadPurger](inj)
+	uploadPurger.Start()
+
+	// Start the collector for assets left without references.
+	orphanCollector := do.MustInvoke[service.OrphanAssetCollector](inj)
+	orphanCollector.Start()
+
+	// Start the worker running queued part enrichments.
+	enrichmentWorker := do.MustInvoke[service.EnrichmentWorker](inj)
+	enrichmentWorker.Start()
+
+	// Start the worker running queued background jobs.
+	jobWorker := do.MustInvoke[service.JobWorker](inj)
+	jobWorker.Start()
+
+	// Report the enrichment backlog to the metrics listener; it is counted when scraped.
+	enrichmentRepo := do.MustInvoke[repo.PartEnrichmentRepo](inj)
+	metrics.PendingEnrichmentJobs.Bind(func() (float64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		n, err := enrichmentRepo.CountPending(ctx)
+		return float64(n), err
+	})
+
 	go func() {
 		log.Sugar().Infow("starting http server", "addr", addr)
 		log.Sugar().Infow("swagger url", "url", addr+"/swagger/index.html")
@@ -135,19 +205,65 @@ func main() {
 		}
 	}()
 
+	if grpcSrv != nil {
+		grpcAddr := fmt.Sprintf("%s:%d", cfg.App.Host, cfg.App.GRPCPort)
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Sugar().Fatalw("grpc listen error", "addr", grpcAddr, "err", err)
+		}
+		go func() {
+			log.Sugar().Infow("starting grpc server", "addr", grpcAddr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Sugar().Fatalw("grpc serve error", "err", err)
+			}
+		}()
+	}
+
+	// The scrape endpoint gets its own listener so it is never reachable through the public API.
+	var metricsSrv *http.Server
+	if cfg.App.MetricsPort > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default.Handler())
+		metricsSrv = &http.Server{
+			Addr:              fmt.Sprintf("%s:%d", cfg.App.Host, cfg.App.MetricsPort),
+			Handler:           mux,
+			ReadHeaderTimeout: 30 * time.Second,
+		}
+		go func() {
+			log.Sugar().Infow("starting metrics server", "addr", metricsSrv.Addr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Sugar().Fatalw("metrics listen error", "err", err)
+			}
+		}()
+	}
+
 	// graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	// Stop the asset reference buffer first (final flush to DB).
+	// Stop the purgers, then the asset reference buffer (final flush to DB).
+	deletedPurger.Stop()
+	uploadPurger.Stop()
+	orphanCollector.Stop()
+	enrichmentWorker.Stop()
+	jobWorker.Stop()
 	assetRefBuffer.Stop()
+	listener.Stop()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Sugar().Errorw("server shutdown", "err", err)
 	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Sugar().Errorw("metrics server shutdown", "err", err)
+		}
+	}
 
 	log.Sugar().Info("server exited")
 }
@@ -15,10 +15,18 @@ import (
 	"github.com/memodb-io/Acontext/internal/infra/httpclient"
 	"github.com/memodb-io/Acontext/internal/infra/logger"
 	mq "github.com/memodb-io/Acontext/internal/infra/queue"
+	"github.com/memodb-io/Acontext/internal/infra/ratelimit"
 	"github.com/memodb-io/Acontext/internal/modules/handler"
 	"github.com/memodb-io/Acontext/internal/modules/model"
 	"github.com/memodb-io/Acontext/internal/modules/repo"
 	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/memodb-io/Acontext/internal/pkg/embedder"
+	"github.com/memodb-io/Acontext/internal/pkg/enricher"
+	"github.com/memodb-io/Acontext/internal/pkg/hook"
+	"github.com/memodb-io/Acontext/internal/pkg/mediameta"
+	"github.com/memodb-io/Acontext/internal/pkg/moderation"
+	"github.com/memodb-io/Acontext/internal/pkg/summarizer"
+	"github.com/memodb-io/Acontext/internal/pkg/toolschema"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/redis/go-redis/v9"
 	"github.com/samber/do"
@@ -103,10 +111,12 @@ func BuildContainer() *do.Injector {
 		if err != nil {
 			return nil, err
 		}
+		model.UseUUIDv7MessageIDs(cfg.MessageID.UUIDv7)
 		// [optional] auto migrate
 		if cfg.Database.AutoMigrate {
 			_ = d.AutoMigrate(
 				&model.Project{},
+				&model.ProjectKey{},
 				&model.User{},
 				&model.Session{},
 				&model.Task{},
@@ -121,7 +131,55 @@ func BuildContainer() *do.Injector {
 				&model.LearningSpaceSkill{},
 				&model.LearningSpaceSession{},
 				&model.SessionEvent{},
+				&model.AssetUpload{},
+				&model.MessageRevision{},
+				&model.PartEnrichment{},
+				&model.MessageFlagAudit{},
+				&model.MessageAnnotation{},
+				&model.ImmutabilityOverride{},
+				&model.Job{},
+				&model.DataMigration{},
 			)
+			// Backfills that scan every artifact, session or message run once; stats drift found
+			// later is repaired from the admin stats check.
+			if n, _, err := repo.RunDataMigration(context.Background(), d, model.DataMigrationArtifactHashes, func() (int64, error) {
+				return repo.BackfillArtifactHashes(context.Background(), d, 1000)
+			}); err != nil {
+				log.Warn("backfill artifact hashes", zap.Error(err))
+			} else if n > 0 {
+				log.Info("backfilled artifact hashes", zap.Int64("rows", n))
+			}
+			if n, _, err := repo.RunDataMigration(context.Background(), d, model.DataMigrationMessageSeqs, func() (int64, error) {
+				return repo.BackfillMessageSeqs(context.Background(), d, 1000)
+			}); err != nil {
+				log.Warn("backfill message seqs", zap.Error(err))
+			} else if n > 0 {
+				log.Info("backfilled message seqs", zap.Int64("sessions", n))
+			}
+			if n, _, err := repo.RunDataMigration(context.Background(), d, model.DataMigrationMessageVersions, func() (int64, error) {
+				return repo.BackfillMessageVersions(context.Background(), d, 1000)
+			}); err != nil {
+				log.Warn("backfill message versions", zap.Error(err))
+			} else if n > 0 {
+				log.Info("backfilled message versions", zap.Int64("messages", n))
+			}
+			if n, _, err := repo.RunDataMigration(context.Background(), d, model.DataMigrationSessionStats, func() (int64, error) {
+				return repo.BackfillSessionStats(context.Background(), d, 1000)
+			}); err != nil {
+				log.Warn("backfill session stats", zap.Error(err))
+			} else if n > 0 {
+				log.Info("backfilled session stats", zap.Int64("sessions", n))
+			}
+			// Expression indexes are not expressible through struct tags.
+			if err := d.Exec(model.MessageSearchIndexDDL).Error; err != nil {
+				log.Warn("create message search index", zap.Error(err))
+			}
+			// Vector search is optional: skip the embeddings table when pgvector is unavailable.
+			if err := d.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+				log.Warn("pgvector unavailable, vector search disabled", zap.Error(err))
+			} else if err := d.AutoMigrate(&model.MessageEmbedding{}, &model.EmbeddingReindex{}); err != nil {
+				log.Warn("migrate message embeddings", zap.Error(err))
+			}
 		}
 
 		// ensure default project exists
@@ -132,12 +190,23 @@ func BuildContainer() *do.Injector {
 		return d, nil
 	})
 
+	// Postgres LISTEN/NOTIFY listener for session streams
+	do.Provide(inj, func(i *do.Injector) (*db.Listener, error) {
+		return db.NewListener(do.MustInvoke[*gorm.DB](i), do.MustInvoke[*zap.Logger](i)), nil
+	})
+
 	// Redis
 	do.Provide(inj, func(i *do.Injector) (*redis.Client, error) {
 		cfg := do.MustInvoke[*config.Config](i)
 		return cache.New(cfg)
 	})
 
+	// Message rate limiter, shared by the REST and gRPC transports
+	do.Provide(inj, func(i *do.Injector) (ratelimit.Limiter, error) {
+		cfg := do.MustInvoke[*config.Config](i)
+		return ratelimit.New(cfg.RateLimit.Backend, do.MustInvoke[*redis.Client](i), do.MustInvoke[*zap.Logger](i)), nil
+	})
+
 	// RabbitMQ DialFunc for connection and reconnection
 	do.Provide(inj, func(i *do.Injector) (mq.DialFunc, error) {
 		cfg := do.MustInvoke[*config.Config](i)
@@ -257,6 +326,15 @@ func BuildContainer() *do.Injector {
 	do.Provide(inj, func(i *do.Injector) (repo.SessionEventRepo, error) {
 		return repo.NewSessionEventRepo(do.MustInvoke[*gorm.DB](i)), nil
 	})
+	do.Provide(inj, func(i *do.Injector) (repo.MessageEmbeddingRepo, error) {
+		return repo.NewMessageEmbeddingRepo(do.MustInvoke[*gorm.DB](i)), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (repo.PartEnrichmentRepo, error) {
+		return repo.NewPartEnrichmentRepo(do.MustInvoke[*gorm.DB](i)), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (repo.JobRepo, error) {
+		return repo.NewJobRepo(do.MustInvoke[*gorm.DB](i)), nil
+	})
 
 	// Material Service (must be before other services that depend on it)
 	do.Provide(inj, func(i *do.Injector) (service.MaterialService, error) {
@@ -267,6 +345,20 @@ func BuildContainer() *do.Injector {
 		), nil
 	})
 
+	// Advisory session locks (Redis-backed)
+	do.Provide(inj, func(i *do.Injector) (repo.ProjectKeyRepo, error) {
+		return repo.NewProjectKeyRepo(do.MustInvoke[*gorm.DB](i)), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.ProjectKeyService, error) {
+		return service.NewProjectKeyService(
+			do.MustInvoke[repo.ProjectKeyRepo](i),
+			do.MustInvoke[*config.Config](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (repo.SessionLockRepo, error) {
+		return repo.NewSessionLockRepo(do.MustInvoke[*redis.Client](i)), nil
+	})
+
 	// Asset reference buffer (Redis-backed, flushed to DB periodically)
 	do.Provide(inj, func(i *do.Injector) (repo.AssetRefBuffer, error) {
 		return repo.NewAssetRefBuffer(
@@ -276,7 +368,105 @@ func BuildContainer() *do.Injector {
 		), nil
 	})
 
+	// Asset metadata extractors, per MIME family; nil when extraction is disabled. Register more
+	// extractors on the registry before the server starts.
+	do.Provide(inj, func(i *do.Injector) (*mediameta.Registry, error) {
+		if !do.MustInvoke[*config.Config](i).AssetMeta.Enabled {
+			return nil, nil
+		}
+		return mediameta.NewDefaultRegistry(), nil
+	})
+
+	// Enrichers for media parts; register providers on the registry before the server starts.
+	do.Provide(inj, func(i *do.Injector) (*enricher.Registry, error) {
+		reg := enricher.NewRegistry()
+		if extractors := do.MustInvoke[*mediameta.Registry](i); extractors != nil {
+			minBytes := do.MustInvoke[*config.Config](i).AssetMeta.SyncMaxBytes
+			if err := reg.Register(enricher.NewAssetMetadata(extractors, minBytes)); err != nil {
+				return nil, err
+			}
+		}
+		if cfg := do.MustInvoke[*config.Config](i).Thumbnail; cfg.Enabled && len(cfg.Sizes) > 0 {
+			store := enricher.NewS3DerivativeStore(do.MustInvoke[*blob.S3Deps](i))
+			if err := reg.Register(enricher.NewThumbnailer(store, cfg.Sizes, cfg.MaxPixels)); err != nil {
+				return nil, err
+			}
+		}
+		return reg, nil
+	})
+
+	// Tool argument schemas; more may be registered on the registry before the server starts.
+	do.Provide(inj, func(i *do.Injector) (*toolschema.Registry, error) {
+		reg := toolschema.NewRegistry()
+		if dir := do.MustInvoke[*config.Config](i).ToolSchema.Dir; dir != "" {
+			if err := reg.LoadDir(dir); err != nil {
+				return nil, err
+			}
+		}
+		return reg, nil
+	})
+
+	// Message hooks; register custom hooks on the chain before the server starts.
+	do.Provide(inj, func(i *do.Injector) (*hook.Chain, error) {
+		chain := hook.NewChain()
+		if do.MustInvoke[*config.Config](i).Hook.RedactPII {
+			chain.Register(hook.NewPIIRedactor())
+		}
+		return chain, nil
+	})
+
+	// Moderation provider; nil leaves new messages unflagged. Replace with a hosted moderation
+	// API to flag beyond the configured terms.
+	do.Provide(inj, func(i *do.Injector) (moderation.Provider, error) {
+		if terms := do.MustInvoke[*config.Config](i).Moderation.BlockedTerms; len(terms) > 0 {
+			return moderation.NewKeywordProvider(terms), nil
+		}
+		return nil, nil
+	})
+
+	// Session summarizer; replace with a model-backed implementation to get abstractive summaries.
+	do.Provide(inj, func(i *do.Injector) (summarizer.Summarizer, error) {
+		return summarizer.NewExtractive(summarizer.DefaultMaxCharsPerMessage), nil
+	})
+
 	// Service
+	do.Provide(inj, func(i *do.Injector) (service.EnrichmentService, error) {
+		return service.NewEnrichmentService(
+			do.MustInvoke[repo.PartEnrichmentRepo](i),
+			do.MustInvoke[repo.SessionRepo](i),
+			do.MustInvoke[repo.AssetReferenceRepo](i),
+			do.MustInvoke[repo.AssetRefBuffer](i),
+			do.MustInvoke[*enricher.Registry](i),
+			do.MustInvoke[*blob.S3Deps](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.EnrichmentWorker, error) {
+		return service.NewEnrichmentWorker(
+			do.MustInvoke[service.EnrichmentService](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (*service.JobRegistry, error) {
+		return service.NewJobRegistry(), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.JobQueue, error) {
+		return service.NewJobQueue(
+			do.MustInvoke[repo.JobRepo](i),
+			do.MustInvoke[*service.JobRegistry](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.JobWorker, error) {
+		return service.NewJobWorker(
+			do.MustInvoke[service.JobQueue](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
+		), nil
+	})
 	do.Provide(inj, func(i *do.Injector) (service.SessionService, error) {
 		return service.NewSessionService(
 			do.MustInvoke[repo.SessionRepo](i),
@@ -289,6 +479,59 @@ func BuildContainer() *do.Injector {
 			do.MustInvoke[*config.Config](i),
 			do.MustInvoke[*redis.Client](i),
 			do.MustInvoke[service.MaterialService](i),
+			do.MustInvoke[service.EnrichmentService](i),
+			do.MustInvoke[summarizer.Summarizer](i),
+			do.MustInvoke[*toolschema.Registry](i),
+			do.MustInvoke[*hook.Chain](i),
+			do.MustInvoke[moderation.Provider](i),
+			do.MustInvoke[*mediameta.Registry](i),
+			do.MustInvoke[repo.SessionLockRepo](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.DeletedPurger, error) {
+		return service.NewDeletedPurger(
+			do.MustInvoke[service.SessionService](i),
+			do.MustInvoke[*redis.Client](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (repo.AssetUploadRepo, error) {
+		return repo.NewAssetUploadRepo(do.MustInvoke[*gorm.DB](i)), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.AssetUploadService, error) {
+		return service.NewAssetUploadService(
+			do.MustInvoke[repo.AssetUploadRepo](i),
+			do.MustInvoke[repo.AssetReferenceRepo](i),
+			do.MustInvoke[*blob.S3Deps](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
+			do.MustInvoke[*mediameta.Registry](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.AssetContentService, error) {
+		return service.NewAssetContentService(
+			do.MustInvoke[repo.AssetReferenceRepo](i),
+			do.MustInvoke[*blob.S3Deps](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.PendingUploadPurger, error) {
+		return service.NewPendingUploadPurger(
+			do.MustInvoke[service.AssetUploadService](i),
+			do.MustInvoke[*redis.Client](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.AssetGCService, error) {
+		return service.NewAssetGCService(do.MustInvoke[repo.AssetReferenceRepo](i)), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.OrphanAssetCollector, error) {
+		return service.NewOrphanAssetCollector(
+			do.MustInvoke[service.AssetGCService](i),
+			do.MustInvoke[*redis.Client](i),
+			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[*zap.Logger](i),
 		), nil
 	})
 	do.Provide(inj, func(i *do.Injector) (service.DiskService, error) {
@@ -299,6 +542,7 @@ func BuildContainer() *do.Injector {
 			do.MustInvoke[repo.ArtifactRepo](i),
 			do.MustInvoke[*blob.S3Deps](i),
 			do.MustInvoke[repo.AgentSkillsRepo](i),
+			do.MustInvoke[repo.AssetReferenceRepo](i),
 			do.MustInvoke[*zap.Logger](i),
 		), nil
 	})
@@ -328,6 +572,32 @@ func BuildContainer() *do.Injector {
 			do.MustInvoke[repo.SessionEventRepo](i),
 		), nil
 	})
+	do.Provide(inj, func(i *do.Injector) (service.MessageEmbeddingService, error) {
+		cfg := do.MustInvoke[*config.Config](i)
+		var emb embedder.Embedder
+		if cfg.Embedding.APIKey != "" {
+			emb = embedder.NewOpenAI(cfg.Embedding.APIKey, cfg.Embedding.BaseURL)
+		}
+		svc := service.NewMessageEmbeddingService(
+			do.MustInvoke[repo.SessionRepo](i),
+			do.MustInvoke[repo.MessageEmbeddingRepo](i),
+			emb,
+			do.MustInvoke[service.JobQueue](i),
+			cfg,
+			do.MustInvoke[*zap.Logger](i),
+		)
+		if err := do.MustInvoke[*service.JobRegistry](i).Register(service.JobKindEmbeddingReindex, svc.RunReindexJob); err != nil {
+			return nil, err
+		}
+		return svc, nil
+	})
+	do.Provide(inj, func(i *do.Injector) (service.MessageStreamService, error) {
+		return service.NewMessageStreamService(
+			do.MustInvoke[repo.SessionRepo](i),
+			do.MustInvoke[*db.Listener](i),
+			do.MustInvoke[*zap.Logger](i),
+		), nil
+	})
 	do.Provide(inj, func(i *do.Injector) (service.LearningSpaceService, error) {
 		if err := validateSkillTemplates(); err != nil {
 			return nil, err
@@ -397,12 +667,46 @@ func BuildContainer() *do.Injector {
 			do.MustInvoke[service.SessionEventService](i),
 		), nil
 	})
+	do.Provide(inj, func(i *do.Injector) (*handler.EnrichmentHandler, error) {
+		return handler.NewEnrichmentHandler(
+			do.MustInvoke[service.EnrichmentService](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (*handler.MessageEmbeddingHandler, error) {
+		return handler.NewMessageEmbeddingHandler(
+			do.MustInvoke[service.MessageEmbeddingService](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (*handler.MessageStreamHandler, error) {
+		return handler.NewMessageStreamHandler(
+			do.MustInvoke[service.MessageStreamService](i),
+		), nil
+	})
 	do.Provide(inj, func(i *do.Injector) (*handler.LearningSpaceHandler, error) {
 		return handler.NewLearningSpaceHandler(
 			do.MustInvoke[service.LearningSpaceService](i),
 			do.MustInvoke[service.UserService](i),
 		), nil
 	})
+	do.Provide(inj, func(i *do.Injector) (*handler.AssetUploadHandler, error) {
+		return handler.NewAssetUploadHandler(
+			do.MustInvoke[service.AssetUploadService](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (*handler.AssetContentHandler, error) {
+		return handler.NewAssetContentHandler(
+			do.MustInvoke[service.AssetContentService](i),
+		), nil
+	})
+	do.Provide(inj, func(i *do.Injector) (*handler.HealthHandler, error) {
+		cfg := do.MustInvoke[*config.Config](i)
+		gormDB := do.MustInvoke[*gorm.DB](i)
+		return handler.NewHealthHandler(
+			time.Duration(cfg.Health.CheckTimeoutMs)*time.Millisecond,
+			handler.HealthCheck{Name: "database", Check: func(ctx context.Context) error { return db.Ping(ctx, gormDB) }},
+			handler.HealthCheck{Name: "storage", Check: do.MustInvoke[*blob.S3Deps](i).Ping},
+		), nil
+	})
 	do.Provide(inj, func(i *do.Injector) (*handler.MaterialHandler, error) {
 		return handler.NewMaterialHandler(
 			do.MustInvoke[service.MaterialService](i),
@@ -414,6 +718,7 @@ func BuildContainer() *do.Injector {
 			do.MustInvoke[*redis.Client](i),
 			do.MustInvoke[*blob.S3Deps](i),
 			do.MustInvoke[repo.AssetReferenceRepo](i),
+			do.MustInvoke[service.ProjectKeyService](i),
 		), nil
 	})
 	return inj
@@ -49,6 +49,8 @@ func BuildAdminContainer() *do.Injector {
 			do.MustInvoke[*gorm.DB](i),
 			do.MustInvoke[*redis.Client](i),
 			do.MustInvoke[*config.Config](i),
+			do.MustInvoke[service.AssetGCService](i),
+			do.MustInvoke[service.JobQueue](i),
 		), nil
 	})
 	do.Provide(inj, func(i *do.Injector) (*handler.MetricsHandler, error) {
@@ -15,6 +15,8 @@ type AppCfg struct {
 	Env         string
 	Host        string
 	Port        int
+	GRPCPort    int    // Port of the gRPC server on Host; 0 disables it
+	MetricsPort int    // Port of the Prometheus scrape endpoint on Host; 0 disables it
 	ExternalURL string // Base URL for constructing material URLs (e.g. https://api.example.com)
 }
 
@@ -107,25 +109,150 @@ type AssetRefWriterCfg struct {
 	FlushIntervalMs int  // Flush interval in milliseconds (default 1000)
 }
 
+type RetentionCfg struct {
+	SoftDeleteHours  int // Hours soft-deleted sessions/messages are kept before purge; <= 0 disables purging (default 720)
+	PurgeIntervalSec int // Interval between purge runs in seconds (default 3600)
+	OrphanAssetHours int // Hours an unreferenced asset is kept before collection; <= 0 disables collection (default 24)
+}
+
+type UploadCfg struct {
+	MaxSizeBytes     int64    // Maximum size of a presigned upload; projects may lower it with project_config.max_upload_size_bytes (default 512MB)
+	MaxBundleBytes   int64    // Uncompressed size an imported session bundle may expand to; each file is also held to MaxSizeBytes (default 2GB)
+	AllowedMIMETypes []string // MIME types accepted for presigned uploads; "type/*" matches a whole family
+	PendingTTLSec    int      // Seconds an unconfirmed upload is kept before it is garbage-collected (default 3600)
+	GCIntervalSec    int      // Interval between garbage-collection runs in seconds (default 600)
+}
+
+type QuotaCfg struct {
+	MaxSessionBytes      int64 // Storage a session's live messages may use; 0 disables the limit
+	MaxAssetBytes        int64 // Largest file accepted as a message part; 0 disables the limit
+	MaxMessageParts      int   // Parts a message may have; projects may lower it with project_config.max_message_parts; 0 disables the limit
+	MaxMessagePartsBytes int64 // Serialized size of a message's parts; projects may lower it with project_config.max_message_parts_bytes; 0 disables the limit
+}
+
+type EnrichmentCfg struct {
+	PollIntervalSec int   // Interval between enrichment queue polls in seconds; <= 0 disables the worker (default 5)
+	BatchSize       int   // Jobs claimed per poll (default 10)
+	JobTimeoutSec   int   // Seconds a running job may take before another worker reclaims it (default 600)
+	MaxAssetBytes   int64 // Largest asset downloaded for enrichment; larger assets fail their jobs (default 100MB)
+}
+
+type JobsCfg struct {
+	PollIntervalSec int // Interval between job queue polls in seconds; <= 0 disables the worker (default 2)
+	BatchSize       int // Jobs claimed per poll (default 10)
+	JobTimeoutSec   int // Seconds a running job may take before another worker reclaims it (default 600)
+	MaxAttempts     int // Runs a job gets before it is moved to the dead letters (default 5)
+	BackoffBaseSec  int // Delay before the first retry in seconds; it doubles with each further failure (default 10)
+	BackoffMaxSec   int // Longest delay between retries in seconds (default 3600)
+}
+
+type ArchiveCfg struct {
+	StorageClass string // S3 storage class of session archive objects, e.g. STANDARD_IA or GLACIER_IR; empty uses the bucket default (default "")
+}
+
+type ThumbnailCfg struct {
+	Enabled   bool  // Generate thumbnails of image parts through the enrichment worker (default true)
+	Sizes     []int // Edges of the boxes thumbnails are fitted in, in pixels (default [128, 512])
+	MaxPixels int64 // Largest image, in pixels, thumbnails are generated for (default 50,000,000)
+}
+
+type AssetMetaCfg struct {
+	Enabled      bool  // Extract dimensions, duration and codec of image, audio and video assets into Asset.Meta (default true)
+	SyncMaxBytes int64 // Largest asset read while it is uploaded; larger ones are read by the enrichment worker (default 8MB)
+}
+
+type SessionLockCfg struct {
+	DefaultTTLSec int // Seconds a session lock lasts when the holder asks for no ttl (default 60)
+	MaxTTLSec     int // Longest ttl a holder may ask for, in seconds (default 3600)
+}
+
+type RateLimitCfg struct {
+	MessageBurst      int     // Messages a session may create at once; projects may override it with project_config.message_rate_burst; <= 0 disables the limit (default 0)
+	MessageRatePerSec float64 // Messages per second the burst refills at; projects may override it with project_config.message_rate_per_sec (default 1)
+	PerAPIKey         bool    // Also apply the message limit across all sessions of an API key (default false)
+	Backend           string  // "redis" shares limiter state across instances, "memory" keeps it per instance (default redis)
+}
+
+type ToolSchemaCfg struct {
+	Dir              string // Directory of <tool>.json argument schemas registered at startup; empty registers none (default "")
+	WarnUnregistered bool   // Log a warning for tool calls whose tool has no registered schema (default false)
+}
+
+type HookCfg struct {
+	RedactPII bool // Register the built-in hook that scrubs email addresses and phone numbers from text parts of new messages (default false)
+}
+
+type ModerationCfg struct {
+	BlockedTerms []string // Flag new messages whose text parts contain any of these terms, case-insensitively; empty disables moderation (default [])
+}
+
+type SearchCfg struct {
+	RecencyHalfLifeHours float64 // Age in hours at which the blended message search sort halves a match's rank; <= 0 disables the decay (default 168)
+}
+
+type MessageIDCfg struct {
+	UUIDv7 bool // Give new messages time-ordered UUIDv7 IDs instead of random v4 ones; existing IDs are unaffected (default false)
+}
+
+type EmbeddingCfg struct {
+	APIKey    string // API key of the OpenAI-compatible embeddings API; empty disables embedding reindexes (default "")
+	BaseURL   string // Base URL of the embeddings API; empty uses the OpenAI API (default "")
+	Model     string // Model reindexes embed with when the request names none (default text-embedding-3-small)
+	BatchSize int    // Messages embedded per request to the embeddings API (default 100)
+}
+
+type ImmutabilityCfg struct {
+	MessageWindowSec int // Seconds after which a message can no longer be edited or deleted without an admin override; <= 0 disables the window (default 0)
+}
+
+type PartCompressionCfg struct {
+	TextThresholdBytes int // Text parts longer than this are stored gzip-compressed in their parts object and decompressed on read; <= 0 disables compression (default 0)
+}
+
+type HealthCfg struct {
+	CheckTimeoutMs int // Milliseconds each /readyz dependency check may take before it is reported as timed out; <= 0 waits for the request only (default 2000)
+}
+
 type Config struct {
-	App            AppCfg
-	Root           RootCfg
-	Log            LogCfg
-	Database       DBCfg
-	Redis          RedisCfg
-	RabbitMQ       MQCfg
-	S3             S3Cfg
-	Core           CoreCfg
-	Metrics        MetricsCfg
-	Telemetry      TelemetryCfg
-	Supabase       SupabaseCfg
-	Artifact       ArtifactCfg
-	AssetRefWriter AssetRefWriterCfg
+	App             AppCfg
+	Root            RootCfg
+	Log             LogCfg
+	Database        DBCfg
+	Redis           RedisCfg
+	RabbitMQ        MQCfg
+	S3              S3Cfg
+	Core            CoreCfg
+	Metrics         MetricsCfg
+	Telemetry       TelemetryCfg
+	Supabase        SupabaseCfg
+	Artifact        ArtifactCfg
+	AssetRefWriter  AssetRefWriterCfg
+	Retention       RetentionCfg
+	Upload          UploadCfg
+	Quota           QuotaCfg
+	Enrichment      EnrichmentCfg
+	Jobs            JobsCfg
+	Archive         ArchiveCfg
+	Thumbnail       ThumbnailCfg
+	AssetMeta       AssetMetaCfg
+	SessionLock     SessionLockCfg
+	RateLimit       RateLimitCfg
+	ToolSchema      ToolSchemaCfg
+	Hook            HookCfg
+	Moderation      ModerationCfg
+	Search          SearchCfg
+	Immutability    ImmutabilityCfg
+	Embedding       EmbeddingCfg
+	MessageID       MessageIDCfg
+	PartCompression PartCompressionCfg
+	Health          HealthCfg
 }
 
 func setDefaults(v *viper.Viper) {
 	v.SetDefault("app.env", "debug")
 	v.SetDefault("app.port", 8029)
+	v.SetDefault("app.grpcport", 0)
+	v.SetDefault("app.metricsport", 0)
 	v.SetDefault("app.externalurl", "")
 	v.SetDefault("root.apiBearerToken", "AaGyw9Tl9qe4ydDh8qO0xdZNkrobQvwHWFRsnp5a3QtfbaDSDJQeRHxXPr4bGpc0g130EqBSjRNF")
 	v.SetDefault("root.projectBearerTokenPrefix", "sk-ac-")
@@ -162,6 +289,53 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("artifact.maxUploadSizeBytes", 16777216) // Default 16MB (16 * 1024 * 1024 bytes)
 	v.SetDefault("assetRefWriter.enabled", true)
 	v.SetDefault("assetRefWriter.flushIntervalMs", 1000)
+	v.SetDefault("retention.softDeleteHours", 720) // Default 30 days
+	v.SetDefault("retention.purgeIntervalSec", 3600)
+	v.SetDefault("retention.orphanAssetHours", 24)
+	v.SetDefault("upload.maxSizeBytes", 536870912)    // Default 512MB
+	v.SetDefault("upload.maxBundleBytes", 2147483648) // Default 2GB
+	v.SetDefault("upload.allowedMIMETypes", []string{"image/*", "audio/*", "video/*", "application/pdf"})
+	v.SetDefault("upload.pendingTTLSec", 3600)
+	v.SetDefault("upload.gcIntervalSec", 600)
+	v.SetDefault("quota.maxSessionBytes", 0)
+	v.SetDefault("quota.maxAssetBytes", 0)
+	v.SetDefault("quota.maxMessageParts", 0)
+	v.SetDefault("quota.maxMessagePartsBytes", 0)
+	v.SetDefault("enrichment.pollIntervalSec", 5)
+	v.SetDefault("enrichment.batchSize", 10)
+	v.SetDefault("enrichment.jobTimeoutSec", 600)
+	v.SetDefault("enrichment.maxAssetBytes", 104857600) // Default 100MB
+	v.SetDefault("jobs.pollIntervalSec", 2)
+	v.SetDefault("jobs.batchSize", 10)
+	v.SetDefault("jobs.jobTimeoutSec", 600)
+	v.SetDefault("jobs.maxAttempts", 5)
+	v.SetDefault("jobs.backoffBaseSec", 10)
+	v.SetDefault("jobs.backoffMaxSec", 3600)
+	v.SetDefault("archive.storageClass", "")
+	v.SetDefault("thumbnail.enabled", true)
+	v.SetDefault("thumbnail.sizes", []int{128, 512})
+	v.SetDefault("thumbnail.maxPixels", 50000000)
+	v.SetDefault("assetMeta.enabled", true)
+	v.SetDefault("assetMeta.syncMaxBytes", 8388608) // Default 8MB
+	v.SetDefault("sessionLock.defaultTTLSec", 60)
+	v.SetDefault("sessionLock.maxTTLSec", 3600)
+	v.SetDefault("rateLimit.messageBurst", 0)
+	v.SetDefault("rateLimit.messageRatePerSec", 1.0)
+	v.SetDefault("rateLimit.perAPIKey", false)
+	v.SetDefault("rateLimit.backend", "redis")
+	v.SetDefault("toolSchema.dir", "")
+	v.SetDefault("toolSchema.warnUnregistered", false)
+	v.SetDefault("hook.redactPII", false)
+	v.SetDefault("moderation.blockedTerms", []string{})
+	v.SetDefault("search.recencyHalfLifeHours", 168.0) // Default 7 days
+	v.SetDefault("immutability.messageWindowSec", 0)
+	v.SetDefault("embedding.apiKey", "")
+	v.SetDefault("embedding.baseURL", "")
+	v.SetDefault("embedding.model", "text-embedding-3-small")
+	v.SetDefault("embedding.batchSize", 100)
+	v.SetDefault("messageID.uuidv7", false)
+	v.SetDefault("partCompression.textThresholdBytes", 0)
+	v.SetDefault("health.checkTimeoutMs", 2000)
 }
 
 func Load() (*Config, error) {
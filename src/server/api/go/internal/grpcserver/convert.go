@@ -0,0 +1,147 @@
+package grpcserver
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	acontextv1 "github.com/memodb-io/Acontext/proto/acontext/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func sessionToPB(s *model.Session) *acontextv1.Session {
+	return &acontextv1.Session{
+		Id:            s.ID.String(),
+		ProjectId:     s.ProjectID.String(),
+		UserId:        uuidString(s.UserID),
+		Configs:       structOf(s.Configs),
+		Metadata:      structOf(s.Metadata),
+		Tags:          s.Tags,
+		IsTemplate:    s.IsTemplate,
+		Archived:      s.Archived,
+		MessageCount:  int32(s.MessageCount),
+		TotalBytes:    s.TotalBytes,
+		LastMessageAt: timestampOf(s.LastMessageAt),
+		CreatedAt:     timestampOf(s.CreatedAt),
+		UpdatedAt:     timestampOf(s.UpdatedAt),
+		FinalizedAt:   optionalTimestamp(s.FinalizedAt),
+	}
+}
+
+func messageToPB(m *model.Message) *acontextv1.Message {
+	parts := make([]*acontextv1.Part, len(m.Parts))
+	for i, p := range m.Parts {
+		parts[i] = &acontextv1.Part{
+			Type:     p.Type,
+			Text:     p.Text,
+			Asset:    assetToPB(p.Asset),
+			Filename: p.Filename,
+			Meta:     structOf(p.Meta),
+		}
+	}
+	return &acontextv1.Message{
+		Id:         m.ID.String(),
+		SessionId:  m.SessionID.String(),
+		ParentId:   uuidString(m.ParentID),
+		Seq:        m.Seq,
+		Role:       m.Role,
+		Parts:      parts,
+		Meta:       structOf(m.Meta.Data()),
+		AuthorId:   uuidString(m.AuthorID),
+		AuthorType: m.AuthorType,
+		TokenCount: int32(m.TokenCount),
+		Version:    int32(m.Version),
+		Pinned:     m.Pinned,
+		Streaming:  m.Streaming,
+		CreatedAt:  timestampOf(m.CreatedAt),
+		UpdatedAt:  timestampOf(m.UpdatedAt),
+	}
+}
+
+func assetToPB(a *model.Asset) *acontextv1.Asset {
+	if a == nil {
+		return nil
+	}
+	return &acontextv1.Asset{
+		Bucket: a.Bucket,
+		S3Key:  a.S3Key,
+		Etag:   a.ETag,
+		Sha256: a.SHA256,
+		Mime:   a.MIME,
+		SizeB:  a.SizeB,
+	}
+}
+
+func eventToPB(ev model.MessageStreamEvent) *acontextv1.MessageEvent {
+	return &acontextv1.MessageEvent{
+		Type:      ev.Type,
+		SessionId: ev.SessionID.String(),
+		MessageId: ev.MessageID.String(),
+		ParentId:  uuidString(ev.ParentID),
+		Role:      ev.Role,
+		Streaming: ev.Streaming,
+		Delta:     ev.Delta,
+		CreatedAt: optionalTimestamp(ev.CreatedAt),
+	}
+}
+
+// structOf converts a JSON object to a Struct through its JSON encoding, which also accepts the
+// typed slices and maps structpb.NewStruct rejects. Empty objects convert to nil.
+func structOf(m map[string]any) *structpb.Struct {
+	if len(m) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	s := &structpb.Struct{}
+	if err := protojson.Unmarshal(data, s); err != nil {
+		return nil
+	}
+	return s
+}
+
+// mapOf is the inverse of structOf; a nil Struct converts to nil.
+func mapOf(s *structpb.Struct) map[string]any {
+	if s == nil {
+		return nil
+	}
+	return s.AsMap()
+}
+
+func uuidString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+// parseOptionalUUID parses an optional ID field; empty parses to nil.
+func parseOptionalUUID(raw string) (*uuid.UUID, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func timestampOf(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestampOf(*t)
+}
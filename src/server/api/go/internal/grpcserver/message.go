@@ -0,0 +1,150 @@
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/memodb-io/Acontext/internal/pkg/normalizer"
+	acontextv1 "github.com/memodb-io/Acontext/proto/acontext/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxMetaSize matches the limit REST puts on user-provided message meta.
+const maxMetaSize = 64 * 1024
+
+func (s *messageServer) StoreMessage(ctx context.Context, req *acontextv1.StoreMessageRequest) (*acontextv1.Message, error) {
+	ctx, project, userKEK, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.allowMessage(ctx, project, req.GetSessionId()); err != nil {
+		return nil, err
+	}
+	session, err := s.projectSession(ctx, project, req.GetSessionId())
+	if err != nil {
+		return nil, err
+	}
+
+	userMeta := mapOf(req.GetMeta())
+	if userMeta != nil {
+		metaBytes, _ := json.Marshal(userMeta)
+		if len(metaBytes) > maxMetaSize {
+			return nil, status.Error(codes.InvalidArgument, "meta size exceeds 64KB limit")
+		}
+	}
+	authorID, err := parseOptionalUUID(req.GetAuthorId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid author_id: %v", err)
+	}
+
+	// The message goes through the acontext normalizer, which validates it like a REST message
+	// stored in that format.
+	parts := make([]service.PartIn, len(req.GetParts()))
+	for i, p := range req.GetParts() {
+		parts[i] = service.PartIn{Type: p.GetType(), Text: p.GetText(), Meta: mapOf(p.GetMeta())}
+	}
+	blob, err := json.Marshal(map[string]any{"role": req.GetRole(), "parts": parts})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid message: %v", err)
+	}
+	role, normalizedParts, meta, err := (&normalizer.AcontextNormalizer{}).Normalize(blob)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to normalize %s message: %v", model.FormatAcontext, err)
+	}
+	for _, p := range normalizedParts {
+		if p.FileField != "" {
+			return nil, status.Error(codes.InvalidArgument, "file parts are not supported over gRPC")
+		}
+	}
+	if len(userMeta) > 0 {
+		meta[model.UserMetaKey] = userMeta
+	}
+
+	msg, err := s.sessionSvc.StoreMessage(ctx, service.StoreMessageInput{
+		ProjectID:      project.ID,
+		SessionID:      session.ID,
+		Role:           role,
+		Parts:          normalizedParts,
+		Format:         model.FormatAcontext,
+		MessageMeta:    meta,
+		UserKEK:        userKEK,
+		IdempotencyKey: req.GetIdempotencyKey(),
+		Project:        project,
+		AuthorID:       authorID,
+		AuthorType:     req.GetAuthorType(),
+	})
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return messageToPB(msg), nil
+}
+
+func (s *messageServer) ListMessages(ctx context.Context, req *acontextv1.ListMessagesRequest) (*acontextv1.ListMessagesResponse, error) {
+	ctx, project, userKEK, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.projectSession(ctx, project, req.GetSessionId())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetLimit() < 0 || req.GetLimit() > maxListLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 0 and %d", maxListLimit)
+	}
+
+	out, err := s.sessionSvc.GetMessages(ctx, service.GetMessagesInput{
+		ProjectID: project.ID,
+		SessionID: session.ID,
+		Limit:     int(req.GetLimit()),
+		Cursor:    req.GetCursor(),
+		TimeDesc:  req.GetTimeDesc(),
+		Roles:     req.GetRoles(),
+		UserKEK:   userKEK,
+	})
+	if err != nil {
+		return nil, statusErr(err)
+	}
+
+	items := make([]*acontextv1.Message, len(out.Items))
+	for i := range out.Items {
+		items[i] = messageToPB(&out.Items[i])
+	}
+	return &acontextv1.ListMessagesResponse{Items: items, NextCursor: out.NextCursor, HasMore: out.HasMore}, nil
+}
+
+func (s *messageServer) SubscribeMessages(req *acontextv1.SubscribeMessagesRequest, stream grpc.ServerStreamingServer[acontextv1.MessageEvent]) error {
+	ctx := stream.Context()
+	ctx, project, _, err := s.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	sessionID, err := uuid.Parse(req.GetSessionId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid session_id: %v", err)
+	}
+
+	events, unsubscribe, err := s.streamSvc.Subscribe(ctx, project.ID, sessionID)
+	if err != nil {
+		return statusErr(err)
+	}
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(eventToPB(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
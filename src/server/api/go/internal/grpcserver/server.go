@@ -0,0 +1,163 @@
+// Package grpcserver serves the gRPC surface defined in proto/acontext/v1. Its RPCs mirror the REST
+// session and message endpoints and call the same service layer, so both transports behave alike.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/db"
+	"github.com/memodb-io/Acontext/internal/infra/ratelimit"
+	"github.com/memodb-io/Acontext/internal/middleware"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/memodb-io/Acontext/internal/pkg/paging"
+	acontextv1 "github.com/memodb-io/Acontext/proto/acontext/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator resolves a project bearer token, without its "Bearer " prefix, to its principal;
+// see middleware.AuthenticateProject.
+type Authenticator func(ctx context.Context, token string) (*middleware.Principal, error)
+
+type ServerDeps struct {
+	Authenticate         Authenticator
+	SessionService       service.SessionService
+	UserService          service.UserService
+	MessageStreamService service.MessageStreamService
+	Config               *config.Config
+	// MessageLimiter limits StoreMessage like middleware.MessageRateLimit limits the REST
+	// endpoint; share one limiter so both transports draw from the same buckets. Nil disables it.
+	MessageLimiter ratelimit.Limiter
+	Log            *zap.Logger
+}
+
+// server holds what the RPCs of both services share.
+type server struct {
+	auth       Authenticator
+	sessionSvc service.SessionService
+	userSvc    service.UserService
+	streamSvc  service.MessageStreamService
+	cfg        *config.Config
+	limiter    ratelimit.Limiter
+	log        *zap.Logger
+}
+
+type sessionServer struct {
+	acontextv1.UnimplementedSessionServiceServer
+	*server
+}
+
+type messageServer struct {
+	acontextv1.UnimplementedMessageServiceServer
+	*server
+}
+
+// NewServer returns a gRPC server with the session and message services registered.
+func NewServer(d ServerDeps, opts ...grpc.ServerOption) *grpc.Server {
+	s := &server{
+		auth:       d.Authenticate,
+		sessionSvc: d.SessionService,
+		userSvc:    d.UserService,
+		streamSvc:  d.MessageStreamService,
+		cfg:        d.Config,
+		limiter:    d.MessageLimiter,
+		log:        d.Log,
+	}
+	g := grpc.NewServer(opts...)
+	acontextv1.RegisterSessionServiceServer(g, &sessionServer{server: s})
+	acontextv1.RegisterMessageServiceServer(g, &messageServer{server: s})
+	return g
+}
+
+// authenticate checks the project token sent in the call's authorization metadata, like
+// middleware.ProjectAuth does for REST requests, and returns ctx carrying the scopes of the
+// token for the service layer to redact by. The user KEK is only returned for projects with
+// encryption enabled, as middleware.GetUserKEKIfEncrypted does.
+func (s *server) authenticate(ctx context.Context) (context.Context, *model.Project, []byte, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, nil, nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	principal, err := s.auth(ctx, strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil {
+		switch {
+		case errors.Is(err, middleware.ErrProjectUnauthorized):
+			return nil, nil, nil, status.Error(codes.Unauthenticated, "Unauthorized")
+		case errors.Is(err, middleware.ErrCompactTokenInvalid):
+			return nil, nil, nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	userKEK := principal.UserKEK
+	if !principal.Project.EncryptionEnabled {
+		userKEK = nil
+	}
+	ctx = context.WithValue(ctx, projectKeyIDKey{}, principal.KeyID)
+	return model.WithScopes(ctx, principal.Scopes), principal.Project, userKEK, nil
+}
+
+// projectKeyIDKey carries the middleware.Principal KeyID of the call on the context authenticate
+// returns, as middleware.ProjectAuth sets project_key_id for REST requests.
+type projectKeyIDKey struct{}
+
+// projectKeyID returns the project key the call authenticated with, or uuid.Nil for the project
+// secret key.
+func projectKeyID(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(projectKeyIDKey{}).(uuid.UUID)
+	return id
+}
+
+// allowMessage applies the message rate limit to a message stored in sessionID, sending the
+// rate limit headers REST sends. A call over the limit fails with ResourceExhausted.
+func (s *server) allowMessage(ctx context.Context, project *model.Project, sessionID string) error {
+	if s.limiter == nil || s.cfg == nil {
+		return nil
+	}
+	res := middleware.AllowMessage(ctx, s.cfg, s.limiter, project, projectKeyID(ctx), sessionID, s.log)
+	if res == nil {
+		return nil
+	}
+	md := metadata.Pairs(
+		strings.ToLower(middleware.RateLimitLimitHeader), strconv.Itoa(res.Limit),
+		strings.ToLower(middleware.RateLimitRemainingHeader), strconv.Itoa(res.Remaining),
+	)
+	if !res.Allowed {
+		md.Set("retry-after", strconv.Itoa(middleware.RetryAfterSeconds(*res)))
+	}
+	_ = grpc.SetHeader(ctx, md)
+	if !res.Allowed {
+		return status.Error(codes.ResourceExhausted, middleware.ErrRateLimited.Error())
+	}
+	return nil
+}
+
+// statusErr maps a service error to the gRPC status matching the REST response for it.
+func statusErr(err error) error {
+	var invalidParts *model.InvalidPartsError
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, service.ErrSessionArchived), errors.Is(err, service.ErrSessionFinalized),
+		errors.Is(err, service.ErrMessageImmutable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, service.ErrQuotaExceeded), errors.Is(err, service.ErrMessageTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, paging.ErrInvalidCursor), errors.Is(err, service.ErrInvalidAuthor),
+		errors.Is(err, service.ErrInvalidMIME), errors.Is(err, service.ErrMIMEMismatch),
+		errors.As(err, &invalidParts):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case db.IsUnavailable(err):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
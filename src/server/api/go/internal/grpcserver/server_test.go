@@ -0,0 +1,312 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/ratelimit"
+	"github.com/memodb-io/Acontext/internal/middleware"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	acontextv1 "github.com/memodb-io/Acontext/proto/acontext/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// MockSessionService implements the SessionService methods the RPCs call; the others panic.
+type MockSessionService struct {
+	service.SessionService
+	mock.Mock
+}
+
+func (m *MockSessionService) Create(ctx context.Context, ss *model.Session) error {
+	args := m.Called(ctx, ss)
+	return args.Error(0)
+}
+
+func (m *MockSessionService) GetByID(ctx context.Context, ss *model.Session) (*model.Session, error) {
+	args := m.Called(ctx, ss)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Session), args.Error(1)
+}
+
+func (m *MockSessionService) List(ctx context.Context, in service.ListSessionsInput) (*service.ListSessionsOutput, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.ListSessionsOutput), args.Error(1)
+}
+
+func (m *MockSessionService) StoreMessage(ctx context.Context, in service.StoreMessageInput) (*model.Message, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Message), args.Error(1)
+}
+
+func (m *MockSessionService) GetMessages(ctx context.Context, in service.GetMessagesInput) (*service.GetMessagesOutput, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.GetMessagesOutput), args.Error(1)
+}
+
+type MockMessageStreamService struct {
+	mock.Mock
+}
+
+func (m *MockMessageStreamService) Subscribe(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (<-chan model.MessageStreamEvent, func(), error) {
+	args := m.Called(ctx, projectID, sessionID)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(<-chan model.MessageStreamEvent), args.Get(1).(func()), args.Error(2)
+}
+
+const testToken = "sk-ac-test"
+
+// dialTestServer serves the gRPC surface over an in-memory listener and returns a client
+// connection to it. configure may adjust the server's deps before it starts.
+func dialTestServer(t *testing.T, project *model.Project, sessionSvc service.SessionService, streamSvc service.MessageStreamService, configure ...func(*ServerDeps)) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	deps := ServerDeps{
+		Authenticate: func(ctx context.Context, token string) (*middleware.Principal, error) {
+			if token != testToken {
+				return nil, middleware.ErrProjectUnauthorized
+			}
+			return &middleware.Principal{Project: project, Scopes: model.Scopes}, nil
+		},
+		SessionService:       sessionSvc,
+		MessageStreamService: streamSvc,
+		Log:                  zap.NewNop(),
+	}
+	for _, f := range configure {
+		f(&deps)
+	}
+	srv := NewServer(deps)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestServer_SessionAndMessages(t *testing.T) {
+	project := &model.Project{ID: uuid.New()}
+	sessionID := uuid.New()
+	messageID := uuid.New()
+	now := time.Now().UTC().Truncate(time.Second)
+	session := &model.Session{ID: sessionID, ProjectID: project.ID, Tags: model.StringArray{"demo"}, CreatedAt: now}
+	message := model.Message{
+		ID:        messageID,
+		SessionID: sessionID,
+		Role:      model.RoleUser,
+		Parts:     []model.Part{{Type: model.PartTypeText, Text: "hello"}},
+		CreatedAt: now,
+	}
+
+	sessionSvc := &MockSessionService{}
+	streamSvc := &MockMessageStreamService{}
+	conn := dialTestServer(t, project, sessionSvc, streamSvc)
+	sessions := acontextv1.NewSessionServiceClient(conn)
+	messages := acontextv1.NewMessageServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+testToken)
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		_, err := sessions.GetSession(ctx, &acontextv1.GetSessionRequest{SessionId: sessionID.String()})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		bad := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer sk-ac-wrong")
+		_, err = sessions.GetSession(bad, &acontextv1.GetSessionRequest{SessionId: sessionID.String()})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("create session", func(t *testing.T) {
+		sessionSvc.On("Create", mock.Anything, mock.MatchedBy(func(ss *model.Session) bool {
+			return ss.ProjectID == project.ID && len(ss.Tags) == 1 && ss.Tags[0] == "demo"
+		})).Run(func(args mock.Arguments) {
+			args.Get(1).(*model.Session).ID = sessionID
+		}).Return(nil).Once()
+
+		got, err := sessions.CreateSession(authed, &acontextv1.CreateSessionRequest{Tags: []string{"Demo"}})
+		require.NoError(t, err)
+		assert.Equal(t, sessionID.String(), got.GetId())
+		assert.Equal(t, project.ID.String(), got.GetProjectId())
+		assert.Equal(t, []string{"demo"}, got.GetTags())
+	})
+
+	sessionSvc.On("GetByID", mock.Anything, &model.Session{ID: sessionID}).Return(session, nil)
+
+	t.Run("store message", func(t *testing.T) {
+		sessionSvc.On("StoreMessage", mock.Anything, mock.MatchedBy(func(in service.StoreMessageInput) bool {
+			return in.ProjectID == project.ID && in.SessionID == sessionID && in.Role == model.RoleUser &&
+				len(in.Parts) == 1 && in.Parts[0].Text == "hello" && in.Format == model.FormatAcontext
+		})).Return(&message, nil).Once()
+
+		got, err := messages.StoreMessage(authed, &acontextv1.StoreMessageRequest{
+			SessionId: sessionID.String(),
+			Role:      model.RoleUser,
+			Parts:     []*acontextv1.Part{{Type: model.PartTypeText, Text: "hello"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, messageID.String(), got.GetId())
+		assert.Equal(t, "hello", got.GetParts()[0].GetText())
+	})
+
+	t.Run("store message validates parts", func(t *testing.T) {
+		_, err := messages.StoreMessage(authed, &acontextv1.StoreMessageRequest{
+			SessionId: sessionID.String(),
+			Role:      model.RoleUser,
+			Parts:     []*acontextv1.Part{{Type: model.PartTypeText}},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("list messages", func(t *testing.T) {
+		// The service redacts by the scopes of the token, which the call context must carry.
+		scoped := mock.MatchedBy(func(ctx context.Context) bool {
+			return assert.ObjectsAreEqual(model.Scopes, model.ScopesFromContext(ctx))
+		})
+		sessionSvc.On("GetMessages", scoped, mock.MatchedBy(func(in service.GetMessagesInput) bool {
+			return in.ProjectID == project.ID && in.SessionID == sessionID && in.Limit == 10
+		})).Return(&service.GetMessagesOutput{Items: []model.Message{message}, NextCursor: "next", HasMore: true}, nil).Once()
+
+		got, err := messages.ListMessages(authed, &acontextv1.ListMessagesRequest{SessionId: sessionID.String(), Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, got.GetItems(), 1)
+		assert.Equal(t, messageID.String(), got.GetItems()[0].GetId())
+		assert.Equal(t, "next", got.GetNextCursor())
+		assert.True(t, got.GetHasMore())
+	})
+
+	t.Run("subscribe messages", func(t *testing.T) {
+		events := make(chan model.MessageStreamEvent, 1)
+		unsubscribed := make(chan struct{})
+		streamSvc.On("Subscribe", mock.Anything, project.ID, sessionID).
+			Return((<-chan model.MessageStreamEvent)(events), func() { close(unsubscribed) }, nil).Once()
+		events <- model.MessageStreamEvent{Type: model.StreamEventMessageCreated, SessionID: sessionID, MessageID: messageID, Role: model.RoleUser, CreatedAt: &now}
+
+		streamCtx, stop := context.WithCancel(authed)
+		stream, err := messages.SubscribeMessages(streamCtx, &acontextv1.SubscribeMessagesRequest{SessionId: sessionID.String()})
+		require.NoError(t, err)
+		ev, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, model.StreamEventMessageCreated, ev.GetType())
+		assert.Equal(t, messageID.String(), ev.GetMessageId())
+		assert.True(t, ev.GetCreatedAt().AsTime().Equal(now))
+
+		stop()
+		select {
+		case <-unsubscribed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("subscription was not released after the call was cancelled")
+		}
+	})
+
+	t.Run("subscribe unknown session", func(t *testing.T) {
+		other := uuid.New()
+		streamSvc.On("Subscribe", mock.Anything, project.ID, other).Return(nil, nil, service.ErrSessionNotFound).Once()
+
+		stream, err := messages.SubscribeMessages(authed, &acontextv1.SubscribeMessagesRequest{SessionId: other.String()})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	sessionSvc.AssertExpectations(t)
+	streamSvc.AssertExpectations(t)
+}
+
+func TestServer_StoreMessageRateLimit(t *testing.T) {
+	project := &model.Project{ID: uuid.New()}
+	sessionID := uuid.New()
+	sessionSvc := &MockSessionService{}
+	sessionSvc.On("GetByID", mock.Anything, &model.Session{ID: sessionID}).Return(&model.Session{ID: sessionID, ProjectID: project.ID}, nil)
+	sessionSvc.On("StoreMessage", mock.Anything, mock.Anything).Return(&model.Message{ID: uuid.New(), SessionID: sessionID, Role: model.RoleUser}, nil)
+	conn := dialTestServer(t, project, sessionSvc, &MockMessageStreamService{}, func(d *ServerDeps) {
+		d.Config = &config.Config{RateLimit: config.RateLimitCfg{MessageBurst: 1, MessageRatePerSec: 0.01}}
+		d.MessageLimiter = ratelimit.NewMemoryLimiter()
+	})
+	messages := acontextv1.NewMessageServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+testToken)
+	req := &acontextv1.StoreMessageRequest{
+		SessionId: sessionID.String(),
+		Role:      model.RoleUser,
+		Parts:     []*acontextv1.Part{{Type: model.PartTypeText, Text: "hello"}},
+	}
+
+	var header metadata.MD
+	_, err := messages.StoreMessage(authed, req, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0"}, header.Get(middleware.RateLimitRemainingHeader))
+
+	_, err = messages.StoreMessage(authed, req, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"100"}, header.Get("retry-after"))
+	sessionSvc.AssertNumberOfCalls(t, "StoreMessage", 1)
+}
+
+func TestServer_StoreMessageRateLimitPerKey(t *testing.T) {
+	project := &model.Project{ID: uuid.New(), SecretKeyHMAC: "hmac"}
+	keyIDs := map[string]uuid.UUID{"sk-ac-key-a": uuid.New(), "sk-ac-key-b": uuid.New()}
+	sessionSvc := &MockSessionService{}
+	sessionSvc.On("GetByID", mock.Anything, mock.Anything).Return(&model.Session{ID: uuid.New(), ProjectID: project.ID}, nil)
+	sessionSvc.On("StoreMessage", mock.Anything, mock.Anything).Return(&model.Message{ID: uuid.New(), Role: model.RoleUser}, nil)
+	conn := dialTestServer(t, project, sessionSvc, &MockMessageStreamService{}, func(d *ServerDeps) {
+		d.Authenticate = func(ctx context.Context, token string) (*middleware.Principal, error) {
+			keyID, ok := keyIDs[token]
+			if !ok {
+				return nil, middleware.ErrProjectUnauthorized
+			}
+			return &middleware.Principal{Project: project, Scopes: model.Scopes, KeyID: keyID}, nil
+		}
+		d.Config = &config.Config{RateLimit: config.RateLimitCfg{MessageBurst: 1, MessageRatePerSec: 0.01, PerAPIKey: true}}
+		d.MessageLimiter = ratelimit.NewMemoryLimiter()
+	})
+	messages := acontextv1.NewMessageServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := func(token string) error {
+		// A new session each time, so only the key's bucket can run out.
+		_, err := messages.StoreMessage(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), &acontextv1.StoreMessageRequest{
+			SessionId: uuid.New().String(),
+			Role:      model.RoleUser,
+			Parts:     []*acontextv1.Part{{Type: model.PartTypeText, Text: "hello"}},
+		})
+		return err
+	}
+
+	require.NoError(t, store("sk-ac-key-a"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(store("sk-ac-key-a")))
+	assert.NoError(t, store("sk-ac-key-b"), "another key of the project has its own bucket")
+}
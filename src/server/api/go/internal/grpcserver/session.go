@@ -0,0 +1,164 @@
+package grpcserver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	acontextv1 "github.com/memodb-io/Acontext/proto/acontext/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/datatypes"
+)
+
+const (
+	defaultSessionListLimit = 20
+	maxListLimit            = 200
+)
+
+func (s *sessionServer) CreateSession(ctx context.Context, req *acontextv1.CreateSessionRequest) (*acontextv1.Session, error) {
+	ctx, project, _, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := service.NormalizeTags(req.GetTags())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tags: %v", err)
+	}
+	allowedPartTypes, err := service.NormalizeAllowedPartTypes(req.GetAllowedPartTypes())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid allowed_part_types: %v", err)
+	}
+
+	session := model.Session{
+		ProjectID:           project.ID,
+		DisableTaskTracking: req.GetDisableTaskTracking(),
+		Configs:             datatypes.JSONMap(mapOf(req.GetConfigs())),
+		Metadata:            datatypes.JSONMap(mapOf(req.GetMetadata())),
+		Tags:                model.StringArray(tags),
+		IsTemplate:          req.GetIsTemplate(),
+		AllowedPartTypes:    datatypes.JSONSlice[string](allowedPartTypes),
+	}
+
+	useCustomID := req.GetUseUuid() != ""
+	if useCustomID {
+		id, err := uuid.Parse(req.GetUseUuid())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid UUID format for use_uuid: %v", err)
+		}
+		session.ID = id
+	}
+
+	if req.GetUser() != "" {
+		user, err := s.userSvc.GetOrCreate(ctx, project.ID, req.GetUser())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get or create user: %v", err)
+		}
+		session.UserID = &user.ID
+	}
+
+	// Like REST, creating a custom UUID the project already has returns the existing session.
+	if useCustomID {
+		existing, err := s.sessionSvc.GetByID(ctx, &model.Session{ID: session.ID})
+		if err == nil && existing.ProjectID == project.ID {
+			return sessionToPB(existing), nil
+		}
+	}
+
+	if err := s.sessionSvc.Create(ctx, &session); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "23505") {
+			return nil, status.Error(codes.AlreadyExists, "session with this UUID already exists")
+		}
+		return nil, statusErr(err)
+	}
+	return sessionToPB(&session), nil
+}
+
+func (s *sessionServer) GetSession(ctx context.Context, req *acontextv1.GetSessionRequest) (*acontextv1.Session, error) {
+	ctx, project, _, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.projectSession(ctx, project, req.GetSessionId())
+	if err != nil {
+		return nil, err
+	}
+	return sessionToPB(session), nil
+}
+
+func (s *sessionServer) ListSessions(ctx context.Context, req *acontextv1.ListSessionsRequest) (*acontextv1.ListSessionsResponse, error) {
+	ctx, project, _, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultSessionListLimit
+	}
+	if limit < 0 || limit > maxListLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxListLimit)
+	}
+	tags, err := service.NormalizeTags(req.GetTags())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tag: %v", err)
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+
+	out, err := s.sessionSvc.List(ctx, service.ListSessionsInput{
+		ProjectID:        project.ID,
+		User:             req.GetUser(),
+		Tags:             tags,
+		IncludeTemplates: req.GetIncludeTemplates(),
+		IncludeArchived:  req.GetIncludeArchived(),
+		Limit:            limit,
+		Cursor:           req.GetCursor(),
+		TimeDesc:         req.GetTimeDesc(),
+	})
+	if err != nil {
+		return nil, statusErr(err)
+	}
+
+	items := make([]*acontextv1.Session, len(out.Items))
+	for i := range out.Items {
+		items[i] = sessionToPB(&out.Items[i])
+	}
+	return &acontextv1.ListSessionsResponse{Items: items, NextCursor: out.NextCursor, HasMore: out.HasMore}, nil
+}
+
+func (s *sessionServer) DeleteSession(ctx context.Context, req *acontextv1.DeleteSessionRequest) (*acontextv1.DeleteSessionResponse, error) {
+	ctx, project, userKEK, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.projectSession(ctx, project, req.GetSessionId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sessionSvc.Delete(ctx, project.ID, session.ID, userKEK, nil); err != nil {
+		return nil, statusErr(err)
+	}
+	return &acontextv1.DeleteSessionResponse{}, nil
+}
+
+// projectSession loads the session named by rawID and checks it belongs to the project, failing
+// with the statuses REST answers 404 and 403 with.
+func (s *server) projectSession(ctx context.Context, project *model.Project, rawID string) (*model.Session, error) {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid session_id: %v", err)
+	}
+	session, err := s.sessionSvc.GetByID(ctx, &model.Session{ID: id})
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "session not found")
+	}
+	if session.ProjectID != project.ID {
+		return nil, status.Error(codes.PermissionDenied, "access denied: session does not belong to this project")
+	}
+	return session, nil
+}
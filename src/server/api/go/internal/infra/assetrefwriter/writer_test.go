@@ -62,6 +62,30 @@ func (m *mockAssetReferenceRepo) ListS3KeysByProject(_ context.Context, _ uuid.U
 	return nil, nil
 }
 
+func (m *mockAssetReferenceRepo) FindAssetByHash(_ context.Context, _ uuid.UUID, _ string) (*model.Asset, error) {
+	return nil, nil
+}
+
+func (m *mockAssetReferenceRepo) SetAssetMeta(_ context.Context, _ uuid.UUID, _ string, _ map[string]any) error {
+	return nil
+}
+
+func (m *mockAssetReferenceRepo) GetByIDs(_ context.Context, _ uuid.UUID, _ []uuid.UUID) ([]model.AssetReference, error) {
+	return nil, nil
+}
+
+func (m *mockAssetReferenceRepo) RegisterAsset(_ context.Context, _ uuid.UUID, _ model.Asset) (*model.AssetReference, bool, error) {
+	return nil, false, nil
+}
+
+func (m *mockAssetReferenceRepo) CollectOrphanedAssets(_ context.Context, _ time.Time, _ int, _ bool) ([]model.AssetReference, error) {
+	return nil, nil
+}
+
+func (m *mockAssetReferenceRepo) DeleteUnreferencedAssets(_ context.Context, _ uuid.UUID, _ []uuid.UUID, _ time.Time) ([]model.AssetReference, error) {
+	return nil, nil
+}
+
 func newTestRedis(t *testing.T) *redis.Client {
 	t.Helper()
 	rdb := redis.NewClient(&redis.Options{
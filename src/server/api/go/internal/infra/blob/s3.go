@@ -29,6 +29,9 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
+// ErrObjectNotFound is returned by HashObject when the key does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
 type S3Deps struct {
 	Client    *s3.Client
 	Uploader  *manager.Uploader
@@ -146,7 +149,201 @@ func (s *S3Deps) PresignPut(ctx context.Context, key, contentType string, expire
 	return ps.URL, nil
 }
 
-// Generate a pre-signed GET URL
+// PresignPutSized generates a pre-signed PUT URL that only accepts a body of exactly size bytes.
+// The client must send the same Content-Type and Content-Length headers.
+func (s *S3Deps) PresignPutSized(ctx context.Context, key, contentType string, size int64, expire time.Duration) (string, error) {
+	params := &s3.PutObjectInput{
+		Bucket:        &s.Bucket,
+		Key:           &key,
+		ContentType:   &contentType,
+		ContentLength: aws.Int64(size),
+	}
+	if s.SSE != nil {
+		params.ServerSideEncryption = *s.SSE
+	}
+	ps, err := s.Presigner.PresignPutObject(ctx, params, func(po *s3.PresignOptions) {
+		po.Expires = expire
+	})
+	if err != nil {
+		return "", err
+	}
+	return ps.URL, nil
+}
+
+// HashObject streams an object to compute its SHA256 and returns its stored metadata. MIME is
+// sniffed from the leading bytes, falling back to the stored content type when detection finds
+// nothing specific. The content is never buffered whole, so it is safe for large media.
+// It returns ErrObjectNotFound when no object exists at key.
+func (s *S3Deps) HashObject(ctx context.Context, key string) (*model.Asset, error) {
+	if key == "" {
+		return nil, errors.New("key is empty")
+	}
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		var notFound *s3types.NotFound
+		if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("get object from S3: %w", err)
+	}
+	defer out.Body.Close()
+
+	h := sha256.New()
+	head := &prefixWriter{limit: sniffLen}
+	size, err := io.Copy(io.MultiWriter(h, head), out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read object body: %w", err)
+	}
+	contentType := mime.DetectMimeType(head.buf, key)
+	if mime.IsGeneric(contentType) && aws.ToString(out.ContentType) != "" {
+		contentType = aws.ToString(out.ContentType)
+	}
+	return &model.Asset{
+		Bucket: s.Bucket,
+		S3Key:  key,
+		ETag:   cleanETag(aws.ToString(out.ETag)),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		MIME:   contentType,
+		SizeB:  size,
+	}, nil
+}
+
+// sniffLen is how many leading bytes of an object are kept for MIME detection; it matches the
+// read limit of the detection library.
+const sniffLen = 3072
+
+// prefixWriter keeps the first limit bytes written to it and discards the rest.
+type prefixWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if n := w.limit - len(w.buf); n > 0 {
+		w.buf = append(w.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// StatObject returns the stored metadata of the object at key without downloading it. SHA256 is
+// read from the sha256 metadata the upload helpers write, so it is empty for objects stored
+// without it. It returns ErrObjectNotFound when no object exists at key.
+func (s *S3Deps) StatObject(ctx context.Context, key string) (*model.Asset, error) {
+	if key == "" {
+		return nil, errors.New("key is empty")
+	}
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		var notFound *s3types.NotFound
+		if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("head object from S3: %w", err)
+	}
+	return &model.Asset{
+		Bucket: s.Bucket,
+		S3Key:  key,
+		ETag:   cleanETag(aws.ToString(out.ETag)),
+		SHA256: out.Metadata["sha256"],
+		MIME:   aws.ToString(out.ContentType),
+		SizeB:  aws.ToInt64(out.ContentLength),
+	}, nil
+}
+
+// ObjectInfo describes a stored object without its content.
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+	ETag        string
+	// Encrypted objects carry an envelope-encrypted DEK in their metadata; their stored bytes
+	// are ciphertext, so Size and byte ranges do not address the plaintext.
+	Encrypted bool
+}
+
+// Ping checks that the bucket is reachable with the configured credentials, giving up when ctx
+// is done.
+func (s *S3Deps) Ping(ctx context.Context) error {
+	_, err := s.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.Bucket)})
+	return err
+}
+
+// HeadObject returns the size, content type and encryption state of the object at key.
+// It returns ErrObjectNotFound when no object exists at key.
+func (s *S3Deps) HeadObject(ctx context.Context, key string) (*ObjectInfo, error) {
+	if key == "" {
+		return nil, errors.New("key is empty")
+	}
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		var notFound *s3types.NotFound
+		if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("head object from S3: %w", err)
+	}
+	return &ObjectInfo{
+		Size:        aws.ToInt64(out.ContentLength),
+		ContentType: aws.ToString(out.ContentType),
+		ETag:        cleanETag(aws.ToString(out.ETag)),
+		Encrypted:   encryptionpkg.MetadataFromMap(out.Metadata) != nil,
+	}, nil
+}
+
+// ByteRange is an inclusive range of byte offsets within an object.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Len returns the number of bytes in the range.
+func (r ByteRange) Len() int64 { return r.End - r.Start + 1 }
+
+// OpenObject opens the stored bytes of the object at key, or only those in rng when it is not
+// nil, for streaming; the caller must close the returned body. The bytes are not decrypted.
+// It returns ErrObjectNotFound when no object exists at key.
+func (s *S3Deps) OpenObject(ctx context.Context, key string, rng *ByteRange) (io.ReadCloser, error) {
+	if key == "" {
+		return nil, errors.New("key is empty")
+	}
+	in := &s3.GetObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	}
+	if rng != nil {
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-%d", rng.Start, rng.End))
+	}
+	out, err := s.Client.GetObject(ctx, in)
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		var notFound *s3types.NotFound
+		if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("get object from S3: %w", err)
+	}
+	return out.Body, nil
+}
+
+// DerivedKeyPrefix returns the prefix under which assets derived from the object at key, such
+// as thumbnails, are stored. It lies outside every upload prefix, so content-addressed dedup
+// never mistakes a derivative for an upload, and an object's derivatives can be deleted with it.
+func DerivedKeyPrefix(key string) string {
+	return "derived/" + key + "/"
+}
+
+// Generate a pre-signed GET URL// Generate a pre-signed GET URL
 func (s *S3Deps) PresignGet(ctx context.Context, key string, expire time.Duration) (string, error) {
 	if key == "" {
 		return "", errors.New("key is empty")
@@ -438,14 +635,19 @@ func (u *S3Deps) PrepareFormFileAsset(keyPrefix string, fh *multipart.FileHeader
 	if _, err := io.Copy(&buf, file); err != nil {
 		return nil, err
 	}
-	fileContent := buf.Bytes()
+	return u.PrepareBytesAsset(keyPrefix, fh.Filename, buf.Bytes()), nil
+}
 
+// PrepareBytesAsset pre-computes asset metadata for raw bytes without making any S3 calls.
+// filename supplies the key extension and refines MIME detection.
+// Returns a PreparedUpload that can later be uploaded via UploadPrepared.
+func (u *S3Deps) PrepareBytesAsset(keyPrefix string, filename string, content []byte) *PreparedUpload {
 	h := sha256.New()
-	h.Write(fileContent)
+	h.Write(content)
 	sumHex := hex.EncodeToString(h.Sum(nil))
 
-	ext := strings.ToLower(filepath.Ext(fh.Filename))
-	contentType := mime.DetectMimeType(fileContent, fh.Filename)
+	ext := strings.ToLower(filepath.Ext(filename))
+	contentType := mime.DetectMimeType(content, filename)
 
 	datePrefix := time.Now().UTC().Format("2006/01/02")
 	key := fmt.Sprintf("%s/%s/%s%s", keyPrefix, datePrefix, sumHex, ext)
@@ -456,11 +658,11 @@ func (u *S3Deps) PrepareFormFileAsset(keyPrefix string, fh *multipart.FileHeader
 			S3Key:  key,
 			SHA256: sumHex,
 			MIME:   contentType,
-			SizeB:  int64(len(fileContent)),
+			SizeB:  int64(len(content)),
 		},
-		Content:  fileContent,
-		Metadata: map[string]string{"sha256": sumHex, "name": fh.Filename},
-	}, nil
+		Content:  content,
+		Metadata: map[string]string{"sha256": sumHex, "name": filename},
+	}
 }
 
 // UploadPrepared executes a deferred S3 upload for a previously prepared asset.
@@ -498,6 +700,12 @@ func (u *S3Deps) UploadPrepared(ctx context.Context, p *PreparedUpload, userKEK
 // UploadFileDirect uploads a file directly to S3 at the specified key (no deduplication).
 // userKEK is optional; when non-nil, the data is encrypted before upload.
 func (u *S3Deps) UploadFileDirect(ctx context.Context, key string, content []byte, contentType string, userKEK []byte) (*model.Asset, error) {
+	return u.UploadFileWithStorageClass(ctx, key, content, contentType, "", userKEK)
+}
+
+// UploadFileWithStorageClass is UploadFileDirect with the object stored in storageClass, such as
+// STANDARD_IA for rarely read data. An empty storageClass uses the bucket default.
+func (u *S3Deps) UploadFileWithStorageClass(ctx context.Context, key string, content []byte, contentType string, storageClass string, userKEK []byte) (*model.Asset, error) {
 	if key == "" {
 		return nil, errors.New("key is empty")
 	}
@@ -528,6 +736,9 @@ func (u *S3Deps) UploadFileDirect(ctx context.Context, key string, content []byt
 	if u.SSE != nil {
 		input.ServerSideEncryption = *u.SSE
 	}
+	if storageClass != "" {
+		input.StorageClass = s3types.StorageClass(storageClass)
+	}
 
 	out, err := u.Uploader.Upload(ctx, input)
 	if err != nil {
@@ -129,3 +129,22 @@ func TestPrepareFormFileAsset_Deterministic(t *testing.T) {
 	assert.Equal(t, p1.Asset.SHA256, p2.Asset.SHA256)
 	assert.Equal(t, p1.Asset.S3Key, p2.Asset.S3Key)
 }
+
+func TestPrepareBytesAsset(t *testing.T) {
+	s3 := &S3Deps{Bucket: "test-bucket"}
+	content := []byte("plain bytes")
+
+	prepared := s3.PrepareBytesAsset("assets/project-1", "note.TXT", content)
+
+	h := sha256.Sum256(content)
+	expectedSHA := hex.EncodeToString(h[:])
+	assert.Equal(t, expectedSHA, prepared.Asset.SHA256)
+	assert.Equal(t, int64(len(content)), prepared.Asset.SizeB)
+	assert.True(t, strings.HasPrefix(prepared.Asset.S3Key, "assets/project-1/"))
+	assert.True(t, strings.HasSuffix(prepared.Asset.S3Key, expectedSHA+".txt"))
+	assert.Equal(t, "note.TXT", prepared.Metadata["name"])
+
+	fromForm, err := s3.PrepareFormFileAsset("assets/project-1", newTestFileHeader("note.TXT", content))
+	require.NoError(t, err)
+	assert.Equal(t, fromForm.Asset, prepared.Asset)
+}
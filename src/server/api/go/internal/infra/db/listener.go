@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// subscriptionBuffer is how many payloads a subscriber may fall behind before it is closed.
+	subscriptionBuffer = 256
+	// listenerRetryDelay is the pause before reconnecting after the listen connection fails.
+	listenerRetryDelay = time.Second
+)
+
+// Listener multiplexes Postgres LISTEN channels over one dedicated pool connection.
+// Channels are listened to while they have at least one local subscriber, so
+// NOTIFY from any API instance reaches subscribers on every instance.
+type Listener struct {
+	db  *gorm.DB
+	log *zap.Logger
+
+	mu    sync.Mutex
+	subs  map[string]map[*subscription]struct{}
+	dirty bool               // the wanted channel set changed since the last sync
+	wake  context.CancelFunc // interrupts the current wait so the loop can sync
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type subscription struct {
+	c       chan string
+	channel string
+	l       *Listener
+	once    sync.Once
+}
+
+func NewListener(db *gorm.DB, log *zap.Logger) *Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Listener{
+		db:     db,
+		log:    log.Named("pg-listener"),
+		subs:   map[string]map[*subscription]struct{}{},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start launches the background listen loop.
+func (l *Listener) Start() {
+	l.wg.Add(1)
+	go l.loop()
+}
+
+// Stop ends the listen loop and closes all subscriptions.
+func (l *Listener) Stop() {
+	l.cancel()
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, set := range l.subs {
+		for s := range set {
+			close(s.c)
+		}
+	}
+	l.subs = map[string]map[*subscription]struct{}{}
+}
+
+// Subscribe starts delivering payloads NOTIFYed on channel. A subscriber that falls
+// too far behind is closed rather than silently skipping payloads, so it can resync.
+// The returned channel is also closed by unsubscribe, which is safe to call more than
+// once, or by Stop.
+func (l *Listener) Subscribe(channel string) (payloads <-chan string, unsubscribe func()) {
+	s := &subscription{c: make(chan string, subscriptionBuffer), channel: channel, l: l}
+
+	l.mu.Lock()
+	set, ok := l.subs[channel]
+	if !ok {
+		set = map[*subscription]struct{}{}
+		l.subs[channel] = set
+		l.markDirtyLocked()
+	}
+	set[s] = struct{}{}
+	l.mu.Unlock()
+	return s.c, s.close
+}
+
+func (s *subscription) close() {
+	s.once.Do(func() {
+		l := s.l
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		set, ok := l.subs[s.channel]
+		if !ok {
+			return
+		}
+		if _, ok := set[s]; !ok {
+			return
+		}
+		delete(set, s)
+		close(s.c)
+		if len(set) == 0 {
+			delete(l.subs, s.channel)
+			l.markDirtyLocked()
+		}
+	})
+}
+
+func (l *Listener) markDirtyLocked() {
+	l.dirty = true
+	if l.wake != nil {
+		l.wake()
+	}
+}
+
+func (l *Listener) dispatch(channel, payload string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	set := l.subs[channel]
+	for s := range set {
+		select {
+		case s.c <- payload:
+		default:
+			l.log.Warn("closing subscription of slow subscriber", zap.String("channel", channel))
+			delete(set, s)
+			close(s.c)
+		}
+	}
+	if set != nil && len(set) == 0 {
+		delete(l.subs, channel)
+		l.markDirtyLocked()
+	}
+}
+
+func (l *Listener) loop() {
+	defer l.wg.Done()
+	for {
+		err := l.run()
+		if l.ctx.Err() != nil {
+			return
+		}
+		l.log.Warn("listen connection failed, retrying", zap.Error(err))
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-time.After(listenerRetryDelay):
+		}
+	}
+}
+
+// run holds one pool connection and serves notifications until it fails or the listener stops.
+func (l *Listener) run() error {
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(l.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		sc, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		pc := sc.Conn()
+		listening := map[string]bool{}
+		defer func() {
+			// The connection goes back to the pool; drop our channels from it.
+			_, _ = pc.Exec(context.Background(), "UNLISTEN *")
+		}()
+
+		// Resync on every (re)connect.
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+
+		for {
+			waitCtx, cancel := context.WithCancel(l.ctx)
+			l.mu.Lock()
+			dirty := l.dirty
+			l.dirty = false
+			var wanted map[string]bool
+			if dirty {
+				wanted = make(map[string]bool, len(l.subs))
+				for ch := range l.subs {
+					wanted[ch] = true
+				}
+			}
+			l.wake = cancel
+			l.mu.Unlock()
+
+			if dirty {
+				if err := syncListens(l.ctx, pc, listening, wanted); err != nil {
+					cancel()
+					return err
+				}
+			}
+
+			n, err := pc.WaitForNotification(waitCtx)
+			cancel()
+			l.mu.Lock()
+			l.wake = nil
+			l.mu.Unlock()
+			if err != nil {
+				if l.ctx.Err() != nil {
+					return nil
+				}
+				if pgconn.Timeout(err) || errors.Is(err, context.Canceled) {
+					continue // woken up to resync channels
+				}
+				return err
+			}
+			l.dispatch(n.Channel, n.Payload)
+		}
+	})
+}
+
+// syncListens issues LISTEN/UNLISTEN so the connection listens on exactly wanted.
+func syncListens(ctx context.Context, pc *pgx.Conn, listening, wanted map[string]bool) error {
+	for ch := range wanted {
+		if !listening[ch] {
+			if _, err := pc.Exec(ctx, "LISTEN "+pgx.Identifier{ch}.Sanitize()); err != nil {
+				return err
+			}
+			listening[ch] = true
+		}
+	}
+	for ch := range listening {
+		if !wanted[ch] {
+			if _, err := pc.Exec(ctx, "UNLISTEN "+pgx.Identifier{ch}.Sanitize()); err != nil {
+				return err
+			}
+			delete(listening, ch)
+		}
+	}
+	return nil
+}
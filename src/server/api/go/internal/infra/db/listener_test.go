@@ -0,0 +1,35 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestListener_ClosesSlowSubscribers(t *testing.T) {
+	l := NewListener(nil, zap.NewNop())
+	slow, unsubscribeSlow := l.Subscribe("ch")
+	defer unsubscribeSlow()
+	fast, unsubscribeFast := l.Subscribe("ch")
+	defer unsubscribeFast()
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		l.dispatch("ch", fmt.Sprint(i))
+		if i < subscriptionBuffer {
+			<-fast
+		}
+	}
+	<-fast
+
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, subscriptionBuffer, received, "the buffered payloads are delivered before the close")
+	assert.Len(t, l.subs["ch"], 1)
+
+	unsubscribeFast()
+	assert.NotContains(t, l.subs, "ch")
+}
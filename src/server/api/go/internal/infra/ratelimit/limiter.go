@@ -0,0 +1,230 @@
+// Package ratelimit implements token-bucket rate limiters. A bucket holds up to Burst tokens and
+// refills at RatePerSec; each allowed request takes one token from every bucket it is checked
+// against.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const keyPrefix = "ratelimit:"
+
+// Limit configures a bucket. A Burst <= 0 or RatePerSec <= 0 disables limiting.
+type Limit struct {
+	Burst      int
+	RatePerSec float64
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.RatePerSec > 0
+}
+
+// Result is the outcome of taking a token. Across several buckets it describes the tightest one.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available again; zero when Allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from buckets identified by key.
+type Limiter interface {
+	// Allow takes a token from every bucket in keys, or from none of them when any is empty, so
+	// a rejected request does not drain the buckets that had room.
+	Allow(ctx context.Context, keys []string, limit Limit) (Result, error)
+}
+
+// MemoryLimiter keeps buckets in process memory, for single-node deployments.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	calls   int
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// sweepEvery is how many calls MemoryLimiter takes between sweeps of full buckets.
+const sweepEvery = 1024
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a token from every bucket in keys, or from none.
+func (l *MemoryLimiter) Allow(_ context.Context, keys []string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now, limit)
+	}
+
+	tokens := make([]*float64, 0, len(keys))
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(limit.Burst), at: now}
+			l.buckets[key] = b
+		}
+		b.tokens = refill(b.tokens, now.Sub(b.at), limit)
+		b.at = now
+		tokens = append(tokens, &b.tokens)
+	}
+	return take(tokens, limit), nil
+}
+
+// sweep drops buckets that have refilled completely, which behave like new ones.
+func (l *MemoryLimiter) sweep(now time.Time, limit Limit) {
+	for key, b := range l.buckets {
+		if refill(b.tokens, now.Sub(b.at), limit) >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed > 0 {
+		tokens += elapsed.Seconds() * limit.RatePerSec
+	}
+	return math.Min(tokens, float64(limit.Burst))
+}
+
+// take takes a token from each bucket when all of them hold one. The result reports the fewest
+// tokens left and, when rejected, the wait until every bucket has a token again.
+func take(tokens []*float64, limit Limit) Result {
+	res := Result{Allowed: true, Limit: limit.Burst}
+	least := float64(limit.Burst)
+	for _, t := range tokens {
+		least = math.Min(least, *t)
+		if *t < 1 {
+			res.Allowed = false
+			res.RetryAfter = max(res.RetryAfter, time.Duration((1-*t)/limit.RatePerSec*float64(time.Second)))
+		}
+	}
+	if res.Allowed {
+		for _, t := range tokens {
+			*t--
+		}
+		least--
+	}
+	res.Remaining = int(least)
+	return res
+}
+
+// tokenBucketScript refills the buckets at KEYS and takes a token from each of them atomically
+// when all of them hold one. ARGV holds the burst and the rate per second. Time comes from the
+// Redis server so that API instances with skewed clocks share one view of each bucket. It
+// returns whether the tokens were taken, the fewest tokens left and the milliseconds until
+// every bucket has a token again.
+var tokenBucketScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local tokens = {}
+local allowed = 1
+local retry = 0
+local least = burst
+for i, key in ipairs(KEYS) do
+	local state = redis.call('HMGET', key, 'tokens', 'at')
+	local n = tonumber(state[1])
+	local at = tonumber(state[2])
+	if n == nil or at == nil then
+		n = burst
+		at = now
+	end
+	if now > at then
+		n = math.min(burst, n + (now - at) * rate / 1000)
+	end
+	if n < 1 then
+		allowed = 0
+		retry = math.max(retry, math.ceil((1 - n) * 1000 / rate))
+	end
+	least = math.min(least, n)
+	tokens[i] = n
+end
+if allowed == 1 then
+	least = least - 1
+end
+for i, key in ipairs(KEYS) do
+	local n = tokens[i]
+	if allowed == 1 then
+		n = n - 1
+	end
+	redis.call('HSET', key, 'tokens', tostring(n), 'at', tostring(now))
+	redis.call('PEXPIRE', key, math.ceil(burst * 1000 / rate) + 1000)
+end
+return {allowed, tostring(least), retry}
+`)
+
+// RedisLimiter keeps buckets in Redis so that every API instance shares them. When Redis fails
+// it falls back to a per-instance MemoryLimiter rather than rejecting or admitting everything.
+type RedisLimiter struct {
+	redis    *redis.Client
+	fallback *MemoryLimiter
+	log      *zap.Logger
+}
+
+func NewRedisLimiter(rdb *redis.Client, log *zap.Logger) *RedisLimiter {
+	return &RedisLimiter{redis: rdb, fallback: NewMemoryLimiter(), log: log.Named("ratelimit")}
+}
+
+// Allow takes a token from every bucket in keys, or from none, in one script call.
+func (l *RedisLimiter) Allow(ctx context.Context, keys []string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = keyPrefix + key
+	}
+	vals, err := tokenBucketScript.Run(ctx, l.redis, redisKeys,
+		limit.Burst, strconv.FormatFloat(limit.RatePerSec, 'f', -1, 64)).Slice()
+	if err == nil && len(vals) == 3 {
+		allowed, _ := vals[0].(int64)
+		tokensStr, _ := vals[1].(string)
+		retryMs, _ := vals[2].(int64)
+		tokens, perr := strconv.ParseFloat(tokensStr, 64)
+		if perr == nil {
+			return Result{
+				Allowed:    allowed == 1,
+				Limit:      limit.Burst,
+				Remaining:  int(tokens),
+				RetryAfter: time.Duration(retryMs) * time.Millisecond,
+			}, nil
+		}
+		err = perr
+	} else if err == nil {
+		err = fmt.Errorf("unexpected rate limit reply %v", vals)
+	}
+	l.log.Warn("redis rate limiter unavailable, using in-memory buckets", zap.Strings("keys", keys), zap.Error(err))
+	return l.fallback.Allow(ctx, keys, limit)
+}
+
+// New returns the limiter for backend: "memory" keeps buckets per instance, anything else shares
+// them through Redis. Without a Redis client the memory limiter is used.
+func New(backend string, rdb *redis.Client, log *zap.Logger) Limiter {
+	if backend == "memory" || rdb == nil {
+		return NewMemoryLimiter()
+	}
+	return NewRedisLimiter(rdb, log)
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+	limit := Limit{Burst: 2, RatePerSec: 0.5}
+
+	res, err := l.Allow(ctx, []string{"s"}, limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Limit)
+	assert.Equal(t, 1, res.Remaining)
+
+	res, _ = l.Allow(ctx, []string{"s"}, limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, _ = l.Allow(ctx, []string{"s"}, limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 2*time.Second, res.RetryAfter)
+
+	res, _ = l.Allow(ctx, []string{"other"}, limit)
+	assert.True(t, res.Allowed, "buckets are per key")
+
+	now = now.Add(2 * time.Second)
+	res, _ = l.Allow(ctx, []string{"s"}, limit)
+	assert.True(t, res.Allowed, "a token refills after 1/rate seconds")
+
+	res, _ = l.Allow(ctx, []string{"s"}, Limit{})
+	assert.True(t, res.Allowed, "a disabled limit allows everything")
+}
+
+func TestMemoryLimiter_AllBuckets(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLimiter()
+	limit := Limit{Burst: 2, RatePerSec: 0.001}
+
+	res, err := l.Allow(ctx, []string{"session-a", "key"}, limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, _ = l.Allow(ctx, []string{"session-b", "key"}, limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	// The key bucket is empty: session-a keeps its token.
+	res, _ = l.Allow(ctx, []string{"session-a", "key"}, limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res, _ = l.Allow(ctx, []string{"session-a"}, limit)
+	assert.True(t, res.Allowed, "a rejected request takes no tokens")
+}
+
+func TestMemoryLimiter_Sweep(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+	limit := Limit{Burst: 1, RatePerSec: 1}
+	_, _ = l.Allow(context.Background(), []string{"a"}, limit)
+
+	now = now.Add(time.Minute)
+	l.sweep(now, limit)
+	assert.Empty(t, l.buckets)
+}
+
+func TestRedisLimiter(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	limit := Limit{Burst: 2, RatePerSec: 0.001}
+
+	// Two instances share buckets through Redis.
+	a := NewRedisLimiter(rdb, zap.NewNop())
+	b := NewRedisLimiter(rdb, zap.NewNop())
+
+	res, err := a.Allow(ctx, []string{"s"}, limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	res, err = b.Allow(ctx, []string{"s"}, limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, err = a.Allow(ctx, []string{"s"}, limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Greater(t, res.RetryAfter, time.Duration(0))
+	assert.True(t, mr.Exists(keyPrefix+"s"))
+
+	t.Run("takes from every bucket or none", func(t *testing.T) {
+		res, err := a.Allow(ctx, []string{"session-a", "s"}, limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		res, err = a.Allow(ctx, []string{"session-a"}, limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 1, res.Remaining, "the rejected request took no token")
+	})
+
+	t.Run("falls back to memory when redis is down", func(t *testing.T) {
+		mr.Close()
+		res, err := a.Allow(ctx, []string{"s"}, limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	})
+}
+
+func TestNew(t *testing.T) {
+	assert.IsType(t, &MemoryLimiter{}, New("memory", redis.NewClient(&redis.Options{}), zap.NewNop()))
+	assert.IsType(t, &MemoryLimiter{}, New("redis", nil, zap.NewNop()))
+	assert.IsType(t, &RedisLimiter{}, New("redis", redis.NewClient(&redis.Options{}), zap.NewNop()))
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -66,11 +67,104 @@ func GetUserKEKBase64IfEncrypted(c *gin.Context) string {
 	return base64.StdEncoding.EncodeToString(kek)
 }
 
+// GetScopes returns the scopes of the requesting principal, as set by the auth middleware from
+// the credential of the request: every scope in model.Scopes for the project secret key and the
+// admin token, and those it was created with for a project key.
+func GetScopes(c *gin.Context) []string {
+	return model.ScopesFromContext(c.Request.Context())
+}
+
+// GetProjectKeyID returns the project key the request authenticated with, or uuid.Nil for the
+// project secret key.
+func GetProjectKeyID(c *gin.Context) uuid.UUID {
+	id, _ := c.Get("project_key_id")
+	keyID, _ := id.(uuid.UUID)
+	return keyID
+}
+
+// RequireSecretKey returns a middleware that rejects requests made with a project key, for the
+// endpoints that manage the project itself.
+func RequireSecretKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetProjectKeyID(c) != uuid.Nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, serializer.Err(http.StatusForbidden, "this endpoint requires the project secret key", nil))
+			return
+		}
+		c.Next()
+	}
+}
+
 const (
 	projectAuthCachePrefix = "project:auth:"
 	projectAuthCacheTTL    = 5 * time.Minute
 )
 
+var (
+	// ErrProjectUnauthorized is returned by AuthenticateProject for a token that is malformed,
+	// names no project or fails verification.
+	ErrProjectUnauthorized = errors.New("unauthorized")
+	// ErrCompactTokenInvalid is returned by AuthenticateProject for a compact token whose KEK
+	// cannot be unwrapped.
+	ErrCompactTokenInvalid = errors.New("invalid API key: failed to unwrap compact token")
+)
+
+// Principal is what a project bearer token authenticates as.
+type Principal struct {
+	Project *model.Project
+	// UserKEK is derived from a compact token; it is nil for legacy keys.
+	UserKEK []byte
+	// Scopes are every scope in model.Scopes for the project secret key, and for a project key
+	// those it was created with.
+	Scopes []string
+	// KeyID is the project key the token belongs to, or uuid.Nil for the project secret key.
+	KeyID uuid.UUID
+}
+
+// AuthenticateProject resolves a project bearer token, without its "Bearer " prefix, to its
+// principal: the project secret key or one of the project's keys. For compact tokens it also
+// derives the user KEK; legacy keys return a nil KEK.
+// It is the token check of ProjectAuth, shared with transports other than HTTP.
+func AuthenticateProject(ctx context.Context, cfg *config.Config, db *gorm.DB, rdb *redis.Client, raw string) (*Principal, error) {
+	parsed, ok := tokens.ParseProjectToken(raw, cfg.Root.ProjectBearerTokenPrefix)
+	if !ok {
+		return nil, ErrProjectUnauthorized
+	}
+
+	// HMAC lookup uses auth_secret (both formats)
+	lookup := tokens.HMAC256Hex(cfg.Root.SecretPepper, parsed.AuthSecret)
+
+	cred, err := lookupProject(ctx, db, rdb, lookup)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProjectUnauthorized
+		}
+		return nil, err
+	}
+
+	// Argon2 verification uses auth_secret (both formats)
+	if cfg.Root.EnableArgon2Verification {
+		_, verifySpan := otel.Tracer("middleware").Start(ctx, "project_auth.verify_secret")
+		pass, err := secrets.VerifySecret(parsed.AuthSecret, cfg.Root.SecretPepper, cred.hashPHC)
+		verifySpan.End()
+		if err != nil || !pass {
+			return nil, ErrProjectUnauthorized
+		}
+	}
+
+	principal := &Principal{Project: cred.project, Scopes: cred.scopes, KeyID: cred.keyID}
+	// Derive KEK from compact token if present.
+	// Legacy keys without CompactRaw have no encryption support.
+	if parsed.CompactRaw == "" {
+		return principal, nil
+	}
+	_, userKEK, err := encryptionpkg.UnpackCompactToken(parsed.CompactRaw, cfg.Root.SecretPepper)
+	if err != nil {
+		return nil, ErrCompactTokenInvalid
+	}
+	principal.UserKEK = userKEK
+	return principal, nil
+}
+
 // ProjectAuth returns a middleware that authenticates requests using project bearer tokens.
 // Token formats: compact (sk-ac-{base64url, 76 chars}) or legacy (sk-ac-{plain_secret}).
 // For compact tokens, derives a KEK and stores it in context for downstream encryption.
@@ -91,48 +185,27 @@ func ProjectAuth(cfg *config.Config, db *gorm.DB, rdb *redis.Client) gin.Handler
 			c.AbortWithStatusJSON(http.StatusUnauthorized, serializer.AuthErr("Unauthorized"))
 			return
 		}
-		raw := strings.TrimPrefix(auth, "Bearer ")
 
-		parsed, ok := tokens.ParseProjectToken(raw, cfg.Root.ProjectBearerTokenPrefix)
-		if !ok {
-			authSpan.SetAttributes(attribute.Bool("authenticated", false))
-			authSpan.End()
-			c.AbortWithStatusJSON(http.StatusUnauthorized, serializer.AuthErr("Unauthorized"))
-			return
-		}
-
-		// HMAC lookup uses auth_secret (both formats)
-		lookup := tokens.HMAC256Hex(cfg.Root.SecretPepper, parsed.AuthSecret)
-
-		project, err := lookupProject(authCtx, db, rdb, lookup)
+		principal, err := AuthenticateProject(authCtx, cfg, db, rdb, strings.TrimPrefix(auth, "Bearer "))
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
+			switch {
+			case errors.Is(err, ErrProjectUnauthorized):
 				authSpan.SetAttributes(attribute.Bool("authenticated", false))
 				authSpan.End()
 				c.AbortWithStatusJSON(http.StatusUnauthorized, serializer.AuthErr("Unauthorized"))
-				return
+			case errors.Is(err, ErrCompactTokenInvalid):
+				authSpan.SetAttributes(attribute.Bool("authenticated", false))
+				authSpan.End()
+				c.AbortWithStatusJSON(http.StatusUnauthorized, serializer.AuthErr(err.Error()))
+			default:
+				authSpan.RecordError(err)
+				authSpan.End()
+				c.AbortWithStatusJSON(http.StatusInternalServerError, serializer.DBErr("", err))
 			}
-			authSpan.RecordError(err)
-			authSpan.End()
-			c.AbortWithStatusJSON(http.StatusInternalServerError, serializer.DBErr("", err))
 			return
 		}
 
-		// Argon2 verification uses auth_secret (both formats)
-		if cfg.Root.EnableArgon2Verification {
-			_, verifySpan := otel.Tracer("middleware").Start(authCtx, "project_auth.verify_secret")
-			pass, err := secrets.VerifySecret(parsed.AuthSecret, cfg.Root.SecretPepper, project.SecretKeyHashPHC)
-			verifySpan.End()
-			if err != nil || !pass {
-				authSpan.SetAttributes(
-					attribute.String("project_id", project.ID.String()),
-					attribute.Bool("authenticated", false),
-				)
-				authSpan.End()
-				c.AbortWithStatusJSON(http.StatusUnauthorized, serializer.AuthErr("Unauthorized"))
-				return
-			}
-		}
+		project := principal.Project
 
 		// Set project_id on HTTP span for telemetry filtering
 		httpSpan := trace.SpanFromContext(c.Request.Context())
@@ -148,30 +221,44 @@ func ProjectAuth(cfg *config.Config, db *gorm.DB, rdb *redis.Client) gin.Handler
 
 		c.Set("project", project)
 		SetWideEventField(c, "project_id", project.ID.String())
-
-		// Derive KEK from compact token if present.
-		// Legacy keys without CompactRaw have no encryption support.
-		if parsed.CompactRaw != "" {
-			_, userKEK, kerr := encryptionpkg.UnpackCompactToken(parsed.CompactRaw, cfg.Root.SecretPepper)
-			if kerr != nil {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, serializer.AuthErr("invalid API key: failed to unwrap compact token"))
-				return
-			}
-			c.Set("user_kek", userKEK)
+		if principal.UserKEK != nil {
+			c.Set("user_kek", principal.UserKEK)
 		}
+		if principal.KeyID != uuid.Nil {
+			c.Set("project_key_id", principal.KeyID)
+			SetWideEventField(c, "project_key_id", principal.KeyID.String())
+		}
+		// The service layer redacts what the principal may not read by the scopes on the context.
+		c.Request = c.Request.WithContext(model.WithScopes(c.Request.Context(), principal.Scopes))
 
 		c.Next()
 	}
 }
 
-// InvalidateProjectAuthCache removes a project's auth cache entry from Redis.
+// InvalidateProjectAuthCache removes a project's auth cache entry from Redis, along with those
+// of its project keys, which carry the same project state.
 // Call this after any operation that changes project state cached here
 // (e.g., encryption_enabled flag, key rotation).
-func InvalidateProjectAuthCache(rdb *redis.Client, hmac string) {
-	if rdb == nil || hmac == "" {
+func InvalidateProjectAuthCache(rdb *redis.Client, projectID uuid.UUID, hmac string) {
+	if rdb == nil {
 		return
 	}
-	_ = rdb.Del(context.Background(), projectAuthCachePrefix+hmac).Err()
+	ctx := context.Background()
+	keys := rdb.SMembers(ctx, projectKeysAuthCacheKey(projectID)).Val()
+	for i, h := range keys {
+		keys[i] = projectAuthCachePrefix + h
+	}
+	keys = append(keys, projectKeysAuthCacheKey(projectID))
+	if hmac != "" {
+		keys = append(keys, projectAuthCachePrefix+hmac)
+	}
+	_ = rdb.Del(ctx, keys...).Err()
+}
+
+// projectKeysAuthCacheKey is the Redis set of the HMACs of a project's keys with an auth cache
+// entry, so InvalidateProjectAuthCache can find them.
+func projectKeysAuthCacheKey(projectID uuid.UUID) string {
+	return projectAuthCachePrefix + "keys:" + projectID.String()
 }
 
 // projectAuthCache is a Redis-serializable subset of model.Project.
@@ -182,10 +269,56 @@ type projectAuthCache struct {
 	SecretKeyHMAC     string `json:"secret_key_hmac"`
 	SecretKeyHashPHC  string `json:"secret_key_hash_phc"`
 	EncryptionEnabled bool   `json:"encryption_enabled"`
+	// Configs carries the project_config limits enforced per request, such as the message rate limit.
+	Configs map[string]interface{} `json:"configs,omitempty"`
+	// KeyID, KeyHashPHC and KeyScopes are set when the entry is that of a project key rather than
+	// the project secret key.
+	KeyID      string   `json:"key_id,omitempty"`
+	KeyHashPHC string   `json:"key_hash_phc,omitempty"`
+	KeyScopes  []string `json:"key_scopes,omitempty"`
+}
+
+// projectCredential is the project a token HMAC belongs to, with what the token is verified
+// against and the scopes it holds.
+type projectCredential struct {
+	project *model.Project
+	hashPHC string
+	scopes  []string
+	keyID   uuid.UUID
+}
+
+func (c *projectAuthCache) credential() *projectCredential {
+	project := &model.Project{
+		SecretKeyHMAC:     c.SecretKeyHMAC,
+		SecretKeyHashPHC:  c.SecretKeyHashPHC,
+		EncryptionEnabled: c.EncryptionEnabled,
+		Configs:           c.Configs,
+	}
+	if id, err := uuid.Parse(c.ID); err == nil {
+		project.ID = id
+	}
+	if c.KeyID == "" {
+		return &projectCredential{project: project, hashPHC: c.SecretKeyHashPHC, scopes: model.Scopes}
+	}
+	keyID, _ := uuid.Parse(c.KeyID)
+	return &projectCredential{project: project, hashPHC: c.KeyHashPHC, scopes: knownScopes(c.KeyScopes), keyID: keyID}
 }
 
-// lookupProject tries Redis cache first, falls back to DB on miss or Redis error.
-func lookupProject(ctx context.Context, db *gorm.DB, rdb *redis.Client, hmac string) (*model.Project, error) {
+// knownScopes returns the scopes of model.Scopes found in scopes, never nil so a key without
+// scopes is told apart from one that could not be read.
+func knownScopes(scopes []string) []string {
+	out := []string{}
+	for _, scope := range scopes {
+		if slices.Contains(model.Scopes, scope) {
+			out = append(out, scope)
+		}
+	}
+	return out
+}
+
+// lookupProject resolves a token HMAC to the project secret key or project key it belongs to.
+// It tries Redis cache first, falls back to DB on miss or Redis error.
+func lookupProject(ctx context.Context, db *gorm.DB, rdb *redis.Client, hmac string) (*projectCredential, error) {
 	cacheKey := projectAuthCachePrefix + hmac
 
 	// Try Redis first
@@ -194,38 +327,48 @@ func lookupProject(ctx context.Context, db *gorm.DB, rdb *redis.Client, hmac str
 		if err == nil {
 			var cached projectAuthCache
 			if json.Unmarshal(data, &cached) == nil && cached.SecretKeyHMAC != "" {
-				project := &model.Project{
-					SecretKeyHMAC:     cached.SecretKeyHMAC,
-					SecretKeyHashPHC:  cached.SecretKeyHashPHC,
-					EncryptionEnabled: cached.EncryptionEnabled,
-				}
-				if id, err := uuid.Parse(cached.ID); err == nil {
-					project.ID = id
-				}
-				return project, nil
+				return cached.credential(), nil
 			}
 		}
 		// On redis.Nil or any other error, fall through to DB
 	}
 
-	// DB lookup
+	// DB lookup: the project secret key, then the project keys
 	var project model.Project
-	if err := db.WithContext(ctx).Where(&model.Project{SecretKeyHMAC: hmac}).First(&project).Error; err != nil {
+	cached := projectAuthCache{}
+	err := db.WithContext(ctx).Where(&model.Project{SecretKeyHMAC: hmac}).First(&project).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var key model.ProjectKey
+		if err := db.WithContext(ctx).Where(&model.ProjectKey{SecretKeyHMAC: hmac}).First(&key).Error; err != nil {
+			return nil, err
+		}
+		if err := db.WithContext(ctx).Where(&model.Project{ID: key.ProjectID}).First(&project).Error; err != nil {
+			return nil, err
+		}
+		cached.KeyID, cached.KeyHashPHC, cached.KeyScopes = key.ID.String(), key.SecretKeyHashPHC, knownScopes(key.Scopes)
+	} else if err != nil {
 		return nil, err
 	}
+	cached.ID = project.ID.String()
+	cached.SecretKeyHMAC = project.SecretKeyHMAC
+	cached.SecretKeyHashPHC = project.SecretKeyHashPHC
+	cached.EncryptionEnabled = project.EncryptionEnabled
+	cached.Configs = project.Configs
 
 	// Write-back to Redis (best-effort, don't block on failure)
 	if rdb != nil {
-		cached := projectAuthCache{
-			ID:                project.ID.String(),
-			SecretKeyHMAC:     project.SecretKeyHMAC,
-			SecretKeyHashPHC:  project.SecretKeyHashPHC,
-			EncryptionEnabled: project.EncryptionEnabled,
-		}
 		if data, err := json.Marshal(&cached); err == nil {
-			_ = rdb.Set(ctx, cacheKey, data, projectAuthCacheTTL).Err()
+			pipe := rdb.TxPipeline()
+			if cached.KeyID != "" {
+				pipe.SAdd(ctx, projectKeysAuthCacheKey(project.ID), hmac)
+				pipe.Expire(ctx, projectKeysAuthCacheKey(project.ID), projectAuthCacheTTL)
+			}
+			pipe.Set(ctx, cacheKey, data, projectAuthCacheTTL)
+			_, _ = pipe.Exec(ctx)
 		}
 	}
 
-	return &project, nil
+	cred := cached.credential()
+	cred.project = &project
+	return cred, nil
 }
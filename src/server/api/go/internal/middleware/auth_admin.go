@@ -138,6 +138,9 @@ func AdminProjectAuth(cfg *config.Config, db *gorm.DB) gin.HandlerFunc {
 		authSpan.End()
 
 		c.Set("project", &project)
+		// Admin tokens may override session immutability; see handler.ImmutabilityOverrideHeader.
+		c.Set("admin", true)
+		c.Request = c.Request.WithContext(model.WithScopes(c.Request.Context(), model.Scopes))
 		SetWideEventField(c, "project_id", project.ID.String())
 		c.Next()
 	}
@@ -65,3 +65,39 @@ func TestProjectAuthCache_OldFormatFallsThrough(t *testing.T) {
 	assert.Empty(t, cached.SecretKeyHMAC, "old format should have empty SecretKeyHMAC after unmarshal")
 	assert.Empty(t, cached.SecretKeyHashPHC, "old format should have empty SecretKeyHashPHC after unmarshal")
 }
+
+func TestProjectAuthCache_Credential(t *testing.T) {
+	projectID := uuid.New()
+	keyID := uuid.New()
+
+	t.Run("secret key holds every scope", func(t *testing.T) {
+		cached := projectAuthCache{ID: projectID.String(), SecretKeyHMAC: "hmac", SecretKeyHashPHC: "project-phc"}
+		cred := cached.credential()
+		assert.Equal(t, projectID, cred.project.ID)
+		assert.Equal(t, "project-phc", cred.hashPHC)
+		assert.Equal(t, model.Scopes, cred.scopes)
+		assert.Equal(t, uuid.Nil, cred.keyID)
+	})
+
+	t.Run("project key holds its own known scopes", func(t *testing.T) {
+		cached := projectAuthCache{
+			ID:               projectID.String(),
+			SecretKeyHMAC:    "hmac",
+			SecretKeyHashPHC: "project-phc",
+			KeyID:            keyID.String(),
+			KeyHashPHC:       "key-phc",
+			KeyScopes:        []string{"unknown", model.ScopeReadSensitive},
+		}
+		cred := cached.credential()
+		assert.Equal(t, "key-phc", cred.hashPHC)
+		assert.Equal(t, []string{model.ScopeReadSensitive}, cred.scopes)
+		assert.Equal(t, keyID, cred.keyID)
+	})
+
+	t.Run("project key without scopes holds none", func(t *testing.T) {
+		cached := projectAuthCache{ID: projectID.String(), SecretKeyHMAC: "hmac", KeyID: keyID.String()}
+		cred := cached.credential()
+		assert.NotNil(t, cred.scopes)
+		assert.Empty(t, cred.scopes)
+	})
+}
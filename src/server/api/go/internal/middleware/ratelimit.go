@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/ratelimit"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+)
+
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+
+	projectConfigMessageRateBurst  = "message_rate_burst"
+	projectConfigMessageRatePerSec = "message_rate_per_sec"
+)
+
+var ErrRateLimited = errors.New("message rate limit exceeded")
+
+// MessageRateLimit limits message creation with a token bucket per session, and per API key
+// when cfg.RateLimit.PerAPIKey is set; see AllowMessage. It must run after ProjectAuth. Responses
+// carry the remaining capacity of the tightest bucket; a request over the limit is rejected with
+// 429 and a Retry-After header.
+func MessageRateLimit(cfg *config.Config, limiter ratelimit.Limiter, log *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		project, ok := c.MustGet("project").(*model.Project)
+		if !ok {
+			c.Next()
+			return
+		}
+		res := AllowMessage(c.Request.Context(), cfg, limiter, project, GetProjectKeyID(c), c.Param("session_id"), log)
+		if res == nil {
+			c.Next()
+			return
+		}
+
+		c.Header(RateLimitLimitHeader, strconv.Itoa(res.Limit))
+		c.Header(RateLimitRemainingHeader, strconv.Itoa(res.Remaining))
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(RetryAfterSeconds(*res)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, serializer.Err(http.StatusTooManyRequests, "RATE_LIMITED", ErrRateLimited))
+			return
+		}
+		c.Next()
+	}
+}
+
+// AllowMessage takes a token for a message stored in sessionID from the session's bucket, and
+// from the API key's when cfg.RateLimit.PerAPIKey is set, or from neither. The API key is the
+// credential the call authenticated with: the project key keyID, or the project secret key when
+// keyID is uuid.Nil. A project may override the deployment limit through project_config. It returns nil when the limit is disabled or the
+// limiter is unavailable: limiting is best effort and must not block writes. Every transport that
+// stores messages calls it so they share one set of buckets.
+func AllowMessage(ctx context.Context, cfg *config.Config, limiter ratelimit.Limiter, project *model.Project, keyID uuid.UUID, sessionID string, log *zap.Logger) *ratelimit.Result {
+	limit := messageRateLimit(project, cfg.RateLimit)
+	if !limit.Enabled() {
+		return nil
+	}
+
+	keys := []string{fmt.Sprintf("message:session:%s:%s", project.ID, sessionID)}
+	if cfg.RateLimit.PerAPIKey {
+		switch {
+		case keyID != uuid.Nil:
+			keys = append(keys, "message:project_key:"+keyID.String())
+		case project.SecretKeyHMAC != "":
+			keys = append(keys, "message:key:"+project.SecretKeyHMAC)
+		}
+	}
+	res, err := limiter.Allow(ctx, keys, limit)
+	if err != nil {
+		log.Warn("message rate limit check failed", zap.Strings("keys", keys), zap.Error(err))
+		return nil
+	}
+	return &res
+}
+
+// RetryAfterSeconds rounds the wait of a rejected result up to whole seconds, at least one.
+func RetryAfterSeconds(res ratelimit.Result) int {
+	return int(math.Max(1, math.Ceil(res.RetryAfter.Seconds())))
+}
+
+// messageRateLimit returns the project's message limit: project_config.message_rate_burst and
+// project_config.message_rate_per_sec replace the deployment values when set.
+func messageRateLimit(project *model.Project, cfg config.RateLimitCfg) ratelimit.Limit {
+	limit := ratelimit.Limit{Burst: cfg.MessageBurst, RatePerSec: cfg.MessageRatePerSec}
+	pc, _ := project.Configs["project_config"].(map[string]interface{})
+	if v, ok := pc[projectConfigMessageRateBurst].(float64); ok {
+		limit.Burst = int(v)
+	}
+	if v, ok := pc[projectConfigMessageRatePerSec].(float64); ok && v > 0 {
+		limit.RatePerSec = v
+	}
+	return limit
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/ratelimit"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+func newRateLimitedRouter(cfg *config.Config, project *model.Project) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("project", project) })
+	r.POST("/session/:session_id/messages", MessageRateLimit(cfg, ratelimit.NewMemoryLimiter(), zap.NewNop()), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	return r
+}
+
+func postMessage(r *gin.Engine, sessionID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/session/"+sessionID+"/messages", nil)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMessageRateLimit(t *testing.T) {
+	cfg := &config.Config{RateLimit: config.RateLimitCfg{MessageBurst: 2, MessageRatePerSec: 0.01}}
+
+	t.Run("per session", func(t *testing.T) {
+		r := newRateLimitedRouter(cfg, &model.Project{ID: uuid.New()})
+		sessionID := uuid.New().String()
+
+		w := postMessage(r, sessionID)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "2", w.Header().Get(RateLimitLimitHeader))
+		assert.Equal(t, "1", w.Header().Get(RateLimitRemainingHeader))
+
+		assert.Equal(t, http.StatusCreated, postMessage(r, sessionID).Code)
+
+		w = postMessage(r, sessionID)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "100", w.Header().Get("Retry-After"))
+		assert.Equal(t, "0", w.Header().Get(RateLimitRemainingHeader))
+		assert.Contains(t, w.Body.String(), "RATE_LIMITED")
+
+		assert.Equal(t, http.StatusCreated, postMessage(r, uuid.New().String()).Code, "other sessions have their own bucket")
+	})
+
+	t.Run("per API key", func(t *testing.T) {
+		perKey := *cfg
+		perKey.RateLimit.PerAPIKey = true
+		r := newRateLimitedRouter(&perKey, &model.Project{ID: uuid.New(), SecretKeyHMAC: "hmac"})
+
+		assert.Equal(t, http.StatusCreated, postMessage(r, uuid.New().String()).Code)
+		assert.Equal(t, http.StatusCreated, postMessage(r, uuid.New().String()).Code)
+		assert.Equal(t, http.StatusTooManyRequests, postMessage(r, uuid.New().String()).Code)
+	})
+
+	t.Run("per API key counts each project key on its own", func(t *testing.T) {
+		perKey := *cfg
+		perKey.RateLimit.PerAPIKey = true
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		project := &model.Project{ID: uuid.New(), SecretKeyHMAC: "hmac"}
+		r.Use(func(c *gin.Context) {
+			c.Set("project", project)
+			if id, err := uuid.Parse(c.GetHeader("X-Key")); err == nil {
+				c.Set("project_key_id", id)
+			}
+		})
+		r.POST("/session/:session_id/messages", MessageRateLimit(&perKey, ratelimit.NewMemoryLimiter(), zap.NewNop()), func(c *gin.Context) {
+			c.Status(http.StatusCreated)
+		})
+		post := func(keyID string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/session/"+uuid.New().String()+"/messages", nil)
+			req.Header.Set("X-Key", keyID)
+			r.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		keyA, keyB := uuid.New().String(), uuid.New().String()
+		assert.Equal(t, http.StatusCreated, post(keyA))
+		assert.Equal(t, http.StatusCreated, post(keyA))
+		assert.Equal(t, http.StatusTooManyRequests, post(keyA))
+		assert.Equal(t, http.StatusCreated, post(keyB), "another key of the project has its own bucket")
+		assert.Equal(t, http.StatusCreated, post(""), "so does the project secret key")
+	})
+
+	t.Run("project override", func(t *testing.T) {
+		project := &model.Project{ID: uuid.New(), Configs: map[string]interface{}{
+			"project_config": map[string]interface{}{"message_rate_burst": float64(1)},
+		}}
+		r := newRateLimitedRouter(cfg, project)
+		sessionID := uuid.New().String()
+		assert.Equal(t, http.StatusCreated, postMessage(r, sessionID).Code)
+		assert.Equal(t, http.StatusTooManyRequests, postMessage(r, sessionID).Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := newRateLimitedRouter(&config.Config{}, &model.Project{ID: uuid.New()})
+		sessionID := uuid.New().String()
+		for i := 0; i < 5; i++ {
+			w := postMessage(r, sessionID)
+			assert.Equal(t, http.StatusCreated, w.Code)
+			assert.Empty(t, w.Header().Get(RateLimitLimitHeader))
+		}
+	})
+}
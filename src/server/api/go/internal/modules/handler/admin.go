@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -29,9 +31,11 @@ type AdminHandler struct {
 	db           *gorm.DB
 	rdb          *redis.Client
 	cfg          *config.Config
+	assetGCSvc   service.AssetGCService
+	jobQueue     service.JobQueue
 }
 
-func NewAdminHandler(projectSvc service.ProjectService, projectRepo repo.ProjectRepo, s3 *blob.S3Deps, assetRefRepo repo.AssetReferenceRepo, db *gorm.DB, rdb *redis.Client, cfg *config.Config) *AdminHandler {
+func NewAdminHandler(projectSvc service.ProjectService, projectRepo repo.ProjectRepo, s3 *blob.S3Deps, assetRefRepo repo.AssetReferenceRepo, db *gorm.DB, rdb *redis.Client, cfg *config.Config, assetGCSvc service.AssetGCService, jobQueue service.JobQueue) *AdminHandler {
 	return &AdminHandler{
 		projectSvc:   projectSvc,
 		projectRepo:  projectRepo,
@@ -40,6 +44,8 @@ func NewAdminHandler(projectSvc service.ProjectService, projectRepo repo.Project
 		db:           db,
 		rdb:          rdb,
 		cfg:          cfg,
+		assetGCSvc:   assetGCSvc,
+		jobQueue:     jobQueue,
 	}
 }
 
@@ -236,6 +242,161 @@ func (h *AdminHandler) AnalyzeProjectMetrics(c *gin.Context) {
 	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
 }
 
+type CollectOrphanedAssetsReq struct {
+	OlderThanHours int  `json:"older_than_hours" binding:"omitempty,min=1" example:"24"`
+	DryRun         bool `json:"dry_run" example:"true"`
+}
+
+// CollectOrphanedAssets godoc
+//
+//	@Summary		Collect orphaned assets
+//	@Description	Delete assets, across all projects, that nothing references and that were not created or referenced within `older_than_hours` (default: the configured retention, and at least 1 hour). Objects are removed from storage before their records. With `dry_run` nothing is deleted and the assets that would be are listed.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		CollectOrphanedAssetsReq	false	"Collection options"
+//	@Success		200		{object}	serializer.Response{data=service.CollectOrphanedAssetsOutput}
+//	@Failure		400		{object}	serializer.Response
+//	@Failure		500		{object}	serializer.Response
+//	@Router			/admin/v1/asset/gc [post]
+func (h *AdminHandler) CollectOrphanedAssets(c *gin.Context) {
+	var req CollectOrphanedAssetsReq
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+	}
+	hours := req.OlderThanHours
+	if hours == 0 {
+		hours = h.cfg.Retention.OrphanAssetHours
+	}
+
+	out, err := h.assetGCSvc.CollectOrphanedAssets(c.Request.Context(), time.Duration(hours)*time.Hour, req.DryRun)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidOrphanAge) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+type CheckSessionStatsReq struct {
+	Repair bool `json:"repair" example:"false"`
+}
+
+type CheckSessionStatsResp struct {
+	Drifted  []repo.SessionStatsDrift `json:"drifted"`
+	Repaired bool                     `json:"repaired"`
+}
+
+// CheckSessionStats godoc
+//
+//	@Summary		Check session message counters
+//	@Description	Compare the message_count and last_message_at of every session, across all projects, with its live messages and list the sessions that disagree. With `repair` their counters are set to the actual values.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		CheckSessionStatsReq	false	"Check options"
+//	@Success		200		{object}	serializer.Response{data=handler.CheckSessionStatsResp}
+//	@Failure		400		{object}	serializer.Response
+//	@Failure		500		{object}	serializer.Response
+//	@Router			/admin/v1/session/stats/check [post]
+func (h *AdminHandler) CheckSessionStats(c *gin.Context) {
+	var req CheckSessionStatsReq
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+	}
+
+	drifted, err := repo.CheckSessionStats(c.Request.Context(), h.db, 1000, req.Repair)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+	if drifted == nil {
+		drifted = []repo.SessionStatsDrift{}
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: CheckSessionStatsResp{Drifted: drifted, Repaired: req.Repair}})
+}
+
+type ListDeadJobsReq struct {
+	Kind  string `form:"kind" json:"kind" example:"asset_gc"`
+	Limit int    `form:"limit,default=50" json:"limit" binding:"min=1,max=200" example:"50"`
+}
+
+type ListDeadJobsResp struct {
+	Items []model.Job `json:"items"`
+}
+
+// ListDeadJobs godoc
+//
+//	@Summary		List dead jobs
+//	@Description	List background jobs that failed on every attempt and were moved to the dead letters, most recently failed first. Each job carries its payload, attempt count and last error.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			kind	query		string	false	"Only jobs of this kind"
+//	@Param			limit	query		int		false	"Maximum jobs returned, default 50, at most 200"
+//	@Success		200		{object}	serializer.Response{data=handler.ListDeadJobsResp}
+//	@Failure		400		{object}	serializer.Response
+//	@Failure		500		{object}	serializer.Response
+//	@Router			/admin/v1/jobs/dead [get]
+func (h *AdminHandler) ListDeadJobs(c *gin.Context) {
+	req := ListDeadJobsReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	jobs, err := h.jobQueue.ListDeadJobs(c.Request.Context(), req.Kind, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: ListDeadJobsResp{Items: jobs}})
+}
+
+// RetryDeadJob godoc
+//
+//	@Summary		Retry a dead job
+//	@Description	Queue a dead job again, due immediately and with a fresh set of attempts.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			job_id	path		string	true	"Job ID"	format(uuid)
+//	@Success		200		{object}	serializer.Response
+//	@Failure		400		{object}	serializer.Response
+//	@Failure		404		{object}	serializer.Response	"No dead job with this ID"
+//	@Failure		500		{object}	serializer.Response
+//	@Router			/admin/v1/jobs/{job_id}/retry [post]
+func (h *AdminHandler) RetryDeadJob(c *gin.Context) {
+	jobID, err := uuid.Parse(c.Param("job_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid job_id", err))
+		return
+	}
+
+	if err := h.jobQueue.RetryDeadJob(c.Request.Context(), jobID); err != nil {
+		if errors.Is(err, service.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "JOB_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
 // EncryptProject encrypts all existing S3 data for a project and enables encryption.
 // Requires project API key as Bearer auth (uses ProjectAuth middleware).
 func (h *AdminHandler) EncryptProject(c *gin.Context) {
@@ -291,7 +452,7 @@ func (h *AdminHandler) RotateProjectSecretKeyAdmin(c *gin.Context) {
 	}
 
 	// Invalidate old HMAC cache so the old key can no longer authenticate
-	middleware.InvalidateProjectAuthCache(h.rdb, oldHMAC)
+	middleware.InvalidateProjectAuthCache(h.rdb, projectID, oldHMAC)
 
 	c.JSON(http.StatusOK, serializer.Response{Data: output})
 }
@@ -332,7 +493,7 @@ func (h *AdminHandler) RotateProjectSecretKey(c *gin.Context) {
 	}
 
 	// Invalidate old HMAC cache so the old key can no longer authenticate
-	middleware.InvalidateProjectAuthCache(h.rdb, oldHMAC)
+	middleware.InvalidateProjectAuthCache(h.rdb, project.ID, oldHMAC)
 
 	c.JSON(http.StatusOK, serializer.Response{Data: output})
 }
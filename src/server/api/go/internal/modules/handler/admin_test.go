@@ -3,6 +3,7 @@ package handler
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +12,7 @@ import (
 	"github.com/bytedance/sonic"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
 	"github.com/memodb-io/Acontext/internal/modules/repo"
 	"github.com/memodb-io/Acontext/internal/modules/service"
 	"github.com/stretchr/testify/assert"
@@ -67,12 +69,17 @@ func (m *MockProjectService) AnalyzeMetrics(ctx context.Context, projectID uuid.
 	return args.Get(0).(*http.Response), args.Error(1)
 }
 
+func (m *MockProjectService) SetDefaultSystemPrompt(ctx context.Context, projectID uuid.UUID, text string) error {
+	args := m.Called(ctx, projectID, text)
+	return args.Error(0)
+}
+
 func TestAdminHandler_CreateProject(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	t.Run("success", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 		secretKey := "test-secret-key-12345"
@@ -111,7 +118,7 @@ func TestAdminHandler_CreateProject(t *testing.T) {
 
 	t.Run("invalid request body", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -125,7 +132,7 @@ func TestAdminHandler_CreateProject(t *testing.T) {
 
 	t.Run("service error", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		mockSvc.On("Create", mock.Anything, mock.Anything).Return(nil, errors.New("service error"))
 
@@ -150,7 +157,7 @@ func TestAdminHandler_DeleteProject(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -170,7 +177,7 @@ func TestAdminHandler_DeleteProject(t *testing.T) {
 
 	t.Run("invalid project id", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -184,7 +191,7 @@ func TestAdminHandler_DeleteProject(t *testing.T) {
 
 	t.Run("service error", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -208,7 +215,7 @@ func TestAdminHandler_AnalyzeProjectUsages(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 		intervalDays := 30
@@ -245,7 +252,7 @@ func TestAdminHandler_AnalyzeProjectUsages(t *testing.T) {
 
 	t.Run("invalid project id", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -259,7 +266,7 @@ func TestAdminHandler_AnalyzeProjectUsages(t *testing.T) {
 
 	t.Run("default interval_days", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -278,7 +285,7 @@ func TestAdminHandler_AnalyzeProjectUsages(t *testing.T) {
 
 	t.Run("service error", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -298,7 +305,7 @@ func TestAdminHandler_AnalyzeProjectUsages(t *testing.T) {
 
 	t.Run("with fields param", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 		expectedFields := []string{"storage"}
@@ -320,7 +327,7 @@ func TestAdminHandler_AnalyzeProjectUsages(t *testing.T) {
 
 	t.Run("with multiple fields param", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 		expectedFields := []string{"task_success", "task_status", "task_stats"}
@@ -340,7 +347,7 @@ func TestAdminHandler_AnalyzeProjectUsages(t *testing.T) {
 
 	t.Run("empty fields param fetches all", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -364,7 +371,7 @@ func TestAdminHandler_AnalyzeProjectStatistics(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -397,7 +404,7 @@ func TestAdminHandler_AnalyzeProjectStatistics(t *testing.T) {
 
 	t.Run("invalid project id", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -411,7 +418,7 @@ func TestAdminHandler_AnalyzeProjectStatistics(t *testing.T) {
 
 	t.Run("service error", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -435,7 +442,7 @@ func TestAdminHandler_AnalyzeProjectMetrics(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -463,7 +470,7 @@ func TestAdminHandler_AnalyzeProjectMetrics(t *testing.T) {
 
 	t.Run("invalid project id", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -477,7 +484,7 @@ func TestAdminHandler_AnalyzeProjectMetrics(t *testing.T) {
 
 	t.Run("service error", func(t *testing.T) {
 		mockSvc := new(MockProjectService)
-		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil)
+		handler := NewAdminHandler(mockSvc, nil, nil, nil, nil, nil, nil, nil, nil)
 
 		projectID := uuid.New()
 
@@ -495,3 +502,125 @@ func TestAdminHandler_AnalyzeProjectMetrics(t *testing.T) {
 		mockSvc.AssertExpectations(t)
 	})
 }
+
+type MockJobQueue struct {
+	mock.Mock
+}
+
+func (m *MockJobQueue) EnqueueJob(ctx context.Context, kind string, payload json.RawMessage) (*model.Job, error) {
+	args := m.Called(ctx, kind, payload)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Job), args.Error(1)
+}
+
+func (m *MockJobQueue) ProcessDue(ctx context.Context, limit int) (int, error) {
+	args := m.Called(ctx, limit)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockJobQueue) ListDeadJobs(ctx context.Context, kind string, limit int) ([]model.Job, error) {
+	args := m.Called(ctx, kind, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.Job), args.Error(1)
+}
+
+func (m *MockJobQueue) RetryDeadJob(ctx context.Context, id uuid.UUID) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func TestAdminHandler_ListDeadJobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("success", func(t *testing.T) {
+		queue := new(MockJobQueue)
+		handler := NewAdminHandler(new(MockProjectService), nil, nil, nil, nil, nil, nil, nil, queue)
+		jobID := uuid.New()
+		queue.On("ListDeadJobs", mock.Anything, "thumbnail", 5).Return([]model.Job{
+			{ID: jobID, Kind: "thumbnail", Status: model.JobStatusDead, Attempts: 5, LastError: "boom"},
+		}, nil)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/admin/v1/jobs/dead?kind=thumbnail&limit=5", nil)
+
+		handler.ListDeadJobs(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp map[string]interface{}
+		assert.NoError(t, sonic.Unmarshal(w.Body.Bytes(), &resp))
+		items := resp["data"].(map[string]interface{})["items"].([]interface{})
+		if assert.Len(t, items, 1) {
+			item := items[0].(map[string]interface{})
+			assert.Equal(t, jobID.String(), item["id"])
+			assert.Equal(t, "boom", item["last_error"])
+		}
+		queue.AssertExpectations(t)
+	})
+
+	t.Run("limit out of range", func(t *testing.T) {
+		queue := new(MockJobQueue)
+		handler := NewAdminHandler(new(MockProjectService), nil, nil, nil, nil, nil, nil, nil, queue)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/admin/v1/jobs/dead?limit=1000", nil)
+
+		handler.ListDeadJobs(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		queue.AssertNotCalled(t, "ListDeadJobs", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestAdminHandler_RetryDeadJob(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jobID := uuid.New()
+
+	tests := []struct {
+		name           string
+		param          string
+		setup          func(*MockJobQueue)
+		expectedStatus int
+	}{
+		{
+			name:           "requeued",
+			param:          jobID.String(),
+			setup:          func(q *MockJobQueue) { q.On("RetryDeadJob", mock.Anything, jobID).Return(nil) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "not dead",
+			param:          jobID.String(),
+			setup:          func(q *MockJobQueue) { q.On("RetryDeadJob", mock.Anything, jobID).Return(service.ErrJobNotFound) },
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid id",
+			param:          "invalid-uuid",
+			setup:          func(q *MockJobQueue) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := new(MockJobQueue)
+			tt.setup(queue)
+			handler := NewAdminHandler(new(MockProjectService), nil, nil, nil, nil, nil, nil, nil, queue)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/admin/v1/jobs/"+tt.param+"/retry", nil)
+			c.Params = gin.Params{{Key: "job_id", Value: tt.param}}
+
+			handler.RetryDeadJob(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			queue.AssertExpectations(t)
+		})
+	}
+}
@@ -54,7 +54,7 @@ type GlobArtifactsReq struct {
 // UpsertArtifact godoc
 //
 //	@Summary		Upsert artifact
-//	@Description	Upload a file and create or update an artifact record under a disk. File size must not exceed the configured maximum upload size limit (default: 16MB).
+//	@Description	Upload a file and create or update an artifact record under a disk. File size must not exceed the configured maximum upload size limit (default: 16MB). When the project already stores identical content, the stored asset is reused and the response has `deduplicated: true`.
 //	@Tags			artifact
 //	@Accept			multipart/form-data
 //	@Produce		json
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/middleware"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+type AssetContentHandler struct {
+	svc service.AssetContentService
+}
+
+func NewAssetContentHandler(s service.AssetContentService) *AssetContentHandler {
+	return &AssetContentHandler{svc: s}
+}
+
+// GetAssetContent godoc
+//
+//	@Summary		Download asset content
+//	@Description	Stream the bytes of a project asset through the API, for clients that cannot use presigned URLs. A single `Range: bytes=...` range is honored with a 206 response, so audio and video players can seek; other Range headers return the whole asset. `Content-Type` is the asset's MIME type.
+//	@Tags			asset
+//	@Produce		octet-stream
+//	@Param			asset_id	path		string	true		"Asset ID"	format(uuid)
+//	@Param			Range		header	string	false	"Byte range, e.g. bytes=0-1023"
+//	@Security		BearerAuth
+//	@Success		200	{file}	binary	"Whole asset"
+//	@Success		206	{file}	binary	"Requested range"
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Asset not found"
+//	@Failure		416	{object}	serializer.Response	"Range not satisfiable"
+//	@Router			/asset/{asset_id}/content [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Read the first kilobyte of an asset\nchunk = client.assets.get_content('asset-uuid', range=(0, 1023))\nprint(len(chunk))\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Read the first kilobyte of an asset\nconst chunk = await client.assets.getContent('asset-uuid', { range: [0, 1023] });\nconsole.log(chunk.byteLength);\n","label":"JavaScript"}]
+func (h *AssetContentHandler) GetAssetContent(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	assetID, err := uuid.Parse(c.Param("asset_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid asset_id", err))
+		return
+	}
+
+	content, err := h.svc.OpenAssetContent(c.Request.Context(), service.OpenAssetContentInput{
+		ProjectID: project.ID,
+		AssetID:   assetID,
+		Range:     c.GetHeader("Range"),
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		var unsatisfiable *service.RangeNotSatisfiableError
+		switch {
+		case errors.As(err, &unsatisfiable):
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", unsatisfiable.Size))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, serializer.Err(http.StatusRequestedRangeNotSatisfiable, "range not satisfiable", err))
+		case errors.Is(err, service.ErrAssetNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "asset not found", err))
+		case errors.Is(err, service.ErrEncryptedRangeLarge):
+			c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "RANGE_ENCRYPTED", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+	defer content.Body.Close()
+
+	headers := map[string]string{"Accept-Ranges": "bytes"}
+	if content.ETag != "" {
+		headers["ETag"] = strconv.Quote(content.ETag)
+	}
+	status := http.StatusOK
+	if content.Range != nil {
+		status = http.StatusPartialContent
+		headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", content.Range.Start, content.Range.End, content.Size)
+	}
+	// DataFromReader copies in small chunks as the client reads, so the asset is never held whole.
+	c.DataFromReader(status, content.Len(), content.MIME, content.Body, headers)
+}
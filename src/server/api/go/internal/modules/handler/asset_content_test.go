@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/infra/blob"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockAssetContentService is a mock implementation of AssetContentService
+type MockAssetContentService struct {
+	mock.Mock
+}
+
+func (m *MockAssetContentService) OpenAssetContent(ctx context.Context, in service.OpenAssetContentInput) (*service.AssetContent, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.AssetContent), args.Error(1)
+}
+
+func TestAssetContentHandler_GetAssetContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+	assetID := uuid.New()
+
+	tests := []struct {
+		name           string
+		rangeHeader    string
+		content        *service.AssetContent
+		err            error
+		expectedStatus int
+		expectedBody   string
+		expectedRange  string
+	}{
+		{
+			name:           "whole asset",
+			content:        &service.AssetContent{Body: io.NopCloser(strings.NewReader("0123456789")), MIME: "audio/mpeg", Size: 10},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "0123456789",
+		},
+		{
+			name:        "partial content",
+			rangeHeader: "bytes=2-4",
+			content: &service.AssetContent{Body: io.NopCloser(strings.NewReader("234")), MIME: "audio/mpeg", Size: 10,
+				Range: &blob.ByteRange{Start: 2, End: 4}},
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "234",
+			expectedRange:  "bytes 2-4/10",
+		},
+		{
+			name:           "unsatisfiable range",
+			rangeHeader:    "bytes=20-",
+			err:            &service.RangeNotSatisfiableError{Size: 10},
+			expectedStatus: http.StatusRequestedRangeNotSatisfiable,
+			expectedRange:  "bytes */10",
+		},
+		{
+			name:           "range of a large encrypted asset",
+			rangeHeader:    "bytes=0-1023",
+			err:            service.ErrEncryptedRangeLarge,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "asset not found",
+			err:            service.ErrAssetNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockAssetContentService)
+			mockService.On("OpenAssetContent", mock.Anything, service.OpenAssetContentInput{
+				ProjectID: projectID, AssetID: assetID, Range: tt.rangeHeader,
+			}).Return(tt.content, tt.err)
+			handler := NewAssetContentHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", &model.Project{ID: projectID})
+			c.Params = gin.Params{{Key: "asset_id", Value: assetID.String()}}
+			c.Request, _ = http.NewRequest("GET", "/asset/"+assetID.String()+"/content", nil)
+			if tt.rangeHeader != "" {
+				c.Request.Header.Set("Range", tt.rangeHeader)
+			}
+
+			handler.GetAssetContent(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedRange, w.Header().Get("Content-Range"))
+			if tt.content != nil {
+				assert.Equal(t, tt.expectedBody, w.Body.String())
+				assert.Equal(t, "audio/mpeg", w.Header().Get("Content-Type"))
+				assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+type AssetUploadHandler struct {
+	svc service.AssetUploadService
+}
+
+func NewAssetUploadHandler(s service.AssetUploadService) *AssetUploadHandler {
+	return &AssetUploadHandler{svc: s}
+}
+
+type CreatePresignedUploadReq struct {
+	MIME      string `json:"mime" binding:"required" example:"video/mp4"`
+	SizeBytes int64  `json:"size_bytes" binding:"required,min=1" example:"104857600"`
+}
+
+// CreatePresignedUpload godoc
+//
+//	@Summary		Create presigned upload
+//	@Description	Reserve a pending asset and return a presigned PUT URL for uploading it directly to object storage. The PUT must send the same Content-Type and Content-Length as the request. The size is checked against the project's upload limit and the MIME type against the allowed list before any URL is issued. Uploads that are not confirmed before `expires_at` are removed. Not available for encrypted projects.
+//	@Tags			asset
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body	handler.CreatePresignedUploadReq	true	"Upload request"
+//	@Security		BearerAuth
+//	@Success		201	{object}	serializer.Response{data=service.CreatePresignedUploadOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or encrypted project"
+//	@Failure		413	{object}	serializer.Response	"Size exceeds the upload limit"
+//	@Failure		415	{object}	serializer.Response	"MIME type not allowed"
+//	@Router			/asset/upload [post]
+//	@x-code-samples	[{"lang":"python","source":"import os\nimport requests\nfrom acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Reserve an upload, PUT the file, then confirm it\nsize = os.path.getsize('demo.mp4')\nupload = client.assets.create_upload(mime='video/mp4', size_bytes=size)\nwith open('demo.mp4', 'rb') as f:\n    requests.put(upload.upload_url, data=f, headers={'Content-Type': 'video/mp4'})\nasset = client.assets.confirm_upload(upload.upload.id)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\nimport fs from 'fs';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Reserve an upload, PUT the file, then confirm it\nconst data = fs.readFileSync('demo.mp4');\nconst upload = await client.assets.createUpload({ mime: 'video/mp4', sizeBytes: data.length });\nawait fetch(upload.upload_url, { method: 'PUT', body: data, headers: { 'Content-Type': 'video/mp4' } });\nconst asset = await client.assets.confirmUpload(upload.upload.id);\n","label":"JavaScript"}]
+func (h *AssetUploadHandler) CreatePresignedUpload(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	req := CreatePresignedUploadReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	out, err := h.svc.CreatePresignedUpload(c.Request.Context(), service.CreatePresignedUploadInput{
+		Project:   project,
+		MIME:      req.MIME,
+		SizeBytes: req.SizeBytes,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, serializer.Err(http.StatusRequestEntityTooLarge, "upload too large", err))
+		case errors.Is(err, service.ErrUploadMIMENotAllowed):
+			c.JSON(http.StatusUnsupportedMediaType, serializer.Err(http.StatusUnsupportedMediaType, "MIME type not allowed", err))
+		case errors.Is(err, service.ErrUploadInvalid), errors.Is(err, service.ErrUploadEncrypted):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+}
+
+// ConfirmAsset godoc
+//
+//	@Summary		Confirm presigned upload
+//	@Description	Confirm that the object for a presigned upload has been written. The object's size, MIME type and content hash are recorded and the asset is registered with the project; if the project already stores identical content, the upload points at the existing asset. Confirming twice returns the confirmed upload.
+//	@Tags			asset
+//	@Accept			json
+//	@Produce		json
+//	@Param			upload_id	path	string	true	"Upload ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.AssetUpload}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or size mismatch"
+//	@Failure		404	{object}	serializer.Response	"Upload not found"
+//	@Failure		409	{object}	serializer.Response	"Object has not been uploaded yet"
+//	@Failure		410	{object}	serializer.Response	"Upload expired"
+//	@Failure		422	{object}	serializer.Response	"Object content does not match the declared MIME type"
+//	@Router			/asset/upload/{upload_id}/confirm [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Confirm a finished upload\nupload = client.assets.confirm_upload('upload-uuid')\nprint(upload.asset_id)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Confirm a finished upload\nconst upload = await client.assets.confirmUpload('upload-uuid');\nconsole.log(upload.asset_id);\n","label":"JavaScript"}]
+func (h *AssetUploadHandler) ConfirmAsset(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	uploadID, err := uuid.Parse(c.Param("upload_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	upload, err := h.svc.ConfirmAsset(c.Request.Context(), project.ID, uploadID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "upload not found", err))
+		case errors.Is(err, service.ErrUploadExpired):
+			c.JSON(http.StatusGone, serializer.Err(http.StatusGone, "upload expired", err))
+		case errors.Is(err, service.ErrUploadObjectMissing):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "object has not been uploaded", err))
+		case errors.Is(err, service.ErrUploadInvalid):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		case errors.Is(err, service.ErrMIMEMismatch):
+			c.JSON(http.StatusUnprocessableEntity, serializer.Err(http.StatusUnprocessableEntity, "MIME type mismatch", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: upload})
+}
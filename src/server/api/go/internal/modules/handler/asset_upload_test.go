@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockAssetUploadService is a mock implementation of AssetUploadService
+type MockAssetUploadService struct {
+	mock.Mock
+}
+
+func (m *MockAssetUploadService) CreatePresignedUpload(ctx context.Context, in service.CreatePresignedUploadInput) (*service.CreatePresignedUploadOutput, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.CreatePresignedUploadOutput), args.Error(1)
+}
+
+func (m *MockAssetUploadService) ConfirmAsset(ctx context.Context, projectID uuid.UUID, uploadID uuid.UUID) (*model.AssetUpload, error) {
+	args := m.Called(ctx, projectID, uploadID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.AssetUpload), args.Error(1)
+}
+
+func (m *MockAssetUploadService) PurgeExpiredUploads(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func TestAssetUploadHandler_CreatePresignedUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		setup          func(*MockAssetUploadService)
+		expectedStatus int
+	}{
+		{
+			name: "success",
+			body: `{"mime":"video/mp4","size_bytes":1024}`,
+			setup: func(svc *MockAssetUploadService) {
+				svc.On("CreatePresignedUpload", mock.Anything, mock.MatchedBy(func(in service.CreatePresignedUploadInput) bool {
+					return in.Project.ID == projectID && in.MIME == "video/mp4" && in.SizeBytes == 1024
+				})).Return(&service.CreatePresignedUploadOutput{Upload: &model.AssetUpload{ID: uuid.New()}, UploadURL: "https://s3.example/put"}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "missing size",
+			body:           `{"mime":"video/mp4"}`,
+			setup:          func(svc *MockAssetUploadService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "too large",
+			body: `{"mime":"video/mp4","size_bytes":1024}`,
+			setup: func(svc *MockAssetUploadService) {
+				svc.On("CreatePresignedUpload", mock.Anything, mock.Anything).Return(nil, service.ErrUploadTooLarge)
+			},
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "disallowed MIME",
+			body: `{"mime":"text/html","size_bytes":1024}`,
+			setup: func(svc *MockAssetUploadService) {
+				svc.On("CreatePresignedUpload", mock.Anything, mock.Anything).Return(nil, service.ErrUploadMIMENotAllowed)
+			},
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockAssetUploadService)
+			tt.setup(mockService)
+			handler := NewAssetUploadHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", &model.Project{ID: projectID})
+			c.Request, _ = http.NewRequest("POST", "/asset/upload", bytes.NewBufferString(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.CreatePresignedUpload(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAssetUploadHandler_ConfirmAsset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+	uploadID := uuid.New()
+
+	tests := []struct {
+		name           string
+		uploadID       string
+		err            error
+		expectedStatus int
+	}{
+		{name: "success", uploadID: uploadID.String(), expectedStatus: http.StatusOK},
+		{name: "invalid id", uploadID: "nope", expectedStatus: http.StatusBadRequest},
+		{name: "not found", uploadID: uploadID.String(), err: service.ErrUploadNotFound, expectedStatus: http.StatusNotFound},
+		{name: "expired", uploadID: uploadID.String(), err: service.ErrUploadExpired, expectedStatus: http.StatusGone},
+		{name: "object missing", uploadID: uploadID.String(), err: service.ErrUploadObjectMissing, expectedStatus: http.StatusConflict},
+		{name: "MIME mismatch", uploadID: uploadID.String(), err: service.ErrMIMEMismatch, expectedStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockAssetUploadService)
+			if tt.uploadID == uploadID.String() {
+				if tt.err != nil {
+					mockService.On("ConfirmAsset", mock.Anything, projectID, uploadID).Return(nil, tt.err)
+				} else {
+					mockService.On("ConfirmAsset", mock.Anything, projectID, uploadID).
+						Return(&model.AssetUpload{ID: uploadID, Status: model.AssetUploadStatusConfirmed}, nil)
+				}
+			}
+			handler := NewAssetUploadHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", &model.Project{ID: projectID})
+			c.Params = gin.Params{{Key: "upload_id", Value: tt.uploadID}}
+			c.Request, _ = http.NewRequest("POST", "/asset/upload/"+tt.uploadID+"/confirm", nil)
+
+			handler.ConfirmAsset(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
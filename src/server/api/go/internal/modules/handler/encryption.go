@@ -48,7 +48,7 @@ func encryptProject(c *gin.Context, db *gorm.DB, rdb *redis.Client, s3 *blob.S3D
 	}
 
 	// Invalidate cached project so subsequent requests see encryption_enabled = true
-	middleware.InvalidateProjectAuthCache(rdb, project.SecretKeyHMAC)
+	middleware.InvalidateProjectAuthCache(rdb, project.ID, project.SecretKeyHMAC)
 
 	// Enumerate all S3 keys for this project
 	s3Keys, err := assetRefRepo.ListS3KeysByProject(c.Request.Context(), project.ID)
@@ -114,7 +114,7 @@ func decryptProject(c *gin.Context, db *gorm.DB, rdb *redis.Client, s3 *blob.S3D
 	}
 
 	// Invalidate cached project so subsequent requests see encryption_enabled = false
-	middleware.InvalidateProjectAuthCache(rdb, project.SecretKeyHMAC)
+	middleware.InvalidateProjectAuthCache(rdb, project.ID, project.SecretKeyHMAC)
 
 	c.JSON(http.StatusOK, serializer.Response{Msg: "encryption disabled"})
 }
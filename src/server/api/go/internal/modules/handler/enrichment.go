@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+type EnrichmentHandler struct {
+	svc service.EnrichmentService
+}
+
+func NewEnrichmentHandler(svc service.EnrichmentService) *EnrichmentHandler {
+	return &EnrichmentHandler{svc: svc}
+}
+
+type EnrichmentStatusResp struct {
+	Items []model.PartEnrichment `json:"items"`
+}
+
+type RetryEnrichmentReq struct {
+	PartIndex *int `json:"part_index" binding:"omitempty,min=0" example:"0"`
+}
+
+type RetryEnrichmentResp struct {
+	Requeued int64 `json:"requeued"`
+}
+
+// writeEnrichmentErr maps enrichment service errors to HTTP responses.
+func writeEnrichmentErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+	case errors.Is(err, service.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+	default:
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+	}
+}
+
+// parseMessagePath reads the session and message IDs and the project of an enrichment request.
+func parseMessagePath(c *gin.Context) (*model.Project, uuid.UUID, uuid.UUID, bool) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return nil, uuid.Nil, uuid.Nil, false
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return nil, uuid.Nil, uuid.Nil, false
+	}
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return nil, uuid.Nil, uuid.Nil, false
+	}
+	return project, sessionID, messageID, true
+}
+
+// GetEnrichmentStatus godoc
+//
+//	@Summary		Get message enrichment status
+//	@Description	List the enrichment jobs (OCR, ASR, captioning, ...) of a message's image, audio and video parts with their status: pending, running, done or failed. Results of finished jobs are stored in the part's meta under the enricher name.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.EnrichmentStatusResp}
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/enrichments [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Check the enrichment of a message's media parts\nresult = client.sessions.get_enrichment_status(session_id, message_id)\nfor job in result.items:\n    print(job.part_index, job.enricher, job.status)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Check the enrichment of a message's media parts\nconst result = await client.sessions.getEnrichmentStatus(sessionId, messageId);\nfor (const job of result.items) {\n  console.log(job.part_index, job.enricher, job.status);\n}\n","label":"JavaScript"}]
+func (h *EnrichmentHandler) GetEnrichmentStatus(c *gin.Context) {
+	project, sessionID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	jobs, err := h.svc.GetEnrichmentStatus(c.Request.Context(), project.ID, sessionID, messageID)
+	if err != nil {
+		writeEnrichmentErr(c, err)
+		return
+	}
+	if jobs == nil {
+		jobs = []model.PartEnrichment{}
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: EnrichmentStatusResp{Items: jobs}})
+}
+
+// RetryEnrichment godoc
+//
+//	@Summary		Retry failed message enrichment
+//	@Description	Re-queue the failed enrichment jobs of a message, or only those of one part when part_index is given.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string						true	"Message ID"	format(uuid)
+//	@Param			payload		body	handler.RetryEnrichmentReq	false	"RetryEnrichment payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.RetryEnrichmentResp}
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/enrichments/retry [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Re-run failed enrichment of the first part\nclient.sessions.retry_enrichment(session_id, message_id, part_index=0)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Re-run failed enrichment of the first part\nawait client.sessions.retryEnrichment(sessionId, messageId, { partIndex: 0 });\n","label":"JavaScript"}]
+func (h *EnrichmentHandler) RetryEnrichment(c *gin.Context) {
+	project, sessionID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	req := RetryEnrichmentReq{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+	}
+
+	n, err := h.svc.RetryEnrichment(c.Request.Context(), service.RetryEnrichmentInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		PartIndex: req.PartIndex,
+	})
+	if err != nil {
+		writeEnrichmentErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: RetryEnrichmentResp{Requeued: n}})
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockEnrichmentService struct {
+	mock.Mock
+}
+
+func (m *MockEnrichmentService) EnqueueParts(ctx context.Context, in service.EnqueueEnrichmentInput) error {
+	args := m.Called(ctx, in)
+	return args.Error(0)
+}
+
+func (m *MockEnrichmentService) GetEnrichmentStatus(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) ([]model.PartEnrichment, error) {
+	args := m.Called(ctx, projectID, sessionID, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.PartEnrichment), args.Error(1)
+}
+
+func (m *MockEnrichmentService) RetryEnrichment(ctx context.Context, in service.RetryEnrichmentInput) (int64, error) {
+	args := m.Called(ctx, in)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockEnrichmentService) ProcessPending(ctx context.Context, limit int) (int, error) {
+	args := m.Called(ctx, limit)
+	return args.Int(0), args.Error(1)
+}
+
+func TestEnrichmentHandler_GetEnrichmentStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	messageID := uuid.New()
+
+	tests := []struct {
+		name           string
+		messageParam   string
+		setup          func(*MockEnrichmentService)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:         "success",
+			messageParam: messageID.String(),
+			setup: func(svc *MockEnrichmentService) {
+				svc.On("GetEnrichmentStatus", mock.Anything, projectID, sessionID, messageID).Return([]model.PartEnrichment{
+					{MessageID: messageID, PartIndex: 0, Enricher: model.MetaKeyOCR, Status: model.EnrichmentStatusDone},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"status":"done"`,
+		},
+		{
+			name:           "invalid message id",
+			messageParam:   "not-a-uuid",
+			setup:          func(svc *MockEnrichmentService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "message not found",
+			messageParam: messageID.String(),
+			setup: func(svc *MockEnrichmentService) {
+				svc.On("GetEnrichmentStatus", mock.Anything, projectID, sessionID, messageID).Return(nil, service.ErrMessageNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockEnrichmentService)
+			tt.setup(mockService)
+			handler := NewEnrichmentHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", &model.Project{ID: projectID})
+			c.Params = gin.Params{
+				{Key: "session_id", Value: sessionID.String()},
+				{Key: "message_id", Value: tt.messageParam},
+			}
+			c.Request, _ = http.NewRequest("GET", "/session/"+sessionID.String()+"/messages/"+tt.messageParam+"/enrichments", nil)
+
+			handler.GetEnrichmentStatus(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.Contains(t, w.Body.String(), tt.expectedBody)
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestEnrichmentHandler_RetryEnrichment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	messageID := uuid.New()
+	partIndex := 2
+
+	tests := []struct {
+		name           string
+		body           string
+		setup          func(*MockEnrichmentService)
+		expectedStatus int
+	}{
+		{
+			name: "retry whole message",
+			body: "",
+			setup: func(svc *MockEnrichmentService) {
+				svc.On("RetryEnrichment", mock.Anything, service.RetryEnrichmentInput{
+					ProjectID: projectID,
+					SessionID: sessionID,
+					MessageID: messageID,
+				}).Return(int64(3), nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "retry one part",
+			body: `{"part_index":2}`,
+			setup: func(svc *MockEnrichmentService) {
+				svc.On("RetryEnrichment", mock.Anything, service.RetryEnrichmentInput{
+					ProjectID: projectID,
+					SessionID: sessionID,
+					MessageID: messageID,
+					PartIndex: &partIndex,
+				}).Return(int64(1), nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "negative part index",
+			body:           `{"part_index":-1}`,
+			setup:          func(svc *MockEnrichmentService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "session not found",
+			body: "",
+			setup: func(svc *MockEnrichmentService) {
+				svc.On("RetryEnrichment", mock.Anything, mock.Anything).Return(int64(0), service.ErrSessionNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockEnrichmentService)
+			tt.setup(mockService)
+			handler := NewEnrichmentHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", &model.Project{ID: projectID})
+			c.Params = gin.Params{
+				{Key: "session_id", Value: sessionID.String()},
+				{Key: "message_id", Value: messageID.String()},
+			}
+			c.Request, _ = http.NewRequest("POST", "/session/"+sessionID.String()+"/messages/"+messageID.String()+"/enrichments/retry", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.RetryEnrichment(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
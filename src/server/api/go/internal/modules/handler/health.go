@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+)
+
+// Dependency statuses reported by Readyz.
+const (
+	DependencyOK      = "ok"
+	DependencyDown    = "down"
+	DependencyTimeout = "timeout"
+)
+
+// HealthCheck probes one dependency the server needs to serve requests.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	checks  []HealthCheck
+	timeout time.Duration
+}
+
+// NewHealthHandler returns the handler of the liveness and readiness probes. Each check gets
+// timeout to answer; timeout <= 0 leaves them bounded by the request only.
+func NewHealthHandler(timeout time.Duration, checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{checks: checks, timeout: timeout}
+}
+
+type DependencyStatus struct {
+	Status    string `json:"status" example:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+type ReadinessResp struct {
+	Ready        bool                        `json:"ready"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Healthz godoc
+//
+//	@Summary		Liveness probe
+//	@Description	Answers 200 as long as the process serves HTTP. No dependency is checked, so a database outage does not get the server restarted.
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	serializer.Response
+//	@Router			/healthz [get]
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, serializer.Response{Msg: "ok"})
+}
+
+// Readyz godoc
+//
+//	@Summary		Readiness probe
+//	@Description	Checks the database and object storage concurrently, each within the configured timeout, and reports the status of each. Answers 503 when any of them is down or too slow to answer.
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	serializer.Response{data=handler.ReadinessResp}
+//	@Failure		503	{object}	serializer.Response{data=handler.ReadinessResp}	"A dependency is down (NOT_READY)"
+//	@Router			/readyz [get]
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	resp := ReadinessResp{Ready: true, Dependencies: make(map[string]DependencyStatus, len(h.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st := h.run(c.Request.Context(), hc)
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Dependencies[hc.Name] = st
+			if st.Status != DependencyOK {
+				resp.Ready = false
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !resp.Ready {
+		c.JSON(http.StatusServiceUnavailable, serializer.Response{Code: http.StatusServiceUnavailable, Msg: "NOT_READY", Data: resp})
+		return
+	}
+	c.JSON(http.StatusOK, serializer.Response{Msg: "ok", Data: resp})
+}
+
+// run runs the check within the timeout. A check that ignores its context is abandoned once the
+// timeout passes, so it cannot hold the probe.
+func (h *HealthHandler) run(ctx context.Context, hc HealthCheck) DependencyStatus {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- hc.Check(ctx) }()
+
+	var st DependencyStatus
+	select {
+	case err := <-done:
+		st.Status = DependencyOK
+		if err != nil {
+			st.Status, st.Error = DependencyDown, err.Error()
+			if ctx.Err() != nil {
+				st.Status = DependencyTimeout
+			}
+		}
+	case <-ctx.Done():
+		st.Status, st.Error = DependencyTimeout, ctx.Err().Error()
+	}
+	st.LatencyMs = time.Since(start).Milliseconds()
+	return st
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveHealth(h *HealthHandler, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/healthz", h.Healthz)
+	router.GET("/readyz", h.Readyz)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestHealthHandler_Healthz(t *testing.T) {
+	h := NewHealthHandler(time.Second, HealthCheck{Name: "database", Check: func(context.Context) error {
+		return errors.New("connection refused")
+	}})
+
+	w := serveHealth(h, "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code, "liveness does not depend on the checks")
+}
+
+func TestHealthHandler_Readyz(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+	// hang ignores its context, as a storage client without a deadline would.
+	hang := func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		checks     []HealthCheck
+		wantStatus int
+		want       map[string]string
+	}{
+		{
+			name:       "all ok",
+			checks:     []HealthCheck{{Name: "database", Check: ok}, {Name: "storage", Check: ok}},
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"database": DependencyOK, "storage": DependencyOK},
+		},
+		{
+			name:       "database down",
+			checks:     []HealthCheck{{Name: "database", Check: down}, {Name: "storage", Check: ok}},
+			wantStatus: http.StatusServiceUnavailable,
+			want:       map[string]string{"database": DependencyDown, "storage": DependencyOK},
+		},
+		{
+			name:       "storage too slow",
+			checks:     []HealthCheck{{Name: "database", Check: ok}, {Name: "storage", Check: hang}},
+			wantStatus: http.StatusServiceUnavailable,
+			want:       map[string]string{"database": DependencyOK, "storage": DependencyTimeout},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHealthHandler(50*time.Millisecond, tc.checks...)
+
+			start := time.Now()
+			w := serveHealth(h, "/readyz")
+			assert.Less(t, time.Since(start), 500*time.Millisecond, "a slow check does not hold the probe")
+			assert.Equal(t, tc.wantStatus, w.Code)
+
+			var resp struct {
+				serializer.Response
+				Data ReadinessResp `json:"data"`
+			}
+			require.NoError(t, sonic.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tc.wantStatus == http.StatusOK, resp.Data.Ready)
+			got := map[string]string{}
+			for name, dep := range resp.Data.Dependencies {
+				got[name] = dep.Status
+				if dep.Status != DependencyOK {
+					assert.NotEmpty(t, dep.Error)
+				}
+			}
+			assert.Equal(t, tc.want, got)
+			if tc.wantStatus != http.StatusOK {
+				assert.Equal(t, "NOT_READY", resp.Msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+type MessageEmbeddingHandler struct {
+	svc service.MessageEmbeddingService
+}
+
+func NewMessageEmbeddingHandler(svc service.MessageEmbeddingService) *MessageEmbeddingHandler {
+	return &MessageEmbeddingHandler{svc: svc}
+}
+
+type UpsertEmbeddingReq struct {
+	Embedding []float32 `json:"embedding" binding:"required,min=1"`
+	Model     string    `json:"model" binding:"omitempty,max=200" example:"text-embedding-3-small"`
+}
+
+type SearchSimilarReq struct {
+	Vector    []float32 `json:"vector" binding:"required,min=1"`
+	Model     string    `json:"model" binding:"omitempty,max=200" example:"text-embedding-3-small"`
+	SessionID string    `json:"session_id" binding:"omitempty,uuid" format:"uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	TopK      int       `json:"top_k" binding:"omitempty,min=1,max=100" example:"10"`
+}
+
+type SearchSimilarResp struct {
+	Items []service.SimilarMessageResult `json:"items"`
+}
+
+type ReindexEmbeddingsReq struct {
+	SessionID string `json:"session_id" binding:"omitempty,uuid" format:"uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	Model     string `json:"model" binding:"omitempty,max=200" example:"text-embedding-3-small"`
+	Force     bool   `json:"force" example:"false"`
+}
+
+// writeEmbeddingErr maps vector search service errors to HTTP responses.
+func writeEmbeddingErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+	case errors.Is(err, service.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+	case errors.Is(err, service.ErrVectorUnsupported):
+		c.JSON(http.StatusNotImplemented, serializer.Err(http.StatusNotImplemented, "VECTOR_UNSUPPORTED", err))
+	case errors.Is(err, service.ErrEmbeddingDimMismatch):
+		c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "EMBEDDING_DIM_MISMATCH", err))
+	case errors.Is(err, service.ErrReindexNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "REINDEX_NOT_FOUND", err))
+	case errors.Is(err, service.ErrReindexRunning):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "REINDEX_RUNNING", err))
+	case errors.Is(err, service.ErrReindexEncrypted):
+		c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "REINDEX_ENCRYPTED", err))
+	case errors.Is(err, service.ErrEmbedderUnavailable):
+		c.JSON(http.StatusNotImplemented, serializer.Err(http.StatusNotImplemented, "EMBEDDER_UNAVAILABLE", err))
+	default:
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+	}
+}
+
+// UpsertEmbedding godoc
+//
+//	@Summary		Set message embedding
+//	@Description	Store or replace the embedding vector of a message. All embeddings of one model in a project must share one dimension. Requires the pgvector extension.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string						true	"Message ID"	format(uuid)
+//	@Param			payload		body	handler.UpsertEmbeddingReq	true	"UpsertEmbedding payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.MessageEmbedding}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or dimension mismatch"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		501	{object}	serializer.Response	"Vector search not supported"
+//	@Router			/session/{session_id}/messages/{message_id}/embedding [put]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Attach an embedding to a message\nclient.sessions.upsert_message_embedding(session_id, message_id, embedding=[0.12, -0.03, 0.88])\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Attach an embedding to a message\nawait client.sessions.upsertMessageEmbedding(sessionId, messageId, { embedding: [0.12, -0.03, 0.88] });\n","label":"JavaScript"}]
+func (h *MessageEmbeddingHandler) UpsertEmbedding(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	req := UpsertEmbeddingReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	e, err := h.svc.UpsertEmbedding(c.Request.Context(), service.UpsertEmbeddingInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		Model:     req.Model,
+		Embedding: req.Embedding,
+	})
+	if err != nil {
+		writeEmbeddingErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: e})
+}
+
+// SearchSimilar godoc
+//
+//	@Summary		Vector similarity search
+//	@Description	Find the messages whose embeddings of the given model are closest to the query vector by cosine distance, optionally scoped to one session. Requires the pgvector extension.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body	handler.SearchSimilarReq	true	"SearchSimilar payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.SearchSimilarResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or dimension mismatch"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		501	{object}	serializer.Response	"Vector search not supported"
+//	@Router			/session/search/similar [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Find similar messages\nresult = client.sessions.search_similar(vector=[0.12, -0.03, 0.88], top_k=5)\nfor hit in result.items:\n    print(hit.message_id, hit.distance)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Find similar messages\nconst result = await client.sessions.searchSimilar({ vector: [0.12, -0.03, 0.88], topK: 5 });\nfor (const hit of result.items) {\n  console.log(hit.message_id, hit.distance);\n}\n","label":"JavaScript"}]
+func (h *MessageEmbeddingHandler) SearchSimilar(c *gin.Context) {
+	req := SearchSimilarReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	if req.TopK == 0 {
+		req.TopK = 10
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	var sessionID *uuid.UUID
+	if req.SessionID != "" {
+		sid := uuid.MustParse(req.SessionID)
+		sessionID = &sid
+	}
+
+	items, err := h.svc.SearchSimilar(c.Request.Context(), service.SearchSimilarInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Model:     req.Model,
+		Vector:    req.Vector,
+		TopK:      req.TopK,
+	})
+	if err != nil {
+		writeEmbeddingErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: SearchSimilarResp{Items: items}})
+}
+
+// ReindexEmbeddings godoc
+//
+//	@Summary		Reindex message embeddings
+//	@Description	Start a background run that embeds the text of the project's messages, or of one session's, with an embedding model. Messages already embedded with the model are skipped unless force is set. A project runs one reindex at a time. Requires the pgvector extension and a configured embedding provider.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body	handler.ReindexEmbeddingsReq	true	"ReindexEmbeddings payload"
+//	@Security		BearerAuth
+//	@Success		202	{object}	serializer.Response{data=model.EmbeddingReindex}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or encrypted project"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"A reindex is already running"
+//	@Failure		501	{object}	serializer.Response	"Vector search or embedding provider not available"
+//	@Router			/session/embeddings/reindex [post]
+func (h *MessageEmbeddingHandler) ReindexEmbeddings(c *gin.Context) {
+	req := ReindexEmbeddingsReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	var sessionID *uuid.UUID
+	if req.SessionID != "" {
+		sid := uuid.MustParse(req.SessionID)
+		sessionID = &sid
+	}
+
+	ri, err := h.svc.ReindexEmbeddings(c.Request.Context(), service.ReindexEmbeddingsInput{
+		Project:   project,
+		SessionID: sessionID,
+		Model:     req.Model,
+		Force:     req.Force,
+	})
+	if err != nil {
+		writeEmbeddingErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, serializer.Response{Data: ri})
+}
+
+// GetEmbeddingReindex godoc
+//
+//	@Summary		Get embedding reindex
+//	@Description	Get the status and progress of an embedding reindex.
+//	@Tags			session
+//	@Produce		json
+//	@Param			reindex_id	path	string	true	"Reindex ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.EmbeddingReindex}
+//	@Failure		400	{object}	serializer.Response	"Invalid reindex_id"
+//	@Failure		404	{object}	serializer.Response	"Reindex not found"
+//	@Router			/session/embeddings/reindex/{reindex_id} [get]
+func (h *MessageEmbeddingHandler) GetEmbeddingReindex(c *gin.Context) {
+	reindexID, err := uuid.Parse(c.Param("reindex_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid reindex_id", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	ri, err := h.svc.GetEmbeddingReindex(c.Request.Context(), project.ID, reindexID)
+	if err != nil {
+		writeEmbeddingErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: ri})
+}
@@ -0,0 +1,297 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockMessageEmbeddingService struct {
+	mock.Mock
+}
+
+func (m *MockMessageEmbeddingService) UpsertEmbedding(ctx context.Context, in service.UpsertEmbeddingInput) (*model.MessageEmbedding, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.MessageEmbedding), args.Error(1)
+}
+
+func (m *MockMessageEmbeddingService) SearchSimilar(ctx context.Context, in service.SearchSimilarInput) ([]service.SimilarMessageResult, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]service.SimilarMessageResult), args.Error(1)
+}
+
+func (m *MockMessageEmbeddingService) ReindexEmbeddings(ctx context.Context, in service.ReindexEmbeddingsInput) (*model.EmbeddingReindex, error) {
+	args := m.Called(ctx, in)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.EmbeddingReindex), args.Error(1)
+}
+
+func (m *MockMessageEmbeddingService) GetEmbeddingReindex(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.EmbeddingReindex, error) {
+	args := m.Called(ctx, projectID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.EmbeddingReindex), args.Error(1)
+}
+
+func (m *MockMessageEmbeddingService) RunReindexJob(ctx context.Context, payload json.RawMessage) error {
+	return m.Called(ctx, payload).Error(0)
+}
+
+func TestMessageEmbeddingHandler_UpsertEmbedding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	messageID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		setup          func(*MockMessageEmbeddingService)
+		expectedStatus int
+	}{
+		{
+			name: "success",
+			body: `{"embedding":[0.1,0.2,0.3]}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("UpsertEmbedding", mock.Anything, service.UpsertEmbeddingInput{
+					ProjectID: projectID,
+					SessionID: sessionID,
+					MessageID: messageID,
+					Embedding: []float32{0.1, 0.2, 0.3},
+				}).Return(&model.MessageEmbedding{MessageID: messageID, Dim: 3}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "empty embedding",
+			body:           `{"embedding":[]}`,
+			setup:          func(svc *MockMessageEmbeddingService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "dimension mismatch",
+			body: `{"embedding":[0.1]}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("UpsertEmbedding", mock.Anything, mock.Anything).Return(nil, service.ErrEmbeddingDimMismatch)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "message not found",
+			body: `{"embedding":[0.1]}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("UpsertEmbedding", mock.Anything, mock.Anything).Return(nil, service.ErrMessageNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "pgvector unavailable",
+			body: `{"embedding":[0.1]}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("UpsertEmbedding", mock.Anything, mock.Anything).Return(nil, service.ErrVectorUnsupported)
+			},
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockMessageEmbeddingService)
+			tt.setup(mockService)
+			handler := NewMessageEmbeddingHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", &model.Project{ID: projectID})
+			c.Params = gin.Params{
+				{Key: "session_id", Value: sessionID.String()},
+				{Key: "message_id", Value: messageID.String()},
+			}
+			c.Request, _ = http.NewRequest("PUT", "/session/"+sessionID.String()+"/messages/"+messageID.String()+"/embedding", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.UpsertEmbedding(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMessageEmbeddingHandler_SearchSimilar(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		setup          func(*MockMessageEmbeddingService)
+		expectedStatus int
+	}{
+		{
+			name: "default top_k",
+			body: `{"vector":[1,0]}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("SearchSimilar", mock.Anything, service.SearchSimilarInput{
+					ProjectID: projectID,
+					Vector:    []float32{1, 0},
+					TopK:      10,
+				}).Return([]service.SimilarMessageResult{{MessageID: uuid.New(), Distance: 0.1}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "scoped to session",
+			body: `{"vector":[1,0],"session_id":"` + sessionID.String() + `","top_k":3}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("SearchSimilar", mock.Anything, service.SearchSimilarInput{
+					ProjectID: projectID,
+					SessionID: &sessionID,
+					Vector:    []float32{1, 0},
+					TopK:      3,
+				}).Return([]service.SimilarMessageResult{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid session id",
+			body:           `{"vector":[1,0],"session_id":"nope"}`,
+			setup:          func(svc *MockMessageEmbeddingService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "top_k too large",
+			body:           `{"vector":[1,0],"top_k":1000}`,
+			setup:          func(svc *MockMessageEmbeddingService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "pgvector unavailable",
+			body: `{"vector":[1,0]}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("SearchSimilar", mock.Anything, mock.Anything).Return(nil, service.ErrVectorUnsupported)
+			},
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockMessageEmbeddingService)
+			tt.setup(mockService)
+			handler := NewMessageEmbeddingHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", &model.Project{ID: projectID})
+			c.Request, _ = http.NewRequest("POST", "/session/search/similar", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.SearchSimilar(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMessageEmbeddingHandler_ReindexEmbeddings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	project := &model.Project{ID: uuid.New()}
+	sessionID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		setup          func(*MockMessageEmbeddingService)
+		expectedStatus int
+	}{
+		{
+			name: "whole project",
+			body: `{"model":"text-embedding-3-small"}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("ReindexEmbeddings", mock.Anything, service.ReindexEmbeddingsInput{
+					Project: project,
+					Model:   "text-embedding-3-small",
+				}).Return(&model.EmbeddingReindex{ID: uuid.New(), Status: model.EmbeddingReindexRunning}, nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name: "forced session",
+			body: `{"session_id":"` + sessionID.String() + `","force":true}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("ReindexEmbeddings", mock.Anything, service.ReindexEmbeddingsInput{
+					Project:   project,
+					SessionID: &sessionID,
+					Force:     true,
+				}).Return(&model.EmbeddingReindex{ID: uuid.New()}, nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "invalid session id",
+			body:           `{"session_id":"nope"}`,
+			setup:          func(svc *MockMessageEmbeddingService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "already running",
+			body: `{}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("ReindexEmbeddings", mock.Anything, mock.Anything).Return(nil, service.ErrReindexRunning)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "no embedder",
+			body: `{}`,
+			setup: func(svc *MockMessageEmbeddingService) {
+				svc.On("ReindexEmbeddings", mock.Anything, mock.Anything).Return(nil, service.ErrEmbedderUnavailable)
+			},
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockMessageEmbeddingService)
+			tt.setup(mockService)
+			handler := NewMessageEmbeddingHandler(mockService)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("project", project)
+			c.Request, _ = http.NewRequest("POST", "/session/embeddings/reindex", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.ReindexEmbeddings(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
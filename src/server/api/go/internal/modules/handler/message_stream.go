@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/serializer"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+// defaultStreamKeepAlive is how often an idle stream sends an SSE comment so
+// proxies and clients do not time the connection out.
+const defaultStreamKeepAlive = 15 * time.Second
+
+type MessageStreamHandler struct {
+	svc       service.MessageStreamService
+	keepAlive time.Duration
+}
+
+func NewMessageStreamHandler(svc service.MessageStreamService) *MessageStreamHandler {
+	return &MessageStreamHandler{svc: svc, keepAlive: defaultStreamKeepAlive}
+}
+
+// StreamSession godoc
+//
+//	@Summary		Stream session messages
+//	@Description	Open a server-sent events stream of the session's message activity. Emits `message.created` when a message is stored or a streaming message starts, `message.part.appended` with each streamed text delta, `message.finalized` when a streaming message completes, `message.deleted` when a message is deleted, and `message.reparented` with the new `parent_id` when a deletion moves a message to another parent. A stream the server ends, for instance because the client reads too slowly, closes with `stream.resync`: events may have been missed, so refetch the messages and reconnect. Event data is JSON; idle streams receive a keep-alive comment every 15 seconds.
+//	@Tags			session
+//	@Produce		text/event-stream
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	model.MessageStreamEvent	"Stream of events"
+//	@Failure		400	{object}	serializer.Response			"Invalid session_id"
+//	@Failure		404	{object}	serializer.Response			"Session not found"
+//	@Router			/session/{session_id}/stream [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Follow new messages as they arrive\nfor event in client.sessions.stream(session_id):\n    print(event.type, event.message_id)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Follow new messages as they arrive\nfor await (const event of client.sessions.stream(sessionId)) {\n  console.log(event.type, event.message_id);\n}\n","label":"JavaScript"}]
+func (h *MessageStreamHandler) StreamSession(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	ctx := c.Request.Context()
+	events, unsubscribe, err := h.svc.Subscribe(ctx, project.ID, sessionID)
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+	defer unsubscribe()
+
+	// The stream outlives the server's write timeout; clear the deadline for this response.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(h.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockMessageStreamService struct {
+	mock.Mock
+}
+
+func (m *MockMessageStreamService) Subscribe(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (<-chan model.MessageStreamEvent, func(), error) {
+	args := m.Called(ctx, projectID, sessionID)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(<-chan model.MessageStreamEvent), args.Get(1).(func()), args.Error(2)
+}
+
+func newStreamRouter(h *MessageStreamHandler, projectID uuid.UUID) *gin.Engine {
+	r := gin.New()
+	r.GET("/session/:session_id/stream", func(c *gin.Context) {
+		c.Set("project", &model.Project{ID: projectID})
+		h.StreamSession(c)
+	})
+	return r
+}
+
+func TestMessageStreamHandler_StreamSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	t.Run("writes events and closes on end of stream", func(t *testing.T) {
+		events := make(chan model.MessageStreamEvent, 2)
+		events <- model.MessageStreamEvent{Type: model.StreamEventMessageCreated, SessionID: sessionID, MessageID: uuid.New()}
+		events <- model.MessageStreamEvent{Type: model.StreamEventMessagePartAppended, SessionID: sessionID, Delta: "hi"}
+		close(events)
+
+		unsubscribed := false
+		svc := &MockMessageStreamService{}
+		svc.On("Subscribe", mock.Anything, projectID, sessionID).
+			Return((<-chan model.MessageStreamEvent)(events), func() { unsubscribed = true }, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/session/"+sessionID.String()+"/stream", nil)
+		newStreamRouter(NewMessageStreamHandler(svc), projectID).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+		body := w.Body.String()
+		assert.Contains(t, body, "event: message.created\ndata: {")
+		assert.Contains(t, body, "event: message.part.appended\ndata: {")
+		assert.Contains(t, body, `"delta":"hi"`)
+		assert.True(t, unsubscribed)
+	})
+
+	t.Run("sends keep-alive and stops on client disconnect", func(t *testing.T) {
+		events := make(chan model.MessageStreamEvent)
+		unsubscribed := make(chan struct{})
+		svc := &MockMessageStreamService{}
+		svc.On("Subscribe", mock.Anything, projectID, sessionID).
+			Return((<-chan model.MessageStreamEvent)(events), func() { close(unsubscribed) }, nil)
+
+		h := NewMessageStreamHandler(svc)
+		h.keepAlive = 10 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/session/"+sessionID.String()+"/stream", nil).WithContext(ctx)
+
+		done := make(chan struct{})
+		go func() {
+			newStreamRouter(h, projectID).ServeHTTP(w, req)
+			close(done)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("handler did not return after disconnect")
+		}
+		<-unsubscribed
+		assert.True(t, strings.Contains(w.Body.String(), ": keep-alive\n\n"))
+	})
+
+	t.Run("session not found", func(t *testing.T) {
+		svc := &MockMessageStreamService{}
+		svc.On("Subscribe", mock.Anything, projectID, sessionID).Return(nil, nil, service.ErrSessionNotFound)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/session/"+sessionID.String()+"/stream", nil)
+		newStreamRouter(NewMessageStreamHandler(svc), projectID).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("invalid session id", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/session/nope/stream", nil)
+		newStreamRouter(NewMessageStreamHandler(&MockMessageStreamService{}), projectID).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
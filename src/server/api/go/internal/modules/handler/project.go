@@ -1,15 +1,20 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/memodb-io/Acontext/internal/infra/blob"
+	"github.com/memodb-io/Acontext/internal/middleware"
 	"github.com/memodb-io/Acontext/internal/modules/model"
 	"github.com/memodb-io/Acontext/internal/modules/repo"
 	"github.com/memodb-io/Acontext/internal/modules/serializer"
+	"github.com/memodb-io/Acontext/internal/modules/service"
 	"gorm.io/gorm"
 )
 
@@ -18,10 +23,11 @@ type ProjectHandler struct {
 	rdb          *redis.Client
 	s3           *blob.S3Deps
 	assetRefRepo repo.AssetReferenceRepo
+	keySvc       service.ProjectKeyService
 }
 
-func NewProjectHandler(db *gorm.DB, rdb *redis.Client, s3 *blob.S3Deps, assetRefRepo repo.AssetReferenceRepo) *ProjectHandler {
-	return &ProjectHandler{db: db, rdb: rdb, s3: s3, assetRefRepo: assetRefRepo}
+func NewProjectHandler(db *gorm.DB, rdb *redis.Client, s3 *blob.S3Deps, assetRefRepo repo.AssetReferenceRepo, keySvc service.ProjectKeyService) *ProjectHandler {
+	return &ProjectHandler{db: db, rdb: rdb, s3: s3, assetRefRepo: assetRefRepo, keySvc: keySvc}
 }
 
 // GetConfigs godoc
@@ -91,19 +97,30 @@ func (h *ProjectHandler) PatchConfigs(c *gin.Context) {
 	}
 
 	const maxStringFieldLen = 2000
-	stringFields := map[string]bool{"task_success_criteria": true, "task_failure_criteria": true}
+	stringFields := map[string]int{
+		"task_success_criteria": maxStringFieldLen,
+		"task_failure_criteria": maxStringFieldLen,
+		"default_system_prompt": service.MaxSystemPromptLen,
+	}
+	numberFields := map[string]bool{"message_rate_burst": true, "message_rate_per_sec": true}
 	for key, value := range patch {
 		if value == nil {
 			continue
 		}
-		if stringFields[key] {
+		if numberFields[key] {
+			if n, ok := value.(float64); !ok || n < 0 {
+				c.JSON(http.StatusBadRequest, serializer.ParamErr(key+" must be a non-negative number", nil))
+				return
+			}
+		}
+		if maxLen, ok := stringFields[key]; ok {
 			str, ok := value.(string)
 			if !ok {
 				c.JSON(http.StatusBadRequest, serializer.ParamErr(key+" must be a string", nil))
 				return
 			}
-			if len(str) > maxStringFieldLen {
-				c.JSON(http.StatusBadRequest, serializer.ParamErr(key+" exceeds max length (2000 chars)", nil))
+			if len(str) > maxLen {
+				c.JSON(http.StatusBadRequest, serializer.ParamErr(fmt.Sprintf("%s exceeds max length (%d chars)", key, maxLen), nil))
 				return
 			}
 		}
@@ -147,6 +164,8 @@ func (h *ProjectHandler) PatchConfigs(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
 		return
 	}
+	// The auth cache carries the configs enforced per request, such as rate limits.
+	middleware.InvalidateProjectAuthCache(h.rdb, freshProject.ID, freshProject.SecretKeyHMAC)
 
 	c.JSON(http.StatusOK, serializer.Response{
 		Code: 0,
@@ -186,3 +205,122 @@ func (h *ProjectHandler) EncryptProject(c *gin.Context) {
 func (h *ProjectHandler) DecryptProject(c *gin.Context) {
 	decryptProject(c, h.db, h.rdb, h.s3, h.assetRefRepo)
 }
+
+type CreateProjectKeyReq struct {
+	Name string `json:"name" example:"dashboard"`
+	// Scopes are the scopes the key holds; without messages:read_sensitive it reads parts whose
+	// meta has sensitive: true as a [redacted] placeholder.
+	Scopes []string `json:"scopes" example:"messages:read_sensitive"`
+}
+
+// CreateProjectKey godoc
+//
+//	@Summary		Create a project key
+//	@Description	Creates an API key of the project that holds only the given scopes. Without messages:read_sensitive, every read made with it gets parts whose meta has sensitive: true replaced by a [redacted] placeholder. The key authenticates like the secret key, except on the endpoints that manage the project, which require the secret key. Its token is only returned here.
+//	@Tags			Project
+//	@Accept			json
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			body	body		handler.CreateProjectKeyReq	true	"Key name and scopes"
+//	@Success		201		{object}	serializer.Response{data=service.CreateProjectKeyOutput}
+//	@Failure		400		{object}	serializer.Response	"Unknown scope, name too long, or an encrypted project with a legacy secret key"
+//	@Failure		403		{object}	serializer.Response	"Not the project secret key"
+//	@Router			/project/keys [post]
+func (h *ProjectHandler) CreateProjectKey(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "failed to load project", nil))
+		return
+	}
+	var req CreateProjectKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	// The key wraps the master key carried by the caller's compact token. A legacy token has none,
+	// and a key issued without it could not read the data of an encrypted project.
+	masterKey := middleware.GetUserKEK(c)
+	if project.EncryptionEnabled && masterKey == nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr(
+			"project has encryption enabled but the current API key has no embedded master key; re-issue a v2 key first", nil))
+		return
+	}
+
+	out, err := h.keySvc.Create(c.Request.Context(), service.CreateProjectKeyInput{
+		ProjectID: project.ID,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		MasterKey: masterKey,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidScope) || errors.Is(err, service.ErrInvalidKeyName) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr(err.Error(), err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+}
+
+// ListProjectKeys godoc
+//
+//	@Summary		List project keys
+//	@Description	Lists the API keys of the project other than its secret key, oldest first. Their tokens are not returned.
+//	@Tags			Project
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=[]model.ProjectKey}
+//	@Failure		403	{object}	serializer.Response	"Not the project secret key"
+//	@Router			/project/keys [get]
+func (h *ProjectHandler) ListProjectKeys(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "failed to load project", nil))
+		return
+	}
+	keys, err := h.keySvc.List(c.Request.Context(), project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+	c.JSON(http.StatusOK, serializer.Response{Data: keys})
+}
+
+// DeleteProjectKey godoc
+//
+//	@Summary		Revoke a project key
+//	@Description	Deletes an API key of the project; requests made with it are rejected right away.
+//	@Tags			Project
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			key_id	path		string	true	"Project key ID"	format(uuid)
+//	@Success		200		{object}	serializer.Response
+//	@Failure		403		{object}	serializer.Response	"Not the project secret key"
+//	@Failure		404		{object}	serializer.Response	"Key not found"
+//	@Router			/project/keys/{key_id} [delete]
+func (h *ProjectHandler) DeleteProjectKey(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "failed to load project", nil))
+		return
+	}
+	keyID, err := uuid.Parse(c.Param("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid key id", err))
+		return
+	}
+	key, err := h.keySvc.Delete(c.Request.Context(), project.ID, keyID)
+	if err != nil {
+		if errors.Is(err, service.ErrProjectKeyNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "PROJECT_KEY_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+	// Drop the cached auth entry of the key so it stops authenticating now rather than on expiry.
+	middleware.InvalidateProjectAuthCache(h.rdb, project.ID, key.SecretKeyHMAC)
+	c.JSON(http.StatusOK, serializer.Response{Msg: "ok"})
+}
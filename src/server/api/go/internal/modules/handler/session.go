@@ -4,14 +4,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/infra/db"
 	"github.com/memodb-io/Acontext/internal/infra/httpclient"
 	"github.com/memodb-io/Acontext/internal/middleware"
 	"github.com/memodb-io/Acontext/internal/modules/model"
@@ -20,14 +24,31 @@ import (
 	"github.com/memodb-io/Acontext/internal/modules/service"
 	"github.com/memodb-io/Acontext/internal/pkg/converter"
 	"github.com/memodb-io/Acontext/internal/pkg/editor"
+	"github.com/memodb-io/Acontext/internal/pkg/hook"
+	"github.com/memodb-io/Acontext/internal/pkg/jsonpatch"
 	"github.com/memodb-io/Acontext/internal/pkg/normalizer"
+	"github.com/memodb-io/Acontext/internal/pkg/paging"
 	"github.com/memodb-io/Acontext/internal/pkg/tokenizer"
+	"github.com/memodb-io/Acontext/internal/pkg/toolschema"
 	"gorm.io/datatypes"
 )
 
 // MaxMetaSize is the maximum allowed size for user-provided message metadata (64KB)
 const MaxMetaSize = 64 * 1024
 
+// IdempotencyKeyHeader carries the client key that deduplicates retried message stores.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// SessionLockHolderHeader names the holder of the session lock a message store requires; stores
+// without it ignore the lock.
+const SessionLockHolderHeader = "X-Session-Lock-Holder"
+
+// MaxIdempotencyKeyLength is the longest accepted IdempotencyKeyHeader value.
+const MaxIdempotencyKeyLength = 255
+
+// IfMatchHeader carries the message version a parts edit is based on, as an entity tag.
+const IfMatchHeader = "If-Match"
+
 // MaxCopyableMessages aliases repo.MaxCopyableMessages for handler-layer use.
 var MaxCopyableMessages = repo.MaxCopyableMessages
 
@@ -49,29 +70,71 @@ type CreateSessionReq struct {
 	User                string                 `form:"user" json:"user" example:"alice@acontext.io"`
 	DisableTaskTracking *bool                  `form:"disable_task_tracking" json:"disable_task_tracking" example:"false"`
 	Configs             map[string]interface{} `form:"configs" json:"configs"`
+	Metadata            map[string]interface{} `form:"metadata" json:"metadata"`
+	Tags                []string               `form:"tags" json:"tags" example:"research"`
 	UseUUID             *string                `form:"use_uuid" json:"use_uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	IsTemplate          bool                   `form:"is_template" json:"is_template" example:"false"`
+	// AllowedPartTypes restricts the part types the session's messages may carry; empty allows all.
+	AllowedPartTypes []string `form:"allowed_part_types" json:"allowed_part_types" example:"text"`
 }
 
 type GetSessionsReq struct {
 	User            string `form:"user" json:"user" example:"alice@acontext.io"`
 	Limit           int    `form:"limit,default=20" json:"limit" binding:"required,min=1,max=200" example:"20"`
 	Cursor          string `form:"cursor" json:"cursor" example:"cHJvdGVjdGVkIHZlcnNpb24gdG8gYmUgZXhjbHVkZWQgaW4gcGFyc2luZyB0aGUgY3Vyc29y"`
-	TimeDesc        bool   `form:"time_desc,default=false" json:"time_desc" example:"false"`
+	TimeDesc        *bool  `form:"time_desc" json:"time_desc" example:"true"`
 	FilterByConfigs string `form:"filter_by_configs" json:"filter_by_configs"` // JSON-encoded string for JSONB containment filter
+	// FilterByMetadata is a JSON-encoded object the session metadata must contain
+	FilterByMetadata string   `form:"filter_by_metadata" json:"filter_by_metadata"`
+	Tag              []string `form:"tag" json:"tag" example:"research"`
+	IncludeTemplates bool     `form:"include_templates,default=false" json:"include_templates" example:"false"`
+	IncludeArchived  bool     `form:"include_archived,default=false" json:"include_archived" example:"false"`
+	Since            string   `form:"since" json:"since" example:"2025-01-01T00:00:00Z"`
+	Until            string   `form:"until" json:"until" example:"2025-02-01T00:00:00Z"`
+	// SortBy is last_message_at (default), the time of the newest message, or created_at. An unset
+	// TimeDesc sorts last_message_at descending, most recently active first, and created_at ascending.
+	SortBy string `form:"sort_by,default=last_message_at" json:"sort_by" binding:"oneof=last_message_at created_at" example:"last_message_at"`
+}
+
+// parseCreatedRange parses the RFC3339 since and until query values, either of which may be empty.
+func parseCreatedRange(since, until string) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if since != "" {
+		if from, err = time.Parse(time.RFC3339, since); err != nil {
+			return from, to, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if until != "" {
+		if to, err = time.Parse(time.RFC3339, until); err != nil {
+			return from, to, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return from, to, errors.New("since must not be after until")
+	}
+	return from, to, nil
 }
 
 // GetSessions godoc
 //
 //	@Summary		Get sessions
-//	@Description	Get all sessions under a project, optionally filtered by user or configs
+//	@Description	Get all sessions under a project, optionally filtered by user, configs, metadata or tags. By default the most recently active session comes first; cursors are only valid with the sort_by they were returned for.
 //	@Tags			session
 //	@Accept			json
 //	@Produce		json
 //	@Param			user				query	string	false	"User identifier to filter sessions"	example(alice@acontext.io)
 //	@Param			filter_by_configs	query	string	false	"JSON-encoded object for JSONB containment filter. Example: {\"agent\":\"bot1\"}"
+//	@Param			filter_by_metadata	query	string	false	"JSON-encoded object the session metadata must contain. Example: {\"team\":\"search\"}"
+//	@Param			tag					query	[]string	false	"Only sessions carrying this tag; repeat to require several"	collectionFormat(multi)
+//	@Param			include_templates	query	boolean	false	"Also list template sessions, which are hidden by default"	example(false)
+//	@Param			include_archived	query	boolean	false	"Also list archived sessions, which are hidden by default"	example(false)
+//	@Param			since				query	string	false	"Only sessions created at or after this RFC3339 time"	example(2025-01-01T00:00:00Z)
+//	@Param			until				query	string	false	"Only sessions created at or before this RFC3339 time"	example(2025-02-01T00:00:00Z)
 //	@Param			limit				query	integer	false	"Limit of sessions to return, default 20. Max 200."
 //	@Param			cursor				query	string	false	"Cursor for pagination. Use the cursor from the previous response to get the next page."
-//	@Param			time_desc			query	boolean	false	"Order by created_at descending if true, ascending if false (default false)"	example(false)
+//	@Param			sort_by				query	string	false	"Sort by last_message_at, the time of the newest message (default), or created_at"	Enums(last_message_at, created_at)
+//	@Param			time_desc			query	boolean	false	"Sort descending if true, ascending if false (default true for last_message_at, false for created_at)"	example(true)
 //	@Security		BearerAuth
 //	@Success		200	{object}	serializer.Response{data=service.ListSessionsOutput}
 //	@Router			/session [get]
@@ -102,15 +165,55 @@ func (h *SessionHandler) GetSessions(c *gin.Context) {
 		}
 	}
 
+	var filterByMetadata map[string]interface{}
+	if req.FilterByMetadata != "" {
+		if err := json.Unmarshal([]byte(req.FilterByMetadata), &filterByMetadata); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid filter_by_metadata JSON", err))
+			return
+		}
+		if len(filterByMetadata) == 0 {
+			filterByMetadata = nil
+		}
+	}
+	tags, err := service.NormalizeTags(req.Tag)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid tag", err))
+		return
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	since, until, err := parseCreatedRange(req.Since, req.Until)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	orderBy := repo.SessionOrder(req.SortBy)
+	timeDesc := orderBy == repo.SessionOrderLastMessageAt
+	if req.TimeDesc != nil {
+		timeDesc = *req.TimeDesc
+	}
+
 	out, err := h.svc.List(c.Request.Context(), service.ListSessionsInput{
-		ProjectID:       project.ID,
-		User:            req.User,
-		FilterByConfigs: filterByConfigs,
-		Limit:           req.Limit,
-		Cursor:          req.Cursor,
-		TimeDesc:        req.TimeDesc,
+		ProjectID:        project.ID,
+		User:             req.User,
+		FilterByConfigs:  filterByConfigs,
+		FilterByMetadata: filterByMetadata,
+		Tags:             tags,
+		IncludeTemplates: req.IncludeTemplates,
+		IncludeArchived:  req.IncludeArchived,
+		Limit:            req.Limit,
+		Cursor:           req.Cursor,
+		TimeDesc:         timeDesc,
+		OrderBy:          orderBy,
+		Since:            since,
+		Until:            until,
 	})
 	if err != nil {
+		if errors.Is(err, paging.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid cursor", err))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
 		return
 	}
@@ -121,7 +224,7 @@ func (h *SessionHandler) GetSessions(c *gin.Context) {
 // CreateSession godoc
 //
 //	@Summary		Create session
-//	@Description	Create a new session. Optionally associate with a user identifier. You can also specify a custom UUID using use_uuid.
+//	@Description	Create a new session. Optionally associate with a user identifier. You can also specify a custom UUID using use_uuid. allowed_part_types restricts the part types its messages may carry; messages with other parts are rejected with 422.
 //	@Tags			session
 //	@Accept			json
 //	@Produce		json
@@ -145,10 +248,25 @@ func (h *SessionHandler) CreateSession(c *gin.Context) {
 		return
 	}
 
+	tags, err := service.NormalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid tags", err))
+		return
+	}
+	allowedPartTypes, err := service.NormalizeAllowedPartTypes(req.AllowedPartTypes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid allowed_part_types", err))
+		return
+	}
+
 	session := model.Session{
 		ProjectID:           project.ID,
 		DisableTaskTracking: false, // Default value
 		Configs:             datatypes.JSONMap(req.Configs),
+		Metadata:            datatypes.JSONMap(req.Metadata),
+		Tags:                model.StringArray(tags),
+		IsTemplate:          req.IsTemplate,
+		AllowedPartTypes:    datatypes.JSONSlice[string](allowedPartTypes),
 	}
 
 	// If use_uuid is provided, validate and set the session ID
@@ -200,19 +318,32 @@ func (h *SessionHandler) CreateSession(c *gin.Context) {
 	c.JSON(http.StatusCreated, serializer.Response{Data: session})
 }
 
+type DeleteSessionReq struct {
+	Cascade bool `form:"cascade,default=false" json:"cascade" example:"false"`
+}
+
 // DeleteSession godoc
 //
 //	@Summary		Delete session
-//	@Description	Delete a session by id
+//	@Description	Delete a session by id. By default the session is soft-deleted and purged after the retention window. With cascade=true it is removed right away together with its messages, and the storage of assets no other message or artifact references is freed; the response reports the counts.
 //	@Tags			session
 //	@Accept			json
 //	@Produce		json
 //	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			cascade		query	boolean	false	"Hard-delete the session, its messages and its unreferenced assets immediately"	example(false)
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
 //	@Security		BearerAuth
-//	@Success		200	{object}	serializer.Response{}
+//	@Success		200	{object}	serializer.Response{data=service.DeleteSessionCascadeOutput}
+//	@Failure		409	{object}	serializer.Response	"Session is finalized or holds messages past the immutability window"
 //	@Router			/session/{session_id} [delete]
 //	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Delete a session\nclient.sessions.delete(session_id='session-uuid')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Delete a session\nawait client.sessions.delete('session-uuid');\n","label":"JavaScript"}]
 func (h *SessionHandler) DeleteSession(c *gin.Context) {
+	req := DeleteSessionReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
 	sessionID, err := uuid.Parse(c.Param("session_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
@@ -235,7 +366,36 @@ func (h *SessionHandler) DeleteSession(c *gin.Context) {
 		return
 	}
 
-	if err := h.svc.Delete(c.Request.Context(), project.ID, sessionID, middleware.GetUserKEKIfEncrypted(c)); err != nil {
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	if req.Cascade {
+		out, err := h.svc.DeleteSessionCascade(c.Request.Context(), project.ID, sessionID, middleware.GetUserKEKIfEncrypted(c), override)
+		if err != nil {
+			if writeImmutable(c, err) {
+				return
+			}
+			if errors.Is(err, service.ErrSessionNotFound) {
+				c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "session not found", nil))
+				return
+			}
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+			return
+		}
+		c.JSON(http.StatusOK, serializer.Response{Data: out})
+		return
+	}
+
+	if err := h.svc.Delete(c.Request.Context(), project.ID, sessionID, middleware.GetUserKEKIfEncrypted(c), override); err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "session not found", nil))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
 		return
 	}
@@ -342,17 +502,180 @@ type StoreMessageReq struct {
 	Blob   interface{}            `form:"blob" json:"blob" binding:"required"`
 	Format string                 `form:"format" json:"format" binding:"omitempty,oneof=acontext openai anthropic gemini" example:"openai" enums:"acontext,openai,anthropic,gemini"`
 	Meta   map[string]interface{} `form:"meta" json:"meta"` // Optional user-provided metadata for the message
+	// Optional tiktoken encoding used for the stored token count (default o200k_base)
+	Tokenizer string `form:"tokenizer" json:"tokenizer" example:"cl100k_base"`
+	// Optional author of the message, e.g. the agent that generated it. Without one, user messages
+	// are attributed to the session's user and system messages to the system.
+	AuthorID   string `form:"author_id" json:"author_id" format:"uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	AuthorType string `form:"author_type" json:"author_type" example:"agent" enums:"user,agent,system"`
+}
+
+type InvalidPartsResp struct {
+	Parts []model.PartError `json:"parts"`
+}
+
+// writeInvalidParts responds with 422 and every invalid part when err is a *model.InvalidPartsError.
+func writeInvalidParts(c *gin.Context, err error) bool {
+	var invalid *model.InvalidPartsError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	resp := serializer.Err(http.StatusUnprocessableEntity, "INVALID_PARTS", err)
+	resp.Data = InvalidPartsResp{Parts: invalid.Parts}
+	c.JSON(http.StatusUnprocessableEntity, resp)
+	return true
+}
+
+type InvalidToolArgumentsResp struct {
+	Calls []toolschema.CallError `json:"calls"`
+}
+
+// writeInvalidToolArguments responds with 422 and the schema violations of every tool call when
+// err is a *toolschema.ArgumentsError.
+func writeInvalidToolArguments(c *gin.Context, err error) bool {
+	var invalid *toolschema.ArgumentsError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	resp := serializer.Err(http.StatusUnprocessableEntity, "INVALID_TOOL_ARGUMENTS", err)
+	resp.Data = InvalidToolArgumentsResp{Calls: invalid.Calls}
+	c.JSON(http.StatusUnprocessableEntity, resp)
+	return true
+}
+
+type MessageRejectedResp struct {
+	Reason string `json:"reason" example:"message contains blocked content"`
+}
+
+// writeMessageRejected responds with 422 and the hook's error when a message hook rejected
+// the message.
+func writeMessageRejected(c *gin.Context, err error) bool {
+	var rejected *hook.RejectedError
+	if !errors.As(err, &rejected) {
+		return false
+	}
+	resp := serializer.Err(http.StatusUnprocessableEntity, "MESSAGE_REJECTED", err)
+	resp.Data = MessageRejectedResp{Reason: rejected.Err.Error()}
+	c.JSON(http.StatusUnprocessableEntity, resp)
+	return true
+}
+
+// writeQuotaExceeded responds with 413, the current usage and the limit when err is a
+// *service.QuotaExceededError.
+func writeQuotaExceeded(c *gin.Context, err error) bool {
+	var quota *service.QuotaExceededError
+	if !errors.As(err, &quota) {
+		return false
+	}
+	resp := serializer.Err(http.StatusRequestEntityTooLarge, "QUOTA_EXCEEDED", err)
+	resp.Data = quota
+	c.JSON(http.StatusRequestEntityTooLarge, resp)
+	return true
+}
+
+// writeMessageTooLarge responds with 413, the offending limit and the message's size when err
+// is a *service.MessageLimitError.
+func writeMessageTooLarge(c *gin.Context, err error) bool {
+	var limit *service.MessageLimitError
+	if !errors.As(err, &limit) {
+		return false
+	}
+	resp := serializer.Err(http.StatusRequestEntityTooLarge, "MESSAGE_TOO_LARGE", err)
+	resp.Data = limit
+	c.JSON(http.StatusRequestEntityTooLarge, resp)
+	return true
+}
+
+// writeMIMEError responds with 400 when a part declares an invalid media type and with 422 when
+// the declared type disagrees with the type detected from the part's content.
+func writeMIMEError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrInvalidMIME):
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+	case errors.Is(err, service.ErrMIMEMismatch):
+		c.JSON(http.StatusUnprocessableEntity, serializer.Err(http.StatusUnprocessableEntity, "MIME_MISMATCH", err))
+	default:
+		return false
+	}
+	return true
+}
+
+// dbUnavailableRetryAfter is the Retry-After, in seconds, sent with DB_UNAVAILABLE.
+const dbUnavailableRetryAfter = "5"
+
+// writeDBUnavailable responds with 503 when err means the database could not be reached, so
+// clients retry the write instead of treating it as rejected.
+func writeDBUnavailable(c *gin.Context, err error) bool {
+	if !db.IsUnavailable(err) {
+		return false
+	}
+	c.Header("Retry-After", dbUnavailableRetryAfter)
+	c.JSON(http.StatusServiceUnavailable, serializer.Err(http.StatusServiceUnavailable, "DB_UNAVAILABLE", err))
+	return true
+}
+
+// messageVersionFromIfMatch reads the message version from IfMatchHeader. The version is sent
+// as an entity tag ("3", W/"3" or a bare 3); a missing header or * returns 0, which skips the check.
+func messageVersionFromIfMatch(c *gin.Context) (int, error) {
+	tag := strings.TrimSpace(c.GetHeader(IfMatchHeader))
+	if tag == "" || tag == "*" {
+		return 0, nil
+	}
+	tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%s must be a message version", IfMatchHeader)
+	}
+	return version, nil
+}
+
+// setMessageETag sets the ETag a client sends back in IfMatchHeader to edit the message again.
+func setMessageETag(c *gin.Context, version int) {
+	c.Header("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// writeSessionLocked responds with 409 and the current lock when err is a *service.SessionLockError,
+// and with 503 when the server keeps no session locks.
+func writeSessionLocked(c *gin.Context, err error) bool {
+	if errors.Is(err, service.ErrSessionLocksUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, serializer.Err(http.StatusServiceUnavailable, "SESSION_LOCKS_UNAVAILABLE", err))
+		return true
+	}
+	var locked *service.SessionLockError
+	if !errors.As(err, &locked) {
+		return false
+	}
+	resp := serializer.Err(http.StatusConflict, "SESSION_LOCKED", err)
+	resp.Data = locked
+	c.JSON(http.StatusConflict, resp)
+	return true
+}
+
+// writeVersionConflict responds with 409 and the current message version when err is a
+// *service.VersionConflictError.
+func writeVersionConflict(c *gin.Context, err error) bool {
+	var conflict *service.VersionConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	setMessageETag(c, conflict.Current)
+	resp := serializer.Err(http.StatusConflict, "VERSION_CONFLICT", err)
+	resp.Data = conflict
+	c.JSON(http.StatusConflict, resp)
+	return true
 }
 
 // StoreMessage godoc
 //
 //	@Summary		Store message to session
-//	@Description	Supports JSON and multipart/form-data. In multipart mode: the payload is a JSON string placed in a form field. The format parameter indicates the format of the input message (default: openai, same as GET). The blob field should be a complete message object: for openai, use OpenAI ChatCompletionMessageParam format (with role and content); for anthropic, use Anthropic MessageParam format (with role and content); for acontext (internal), use {role, parts} format. The optional meta field allows attaching user-provided metadata to the message, which can be retrieved via get_messages().metas or updated via patch_message_meta().
+//	@Description	Supports JSON and multipart/form-data. In multipart mode: the payload is a JSON string placed in a form field. The format parameter indicates the format of the input message (default: openai, same as GET). The blob field should be a complete message object: for openai, use OpenAI ChatCompletionMessageParam format (with role and content); for anthropic, use Anthropic MessageParam format (with role and content); for acontext (internal), use {role, parts} format. The optional meta field allows attaching user-provided metadata to the message, which can be retrieved via get_messages().metas or updated via patch_message_meta(). The optional author_id and author_type attribute the message to a user, agent or system, independently of its role; without them user messages are attributed to the session's user and system messages to the system.
 //	@Tags			session
 //	@Accept			json
 //	@Accept			multipart/form-data
 //	@Produce		json
 //	@Param			session_id	path		string					true	"Session ID"	Format(uuid)
+//	@Param			Idempotency-Key	header		string					false	"Client key that makes retries safe: a repeated key returns the first message with 200 for 24h"
+//	@Param			X-Session-Lock-Holder	header	string					false	"Store the message only if this holder holds the session lock"
 //
 //	// Content-Type: application/json
 //	@Param			payload		body		handler.StoreMessageReq	true	"StoreMessage payload (Content-Type: application/json)"
@@ -362,11 +685,25 @@ type StoreMessageReq struct {
 //	@Param			file		formData	file					false	"When uploading files, the field name must correspond to parts[*].file_field."
 //	@Security		BearerAuth
 //	@Success		201	{object}	serializer.Response{data=model.Message}
+//	@Success		200	{object}	serializer.Response{data=model.Message}	"Message previously stored with the same Idempotency-Key"
+//	@Failure		409	{object}	serializer.Response	"Session is archived (SESSION_ARCHIVED), or X-Session-Lock-Holder does not hold the session lock (SESSION_LOCKED, data=service.SessionLockError)"
+//	@Failure		413	{object}	serializer.Response{data=service.QuotaExceededError}	"Storage quota exceeded (QUOTA_EXCEEDED), or the message has more parts or larger parts than the project allows (MESSAGE_TOO_LARGE, data=service.MessageLimitError)"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"Parts are inconsistent with their types or of a type the session does not allow (INVALID_PARTS), tool-call arguments violate their registered schema (INVALID_TOOL_ARGUMENTS, data=handler.InvalidToolArgumentsResp), a message hook rejected the message (MESSAGE_REJECTED, data=handler.MessageRejectedResp), or a part's declared media type disagrees with its content (MIME_MISMATCH)"
+//	@Failure		429	{object}	serializer.Response	"Message rate limit exceeded; retry after the Retry-After header"
+//	@Failure		503	{object}	serializer.Response	"The database cannot be reached (DB_UNAVAILABLE); retry after the Retry-After header, or session locks are unavailable (SESSION_LOCKS_UNAVAILABLE)"
+//	@Header			all	{integer}	X-RateLimit-Limit		"Messages the session may create in a burst"
+//	@Header			all	{integer}	X-RateLimit-Remaining	"Messages left in the current burst"
 //	@Router			/session/{session_id}/messages [post]
 //	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\nfrom acontext.messages import build_acontext_message\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Store a message in OpenAI format with user metadata\nclient.sessions.store_message(\n    session_id='session-uuid',\n    blob={'role': 'user', 'content': 'Hello!'},\n    format='openai',\n    meta={'source': 'web', 'request_id': 'abc123'}\n)\n\n# Store a message in Acontext format\nmessage = build_acontext_message(role='user', parts=['Hello!'])\nclient.sessions.store_message(\n    session_id='session-uuid',\n    blob=message,\n    format='acontext'\n)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient, MessagePart } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Store a message in OpenAI format with user metadata\nawait client.sessions.storeMessage(\n  'session-uuid',\n  { role: 'user', content: 'Hello!' },\n  { format: 'openai', meta: { source: 'web', request_id: 'abc123' } }\n);\n\n// Store a message in Acontext format\nawait client.sessions.storeMessage(\n  'session-uuid',\n  {\n    role: 'user',\n    parts: [MessagePart.textPart('Hello!')]\n  },\n  { format: 'acontext' }\n);\n","label":"JavaScript"}]
 func (h *SessionHandler) StoreMessage(c *gin.Context) {
 	req := StoreMessageReq{}
 
+	idempotencyKey := c.GetHeader(IdempotencyKeyHeader)
+	if len(idempotencyKey) > MaxIdempotencyKeyLength {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr(fmt.Sprintf("%s exceeds %d characters", IdempotencyKeyHeader, MaxIdempotencyKeyLength), nil))
+		return
+	}
+
 	ct := c.ContentType()
 	if strings.HasPrefix(ct, "multipart/form-data") {
 		if p := c.PostForm("payload"); p != "" {
@@ -394,6 +731,13 @@ func (h *SessionHandler) StoreMessage(c *gin.Context) {
 		return
 	}
 
+	if req.Tokenizer != "" {
+		if err := tokenizer.ValidateEncoding(req.Tokenizer); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid tokenizer", err))
+			return
+		}
+	}
+
 	// Validate meta size (max 64KB)
 	if req.Meta != nil {
 		metaBytes, _ := json.Marshal(req.Meta)
@@ -457,6 +801,12 @@ func (h *SessionHandler) StoreMessage(c *gin.Context) {
 		return
 	}
 
+	authorID, err := parseAuthorID(req.AuthorID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid author_id", err))
+		return
+	}
+
 	// Store user-provided meta in __user_meta__ field for complete isolation from system fields
 	if len(req.Meta) > 0 {
 		if normalizedMeta == nil {
@@ -466,16 +816,56 @@ func (h *SessionHandler) StoreMessage(c *gin.Context) {
 	}
 
 	out, err := h.svc.StoreMessage(c.Request.Context(), service.StoreMessageInput{
-		ProjectID:   project.ID,
-		SessionID:   sessionID,
-		Role:        normalizedRole,
-		Parts:       normalizedParts,
-		Format:      format,
-		MessageMeta: normalizedMeta,
-		Files:       fileMap,
-		UserKEK:     middleware.GetUserKEKIfEncrypted(c),
+		ProjectID:      project.ID,
+		SessionID:      sessionID,
+		Role:           normalizedRole,
+		Parts:          normalizedParts,
+		Format:         format,
+		MessageMeta:    normalizedMeta,
+		Files:          fileMap,
+		UserKEK:        middleware.GetUserKEKIfEncrypted(c),
+		TokenEncoding:  req.Tokenizer,
+		IdempotencyKey: idempotencyKey,
+		Project:        project,
+		AuthorID:       authorID,
+		AuthorType:     req.AuthorType,
+		LockHolderID:   c.GetHeader(SessionLockHolderHeader),
 	})
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidAuthor) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+		if writeInvalidParts(c, err) {
+			return
+		}
+		if writeMIMEError(c, err) {
+			return
+		}
+		if writeInvalidToolArguments(c, err) {
+			return
+		}
+		if writeMessageRejected(c, err) {
+			return
+		}
+		if writeQuotaExceeded(c, err) {
+			return
+		}
+		if writeMessageTooLarge(c, err) {
+			return
+		}
+		if writeSessionArchived(c, err) {
+			return
+		}
+		if writeImmutable(c, err) {
+			return
+		}
+		if writeSessionLocked(c, err) {
+			return
+		}
+		if writeDBUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, serializer.DBErr("", err))
 		return
 	}
@@ -484,18 +874,63 @@ func (h *SessionHandler) StoreMessage(c *gin.Context) {
 	responseMeta := converter.ExtractUserMeta(out.Meta.Data())
 	out.Meta = datatypes.NewJSONType(responseMeta)
 
-	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+	status := http.StatusCreated
+	if out.Replayed {
+		status = http.StatusOK
+	}
+	c.JSON(status, serializer.Response{Data: out})
 }
 
 type GetMessagesReq struct {
-	Limit                         *int   `form:"limit" json:"limit" binding:"omitempty,min=0,max=200" example:"20"`
-	Cursor                        string `form:"cursor" json:"cursor" example:"cHJvdGVjdGVkIHZlcnNpb24gdG8gYmUgZXhjbHVkZWQgaW4gcGFyc2luZyB0aGUgY3Vyc29y"`
-	WithAssetPublicURL            bool   `form:"with_asset_public_url,default=true" json:"with_asset_public_url" example:"true"`
-	WithEvents                    bool   `form:"with_events,default=false" json:"with_events" example:"false"`
-	Format                        string `form:"format,default=openai" json:"format" binding:"omitempty,oneof=acontext openai anthropic gemini" example:"openai" enums:"acontext,openai,anthropic,gemini"`
-	TimeDesc                      bool   `form:"time_desc,default=false" json:"time_desc" example:"false"`
-	EditStrategies                string `form:"edit_strategies" json:"edit_strategies" example:"[{\"type\":\"remove_tool_result\",\"params\":{\"keep_recent_n_tool_results\":3}}]"`
-	PinEditingStrategiesAtMessage string `form:"pin_editing_strategies_at_message" json:"pin_editing_strategies_at_message" example:""`
+	Limit                         *int     `form:"limit" json:"limit" binding:"omitempty,min=0,max=200" example:"20"`
+	Cursor                        string   `form:"cursor" json:"cursor" example:"cHJvdGVjdGVkIHZlcnNpb24gdG8gYmUgZXhjbHVkZWQgaW4gcGFyc2luZyB0aGUgY3Vyc29y"`
+	WithAssetPublicURL            bool     `form:"with_asset_public_url,default=true" json:"with_asset_public_url" example:"true"`
+	WithEvents                    bool     `form:"with_events,default=false" json:"with_events" example:"false"`
+	Format                        string   `form:"format,default=openai" json:"format" binding:"omitempty,oneof=acontext openai anthropic gemini" example:"openai" enums:"acontext,openai,anthropic,gemini"`
+	TimeDesc                      bool     `form:"time_desc,default=false" json:"time_desc" example:"false"`
+	EditStrategies                string   `form:"edit_strategies" json:"edit_strategies" example:"[{\"type\":\"remove_tool_result\",\"params\":{\"keep_recent_n_tool_results\":3}}]"`
+	PinEditingStrategiesAtMessage string   `form:"pin_editing_strategies_at_message" json:"pin_editing_strategies_at_message" example:""`
+	Roles                         []string `form:"role" json:"role" example:"assistant"`
+	Fields                        string   `form:"fields" json:"fields" example:"id,role,created_at"`
+	Since                         string   `form:"since" json:"since" example:"2025-01-01T00:00:00Z"`
+	Until                         string   `form:"until" json:"until" example:"2025-02-01T00:00:00Z"`
+	Include                       string   `form:"include" json:"include" example:"tree_stats"`
+	AuthorID                      string   `form:"author_id" json:"author_id" example:"123e4567-e89b-12d3-a456-426614174000"`
+	AuthorType                    string   `form:"author_type" json:"author_type" example:"agent"`
+	AnnotationKind                string   `form:"annotation_kind" json:"annotation_kind" example:"reaction"`
+	AnnotationValue               string   `form:"annotation_value" json:"annotation_value" example:"thumbs_down"`
+}
+
+// messageIncludeTreeStats is the include value that adds each listed message's depth and child count.
+const messageIncludeTreeStats = "tree_stats"
+
+// parseInclude splits a comma-separated include parameter into the set of values it names,
+// rejecting any outside allowed.
+func parseInclude(raw string, allowed ...string) (map[string]bool, error) {
+	include := map[string]bool{}
+	for _, v := range strings.Split(raw, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !slices.Contains(allowed, v) {
+			return nil, fmt.Errorf("unknown include %q, expected one of %s", v, strings.Join(allowed, ", "))
+		}
+		include[v] = true
+	}
+	return include, nil
+}
+
+// parseAuthorID parses an optional author_id value, returning nil when it is empty.
+func parseAuthorID(raw string) (*uuid.UUID, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
 }
 
 // GetMessages godoc
@@ -511,8 +946,17 @@ type GetMessagesReq struct {
 //	@Param			with_asset_public_url				query	boolean	false	"Whether to return asset public url, default is true"																																																																							example(true)
 //	@Param			with_events							query	boolean	false	"Whether to include session events in the response, default is false"																																																																			example(false)
 //	@Param			format								query	string	false	"Format to convert messages to: acontext (original), openai (default), anthropic, gemini."																																																														enums(acontext,openai,anthropic,gemini)
-//	@Param			time_desc							query	boolean	false	"Order by created_at descending if true, ascending if false (default false)"																																																																	example(false)
+//	@Param			time_desc							query	boolean	false	"Order by seq (storage order) descending if true, ascending if false (default false)"																																																																	example(false)
 //	@Param			edit_strategies						query	string	false	"JSON array of edit strategies to apply before format conversion"																																																																				example([{"type":"remove_tool_result","params":{"keep_recent_n_tool_results":3}}])
+//	@Param			role								query	[]string	false	"Only return messages with this role. Repeat to allow several roles. Stored roles are user and assistant; tool results are stored under user."	collectionFormat(multi)	enums(user,assistant)
+//	@Param			fields								query	string	false	"Comma-separated message fields to return, e.g. id,role,created_at. Requires format=acontext. Only the selected columns are read, and parts are not loaded unless `parts` is selected, which makes metadata-only listings cheap. edit_strategies need `parts`. One of: id, session_id, parent_id, role, author (author_id and author_type), parts, session_task_process_status, meta, task_id, flagged (with flag_reason), created_at, updated_at."	example(id,role,created_at)
+//	@Param			author_id							query	string	false	"Only messages attributed to this author"	format(uuid)
+//	@Param			author_type							query	string	false	"Only messages whose author is of this type. Unlike role, this tells apart users, agents and the system."	enums(user,agent,system)
+//	@Param			annotation_kind						query	string	false	"Only messages with an annotation of this kind"	enums(reaction,label,note)
+//	@Param			annotation_value					query	string	false	"With annotation_kind, only messages with an annotation of this value, e.g. thumbs_down or a label"
+//	@Param			since								query	string	false	"Only messages created at or after this RFC3339 time. Combines with cursor pagination."	example(2025-01-01T00:00:00Z)
+//	@Param			until								query	string	false	"Only messages created at or before this RFC3339 time. Combines with cursor pagination."	example(2025-02-01T00:00:00Z)
+//	@Param			include								query	string	false	"Comma-separated extras to compute. `tree_stats` adds `tree_stats`, holding each message's `depth` (distance from the root, 0 for a root) and `child_count` (live direct children) in the order of `ids`. They are computed in one query over the session's tree, so they always reflect the latest reparenting."	example(tree_stats)
 //	@Param			pin_editing_strategies_at_message	query	string	false	"Message ID to pin editing strategies at. When provided, strategies are only applied to messages up to and including this message ID, keeping subsequent messages unchanged. This helps maintain prompt cache stability by preserving a stable prefix. The response will include edit_at_message_id indicating where strategies were applied."	example()
 //	@Security		BearerAuth
 //	@Success		200	{object}	serializer.Response{data=converter.GetMessagesOutput}
@@ -537,6 +981,44 @@ func (h *SessionHandler) GetMessages(c *gin.Context) {
 		return
 	}
 
+	for _, role := range req.Roles {
+		if !model.IsStoredRole(role) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", fmt.Errorf("unknown role %q, expected one of %s", role, strings.Join(model.StoredRoles, ", "))))
+			return
+		}
+	}
+
+	since, until, err := parseCreatedRange(req.Since, req.Until)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	authorID, err := parseAuthorID(req.AuthorID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid author_id", err))
+		return
+	}
+	if req.AuthorType != "" && !model.IsAuthorType(req.AuthorType) {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", fmt.Errorf("unknown author_type %q, expected one of %s", req.AuthorType, strings.Join(model.AuthorTypes, ", "))))
+		return
+	}
+
+	if req.AnnotationKind != "" && !model.IsAnnotationKind(req.AnnotationKind) {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", fmt.Errorf("unknown annotation_kind %q, expected one of %s", req.AnnotationKind, strings.Join(model.AnnotationKinds, ", "))))
+		return
+	}
+	if req.AnnotationValue != "" && req.AnnotationKind == "" {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("annotation_value requires annotation_kind")))
+		return
+	}
+
+	include, err := parseInclude(req.Include, messageIncludeTreeStats)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
 	// If limit is not provided, set it to 0 to fetch all messages
 	limit := 0
 	if req.Limit != nil {
@@ -552,6 +1034,22 @@ func (h *SessionHandler) GetMessages(c *gin.Context) {
 		}
 	}
 
+	fields, err := model.ParseMessageFields(req.Fields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid fields", err))
+		return
+	}
+	if len(fields) > 0 {
+		if req.Format != string(model.FormatAcontext) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("fields requires format=acontext")))
+			return
+		}
+		if len(editStrategies) > 0 && !slices.Contains(fields, "parts") {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("edit_strategies require the parts field")))
+			return
+		}
+	}
+
 	out, err := h.svc.GetMessages(c.Request.Context(), service.GetMessagesInput{
 		ProjectID:                     project.ID,
 		SessionID:                     sessionID,
@@ -563,9 +1061,22 @@ func (h *SessionHandler) GetMessages(c *gin.Context) {
 		TimeDesc:                      req.TimeDesc,
 		EditStrategies:                editStrategies,
 		PinEditingStrategiesAtMessage: req.PinEditingStrategiesAtMessage,
+		Roles:                         req.Roles,
+		Fields:                        fields,
+		Since:                         since,
+		Until:                         until,
+		AuthorID:                      authorID,
+		AuthorType:                    req.AuthorType,
+		AnnotationKind:                req.AnnotationKind,
+		AnnotationValue:               req.AnnotationValue,
+		WithTreeStats:                 include[messageIncludeTreeStats],
 		UserKEK:                       middleware.GetUserKEKIfEncrypted(c),
 	})
 	if err != nil {
+		if errors.Is(err, paging.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid cursor", err))
+			return
+		}
 		c.JSON(http.StatusBadRequest, serializer.DBErr("", err))
 		return
 	}
@@ -603,6 +1114,15 @@ func (h *SessionHandler) GetMessages(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, serializer.DBErr("failed to convert messages", err))
 		return
 	}
+	if len(fields) > 0 {
+		convertedOut.Items = converter.SelectAcontextFields(out.Items, fields)
+	}
+	if out.TreeStats != nil {
+		convertedOut.TreeStats = make([]model.MessageTreeStats, len(out.Items))
+		for i, m := range out.Items {
+			convertedOut.TreeStats[i] = out.TreeStats[m.ID]
+		}
+	}
 
 	c.JSON(http.StatusOK, serializer.Response{Data: convertedOut})
 }
@@ -766,63 +1286,161 @@ func (h *SessionHandler) GetTokenCounts(c *gin.Context) {
 	}})
 }
 
-// GetSessionObservingStatus godoc
+type GetSessionTokensReq struct {
+	Encoding string `form:"encoding" json:"encoding" example:"cl100k_base"`
+}
+
+// GetSessionTokens godoc
 //
-//	@Summary		Get message observing status for a session
-//	@Description	Returns the count of observed, in_process, and pending messages
+//	@Summary		Get session token breakdown
+//	@Description	Get the session's total token count and a per-role breakdown, for estimating context-window pressure before sending a new turn. Counts cover text and tool-call parts. Pass encoding to count with the tokenizer of your LLM (default o200k_base).
 //	@Tags			session
 //	@Accept			json
 //	@Produce		json
 //	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			encoding	query	string	false	"tiktoken encoding, e.g. cl100k_base or o200k_base"
 //	@Security		BearerAuth
-//	@Success		200	{object}	serializer.Response{data=model.MessageObservingStatus}
-//	@Router			/session/{session_id}/observing_status [get]
-//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Get message observing status\nresult = client.sessions.messages_observing_status(session_id='session-uuid')\nprint(f\"Observed: {result.observed}, In Process: {result.in_process}, Pending: {result.pending}\")\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Get message observing status\nconst result = await client.sessions.messagesObservingStatus('session-uuid');\nconsole.log(`Observed: ${result.observed}, In Process: ${result.in_process}, Pending: ${result.pending}`);\n","label":"JavaScript"}]
-func (h *SessionHandler) GetSessionObservingStatus(c *gin.Context) {
+//	@Success		200	{object}	serializer.Response{data=service.SessionTokensOutput}
+//	@Failure		400	{object}	serializer.Response	"Unsupported encoding"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/tokens [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Get token breakdown\nresult = client.sessions.get_tokens(session_id='session-uuid', encoding='cl100k_base')\nprint(result.total, result.by_role)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Get token breakdown\nconst result = await client.sessions.getTokens('session-uuid', { encoding: 'cl100k_base' });\nconsole.log(result.total, result.by_role);\n","label":"JavaScript"}]
+func (h *SessionHandler) GetSessionTokens(c *gin.Context) {
 	sessionID, err := uuid.Parse(c.Param("session_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
 		return
 	}
 
+	req := GetSessionTokensReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
 	project, ok := c.MustGet("project").(*model.Project)
 	if !ok {
 		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
 		return
 	}
 
-	session, err := h.svc.GetByID(c.Request.Context(), &model.Session{ID: sessionID})
+	out, err := h.svc.GetSessionTokens(c.Request.Context(), service.GetSessionTokensInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Encoding:  req.Encoding,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
 	if err != nil {
-		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "session not found", nil))
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, tokenizer.ErrUnsupportedEncoding):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid encoding", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
 		return
 	}
-	if session.ProjectID != project.ID {
-		c.JSON(http.StatusForbidden, serializer.Err(http.StatusForbidden, "access denied: session does not belong to this project", nil))
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+// GetStorageUsage godoc
+//
+//	@Summary		Get session storage usage
+//	@Description	Get the bytes stored by the session's live messages, counting their parts and referenced assets, and the limits that apply. A limit of 0 means no limit.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=service.SessionStorageUsage}
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/usage [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Check how much storage a session uses\nusage = client.sessions.get_usage(session_id='session-uuid')\nprint(usage.total_bytes, usage.limit_bytes)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Check how much storage a session uses\nconst usage = await client.sessions.getUsage('session-uuid');\nconsole.log(usage.total_bytes, usage.limit_bytes);\n","label":"JavaScript"}]
+func (h *SessionHandler) GetStorageUsage(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
 		return
 	}
 
-	status, err := h.svc.GetSessionObservingStatus(c.Request.Context(), sessionID.String())
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	out, err := h.svc.GetStorageUsage(c.Request.Context(), project.ID, sessionID)
 	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
 		return
 	}
 
-	c.JSON(http.StatusOK, serializer.Response{Data: status})
-}
-
-type PatchMessageMetaReq struct {
-	Meta map[string]interface{} `form:"meta" json:"meta" binding:"required"`
-}
-
-type PatchMessageMetaResp struct {
-	Meta map[string]interface{} `json:"meta"`
-}
-
-type PatchSessionConfigsReq struct {
-	Configs map[string]interface{} `form:"configs" json:"configs" binding:"required"`
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
 }
 
-type PatchSessionConfigsResp struct {
+// GetSessionObservingStatus godoc
+//
+//	@Summary		Get message observing status for a session
+//	@Description	Returns the count of observed, in_process, and pending messages
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.MessageObservingStatus}
+//	@Router			/session/{session_id}/observing_status [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Get message observing status\nresult = client.sessions.messages_observing_status(session_id='session-uuid')\nprint(f\"Observed: {result.observed}, In Process: {result.in_process}, Pending: {result.pending}\")\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Get message observing status\nconst result = await client.sessions.messagesObservingStatus('session-uuid');\nconsole.log(`Observed: ${result.observed}, In Process: ${result.in_process}, Pending: ${result.pending}`);\n","label":"JavaScript"}]
+func (h *SessionHandler) GetSessionObservingStatus(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	session, err := h.svc.GetByID(c.Request.Context(), &model.Session{ID: sessionID})
+	if err != nil {
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "session not found", nil))
+		return
+	}
+	if session.ProjectID != project.ID {
+		c.JSON(http.StatusForbidden, serializer.Err(http.StatusForbidden, "access denied: session does not belong to this project", nil))
+		return
+	}
+
+	status, err := h.svc.GetSessionObservingStatus(c.Request.Context(), sessionID.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: status})
+}
+
+type PatchMessageMetaReq struct {
+	Meta map[string]interface{} `form:"meta" json:"meta" binding:"required"`
+}
+
+type PatchMessageMetaResp struct {
+	Meta map[string]interface{} `json:"meta"`
+}
+
+type PatchSessionConfigsReq struct {
+	Configs map[string]interface{} `form:"configs" json:"configs" binding:"required"`
+}
+
+type PatchSessionConfigsResp struct {
 	Configs map[string]interface{} `json:"configs"`
 }
 
@@ -831,6 +1449,41 @@ type CopySessionResp struct {
 	NewSessionID string `json:"new_session_id"`
 }
 
+type SearchMessagesReq struct {
+	Query     string `form:"query" json:"query" binding:"required" example:"refund policy"`
+	SessionID string `form:"session_id" json:"session_id" binding:"omitempty,uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	Sort      string `form:"sort,default=relevance" json:"sort" binding:"omitempty,oneof=relevance recency blended" example:"blended"`
+	Limit     int    `form:"limit,default=20" json:"limit" binding:"required,min=1,max=200" example:"20"`
+}
+
+type SearchMessagesResp struct {
+	Items []service.MessageSearchResult `json:"items"`
+}
+
+type GetMessageThreadResp struct {
+	Items []service.ThreadMessage `json:"items"`
+}
+
+type DiffBranchesReq struct {
+	LeafA string `form:"leaf_a" json:"leaf_a" binding:"required,uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	LeafB string `form:"leaf_b" json:"leaf_b" binding:"required,uuid" example:"123e4567-e89b-12d3-a456-426614174001"`
+}
+
+type ReparentMessageReq struct {
+	// ParentID is the new parent; null or omitted makes the message a root.
+	ParentID *string `json:"parent_id" binding:"omitempty,uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+}
+
+type ForkSessionReq struct {
+	MessageID string `form:"message_id" json:"message_id" binding:"required,uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+}
+
+type ForkSessionResp struct {
+	OldSessionID string            `json:"old_session_id"`
+	NewSessionID string            `json:"new_session_id"`
+	MessageIDMap map[string]string `json:"message_id_map"`
+}
+
 // PatchMessageMeta godoc
 //
 //	@Summary		Patch message metadata
@@ -841,6 +1494,7 @@ type CopySessionResp struct {
 //	@Param			session_id	path	string						true	"Session ID"	format(uuid)
 //	@Param			message_id	path	string						true	"Message ID"	format(uuid)
 //	@Param			payload		body	handler.PatchMessageMetaReq	true	"PatchMessageMeta payload"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
 //	@Security		BearerAuth
 //	@Success		200	{object}	serializer.Response{data=handler.PatchMessageMetaResp}
 //	@Failure		400	{object}	serializer.Response	"Invalid request"
@@ -879,8 +1533,16 @@ func (h *SessionHandler) PatchMessageMeta(c *gin.Context) {
 		return
 	}
 
-	updatedMeta, err := h.svc.PatchMessageMeta(c.Request.Context(), project.ID, sessionID, messageID, req.Meta)
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	updatedMeta, err := h.svc.PatchMessageMeta(c.Request.Context(), project.ID, sessionID, messageID, req.Meta, override)
 	if err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
 		if strings.Contains(err.Error(), "not found") {
 			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, err.Error(), nil))
 			return
@@ -946,6 +1608,222 @@ func (h *SessionHandler) PatchConfigs(c *gin.Context) {
 	c.JSON(http.StatusOK, serializer.Response{Data: PatchSessionConfigsResp{Configs: updatedConfigs}})
 }
 
+type SetSessionMetadataReq struct {
+	Metadata map[string]interface{} `form:"metadata" json:"metadata" binding:"required"`
+}
+
+type SessionMetadataResp struct {
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// SetMetadata godoc
+//
+//	@Summary		Set session metadata
+//	@Description	Replace the session metadata with the given object. Returns the stored metadata.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.SetSessionMetadataReq	true	"SetSessionMetadata payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.SessionMetadataResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/metadata [put]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Replace session metadata\nmetadata = client.sessions.set_metadata(\n    session_id='session-uuid',\n    metadata={'team': 'search', 'priority': 2}\n)\nprint(metadata)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Replace session metadata\nconst metadata = await client.sessions.setMetadata('session-uuid', { team: 'search', priority: 2 });\nconsole.log(metadata);\n","label":"JavaScript"}]
+func (h *SessionHandler) SetMetadata(c *gin.Context) {
+	h.writeMetadata(c, false)
+}
+
+// PatchMetadata godoc
+//
+//	@Summary		Merge session metadata
+//	@Description	Merge the given keys into the session metadata. Pass null as value to delete a key. Returns the complete metadata after the merge.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.SetSessionMetadataReq	true	"PatchSessionMetadata payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.SessionMetadataResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/metadata [patch]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Merge session metadata (use None to delete a key)\nmetadata = client.sessions.patch_metadata(\n    session_id='session-uuid',\n    metadata={'priority': 3, 'draft': None}\n)\nprint(metadata)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Merge session metadata (use null to delete a key)\nconst metadata = await client.sessions.patchMetadata('session-uuid', { priority: 3, draft: null });\nconsole.log(metadata);\n","label":"JavaScript"}]
+func (h *SessionHandler) PatchMetadata(c *gin.Context) {
+	h.writeMetadata(c, true)
+}
+
+func (h *SessionHandler) writeMetadata(c *gin.Context, merge bool) {
+	req := SetSessionMetadataReq{}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	metadataBytes, _ := json.Marshal(req.Metadata)
+	if len(metadataBytes) > MaxMetaSize {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("metadata size exceeds 64KB limit", nil))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	metadata, err := h.svc.SetMetadata(c.Request.Context(), service.SetSessionMetadataInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Metadata:  req.Metadata,
+		Merge:     merge,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: SessionMetadataResp{Metadata: metadata}})
+}
+
+type UpdateSessionTagsReq struct {
+	Add    []string `json:"add" example:"research"`
+	Remove []string `json:"remove" example:"draft"`
+}
+
+type SessionTagsResp struct {
+	Tags []string `json:"tags"`
+}
+
+// UpdateTags godoc
+//
+//	@Summary		Add or remove session tags
+//	@Description	Add and remove session tags. Tags are trimmed, lowercased and deduplicated; a tag in both lists is removed. Returns the resulting tags.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.UpdateSessionTagsReq	true	"UpdateSessionTags payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.SessionTagsResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/tags [patch]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Tag a session and drop an old tag\ntags = client.sessions.update_tags(\n    session_id='session-uuid',\n    add=['Research', 'q3'],\n    remove=['draft']\n)\nprint(tags)  # ['research', 'q3']\n\n# List sessions carrying a tag\nsessions = client.sessions.list(tag='research')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Tag a session and drop an old tag\nconst tags = await client.sessions.updateTags('session-uuid', { add: ['Research', 'q3'], remove: ['draft'] });\nconsole.log(tags);  // ['research', 'q3']\n\n// List sessions carrying a tag\nconst sessions = await client.sessions.list({ tag: 'research' });\n","label":"JavaScript"}]
+func (h *SessionHandler) UpdateTags(c *gin.Context) {
+	req := UpdateSessionTagsReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("add or remove is required", nil))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	tags, err := h.svc.UpdateTags(c.Request.Context(), service.UpdateSessionTagsInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Add:       req.Add,
+		Remove:    req.Remove,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTag):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid tag", err))
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: SessionTagsResp{Tags: tags}})
+}
+
+type SetAllowedPartTypesReq struct {
+	// AllowedPartTypes lists the part types messages may carry; empty lifts the restriction.
+	AllowedPartTypes []string `json:"allowed_part_types" example:"text"`
+}
+
+type AllowedPartTypesResp struct {
+	AllowedPartTypes []string `json:"allowed_part_types"`
+}
+
+// SetAllowedPartTypes godoc
+//
+//	@Summary		Set allowed part types
+//	@Description	Restrict the part types the session's messages may carry, as a guardrail for integrations that only produce some of them. Storing, streaming or editing a message with a part of another type is rejected with 422 (INVALID_PARTS). Entries must be known part types and are deduplicated; an empty list allows every type again. Existing messages are not checked.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.SetAllowedPartTypesReq	true	"SetAllowedPartTypes payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.AllowedPartTypesResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or unknown part type"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/allowed_part_types [put]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Only accept text and tool parts in this session\nclient.sessions.set_allowed_part_types(\n    session_id='session-uuid',\n    allowed_part_types=['text', 'tool-call', 'tool-result']\n)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Only accept text and tool parts in this session\nawait client.sessions.setAllowedPartTypes('session-uuid', {\n  allowedPartTypes: ['text', 'tool-call', 'tool-result']\n});\n","label":"JavaScript"}]
+func (h *SessionHandler) SetAllowedPartTypes(c *gin.Context) {
+	req := SetAllowedPartTypesReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	types, err := h.svc.SetAllowedPartTypes(c.Request.Context(), project.ID, sessionID, req.AllowedPartTypes)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidPartType):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid allowed_part_types", err))
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: AllowedPartTypesResp{AllowedPartTypes: types}})
+}
+
 // CopySession godoc
 //
 //	@Summary		Copy session
@@ -1008,3 +1886,3192 @@ func (h *SessionHandler) CopySession(c *gin.Context) {
 		},
 	})
 }
+
+type SetTemplateReq struct {
+	IsTemplate bool `json:"is_template" example:"true"`
+}
+
+// SetTemplate godoc
+//
+//	@Summary		Mark session as template
+//	@Description	Mark a session as a reusable template, or turn a template back into an ordinary session. Templates are hidden from session listings unless include_templates=true and can be instantiated into new sessions.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string					true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.SetTemplateReq	true	"SetTemplate payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/template [put]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Turn a session into a reusable template\nclient.sessions.set_template(session_id='session-uuid', is_template=True)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Turn a session into a reusable template\nawait client.sessions.setTemplate('session-uuid', { isTemplate: true });\n","label":"JavaScript"}]
+func (h *SessionHandler) SetTemplate(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := SetTemplateReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	if err := h.svc.SetTemplate(c.Request.Context(), project.ID, sessionID, req.IsTemplate); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
+type InstantiateTemplateReq struct {
+	// Overrides maps placeholder names to values: {{user_name}} in a text part becomes overrides["user_name"].
+	Overrides map[string]string `json:"overrides"`
+}
+
+// InstantiateTemplate godoc
+//
+//	@Summary		Instantiate session template
+//	@Description	Create a new session from a template session. The template's messages are copied with fresh IDs, and {{name}} placeholders in text parts are replaced by the matching overrides; placeholders without an override are kept. The template itself is unchanged.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Template session ID"	format(uuid)
+//	@Param			payload		body	handler.InstantiateTemplateReq	false	"InstantiateTemplate payload"
+//	@Security		BearerAuth
+//	@Success		201	{object}	serializer.Response{data=service.InstantiateTemplateOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request, invalid override name, or session is not a template"
+//	@Failure		404	{object}	serializer.Response	"Template not found"
+//	@Failure		413	{object}	serializer.Response	"Template exceeds maximum copyable size"
+//	@Router			/session/{session_id}/instantiate [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Start a new session from a template\nresult = client.sessions.instantiate_template(\n    session_id='template-uuid',\n    overrides={'user_name': 'Alice'}\n)\nprint(result.session_id)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Start a new session from a template\nconst result = await client.sessions.instantiateTemplate('template-uuid', {\n  overrides: { user_name: 'Alice' }\n});\nconsole.log(result.session_id);\n","label":"JavaScript"}]
+func (h *SessionHandler) InstantiateTemplate(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	templateID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := InstantiateTemplateReq{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+	}
+
+	out, err := h.svc.InstantiateTemplate(c.Request.Context(), service.InstantiateTemplateInput{
+		ProjectID:  project.ID,
+		TemplateID: templateID,
+		Overrides:  req.Overrides,
+		UserKEK:    middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrNotTemplate):
+			c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "NOT_A_TEMPLATE", err))
+		case errors.Is(err, service.ErrInvalidTemplateOverride):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		case errors.Is(err, service.ErrSessionTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, serializer.Err(
+				http.StatusRequestEntityTooLarge,
+				"SESSION_TOO_LARGE",
+				fmt.Errorf("Template exceeds maximum copyable size (%d messages).", repo.MaxCopyableMessages),
+			))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+}
+
+type ArchiveSessionReq struct {
+	// Purge deletes the session's message rows once the archive is written, keeping only the session itself.
+	Purge bool `json:"purge" example:"false"`
+}
+
+// writeSessionArchived responds with 409 when err reports that the session is archived.
+func writeSessionArchived(c *gin.Context, err error) bool {
+	if !errors.Is(err, service.ErrSessionArchived) {
+		return false
+	}
+	c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_ARCHIVED", err))
+	return true
+}
+
+// ImmutabilityOverrideHeader carries the reason for an admin change to a finalized session or to
+// a message past the immutability window.
+const ImmutabilityOverrideHeader = "X-Immutability-Override"
+
+// immutabilityOverride returns the override the request asks for through
+// ImmutabilityOverrideHeader, or nil. Only requests authenticated with an admin project token may
+// override; others are answered with 403 and ok false.
+func immutabilityOverride(c *gin.Context) (*service.ImmutabilityOverride, bool) {
+	reason := strings.TrimSpace(c.GetHeader(ImmutabilityOverrideHeader))
+	if reason == "" {
+		return nil, true
+	}
+	if !c.GetBool("admin") {
+		c.JSON(http.StatusForbidden, serializer.Err(http.StatusForbidden, "OVERRIDE_FORBIDDEN", errors.New("immutability overrides require an admin token")))
+		return nil, false
+	}
+	return &service.ImmutabilityOverride{Reason: reason}, true
+}
+
+// writeImmutable responds with 409 when err reports that the session is finalized or the message
+// is past the immutability window.
+func writeImmutable(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrSessionFinalized):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_FINALIZED", err))
+	case errors.Is(err, service.ErrMessageImmutable):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_IMMUTABLE", err))
+	default:
+		return false
+	}
+	return true
+}
+
+// writeMessageShared responds with 409 when err reports that the message is shared with a
+// shallow clone.
+func writeMessageShared(c *gin.Context, err error) bool {
+	if !errors.Is(err, service.ErrMessageShared) {
+		return false
+	}
+	c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_SHARED", err))
+	return true
+}
+
+// ArchiveSession godoc
+//
+//	@Summary		Archive session
+//	@Description	Write the session's messages and asset manifest to a compressed archive in cold storage. Archived sessions are hidden from session listings unless include_archived=true and reject new messages until restored. With purge=true the message rows are deleted from the database as well, leaving only the session; restoring brings them back with their original IDs. Messages being streamed must be finalized first.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.ArchiveSessionReq	false	"ArchiveSession payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.Session}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"Session is already archived (SESSION_ARCHIVED), has a message still streaming (MESSAGE_STREAMING), or shares messages with a shallow clone and purge was requested (SESSION_SHARED)"
+//	@Router			/session/{session_id}/archive [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Move a finished session to cold storage and drop its rows\nsession = client.sessions.archive(session_id='session-uuid', purge=True)\nprint(session.archived)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Move a finished session to cold storage and drop its rows\nconst session = await client.sessions.archive('session-uuid', { purge: true });\nconsole.log(session.archived);\n","label":"JavaScript"}]
+func (h *SessionHandler) ArchiveSession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := ArchiveSessionReq{}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	session, err := h.svc.ArchiveSession(c.Request.Context(), service.ArchiveSessionInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Purge:     req.Purge,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if writeSessionArchived(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageStreaming):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_STREAMING", err))
+		case errors.Is(err, service.ErrSessionShared):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_SHARED", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: session})
+}
+
+// RestoreSession godoc
+//
+//	@Summary		Restore archived session
+//	@Description	Bring an archived session back from cold storage. Purged messages are rehydrated from the archive with their original IDs, the session is listed again and accepts new messages, and the archive is deleted.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=service.RestoreSessionOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid session ID"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"Session is not archived (SESSION_NOT_ARCHIVED)"
+//	@Router			/session/{session_id}/restore [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Bring an archived session back\nresult = client.sessions.restore(session_id='session-uuid')\nprint(result.restored_messages)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Bring an archived session back\nconst result = await client.sessions.restore('session-uuid');\nconsole.log(result.restoredMessages);\n","label":"JavaScript"}]
+func (h *SessionHandler) RestoreSession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	out, err := h.svc.RestoreSession(c.Request.Context(), service.RestoreSessionInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrSessionNotArchived):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_NOT_ARCHIVED", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+// FinalizeSession godoc
+//
+//	@Summary		Finalize session
+//	@Description	Lock the session for audit: no messages can be added to it afterwards, and its messages can no longer be edited or deleted (409 SESSION_FINALIZED). Admin tokens can still change them by passing X-Immutability-Override with a reason, which is recorded in the session's override log. Finalizing is permanent and finalizing a finalized session changes nothing. Messages being streamed must be finalized first.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.Session}
+//	@Failure		400	{object}	serializer.Response	"Invalid session ID"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"Session has a message still streaming (MESSAGE_STREAMING)"
+//	@Router			/session/{session_id}/finalize [post]
+func (h *SessionHandler) FinalizeSession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	session, err := h.svc.FinalizeSession(c.Request.Context(), project.ID, sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageStreaming):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_STREAMING", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: session})
+}
+
+// GetImmutabilityOverrides godoc
+//
+//	@Summary		List immutability overrides
+//	@Description	List the admin overrides that changed the session while it was finalized or its messages were past the immutability window, oldest first. The log is kept after the session is deleted.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=[]model.ImmutabilityOverride}
+//	@Failure		400	{object}	serializer.Response	"Invalid session ID"
+//	@Router			/session/{session_id}/immutability/overrides [get]
+func (h *SessionHandler) GetImmutabilityOverrides(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	overrides, err := h.svc.ListImmutabilityOverrides(c.Request.Context(), project.ID, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: overrides})
+}
+
+type MergeSessionsReq struct {
+	SourceSessionIDs []uuid.UUID `json:"source_session_ids" binding:"required,min=1"`
+	// Mode is attach (default) or branches.
+	Mode string `json:"mode" binding:"omitempty,oneof=attach branches" example:"attach"`
+}
+
+// MergeSessions godoc
+//
+//	@Summary		Merge sessions
+//	@Description	Move every message of the source sessions into this one and delete the sources. The sources are appended in the order given, their messages numbered after this session's latest. With `attach` (default) the root messages of each source move under the newest message so far, continuing the conversation; with `branches` they stay roots, one branch per source. Embeddings follow the messages; tasks and events stay with the deleted sources. Listeners of this session get a message.created event for each message moved.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Target session ID"	format(uuid)
+//	@Param			payload		body	handler.MergeSessionsReq	true	"MergeSessions payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=repo.MergeSessionsResult}
+//	@Failure		400	{object}	serializer.Response	"Invalid request, or the session is among its sources"
+//	@Failure		404	{object}	serializer.Response	"A session was not found"
+//	@Failure		409	{object}	serializer.Response	"A session is archived (SESSION_ARCHIVED) or finalized (SESSION_FINALIZED), a source has a message still streaming (MESSAGE_STREAMING), or a source is or has a shallow clone (SESSION_SHARED)"
+//	@Router			/session/{session_id}/merge [post]
+func (h *SessionHandler) MergeSessions(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := MergeSessionsReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	result, err := h.svc.MergeSessions(c.Request.Context(), service.MergeSessionsInput{
+		ProjectID: project.ID,
+		TargetID:  sessionID,
+		SourceIDs: req.SourceSessionIDs,
+		Mode:      repo.SessionMergeMode(req.Mode),
+	})
+	if err != nil {
+		if writeSessionArchived(c, err) || writeImmutable(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMergeIntoSelf), errors.Is(err, service.ErrNoMergeSources), errors.Is(err, service.ErrInvalidMergeMode):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		case errors.Is(err, service.ErrMessageStreaming):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_STREAMING", err))
+		case errors.Is(err, service.ErrSessionShared):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_SHARED", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: result})
+}
+
+type AcquireSessionLockReq struct {
+	HolderID string `json:"holder_id" binding:"required" example:"agent-worker-1"`
+	// TTLSeconds is how long the lock lasts unless renewed; 0 uses the server default.
+	TTLSeconds int `json:"ttl_seconds" binding:"omitempty,min=0" example:"60"`
+}
+
+// AcquireSessionLock godoc
+//
+//	@Summary		Acquire session lock
+//	@Description	Lock the session for a single writer, or renew the lock the holder already has. The lock is advisory: only message stores sending the X-Session-Lock-Holder header are checked against it. It expires after ttl_seconds unless renewed, so a crashed holder does not block the session.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.AcquireSessionLockReq	true	"AcquireSessionLock payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.SessionLock}
+//	@Failure		400	{object}	serializer.Response	"Invalid holder ID or TTL"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response{data=service.SessionLockError}	"Another holder has the lock (SESSION_LOCKED)"
+//	@Failure		503	{object}	serializer.Response	"Session locks are unavailable (SESSION_LOCKS_UNAVAILABLE)"
+//	@Router			/session/{session_id}/lock [post]
+func (h *SessionHandler) AcquireSessionLock(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := AcquireSessionLockReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	lock, err := h.svc.AcquireSessionLock(c.Request.Context(), service.AcquireSessionLockInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		HolderID:  req.HolderID,
+		TTL:       time.Duration(req.TTLSeconds) * time.Second,
+	})
+	if err != nil {
+		writeSessionLockErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: lock})
+}
+
+// ReleaseSessionLock godoc
+//
+//	@Summary		Release session lock
+//	@Description	Unlock the session. Only the holder may release the lock.
+//	@Tags			session
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			holder_id	query	string	true	"Holder of the lock"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response
+//	@Failure		400	{object}	serializer.Response	"Invalid holder ID"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response{data=service.SessionLockError}	"The caller does not hold the lock (SESSION_LOCKED)"
+//	@Failure		503	{object}	serializer.Response	"Session locks are unavailable (SESSION_LOCKS_UNAVAILABLE)"
+//	@Router			/session/{session_id}/lock [delete]
+func (h *SessionHandler) ReleaseSessionLock(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	if err := h.svc.ReleaseSessionLock(c.Request.Context(), project.ID, sessionID, c.Query("holder_id")); err != nil {
+		writeSessionLockErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
+// GetSessionLock godoc
+//
+//	@Summary		Get session lock
+//	@Description	Get the session's lock; data is null when the session is not locked.
+//	@Tags			session
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.SessionLock}
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		503	{object}	serializer.Response	"Session locks are unavailable (SESSION_LOCKS_UNAVAILABLE)"
+//	@Router			/session/{session_id}/lock [get]
+func (h *SessionHandler) GetSessionLock(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	lock, err := h.svc.GetSessionLock(c.Request.Context(), project.ID, sessionID)
+	if err != nil {
+		writeSessionLockErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: lock})
+}
+
+// writeSessionLockErr responds with the error of a session lock endpoint.
+func writeSessionLockErr(c *gin.Context, err error) {
+	if writeSessionLocked(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+	case errors.Is(err, service.ErrInvalidLockHolder), errors.Is(err, service.ErrInvalidLockTTL):
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+	default:
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+	}
+}
+
+// ForkSession godoc
+//
+//	@Summary		Fork session
+//	@Description	Create a new session from the branch ending at the given message. The root-to-message ancestor chain is copied with fresh message IDs; assets are shared with the original session.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string					true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.ForkSessionReq	true	"ForkSession payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.ForkSessionResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or message not in session"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		413	{object}	serializer.Response	"Branch exceeds maximum copyable size"
+//	@Failure		500	{object}	serializer.Response	"Failed to fork session"
+//	@Router			/session/{session_id}/fork [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Fork a session at a message\nresult = client.sessions.fork(session_id='session-uuid', message_id='message-uuid')\nprint(f\"Forked session: {result.new_session_id}\")\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Fork a session at a message\nconst result = await client.sessions.fork('session-uuid', { messageId: 'message-uuid' });\nconsole.log(`Forked session: ${result.newSessionId}`);\n","label":"JavaScript"}]
+func (h *SessionHandler) ForkSession(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "INVALID_SESSION_ID", err))
+		return
+	}
+
+	req := ForkSessionReq{}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	messageID, err := uuid.Parse(req.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	result, err := h.svc.ForkSession(c.Request.Context(), service.ForkSessionInput{
+		ProjectID:     project.ID,
+		SessionID:     sessionID,
+		FromMessageID: messageID,
+		UserKEK:       middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageNotInSession) {
+			c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "MESSAGE_NOT_IN_SESSION", err))
+			return
+		}
+		if errors.Is(err, service.ErrSessionTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, serializer.Err(
+				http.StatusRequestEntityTooLarge,
+				"SESSION_TOO_LARGE",
+				fmt.Errorf("Branch exceeds maximum copyable size (%d messages).", repo.MaxCopyableMessages),
+			))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "INTERNAL_ERROR", err))
+		return
+	}
+
+	idMap := make(map[string]string, len(result.MessageIDMap))
+	for oldID, newID := range result.MessageIDMap {
+		idMap[oldID.String()] = newID.String()
+	}
+	c.JSON(http.StatusOK, serializer.Response{
+		Data: ForkSessionResp{
+			OldSessionID: result.OldSessionID.String(),
+			NewSessionID: result.NewSessionID.String(),
+			MessageIDMap: idMap,
+		},
+	})
+}
+
+type CloneSessionShallowReq struct {
+	// MessageID is the branch point; omitted, the clone branches at the newest message.
+	MessageID string `form:"message_id" json:"message_id" binding:"omitempty,uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+}
+
+// CloneSessionShallow godoc
+//
+//	@Summary		Clone session by reference
+//	@Description	Create a lightweight fork that shares the source's messages on the branch ending at the given message, the newest message when omitted, instead of copying them. Only the new session row is written; the clone lists the shared messages followed by its own, and its first message continues from the branch point. Shared messages are copy-on-write: adding messages to either session never touches the other, but editing, moving or deleting a shared message from either side returns 409 (MESSAGE_SHARED). Deleting the source keeps the messages its clones share, which are handed over to a clone.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.CloneSessionShallowReq	false	"CloneSessionShallow payload"
+//	@Security		BearerAuth
+//	@Success		201	{object}	serializer.Response{data=service.CloneSessionShallowOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or message not in session"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"Branch point is still streaming or the session is archived"
+//	@Router			/session/{session_id}/clone [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Clone a session by reference at a message\nresult = client.sessions.clone_shallow(session_id='session-uuid', message_id='message-uuid')\nprint(f\"Clone: {result.new_session_id}, sharing {result.shared_messages} messages\")\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Clone a session by reference at a message\nconst result = await client.sessions.cloneShallow('session-uuid', { messageId: 'message-uuid' });\nconsole.log(`Clone: ${result.new_session_id}, sharing ${result.shared_messages} messages`);\n","label":"JavaScript"}]
+func (h *SessionHandler) CloneSessionShallow(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "INVALID_SESSION_ID", err))
+		return
+	}
+
+	req := CloneSessionShallowReq{}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	messageID := uuid.Nil
+	if req.MessageID != "" {
+		if messageID, err = uuid.Parse(req.MessageID); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	out, err := h.svc.CloneSessionShallow(c.Request.Context(), service.CloneSessionShallowInput{
+		ProjectID:     project.ID,
+		SessionID:     sessionID,
+		FromMessageID: messageID,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotInSession):
+			c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "MESSAGE_NOT_IN_SESSION", err))
+		case errors.Is(err, service.ErrMessageStreaming):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_STREAMING", err))
+		case errors.Is(err, service.ErrSessionArchived):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_ARCHIVED", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "INTERNAL_ERROR", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+}
+
+// GetMessageThread godoc
+//
+//	@Summary		Get message thread
+//	@Description	Get the path of messages from the root of the conversation tree down to the given message, root first. Each item carries its depth (root = 0).
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.GetMessageThreadResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		500	{object}	serializer.Response	"Message parent chain contains a cycle"
+//	@Router			/session/{session_id}/messages/{message_id}/thread [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Get the root-to-message path\nthread = client.sessions.get_message_thread(session_id='session-uuid', message_id='message-uuid')\nfor item in thread.items:\n    print(item.depth, item.role)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Get the root-to-message path\nconst thread = await client.sessions.getMessageThread('session-uuid', 'message-uuid');\nfor (const item of thread.items) {\n  console.log(item.depth, item.role);\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) GetMessageThread(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	items, err := h.svc.GetMessageThread(c.Request.Context(), service.GetMessageThreadInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageCycle) {
+			c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "MESSAGE_CYCLE", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: GetMessageThreadResp{Items: items}})
+}
+
+// ReparentMessage godoc
+//
+//	@Summary		Move message to another parent
+//	@Description	Change a message's parent within the same session. The message's whole subtree moves with it. Moving a message under itself or one of its descendants is rejected. Returns the message's new depth (root = 0).
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string						true	"Message ID"	format(uuid)
+//	@Param			payload		body	handler.ReparentMessageReq	true	"ReparentMessage payload"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=service.ReparentMessageOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request, parent in another session, or move would create a cycle"
+//	@Failure		404	{object}	serializer.Response	"Session, message or parent not found"
+//	@Failure		409	{object}	serializer.Response	"Message is shared with a shallow clone"
+//	@Router			/session/{session_id}/messages/{message_id}/parent [put]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Move a message and its replies under another message\nresult = client.sessions.reparent_message(\n    session_id='session-uuid',\n    message_id='message-uuid',\n    parent_id='new-parent-uuid'\n)\nprint(result.depth)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Move a message and its replies under another message\nconst result = await client.sessions.reparentMessage('session-uuid', 'message-uuid', {\n  parentId: 'new-parent-uuid'\n});\nconsole.log(result.depth);\n","label":"JavaScript"}]
+func (h *SessionHandler) ReparentMessage(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	req := ReparentMessageReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	var parentID *uuid.UUID
+	if req.ParentID != nil {
+		id, err := uuid.Parse(*req.ParentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid parent_id", err))
+			return
+		}
+		parentID = &id
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	out, err := h.svc.ReparentMessage(c.Request.Context(), service.ReparentMessageInput{
+		ProjectID:   project.ID,
+		SessionID:   sessionID,
+		MessageID:   messageID,
+		NewParentID: parentID,
+		Override:    override,
+	})
+	if err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotInSession):
+			c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "MESSAGE_NOT_IN_SESSION", err))
+		case errors.Is(err, service.ErrReparentCycle):
+			c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "REPARENT_CYCLE", err))
+		case errors.Is(err, service.ErrMessageCycle):
+			c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "MESSAGE_CYCLE", err))
+		case errors.Is(err, service.ErrMessageShared):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_SHARED", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+// DiffBranches godoc
+//
+//	@Summary		Diff two branches
+//	@Description	Compare the branches ending at two leaf messages of the same session. Returns the fork point - the deepest message both root-to-leaf paths share, null if they share none - and the diverging tail of each branch below it, in order. A tail is empty when its leaf is an ancestor of the other leaf. Each message carries its depth (root = 0).
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			leaf_a		query	string	true	"Leaf message of the first branch"	format(uuid)
+//	@Param			leaf_b		query	string	true	"Leaf message of the second branch"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=service.DiffBranchesOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request, or a leaf not in the session"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		500	{object}	serializer.Response	"Message parent chain contains a cycle"
+//	@Router			/session/{session_id}/diff [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Compare two alternative continuations\ndiff = client.sessions.diff_branches(session_id='session-uuid', leaf_a='leaf-a-uuid', leaf_b='leaf-b-uuid')\nprint(diff.fork_point, len(diff.tail_a), len(diff.tail_b))\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Compare two alternative continuations\nconst diff = await client.sessions.diffBranches('session-uuid', { leafA: 'leaf-a-uuid', leafB: 'leaf-b-uuid' });\nconsole.log(diff.fork_point, diff.tail_a.length, diff.tail_b.length);\n","label":"JavaScript"}]
+func (h *SessionHandler) DiffBranches(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := DiffBranchesReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	out, err := h.svc.DiffBranches(c.Request.Context(), service.DiffBranchesInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		LeafA:     uuid.MustParse(req.LeafA),
+		LeafB:     uuid.MustParse(req.LeafB),
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotInSession):
+			c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "MESSAGE_NOT_IN_SESSION", err))
+		case errors.Is(err, service.ErrMessageCycle):
+			c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "MESSAGE_CYCLE", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+type ValidateSessionResp struct {
+	Valid  bool                      `json:"valid"`
+	Issues []editor.ToolPairingIssue `json:"issues"`
+}
+
+// ValidateSession godoc
+//
+//	@Summary		Validate tool-call pairing
+//	@Description	Check that every tool-call part has a matching tool-result part (tool-result meta.tool_call_id equal to the tool-call meta.id) further down the same branch, and that every tool-result answers such a call. Returns the orphaned, duplicate and ID-less parts found; an empty list means the session can be replayed safely.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.ValidateSessionResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/validate [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Check tool-call pairing before replaying a session\nresult = client.sessions.validate(session_id='session-uuid')\nfor issue in result.issues:\n    print(issue.type, issue.message_id, issue.tool_call_id)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Check tool-call pairing before replaying a session\nconst result = await client.sessions.validate('session-uuid');\nfor (const issue of result.issues) {\n  console.log(issue.type, issue.message_id, issue.tool_call_id);\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) ValidateSession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	issues, err := h.svc.ValidateToolPairing(c.Request.Context(), service.ValidateToolPairingInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: ValidateSessionResp{Valid: len(issues) == 0, Issues: issues}})
+}
+
+type ValidateTreeResp struct {
+	Valid  bool                    `json:"valid"`
+	Broken []repo.BrokenParentLink `json:"broken"`
+}
+
+// ValidateTree godoc
+//
+//	@Summary		Validate message tree
+//	@Description	Find the session's live messages whose parent_id names a message that does not exist or belongs to another session (`reason` is `missing` or `cross_session`). A shallow clone's link to its base message is valid. Such links are rejected on creation and only come from legacy data or partial imports; see the repair endpoint to fix them.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.ValidateTreeResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/tree/validate [get]
+func (h *SessionHandler) ValidateTree(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	links, err := h.svc.ValidateTree(c.Request.Context(), service.ValidateTreeInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: ValidateTreeResp{Valid: len(links) == 0, Broken: links}})
+}
+
+type RepairTreeReq struct {
+	Mode string `json:"mode" binding:"required,oneof=reattach delete" example:"reattach"`
+}
+
+// RepairTree godoc
+//
+//	@Summary		Repair message tree
+//	@Description	Fix the broken parent links the validate endpoint reports. `reattach` moves each orphan under the session root - the base message of a shallow clone, else the first root message - or makes it a root when the session has none; `delete` soft-deletes each orphan together with its descendants. Listeners get message.reparented or message.deleted events. Fails with 409 when an affected message is shared with a shallow clone.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string					true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.RepairTreeReq	true	"Repair mode"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=repo.RepairTreeResult}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"Message shared with a shallow clone"
+//	@Router			/session/{session_id}/tree/repair [post]
+func (h *SessionHandler) RepairTree(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := RepairTreeReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.svc.RepairTree(c.Request.Context(), service.RepairTreeInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Mode:      repo.TreeRepairMode(req.Mode),
+		Override:  override,
+	})
+	if err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrInvalidTreeRepairMode):
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		case errors.Is(err, service.ErrMessageShared):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_SHARED", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: result})
+}
+
+type DedupeSessionReq struct {
+	DryRun bool `form:"dry_run" json:"dry_run" example:"true"`
+}
+
+// DedupeSession godoc
+//
+//	@Summary		Merge duplicate consecutive messages
+//	@Description	Collapse messages that repeat their parent - the same role and identical text parts, as left behind by clients retrying a request - into the topmost copy. The survivor keeps the earliest created_at and gains the asset parts only the copies link, the copies' children move under it, and the copies are deleted. Messages with tool calls, tool results or other non-text content are never merged. With `dry_run=true` the merges are reported without being applied.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			dry_run		query	bool	false	"Report the merges without applying them"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=service.DedupeConsecutiveOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"Messages changed during the merge (MESSAGES_CHANGED) or a duplicate is shared with a shallow clone (MESSAGE_SHARED)"
+//	@Failure		413	{object}	serializer.Response{data=service.QuotaExceededError}	"Storage quota exceeded"
+//	@Router			/session/{session_id}/dedupe [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Preview, then merge duplicated retries\nreport = client.sessions.dedupe(session_id='session-uuid', dry_run=True)\nfor merge in report.merges:\n    print(merge.survivor_id, merge.duplicate_ids)\nclient.sessions.dedupe(session_id='session-uuid')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Preview, then merge duplicated retries\nconst report = await client.sessions.dedupe('session-uuid', { dryRun: true });\nfor (const merge of report.merges) {\n  console.log(merge.survivor_id, merge.duplicate_ids);\n}\nawait client.sessions.dedupe('session-uuid');\n","label":"JavaScript"}]
+func (h *SessionHandler) DedupeSession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := DedupeSessionReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	out, err := h.svc.DedupeConsecutive(c.Request.Context(), service.DedupeConsecutiveInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		DryRun:    req.DryRun,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+		Override:  override,
+	})
+	if err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageNotFound) || errors.Is(err, service.ErrMessagePartsChanged) {
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGES_CHANGED", err))
+			return
+		}
+		if writeMessageShared(c, err) {
+			return
+		}
+		if writeQuotaExceeded(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+type ExportSessionReq struct {
+	Format string `form:"format,default=openai" json:"format" enums:"openai,markdown" example:"openai"`
+	// InlineImages embeds stored images in a markdown export as base64 data URLs instead of linking to them.
+	InlineImages bool `form:"inline_images" json:"inline_images" example:"false"`
+}
+
+type ExportUnsupportedPartsResp struct {
+	PartTypes []string `json:"part_types"`
+}
+
+// ExportSession godoc
+//
+//	@Summary		Export session
+//	@Description	Export the session's main branch - the thread ending at its newest message - in an external format. `openai` produces a chat-completions `{"messages": [...]}` body: images become `image_url` blocks, tool calls and results follow the `tool_calls` / `tool` role conventions, and stored media without an inline representation is kept as a text reference to its asset URL. `markdown` produces a `{"markdown": "..."}` transcript for people to read: a heading per message, text escaped so it renders as written, images embedded (as base64 data URLs with `inline_images=true`, linked to their asset URL otherwise), other media linked, and tool calls, tool results and data in fenced code blocks. Returns 422 listing the part types the format cannot represent.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			format		query	string	false	"Export format, default openai"	Enums(openai, markdown)
+//	@Param			inline_images	query	bool	false	"Embed images in a markdown export as base64 data URLs instead of linking to their asset URL"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=converter.OpenAIExport}	"openai export; a markdown export has data=converter.MarkdownExport"
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		422	{object}	serializer.Response{data=handler.ExportUnsupportedPartsResp}	"Session has parts the format cannot represent"
+//	@Router			/session/{session_id}/export [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Export the conversation as an OpenAI chat-completions body\nexported = client.sessions.export(session_id='session-uuid', format='openai')\nprint(len(exported.messages))\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Export the conversation as an OpenAI chat-completions body\nconst exported = await client.sessions.export('session-uuid', { format: 'openai' });\nconsole.log(exported.messages.length);\n","label":"JavaScript"}]
+func (h *SessionHandler) ExportSession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := ExportSessionReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	format, err := converter.ValidateExportFormat(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid format", err))
+		return
+	}
+
+	out, err := h.svc.ExportSession(c.Request.Context(), service.ExportSessionInput{
+		ProjectID:   project.ID,
+		SessionID:   sessionID,
+		AssetExpire: time.Hour * 24,
+		UserKEK:     middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageCycle) {
+			c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "MESSAGE_CYCLE", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	exported, err := converter.ExportSession(out.Messages, format, out.PublicURLs, converter.ExportOptions{InlineImages: req.InlineImages})
+	if err != nil {
+		var unsupported *converter.UnsupportedPartsError
+		if errors.As(err, &unsupported) {
+			resp := serializer.Err(http.StatusUnprocessableEntity, "UNSUPPORTED_PARTS", err)
+			resp.Data = ExportUnsupportedPartsResp{PartTypes: unsupported.PartTypes}
+			c.JSON(http.StatusUnprocessableEntity, resp)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "failed to export session", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: exported})
+}
+
+type ReplaySessionReq struct {
+	BranchMessageID string `form:"branch_message_id" json:"branch_message_id" example:"123e4567-e89b-12d3-a456-426614174000"`
+	Format          string `form:"format,default=acontext" json:"format" enums:"acontext,openai" example:"acontext"`
+}
+
+// ReplaySession godoc
+//
+//	@Summary		Replay session
+//	@Description	Split a branch of the session into the prompt/completion turns it recorded, for offline evaluation. Each turn holds the input context - every message before an assistant reply, tool calls and tool results included, in order - and the consecutive assistant messages that answered it. The branch ends at `branch_message_id`, or at the newest message when omitted. Messages after the last assistant reply belong to no turn. The output is deterministic and links no asset URLs.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id			path	string	true	"Session ID"	format(uuid)
+//	@Param			branch_message_id	query	string	false	"Message ID the replayed branch ends at, default the newest message"	format(uuid)
+//	@Param			format				query	string	false	"Message format of the turns, default acontext"	Enums(acontext, openai)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=converter.ReplayExport}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		422	{object}	serializer.Response{data=handler.ExportUnsupportedPartsResp}	"Branch has parts the format cannot represent"
+//	@Router			/session/{session_id}/replay [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Replay the main branch as OpenAI messages\nreplay = client.sessions.replay(session_id='session-uuid', format='openai')\nfor turn in replay.turns:\n    print(turn.index, len(turn.input), len(turn.output))\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Replay the main branch as OpenAI messages\nconst replay = await client.sessions.replay('session-uuid', { format: 'openai' });\nfor (const turn of replay.turns) {\n  console.log(turn.index, turn.input.length, turn.output.length);\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) ReplaySession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := ReplaySessionReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	format, err := converter.ValidateReplayFormat(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid format", err))
+		return
+	}
+	var branchMessageID *uuid.UUID
+	if req.BranchMessageID != "" {
+		id, err := uuid.Parse(req.BranchMessageID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid branch_message_id", err))
+			return
+		}
+		branchMessageID = &id
+	}
+
+	out, err := h.svc.ReplaySession(c.Request.Context(), service.ReplaySessionInput{
+		ProjectID:       project.ID,
+		SessionID:       sessionID,
+		BranchMessageID: branchMessageID,
+		UserKEK:         middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageCycle) {
+			c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "MESSAGE_CYCLE", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	var leafID *string
+	if out.LeafMessageID != nil {
+		id := out.LeafMessageID.String()
+		leafID = &id
+	}
+	exported, err := converter.ExportReplay(leafID, out.Turns, format)
+	if err != nil {
+		var unsupported *converter.UnsupportedPartsError
+		if errors.As(err, &unsupported) {
+			resp := serializer.Err(http.StatusUnprocessableEntity, "UNSUPPORTED_PARTS", err)
+			resp.Data = ExportUnsupportedPartsResp{PartTypes: unsupported.PartTypes}
+			c.JSON(http.StatusUnprocessableEntity, resp)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.Err(http.StatusInternalServerError, "failed to replay session", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: exported})
+}
+
+type ImportSessionReq struct {
+	Format string `form:"format,default=anthropic" json:"format" enums:"anthropic" example:"anthropic"`
+}
+
+// ImportSession godoc
+//
+//	@Summary		Import session
+//	@Description	Create a new session from a whole conversation in an external format. `anthropic` takes a Messages API request body (`{"system": ..., "messages": [...]}`): text, image, tool_use, tool_result, document and thinking blocks become parts, base64 images and documents are stored as assets, and the messages are chained in order. A system prompt is kept as a leading message with `original_role` "system". Unknown roles or block types are rejected.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			format	query	string	false	"Import format, default anthropic"	Enums(anthropic)
+//	@Param			payload	body	object	true	"Conversation in the given format"
+//	@Security		BearerAuth
+//	@Success		201	{object}	serializer.Response{data=service.ImportSessionOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid conversation"
+//	@Failure		413	{object}	serializer.Response{data=service.QuotaExceededError}	"Storage quota exceeded"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"Parts are inconsistent with their types, or a part's declared media type disagrees with its content (MIME_MISMATCH)"
+//	@Router			/session/import [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Import an Anthropic Messages conversation into a new session\nimported = client.sessions.import_session(\n    format='anthropic',\n    body={'system': 'Be brief.', 'messages': [{'role': 'user', 'content': 'Hi'}]},\n)\nprint(imported.session.id, len(imported.message_ids))\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Import an Anthropic Messages conversation into a new session\nconst imported = await client.sessions.importSession({\n  format: 'anthropic',\n  body: { system: 'Be brief.', messages: [{ role: 'user', content: 'Hi' }] },\n});\nconsole.log(imported.session.id, imported.message_ids.length);\n","label":"JavaScript"}]
+func (h *SessionHandler) ImportSession(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	req := ImportSessionReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	format, err := normalizer.ValidateImportFormat(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid format", err))
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("failed to read body", err))
+		return
+	}
+	messages, err := normalizer.ParseConversation(format, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid conversation", err))
+		return
+	}
+
+	out, err := h.svc.ImportSession(c.Request.Context(), service.ImportSessionInput{
+		ProjectID: project.ID,
+		Messages:  messages,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidImport) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid conversation", err))
+			return
+		}
+		if writeInvalidParts(c, err) {
+			return
+		}
+		if writeMIMEError(c, err) {
+			return
+		}
+		if writeQuotaExceeded(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+}
+
+// ExportSessionBundle godoc
+//
+//	@Summary		Export session bundle
+//	@Description	Download the whole session, branches included, as a zip bundle for backup or transfer. The archive holds `messages.json` (session settings, messages and parts; asset parts name their file in `asset_path`), every referenced asset under `assets/`, and `manifest.json` listing the SHA-256 and size of each file. The archive is streamed; restore it with the bundle import endpoint.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		application/zip
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{file}		binary	"Session bundle"
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/bundle [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Download a self-contained backup of the session\ndata = client.sessions.export_bundle(session_id='session-uuid')\nwith open('session.zip', 'wb') as f:\n    f.write(data)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\nimport fs from 'fs';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Download a self-contained backup of the session\nconst data = await client.sessions.exportBundle('session-uuid');\nfs.writeFileSync('session.zip', Buffer.from(data));\n","label":"JavaScript"}]
+func (h *SessionHandler) ExportSessionBundle(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	b, err := h.svc.ExportSessionBundle(c.Request.Context(), service.ExportSessionBundleInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%s.zip"`, sessionID))
+	c.Status(http.StatusOK)
+	if err := b.Write(c.Request.Context(), c.Writer); err != nil {
+		// The status line is already sent; the client sees a truncated archive.
+		_ = c.Error(err)
+	}
+}
+
+type ExportProjectReq struct {
+	Cursor         string `form:"cursor" json:"cursor" example:"ZXhwb3J0fDE3MDAwMDAwMDAwMDAwMDAwMDB8..."`
+	Assets         string `form:"assets,default=url" json:"assets" binding:"omitempty,oneof=url inline" example:"url" enums:"url,inline"`
+	IncludeDeleted bool   `form:"include_deleted,default=false" json:"include_deleted" example:"false"`
+}
+
+// ExportProject godoc
+//
+//	@Summary		Export project
+//	@Description	Stream every session of the project and its messages as NDJSON, for data portability and erasure requests. Each line is a record: a `session` record is followed by a `message` record for each of its messages in order, and a final `end` record marks a complete export. Message records carry the parts, with a manifest of the assets they reference: download URLs (`assets=url`, valid for 24 hours) or the base64 content inline (`assets=inline`). Templates and archived sessions are included; soft-deleted sessions and messages only with `include_deleted`, marked by `deleted_at`. Every record but `end` carries a `cursor`: pass the last one received to resume an interrupted download after it. A failure after the stream has started is reported in an `error` record.
+//	@Tags			Project
+//	@Produce		application/x-ndjson
+//	@Param			cursor			query	string	false	"Cursor of the last record received, to resume after it"
+//	@Param			assets			query	string	false	"Asset manifest mode, default url"	Enums(url, inline)
+//	@Param			include_deleted	query	bool	false	"Include soft-deleted sessions and messages"
+//	@Security		BearerAuth
+//	@Success		200	{object}	service.ExportRecord	"One record per line"
+//	@Failure		400	{object}	serializer.Response	"Invalid request or cursor"
+//	@Router			/project/export [get]
+//	@x-code-samples	[{"lang":"python","source":"import json\nfrom acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Stream the whole project, resuming after the last record on interruption\ncursor = None\nwith open('export.ndjson', 'a') as f:\n    for record in client.project.export(cursor=cursor, assets='url'):\n        f.write(json.dumps(record) + '\\n')\n        cursor = record.get('cursor')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Stream the whole project, resuming after the last record on interruption\nlet cursor;\nfor await (const record of client.project.export({ cursor, assets: 'url' })) {\n  cursor = record.cursor ?? cursor;\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) ExportProject(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	req := ExportProjectReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	// Headers go out with the first record, so errors before it still get a JSON response.
+	enc := json.NewEncoder(c.Writer)
+	started := false
+	err := h.svc.ExportProject(c.Request.Context(), service.ExportProjectInput{
+		ProjectID:      project.ID,
+		Cursor:         req.Cursor,
+		Assets:         service.ExportAssetMode(req.Assets),
+		IncludeDeleted: req.IncludeDeleted,
+		AssetExpire:    time.Hour * 24,
+		UserKEK:        middleware.GetUserKEKIfEncrypted(c),
+	}, func(rec *service.ExportRecord) error {
+		if !started {
+			started = true
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="project-%s.ndjson"`, project.ID))
+			c.Status(http.StatusOK)
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil {
+		return
+	}
+	if !started {
+		if errors.Is(err, paging.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid cursor", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+	// The status line is already sent; the missing end record tells the client to resume.
+	_ = c.Error(err)
+	_ = enc.Encode(gin.H{"type": "error", "error": err.Error()})
+}
+
+// ImportSessionBundle godoc
+//
+//	@Summary		Import session bundle
+//	@Description	Restore a zip bundle produced by the bundle export as a new session, with its settings, messages and branches. Every file is verified against the manifest checksums. Assets are uploaded again, except content the project already stores, which is linked instead.
+//	@Tags			session
+//	@Accept			mpfd
+//	@Produce		json
+//	@Param			bundle	formData	file	true	"Session bundle zip"
+//	@Security		BearerAuth
+//	@Success		201	{object}	serializer.Response{data=service.ImportSessionOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid bundle"
+//	@Failure		413	{object}	serializer.Response{data=service.QuotaExceededError}	"Storage quota exceeded"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"Parts are inconsistent with their types, or a part's declared media type disagrees with its content (MIME_MISMATCH)"
+//	@Router			/session/import/bundle [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\nfrom acontext.uploads import FileUpload\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Restore a session from a bundle\nwith open('session.zip', 'rb') as f:\n    imported = client.sessions.import_bundle(\n        bundle=FileUpload(filename='session.zip', content=f.read(), content_type='application/zip')\n    )\nprint(imported.session.id)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\nimport fs from 'fs';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Restore a session from a bundle\nconst imported = await client.sessions.importBundle({\n  bundle: ['session.zip', fs.readFileSync('session.zip'), 'application/zip']\n});\nconsole.log(imported.session.id);\n","label":"JavaScript"}]
+func (h *SessionHandler) ImportSessionBundle(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	fh, err := c.FormFile("bundle")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("bundle is required")))
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("failed to read bundle", err))
+		return
+	}
+	defer f.Close()
+
+	out, err := h.svc.ImportSessionBundle(c.Request.Context(), service.ImportSessionBundleInput{
+		ProjectID: project.ID,
+		Bundle:    f,
+		Size:      fh.Size,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidImport) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid bundle", err))
+			return
+		}
+		if writeInvalidParts(c, err) {
+			return
+		}
+		if writeMIMEError(c, err) {
+			return
+		}
+		if writeQuotaExceeded(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+}
+
+// DeleteMessage godoc
+//
+//	@Summary		Delete message
+//	@Description	Soft-delete a message. It disappears from listings but can be restored until it is purged after the retention window.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		409	{object}	serializer.Response	"Message is shared with a shallow clone"
+//	@Router			/session/{session_id}/messages/{message_id} [delete]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Soft-delete a message\nclient.sessions.delete_message(session_id='session-uuid', message_id='message-uuid')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Soft-delete a message\nawait client.sessions.deleteMessage('session-uuid', 'message-uuid');\n","label":"JavaScript"}]
+func (h *SessionHandler) DeleteMessage(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	if err := h.svc.DeleteMessage(c.Request.Context(), project.ID, sessionID, messageID, override); err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+			return
+		}
+		if writeMessageShared(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
+type DeleteMessagesReq struct {
+	Roles   []string `form:"role" json:"role" example:"user"`
+	Since   string   `form:"since" json:"since" example:"2025-01-01T00:00:00Z"`
+	Until   string   `form:"until" json:"until" example:"2025-02-01T00:00:00Z"`
+	Flagged *bool    `form:"flagged" json:"flagged" example:"true"`
+	Cascade bool     `form:"cascade,default=false" json:"cascade" example:"false"`
+}
+
+// DeleteMessages godoc
+//
+//	@Summary		Delete messages by filter
+//	@Description	Soft-delete every message of the session matching the filter, in one transaction. A message must match all filters given, and at least one is required. Children of deleted messages are moved to the nearest surviving ancestor, or deleted with them when cascade=true. Connected stream clients receive message.deleted and message.reparented events.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string		true	"Session ID"	format(uuid)
+//	@Param			role		query	[]string	false	"Delete only messages stored with these roles (tool results are stored as user)"	collectionFormat(multi)
+//	@Param			since		query	string		false	"Delete only messages created at or after this RFC3339 time"	example(2025-01-01T00:00:00Z)
+//	@Param			until		query	string		false	"Delete only messages created at or before this RFC3339 time"	example(2025-02-01T00:00:00Z)
+//	@Param			flagged		query	boolean		false	"Delete only flagged (true) or unflagged (false) messages"
+//	@Param			cascade		query	boolean		false	"Delete the descendants of matching messages instead of reparenting them"	default(false)
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=repo.DeleteMessagesResult}
+//	@Failure		400	{object}	serializer.Response	"Invalid or empty filter"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"A matching message is shared with a shallow clone"
+//	@Router			/session/{session_id}/messages [delete]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Delete tool messages older than 30 days\nresult = client.sessions.delete_messages(session_id='session-uuid', role=['user'], until='2025-01-01T00:00:00Z')\nprint(result.deleted)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Delete flagged messages and their replies\nconst result = await client.sessions.deleteMessages('session-uuid', { flagged: true, cascade: true });\nconsole.log(result.deleted);\n","label":"JavaScript"}]
+func (h *SessionHandler) DeleteMessages(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	req := DeleteMessagesReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	for _, role := range req.Roles {
+		if !model.IsStoredRole(role) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", fmt.Errorf("unknown role %q, expected one of %s", role, strings.Join(model.StoredRoles, ", "))))
+			return
+		}
+	}
+	since, until, err := parseCreatedRange(req.Since, req.Until)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	out, err := h.svc.DeleteMessages(c.Request.Context(), service.DeleteMessagesInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Roles:     req.Roles,
+		Since:     since,
+		Until:     until,
+		Flagged:   req.Flagged,
+		Cascade:   req.Cascade,
+		Override:  override,
+	})
+	if err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrEmptyMessageFilter) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if writeMessageShared(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+// RestoreMessage godoc
+//
+//	@Summary		Restore message
+//	@Description	Restore a soft-deleted message that has not been purged yet.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/restore [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Restore a soft-deleted message\nclient.sessions.restore_message(session_id='session-uuid', message_id='message-uuid')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Restore a soft-deleted message\nawait client.sessions.restoreMessage('session-uuid', 'message-uuid');\n","label":"JavaScript"}]
+func (h *SessionHandler) RestoreMessage(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	if err := h.svc.RestoreMessage(c.Request.Context(), project.ID, sessionID, messageID, override); err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
+// PinMessage godoc
+//
+//	@Summary		Pin message
+//	@Description	Pin a message so the system-pinned context strategy always includes it in built prompts, whatever the token budget. Pinning does not change the message's position or its children.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/pin [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Keep a key instruction in every built prompt\nclient.sessions.pin_message(session_id='session-uuid', message_id='message-uuid')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Keep a key instruction in every built prompt\nawait client.sessions.pinMessage('session-uuid', 'message-uuid');\n","label":"JavaScript"}]
+func (h *SessionHandler) PinMessage(c *gin.Context) {
+	h.setMessagePinned(c, true)
+}
+
+// UnpinMessage godoc
+//
+//	@Summary		Unpin message
+//	@Description	Unpin a message. The message stays where it is, and its children are not affected.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/pin [delete]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Stop forcing a message into built prompts\nclient.sessions.unpin_message(session_id='session-uuid', message_id='message-uuid')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Stop forcing a message into built prompts\nawait client.sessions.unpinMessage('session-uuid', 'message-uuid');\n","label":"JavaScript"}]
+func (h *SessionHandler) UnpinMessage(c *gin.Context) {
+	h.setMessagePinned(c, false)
+}
+
+func (h *SessionHandler) setMessagePinned(c *gin.Context, pinned bool) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	if err := h.svc.SetMessagePinned(c.Request.Context(), project.ID, sessionID, messageID, pinned); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		if errors.Is(err, service.ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
+type GetPinnedMessagesResp struct {
+	Items []model.Message `json:"items"`
+}
+
+// GetPinnedMessages godoc
+//
+//	@Summary		List pinned messages
+//	@Description	List the session's pinned messages with their parts, oldest first.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.GetPinnedMessagesResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/pinned [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# List pinned messages\npinned = client.sessions.get_pinned_messages(session_id='session-uuid')\nfor message in pinned.items:\n    print(message.id, message.role)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// List pinned messages\nconst pinned = await client.sessions.getPinnedMessages('session-uuid');\nfor (const message of pinned.items) {\n  console.log(message.id, message.role);\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) GetPinnedMessages(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	items, err := h.svc.ListPinnedMessages(c.Request.Context(), project.ID, sessionID, middleware.GetUserKEKIfEncrypted(c))
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: GetPinnedMessagesResp{Items: items}})
+}
+
+type SetMessageFlagReq struct {
+	Flagged *bool `json:"flagged" binding:"required" example:"false"`
+	// Reason is stored as the message's flag_reason when flagged is true.
+	Reason string `json:"reason" example:"contains blocked term"`
+	// Note explains the change in the message's flag audit trail.
+	Note string `json:"note" binding:"required" example:"False positive: quoted from the policy document"`
+}
+
+// SetMessageFlag godoc
+//
+//	@Summary		Override message moderation flag
+//	@Description	Set or clear the moderation flag of a message, e.g. to clear a false positive. Flagged messages are stored and listed as usual but left out of built context. Every change is recorded, with the flag it replaces and the note, in the message's flag audit trail.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string						true	"Message ID"	format(uuid)
+//	@Param			payload		body	handler.SetMessageFlagReq	true	"SetMessageFlag payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.MessageFlagAudit}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/flag [put]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Clear a false positive\naudit = client.sessions.set_message_flag(\n    session_id='session-uuid',\n    message_id='message-uuid',\n    flagged=False,\n    note='Quoted from the policy document'\n)\nprint(audit.previous_flag_reason)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Clear a false positive\nconst audit = await client.sessions.setMessageFlag('session-uuid', 'message-uuid', {\n  flagged: false,\n  note: 'Quoted from the policy document'\n});\nconsole.log(audit.previous_flag_reason);\n","label":"JavaScript"}]
+func (h *SessionHandler) SetMessageFlag(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	req := SetMessageFlagReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	audit, err := h.svc.SetMessageFlag(c.Request.Context(), service.SetMessageFlagInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		Flagged:   *req.Flagged,
+		Reason:    req.Reason,
+		Note:      req.Note,
+	})
+	if err != nil {
+		writeMessageFlagErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: audit})
+}
+
+type GetMessageFlagAuditsResp struct {
+	Items []model.MessageFlagAudit `json:"items"`
+}
+
+// GetMessageFlagAudits godoc
+//
+//	@Summary		Get message flag audit trail
+//	@Description	List the overrides of a message's moderation flag, oldest first.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.GetMessageFlagAuditsResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/messages/{message_id}/flag/audits [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Review who changed a flag and why\naudits = client.sessions.get_message_flag_audits(session_id='session-uuid', message_id='message-uuid')\nfor audit in audits.items:\n    print(audit.created_at, audit.flagged, audit.note)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Review who changed a flag and why\nconst audits = await client.sessions.getMessageFlagAudits('session-uuid', 'message-uuid');\nfor (const audit of audits.items) {\n  console.log(audit.created_at, audit.flagged, audit.note);\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) GetMessageFlagAudits(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	items, err := h.svc.ListMessageFlagAudits(c.Request.Context(), project.ID, sessionID, messageID)
+	if err != nil {
+		writeMessageFlagErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: GetMessageFlagAuditsResp{Items: items}})
+}
+
+func writeMessageFlagErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+	case errors.Is(err, service.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+	default:
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+	}
+}
+
+type AddMessageAnnotationReq struct {
+	Kind string `json:"kind" binding:"required" example:"reaction" enums:"reaction,label,note"`
+	// Value is the reaction (thumbs_up or thumbs_down) or the label; it is empty for a note.
+	Value string `json:"value" example:"thumbs_up"`
+	// Note is the text of a note, or an optional comment on a reaction or label.
+	Note       string `json:"note" example:"Answer cites the wrong policy"`
+	AuthorID   string `json:"author_id" format:"uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	AuthorType string `json:"author_type" example:"user" enums:"user,agent,system"`
+}
+
+// AddMessageAnnotation godoc
+//
+//	@Summary		Annotate message
+//	@Description	Attach feedback to a message: a `reaction` (value `thumbs_up` or `thumbs_down`), a `label` (any value up to 64 bytes) or a free-text `note`. Reactions and labels can carry a note as a comment. A message can have any number of annotations; author_id and author_type optionally record who left it. Annotations do not change the message or its updated_at, and are deleted with it.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string							true	"Message ID"	format(uuid)
+//	@Param			payload		body	handler.AddMessageAnnotationReq	true	"AddMessageAnnotation payload"
+//	@Security		BearerAuth
+//	@Success		201	{object}	serializer.Response{data=model.MessageAnnotation}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/annotations [post]
+func (h *SessionHandler) AddMessageAnnotation(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	req := AddMessageAnnotationReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	authorID, err := parseAuthorID(req.AuthorID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid author_id", err))
+		return
+	}
+
+	annotation, err := h.svc.AddMessageAnnotation(c.Request.Context(), service.AddMessageAnnotationInput{
+		ProjectID:  project.ID,
+		SessionID:  sessionID,
+		MessageID:  messageID,
+		Kind:       req.Kind,
+		Value:      req.Value,
+		Note:       req.Note,
+		AuthorID:   authorID,
+		AuthorType: req.AuthorType,
+	})
+	if err != nil {
+		writeMessageAnnotationErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, serializer.Response{Data: annotation})
+}
+
+type GetMessageAnnotationsResp struct {
+	Items []model.MessageAnnotation `json:"items"`
+}
+
+// GetMessageAnnotations godoc
+//
+//	@Summary		List message annotations
+//	@Description	List the reactions, labels and notes of a message, oldest first.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.GetMessageAnnotationsResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/messages/{message_id}/annotations [get]
+func (h *SessionHandler) GetMessageAnnotations(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	items, err := h.svc.ListMessageAnnotations(c.Request.Context(), project.ID, sessionID, messageID)
+	if err != nil {
+		writeMessageAnnotationErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: GetMessageAnnotationsResp{Items: items}})
+}
+
+// DeleteMessageAnnotation godoc
+//
+//	@Summary		Delete message annotation
+//	@Description	Remove one annotation from a message.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id		path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id		path	string	true	"Message ID"	format(uuid)
+//	@Param			annotation_id	path	string	true	"Annotation ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or annotation not found"
+//	@Router			/session/{session_id}/messages/{message_id}/annotations/{annotation_id} [delete]
+func (h *SessionHandler) DeleteMessageAnnotation(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	annotationID, err := uuid.Parse(c.Param("annotation_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid annotation_id", err))
+		return
+	}
+
+	if err := h.svc.DeleteMessageAnnotation(c.Request.Context(), project.ID, sessionID, messageID, annotationID); err != nil {
+		writeMessageAnnotationErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
+type GetReactionCountsResp struct {
+	// Counts maps each reaction to the number of times the session's messages received it.
+	Counts map[string]int64 `json:"counts"`
+}
+
+// GetReactionCounts godoc
+//
+//	@Summary		Count session reactions
+//	@Description	Count the reaction annotations on the session's messages by reaction. Every reaction is listed, with 0 when no message received it. Deleted messages are not counted.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.GetReactionCountsResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/{session_id}/annotations/reactions [get]
+func (h *SessionHandler) GetReactionCounts(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	counts, err := h.svc.CountSessionReactions(c.Request.Context(), project.ID, sessionID)
+	if err != nil {
+		writeMessageAnnotationErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: GetReactionCountsResp{Counts: counts}})
+}
+
+func writeMessageAnnotationErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidAnnotation), errors.Is(err, service.ErrInvalidAuthor):
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+	case errors.Is(err, service.ErrAnnotationNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "ANNOTATION_NOT_FOUND", err))
+	default:
+		writeMessageFlagErr(c, err)
+	}
+}
+
+type UpdateMessagePartsReq struct {
+	Parts []service.PartIn `json:"parts" binding:"required,min=1"`
+	// Optional tiktoken encoding used for the stored token count (default o200k_base)
+	Tokenizer string `json:"tokenizer" example:"cl100k_base"`
+}
+
+// UpdateMessageParts godoc
+//
+//	@Summary		Edit message parts
+//	@Description	Replace a message's parts, keeping the previous parts as a revision. Parts use the acontext format. Supports JSON and multipart/form-data; in multipart mode the request is a JSON string in the `payload` field and file parts name their upload with `file_field`. The message keeps its role, meta, creation time, parent and children. Streaming messages cannot be edited until finalized. Send the message's `version` in If-Match to reject the edit when someone else edited the message first; the response ETag carries the new version.
+//	@Tags			session
+//	@Accept			json,mpfd
+//	@Produce		json
+//	@Param			session_id	path		string							true	"Session ID"	format(uuid)
+//	@Param			message_id	path		string							true	"Message ID"	format(uuid)
+//	@Param			payload		body		handler.UpdateMessagePartsReq	true	"New parts"
+//	@Param			If-Match	header		string							false	"Message version the edit is based on"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.Message}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		409	{object}	serializer.Response{data=service.VersionConflictError}	"Message is still streaming, is past the If-Match version, or is shared with a shallow clone (MESSAGE_SHARED)"
+//	@Failure		413	{object}	serializer.Response{data=service.QuotaExceededError}	"Storage quota exceeded"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"Parts are inconsistent with their types or of a type the session does not allow (INVALID_PARTS), tool-call arguments violate their registered schema (INVALID_TOOL_ARGUMENTS, data=handler.InvalidToolArgumentsResp), or a part's declared media type disagrees with its content (MIME_MISMATCH)"
+//	@Router			/session/{session_id}/messages/{message_id}/parts [put]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Replace the parts of a message; the old parts become a revision\nmessage = client.sessions.update_message_parts(\n    session_id='session-uuid',\n    message_id='message-uuid',\n    parts=[{'type': 'text', 'text': 'Corrected answer'}]\n)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Replace the parts of a message; the old parts become a revision\nconst message = await client.sessions.updateMessageParts('session-uuid', 'message-uuid', {\n  parts: [{ type: 'text', text: 'Corrected answer' }]\n});\n","label":"JavaScript"}]
+func (h *SessionHandler) UpdateMessageParts(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+	expectedVersion, err := messageVersionFromIfMatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	req := UpdateMessagePartsReq{}
+	multipartReq := strings.HasPrefix(c.ContentType(), "multipart/form-data")
+	if multipartReq {
+		if err := sonic.Unmarshal([]byte(c.PostForm("payload")), &req); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid payload json", err))
+			return
+		}
+		if len(req.Parts) == 0 {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("parts must not be empty")))
+			return
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	if req.Tokenizer != "" {
+		if err := tokenizer.ValidateEncoding(req.Tokenizer); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid tokenizer", err))
+			return
+		}
+	}
+
+	fileMap := map[string]*multipart.FileHeader{}
+	for i := range req.Parts {
+		if err := req.Parts[i].Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr(fmt.Sprintf("invalid parts[%d]", i), err))
+			return
+		}
+		field := req.Parts[i].FileField
+		if field == "" {
+			continue
+		}
+		if !multipartReq {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", fmt.Errorf("parts[%d]: file parts require multipart/form-data", i)))
+			return
+		}
+		fh, err := c.FormFile(field)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr(fmt.Sprintf("missing file %s", field), err))
+			return
+		}
+		fileMap[field] = fh
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	msg, err := h.svc.UpdateMessageParts(c.Request.Context(), service.UpdateMessagePartsInput{
+		ProjectID:       project.ID,
+		SessionID:       sessionID,
+		MessageID:       messageID,
+		Parts:           req.Parts,
+		Files:           fileMap,
+		UserKEK:         middleware.GetUserKEKIfEncrypted(c),
+		TokenEncoding:   req.Tokenizer,
+		ExpectedVersion: expectedVersion,
+		Override:        override,
+	})
+	if err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		if writeInvalidParts(c, err) {
+			return
+		}
+		if writeMIMEError(c, err) {
+			return
+		}
+		if writeInvalidToolArguments(c, err) {
+			return
+		}
+		if writeQuotaExceeded(c, err) {
+			return
+		}
+		if writeVersionConflict(c, err) {
+			return
+		}
+		if writeMessageShared(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageStreaming):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_STREAMING", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	setMessageETag(c, msg.Version)
+	c.JSON(http.StatusOK, serializer.Response{Data: msg})
+}
+
+// PatchMessageParts godoc
+//
+//	@Summary		Patch message parts
+//	@Description	Apply an RFC 6902 JSON Patch document to a message's parts array, in the acontext format, for targeted edits such as replacing one part's text (`{"op":"replace","path":"/0/text","value":"..."}`) or removing a part (`{"op":"remove","path":"/2"}`) without resending every part. The patch is applied atomically and the result must be valid parts; it may move or drop asset parts but not reference new assets. The previous parts are kept as a revision. Without the `messages:read_sensitive` scope, operations may not read, test or change parts marked sensitive, nor their sensitive flag, though parts may be inserted next to them. Send the message's `version` in If-Match to reject the patch when someone else edited the message first; the response ETag carries the new version.
+//	@Tags			session
+//	@Accept			json-patch+json
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path		string	true	"Message ID"	format(uuid)
+//	@Param			payload		body		[]object	true	"JSON Patch operations"
+//	@Param			If-Match	header		string	false	"Message version the patch is based on"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.Message}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		403	{object}	serializer.Response	"An operation addresses a sensitive part without the messages:read_sensitive scope (PATCH_SENSITIVE)"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		409	{object}	serializer.Response{data=service.VersionConflictError}	"A test operation failed (PATCH_TEST_FAILED), or the message is still streaming, is past the If-Match version, or is shared with a shallow clone (MESSAGE_SHARED)"
+//	@Failure		413	{object}	serializer.Response{data=service.QuotaExceededError}	"Storage quota exceeded"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"The patch cannot be applied (INVALID_PATCH), the patched parts are inconsistent with their types or of a type the session does not allow (INVALID_PARTS), or tool-call arguments violate their registered schema (INVALID_TOOL_ARGUMENTS, data=handler.InvalidToolArgumentsResp)"
+//	@Router			/session/{session_id}/messages/{message_id}/parts [patch]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Fix the text of the first part and drop the third\nmessage = client.sessions.patch_message_parts(\n    session_id='session-uuid',\n    message_id='message-uuid',\n    patch=[\n        {'op': 'replace', 'path': '/0/text', 'value': 'Corrected answer'},\n        {'op': 'remove', 'path': '/2'},\n    ]\n)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Fix the text of the first part and drop the third\nconst message = await client.sessions.patchMessageParts('session-uuid', 'message-uuid', [\n  { op: 'replace', path: '/0/text', value: 'Corrected answer' },\n  { op: 'remove', path: '/2' },\n]);\n","label":"JavaScript"}]
+func (h *SessionHandler) PatchMessageParts(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+	expectedVersion, err := messageVersionFromIfMatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	if ct := c.ContentType(); ct != "application/json-patch+json" && ct != "application/json" {
+		c.JSON(http.StatusUnsupportedMediaType, serializer.ParamErr("", fmt.Errorf("unsupported content type %q, expected application/json-patch+json", ct)))
+		return
+	}
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+	if _, err := jsonpatch.Decode(patch); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return
+	}
+
+	msg, err := h.svc.PatchMessageParts(c.Request.Context(), service.PatchMessagePartsInput{
+		ProjectID:       project.ID,
+		SessionID:       sessionID,
+		MessageID:       messageID,
+		Patch:           patch,
+		UserKEK:         middleware.GetUserKEKIfEncrypted(c),
+		ExpectedVersion: expectedVersion,
+		Override:        override,
+	})
+	if err != nil {
+		if writeImmutable(c, err) {
+			return
+		}
+		if writeInvalidParts(c, err) {
+			return
+		}
+		if writeInvalidToolArguments(c, err) {
+			return
+		}
+		if writeQuotaExceeded(c, err) {
+			return
+		}
+		if writeVersionConflict(c, err) {
+			return
+		}
+		if writeMessageShared(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrInvalidPatch):
+			c.JSON(http.StatusUnprocessableEntity, serializer.Err(http.StatusUnprocessableEntity, "INVALID_PATCH", err))
+		case errors.Is(err, service.ErrPatchTestFailed):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "PATCH_TEST_FAILED", err))
+		case errors.Is(err, service.ErrPatchSensitive):
+			c.JSON(http.StatusForbidden, serializer.Err(http.StatusForbidden, "PATCH_SENSITIVE", err))
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageStreaming):
+			c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_STREAMING", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	setMessageETag(c, msg.Version)
+	c.JSON(http.StatusOK, serializer.Response{Data: msg})
+}
+
+// GetMessageRevisions godoc
+//
+//	@Summary		Get message revisions
+//	@Description	List the previous versions of a message's parts, newest first. Each edit adds one revision holding the parts it replaced; a message that was never edited has none.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=[]model.MessageRevision}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/revisions [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# List earlier versions of a message, newest first\nrevisions = client.sessions.get_message_revisions(session_id='session-uuid', message_id='message-uuid')\nfor rev in revisions:\n    print(rev.version, rev.parts)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// List earlier versions of a message, newest first\nconst revisions = await client.sessions.getMessageRevisions('session-uuid', 'message-uuid');\nrevisions.forEach((rev) => console.log(rev.version, rev.parts));\n","label":"JavaScript"}]
+func (h *SessionHandler) GetMessageRevisions(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	revs, err := h.svc.GetMessageRevisions(c.Request.Context(), service.GetMessageRevisionsInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		UserKEK:   middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: revs})
+}
+
+type GetMessagePartsReq struct {
+	Type string `form:"type" json:"type" binding:"required,oneof=text image audio video file tool-call tool-result data thinking redacted_thinking" example:"image"`
+}
+
+// GetMessageParts godoc
+//
+//	@Summary		Get message parts by type
+//	@Description	Return only the parts of the given type from a message, in order. Media parts (image, audio, video, file) come with material URLs for their assets in public_urls, keyed by asset SHA256. A message with no parts of the type returns an empty list.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string	true	"Message ID"	format(uuid)
+//	@Param			type			query	string	true	"Part type"	Enums(text, image, audio, video, file, tool-call, tool-result, data, thinking, redacted_thinking)
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=service.GetMessagePartsOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Router			/session/{session_id}/messages/{message_id}/parts [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Collect the images of a message for a gallery\nresult = client.sessions.get_message_parts(session_id='session-uuid', message_id='message-uuid', type='image')\nfor part in result.items:\n    print(result.public_urls[part.asset.sha256].url)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Collect the images of a message for a gallery\nconst result = await client.sessions.getMessageParts('session-uuid', 'message-uuid', { type: 'image' });\nresult.items.forEach((part) => console.log(result.public_urls[part.asset.sha256].url));\n","label":"JavaScript"}]
+func (h *SessionHandler) GetMessageParts(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+	req := GetMessagePartsReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	out, err := h.svc.GetMessageParts(c.Request.Context(), service.GetMessagePartsInput{
+		ProjectID:   project.ID,
+		SessionID:   sessionID,
+		MessageID:   messageID,
+		Type:        model.PartType(req.Type),
+		AssetExpire: time.Hour * 24,
+		UserKEK:     middleware.GetUserKEKIfEncrypted(c),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+		case errors.Is(err, service.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+		default:
+			c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+type MessageAssetsReq struct {
+	AssetIDs []uuid.UUID `json:"asset_ids" binding:"required,min=1"`
+}
+
+// AssetsNotFoundResp lists the requested asset IDs that do not exist in the project.
+type AssetsNotFoundResp struct {
+	AssetIDs []uuid.UUID `json:"asset_ids"`
+}
+
+// AttachAssets godoc
+//
+//	@Summary		Attach assets to a message
+//	@Description	Link stored assets, such as confirmed presigned uploads, to an existing message. Each asset becomes a media part appended to the message, typed from its MIME type (image, audio, video, otherwise file). Assets the message already links are skipped. The previous parts are kept as a revision. Not available for encrypted projects.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path		string						true	"Message ID"	format(uuid)
+//	@Param			payload		body		handler.MessageAssetsReq	true	"Assets to attach"
+//	@Param			If-Match	header		string						false	"Message version the edit is based on"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.Message}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response{data=handler.AssetsNotFoundResp}	"Session, message or assets not found"
+//	@Failure		409	{object}	serializer.Response{data=service.VersionConflictError}	"Message is streaming, was edited concurrently or is past the If-Match version"
+//	@Failure		413	{object}	serializer.Response{data=service.QuotaExceededError}	"Storage quota exceeded"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"The session does not allow the resulting part types"
+//	@Router			/session/{session_id}/messages/{message_id}/assets [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Link uploaded assets to an existing message\nmessage = client.sessions.attach_assets(\n    session_id='session-uuid',\n    message_id='message-uuid',\n    asset_ids=['asset-uuid-1', 'asset-uuid-2']\n)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Link uploaded assets to an existing message\nconst message = await client.sessions.attachAssets('session-uuid', 'message-uuid', {\n  assetIds: ['asset-uuid-1', 'asset-uuid-2']\n});\n","label":"JavaScript"}]
+func (h *SessionHandler) AttachAssets(c *gin.Context) {
+	in, ok := bindMessageAssets(c)
+	if !ok {
+		return
+	}
+	msg, err := h.svc.AttachAssets(c.Request.Context(), in)
+	if err != nil {
+		writeMessageAssetsErr(c, err)
+		return
+	}
+	setMessageETag(c, msg.Version)
+	c.JSON(http.StatusOK, serializer.Response{Data: msg})
+}
+
+// DetachAssets godoc
+//
+//	@Summary		Detach assets from a message
+//	@Description	Remove the media parts that link the given assets from a message. The assets are not deleted: the previous parts are kept as a revision, and unreferenced assets are removed by orphan collection. Not available for encrypted projects.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path		string						true	"Message ID"	format(uuid)
+//	@Param			payload		body		handler.MessageAssetsReq	true	"Assets to detach"
+//	@Param			If-Match	header		string						false	"Message version the edit is based on"
+//	@Param			X-Immutability-Override	header	string	false	"Reason for an admin override of session finalization or the message immutability window, which otherwise answer 409 (SESSION_FINALIZED, MESSAGE_IMMUTABLE); requires an admin token"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.Message}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response{data=handler.AssetsNotFoundResp}	"Session, message or assets not found"
+//	@Failure		409	{object}	serializer.Response{data=service.VersionConflictError}	"Message is streaming, was edited concurrently or is past the If-Match version"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"Remaining parts are inconsistent with their types"
+//	@Router			/session/{session_id}/messages/{message_id}/assets [delete]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Unlink assets from a message; the assets themselves are kept\nmessage = client.sessions.detach_assets(\n    session_id='session-uuid',\n    message_id='message-uuid',\n    asset_ids=['asset-uuid-1']\n)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Unlink assets from a message; the assets themselves are kept\nconst message = await client.sessions.detachAssets('session-uuid', 'message-uuid', {\n  assetIds: ['asset-uuid-1']\n});\n","label":"JavaScript"}]
+func (h *SessionHandler) DetachAssets(c *gin.Context) {
+	in, ok := bindMessageAssets(c)
+	if !ok {
+		return
+	}
+	msg, err := h.svc.DetachAssets(c.Request.Context(), in)
+	if err != nil {
+		writeMessageAssetsErr(c, err)
+		return
+	}
+	setMessageETag(c, msg.Version)
+	c.JSON(http.StatusOK, serializer.Response{Data: msg})
+}
+
+// bindMessageAssets reads the path and body of an attach or detach request, responding with 400
+// when they are invalid.
+func bindMessageAssets(c *gin.Context) (service.MessageAssetsInput, bool) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return service.MessageAssetsInput{}, false
+	}
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return service.MessageAssetsInput{}, false
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return service.MessageAssetsInput{}, false
+	}
+	expectedVersion, err := messageVersionFromIfMatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return service.MessageAssetsInput{}, false
+	}
+	req := MessageAssetsReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return service.MessageAssetsInput{}, false
+	}
+	override, ok := immutabilityOverride(c)
+	if !ok {
+		return service.MessageAssetsInput{}, false
+	}
+	return service.MessageAssetsInput{
+		ProjectID:       project.ID,
+		SessionID:       sessionID,
+		MessageID:       messageID,
+		AssetIDs:        req.AssetIDs,
+		ExpectedVersion: expectedVersion,
+		UserKEK:         middleware.GetUserKEKIfEncrypted(c),
+		Override:        override,
+	}, true
+}
+
+func writeMessageAssetsErr(c *gin.Context, err error) {
+	if writeImmutable(c, err) {
+		return
+	}
+	if writeInvalidParts(c, err) {
+		return
+	}
+	if writeQuotaExceeded(c, err) {
+		return
+	}
+	if writeVersionConflict(c, err) {
+		return
+	}
+	var missing *service.AssetsNotFoundError
+	if errors.As(err, &missing) {
+		resp := serializer.Err(http.StatusNotFound, "ASSET_NOT_FOUND", err)
+		resp.Data = AssetsNotFoundResp{AssetIDs: missing.AssetIDs}
+		c.JSON(http.StatusNotFound, resp)
+		return
+	}
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+	case errors.Is(err, service.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+	case errors.Is(err, service.ErrMessageStreaming):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_STREAMING", err))
+	case errors.Is(err, service.ErrMessagePartsChanged):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_PARTS_CHANGED", err))
+	case errors.Is(err, service.ErrAssetAttachEncrypted):
+		c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "ASSETS_ENCRYPTED", err))
+	default:
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+	}
+}
+
+type StartStreamingMessageReq struct {
+	Meta map[string]interface{} `json:"meta"` // Optional user-provided metadata for the message
+	// Optional author of the reply, e.g. the agent streaming it
+	AuthorID   string `json:"author_id" format:"uuid" example:"123e4567-e89b-12d3-a456-426614174000"`
+	AuthorType string `json:"author_type" example:"agent" enums:"user,agent,system"`
+}
+
+type AppendMessagePartReq struct {
+	Delta string `json:"delta" binding:"required" example:"Hello"`
+}
+
+type FinalizeMessageReq struct {
+	// Optional tiktoken encoding used for the stored token count (default o200k_base)
+	Tokenizer string `json:"tokenizer" example:"cl100k_base"`
+}
+
+// writeStreamingErr maps streaming service errors to HTTP responses.
+func writeStreamingErr(c *gin.Context, err error) {
+	if writeInvalidParts(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+	case errors.Is(err, service.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "MESSAGE_NOT_FOUND", err))
+	case errors.Is(err, service.ErrMessageNotStreaming):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "MESSAGE_NOT_STREAMING", err))
+	case errors.Is(err, service.ErrStreamingEncrypted):
+		c.JSON(http.StatusBadRequest, serializer.Err(http.StatusBadRequest, "STREAMING_ENCRYPTED", err))
+	case errors.Is(err, service.ErrInvalidAuthor):
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+	case errors.Is(err, service.ErrSessionArchived):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_ARCHIVED", err))
+	case errors.Is(err, service.ErrSessionFinalized):
+		c.JSON(http.StatusConflict, serializer.Err(http.StatusConflict, "SESSION_FINALIZED", err))
+	default:
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+	}
+}
+
+// StartStreamingMessage godoc
+//
+//	@Summary		Start streaming assistant message
+//	@Description	Create an empty assistant message for streaming. Append text deltas with the append endpoint, then finalize it. The message is not processed by the task pipeline until finalized. Not available for encrypted projects.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string							true	"Session ID"	format(uuid)
+//	@Param			payload		body	handler.StartStreamingMessageReq	false	"StartStreamingMessage payload"
+//	@Security		BearerAuth
+//	@Success		201	{object}	serializer.Response{data=model.Message}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Failure		409	{object}	serializer.Response	"Session is archived (SESSION_ARCHIVED)"
+//	@Failure		422	{object}	serializer.Response{data=handler.InvalidPartsResp}	"The session does not allow text parts"
+//	@Failure		429	{object}	serializer.Response	"Message rate limit exceeded; retry after the Retry-After header"
+//	@Router			/session/{session_id}/messages/stream [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Stream an assistant reply\nmsg = client.sessions.start_streaming_message(session_id='session-uuid')\nfor delta in ['Hel', 'lo!']:\n    client.sessions.append_message_part(session_id='session-uuid', message_id=msg.id, delta=delta)\nclient.sessions.finalize_message(session_id='session-uuid', message_id=msg.id)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Stream an assistant reply\nconst msg = await client.sessions.startStreamingMessage('session-uuid');\nfor (const delta of ['Hel', 'lo!']) {\n  await client.sessions.appendMessagePart('session-uuid', msg.id, delta);\n}\nawait client.sessions.finalizeMessage('session-uuid', msg.id);\n","label":"JavaScript"}]
+func (h *SessionHandler) StartStreamingMessage(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	// The body is optional
+	req := StartStreamingMessageReq{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+	}
+
+	var meta map[string]interface{}
+	if len(req.Meta) > 0 {
+		metaBytes, _ := json.Marshal(req.Meta)
+		if len(metaBytes) > MaxMetaSize {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("meta size exceeds 64KB limit", nil))
+			return
+		}
+		meta = map[string]interface{}{model.UserMetaKey: req.Meta}
+	}
+	authorID, err := parseAuthorID(req.AuthorID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid author_id", err))
+		return
+	}
+
+	out, err := h.svc.StartStreamingMessage(c.Request.Context(), service.StartStreamingMessageInput{
+		ProjectID:   project.ID,
+		SessionID:   sessionID,
+		MessageMeta: meta,
+		UserKEK:     middleware.GetUserKEKIfEncrypted(c),
+		AuthorID:    authorID,
+		AuthorType:  req.AuthorType,
+	})
+	if err != nil {
+		writeStreamingErr(c, err)
+		return
+	}
+
+	out.Meta = datatypes.NewJSONType(converter.ExtractUserMeta(out.Meta.Data()))
+	c.JSON(http.StatusCreated, serializer.Response{Data: out})
+}
+
+// AppendMessagePart godoc
+//
+//	@Summary		Append text delta to streaming message
+//	@Description	Append a text delta to the last text part of a streaming message. Concurrent appends to the same message are serialized and applied in arrival order.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string						true	"Message ID"	format(uuid)
+//	@Param			payload		body	handler.AppendMessagePartReq	true	"AppendMessagePart payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		409	{object}	serializer.Response	"Message is not streaming"
+//	@Router			/session/{session_id}/messages/{message_id}/append [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Append a text delta\nclient.sessions.append_message_part(session_id='session-uuid', message_id='message-uuid', delta='Hello')\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Append a text delta\nawait client.sessions.appendMessagePart('session-uuid', 'message-uuid', 'Hello');\n","label":"JavaScript"}]
+func (h *SessionHandler) AppendMessagePart(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	req := AppendMessagePartReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	if err := h.svc.AppendMessagePart(c.Request.Context(), service.AppendMessagePartInput{
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		Delta:     req.Delta,
+	}); err != nil {
+		writeStreamingErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{})
+}
+
+// FinalizeMessage godoc
+//
+//	@Summary		Finalize streaming message
+//	@Description	Mark a streaming message complete. Its buffered text becomes the message's text part, and the message is handed to the task pipeline like a regular message.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string						true	"Session ID"	format(uuid)
+//	@Param			message_id	path	string						true	"Message ID"	format(uuid)
+//	@Param			payload		body	handler.FinalizeMessageReq	false	"FinalizeMessage payload"
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=model.Message}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session or message not found"
+//	@Failure		409	{object}	serializer.Response	"Message is not streaming"
+//	@Router			/session/{session_id}/messages/{message_id}/finalize [post]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Finalize a streaming message\nmsg = client.sessions.finalize_message(session_id='session-uuid', message_id='message-uuid')\nprint(msg.parts)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Finalize a streaming message\nconst msg = await client.sessions.finalizeMessage('session-uuid', 'message-uuid');\nconsole.log(msg.parts);\n","label":"JavaScript"}]
+func (h *SessionHandler) FinalizeMessage(c *gin.Context) {
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+		return
+	}
+
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid message_id", err))
+		return
+	}
+
+	// The body is optional
+	req := FinalizeMessageReq{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+			return
+		}
+	}
+	if req.Tokenizer != "" {
+		if err := tokenizer.ValidateEncoding(req.Tokenizer); err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid tokenizer", err))
+			return
+		}
+	}
+
+	out, err := h.svc.FinalizeMessage(c.Request.Context(), service.FinalizeMessageInput{
+		ProjectID:     project.ID,
+		SessionID:     sessionID,
+		MessageID:     messageID,
+		TokenEncoding: req.Tokenizer,
+	})
+	if err != nil {
+		writeStreamingErr(c, err)
+		return
+	}
+
+	out.Meta = datatypes.NewJSONType(converter.ExtractUserMeta(out.Meta.Data()))
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
+
+// SearchMessages godoc
+//
+//	@Summary		Search messages
+//	@Description	Full-text search over the text parts of messages in the project, optionally scoped to one session. `sort=relevance` (default) orders results by text rank and `sort=recency` by creation time, newest first; `sort=blended` decays the rank with the message's age, halving it every configured half-life (a week by default), so recent matches can outrank older, stronger ones. Each result includes a highlighted snippet, its rank and the score it was ordered by. Messages in encrypted projects are not indexed.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			query		query	string	true	"Search query"	example(refund policy)
+//	@Param			session_id	query	string	false	"Restrict the search to this session"	format(uuid)
+//	@Param			sort		query	string	false	"Result order, default relevance"	Enums(relevance, recency, blended)
+//	@Param			limit		query	integer	false	"Maximum number of results, default 20. Max 200."
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=handler.SearchMessagesResp}
+//	@Failure		400	{object}	serializer.Response	"Invalid request"
+//	@Failure		404	{object}	serializer.Response	"Session not found"
+//	@Router			/session/search [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Search message text\nresults = client.sessions.search_messages(query='refund policy', limit=10)\nfor hit in results.items:\n    print(hit.session_id, hit.score, hit.snippet)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Search message text\nconst results = await client.sessions.searchMessages({ query: 'refund policy', limit: 10 });\nfor (const hit of results.items) {\n  console.log(hit.sessionId, hit.rank, hit.snippet);\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) SearchMessages(c *gin.Context) {
+	req := SearchMessagesReq{}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	in := service.SearchMessagesInput{
+		ProjectID: project.ID,
+		Query:     req.Query,
+		Sort:      repo.MessageSearchSort(req.Sort),
+		Limit:     req.Limit,
+	}
+	if req.SessionID != "" {
+		sessionID, err := uuid.Parse(req.SessionID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid session_id", err))
+			return
+		}
+		in.SessionID = &sessionID
+	}
+
+	items, err := h.svc.SearchMessages(c.Request.Context(), in)
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, serializer.Err(http.StatusNotFound, "SESSION_NOT_FOUND", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: SearchMessagesResp{Items: items}})
+}
+
+type SearchHistoryReq struct {
+	Query          string `form:"query" json:"query" binding:"required" example:"refund policy"`
+	User           string `form:"user" json:"user" example:"alice@acontext.io"`
+	Limit          int    `form:"limit,default=20" json:"limit" binding:"required,min=1,max=100" example:"20"`
+	HitsPerSession int    `form:"hits_per_session,default=3" json:"hits_per_session" binding:"required,min=1,max=20" example:"3"`
+	Cursor         string `form:"cursor" json:"cursor" example:"cHJvdGVjdGVkIHZlcnNpb24gdG8gYmUgZXhjbHVkZWQgaW4gcGFyc2luZyB0aGUgY3Vyc29y"`
+}
+
+// SearchHistory godoc
+//
+//	@Summary		Search history across sessions
+//	@Description	Full-text search over the text parts of messages in every session of the project, or only the sessions of one user, with results grouped by session. Sessions with the most recent matches come first; each carries its title (metadata.title), the number of matching messages and its best hits by rank. Messages in encrypted projects are not indexed.
+//	@Tags			session
+//	@Accept			json
+//	@Produce		json
+//	@Param			query				query	string	true	"Search query"	example(refund policy)
+//	@Param			user				query	string	false	"Only search sessions owned by this user identifier"	example(alice@acontext.io)
+//	@Param			limit				query	integer	false	"Sessions per page, default 20. Max 100."
+//	@Param			hits_per_session	query	integer	false	"Hits returned per session, default 3. Max 20."
+//	@Param			cursor				query	string	false	"Cursor for pagination. Use the cursor from the previous response to get the next page."
+//	@Security		BearerAuth
+//	@Success		200	{object}	serializer.Response{data=service.SearchHistoryOutput}
+//	@Failure		400	{object}	serializer.Response	"Invalid request or cursor"
+//	@Router			/session/search/history [get]
+//	@x-code-samples	[{"lang":"python","source":"from acontext import AcontextClient\n\nclient = AcontextClient(api_key='sk_project_token')\n\n# Search a user's history, grouped by session\nresults = client.sessions.search_history(query='refund policy', user='alice@acontext.io')\nfor group in results.items:\n    print(group.title or group.session_id, group.match_count)\n    for hit in group.hits:\n        print('  ', hit.snippet)\n","label":"Python"},{"lang":"javascript","source":"import { AcontextClient } from '@acontext/acontext';\n\nconst client = new AcontextClient({ apiKey: 'sk_project_token' });\n\n// Search a user's history, grouped by session\nconst results = await client.sessions.searchHistory({ query: 'refund policy', user: 'alice@acontext.io' });\nfor (const group of results.items) {\n  console.log(group.title || group.sessionId, group.matchCount);\n  for (const hit of group.hits) {\n    console.log('  ', hit.snippet);\n  }\n}\n","label":"JavaScript"}]
+func (h *SessionHandler) SearchHistory(c *gin.Context) {
+	req := SearchHistoryReq{}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", err))
+		return
+	}
+
+	project, ok := c.MustGet("project").(*model.Project)
+	if !ok {
+		c.JSON(http.StatusBadRequest, serializer.ParamErr("", errors.New("project not found")))
+		return
+	}
+
+	out, err := h.svc.SearchHistory(c.Request.Context(), service.SearchHistoryInput{
+		ProjectID:      project.ID,
+		User:           req.User,
+		Query:          req.Query,
+		Limit:          req.Limit,
+		HitsPerSession: req.HitsPerSession,
+		Cursor:         req.Cursor,
+	})
+	if err != nil {
+		if errors.Is(err, paging.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, serializer.ParamErr("invalid cursor", err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, serializer.DBErr("", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, serializer.Response{Data: out})
+}
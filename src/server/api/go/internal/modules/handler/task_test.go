@@ -44,6 +44,10 @@ func (m *MockSessionRepo) Create(ctx context.Context, s *model.Session) error {
 func (m *MockSessionRepo) Delete(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) error {
 	return m.Called(ctx, projectID, sessionID, userKEK).Error(0)
 }
+func (m *MockSessionRepo) DeleteSessionCascade(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) (*repo.DeleteSessionCascadeResult, error) {
+	args := m.Called(ctx, projectID, sessionID, userKEK)
+	return args.Get(0).(*repo.DeleteSessionCascadeResult), args.Error(1)
+}
 func (m *MockSessionRepo) Update(ctx context.Context, s *model.Session) error {
 	return m.Called(ctx, s).Error(0)
 }
@@ -58,19 +62,69 @@ func (m *MockSessionRepo) GetDisableTaskTracking(ctx context.Context, sessionID
 	args := m.Called(ctx, sessionID)
 	return args.Bool(0), args.Error(1)
 }
-func (m *MockSessionRepo) ListWithCursor(ctx context.Context, projectID uuid.UUID, userIdentifier string, filterByConfigs map[string]interface{}, afterCreatedAt time.Time, afterID uuid.UUID, limit int, timeDesc bool) ([]model.Session, error) {
-	args := m.Called(ctx, projectID, userIdentifier, filterByConfigs, afterCreatedAt, afterID, limit, timeDesc)
+func (m *MockSessionRepo) UpdateLabels(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, update func(s *model.Session) error) (*model.Session, error) {
+	args := m.Called(ctx, projectID, sessionID, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Session), args.Error(1)
+}
+
+func (m *MockSessionRepo) UpdateSummary(ctx context.Context, sessionID uuid.UUID, expectedMarker *uuid.UUID, summary string, marker uuid.UUID) error {
+	args := m.Called(ctx, sessionID, expectedMarker, summary, marker)
+	return args.Error(0)
+}
+func (m *MockSessionRepo) ListWithCursor(ctx context.Context, projectID uuid.UUID, userIdentifier string, filterByConfigs map[string]interface{}, filterByMetadata map[string]interface{}, tags []string, includeTemplates bool, includeArchived bool, afterAt time.Time, afterID uuid.UUID, limit int, timeDesc bool, orderBy repo.SessionOrder, createdIn repo.TimeRange) ([]model.Session, error) {
+	args := m.Called(ctx, projectID, userIdentifier, filterByConfigs, filterByMetadata, tags, includeTemplates, includeArchived, afterAt, afterID, limit, timeDesc, orderBy, createdIn)
 	return args.Get(0).([]model.Session), args.Error(1)
 }
-func (m *MockSessionRepo) CreateMessageWithAssets(ctx context.Context, msg *model.Message) error {
-	return m.Called(ctx, msg).Error(0)
+func (m *MockSessionRepo) SetTemplate(ctx context.Context, sessionID uuid.UUID, isTemplate bool) error {
+	return m.Called(ctx, sessionID, isTemplate).Error(0)
+}
+func (m *MockSessionRepo) GetMessageTreeStats(ctx context.Context, sessionID uuid.UUID, messageIDs []uuid.UUID) (map[uuid.UUID]model.MessageTreeStats, error) {
+	args := m.Called(ctx, sessionID, messageIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[uuid.UUID]model.MessageTreeStats), args.Error(1)
+}
+func (m *MockSessionRepo) SetSystemPrompt(ctx context.Context, sessionID uuid.UUID, prompt *string) error {
+	return m.Called(ctx, sessionID, prompt).Error(0)
+}
+func (m *MockSessionRepo) SetAllowedPartTypes(ctx context.Context, sessionID uuid.UUID, types []string) error {
+	return m.Called(ctx, sessionID, types).Error(0)
+}
+func (m *MockSessionRepo) ArchiveSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, key string, purge bool, userKEK []byte, store func(arc *repo.SessionArchive) error) (*model.Session, error) {
+	args := m.Called(ctx, projectID, sessionID, key, purge, userKEK, store)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Session), args.Error(1)
+}
+func (m *MockSessionRepo) RestoreSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, load func(key string) (*repo.SessionArchive, error)) (*repo.RestoreSessionResult, error) {
+	args := m.Called(ctx, projectID, sessionID, load)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.RestoreSessionResult), args.Error(1)
 }
-func (m *MockSessionRepo) ListBySessionWithCursor(ctx context.Context, sessionID uuid.UUID, afterCreatedAt time.Time, afterID uuid.UUID, limit int, timeDesc bool) ([]model.Message, error) {
-	args := m.Called(ctx, sessionID, afterCreatedAt, afterID, limit, timeDesc)
+func (m *MockSessionRepo) CreateMessageWithAssets(ctx context.Context, msg *model.Message, maxSessionBytes int64) error {
+	return m.Called(ctx, msg, maxSessionBytes).Error(0)
+}
+func (m *MockSessionRepo) ListBySessionWithCursor(ctx context.Context, sessionID uuid.UUID, afterSeq int64, afterID uuid.UUID, limit int, timeDesc bool, roles []string, columns []string, createdIn repo.TimeRange, author repo.AuthorFilter, annotated repo.AnnotationFilter) ([]model.Message, error) {
+	args := m.Called(ctx, sessionID, afterSeq, afterID, limit, timeDesc, roles, columns, createdIn, author, annotated)
 	return args.Get(0).([]model.Message), args.Error(1)
 }
-func (m *MockSessionRepo) ListAllMessagesBySession(ctx context.Context, sessionID uuid.UUID) ([]model.Message, error) {
-	args := m.Called(ctx, sessionID)
+func (m *MockSessionRepo) ListSessionsForExport(ctx context.Context, projectID uuid.UUID, afterCreatedAt time.Time, afterID uuid.UUID, limit int, includeDeleted bool) ([]model.Session, error) {
+	args := m.Called(ctx, projectID, afterCreatedAt, afterID, limit, includeDeleted)
+	return args.Get(0).([]model.Session), args.Error(1)
+}
+func (m *MockSessionRepo) ListMessagesForExport(ctx context.Context, sessionID uuid.UUID, afterSeq int64, afterID uuid.UUID, limit int, includeDeleted bool) ([]model.Message, error) {
+	args := m.Called(ctx, sessionID, afterSeq, afterID, limit, includeDeleted)
+	return args.Get(0).([]model.Message), args.Error(1)
+}
+func (m *MockSessionRepo) ListAllMessagesBySession(ctx context.Context, sessionID uuid.UUID, roles []string, columns []string, createdIn repo.TimeRange, author repo.AuthorFilter, annotated repo.AnnotationFilter) ([]model.Message, error) {
+	args := m.Called(ctx, sessionID, roles, columns, createdIn, author, annotated)
 	return args.Get(0).([]model.Message), args.Error(1)
 }
 func (m *MockSessionRepo) GetObservingStatus(ctx context.Context, sessionID string) (*model.MessageObservingStatus, error) {
@@ -91,9 +145,232 @@ func (m *MockSessionRepo) GetMessageByID(ctx context.Context, sessionID uuid.UUI
 	}
 	return args.Get(0).(*model.Message), args.Error(1)
 }
+
+func (m *MockSessionRepo) GetMessageByIdempotencyKey(ctx context.Context, sessionID uuid.UUID, key string) (*model.Message, error) {
+	args := m.Called(ctx, sessionID, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Message), args.Error(1)
+}
+
+func (m *MockSessionRepo) GetMessageThread(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.Message, error) {
+	args := m.Called(ctx, sessionID, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.Message), args.Error(1)
+}
+
+func (m *MockSessionRepo) ReparentMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, newParentID *uuid.UUID) (int, error) {
+	args := m.Called(ctx, sessionID, messageID, newParentID)
+	return args.Int(0), args.Error(1)
+}
+func (m *MockSessionRepo) ValidateTree(ctx context.Context, sessionID uuid.UUID) ([]repo.BrokenParentLink, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repo.BrokenParentLink), args.Error(1)
+}
+func (m *MockSessionRepo) RepairTree(ctx context.Context, sessionID uuid.UUID, mode repo.TreeRepairMode) (*repo.RepairTreeResult, error) {
+	args := m.Called(ctx, sessionID, mode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.RepairTreeResult), args.Error(1)
+}
+func (m *MockSessionRepo) FinalizeSession(ctx context.Context, sessionID uuid.UUID) (*model.Session, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Session), args.Error(1)
+}
+func (m *MockSessionRepo) MergeSessions(ctx context.Context, projectID uuid.UUID, targetID uuid.UUID, sourceIDs []uuid.UUID, mode repo.SessionMergeMode) (*repo.MergeSessionsResult, error) {
+	args := m.Called(ctx, projectID, targetID, sourceIDs, mode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.MergeSessionsResult), args.Error(1)
+}
+func (m *MockSessionRepo) CreateImmutabilityOverride(ctx context.Context, o *model.ImmutabilityOverride) error {
+	return m.Called(ctx, o).Error(0)
+}
+func (m *MockSessionRepo) ListImmutabilityOverrides(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) ([]model.ImmutabilityOverride, error) {
+	args := m.Called(ctx, projectID, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.ImmutabilityOverride), args.Error(1)
+}
+func (m *MockSessionRepo) SetMessagePinned(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, pinned bool) error {
+	return m.Called(ctx, sessionID, messageID, pinned).Error(0)
+}
+func (m *MockSessionRepo) ListPinnedMessages(ctx context.Context, sessionID uuid.UUID) ([]model.Message, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.Message), args.Error(1)
+}
+
+func (m *MockSessionRepo) SetMessageFlag(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, flagged bool, reason string, note string) (*model.MessageFlagAudit, error) {
+	args := m.Called(ctx, sessionID, messageID, flagged, reason, note)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.MessageFlagAudit), args.Error(1)
+}
+
+func (m *MockSessionRepo) ListMessageFlagAudits(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageFlagAudit, error) {
+	args := m.Called(ctx, sessionID, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.MessageFlagAudit), args.Error(1)
+}
+
+func (m *MockSessionRepo) CreateMessageAnnotation(ctx context.Context, sessionID uuid.UUID, a *model.MessageAnnotation) error {
+	return m.Called(ctx, sessionID, a).Error(0)
+}
+
+func (m *MockSessionRepo) ListMessageAnnotations(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageAnnotation, error) {
+	args := m.Called(ctx, sessionID, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.MessageAnnotation), args.Error(1)
+}
+
+func (m *MockSessionRepo) DeleteMessageAnnotation(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, annotationID uuid.UUID) error {
+	return m.Called(ctx, sessionID, messageID, annotationID).Error(0)
+}
+
+func (m *MockSessionRepo) CountSessionReactions(ctx context.Context, sessionID uuid.UUID) (map[string]int64, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+func (m *MockSessionRepo) SearchMessages(ctx context.Context, projectID uuid.UUID, sessionID *uuid.UUID, query string, order repo.MessageSearchOrder, limit int) ([]repo.MessageSearchHit, error) {
+	args := m.Called(ctx, projectID, sessionID, query, order, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repo.MessageSearchHit), args.Error(1)
+}
+
+func (m *MockSessionRepo) SearchMessagesBySession(ctx context.Context, projectID uuid.UUID, userIdentifier string, query string, afterLatest time.Time, afterSessionID uuid.UUID, limit int, hitsPerSession int) ([]repo.SessionSearchGroup, error) {
+	args := m.Called(ctx, projectID, userIdentifier, query, afterLatest, afterSessionID, limit, hitsPerSession)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repo.SessionSearchGroup), args.Error(1)
+}
+func (m *MockSessionRepo) UpdateMessageTokenCount(ctx context.Context, messageID uuid.UUID, count int, encoding string) error {
+	return m.Called(ctx, messageID, count, encoding).Error(0)
+}
+
+func (m *MockSessionRepo) SessionTokenTotal(ctx context.Context, sessionID uuid.UUID) (*repo.SessionTokenTotals, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.SessionTokenTotals), args.Error(1)
+}
+
+func (m *MockSessionRepo) AppendStreamText(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, delta string) error {
+	return m.Called(ctx, sessionID, messageID, delta).Error(0)
+}
+
+func (m *MockSessionRepo) FinalizeStreamingMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, finalize func(msg *model.Message) error) (*model.Message, error) {
+	args := m.Called(ctx, sessionID, messageID, finalize)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Message), args.Error(1)
+}
+
+func (m *MockSessionRepo) UpdateMessageParts(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, update func(msg *model.Message) error) (*model.Message, *model.MessageRevision, error) {
+	args := m.Called(ctx, sessionID, messageID, update)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*model.Message), args.Get(1).(*model.MessageRevision), args.Error(2)
+}
+
+func (m *MockSessionRepo) ReplaceMessageParts(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, expectedSHA256 string, update func(msg *model.Message) error) (*model.Message, error) {
+	args := m.Called(ctx, sessionID, messageID, expectedSHA256, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	msg := args.Get(0).(*model.Message)
+	if err := update(msg); err != nil {
+		return nil, err
+	}
+	return msg, args.Error(1)
+}
+
+func (m *MockSessionRepo) ListMessageRevisions(ctx context.Context, messageID uuid.UUID) ([]model.MessageRevision, error) {
+	args := m.Called(ctx, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.MessageRevision), args.Error(1)
+}
+
+func (m *MockSessionRepo) CreateMessagesBatch(ctx context.Context, sessionID uuid.UUID, msgs []model.Message) error {
+	args := m.Called(ctx, sessionID, msgs)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepo) CreateSessionWithMessages(ctx context.Context, s *model.Session, msgs []model.Message) error {
+	args := m.Called(ctx, s, msgs)
+	return args.Error(0)
+}
+
 func (m *MockSessionRepo) UpdateMessageMeta(ctx context.Context, messageID uuid.UUID, meta datatypes.JSONType[map[string]interface{}]) error {
 	return m.Called(ctx, messageID, meta).Error(0)
 }
+
+func (m *MockSessionRepo) DeleteMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) error {
+	args := m.Called(ctx, sessionID, messageID)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepo) DeleteMessages(ctx context.Context, sessionID uuid.UUID, filter repo.MessageFilter, cascade bool) (*repo.DeleteMessagesResult, error) {
+	args := m.Called(ctx, sessionID, filter, cascade)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.DeleteMessagesResult), args.Error(1)
+}
+
+func (m *MockSessionRepo) RestoreMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) error {
+	args := m.Called(ctx, sessionID, messageID)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepo) MergeDuplicateMessages(ctx context.Context, sessionID uuid.UUID, survivorID uuid.UUID, duplicateIDs []uuid.UUID) error {
+	args := m.Called(ctx, sessionID, survivorID, duplicateIDs)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepo) PurgeDeleted(ctx context.Context, olderThan time.Duration) (*repo.PurgeDeletedResult, error) {
+	args := m.Called(ctx, olderThan)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.PurgeDeletedResult), args.Error(1)
+}
+
+func (m *MockSessionRepo) ExpireIdempotencyKeys(ctx context.Context, olderThan time.Duration) (int64, error) {
+	args := m.Called(ctx, olderThan)
+	return args.Get(0).(int64), args.Error(1)
+}
 func (m *MockSessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID, userKEK []byte) (*repo.CopySessionResult, error) {
 	args := m.Called(ctx, sessionID, userKEK)
 	if args.Get(0) == nil {
@@ -101,6 +378,20 @@ func (m *MockSessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID,
 	}
 	return args.Get(0).(*repo.CopySessionResult), args.Error(1)
 }
+func (m *MockSessionRepo) ForkSession(ctx context.Context, sessionID uuid.UUID, fromMessageID uuid.UUID, userKEK []byte) (*repo.ForkSessionResult, error) {
+	args := m.Called(ctx, sessionID, fromMessageID, userKEK)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.ForkSessionResult), args.Error(1)
+}
+func (m *MockSessionRepo) CloneSessionShallow(ctx context.Context, sessionID uuid.UUID, fromMessageID uuid.UUID) (*repo.CloneSessionShallowResult, error) {
+	args := m.Called(ctx, sessionID, fromMessageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*repo.CloneSessionShallowResult), args.Error(1)
+}
 func (m *MockSessionRepo) HasUnfinishedMessages(ctx context.Context, sessionID uuid.UUID) (bool, error) {
 	args := m.Called(ctx, sessionID)
 	return args.Bool(0), args.Error(1)
@@ -109,6 +400,13 @@ func (m *MockSessionRepo) HasFailedMessages(ctx context.Context, sessionID uuid.
 	args := m.Called(ctx, sessionID)
 	return args.Bool(0), args.Error(1)
 }
+func (m *MockSessionRepo) OldestMessageCreatedAt(ctx context.Context, sessionID uuid.UUID) (*time.Time, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*time.Time), args.Error(1)
+}
 
 func TestTaskHandler_GetTasks(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -38,6 +38,11 @@ type Artifact struct {
 	Filename  string                    `gorm:"type:text;not null;uniqueIndex:idx_disk_path_filename" json:"filename"`
 	Meta      datatypes.JSONMap         `gorm:"type:jsonb" swaggertype:"object" json:"meta"`
 	AssetMeta datatypes.JSONType[Asset] `gorm:"type:jsonb;not null" swaggertype:"-" json:"-"`
+	// SHA256 is the content hash of the stored asset, mirrored from AssetMeta for lookups.
+	SHA256 string `gorm:"type:varchar(64);index" json:"sha256"`
+
+	// Deduplicated is set on upload when the content reused an already stored asset.
+	Deduplicated bool `gorm:"-" json:"deduplicated,omitempty"`
 
 	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
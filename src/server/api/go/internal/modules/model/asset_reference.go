@@ -57,6 +57,24 @@ type Asset struct {
 	MIME    string `json:"mime"`
 	SizeB   int64  `json:"size_b"`
 	Content string `json:"content,omitempty"` // Text content for text-searchable files (text/*, application/json, application/x-*)
+	// DerivedFromID is the SHA256 of the asset this one was generated from, e.g. the image of a
+	// thumbnail; empty for uploaded assets.
+	DerivedFromID string `json:"derived_from_id,omitempty"`
+	// Meta holds the intrinsic metadata of image, audio and video assets, e.g. dimensions,
+	// duration and codec (see package mediameta). It is nil until extracted, and stays nil
+	// when extraction fails.
+	Meta map[string]any `json:"meta,omitempty"`
+}
+
+// Thumbnail is a downscaled copy of an image asset that fits in a Size x Size box. URL is only
+// set in responses that ask for asset URLs.
+type Thumbnail struct {
+	Size     int        `json:"size"`
+	Width    int        `json:"width"`
+	Height   int        `json:"height"`
+	Asset    Asset      `json:"asset"`
+	URL      string     `json:"url,omitempty"`
+	ExpireAt *time.Time `json:"expire_at,omitempty"`
 }
 
 // IsOrphaned returns true if this asset has no references
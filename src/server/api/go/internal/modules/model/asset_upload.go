@@ -0,0 +1,41 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+const (
+	AssetUploadStatusPending   = "pending"
+	AssetUploadStatusConfirmed = "confirmed"
+)
+
+// AssetUpload tracks a media object the client uploads straight to S3 through a presigned URL.
+// It stays pending until confirmed; pending uploads past ExpiresAt are garbage-collected
+// together with whatever object was written to their key.
+type AssetUpload struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	ProjectID uuid.UUID `gorm:"type:uuid;not null;index" json:"project_id"`
+	S3Key     string    `gorm:"type:text;not null" json:"-"`
+	MIME      string    `gorm:"type:text;not null" json:"mime"`
+	SizeB     int64     `gorm:"not null" json:"size_b"`
+	Status    string    `gorm:"type:varchar(16);not null;default:'pending';index:idx_asset_uploads_status_expires,priority:1" json:"status"`
+
+	// AssetMeta describes the stored object once the upload is confirmed.
+	AssetMeta datatypes.JSONType[Asset] `gorm:"type:jsonb;not null" swaggertype:"object" json:"asset_meta"`
+	// AssetID is the asset reference row the confirmed content is registered under.
+	AssetID *uuid.UUID `gorm:"type:uuid" json:"asset_id,omitempty"`
+
+	ExpiresAt   time.Time  `gorm:"not null;index:idx_asset_uploads_status_expires,priority:2" json:"expires_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
+
+	// AssetUpload <-> Project
+	Project *Project `gorm:"foreignKey:ProjectID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (AssetUpload) TableName() string { return "asset_uploads" }
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Names of the one-time data migrations run at boot.
+const (
+	DataMigrationArtifactHashes  = "backfill_artifact_hashes"
+	DataMigrationMessageSeqs     = "backfill_message_seqs"
+	DataMigrationMessageVersions = "backfill_message_versions"
+	DataMigrationSessionStats    = "backfill_session_stats"
+)
+
+// DataMigration records a one-time data migration that completed, so it is not run again at the
+// next boot.
+type DataMigration struct {
+	Name      string    `gorm:"type:text;primaryKey" json:"name"`
+	AppliedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"applied_at"`
+}
+
+func (DataMigration) TableName() string { return "data_migrations" }
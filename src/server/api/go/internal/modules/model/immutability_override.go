@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Actions an ImmutabilityOverride records.
+const (
+	OverrideUpdateParts    = "update_parts"
+	OverridePatchParts     = "patch_parts"
+	OverrideAttachAssets   = "attach_assets"
+	OverrideDetachAssets   = "detach_assets"
+	OverridePatchMeta      = "patch_meta"
+	OverrideDeleteMessage  = "delete_message"
+	OverrideRestoreMessage = "restore_message"
+	OverrideReparent       = "reparent_message"
+	OverrideDeleteMessages = "delete_messages"
+	OverrideDedupe         = "dedupe"
+	OverrideRepairTree     = "repair_tree"
+	OverrideDeleteSession  = "delete_session"
+	OverridePurgeSession   = "purge_session"
+)
+
+// ImmutabilityOverride records an admin change to a finalized session, or to a message older
+// than the immutability window, that was let through by an explicit override. Rows are kept when
+// the session is deleted so the log outlives the transcript it covers.
+type ImmutabilityOverride struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	ProjectID uuid.UUID `gorm:"type:uuid;not null;index" json:"project_id"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null;index:idx_immutability_override_created,priority:1" json:"session_id"`
+	// MessageID is the message the action targeted; nil for actions on the whole session.
+	MessageID *uuid.UUID `gorm:"type:uuid" json:"message_id,omitempty"`
+
+	Action string `gorm:"type:text;not null" json:"action"`
+	Reason string `gorm:"type:text;not null" json:"reason"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP;index:idx_immutability_override_created,priority:2" json:"created_at"`
+
+	// ImmutabilityOverride <-> Project
+	Project *Project `gorm:"foreignKey:ProjectID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (ImmutabilityOverride) TableName() string { return "immutability_overrides" }
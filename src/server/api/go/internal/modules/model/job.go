@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	// JobStatusDead marks a job that ran out of attempts; it stays in the table until it is
+	// retried by hand.
+	JobStatusDead = "dead"
+)
+
+// Job is a unit of background work run by the handler registered for its Kind. Failed runs are
+// retried with exponential backoff until MaxAttempts is reached; finished jobs are deleted.
+type Job struct {
+	ID      uuid.UUID      `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	Kind    string         `gorm:"type:text;not null;index:idx_jobs_kind_status,priority:1" json:"kind"`
+	Payload datatypes.JSON `gorm:"type:jsonb;not null" swaggertype:"object" json:"payload"`
+
+	Status      string `gorm:"type:varchar(16);not null;default:'pending';index:idx_jobs_status_run_at,priority:1;index:idx_jobs_kind_status,priority:2" json:"status"`
+	Attempts    int    `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts int    `gorm:"not null" json:"max_attempts"`
+	LastError   string `gorm:"type:text;not null;default:''" json:"last_error,omitempty"`
+
+	// RunAt is when the job is next due; a running job's RunAt is when it was claimed.
+	RunAt     time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index:idx_jobs_status_run_at,priority:2" json:"run_at"`
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (Job) TableName() string { return "jobs" }
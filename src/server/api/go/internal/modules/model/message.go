@@ -1,10 +1,16 @@
 package model
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // ---------------------------------------------------------------------------
@@ -19,6 +25,8 @@ const (
 	FormatOpenAI    MessageFormat = "openai"
 	FormatAnthropic MessageFormat = "anthropic"
 	FormatGemini    MessageFormat = "gemini"
+	// FormatMarkdown is a readable transcript; sessions can be exported to it but not read from it.
+	FormatMarkdown MessageFormat = "markdown"
 )
 
 // ---------------------------------------------------------------------------
@@ -35,6 +43,33 @@ const (
 	RoleAssistant Role = "assistant"
 )
 
+// StoredRoles lists the roles a stored message may have; it mirrors the check constraint on
+// Message.Role. Other provider roles are folded into these on store.
+var StoredRoles = []Role{RoleUser, RoleAssistant}
+
+// IsStoredRole reports whether role is one of StoredRoles.
+func IsStoredRole(role string) bool {
+	return slices.Contains(StoredRoles, role)
+}
+
+// AuthorType says what kind of principal produced a message. It is independent of Role: two
+// agents in one session both write assistant messages but have different authors.
+type AuthorType = string
+
+const (
+	AuthorTypeUser   AuthorType = "user"
+	AuthorTypeAgent  AuthorType = "agent"
+	AuthorTypeSystem AuthorType = "system"
+)
+
+// AuthorTypes lists the valid author types; it mirrors the check constraint on Message.AuthorType.
+var AuthorTypes = []AuthorType{AuthorTypeUser, AuthorTypeAgent, AuthorTypeSystem}
+
+// IsAuthorType reports whether t is one of AuthorTypes.
+func IsAuthorType(t string) bool {
+	return slices.Contains(AuthorTypes, t)
+}
+
 // ---------------------------------------------------------------------------
 // Message task-process status constants
 // ---------------------------------------------------------------------------
@@ -72,6 +107,17 @@ const (
 	PartTypeRedactedThinking PartType = "redacted_thinking"
 )
 
+// PartTypes lists every known part type.
+var PartTypes = []PartType{
+	PartTypeText, PartTypeImage, PartTypeAudio, PartTypeVideo, PartTypeFile,
+	PartTypeToolCall, PartTypeToolResult, PartTypeData, PartTypeThinking, PartTypeRedactedThinking,
+}
+
+// IsPartType reports whether t is one of PartTypes.
+func IsPartType(t string) bool {
+	return slices.Contains(PartTypes, t)
+}
+
 // ---------------------------------------------------------------------------
 // Meta key constants  (Part.Meta and Message.Meta dictionary keys)
 // ---------------------------------------------------------------------------
@@ -169,6 +215,22 @@ const (
 	MetaKeyDataType MetaKey = "data_type"
 )
 
+// Enrichment Meta Keys -- written asynchronously into image, audio and video parts by the
+// enrichers registered under these names.
+const (
+	// MetaKeyOCR stores text recognized in an image or video.
+	MetaKeyOCR MetaKey = "ocr"
+
+	// MetaKeyASR stores the speech transcript of an audio or video part.
+	MetaKeyASR MetaKey = "asr"
+
+	// MetaKeyCaption stores a generated description of an image or video.
+	MetaKeyCaption MetaKey = "caption"
+
+	// MetaKeyThumbnails stores the downscaled copies of an image, as {"items": [Thumbnail...]}.
+	MetaKeyThumbnails MetaKey = "thumbnails"
+)
+
 // ---------------------------------------------------------------------------
 // Message-level Meta key constants  (stored in Message.Meta DB JSONB)
 // ---------------------------------------------------------------------------
@@ -197,18 +259,70 @@ const (
 
 type Message struct {
 	ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
-	SessionID uuid.UUID  `gorm:"type:uuid;not null;index;index:idx_session_created,priority:1" json:"session_id"`
+	SessionID uuid.UUID  `gorm:"type:uuid;not null;index;index:idx_session_created,priority:1;index:idx_session_seq,priority:1;uniqueIndex:idx_messages_idempotency_key,priority:1" json:"session_id"`
 	ParentID  *uuid.UUID `gorm:"type:uuid;index" json:"parent_id"`
 	Parent    *Message   `gorm:"foreignKey:ParentID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
 	Children  []Message  `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
 
+	// Seq is the message's position in its session, taken from Session.LastMessageSeq on insert.
+	// It increases strictly with insertion order and is the default ordering key, so messages
+	// created within the same clock tick still list deterministically.
+	Seq int64 `gorm:"not null;default:0;index:idx_session_seq,priority:2" json:"seq"`
+
 	Role string `gorm:"type:text;not null;check:role IN ('user','assistant')" json:"role"`
 
+	// AuthorID and AuthorType attribute the message to the user, agent or system that produced
+	// it. Both are null for unattributed messages, which includes every message stored before
+	// attribution was added.
+	AuthorID   *uuid.UUID `gorm:"type:uuid;index" json:"author_id,omitempty"`
+	AuthorType string     `gorm:"type:text;default:null;check:author_type IN ('user','agent','system')" json:"author_type,omitempty"`
+
 	Meta datatypes.JSONType[map[string]any] `gorm:"type:jsonb;not null;default:'{}';index:idx_messages_meta,type:gin" swaggertype:"object" json:"meta"`
 
 	PartsAssetMeta datatypes.JSONType[Asset] `gorm:"type:jsonb;not null" swaggertype:"-" json:"-"`
 	Parts          []Part                    `gorm:"-" swaggertype:"array,object" json:"parts"`
 
+	// SearchText is the concatenated text of the message's text parts, indexed for full-text search.
+	// Left empty for envelope-encrypted projects so plaintext never lands in the database.
+	SearchText string `gorm:"type:text;not null;default:''" json:"-"`
+
+	// TokenCount is the token count of the message's text and tool-call parts, computed with TokenEncoding.
+	// An empty TokenEncoding marks messages stored before counts were recorded.
+	TokenCount    int    `gorm:"not null;default:0" json:"token_count"`
+	TokenEncoding string `gorm:"type:text;not null;default:''" json:"token_encoding,omitempty"`
+
+	// StorageBytes is the size of the parts object plus the assets its parts reference. It is what
+	// the message adds to its session's TotalBytes.
+	StorageBytes int64 `gorm:"not null;default:0" json:"storage_bytes"`
+
+	// Version counts the versions of the message's parts. It starts at 1 and each edit, which saves
+	// the replaced parts as a MessageRevision with the old version, increments it. Clients send it
+	// back in If-Match so concurrent edits are rejected instead of overwriting each other.
+	Version int `gorm:"not null;default:1" json:"version"`
+
+	// Pinned messages are always included by the system-pinned context strategy, whatever the
+	// token budget. Pinning changes neither the message's position nor its children.
+	Pinned bool `gorm:"not null;default:false" json:"pinned"`
+
+	// Flagged is set by the moderation provider when the message is created, with its reason in
+	// FlagReason. Flagged messages are stored and listed as usual but left out of built context.
+	// Overrides through the API are recorded as MessageFlagAudit rows.
+	Flagged    bool   `gorm:"not null;default:false" json:"flagged"`
+	FlagReason string `gorm:"type:text;not null;default:''" json:"flag_reason,omitempty"`
+
+	// Streaming is true while an assistant reply is being streamed in. Text deltas accumulate
+	// in StreamText until the message is finalized into its parts asset.
+	Streaming  bool   `gorm:"not null;default:false" json:"streaming"`
+	StreamText string `gorm:"type:text;not null;default:''" json:"-"`
+
+	// IdempotencyKey is the client-supplied key the message was created with. A retry with the same
+	// key in the session returns this message instead of storing a new one. Keys are released
+	// after the repo's IdempotencyKeyTTL.
+	IdempotencyKey *string `gorm:"type:text;uniqueIndex:idx_messages_idempotency_key,priority:2,where:idempotency_key IS NOT NULL" json:"-"`
+
+	// Replayed is set when the message was returned for a repeated idempotency key rather than created.
+	Replayed bool `gorm:"-" json:"-"`
+
 	TaskID *uuid.UUID `gorm:"type:uuid;index" json:"task_id"`
 
 	SessionTaskProcessStatus string `gorm:"type:text;not null;default:'pending'" json:"session_task_process_status"`
@@ -216,6 +330,9 @@ type Message struct {
 	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP;index:idx_session_created,priority:2,sort:desc" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
 
+	// DeletedAt marks the message as soft-deleted; regular queries skip it until it is purged.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
 	// Message <-> Session
 	Session *Session `gorm:"foreignKey:SessionID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
 
@@ -225,11 +342,64 @@ type Message struct {
 
 func (Message) TableName() string { return "messages" }
 
+// MessageTreeStats places a message in its session's tree: Depth is its distance from the root,
+// 0 for a root, and ChildCount the number of its direct children.
+type MessageTreeStats struct {
+	Depth      int `json:"depth"`
+	ChildCount int `json:"child_count"`
+}
+
+// MessageSearchConfig is the text search configuration used to index and query SearchText.
+// "simple" avoids language-specific stemming since conversations may be in any language.
+const MessageSearchConfig = "simple"
+
+// MessageSearchIndexDDL creates the GIN index backing full-text search on messages.search_text.
+const MessageSearchIndexDDL = "CREATE INDEX IF NOT EXISTS idx_messages_search_text ON messages USING GIN (to_tsvector('" + MessageSearchConfig + "', search_text))"
+
 // GetReservedKeys returns a list of reserved metadata keys for Message
 func (Message) GetReservedKeys() []string {
 	return []string{GeminiCallInfoKey}
 }
 
+// The accessors below read the message's loaded Parts; they return nothing for a message whose
+// parts were not loaded. Returned parts keep their order and share Meta with the message.
+
+// TextParts returns the message's text parts.
+func (m *Message) TextParts() []Part { return m.PartsOfType(PartTypeText) }
+
+// MediaParts returns the message's image, audio, video and file parts.
+func (m *Message) MediaParts() []Part {
+	return m.PartsOfType(PartTypeImage, PartTypeAudio, PartTypeVideo, PartTypeFile)
+}
+
+// ToolCalls returns the message's tool-call parts.
+func (m *Message) ToolCalls() []Part { return m.PartsOfType(PartTypeToolCall) }
+
+// ToolResults returns the message's tool-result parts.
+func (m *Message) ToolResults() []Part { return m.PartsOfType(PartTypeToolResult) }
+
+// ConcatText joins the text of the message's text parts with newlines, skipping empty ones.
+func (m *Message) ConcatText() string {
+	var texts []string
+	for _, p := range m.TextParts() {
+		if p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// PartsOfType returns the message's parts whose type is one of types.
+func (m *Message) PartsOfType(types ...PartType) []Part {
+	var parts []Part
+	for _, p := range m.Parts {
+		if slices.Contains(types, p.Type) {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
 // ---------------------------------------------------------------------------
 // Part model
 // ---------------------------------------------------------------------------
@@ -287,6 +457,25 @@ func (p Part) GetMetaBool(key MetaKey) bool {
 	return v
 }
 
+// Thumbnails returns the thumbnails recorded in an image part's Meta.
+func (p Part) Thumbnails() []Thumbnail {
+	raw, ok := p.Meta[MetaKeyThumbnails]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var v struct {
+		Items []Thumbnail `json:"items"`
+	}
+	if json.Unmarshal(data, &v) != nil {
+		return nil
+	}
+	return v.Items
+}
+
 // ID returns the tool-call's own unique ID from Meta.
 func (p Part) ID() string { return p.GetMetaString(MetaKeyID) }
 
@@ -305,6 +494,92 @@ func (p Part) IsError() bool { return p.GetMetaBool(MetaKeyIsError) }
 // Signature returns the Anthropic thinking signature from Meta.
 func (p Part) Signature() string { return p.GetMetaString(MetaKeySignature) }
 
+// ---------------------------------------------------------------------------
+// Part validation
+// ---------------------------------------------------------------------------
+
+// Validate checks that the fields a stored part carries are consistent with its Type.
+func (p Part) Validate() error {
+	switch p.Type {
+	case PartTypeText, PartTypeThinking:
+		if p.Text == "" {
+			return fmt.Errorf("%s part requires non-empty text", p.Type)
+		}
+		if p.Asset != nil || p.Filename != "" {
+			return fmt.Errorf("%s part must not reference an asset", p.Type)
+		}
+	case PartTypeImage, PartTypeAudio, PartTypeVideo, PartTypeFile:
+		if p.Text != "" {
+			return fmt.Errorf("%s part must not carry text", p.Type)
+		}
+		if p.Asset != nil {
+			if p.Asset.S3Key == "" || p.Asset.MIME == "" {
+				return fmt.Errorf("%s part asset requires s3_key and mime", p.Type)
+			}
+			return nil
+		}
+		// Parts normalized from provider formats reference their media inline instead of an asset.
+		for _, key := range []MetaKey{MetaKeyURL, MetaKeyData, MetaKeyFileID, MetaKeyFileData} {
+			if p.GetMetaString(key) != "" {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s part requires an uploaded asset or a url, data or file_id in meta", p.Type)
+	case PartTypeToolCall:
+		if p.Name() == "" {
+			return errors.New("tool-call part requires 'name' in meta")
+		}
+		if _, ok := p.Meta[MetaKeyArguments]; !ok {
+			return errors.New("tool-call part requires 'arguments' in meta")
+		}
+	case PartTypeToolResult:
+		if p.ToolCallID() == "" {
+			return errors.New("tool-result part requires 'tool_call_id' in meta")
+		}
+	case PartTypeData:
+		if _, ok := p.Meta[MetaKeyDataType]; !ok {
+			return errors.New("data part requires 'data_type' in meta")
+		}
+	case PartTypeRedactedThinking:
+	default:
+		return fmt.Errorf("unknown part type %q", p.Type)
+	}
+	return nil
+}
+
+// PartError describes why the part at Index of a message is invalid.
+type PartError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// InvalidPartsError lists every invalid part of a message.
+type InvalidPartsError struct {
+	Parts []PartError
+}
+
+func (e *InvalidPartsError) Error() string {
+	msgs := make([]string, len(e.Parts))
+	for i, pe := range e.Parts {
+		msgs[i] = fmt.Sprintf("parts[%d]: %s", pe.Index, pe.Error)
+	}
+	return "invalid parts: " + strings.Join(msgs, "; ")
+}
+
+// ValidateParts validates each part and reports all failures as an *InvalidPartsError.
+func ValidateParts(parts []Part) error {
+	var invalid []PartError
+	for i, p := range parts {
+		if err := p.Validate(); err != nil {
+			invalid = append(invalid, PartError{Index: i, Error: err.Error()})
+		}
+	}
+	if len(invalid) > 0 {
+		return &InvalidPartsError{Parts: invalid}
+	}
+	return nil
+}
+
 // ---------------------------------------------------------------------------
 // Part constructor helpers
 // ---------------------------------------------------------------------------
@@ -391,6 +666,21 @@ func NewAudioPart(base64Data, format string) Part {
 	}
 }
 
+// NewAssetPart creates a media Part linking a stored asset. The part type follows the asset's
+// MIME type: image/*, audio/* and video/* map to their types and anything else is a file.
+func NewAssetPart(asset Asset, filename string) Part {
+	partType := PartTypeFile
+	switch {
+	case strings.HasPrefix(asset.MIME, "image/"):
+		partType = PartTypeImage
+	case strings.HasPrefix(asset.MIME, "audio/"):
+		partType = PartTypeAudio
+	case strings.HasPrefix(asset.MIME, "video/"):
+		partType = PartTypeVideo
+	}
+	return Part{Type: partType, Asset: &asset, Filename: filename}
+}
+
 // NewRedactedThinkingPart creates a redacted_thinking Part.
 // The data is an opaque string; there is no text content.
 func NewRedactedThinkingPart(data string) Part {
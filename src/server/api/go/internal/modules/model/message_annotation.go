@@ -0,0 +1,67 @@
+package model
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AnnotationKind says what a MessageAnnotation records.
+type AnnotationKind = string
+
+const (
+	// AnnotationKindReaction is a feedback reaction; its Value is one of Reactions.
+	AnnotationKindReaction AnnotationKind = "reaction"
+	// AnnotationKindLabel is a free-form label in Value, e.g. from a manual labeling pass.
+	AnnotationKindLabel AnnotationKind = "label"
+	// AnnotationKindNote is a free-text note in Note; it has no Value.
+	AnnotationKindNote AnnotationKind = "note"
+)
+
+// AnnotationKinds lists the valid annotation kinds; it mirrors the check constraint on
+// MessageAnnotation.Kind.
+var AnnotationKinds = []AnnotationKind{AnnotationKindReaction, AnnotationKindLabel, AnnotationKindNote}
+
+// IsAnnotationKind reports whether k is one of AnnotationKinds.
+func IsAnnotationKind(k string) bool {
+	return slices.Contains(AnnotationKinds, k)
+}
+
+const (
+	ReactionThumbsUp   = "thumbs_up"
+	ReactionThumbsDown = "thumbs_down"
+)
+
+// Reactions lists the values a reaction annotation can take.
+var Reactions = []string{ReactionThumbsUp, ReactionThumbsDown}
+
+// IsReaction reports whether v is one of Reactions.
+func IsReaction(v string) bool {
+	return slices.Contains(Reactions, v)
+}
+
+// MaxAnnotationValueLen caps the length of a reaction or label value.
+const MaxAnnotationValueLen = 64
+
+// MessageAnnotation is feedback attached to a message: a reaction, a label or a note. A message
+// can carry any number of them, from any number of authors.
+type MessageAnnotation struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	MessageID uuid.UUID `gorm:"type:uuid;not null;index:idx_message_annotation_kind,priority:1;index:idx_message_annotation_created,priority:1" json:"message_id"`
+
+	Kind  string `gorm:"type:text;not null;check:kind IN ('reaction','label','note');index:idx_message_annotation_kind,priority:2" json:"kind"`
+	Value string `gorm:"type:text;not null;default:'';index:idx_message_annotation_kind,priority:3" json:"value"`
+	Note  string `gorm:"type:text;not null;default:''" json:"note"`
+
+	// AuthorID and AuthorType attribute the annotation like Message.AuthorID and AuthorType.
+	AuthorID   *uuid.UUID `gorm:"type:uuid;index" json:"author_id,omitempty"`
+	AuthorType string     `gorm:"type:text;default:null;check:author_type IN ('user','agent','system')" json:"author_type,omitempty"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP;index:idx_message_annotation_created,priority:2" json:"created_at"`
+
+	// MessageAnnotation <-> Message
+	Message *Message `gorm:"foreignKey:MessageID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (MessageAnnotation) TableName() string { return "message_annotations" }
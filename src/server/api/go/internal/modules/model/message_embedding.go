@@ -0,0 +1,130 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Vector is a pgvector value, exchanged with Postgres in its text form, e.g. "[0.1,0.2,0.3]".
+type Vector []float32
+
+// Value implements driver.Valuer.
+func (v Vector) Value() (driver.Value, error) {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(f), 'f', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String(), nil
+}
+
+// Scan implements sql.Scanner.
+func (v *Vector) Scan(src any) error {
+	var s string
+	switch t := src.(type) {
+	case string:
+		s = t
+	case []byte:
+		s = string(t)
+	case nil:
+		*v = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Vector", src)
+	}
+
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return fmt.Errorf("invalid vector literal %q", s)
+	}
+	s = s[1 : len(s)-1]
+	if s == "" {
+		*v = Vector{}
+		return nil
+	}
+	fields := strings.Split(s, ",")
+	out := make(Vector, len(fields))
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(strings.TrimSpace(f), 32)
+		if err != nil {
+			return fmt.Errorf("invalid vector element %q: %w", f, err)
+		}
+		out[i] = float32(x)
+	}
+	*v = out
+	return nil
+}
+
+// MessageEmbedding stores one embedding per message for similarity search.
+// The table needs the pgvector extension and is only migrated when it is available.
+type MessageEmbedding struct {
+	MessageID uuid.UUID `gorm:"type:uuid;primaryKey" json:"message_id"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null;index" json:"session_id"`
+	ProjectID uuid.UUID `gorm:"type:uuid;not null;index" json:"project_id"`
+	Dim       int       `gorm:"not null" json:"dim"`
+	Embedding Vector    `gorm:"type:vector;not null" swaggertype:"array,number" json:"embedding"`
+
+	// Model names the embedding model that produced Embedding, empty for vectors stored without
+	// one. Searches only compare vectors of one model, so a project can switch models gradually.
+	Model string `gorm:"type:text;not null;default:'';index" json:"model"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
+
+	// MessageEmbedding <-> Message
+	Message *Message `gorm:"foreignKey:MessageID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+
+	// MessageEmbedding <-> Session
+	Session *Session `gorm:"foreignKey:SessionID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (MessageEmbedding) TableName() string { return "message_embeddings" }
+
+const (
+	EmbeddingReindexRunning = "running"
+	EmbeddingReindexDone    = "done"
+	// EmbeddingReindexFailed marks a reindex stopped by an error retrying cannot fix, such as a
+	// model whose vectors do not match the dimension of the model's other embeddings.
+	EmbeddingReindexFailed = "failed"
+)
+
+// EmbeddingReindex tracks a run that recomputes the embeddings of a project's messages, or of one
+// session's, with Model. Messages are processed in ID order and AfterMessageID checkpoints the
+// last one done, so a run interrupted at any point resumes where it stopped.
+type EmbeddingReindex struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	ProjectID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_embedding_reindexes_running,where:status = 'running'" json:"project_id"`
+	SessionID *uuid.UUID `gorm:"type:uuid" json:"session_id,omitempty"`
+	Model     string     `gorm:"type:text;not null" json:"model"`
+	// Force recomputes messages that already have an embedding of Model.
+	Force  bool   `gorm:"not null;default:false" json:"force"`
+	Status string `gorm:"type:varchar(16);not null;default:'running'" json:"status"`
+
+	AfterMessageID uuid.UUID `gorm:"type:uuid;not null" json:"-"`
+	// Total counts the messages in scope when the run started; Embedded and Skipped the messages
+	// processed so far. Messages without text are skipped, as are those already embedded with
+	// Model unless Force is set.
+	Total    int64 `gorm:"not null;default:0" json:"total"`
+	Embedded int64 `gorm:"not null;default:0" json:"embedded"`
+	Skipped  int64 `gorm:"not null;default:0" json:"skipped"`
+	// Error is the last error the run hit; the run is retried unless Status is failed.
+	Error string `gorm:"type:text;not null;default:''" json:"error,omitempty"`
+
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
+
+	// EmbeddingReindex <-> Project
+	Project *Project `gorm:"foreignKey:ProjectID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (EmbeddingReindex) TableName() string { return "embedding_reindexes" }
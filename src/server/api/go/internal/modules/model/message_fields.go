@@ -0,0 +1,75 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MessageFieldColumns maps each message field a client can select, by its name in the acontext
+// message format, to the columns it is read from. The parts field is loaded from the parts
+// object named by parts_asset_meta, so leaving it out also skips that load.
+var MessageFieldColumns = map[string][]string{
+	"id":                          {"id"},
+	"session_id":                  {"session_id"},
+	"parent_id":                   {"parent_id"},
+	"role":                        {"role"},
+	"author":                      {"author_id", "author_type"},
+	"parts":                       {"parts_asset_meta"},
+	"session_task_process_status": {"session_task_process_status"},
+	"meta":                        {"meta"},
+	"task_id":                     {"task_id"},
+	"flagged":                     {"flagged", "flag_reason"},
+	"created_at":                  {"created_at"},
+	"updated_at":                  {"updated_at"},
+}
+
+// messageKeyColumns are read for every selection: listings order and paginate on them.
+var messageKeyColumns = []string{"id", "seq", "created_at"}
+
+// ErrUnknownMessageField is returned for field names outside MessageFieldColumns.
+var ErrUnknownMessageField = errors.New("unknown message field")
+
+// ParseMessageFields parses a comma-separated field list such as "id,role,created_at". Names
+// are trimmed and de-duplicated, keeping their first position. An empty list returns nil, which
+// selects every field.
+func ParseMessageFields(s string) ([]string, error) {
+	var fields []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := MessageFieldColumns[name]; !ok {
+			known := make([]string, 0, len(MessageFieldColumns))
+			for k := range MessageFieldColumns {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("%w %q, expected a subset of %s", ErrUnknownMessageField, name, strings.Join(known, ", "))
+		}
+		if !slices.Contains(fields, name) {
+			fields = append(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+// MessageFieldsColumns returns the columns to read for fields, including the key columns.
+// It returns nil, reading every column, when fields is empty.
+func MessageFieldsColumns(fields []string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	columns := slices.Clone(messageKeyColumns)
+	for _, f := range fields {
+		for _, c := range MessageFieldColumns[f] {
+			if !slices.Contains(columns, c) {
+				columns = append(columns, c)
+			}
+		}
+	}
+	return columns
+}
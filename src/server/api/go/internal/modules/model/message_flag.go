@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageFlagAudit records a change to a message's moderation flag made through the API, e.g.
+// clearing a false positive, with the flag before and after the change.
+type MessageFlagAudit struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	MessageID uuid.UUID `gorm:"type:uuid;not null;index:idx_message_flag_audit_created,priority:1" json:"message_id"`
+
+	PreviousFlagged    bool   `gorm:"not null" json:"previous_flagged"`
+	PreviousFlagReason string `gorm:"type:text;not null;default:''" json:"previous_flag_reason"`
+	Flagged            bool   `gorm:"not null" json:"flagged"`
+	FlagReason         string `gorm:"type:text;not null;default:''" json:"flag_reason"`
+
+	// Note is the caller's explanation for the change.
+	Note string `gorm:"type:text;not null;default:''" json:"note"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP;index:idx_message_flag_audit_created,priority:2" json:"created_at"`
+
+	// MessageFlagAudit <-> Message
+	Message *Message `gorm:"foreignKey:MessageID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (MessageFlagAudit) TableName() string { return "message_flag_audits" }
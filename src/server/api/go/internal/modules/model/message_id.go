@@ -0,0 +1,39 @@
+package model
+
+import (
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// messageIDsV7 selects UUIDv7 for new message IDs; see UseUUIDv7MessageIDs.
+var messageIDsV7 atomic.Bool
+
+// UseUUIDv7MessageIDs makes NewMessageID return UUIDv7s, which start with their creation time in
+// milliseconds, instead of random v4s. New messages then sort by ID in creation order and insert
+// at the right edge of the primary key index. Stored v4 IDs stay valid: both are plain uuid
+// values to the database, and nothing relies on the version of an ID.
+func UseUUIDv7MessageIDs(on bool) {
+	messageIDsV7.Store(on)
+}
+
+// NewMessageID returns the ID of a new message: a UUIDv7 when UseUUIDv7MessageIDs is on,
+// otherwise a random v4 like the column default.
+func NewMessageID() uuid.UUID {
+	if messageIDsV7.Load() {
+		if id, err := uuid.NewV7(); err == nil {
+			return id
+		}
+	}
+	return uuid.New()
+}
+
+// BeforeCreate assigns NewMessageID to a message created without an ID, so messages get their ID
+// from the application rather than the column default.
+func (m *Message) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = NewMessageID()
+	}
+	return nil
+}
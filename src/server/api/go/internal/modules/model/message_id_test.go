@@ -0,0 +1,33 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMessageID(t *testing.T) {
+	defer UseUUIDv7MessageIDs(false)
+
+	assert.Equal(t, uuid.Version(4), NewMessageID().Version())
+
+	UseUUIDv7MessageIDs(true)
+	prev := NewMessageID()
+	assert.Equal(t, uuid.Version(7), prev.Version())
+	for range 1000 {
+		id := NewMessageID()
+		assert.Equal(t, 1, bytes.Compare(id[:], prev[:]), "v7 IDs increase in creation order")
+		prev = id
+	}
+
+	m := &Message{}
+	assert.NoError(t, m.BeforeCreate(nil))
+	assert.Equal(t, uuid.Version(7), m.ID.Version())
+
+	kept := uuid.New()
+	m = &Message{ID: kept}
+	assert.NoError(t, m.BeforeCreate(nil))
+	assert.Equal(t, kept, m.ID, "a given ID, v4 included, is kept")
+}
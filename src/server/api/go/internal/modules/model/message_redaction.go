@@ -0,0 +1,91 @@
+package model
+
+import (
+	"context"
+	"slices"
+)
+
+// MetaKeySensitive marks a part (bool) whose content only principals holding ScopeReadSensitive
+// may read; others get a placeholder from RedactForPrincipal instead.
+const MetaKeySensitive MetaKey = "sensitive"
+
+// MetaKeyRedacted is set (true) on the placeholder that replaces a sensitive part.
+const MetaKeyRedacted MetaKey = "redacted"
+
+// RedactedText is the text of the placeholder that replaces a sensitive part.
+const RedactedText = "[redacted]"
+
+// Principal scopes.
+const (
+	// ScopeReadSensitive lets a principal read the content of parts marked sensitive.
+	ScopeReadSensitive = "messages:read_sensitive"
+)
+
+// Scopes lists every scope; a project secret key holds all of them.
+var Scopes = []string{ScopeReadSensitive}
+
+type scopesKey struct{}
+
+// WithScopes returns a copy of ctx carrying the scopes of the principal it serves. The auth
+// middleware sets them from the credential the request was made with.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// ScopesFromContext returns the scopes WithScopes put on ctx. A context without them holds no
+// scope, so reads on it see sensitive parts redacted.
+func ScopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesKey{}).([]string)
+	return scopes
+}
+
+// IsSensitive reports whether the part is marked sensitive.
+func (p Part) IsSensitive() bool {
+	return p.GetMetaBool(MetaKeySensitive)
+}
+
+// redactedMetaKeys are the Meta keys a placeholder keeps so tool calls still pair with their
+// results after conversion.
+var redactedMetaKeys = []MetaKey{MetaKeyID, MetaKeyName, MetaKeyToolCallID}
+
+// RedactPart returns the placeholder that replaces a sensitive part. Text, thinking and tool
+// parts keep their type with RedactedText as content, and a tool call keeps its id and name with
+// empty arguments; any other part, such as a media part and its asset, becomes a text part.
+func RedactPart(p Part) Part {
+	meta := map[string]any{MetaKeyRedacted: true}
+	switch p.Type {
+	case PartTypeText, PartTypeThinking, PartTypeToolResult:
+	case PartTypeToolCall:
+		meta[MetaKeyArguments] = "{}"
+	default:
+		return Part{Type: PartTypeText, Text: RedactedText, Meta: meta}
+	}
+	for _, k := range redactedMetaKeys {
+		if v, ok := p.Meta[k]; ok {
+			meta[k] = v
+		}
+	}
+	out := Part{Type: p.Type, Meta: meta}
+	if p.Type != PartTypeToolCall {
+		out.Text = RedactedText
+	}
+	return out
+}
+
+// RedactForPrincipal returns msg as a principal holding scopes may read it: without
+// ScopeReadSensitive each sensitive part is replaced by RedactPart. The parts of msg are not
+// modified; a message with something to redact gets a new parts slice.
+func RedactForPrincipal(msg Message, scopes []string) Message {
+	if slices.Contains(scopes, ScopeReadSensitive) || !slices.ContainsFunc(msg.Parts, Part.IsSensitive) {
+		return msg
+	}
+	parts := make([]Part, len(msg.Parts))
+	for i, p := range msg.Parts {
+		if p.IsSensitive() {
+			p = RedactPart(p)
+		}
+		parts[i] = p
+	}
+	msg.Parts = parts
+	return msg
+}
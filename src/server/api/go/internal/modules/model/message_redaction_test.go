@@ -0,0 +1,38 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactForPrincipal(t *testing.T) {
+	sensitive := map[string]any{MetaKeySensitive: true}
+	msg := Message{Parts: []Part{
+		{Type: PartTypeText, Text: "hello"},
+		{Type: PartTypeText, Text: "account 1234", Meta: sensitive},
+		{Type: PartTypeImage, Asset: &Asset{SHA256: "abc"}, Filename: "id.png", Meta: sensitive},
+		{Type: PartTypeToolCall, Meta: map[string]any{MetaKeySensitive: true, MetaKeyID: "call_1", MetaKeyName: "lookup", MetaKeyArguments: `{"ssn":"123"}`}},
+		{Type: PartTypeToolResult, Text: "secret", Meta: map[string]any{MetaKeySensitive: true, MetaKeyToolCallID: "call_1"}},
+		{Type: PartTypeText, Text: "bye", Meta: map[string]any{MetaKeySensitive: false}},
+	}}
+
+	t.Run("redacts sensitive parts only", func(t *testing.T) {
+		got := RedactForPrincipal(msg, nil)
+		assert.Equal(t, []Part{
+			{Type: PartTypeText, Text: "hello"},
+			{Type: PartTypeText, Text: RedactedText, Meta: map[string]any{MetaKeyRedacted: true}},
+			{Type: PartTypeText, Text: RedactedText, Meta: map[string]any{MetaKeyRedacted: true}},
+			{Type: PartTypeToolCall, Meta: map[string]any{MetaKeyRedacted: true, MetaKeyID: "call_1", MetaKeyName: "lookup", MetaKeyArguments: "{}"}},
+			{Type: PartTypeToolResult, Text: RedactedText, Meta: map[string]any{MetaKeyRedacted: true, MetaKeyToolCallID: "call_1"}},
+			msg.Parts[5],
+		}, got.Parts)
+		assert.Equal(t, "account 1234", msg.Parts[1].Text, "the original parts are untouched")
+		assert.NotNil(t, msg.Parts[2].Asset)
+	})
+
+	t.Run("scope reveals sensitive parts", func(t *testing.T) {
+		got := RedactForPrincipal(msg, []string{ScopeReadSensitive})
+		assert.Equal(t, msg.Parts, got.Parts)
+	})
+}
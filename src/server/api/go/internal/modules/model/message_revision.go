@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// MessageRevision is a previous version of a message's parts, saved when the message is edited.
+// Versions count from 1 per message; the message itself holds the version after the newest revision.
+type MessageRevision struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	MessageID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_message_revision_version,priority:1" json:"message_id"`
+	Version   int       `gorm:"not null;uniqueIndex:idx_message_revision_version,priority:2" json:"version"`
+
+	// PartsAssetMeta points at the parts object the message had before the edit. The revision
+	// keeps that object's asset reference until the message is purged.
+	PartsAssetMeta datatypes.JSONType[Asset] `gorm:"type:jsonb;not null" swaggertype:"-" json:"-"`
+	Parts          []Part                    `gorm:"-" swaggertype:"array,object" json:"parts"`
+
+	TokenCount int `gorm:"not null;default:0" json:"token_count"`
+
+	// CreatedAt is when the version was replaced.
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	// MessageRevision <-> Message
+	Message *Message `gorm:"foreignKey:MessageID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (MessageRevision) TableName() string { return "message_revisions" }
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types pushed to session stream subscribers
+const (
+	StreamEventMessageCreated      = "message.created"
+	StreamEventMessagePartAppended = "message.part.appended"
+	StreamEventMessageFinalized    = "message.finalized"
+	StreamEventMessageDeleted      = "message.deleted"
+	StreamEventMessageReparented   = "message.reparented"
+	// StreamEventResync ends a stream the server closed, e.g. because the subscriber fell
+	// behind; events may have been missed, so clients refetch messages and resubscribe.
+	StreamEventResync = "stream.resync"
+)
+
+// MessageStreamEvent is the JSON payload NOTIFYed on a session's stream channel.
+// It carries message metadata only; clients fetch parts through the messages API.
+type MessageStreamEvent struct {
+	Type      string     `json:"type"`
+	SessionID uuid.UUID  `json:"session_id"`
+	MessageID uuid.UUID  `json:"message_id"`
+	ParentID  *uuid.UUID `json:"parent_id,omitempty"`
+	Role      string     `json:"role,omitempty"`
+	Streaming bool       `json:"streaming,omitempty"`
+	Delta     string     `json:"delta,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// MessageStreamChannel returns the LISTEN/NOTIFY channel carrying a session's stream events.
+func MessageStreamChannel(sessionID uuid.UUID) string {
+	return "session_stream_" + strings.ReplaceAll(sessionID.String(), "-", "")
+}
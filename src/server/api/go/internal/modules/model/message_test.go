@@ -0,0 +1,189 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPart_Validate(t *testing.T) {
+	asset := &Asset{S3Key: "assets/p/abc.png", MIME: "image/png"}
+
+	tests := []struct {
+		name    string
+		part    Part
+		wantErr string
+	}{
+		{name: "text", part: NewTextPart("hello")},
+		{name: "text without text", part: Part{Type: PartTypeText}, wantErr: "requires non-empty text"},
+		{name: "text with asset", part: Part{Type: PartTypeText, Text: "hi", Asset: asset}, wantErr: "must not reference an asset"},
+		{name: "thinking", part: NewThinkingPart("hmm", "sig")},
+		{name: "thinking without text", part: Part{Type: PartTypeThinking}, wantErr: "requires non-empty text"},
+		{name: "image with asset", part: Part{Type: PartTypeImage, Asset: asset}},
+		{name: "image by url", part: NewImagePartURL("https://example.com/a.png")},
+		{name: "image with text and no asset", part: Part{Type: PartTypeImage, Text: "caption"}, wantErr: "must not carry text"},
+		{name: "image without source", part: Part{Type: PartTypeImage}, wantErr: "requires an uploaded asset"},
+		{name: "image asset without mime", part: Part{Type: PartTypeImage, Asset: &Asset{S3Key: "k"}}, wantErr: "requires s3_key and mime"},
+		{name: "audio inline", part: NewAudioPart("AAAA", "wav")},
+		{name: "video with asset", part: Part{Type: PartTypeVideo, Asset: &Asset{S3Key: "k", MIME: "video/mp4"}}},
+		{name: "file by id", part: Part{Type: PartTypeFile, Meta: map[string]any{MetaKeyFileID: "file-1"}}},
+		{name: "file without source", part: Part{Type: PartTypeFile, Meta: map[string]any{MetaKeyFilename: "a.pdf"}}, wantErr: "requires an uploaded asset"},
+		{name: "tool-call", part: NewToolCallPart("call_1", "search", `{"q":"x"}`)},
+		{name: "tool-call without name", part: Part{Type: PartTypeToolCall, Meta: map[string]any{MetaKeyArguments: "{}"}}, wantErr: "requires 'name'"},
+		{name: "tool-call without arguments", part: Part{Type: PartTypeToolCall, Meta: map[string]any{MetaKeyName: "search"}}, wantErr: "requires 'arguments'"},
+		{name: "tool-result", part: NewToolResultPart("call_1", "42")},
+		{name: "tool-result without call reference", part: Part{Type: PartTypeToolResult, Text: "42"}, wantErr: "requires 'tool_call_id'"},
+		{name: "data", part: Part{Type: PartTypeData, Meta: map[string]any{MetaKeyDataType: "chart"}}},
+		{name: "data without data_type", part: Part{Type: PartTypeData}, wantErr: "requires 'data_type'"},
+		{name: "redacted thinking", part: NewRedactedThinkingPart("opaque")},
+		{name: "unknown type", part: Part{Type: "sticker"}, wantErr: "unknown part type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.part.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestValidateParts(t *testing.T) {
+	assert.NoError(t, ValidateParts([]Part{NewTextPart("a"), NewToolResultPart("call_1", "b")}))
+
+	err := ValidateParts([]Part{
+		{Type: PartTypeImage, Text: "caption"},
+		NewTextPart("ok"),
+		{Type: PartTypeToolResult},
+	})
+	var invalid *InvalidPartsError
+	if assert.True(t, errors.As(err, &invalid)) {
+		assert.Len(t, invalid.Parts, 2)
+		assert.Equal(t, 0, invalid.Parts[0].Index)
+		assert.Equal(t, 2, invalid.Parts[1].Index)
+	}
+}
+
+func TestNewAssetPart(t *testing.T) {
+	tests := []struct {
+		mime string
+		want PartType
+	}{
+		{"image/png", PartTypeImage},
+		{"audio/mpeg", PartTypeAudio},
+		{"video/mp4", PartTypeVideo},
+		{"application/pdf", PartTypeFile},
+		{"application/octet-stream", PartTypeFile},
+	}
+	for _, tt := range tests {
+		part := NewAssetPart(Asset{MIME: tt.mime, S3Key: "assets/x"}, "x")
+		assert.Equal(t, tt.want, part.Type, tt.mime)
+		assert.NoError(t, part.Validate(), tt.mime)
+	}
+}
+
+func TestParseMessageFields(t *testing.T) {
+	fields, err := ParseMessageFields(" id,role, created_at,role,")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "role", "created_at"}, fields)
+
+	fields, err = ParseMessageFields("")
+	require.NoError(t, err)
+	assert.Nil(t, fields)
+
+	_, err = ParseMessageFields("id,search_text")
+	assert.ErrorIs(t, err, ErrUnknownMessageField)
+	assert.ErrorContains(t, err, "search_text")
+}
+
+func TestMessageFieldsColumns(t *testing.T) {
+	assert.Nil(t, MessageFieldsColumns(nil))
+	assert.Equal(t, []string{"id", "seq", "created_at", "role"}, MessageFieldsColumns([]string{"role", "id"}))
+	assert.Equal(t, []string{"id", "seq", "created_at", "parts_asset_meta"}, MessageFieldsColumns([]string{"parts"}))
+}
+
+func TestMessage_PartAccessors(t *testing.T) {
+	msg := Message{Parts: []Part{
+		{Type: PartTypeText, Text: "first"},
+		{Type: PartTypeImage, Filename: "a.png"},
+		{Type: PartTypeToolCall, Meta: map[string]any{"name": "search"}},
+		{Type: PartTypeText, Text: ""},
+		{Type: PartTypeToolResult, Meta: map[string]any{"tool_call_id": "call_1"}},
+		{Type: PartTypeFile, Filename: "b.pdf"},
+		{Type: PartTypeThinking, Text: "hidden"},
+		{Type: PartTypeText, Text: "second"},
+	}}
+
+	texts := msg.TextParts()
+	require.Len(t, texts, 3)
+	assert.Equal(t, "first", texts[0].Text)
+	assert.Equal(t, "second", texts[2].Text)
+
+	media := msg.MediaParts()
+	require.Len(t, media, 2)
+	assert.Equal(t, "a.png", media[0].Filename)
+	assert.Equal(t, "b.pdf", media[1].Filename)
+
+	calls := msg.ToolCalls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, "search", calls[0].Meta["name"])
+	assert.Len(t, msg.ToolResults(), 1)
+
+	assert.Equal(t, "first\nsecond", msg.ConcatText(), "empty and non-text parts are skipped")
+}
+
+func TestMessage_PartAccessorsEmpty(t *testing.T) {
+	for _, msg := range []Message{{}, {Parts: []Part{}}, {Parts: []Part{{Type: PartTypeImage}}}} {
+		assert.Empty(t, msg.TextParts())
+		assert.Empty(t, msg.ToolCalls())
+		assert.Empty(t, msg.ToolResults())
+		assert.Equal(t, "", msg.ConcatText())
+	}
+	assert.Empty(t, (&Message{}).MediaParts())
+}
+
+func TestIsAuthorType(t *testing.T) {
+	for _, typ := range []string{AuthorTypeUser, AuthorTypeAgent, AuthorTypeSystem} {
+		assert.True(t, IsAuthorType(typ), typ)
+	}
+	assert.False(t, IsAuthorType(""))
+	assert.False(t, IsAuthorType(RoleAssistant))
+}
+
+func TestIsAnnotationKind(t *testing.T) {
+	for _, kind := range AnnotationKinds {
+		assert.True(t, IsAnnotationKind(kind), kind)
+	}
+	assert.False(t, IsAnnotationKind(""))
+	assert.False(t, IsAnnotationKind("thumbs_up"))
+	assert.True(t, IsReaction(ReactionThumbsDown))
+	assert.False(t, IsReaction(AnnotationKindLabel))
+}
+
+func TestIsPartType(t *testing.T) {
+	for _, typ := range PartTypes {
+		assert.True(t, IsPartType(typ), typ)
+	}
+	assert.False(t, IsPartType(""))
+	assert.False(t, IsPartType("pdf"))
+}
+
+func TestSession_CheckPartTypes(t *testing.T) {
+	parts := []Part{NewTextPart("hi"), {Type: PartTypeImage}, NewTextPart("bye")}
+
+	assert.NoError(t, (&Session{}).CheckPartTypes(parts), "an empty allowlist allows every type")
+	assert.NoError(t, (&Session{AllowedPartTypes: []string{PartTypeText, PartTypeImage}}).CheckPartTypes(parts))
+
+	err := (&Session{AllowedPartTypes: []string{PartTypeText}}).CheckPartTypes(parts)
+	var invalid *InvalidPartsError
+	if assert.ErrorAs(t, err, &invalid) {
+		assert.Len(t, invalid.Parts, 1)
+		assert.Equal(t, 1, invalid.Parts[0].Index)
+		assert.Contains(t, invalid.Parts[0].Error, "image")
+	}
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+)
+
+// MetaKeyTextGzip holds the base64 of the gzip-compressed text of a part stored compressed; the
+// part's Text is empty until DecompressParts restores it. It is never set on parts clients see.
+const MetaKeyTextGzip MetaKey = "text_gzip"
+
+// CompressParts returns parts as they are stored in a parts object: the text of each part longer
+// than threshold bytes is moved into MetaKeyTextGzip when that makes the part smaller. Text that
+// does not shrink, such as already compressed data, is left as it is. parts is not modified; a
+// threshold <= 0 returns it unchanged. saved is how many bytes of text the compression saved.
+func CompressParts(parts []Part, threshold int) (out []Part, saved int64, err error) {
+	if threshold <= 0 {
+		return parts, 0, nil
+	}
+	for i, p := range parts {
+		if len(p.Text) <= threshold {
+			continue
+		}
+		encoded, err := gzipText(p.Text)
+		if err != nil {
+			return nil, 0, fmt.Errorf("compress part %d: %w", i, err)
+		}
+		if len(encoded) >= len(p.Text) {
+			continue
+		}
+		if out == nil {
+			out = make([]Part, len(parts))
+			copy(out, parts)
+		}
+		meta := maps.Clone(p.Meta)
+		if meta == nil {
+			meta = map[string]any{}
+		}
+		meta[MetaKeyTextGzip] = encoded
+		out[i].Text, out[i].Meta = "", meta
+		saved += int64(len(p.Text) - len(encoded))
+	}
+	if out == nil {
+		return parts, 0, nil
+	}
+	return out, saved, nil
+}
+
+// DecompressParts restores in place the text of the parts CompressParts compressed, so parts
+// read from a parts object are the parts that were written.
+func DecompressParts(parts []Part) error {
+	for i := range parts {
+		encoded, ok := parts[i].Meta[MetaKeyTextGzip].(string)
+		if !ok {
+			continue
+		}
+		text, err := gunzipText(encoded)
+		if err != nil {
+			return fmt.Errorf("decompress part %d: %w", i, err)
+		}
+		parts[i].Text = text
+		delete(parts[i].Meta, MetaKeyTextGzip)
+		if len(parts[i].Meta) == 0 {
+			parts[i].Meta = nil
+		}
+	}
+	return nil
+}
+
+func gzipText(text string) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, text); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func gunzipText(encoded string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	text, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
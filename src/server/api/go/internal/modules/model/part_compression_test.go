@@ -0,0 +1,82 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressParts_RoundTrip(t *testing.T) {
+	large := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 2000)
+	parts := []Part{
+		{Type: PartTypeText, Text: "small"},
+		{Type: PartTypeText, Text: large, Meta: map[string]any{MetaKeySensitive: true}},
+		{Type: PartTypeToolResult, Text: large, Meta: map[string]any{MetaKeyToolCallID: "call_1"}},
+		{Type: PartTypeImage, Asset: &Asset{SHA256: "abc"}},
+	}
+	original := make([]Part, len(parts))
+	copy(original, parts)
+
+	stored, saved, err := CompressParts(parts, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, original, parts, "input is not modified")
+	assert.Equal(t, "small", stored[0].Text)
+	assert.Nil(t, stored[0].Meta)
+	for _, i := range []int{1, 2} {
+		assert.Empty(t, stored[i].Text)
+		assert.Contains(t, stored[i].Meta, MetaKeyTextGzip)
+	}
+	assert.Equal(t, true, stored[1].Meta[MetaKeySensitive], "other meta is kept")
+
+	plain, err := json.Marshal(parts)
+	require.NoError(t, err)
+	compressed, err := json.Marshal(stored)
+	require.NoError(t, err)
+	assert.InDelta(t, len(plain)-len(compressed), saved, 64, "saved leaves out the meta keys added")
+	assert.Less(t, len(compressed)*10, len(plain), "repetitive text compresses well")
+
+	var read []Part
+	require.NoError(t, json.Unmarshal(compressed, &read))
+	require.NoError(t, DecompressParts(read))
+	var want []Part
+	require.NoError(t, json.Unmarshal(plain, &want))
+	assert.Equal(t, want, read)
+}
+
+func TestCompressParts_Skips(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		parts := []Part{{Type: PartTypeText, Text: strings.Repeat("a", 4096)}}
+		stored, saved, err := CompressParts(parts, 0)
+		require.NoError(t, err)
+		assert.Equal(t, parts, stored)
+		assert.Zero(t, saved)
+	})
+
+	t.Run("incompressible text", func(t *testing.T) {
+		raw := make([]byte, 4096)
+		_, err := rand.Read(raw)
+		require.NoError(t, err)
+		parts := []Part{{Type: PartTypeText, Text: base64.StdEncoding.EncodeToString(raw)}}
+
+		stored, saved, err := CompressParts(parts, 1024)
+		require.NoError(t, err)
+		assert.Equal(t, parts, stored)
+		assert.Zero(t, saved)
+	})
+
+	t.Run("plain parts decompress unchanged", func(t *testing.T) {
+		parts := []Part{{Type: PartTypeText, Text: "hi", Meta: map[string]any{MetaKeyName: "x"}}}
+		require.NoError(t, DecompressParts(parts))
+		assert.Equal(t, []Part{{Type: PartTypeText, Text: "hi", Meta: map[string]any{MetaKeyName: "x"}}}, parts)
+	})
+
+	t.Run("corrupt text fails", func(t *testing.T) {
+		parts := []Part{{Type: PartTypeText, Meta: map[string]any{MetaKeyTextGzip: "not gzip"}}}
+		assert.Error(t, DecompressParts(parts))
+	})
+}
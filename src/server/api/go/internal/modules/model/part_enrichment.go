@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+const (
+	EnrichmentStatusPending = "pending"
+	EnrichmentStatusRunning = "running"
+	EnrichmentStatusDone    = "done"
+	EnrichmentStatusFailed  = "failed"
+)
+
+// PartEnrichment is a queued run of one enricher (OCR, ASR, captioning, ...) over the asset of a
+// media part. When it succeeds the result is written into the part's Meta under the enricher name.
+type PartEnrichment struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"-"`
+	ProjectID uuid.UUID `gorm:"type:uuid;not null" json:"-"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null" json:"-"`
+	MessageID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_part_enrichment_job,priority:1" json:"message_id"`
+	PartIndex int       `gorm:"not null;uniqueIndex:idx_part_enrichment_job,priority:2" json:"part_index"`
+	Enricher  string    `gorm:"type:text;not null;uniqueIndex:idx_part_enrichment_job,priority:3" json:"enricher"`
+
+	// AssetMeta is the asset the job was queued for; the result is only written back while the
+	// part still holds it.
+	AssetMeta datatypes.JSONType[Asset] `gorm:"type:jsonb;not null" swaggertype:"-" json:"-"`
+
+	Status   string `gorm:"type:varchar(16);not null;default:'pending';index:idx_part_enrichment_status_updated,priority:1" json:"status"`
+	Attempts int    `gorm:"not null;default:0" json:"attempts"`
+	Error    string `gorm:"type:text;not null;default:''" json:"error,omitempty"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP;index:idx_part_enrichment_status_updated,priority:2" json:"updated_at"`
+
+	// PartEnrichment <-> Message
+	Message *Message `gorm:"foreignKey:MessageID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (PartEnrichment) TableName() string { return "part_enrichments" }
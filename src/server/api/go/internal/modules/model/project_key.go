@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// MaxProjectKeyNameLength bounds ProjectKey.Name.
+const MaxProjectKeyNameLength = 255
+
+// ProjectKey is an API key of a project other than its secret key. It authenticates as the
+// project but holds only the scopes it was created with, so a reader such as a dashboard can be
+// given a key that sees sensitive parts redacted.
+type ProjectKey struct {
+	ID               uuid.UUID                   `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	ProjectID        uuid.UUID                   `gorm:"type:uuid;not null;index" json:"project_id"`
+	Name             string                      `gorm:"type:varchar(255);not null;default:''" json:"name"`
+	SecretKeyHMAC    string                      `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	SecretKeyHashPHC string                      `gorm:"type:varchar(255);not null" json:"-"`
+	Scopes           datatypes.JSONSlice[string] `gorm:"type:jsonb;not null;default:'[]'" swaggertype:"array,string" json:"scopes"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	// ProjectKey <-> Project
+	Project *Project `gorm:"foreignKey:ProjectID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
+}
+
+func (ProjectKey) TableName() string { return "project_keys" }
@@ -1,22 +1,84 @@
 package model
 
 import (
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 type Session struct {
 	ID                  uuid.UUID         `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
-	ProjectID           uuid.UUID         `gorm:"type:uuid;not null;index" json:"project_id"`
+	ProjectID           uuid.UUID         `gorm:"type:uuid;not null;index;index:idx_sessions_project_last_message_at,priority:1" json:"project_id"`
 	UserID              *uuid.UUID        `gorm:"type:uuid;index" json:"user_id"`
 	DisableTaskTracking bool              `gorm:"not null;default:false" json:"disable_task_tracking"`
 	Configs             datatypes.JSONMap `gorm:"type:jsonb;index:idx_sessions_configs,type:gin" swaggertype:"object" json:"configs"`
 
+	// Metadata holds caller-defined key/values; Tags are lowercased and unique. Both are GIN-indexed
+	// for containment filters when listing sessions.
+	Metadata datatypes.JSONMap `gorm:"type:jsonb;not null;default:'{}';index:idx_sessions_metadata,type:gin" swaggertype:"object" json:"metadata"`
+	Tags     StringArray       `gorm:"type:text[];not null;default:'{}';index:idx_sessions_tags,type:gin" swaggertype:"array,string" json:"tags"`
+
+	// IsTemplate marks a reusable session that InstantiateTemplate copies into new sessions.
+	// Templates are left out of session listings unless asked for.
+	IsTemplate bool `gorm:"not null;default:false" json:"is_template"`
+
+	// TotalBytes is the sum of StorageBytes over the session's live messages, kept up to date in the
+	// transactions that create, edit, delete and restore them.
+	TotalBytes int64 `gorm:"not null;default:0" json:"total_bytes"`
+
+	// MessageCount and LastMessageAt summarize the session's live messages so listings can sort by
+	// activity without aggregating them: the count, and the creation time of the newest one, or of
+	// the session itself when that is later. They are kept up to date in the transactions that add
+	// and remove messages; a purged archive keeps the values it had when it was archived.
+	MessageCount  int       `gorm:"not null;default:0" json:"message_count"`
+	LastMessageAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index:idx_sessions_project_last_message_at,priority:2" json:"last_message_at"`
+
+	// LastMessageSeq is the Seq given to the session's newest message; inserts advance it.
+	LastMessageSeq int64 `gorm:"not null;default:0" json:"-"`
+
+	// Summary condenses the session's messages up to and including SummarizedUpToMessageID.
+	// SummarizeSession folds newer messages in incrementally.
+	Summary                 string     `gorm:"type:text;not null;default:''" json:"summary,omitempty"`
+	SummarizedUpToMessageID *uuid.UUID `gorm:"type:uuid" json:"summarized_up_to_message_id,omitempty"`
+
+	// SystemPrompt overrides the project's default system prompt for this session; an empty
+	// string turns injection off, nil inherits the project default.
+	SystemPrompt *string `gorm:"type:text" json:"system_prompt,omitempty"`
+
+	// AllowedPartTypes restricts the part types the session's messages may carry; empty allows
+	// every known type.
+	AllowedPartTypes datatypes.JSONSlice[string] `gorm:"type:jsonb;not null;default:'[]'" swaggertype:"array,string" json:"allowed_part_types"`
+
+	// Archived sessions are snapshotted to the ArchiveKey object in cold storage and left out of
+	// session listings; no messages can be added to them until they are restored. ArchivePurged
+	// marks an archive whose message rows were deleted, leaving the session as a stub; the
+	// archive then holds the asset references of the parts objects listed in ArchiveAssets.
+	Archived      bool                       `gorm:"not null;default:false;index" json:"archived"`
+	ArchivePurged bool                       `gorm:"not null;default:false" json:"archive_purged,omitempty"`
+	ArchivedAt    *time.Time                 `json:"archived_at,omitempty"`
+	ArchiveKey    string                     `gorm:"type:text;not null;default:''" json:"-"`
+	ArchiveAssets datatypes.JSONSlice[Asset] `gorm:"type:jsonb;not null;default:'[]'" swaggertype:"-" json:"-"`
+
+	// FinalizedAt locks a finalized session: no messages can be added to it, and its messages
+	// can no longer be edited or deleted without an admin override.
+	FinalizedAt *time.Time `json:"finalized_at,omitempty"`
+
+	// BaseMessageID is set on a shallow clone: the thread ending at this message is shared with
+	// BaseSessionID, the session owning its rows, instead of being copied. The clone's first own
+	// message points at it as its parent. See SessionRepo.CloneSessionShallow.
+	BaseSessionID *uuid.UUID `gorm:"type:uuid;index" json:"base_session_id,omitempty"`
+	BaseMessageID *uuid.UUID `gorm:"type:uuid" json:"base_message_id,omitempty"`
+
 	CreatedAt time.Time `gorm:"autoCreateTime;not null;default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime;not null;default:CURRENT_TIMESTAMP" json:"updated_at"`
 
+	// DeletedAt marks the session as soft-deleted; its messages are hidden with it until purged.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
 	// Session <-> Project
 	Project *Project `gorm:"foreignKey:ProjectID;references:ID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE;" json:"-"`
 
@@ -35,6 +97,9 @@ type Session struct {
 
 func (Session) TableName() string { return "sessions" }
 
+// SessionMetadataKeyTitle is the Metadata key holding a session's display title.
+const SessionMetadataKeyTitle = "title"
+
 // MessageObservingStatus represents the count of messages by their observing status
 type MessageObservingStatus struct {
 	Observed  int       `json:"observed"`
@@ -42,3 +107,20 @@ type MessageObservingStatus struct {
 	Pending   int       `json:"pending"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// CheckPartTypes reports the parts whose type AllowedPartTypes leaves out as an *InvalidPartsError.
+func (s *Session) CheckPartTypes(parts []Part) error {
+	if len(s.AllowedPartTypes) == 0 {
+		return nil
+	}
+	var invalid []PartError
+	for i, p := range parts {
+		if !slices.Contains(s.AllowedPartTypes, p.Type) {
+			invalid = append(invalid, PartError{Index: i, Error: fmt.Sprintf("%s parts are not allowed in this session", p.Type)})
+		}
+	}
+	if len(invalid) > 0 {
+		return &InvalidPartsError{Parts: invalid}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionLock is an advisory lock an agent takes on a session so that no other writer
+// interleaves messages with its turns. It lapses at ExpiresAt unless its holder renews it, so a
+// crashed holder cannot keep the session locked.
+type SessionLock struct {
+	SessionID uuid.UUID `json:"session_id"`
+	HolderID  string    `json:"holder_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// StringArray is a Postgres text[] value, encoded and decoded in its text form with the pgtype
+// array codec, e.g. `{research,"quo\"te"}`. A nil StringArray is written as an empty array.
+type StringArray []string
+
+// Value implements driver.Valuer.
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		a = StringArray{}
+	}
+	// A Map memoizes encode plans and is not safe for concurrent use, so each call takes its own.
+	buf, err := pgtype.NewMap().Encode(pgtype.TextArrayOID, pgtype.TextFormatCode, []string(a), nil)
+	if err != nil {
+		return nil, fmt.Errorf("encode text array: %w", err)
+	}
+	return string(buf), nil
+}
+
+// Scan implements sql.Scanner.
+func (a *StringArray) Scan(src any) error {
+	switch src.(type) {
+	case string, []byte:
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into StringArray", src)
+	}
+	var out []string
+	if err := pgtype.NewMap().SQLScanner(&out).Scan(src); err != nil {
+		return fmt.Errorf("decode text array: %w", err)
+	}
+	*a = out
+	return nil
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringArray_RoundTrip(t *testing.T) {
+	for _, in := range []StringArray{
+		{},
+		{"research"},
+		{"q3 review", `quo"te`, `back\slash`, "comma,tag", "{brace}", "NULL", ""},
+	} {
+		v, err := in.Value()
+		require.NoError(t, err)
+
+		var out StringArray
+		require.NoError(t, out.Scan(v))
+		assert.Equal(t, in, out)
+
+		require.NoError(t, out.Scan([]byte(v.(string))))
+		assert.Equal(t, in, out)
+	}
+}
+
+func TestStringArray_Value(t *testing.T) {
+	v, err := StringArray{"research", `quo"te`}.Value()
+	require.NoError(t, err)
+	assert.Equal(t, `{research,"quo\"te"}`, v)
+
+	v, err = StringArray(nil).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", v, "nil is stored as an empty array")
+}
+
+func TestStringArray_Scan(t *testing.T) {
+	a := StringArray{"stale"}
+	require.NoError(t, a.Scan(nil))
+	assert.Nil(t, a)
+
+	assert.Error(t, a.Scan(42))
+	assert.Error(t, a.Scan("not an array"))
+}
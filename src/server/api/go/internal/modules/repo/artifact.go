@@ -34,6 +34,9 @@ func NewArtifactRepo(db *gorm.DB, assetReferenceRepo AssetReferenceRepo) Artifac
 func (r *artifactRepo) Create(ctx context.Context, projectID uuid.UUID, a *model.Artifact) error {
 	// Save asset meta before creation for reference increment
 	asset := a.AssetMeta.Data()
+	if a.SHA256 == "" {
+		a.SHA256 = asset.SHA256
+	}
 
 	// Use transaction to ensure atomicity: create artifact and increment reference
 	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
@@ -49,6 +52,32 @@ func (r *artifactRepo) Create(ctx context.Context, projectID uuid.UUID, a *model
 	})
 }
 
+// BackfillArtifactHashes copies the content hash out of asset_meta into the sha256 column for
+// artifacts stored before the column existed, batchSize rows per statement so large tables are
+// not locked in one update. It returns the number of rows updated.
+func BackfillArtifactHashes(ctx context.Context, db *gorm.DB, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("BackfillArtifactHashes: batch size must be positive")
+	}
+	var total int64
+	for {
+		res := db.WithContext(ctx).Exec(`
+			UPDATE artifacts SET sha256 = asset_meta->>'sha256'
+			WHERE id IN (
+				SELECT id FROM artifacts
+				WHERE COALESCE(sha256, '') = '' AND COALESCE(asset_meta->>'sha256', '') <> ''
+				LIMIT ?
+			)`, batchSize)
+		if res.Error != nil {
+			return total, fmt.Errorf("backfill artifact hashes: %w", res.Error)
+		}
+		total += res.RowsAffected
+		if res.RowsAffected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
 func (r *artifactRepo) DeleteByPath(ctx context.Context, projectID uuid.UUID, diskID uuid.UUID, path string, filename string) error {
 	var a model.Artifact
 	err := r.db.WithContext(ctx).Where("disk_id = ? AND path = ? AND filename = ?", diskID, path, filename).First(&a).Error
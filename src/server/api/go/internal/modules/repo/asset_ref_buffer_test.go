@@ -45,6 +45,25 @@ func (m *mockAssetReferenceRepoForBuffer) BatchDecrementAssetRefs(_ context.Cont
 func (m *mockAssetReferenceRepoForBuffer) ListS3KeysByProject(_ context.Context, _ uuid.UUID) ([]string, error) {
 	return nil, nil
 }
+func (m *mockAssetReferenceRepoForBuffer) FindAssetByHash(_ context.Context, _ uuid.UUID, _ string) (*model.Asset, error) {
+	return nil, nil
+}
+func (m *mockAssetReferenceRepoForBuffer) SetAssetMeta(_ context.Context, _ uuid.UUID, _ string, _ map[string]any) error {
+	return nil
+}
+func (m *mockAssetReferenceRepoForBuffer) GetByIDs(_ context.Context, _ uuid.UUID, _ []uuid.UUID) ([]model.AssetReference, error) {
+	return nil, nil
+}
+func (m *mockAssetReferenceRepoForBuffer) RegisterAsset(_ context.Context, _ uuid.UUID, _ model.Asset) (*model.AssetReference, bool, error) {
+	return nil, false, nil
+}
+func (m *mockAssetReferenceRepoForBuffer) CollectOrphanedAssets(_ context.Context, _ time.Time, _ int, _ bool) ([]model.AssetReference, error) {
+	return nil, nil
+}
+
+func (m *mockAssetReferenceRepoForBuffer) DeleteUnreferencedAssets(_ context.Context, _ uuid.UUID, _ []uuid.UUID, _ time.Time) ([]model.AssetReference, error) {
+	return nil, nil
+}
 
 func setupMiniRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
 	t.Helper()
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -27,6 +28,12 @@ type AssetReferenceRepo interface {
 	BatchIncrementAssetRefsWithCounts(ctx context.Context, projectID uuid.UUID, increments []AssetRefIncrement) error
 	BatchDecrementAssetRefs(ctx context.Context, projectID uuid.UUID, assets []model.Asset) error
 	ListS3KeysByProject(ctx context.Context, projectID uuid.UUID) ([]string, error)
+	FindAssetByHash(ctx context.Context, projectID uuid.UUID, sha256 string) (*model.Asset, error)
+	SetAssetMeta(ctx context.Context, projectID uuid.UUID, sha256 string, meta map[string]any) error
+	GetByIDs(ctx context.Context, projectID uuid.UUID, ids []uuid.UUID) ([]model.AssetReference, error)
+	RegisterAsset(ctx context.Context, projectID uuid.UUID, asset model.Asset) (ref *model.AssetReference, created bool, err error)
+	CollectOrphanedAssets(ctx context.Context, cutoff time.Time, limit int, dryRun bool) ([]model.AssetReference, error)
+	DeleteUnreferencedAssets(ctx context.Context, projectID uuid.UUID, ids []uuid.UUID, cutoff time.Time) ([]model.AssetReference, error)
 }
 
 type assetReferenceRepo struct {
@@ -98,7 +105,7 @@ func (r *assetReferenceRepo) DecrementAssetRef(ctx context.Context, projectID uu
 	}
 
 	if ref.RefCount <= 1 {
-		if err := r.s3.DeleteObject(ctx, ref.S3Key); err != nil {
+		if err := r.deleteAssetObject(ctx, ref.S3Key); err != nil {
 			return err
 		}
 		return r.db.WithContext(ctx).Session(&gorm.Session{SkipHooks: true}).Delete(&ref).Error
@@ -109,6 +116,14 @@ func (r *assetReferenceRepo) DecrementAssetRef(ctx context.Context, projectID uu
 		UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error
 }
 
+// deleteAssetObject deletes the object at key together with the assets derived from it.
+func (r *assetReferenceRepo) deleteAssetObject(ctx context.Context, key string) error {
+	if err := r.s3.DeleteObject(ctx, key); err != nil {
+		return err
+	}
+	return r.s3.DeleteObjectsByPrefix(ctx, blob.DerivedKeyPrefix(key))
+}
+
 // BatchIncrementAssetRefs increments reference counts for a slice of assets.
 // Duplicated assets (by sha256) in the slice are coalesced and counted.
 // Uses SkipHooks to prevent recursive hook triggers when called from other hooks.
@@ -247,7 +262,7 @@ func (r *assetReferenceRepo) BatchDecrementAssetRefs(ctx context.Context, projec
 			return err
 		}
 		if ref.RefCount <= dec {
-			if err := r.s3.DeleteObject(ctx, ref.S3Key); err != nil {
+			if err := r.deleteAssetObject(ctx, ref.S3Key); err != nil {
 				return err
 			}
 			if err := sessionTx.Delete(&ref).Error; err != nil {
@@ -276,3 +291,215 @@ func (r *assetReferenceRepo) ListS3KeysByProject(ctx context.Context, projectID
 	}
 	return keys, nil
 }
+
+// FindAssetByHash returns the canonical stored asset with the given content hash in the project,
+// or nil when no referenced asset has that hash. The returned S3Key is the canonical key. The
+// asset's last_referenced_at is refreshed, so neither DeleteUnreferencedAssets nor orphan
+// collection deletes it before the caller's buffered reference is counted.
+func (r *assetReferenceRepo) FindAssetByHash(ctx context.Context, projectID uuid.UUID, sha256 string) (*model.Asset, error) {
+	if sha256 == "" {
+		return nil, nil
+	}
+	var ref model.AssetReference
+	err := r.db.WithContext(ctx).Raw(
+		`UPDATE asset_references SET last_referenced_at = ?
+		WHERE project_id = ? AND sha256 = ? AND ref_count > 0 AND s3_key != ''
+		RETURNING *`,
+		time.Now(), projectID, sha256,
+	).Scan(&ref).Error
+	if err != nil {
+		return nil, fmt.Errorf("find asset by hash: %w", err)
+	}
+	if ref.ID == uuid.Nil {
+		return nil, nil
+	}
+	asset := ref.AssetMeta.Data()
+	asset.SHA256 = ref.SHA256
+	asset.S3Key = ref.S3Key
+	// Extracted text belongs to whichever entity stored the asset first.
+	asset.Content = ""
+	return &asset, nil
+}
+
+// SetAssetMeta stores meta as the Asset.Meta of the project's asset with the given hash, so
+// messages that link the stored asset later carry the metadata extracted after it was registered.
+func (r *assetReferenceRepo) SetAssetMeta(ctx context.Context, projectID uuid.UUID, sha256 string, meta map[string]any) error {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("marshal asset meta: %w", err)
+	}
+	return r.db.WithContext(ctx).Session(&gorm.Session{SkipHooks: true}).Model(&model.AssetReference{}).
+		Where("project_id = ? AND sha256 = ?", projectID, sha256).
+		UpdateColumn("asset_meta", gorm.Expr("jsonb_set(asset_meta, '{meta}', ?::jsonb)", string(raw))).Error
+}
+
+// GetByIDs returns the project's asset rows with the given IDs, in no particular order. IDs that
+// do not exist or belong to another project are left out.
+func (r *assetReferenceRepo) GetByIDs(ctx context.Context, projectID uuid.UUID, ids []uuid.UUID) ([]model.AssetReference, error) {
+	var refs []model.AssetReference
+	if len(ids) == 0 {
+		return refs, nil
+	}
+	err := r.db.WithContext(ctx).
+		Where("project_id = ? AND id IN ?", projectID, ids).
+		Find(&refs).Error
+	if err != nil {
+		return nil, fmt.Errorf("get assets by ids: %w", err)
+	}
+	return refs, nil
+}
+
+// RegisterAsset records asset in the project without adding a reference, so content uploaded on
+// its own has an asset row before anything links it. When the project already stores the same
+// content, the existing row is returned and created is false; its last_referenced_at is refreshed
+// so orphan collection leaves it alone for another grace period.
+func (r *assetReferenceRepo) RegisterAsset(ctx context.Context, projectID uuid.UUID, asset model.Asset) (*model.AssetReference, bool, error) {
+	if projectID == uuid.Nil {
+		return nil, false, fmt.Errorf("RegisterAsset: project_id is required")
+	}
+	if asset.SHA256 == "" {
+		return nil, false, fmt.Errorf("RegisterAsset: asset.sha256 is required")
+	}
+
+	now := time.Now()
+	row := model.AssetReference{
+		ProjectID:        projectID,
+		SHA256:           asset.SHA256,
+		S3Key:            asset.S3Key,
+		RefCount:         0,
+		AssetMeta:        datatypes.NewJSONType(asset),
+		LastReferencedAt: now,
+	}
+	tx := r.db.WithContext(ctx).Session(&gorm.Session{SkipHooks: true})
+	res := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}, {Name: "sha256"}},
+		DoNothing: true,
+	}).Omit(clause.Associations).Create(&row)
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return &row, true, nil
+	}
+
+	var existing model.AssetReference
+	if err := tx.Where("project_id = ? AND sha256 = ?", projectID, asset.SHA256).First(&existing).Error; err != nil {
+		return nil, false, err
+	}
+	if err := tx.Model(&existing).UpdateColumn("last_referenced_at", now).Error; err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+// CollectOrphanedAssets removes up to limit assets, across all projects, that have no references
+// and were neither created nor referenced since cutoff. Objects are deleted from S3 before their
+// rows. Rows are locked for the duration, so a concurrent reference either waits and recreates the
+// row, or lands first and excludes it. With dryRun nothing is locked or deleted; the assets that
+// would be collected are returned.
+func (r *assetReferenceRepo) CollectOrphanedAssets(ctx context.Context, cutoff time.Time, limit int, dryRun bool) ([]model.AssetReference, error) {
+	orphaned := func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("ref_count <= 0 AND last_referenced_at < ? AND created_at < ?", cutoff, cutoff).
+			Order("last_referenced_at ASC").
+			Limit(limit)
+	}
+
+	var refs []model.AssetReference
+	if dryRun {
+		if err := orphaned(r.db.WithContext(ctx)).Find(&refs).Error; err != nil {
+			return nil, fmt.Errorf("list orphaned assets: %w", err)
+		}
+		return refs, nil
+	}
+
+	return r.deleteLockedAssets(ctx, orphaned)
+}
+
+// DeleteUnreferencedAssets removes the project's assets with the given IDs that have no references
+// left and were neither created nor referenced since cutoff, objects first. It is used right after
+// references are released to free their storage without waiting for CollectOrphanedAssets; an
+// asset referenced again in the meantime, or recently enough that the reference may still be
+// buffered, is left alone.
+func (r *assetReferenceRepo) DeleteUnreferencedAssets(ctx context.Context, projectID uuid.UUID, ids []uuid.UUID, cutoff time.Time) ([]model.AssetReference, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return r.deleteLockedAssets(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("project_id = ? AND id IN ? AND ref_count <= 0 AND last_referenced_at < ? AND created_at < ?",
+			projectID, ids, cutoff, cutoff)
+	})
+}
+
+// deleteLockedAssets locks the asset rows selected by scope, skipping rows locked elsewhere,
+// deletes their objects and derived objects, and then the rows. When an object cannot be
+// deleted nothing is, and the rows stay for a later attempt.
+func (r *assetReferenceRepo) deleteLockedAssets(ctx context.Context, scope func(tx *gorm.DB) *gorm.DB) ([]model.AssetReference, error) {
+	var refs []model.AssetReference
+	err := r.db.WithContext(ctx).Session(&gorm.Session{SkipHooks: true}).Transaction(func(tx *gorm.DB) error {
+		if err := scope(tx).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Find(&refs).Error; err != nil {
+			return fmt.Errorf("lock orphaned assets: %w", err)
+		}
+		if len(refs) == 0 {
+			return nil
+		}
+
+		keys := make([]string, 0, len(refs))
+		ids := make([]uuid.UUID, 0, len(refs))
+		for _, ref := range refs {
+			if ref.S3Key != "" {
+				keys = append(keys, ref.S3Key)
+			}
+			ids = append(ids, ref.ID)
+		}
+		if err := r.s3.DeleteObjects(ctx, keys); err != nil {
+			return fmt.Errorf("delete orphaned asset objects: %w", err)
+		}
+		for _, key := range keys {
+			if err := r.s3.DeleteObjectsByPrefix(ctx, blob.DerivedKeyPrefix(key)); err != nil {
+				return fmt.Errorf("delete derived asset objects: %w", err)
+			}
+		}
+		return tx.Where("id IN ?", ids).Delete(&model.AssetReference{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// releaseAssetRefs drops one reference per occurrence in assets from the project's asset rows as
+// part of tx, without touching object storage. It returns how many distinct assets were released
+// and the IDs of those left without references, whose objects the caller deletes after commit.
+func releaseAssetRefs(tx *gorm.DB, projectID uuid.UUID, assets []model.Asset) (int, []uuid.UUID, error) {
+	grouped := make(map[string]int)
+	for _, a := range assets {
+		if a.SHA256 != "" {
+			grouped[a.SHA256]++
+		}
+	}
+
+	released := 0
+	var orphaned []uuid.UUID
+	now := time.Now()
+	for sha, dec := range grouped {
+		var row struct {
+			ID       uuid.UUID
+			RefCount int
+		}
+		if err := tx.Raw(
+			`UPDATE asset_references SET ref_count = GREATEST(ref_count - ?, 0), updated_at = ?
+			WHERE project_id = ? AND sha256 = ? RETURNING id, ref_count`,
+			dec, now, projectID, sha,
+		).Scan(&row).Error; err != nil {
+			return released, orphaned, fmt.Errorf("release asset reference %s: %w", sha, err)
+		}
+		if row.ID == uuid.Nil {
+			continue
+		}
+		released++
+		if row.RefCount <= 0 {
+			orphaned = append(orphaned, row.ID)
+		}
+	}
+	return released, orphaned, nil
+}
@@ -3,11 +3,13 @@ package repo
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/memodb-io/Acontext/internal/modules/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -180,3 +182,174 @@ func TestAssetReferenceRepo_IncrementVsBatchIncrement(t *testing.T) {
 		db.Where("project_id = ? AND sha256 = ?", projectID, shaBatch).Delete(&model.AssetReference{})
 	})
 }
+
+func TestAssetReferenceRepo_FindAssetByHash(t *testing.T) {
+	db := setupAssetRefTestDB(t)
+	if db == nil {
+		return
+	}
+
+	repo := NewAssetReferenceRepo(db, nil)
+	ctx := context.Background()
+
+	projectID := uuid.New()
+	project := &model.Project{
+		ID:               projectID,
+		SecretKeyHMAC:    "test_hmac_asset_find_" + projectID.String()[:8],
+		SecretKeyHashPHC: "test_hash_asset_find",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupAssetRefTestDB(t, db, projectID)
+
+	sha := "find" + uuid.New().String()[:60]
+	require.NoError(t, repo.IncrementAssetRef(ctx, projectID, model.Asset{SHA256: sha, S3Key: "assets/first.png", MIME: "image/png", Content: "text"}))
+	// A later upload of the same content keeps the first key as canonical.
+	require.NoError(t, repo.IncrementAssetRef(ctx, projectID, model.Asset{SHA256: sha, S3Key: "assets/second.png", MIME: "image/png"}))
+
+	stale := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, db.Model(&model.AssetReference{}).Where("project_id = ? AND sha256 = ?", projectID, sha).
+		UpdateColumn("last_referenced_at", stale).Error)
+
+	found, err := repo.FindAssetByHash(ctx, projectID, sha)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "assets/first.png", found.S3Key)
+	assert.Empty(t, found.Content)
+
+	// Linking by hash counts as a reference for the grace period of unreferenced assets.
+	var ref model.AssetReference
+	require.NoError(t, db.Where("project_id = ? AND sha256 = ?", projectID, sha).First(&ref).Error)
+	assert.True(t, ref.LastReferencedAt.After(stale.Add(time.Hour)))
+
+	missing, err := repo.FindAssetByHash(ctx, projectID, "nope")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	other, err := repo.FindAssetByHash(ctx, uuid.New(), sha)
+	require.NoError(t, err)
+	assert.Nil(t, other)
+}
+
+func TestAssetReferenceRepo_GetByIDs(t *testing.T) {
+	db := setupAssetRefTestDB(t)
+	if db == nil {
+		return
+	}
+
+	repo := NewAssetReferenceRepo(db, nil)
+	ctx := context.Background()
+
+	projectID := uuid.New()
+	project := &model.Project{
+		ID:               projectID,
+		SecretKeyHMAC:    "test_hmac_asset_ids_" + projectID.String()[:8],
+		SecretKeyHashPHC: "test_hash_asset_ids",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupAssetRefTestDB(t, db, projectID)
+
+	ref, created, err := repo.RegisterAsset(ctx, projectID, model.Asset{SHA256: "ids" + uuid.New().String()[:60], S3Key: "assets/ids.png", MIME: "image/png"})
+	require.NoError(t, err)
+	require.True(t, created)
+
+	refs, err := repo.GetByIDs(ctx, projectID, []uuid.UUID{ref.ID, uuid.New()})
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	assert.Equal(t, ref.ID, refs[0].ID)
+	assert.Equal(t, "assets/ids.png", refs[0].S3Key)
+
+	other, err := repo.GetByIDs(ctx, uuid.New(), []uuid.UUID{ref.ID})
+	require.NoError(t, err)
+	assert.Empty(t, other)
+}
+
+func TestAssetReferenceRepo_DeleteUnreferencedAssets_Grace(t *testing.T) {
+	db := setupAssetRefTestDB(t)
+	if db == nil {
+		return
+	}
+
+	// S3 is nil — nothing within the grace period reaches storage
+	repo := NewAssetReferenceRepo(db, nil)
+	ctx := context.Background()
+
+	projectID := uuid.New()
+	project := &model.Project{
+		ID:               projectID,
+		SecretKeyHMAC:    "test_hmac_asset_grace_" + projectID.String()[:8],
+		SecretKeyHashPHC: "test_hash_asset_grace",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupAssetRefTestDB(t, db, projectID)
+
+	old := time.Now().Add(-48 * time.Hour)
+	sha := "grace" + uuid.New().String()[:59]
+	ref := &model.AssetReference{
+		ProjectID:        projectID,
+		SHA256:           sha,
+		S3Key:            "assets/" + sha,
+		AssetMeta:        datatypes.NewJSONType(model.Asset{SHA256: sha}),
+		CreatedAt:        old,
+		LastReferencedAt: time.Now(),
+	}
+	require.NoError(t, db.Create(ref).Error)
+
+	deleted, err := repo.DeleteUnreferencedAssets(ctx, projectID, []uuid.UUID{ref.ID}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, deleted, "a recently referenced asset is left to orphan collection")
+
+	var count int64
+	require.NoError(t, db.Model(&model.AssetReference{}).Where("id = ?", ref.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAssetReferenceRepo_CollectOrphanedAssets_DryRun(t *testing.T) {
+	db := setupAssetRefTestDB(t)
+	if db == nil {
+		return
+	}
+
+	// S3 is nil — a dry run never touches storage
+	repo := NewAssetReferenceRepo(db, nil)
+	ctx := context.Background()
+
+	projectID := uuid.New()
+	project := &model.Project{
+		ID:               projectID,
+		SecretKeyHMAC:    "test_hmac_asset_gc_" + projectID.String()[:8],
+		SecretKeyHashPHC: "test_hash_asset_gc",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupAssetRefTestDB(t, db, projectID)
+
+	old := time.Now().Add(-48 * time.Hour)
+	insert := func(sha string, refCount int, lastReferenced time.Time) {
+		require.NoError(t, db.Create(&model.AssetReference{
+			ProjectID:        projectID,
+			SHA256:           sha,
+			S3Key:            "assets/" + sha,
+			RefCount:         refCount,
+			AssetMeta:        datatypes.NewJSONType(model.Asset{SHA256: sha}),
+			CreatedAt:        lastReferenced,
+			LastReferencedAt: lastReferenced,
+		}).Error)
+	}
+	prefix := "gc" + uuid.New().String()[:8]
+	insert(prefix+"orphan", 0, old)
+	insert(prefix+"linked", 2, old)
+	insert(prefix+"fresh", 0, time.Now())
+
+	refs, err := repo.CollectOrphanedAssets(ctx, time.Now().Add(-24*time.Hour), 1000, true)
+	require.NoError(t, err)
+	var shas []string
+	for _, ref := range refs {
+		if ref.ProjectID == projectID {
+			shas = append(shas, ref.SHA256)
+		}
+	}
+	assert.Equal(t, []string{prefix + "orphan"}, shas)
+
+	var count int64
+	require.NoError(t, db.Model(&model.AssetReference{}).Where("project_id = ?", projectID).Count(&count).Error)
+	assert.Equal(t, int64(3), count, "dry run must not delete rows")
+}
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+)
+
+type AssetUploadRepo interface {
+	Create(ctx context.Context, u *model.AssetUpload) error
+	Get(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.AssetUpload, error)
+	Confirm(ctx context.Context, u *model.AssetUpload) (bool, error)
+	ListExpiredPending(ctx context.Context, before time.Time, limit int) ([]model.AssetUpload, error)
+	DeletePending(ctx context.Context, ids []uuid.UUID) (int64, error)
+}
+
+type assetUploadRepo struct {
+	db *gorm.DB
+}
+
+func NewAssetUploadRepo(db *gorm.DB) AssetUploadRepo {
+	return &assetUploadRepo{db: db}
+}
+
+func (r *assetUploadRepo) Create(ctx context.Context, u *model.AssetUpload) error {
+	return r.db.WithContext(ctx).Create(u).Error
+}
+
+func (r *assetUploadRepo) Get(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.AssetUpload, error) {
+	var u model.AssetUpload
+	if err := r.db.WithContext(ctx).Where("id = ? AND project_id = ?", id, projectID).First(&u).Error; err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Confirm stores u's final metadata and marks it confirmed, but only while it is still pending.
+// It reports false when another request confirmed or collected the upload first.
+func (r *assetUploadRepo) Confirm(ctx context.Context, u *model.AssetUpload) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.AssetUpload{}).
+		Where("id = ? AND status = ?", u.ID, model.AssetUploadStatusPending).
+		Updates(map[string]interface{}{
+			"status":       model.AssetUploadStatusConfirmed,
+			"mime":         u.MIME,
+			"size_b":       u.SizeB,
+			"asset_meta":   u.AssetMeta,
+			"asset_id":     u.AssetID,
+			"confirmed_at": u.ConfirmedAt,
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	u.Status = model.AssetUploadStatusConfirmed
+	return true, nil
+}
+
+// ListExpiredPending returns up to limit pending uploads that expired before the given time, oldest first.
+func (r *assetUploadRepo) ListExpiredPending(ctx context.Context, before time.Time, limit int) ([]model.AssetUpload, error) {
+	var items []model.AssetUpload
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", model.AssetUploadStatusPending, before).
+		Order("expires_at ASC").
+		Limit(limit).
+		Find(&items).Error
+	return items, err
+}
+
+// DeletePending deletes the given uploads that are still pending; confirmed ones are kept.
+func (r *assetUploadRepo) DeletePending(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	res := r.db.WithContext(ctx).
+		Where("id IN ? AND status = ?", ids, model.AssetUploadStatusPending).
+		Delete(&model.AssetUpload{})
+	return res.RowsAffected, res.Error
+}
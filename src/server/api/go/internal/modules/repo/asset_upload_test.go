@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestAssetUploadRepo_Lifecycle(t *testing.T) {
+	db := setupAssetRefTestDB(t)
+	if db == nil {
+		return
+	}
+	require.NoError(t, db.AutoMigrate(&model.AssetUpload{}))
+
+	repo := NewAssetUploadRepo(db)
+	ctx := context.Background()
+
+	projectID := uuid.New()
+	project := &model.Project{
+		ID:               projectID,
+		SecretKeyHMAC:    "test_hmac_asset_upload_" + projectID.String()[:8],
+		SecretKeyHashPHC: "test_hash_asset_upload",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer func() {
+		db.Exec("DELETE FROM asset_uploads WHERE project_id = ?", projectID)
+		cleanupAssetRefTestDB(t, db, projectID)
+	}()
+
+	newUpload := func(expiresAt time.Time) *model.AssetUpload {
+		u := &model.AssetUpload{
+			ID:        uuid.New(),
+			ProjectID: projectID,
+			S3Key:     "assets/" + projectID.String() + "/uploads/" + uuid.New().String(),
+			MIME:      "image/png",
+			SizeB:     10,
+			Status:    model.AssetUploadStatusPending,
+			AssetMeta: datatypes.NewJSONType(model.Asset{}),
+			ExpiresAt: expiresAt,
+		}
+		require.NoError(t, repo.Create(ctx, u))
+		return u
+	}
+
+	live := newUpload(time.Now().Add(time.Hour))
+	expired := newUpload(time.Now().Add(-time.Hour))
+
+	t.Run("get is project scoped", func(t *testing.T) {
+		got, err := repo.Get(ctx, projectID, live.ID)
+		require.NoError(t, err)
+		assert.Equal(t, live.S3Key, got.S3Key)
+
+		_, err = repo.Get(ctx, uuid.New(), live.ID)
+		assert.Error(t, err)
+	})
+
+	t.Run("confirm only once", func(t *testing.T) {
+		now := time.Now()
+		assetID := uuid.New()
+		live.AssetID = &assetID
+		live.ConfirmedAt = &now
+		live.AssetMeta = datatypes.NewJSONType(model.Asset{SHA256: "abc", S3Key: live.S3Key})
+
+		ok, err := repo.Confirm(ctx, live)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = repo.Confirm(ctx, live)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		got, err := repo.Get(ctx, projectID, live.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.AssetUploadStatusConfirmed, got.Status)
+		assert.Equal(t, "abc", got.AssetMeta.Data().SHA256)
+	})
+
+	t.Run("expired pending uploads are listed and deleted", func(t *testing.T) {
+		items, err := repo.ListExpiredPending(ctx, time.Now(), 100)
+		require.NoError(t, err)
+		var ids []uuid.UUID
+		for _, u := range items {
+			if u.ProjectID == projectID {
+				ids = append(ids, u.ID)
+			}
+		}
+		assert.Equal(t, []uuid.UUID{expired.ID}, ids)
+
+		n, err := repo.DeletePending(ctx, []uuid.UUID{expired.ID, live.ID})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n, "confirmed uploads are kept")
+	})
+}
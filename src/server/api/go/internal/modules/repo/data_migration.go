@@ -0,0 +1,37 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RunDataMigration runs the one-time data migration name unless it is already recorded in
+// data_migrations, and records it once migrate succeeds. A migration that fails is run again at
+// the next boot, so migrate must be safe to repeat; the same holds when two instances boot before
+// either has recorded it. It returns the rows migrate reports and whether it ran.
+func RunDataMigration(ctx context.Context, db *gorm.DB, name string, migrate func() (int64, error)) (int64, bool, error) {
+	var applied model.DataMigration
+	err := db.WithContext(ctx).Where("name = ?", name).First(&applied).Error
+	if err == nil {
+		return 0, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, fmt.Errorf("check data migration %s: %w", name, err)
+	}
+
+	n, err := migrate()
+	if err != nil {
+		return n, true, err
+	}
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.DataMigration{Name: name, AppliedAt: time.Now()}).Error; err != nil {
+		return n, true, fmt.Errorf("record data migration %s: %w", name, err)
+	}
+	return n, true, nil
+}
@@ -0,0 +1,47 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDataMigration(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+	require.NoError(t, db.AutoMigrate(&model.DataMigration{}))
+	ctx := context.Background()
+	name := "test_migration_" + uuid.NewString()
+	defer db.Where("name = ?", name).Delete(&model.DataMigration{})
+
+	runs := 0
+	migrate := func() (int64, error) {
+		runs++
+		if runs == 1 {
+			return 0, errors.New("interrupted")
+		}
+		return 7, nil
+	}
+
+	_, ran, err := RunDataMigration(ctx, db, name, migrate)
+	assert.ErrorContains(t, err, "interrupted")
+	assert.True(t, ran)
+
+	n, ran, err := RunDataMigration(ctx, db, name, migrate)
+	require.NoError(t, err)
+	assert.True(t, ran, "a failed migration runs again")
+	assert.Equal(t, int64(7), n)
+
+	n, ran, err = RunDataMigration(ctx, db, name, migrate)
+	require.NoError(t, err)
+	assert.False(t, ran)
+	assert.Zero(t, n)
+	assert.Equal(t, 2, runs)
+}
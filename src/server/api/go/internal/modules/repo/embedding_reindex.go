@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+)
+
+// ErrReindexRunning is returned by CreateReindex when the project already has a reindex running.
+var ErrReindexRunning = errors.New("an embedding reindex is already running for the project")
+
+// ReindexCandidate is a message in the scope of a reindex.
+type ReindexCandidate struct {
+	ID         uuid.UUID
+	SessionID  uuid.UUID
+	SearchText string
+	// Embedded reports whether the message already has an embedding of the reindex's model.
+	Embedded bool
+}
+
+// reindexScope selects the live messages of the reindex's project, or of its session.
+func reindexScope(db *gorm.DB, ri *model.EmbeddingReindex) *gorm.DB {
+	q := db.Table("messages m").
+		Joins("JOIN sessions s ON s.id = m.session_id").
+		Where("s.project_id = ? AND m.deleted_at IS NULL AND s.deleted_at IS NULL", ri.ProjectID)
+	if ri.SessionID != nil {
+		q = q.Where("m.session_id = ?", *ri.SessionID)
+	}
+	return q
+}
+
+// CreateReindex stores a new running reindex with Total set to the messages in its scope. A
+// project runs one reindex at a time; while one is running it returns ErrReindexRunning.
+func (r *messageEmbeddingRepo) CreateReindex(ctx context.Context, ri *model.EmbeddingReindex) error {
+	if err := r.ensureSupported(ctx); err != nil {
+		return err
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var running int64
+		if err := tx.Model(&model.EmbeddingReindex{}).
+			Where("project_id = ? AND status = ?", ri.ProjectID, model.EmbeddingReindexRunning).
+			Count(&running).Error; err != nil {
+			return fmt.Errorf("check running reindex: %w", err)
+		}
+		if running > 0 {
+			return ErrReindexRunning
+		}
+		if err := reindexScope(tx, ri).Count(&ri.Total).Error; err != nil {
+			return fmt.Errorf("count reindex messages: %w", err)
+		}
+		ri.Status = model.EmbeddingReindexRunning
+		return tx.Create(ri).Error
+	})
+	// The partial unique index catches a reindex started concurrently.
+	if err != nil && (strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "23505")) {
+		return ErrReindexRunning
+	}
+	return err
+}
+
+func (r *messageEmbeddingRepo) GetReindex(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.EmbeddingReindex, error) {
+	var ri model.EmbeddingReindex
+	if err := r.db.WithContext(ctx).Where("id = ? AND project_id = ?", id, projectID).First(&ri).Error; err != nil {
+		return nil, err
+	}
+	return &ri, nil
+}
+
+// NextReindexBatch returns up to limit messages of the reindex's scope after its checkpoint, in
+// ID order.
+func (r *messageEmbeddingRepo) NextReindexBatch(ctx context.Context, ri *model.EmbeddingReindex, limit int) ([]ReindexCandidate, error) {
+	var batch []ReindexCandidate
+	err := reindexScope(r.db.WithContext(ctx), ri).
+		Select("m.id, m.session_id, m.search_text, e.message_id IS NOT NULL AS embedded").
+		Joins("LEFT JOIN message_embeddings e ON e.message_id = m.id AND e.model = ?", ri.Model).
+		Where("m.id > ?", ri.AfterMessageID).
+		Order("m.id ASC").Limit(limit).
+		Scan(&batch).Error
+	if err != nil {
+		return nil, fmt.Errorf("list reindex messages: %w", err)
+	}
+	return batch, nil
+}
+
+// SaveReindexProgress stores the checkpoint, counters, status and error of the reindex.
+func (r *messageEmbeddingRepo) SaveReindexProgress(ctx context.Context, ri *model.EmbeddingReindex) error {
+	return r.db.WithContext(ctx).Model(&model.EmbeddingReindex{}).Where("id = ?", ri.ID).
+		Updates(map[string]interface{}{
+			"after_message_id": ri.AfterMessageID,
+			"embedded":         ri.Embedded,
+			"skipped":          ri.Skipped,
+			"status":           ri.Status,
+			"error":            ri.Error,
+			"finished_at":      ri.FinishedAt,
+		}).Error
+}
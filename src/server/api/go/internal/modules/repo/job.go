@@ -0,0 +1,143 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type JobRepo interface {
+	Enqueue(ctx context.Context, job *model.Job) error
+	Claim(ctx context.Context, kinds []string, now time.Time, staleBefore time.Time, limit int) ([]model.Job, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Reschedule(ctx context.Context, id uuid.UUID, runAt time.Time, errMsg string) error
+	Bury(ctx context.Context, id uuid.UUID, errMsg string) error
+	ListDead(ctx context.Context, kind string, limit int) ([]model.Job, error)
+	RequeueDead(ctx context.Context, id uuid.UUID) error
+}
+
+type jobRepo struct {
+	db *gorm.DB
+}
+
+func NewJobRepo(db *gorm.DB) JobRepo {
+	return &jobRepo{db: db}
+}
+
+// Enqueue inserts job; a zero RunAt makes it due immediately.
+func (r *jobRepo) Enqueue(ctx context.Context, job *model.Job) error {
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	if job.Status == "" {
+		job.Status = model.JobStatusPending
+	}
+	return r.db.WithContext(ctx).Create(job).Error
+}
+
+// Claim marks up to limit due jobs of the given kinds as running and returns them, the longest
+// due first. Jobs left running since before staleBefore are claimed again, so a job whose worker
+// died is not lost. Rows are locked with SKIP LOCKED, so concurrent workers never claim the same
+// job. Only kinds the caller can run are claimed; other kinds are left for workers that can.
+func (r *jobRepo) Claim(ctx context.Context, kinds []string, now time.Time, staleBefore time.Time, limit int) ([]model.Job, error) {
+	if len(kinds) == 0 || limit <= 0 {
+		return nil, nil
+	}
+	var jobs []model.Job
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("kind IN ?", kinds).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND run_at < ?)",
+				model.JobStatusPending, now, model.JobStatusRunning, staleBefore).
+			Order("run_at ASC").
+			Limit(limit).
+			Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(jobs))
+		for i := range jobs {
+			ids[i] = jobs[i].ID
+			jobs[i].Status = model.JobStatusRunning
+			jobs[i].Attempts++
+			jobs[i].RunAt = now
+		}
+		return tx.Model(&model.Job{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":     model.JobStatusRunning,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"run_at":     now,
+			"updated_at": time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Delete removes a job that finished.
+func (r *jobRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Where("id = ?", id).Delete(&model.Job{}).Error
+}
+
+// Reschedule puts a failed running job back in the queue, due at runAt.
+func (r *jobRepo) Reschedule(ctx context.Context, id uuid.UUID, runAt time.Time, errMsg string) error {
+	return r.db.WithContext(ctx).Model(&model.Job{}).
+		Where("id = ? AND status = ?", id, model.JobStatusRunning).
+		Updates(map[string]interface{}{
+			"status":     model.JobStatusPending,
+			"run_at":     runAt,
+			"last_error": errMsg,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// Bury moves a running job that ran out of attempts to the dead letters.
+func (r *jobRepo) Bury(ctx context.Context, id uuid.UUID, errMsg string) error {
+	return r.db.WithContext(ctx).Model(&model.Job{}).
+		Where("id = ? AND status = ?", id, model.JobStatusRunning).
+		Updates(map[string]interface{}{
+			"status":     model.JobStatusDead,
+			"last_error": errMsg,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// ListDead returns up to limit dead jobs, of kind when it is set, most recently failed first.
+func (r *jobRepo) ListDead(ctx context.Context, kind string, limit int) ([]model.Job, error) {
+	q := r.db.WithContext(ctx).Where("status = ?", model.JobStatusDead)
+	if kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	var jobs []model.Job
+	err := q.Order("updated_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+// RequeueDead gives a dead job a fresh set of attempts, due immediately. Returns
+// gorm.ErrRecordNotFound if no dead job has the id.
+func (r *jobRepo) RequeueDead(ctx context.Context, id uuid.UUID) error {
+	now := time.Now()
+	res := r.db.WithContext(ctx).Model(&model.Job{}).
+		Where("id = ? AND status = ?", id, model.JobStatusDead).
+		Updates(map[string]interface{}{
+			"status":     model.JobStatusPending,
+			"attempts":   0,
+			"run_at":     now,
+			"updated_at": now,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func TestJobRepo_Lifecycle(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+	require.NoError(t, db.AutoMigrate(&model.Job{}))
+
+	ctx := context.Background()
+	kind := "test-job-" + t.Name()
+	defer db.Exec("DELETE FROM jobs WHERE kind = ?", kind)
+
+	r := NewJobRepo(db)
+	now := time.Now()
+	due := &model.Job{Kind: kind, Payload: datatypes.JSON(`{"n":1}`), MaxAttempts: 2}
+	later := &model.Job{Kind: kind, Payload: datatypes.JSON(`{"n":2}`), MaxAttempts: 2, RunAt: now.Add(time.Hour)}
+	require.NoError(t, r.Enqueue(ctx, due))
+	require.NoError(t, r.Enqueue(ctx, later))
+	assert.Equal(t, model.JobStatusPending, due.Status)
+
+	claim := func(at time.Time) []model.Job {
+		jobs, err := r.Claim(ctx, []string{kind}, at, at.Add(-10*time.Minute), 10)
+		require.NoError(t, err)
+		return jobs
+	}
+
+	claimed := claim(now)
+	require.Len(t, claimed, 1, "only due jobs are claimed")
+	assert.Equal(t, due.ID, claimed[0].ID)
+	assert.Equal(t, 1, claimed[0].Attempts)
+	assert.Empty(t, claim(now), "a running job is not claimed twice")
+
+	none, err := r.Claim(ctx, []string{"other-kind"}, now, now, 10)
+	require.NoError(t, err)
+	assert.Empty(t, none, "kinds the caller cannot run are left alone")
+
+	require.NoError(t, r.Reschedule(ctx, due.ID, now.Add(time.Minute), "boom"))
+	assert.Empty(t, claim(now))
+	claimed = claim(now.Add(2 * time.Minute))
+	require.Len(t, claimed, 1)
+	assert.Equal(t, 2, claimed[0].Attempts)
+	assert.Equal(t, "boom", claimed[0].LastError)
+
+	require.NoError(t, r.Bury(ctx, due.ID, "boom again"))
+	dead, err := r.ListDead(ctx, kind, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "boom again", dead[0].LastError)
+
+	require.NoError(t, r.RequeueDead(ctx, due.ID))
+	assert.ErrorIs(t, r.RequeueDead(ctx, due.ID), gorm.ErrRecordNotFound, "only dead jobs are requeued")
+	claimed = claim(time.Now())
+	require.Len(t, claimed, 1)
+	assert.Equal(t, 1, claimed[0].Attempts, "a requeued job gets fresh attempts")
+
+	require.NoError(t, r.Delete(ctx, due.ID))
+	var n int64
+	require.NoError(t, db.Model(&model.Job{}).Where("kind = ?", kind).Count(&n).Error)
+	assert.Equal(t, int64(1), n)
+}
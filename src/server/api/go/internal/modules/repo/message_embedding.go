@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrVectorUnsupported is returned when the pgvector extension or the embeddings table is missing.
+	ErrVectorUnsupported = errors.New("vector search is not supported: pgvector extension is not installed")
+
+	// ErrEmbeddingDimMismatch is returned when a vector's dimension differs from the dimension the
+	// project stores for its model.
+	ErrEmbeddingDimMismatch = errors.New("embedding dimension mismatch")
+)
+
+type MessageEmbeddingRepo interface {
+	Upsert(ctx context.Context, e *model.MessageEmbedding) error
+	SearchSimilar(ctx context.Context, projectID uuid.UUID, sessionID *uuid.UUID, embeddingModel string, vector model.Vector, topK int) ([]SimilarMessage, error)
+	CreateReindex(ctx context.Context, ri *model.EmbeddingReindex) error
+	GetReindex(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.EmbeddingReindex, error)
+	NextReindexBatch(ctx context.Context, ri *model.EmbeddingReindex, limit int) ([]ReindexCandidate, error)
+	SaveReindexProgress(ctx context.Context, ri *model.EmbeddingReindex) error
+}
+
+// SimilarMessage is a message matched by vector search with its cosine distance to the query
+type SimilarMessage struct {
+	model.Message
+	Distance float64
+}
+
+type messageEmbeddingRepo struct {
+	db *gorm.DB
+}
+
+func NewMessageEmbeddingRepo(db *gorm.DB) MessageEmbeddingRepo {
+	return &messageEmbeddingRepo{db: db}
+}
+
+// ensureSupported reports ErrVectorUnsupported unless pgvector and the embeddings table exist.
+func (r *messageEmbeddingRepo) ensureSupported(ctx context.Context) error {
+	var ok bool
+	if err := r.db.WithContext(ctx).Raw(
+		"SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'vector') AND to_regclass('message_embeddings') IS NOT NULL",
+	).Scan(&ok).Error; err != nil {
+		return err
+	}
+	if !ok {
+		return ErrVectorUnsupported
+	}
+	return nil
+}
+
+// storedDim returns the dimension of the project's embeddings of the model, or 0 if none is stored.
+func storedDim(db *gorm.DB, projectID uuid.UUID, embeddingModel string) (int, error) {
+	var dims []int
+	err := db.Model(&model.MessageEmbedding{}).
+		Where("project_id = ? AND model = ?", projectID, embeddingModel).
+		Limit(1).
+		Pluck("dim", &dims).Error
+	if err != nil || len(dims) == 0 {
+		return 0, err
+	}
+	return dims[0], nil
+}
+
+// Upsert stores or replaces the embedding of a message. All embeddings of one model in a
+// project must share one dimension so they stay comparable; writes of a project's model are
+// serialized by an advisory lock so concurrent first embeddings cannot set two.
+func (r *messageEmbeddingRepo) Upsert(ctx context.Context, e *model.MessageEmbedding) error {
+	if err := r.ensureSupported(ctx); err != nil {
+		return err
+	}
+	e.Dim = len(e.Embedding)
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Writers of the project's model take turns, so the first embedding stored sets the
+		// dimension and a concurrent writer with another one sees it.
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtextextended(?, 0))",
+			"message_embeddings:"+e.ProjectID.String()+":"+e.Model).Error; err != nil {
+			return err
+		}
+		dim, err := storedDim(tx, e.ProjectID, e.Model)
+		if err != nil {
+			return err
+		}
+		if dim != 0 && dim != e.Dim {
+			return fmt.Errorf("%w: got %d, project uses %d", ErrEmbeddingDimMismatch, e.Dim, dim)
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"dim", "embedding", "model", "updated_at"}),
+		}).Create(e).Error
+	})
+}
+
+// SearchSimilar returns the topK live messages closest to vector by cosine distance among the
+// embeddings of embeddingModel, optionally scoped to one session.
+func (r *messageEmbeddingRepo) SearchSimilar(ctx context.Context, projectID uuid.UUID, sessionID *uuid.UUID, embeddingModel string, vector model.Vector, topK int) ([]SimilarMessage, error) {
+	if err := r.ensureSupported(ctx); err != nil {
+		return nil, err
+	}
+	dim, err := storedDim(r.db.WithContext(ctx), projectID, embeddingModel)
+	if err != nil {
+		return nil, err
+	}
+	if dim == 0 {
+		return []SimilarMessage{}, nil
+	}
+	if dim != len(vector) {
+		return nil, fmt.Errorf("%w: got %d, project uses %d", ErrEmbeddingDimMismatch, len(vector), dim)
+	}
+
+	q := r.db.WithContext(ctx).
+		Table("message_embeddings e").
+		Select("m.*, e.embedding <=> ?::vector AS distance", vector).
+		Joins("JOIN messages m ON m.id = e.message_id").
+		Joins("JOIN sessions s ON s.id = e.session_id").
+		Where("e.project_id = ? AND e.model = ? AND m.deleted_at IS NULL AND s.deleted_at IS NULL", projectID, embeddingModel)
+	if sessionID != nil {
+		q = q.Where("e.session_id = ?", *sessionID)
+	}
+
+	var hits []SimilarMessage
+	err = q.Order("distance ASC").Limit(topK).Scan(&hits).Error
+	return hits, err
+}
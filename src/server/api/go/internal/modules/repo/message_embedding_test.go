@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestMessageEmbeddingRepo_SearchSimilar(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		t.Skip("pgvector not available, skipping vector search tests")
+	}
+	require.NoError(t, db.AutoMigrate(&model.Message{}, &model.MessageEmbedding{}, &model.EmbeddingReindex{}))
+
+	ctx := context.Background()
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_embedding",
+		SecretKeyHashPHC: "test_hash_embedding",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	newMsg := func() *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      ss.ID,
+			Role:           "user",
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "embedding-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	near, far, deleted := newMsg(), newMsg(), newMsg()
+
+	r := NewMessageEmbeddingRepo(db)
+	upsert := func(m *model.Message, v model.Vector) error {
+		return r.Upsert(ctx, &model.MessageEmbedding{MessageID: m.ID, SessionID: ss.ID, ProjectID: project.ID, Embedding: v})
+	}
+	require.NoError(t, upsert(near, model.Vector{1, 0.1}))
+	require.NoError(t, upsert(far, model.Vector{0, 1}))
+	require.NoError(t, upsert(deleted, model.Vector{1, 0}))
+	require.NoError(t, db.Delete(deleted).Error)
+
+	t.Run("orders by cosine distance and skips deleted messages", func(t *testing.T) {
+		hits, err := r.SearchSimilar(ctx, project.ID, &ss.ID, "", model.Vector{1, 0}, 10)
+		require.NoError(t, err)
+		require.Len(t, hits, 2)
+		assert.Equal(t, near.ID, hits[0].ID)
+		assert.Equal(t, far.ID, hits[1].ID)
+		assert.Less(t, hits[0].Distance, hits[1].Distance)
+	})
+
+	t.Run("upsert replaces existing vector", func(t *testing.T) {
+		require.NoError(t, upsert(far, model.Vector{1, 0}))
+		hits, err := r.SearchSimilar(ctx, project.ID, nil, "", model.Vector{1, 0}, 1)
+		require.NoError(t, err)
+		require.Len(t, hits, 1)
+		assert.Equal(t, far.ID, hits[0].ID)
+	})
+
+	t.Run("dimension mismatch", func(t *testing.T) {
+		assert.ErrorIs(t, upsert(near, model.Vector{1, 0, 0}), ErrEmbeddingDimMismatch)
+		_, err := r.SearchSimilar(ctx, project.ID, nil, "", model.Vector{1, 0, 0}, 10)
+		assert.ErrorIs(t, err, ErrEmbeddingDimMismatch)
+	})
+
+	t.Run("models keep their own dimension", func(t *testing.T) {
+		other := newMsg()
+		require.NoError(t, r.Upsert(ctx, &model.MessageEmbedding{MessageID: other.ID, SessionID: ss.ID, ProjectID: project.ID, Model: "big", Embedding: model.Vector{1, 0, 0}}))
+		hits, err := r.SearchSimilar(ctx, project.ID, nil, "big", model.Vector{1, 0, 0}, 10)
+		require.NoError(t, err)
+		require.Len(t, hits, 1)
+		assert.Equal(t, other.ID, hits[0].ID)
+	})
+
+	t.Run("reindex batches", func(t *testing.T) {
+		ri := &model.EmbeddingReindex{ProjectID: project.ID, SessionID: &ss.ID, Model: "big"}
+		require.NoError(t, r.CreateReindex(ctx, ri))
+		assert.Equal(t, int64(3), ri.Total, "live messages only")
+		assert.ErrorIs(t, r.CreateReindex(ctx, &model.EmbeddingReindex{ProjectID: project.ID, Model: "big"}), ErrReindexRunning)
+
+		batch, err := r.NextReindexBatch(ctx, ri, 10)
+		require.NoError(t, err)
+		require.Len(t, batch, 3)
+		embedded := 0
+		for _, c := range batch {
+			if c.Embedded {
+				embedded++
+			}
+		}
+		assert.Equal(t, 1, embedded)
+
+		ri.AfterMessageID = batch[1].ID
+		ri.Status = model.EmbeddingReindexDone
+		require.NoError(t, r.SaveReindexProgress(ctx, ri))
+		rest, err := r.NextReindexBatch(ctx, ri, 10)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		assert.Equal(t, batch[2].ID, rest[0].ID)
+
+		got, err := r.GetReindex(ctx, project.ID, ri.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.EmbeddingReindexDone, got.Status)
+		require.NoError(t, r.CreateReindex(ctx, &model.EmbeddingReindex{ProjectID: project.ID, Model: "big"}))
+	})
+
+	t.Run("concurrent first writers agree on one dimension", func(t *testing.T) {
+		vectors := []model.Vector{{1, 0}, {1, 0, 0}}
+		msgs := []*model.Message{newMsg(), newMsg()}
+		errs := make([]error, len(vectors))
+		var wg sync.WaitGroup
+		for i := range vectors {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = r.Upsert(ctx, &model.MessageEmbedding{MessageID: msgs[i].ID, SessionID: ss.ID, ProjectID: project.ID, Model: "race", Embedding: vectors[i]})
+			}(i)
+		}
+		wg.Wait()
+		if errs[0] == nil {
+			assert.ErrorIs(t, errs[1], ErrEmbeddingDimMismatch)
+		} else {
+			assert.ErrorIs(t, errs[0], ErrEmbeddingDimMismatch)
+			assert.NoError(t, errs[1])
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PartEnrichmentRepo interface {
+	ReplaceForMessage(ctx context.Context, messageID uuid.UUID, jobs []model.PartEnrichment) error
+	ListByMessage(ctx context.Context, messageID uuid.UUID) ([]model.PartEnrichment, error)
+	ClaimPending(ctx context.Context, staleBefore time.Time, limit int) ([]model.PartEnrichment, error)
+	Complete(ctx context.Context, id uuid.UUID, status string, errMsg string) error
+	RetryFailed(ctx context.Context, messageID uuid.UUID, partIndex *int) (int64, error)
+	CountPending(ctx context.Context) (int64, error)
+}
+
+type partEnrichmentRepo struct {
+	db *gorm.DB
+}
+
+func NewPartEnrichmentRepo(db *gorm.DB) PartEnrichmentRepo {
+	return &partEnrichmentRepo{db: db}
+}
+
+// ReplaceForMessage drops the message's existing jobs, finished or not, and queues jobs instead.
+func (r *partEnrichmentRepo) ReplaceForMessage(ctx context.Context, messageID uuid.UUID, jobs []model.PartEnrichment) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", messageID).Delete(&model.PartEnrichment{}).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+		return tx.Create(&jobs).Error
+	})
+}
+
+// ListByMessage returns the message's jobs ordered by part and enricher.
+func (r *partEnrichmentRepo) ListByMessage(ctx context.Context, messageID uuid.UUID) ([]model.PartEnrichment, error) {
+	var jobs []model.PartEnrichment
+	err := r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("part_index ASC, enricher ASC").
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// ClaimPending marks up to limit jobs as running and returns them, oldest first. Jobs left running
+// since before staleBefore are claimed again, so a job whose worker died is not lost. Rows are
+// locked with SKIP LOCKED, so concurrent workers never claim the same job.
+func (r *partEnrichmentRepo) ClaimPending(ctx context.Context, staleBefore time.Time, limit int) ([]model.PartEnrichment, error) {
+	var jobs []model.PartEnrichment
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR (status = ? AND updated_at < ?)",
+				model.EnrichmentStatusPending, model.EnrichmentStatusRunning, staleBefore).
+			Order("updated_at ASC").
+			Limit(limit).
+			Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(jobs))
+		for i := range jobs {
+			ids[i] = jobs[i].ID
+			jobs[i].Status = model.EnrichmentStatusRunning
+			jobs[i].Attempts++
+		}
+		return tx.Model(&model.PartEnrichment{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":     model.EnrichmentStatusRunning,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"updated_at": time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Complete records the outcome of a claimed job. It is a no-op when the job was replaced or
+// re-queued in the meantime.
+func (r *partEnrichmentRepo) Complete(ctx context.Context, id uuid.UUID, status string, errMsg string) error {
+	return r.db.WithContext(ctx).Model(&model.PartEnrichment{}).
+		Where("id = ? AND status = ?", id, model.EnrichmentStatusRunning).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"error":      errMsg,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// RetryFailed re-queues the message's failed jobs, or only those of partIndex when it is set.
+// It returns the number of jobs re-queued.
+func (r *partEnrichmentRepo) RetryFailed(ctx context.Context, messageID uuid.UUID, partIndex *int) (int64, error) {
+	q := r.db.WithContext(ctx).Model(&model.PartEnrichment{}).
+		Where("message_id = ? AND status = ?", messageID, model.EnrichmentStatusFailed)
+	if partIndex != nil {
+		q = q.Where("part_index = ?", *partIndex)
+	}
+	res := q.Updates(map[string]interface{}{
+		"status":     model.EnrichmentStatusPending,
+		"error":      "",
+		"updated_at": time.Now(),
+	})
+	return res.RowsAffected, res.Error
+}
+
+// CountPending returns how many jobs are waiting to be claimed, across all projects.
+func (r *partEnrichmentRepo) CountPending(ctx context.Context) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Model(&model.PartEnrichment{}).
+		Where("status = ?", model.EnrichmentStatusPending).
+		Count(&n).Error
+	return n, err
+}
@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestPartEnrichmentRepo_Lifecycle(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}, &model.PartEnrichment{}))
+
+	ctx := context.Background()
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_enrichment",
+		SecretKeyHashPHC: "test_hash_enrichment",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+	msg := &model.Message{
+		SessionID:      ss.ID,
+		Role:           "user",
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "enrichment-sha-" + uuid.NewString()}),
+	}
+	require.NoError(t, db.Create(msg).Error)
+
+	r := NewPartEnrichmentRepo(db)
+	job := func(idx int, name string) model.PartEnrichment {
+		return model.PartEnrichment{
+			ProjectID: project.ID,
+			SessionID: ss.ID,
+			MessageID: msg.ID,
+			PartIndex: idx,
+			Enricher:  name,
+			AssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "img"}),
+			Status:    model.EnrichmentStatusPending,
+		}
+	}
+	require.NoError(t, r.ReplaceForMessage(ctx, msg.ID, []model.PartEnrichment{job(0, "ocr"), job(0, "caption"), job(1, "ocr")}))
+
+	claimMine := func() []model.PartEnrichment {
+		claimed, err := r.ClaimPending(ctx, time.Now().Add(-time.Hour), 100)
+		require.NoError(t, err)
+		var mine []model.PartEnrichment
+		for _, j := range claimed {
+			if j.MessageID == msg.ID {
+				mine = append(mine, j)
+			}
+		}
+		return mine
+	}
+
+	claimed := claimMine()
+	require.Len(t, claimed, 3)
+	for _, j := range claimed {
+		assert.Equal(t, model.EnrichmentStatusRunning, j.Status)
+		assert.Equal(t, 1, j.Attempts)
+	}
+	assert.Empty(t, claimMine(), "running jobs are not claimed twice")
+
+	for _, j := range claimed {
+		status, errMsg := model.EnrichmentStatusDone, ""
+		if j.PartIndex == 1 {
+			status, errMsg = model.EnrichmentStatusFailed, "provider timeout"
+		}
+		require.NoError(t, r.Complete(ctx, j.ID, status, errMsg))
+	}
+
+	jobs, err := r.ListByMessage(ctx, msg.ID)
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	assert.Equal(t, "caption", jobs[0].Enricher, "ordered by part then enricher")
+	assert.Equal(t, model.EnrichmentStatusFailed, jobs[2].Status)
+	assert.Equal(t, "provider timeout", jobs[2].Error)
+
+	other := 0
+	n, err := r.RetryFailed(ctx, msg.ID, &other)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n, "part 0 has no failed jobs")
+	n, err = r.RetryFailed(ctx, msg.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	retried := claimMine()
+	require.Len(t, retried, 1)
+	assert.Equal(t, 2, retried[0].Attempts)
+
+	t.Run("stale running jobs are reclaimed", func(t *testing.T) {
+		require.NoError(t, db.Model(&model.PartEnrichment{}).Where("id = ?", retried[0].ID).
+			UpdateColumn("updated_at", time.Now().Add(-2*time.Hour)).Error)
+		again := claimMine()
+		require.Len(t, again, 1)
+		assert.Equal(t, 3, again[0].Attempts)
+	})
+
+	t.Run("replace drops earlier jobs", func(t *testing.T) {
+		require.NoError(t, r.ReplaceForMessage(ctx, msg.ID, nil))
+		jobs, err := r.ListByMessage(ctx, msg.ID)
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+	})
+}
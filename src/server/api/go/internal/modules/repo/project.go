@@ -7,6 +7,7 @@ import (
 	"github.com/memodb-io/Acontext/internal/modules/model"
 	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProjectRepo interface {
@@ -14,6 +15,7 @@ type ProjectRepo interface {
 	Delete(ctx context.Context, projectID uuid.UUID) error
 	GetByID(ctx context.Context, projectID uuid.UUID) (*model.Project, error)
 	Update(ctx context.Context, p *model.Project) error
+	SetProjectConfig(ctx context.Context, projectID uuid.UUID, key string, value interface{}) error
 	AnalyzeUsages(ctx context.Context, projectID uuid.UUID, intervalDays int, fields []string) (*AnalyzeUsagesResult, error)
 	AnalyzeStatistics(ctx context.Context, projectID uuid.UUID) (*AnalyzeStatisticsResult, error)
 }
@@ -126,6 +128,32 @@ func (r *projectRepo) Update(ctx context.Context, p *model.Project) error {
 	return r.db.WithContext(ctx).Model(&model.Project{}).Where("id = ?", p.ID).Updates(p).Error
 }
 
+// SetProjectConfig sets one key of the project's project_config, or deletes it when value is
+// nil, leaving the other keys as they are.
+func (r *projectRepo) SetProjectConfig(ctx context.Context, projectID uuid.UUID, key string, value interface{}) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var p model.Project
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "configs").
+			Where("id = ?", projectID).First(&p).Error; err != nil {
+			return err
+		}
+		if p.Configs == nil {
+			p.Configs = map[string]interface{}{}
+		}
+		pc, _ := p.Configs["project_config"].(map[string]interface{})
+		if pc == nil {
+			pc = map[string]interface{}{}
+		}
+		if value == nil {
+			delete(pc, key)
+		} else {
+			pc[key] = value
+		}
+		p.Configs["project_config"] = pc
+		return tx.Model(&model.Project{}).Where("id = ?", projectID).Update("configs", p.Configs).Error
+	})
+}
+
 func (r *projectRepo) AnalyzeUsages(ctx context.Context, projectID uuid.UUID, intervalDays int, fields []string) (*AnalyzeUsagesResult, error) {
 	result := &AnalyzeUsagesResult{}
 	g, ctx := errgroup.WithContext(ctx)
@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+)
+
+type ProjectKeyRepo interface {
+	Create(ctx context.Context, k *model.ProjectKey) error
+	// ListByProject returns the keys of the project, oldest first.
+	ListByProject(ctx context.Context, projectID uuid.UUID) ([]model.ProjectKey, error)
+	// Delete removes a key of the project and returns it, or gorm.ErrRecordNotFound when the
+	// project has no such key.
+	Delete(ctx context.Context, projectID uuid.UUID, keyID uuid.UUID) (*model.ProjectKey, error)
+}
+
+type projectKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewProjectKeyRepo(db *gorm.DB) ProjectKeyRepo {
+	return &projectKeyRepo{db: db}
+}
+
+func (r *projectKeyRepo) Create(ctx context.Context, k *model.ProjectKey) error {
+	return r.db.WithContext(ctx).Create(k).Error
+}
+
+func (r *projectKeyRepo) ListByProject(ctx context.Context, projectID uuid.UUID) ([]model.ProjectKey, error) {
+	var keys []model.ProjectKey
+	err := r.db.WithContext(ctx).
+		Where(&model.ProjectKey{ProjectID: projectID}).
+		Order("created_at ASC, id ASC").
+		Find(&keys).Error
+	return keys, err
+}
+
+func (r *projectKeyRepo) Delete(ctx context.Context, projectID uuid.UUID, keyID uuid.UUID) (*model.ProjectKey, error) {
+	var key model.ProjectKey
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&model.ProjectKey{ID: keyID, ProjectID: projectID}).First(&key).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&key).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
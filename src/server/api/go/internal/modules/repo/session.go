@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/memodb-io/Acontext/internal/infra/blob"
@@ -19,28 +22,161 @@ import (
 const (
 	// MaxCopyableMessages is the maximum number of messages allowed for synchronous copy
 	MaxCopyableMessages = 5000
+
+	// purgeBatchSize bounds how many sessions a single PurgeDeleted call hard-deletes
+	purgeBatchSize = 500
+
+	// IdempotencyKeyTTL is how long a message's idempotency key deduplicates retries.
+	IdempotencyKeyTTL = 24 * time.Hour
+)
+
+// TimeRange bounds the created_at of listed rows. Both bounds are inclusive and a zero bound
+// leaves its side open, so the zero TimeRange matches every row.
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// where adds the range predicates on column to q.
+func (tr TimeRange) where(q *gorm.DB, column string) *gorm.DB {
+	if !tr.Since.IsZero() {
+		q = q.Where(column+" >= ?", tr.Since)
+	}
+	if !tr.Until.IsZero() {
+		q = q.Where(column+" <= ?", tr.Until)
+	}
+	return q
+}
+
+// SessionOrder is the column session listings sort by, with the session ID breaking ties.
+type SessionOrder string
+
+const (
+	// SessionOrderCreatedAt sorts by creation time; the empty SessionOrder sorts the same way.
+	SessionOrderCreatedAt SessionOrder = "created_at"
+	// SessionOrderLastMessageAt sorts by the time of the newest message. The key moves as messages
+	// arrive, so a session may show up on a later page again or not at all while paging.
+	SessionOrderLastMessageAt SessionOrder = "last_message_at"
 )
 
+// column returns the sessions column o sorts by.
+func (o SessionOrder) column() string {
+	if o == SessionOrderLastMessageAt {
+		return "sessions.last_message_at"
+	}
+	return "sessions.created_at"
+}
+
+// AuthorFilter selects listed messages by author. A nil ID or an empty Type leaves that side
+// open, so the zero AuthorFilter matches every message, unattributed ones included.
+type AuthorFilter struct {
+	ID   *uuid.UUID
+	Type string
+}
+
+// where adds the author predicates to q.
+func (af AuthorFilter) where(q *gorm.DB) *gorm.DB {
+	if af.ID != nil {
+		q = q.Where("author_id = ?", *af.ID)
+	}
+	if af.Type != "" {
+		q = q.Where("author_type = ?", af.Type)
+	}
+	return q
+}
+
 // ErrSessionTooLarge is returned when a session exceeds MaxCopyableMessages.
 var ErrSessionTooLarge = errors.New("session exceeds maximum copyable size")
 
+// ErrMessageNotInSession is returned when a message does not belong to the given session.
+var ErrMessageNotInSession = errors.New("message does not belong to session")
+
+// ErrMessageCycle is returned when a message's parent chain loops back on itself.
+var ErrMessageCycle = errors.New("message parent chain contains a cycle")
+
+// ErrReparentCycle is returned when a message would be moved under one of its own descendants.
+var ErrReparentCycle = errors.New("message cannot be moved under its own subtree")
+
+// ErrMessageNotStreaming is returned when appending to or finalizing a message that is not streaming.
+var ErrMessageNotStreaming = errors.New("message is not streaming")
+
+// ErrMessageStreaming is returned when editing a message that is still being streamed.
+var ErrMessageStreaming = errors.New("message is still streaming")
+
+// ErrPartsChanged is returned when a message's parts were replaced since they were read.
+var ErrPartsChanged = errors.New("message parts changed concurrently")
+
+// ErrSummaryChanged is returned when a session was summarized again since it was read.
+var ErrSummaryChanged = errors.New("session summary changed concurrently")
+
+// ErrDuplicateIdempotencyKey is returned when another message of the session already holds the key.
+var ErrDuplicateIdempotencyKey = errors.New("idempotency key already used in session")
+
 type SessionRepo interface {
 	Create(ctx context.Context, s *model.Session) error
 	Delete(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) error
+	DeleteSessionCascade(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) (*DeleteSessionCascadeResult, error)
 	Update(ctx context.Context, s *model.Session) error
 	Get(ctx context.Context, s *model.Session) (*model.Session, error)
 	GetDisableTaskTracking(ctx context.Context, sessionID uuid.UUID) (bool, error)
-	ListWithCursor(ctx context.Context, projectID uuid.UUID, userIdentifier string, filterByConfigs map[string]interface{}, afterCreatedAt time.Time, afterID uuid.UUID, limit int, timeDesc bool) ([]model.Session, error)
-	CreateMessageWithAssets(ctx context.Context, msg *model.Message) error
-	ListBySessionWithCursor(ctx context.Context, sessionID uuid.UUID, afterCreatedAt time.Time, afterID uuid.UUID, limit int, timeDesc bool) ([]model.Message, error)
-	ListAllMessagesBySession(ctx context.Context, sessionID uuid.UUID) ([]model.Message, error)
+	UpdateLabels(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, update func(s *model.Session) error) (*model.Session, error)
+	UpdateSummary(ctx context.Context, sessionID uuid.UUID, expectedMarker *uuid.UUID, summary string, marker uuid.UUID) error
+	ListWithCursor(ctx context.Context, projectID uuid.UUID, userIdentifier string, filterByConfigs map[string]interface{}, filterByMetadata map[string]interface{}, tags []string, includeTemplates bool, includeArchived bool, afterAt time.Time, afterID uuid.UUID, limit int, timeDesc bool, orderBy SessionOrder, createdIn TimeRange) ([]model.Session, error)
+	SetTemplate(ctx context.Context, sessionID uuid.UUID, isTemplate bool) error
+	SetSystemPrompt(ctx context.Context, sessionID uuid.UUID, prompt *string) error
+	SetAllowedPartTypes(ctx context.Context, sessionID uuid.UUID, types []string) error
+	ArchiveSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, key string, purge bool, userKEK []byte, store func(arc *SessionArchive) error) (*model.Session, error)
+	RestoreSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, load func(key string) (*SessionArchive, error)) (*RestoreSessionResult, error)
+	CreateMessageWithAssets(ctx context.Context, msg *model.Message, maxSessionBytes int64) error
+	CreateMessagesBatch(ctx context.Context, sessionID uuid.UUID, msgs []model.Message) error
+	CreateSessionWithMessages(ctx context.Context, s *model.Session, msgs []model.Message) error
+	ListSessionsForExport(ctx context.Context, projectID uuid.UUID, afterCreatedAt time.Time, afterID uuid.UUID, limit int, includeDeleted bool) ([]model.Session, error)
+	ListMessagesForExport(ctx context.Context, sessionID uuid.UUID, afterSeq int64, afterID uuid.UUID, limit int, includeDeleted bool) ([]model.Message, error)
+	ListBySessionWithCursor(ctx context.Context, sessionID uuid.UUID, afterSeq int64, afterID uuid.UUID, limit int, timeDesc bool, roles []string, columns []string, createdIn TimeRange, author AuthorFilter, annotated AnnotationFilter) ([]model.Message, error)
+	ListAllMessagesBySession(ctx context.Context, sessionID uuid.UUID, roles []string, columns []string, createdIn TimeRange, author AuthorFilter, annotated AnnotationFilter) ([]model.Message, error)
 	GetObservingStatus(ctx context.Context, sessionID string) (*model.MessageObservingStatus, error)
 	PopGeminiCallIDAndName(ctx context.Context, sessionID uuid.UUID) (string, string, error)
 	GetMessageByID(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) (*model.Message, error)
+	GetMessageByIdempotencyKey(ctx context.Context, sessionID uuid.UUID, key string) (*model.Message, error)
+	GetMessageThread(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.Message, error)
+	GetMessageTreeStats(ctx context.Context, sessionID uuid.UUID, messageIDs []uuid.UUID) (map[uuid.UUID]model.MessageTreeStats, error)
+	ReparentMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, newParentID *uuid.UUID) (int, error)
+	ValidateTree(ctx context.Context, sessionID uuid.UUID) ([]BrokenParentLink, error)
+	RepairTree(ctx context.Context, sessionID uuid.UUID, mode TreeRepairMode) (*RepairTreeResult, error)
+	FinalizeSession(ctx context.Context, sessionID uuid.UUID) (*model.Session, error)
+	MergeSessions(ctx context.Context, projectID uuid.UUID, targetID uuid.UUID, sourceIDs []uuid.UUID, mode SessionMergeMode) (*MergeSessionsResult, error)
+	CreateImmutabilityOverride(ctx context.Context, o *model.ImmutabilityOverride) error
+	ListImmutabilityOverrides(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) ([]model.ImmutabilityOverride, error)
+	SetMessagePinned(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, pinned bool) error
+	ListPinnedMessages(ctx context.Context, sessionID uuid.UUID) ([]model.Message, error)
+	SetMessageFlag(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, flagged bool, reason string, note string) (*model.MessageFlagAudit, error)
+	ListMessageFlagAudits(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageFlagAudit, error)
+	CreateMessageAnnotation(ctx context.Context, sessionID uuid.UUID, a *model.MessageAnnotation) error
+	ListMessageAnnotations(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageAnnotation, error)
+	DeleteMessageAnnotation(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, annotationID uuid.UUID) error
+	CountSessionReactions(ctx context.Context, sessionID uuid.UUID) (map[string]int64, error)
+	SearchMessages(ctx context.Context, projectID uuid.UUID, sessionID *uuid.UUID, query string, order MessageSearchOrder, limit int) ([]MessageSearchHit, error)
+	SearchMessagesBySession(ctx context.Context, projectID uuid.UUID, userIdentifier string, query string, afterLatest time.Time, afterSessionID uuid.UUID, limit int, hitsPerSession int) ([]SessionSearchGroup, error)
 	UpdateMessageMeta(ctx context.Context, messageID uuid.UUID, meta datatypes.JSONType[map[string]interface{}]) error
+	UpdateMessageTokenCount(ctx context.Context, messageID uuid.UUID, count int, encoding string) error
+	AppendStreamText(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, delta string) error
+	FinalizeStreamingMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, finalize func(msg *model.Message) error) (*model.Message, error)
+	UpdateMessageParts(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, update func(msg *model.Message) error) (*model.Message, *model.MessageRevision, error)
+	ReplaceMessageParts(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, expectedSHA256 string, update func(msg *model.Message) error) (*model.Message, error)
+	ListMessageRevisions(ctx context.Context, messageID uuid.UUID) ([]model.MessageRevision, error)
+	SessionTokenTotal(ctx context.Context, sessionID uuid.UUID) (*SessionTokenTotals, error)
+	DeleteMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) error
+	DeleteMessages(ctx context.Context, sessionID uuid.UUID, filter MessageFilter, cascade bool) (*DeleteMessagesResult, error)
+	RestoreMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) error
+	MergeDuplicateMessages(ctx context.Context, sessionID uuid.UUID, survivorID uuid.UUID, duplicateIDs []uuid.UUID) error
+	PurgeDeleted(ctx context.Context, olderThan time.Duration) (*PurgeDeletedResult, error)
+	ExpireIdempotencyKeys(ctx context.Context, olderThan time.Duration) (int64, error)
 	CopySession(ctx context.Context, sessionID uuid.UUID, userKEK []byte) (*CopySessionResult, error)
+	ForkSession(ctx context.Context, sessionID uuid.UUID, fromMessageID uuid.UUID, userKEK []byte) (*ForkSessionResult, error)
+	CloneSessionShallow(ctx context.Context, sessionID uuid.UUID, fromMessageID uuid.UUID) (*CloneSessionShallowResult, error)
 	HasUnfinishedMessages(ctx context.Context, sessionID uuid.UUID) (bool, error)
 	HasFailedMessages(ctx context.Context, sessionID uuid.UUID) (bool, error)
+	OldestMessageCreatedAt(ctx context.Context, sessionID uuid.UUID) (*time.Time, error)
 }
 
 // CopySessionResult contains the result of a copy operation
@@ -49,6 +185,98 @@ type CopySessionResult struct {
 	NewSessionID uuid.UUID
 }
 
+// ForkSessionResult contains the result of a fork operation
+type ForkSessionResult struct {
+	OldSessionID uuid.UUID
+	NewSessionID uuid.UUID
+	// MessageIDMap maps each copied source message ID to its new ID.
+	MessageIDMap map[uuid.UUID]uuid.UUID
+}
+
+// MessageSearchHit is a message matched by full-text search, with its rank and a highlighted snippet.
+// Score is the value hits were ordered by: the decayed rank for MessageSearchSortBlended and the
+// rank otherwise.
+type MessageSearchHit struct {
+	model.Message
+	Rank    float64
+	Score   float64
+	Snippet string
+}
+
+// MessageSearchSort selects how SearchMessages orders its hits.
+type MessageSearchSort string
+
+const (
+	// MessageSearchSortRelevance orders hits by text rank.
+	MessageSearchSortRelevance MessageSearchSort = "relevance"
+	// MessageSearchSortRecency orders hits by creation time, newest first.
+	MessageSearchSortRecency MessageSearchSort = "recency"
+	// MessageSearchSortBlended orders hits by text rank decayed with age, so a recent hit can
+	// outrank an older one that matches better.
+	MessageSearchSortBlended MessageSearchSort = "blended"
+)
+
+// MessageSearchOrder is the ordering of a message search. HalfLife is the age at which the
+// blended sort has halved a hit's rank; a zero HalfLife disables the decay.
+type MessageSearchOrder struct {
+	Sort     MessageSearchSort
+	HalfLife time.Duration
+}
+
+// maxSearchDecayHalfLives caps the exponent of the blended decay: 0.5^1000 is still a normal
+// float8, while larger exponents underflow, which Postgres reports as an error.
+const maxSearchDecayHalfLives = 1000
+
+// scoreSQL returns the expression scoring a hit with text rank rankSQL under the order.
+func (o MessageSearchOrder) scoreSQL(rankSQL string) string {
+	if o.Sort != MessageSearchSortBlended || o.HalfLife <= 0 {
+		return rankSQL
+	}
+	age := "GREATEST(extract(epoch FROM now() - m.created_at)::float8, 0)"
+	return fmt.Sprintf("%s * power(0.5::float8, LEAST(%s / %f, %d))", rankSQL, age, o.HalfLife.Seconds(), maxSearchDecayHalfLives)
+}
+
+// SessionSearchGroup is a session with messages matched by full-text search: its best hits by
+// rank, how many of its messages matched and when the newest of them was created.
+type SessionSearchGroup struct {
+	SessionID     uuid.UUID
+	Title         string
+	MatchCount    int
+	LatestMatchAt time.Time
+	Hits          []MessageSearchHit
+}
+
+// PurgeDeletedResult reports how many soft-deleted rows a purge removed
+type PurgeDeletedResult struct {
+	Sessions int64
+	Messages int64
+}
+
+const (
+	// unreferencedAssetGrace is how long after its last reference DeleteSessionCascade leaves an
+	// unreferenced asset to the OrphanAssetCollector instead of deleting it. Assets linked by
+	// content hash are only counted once the buffered reference is flushed, so a recent
+	// reference may not be counted yet.
+	unreferencedAssetGrace = 10 * time.Minute
+	// cascadeReadAttempts bounds how often DeleteSessionCascade retries to read the parts
+	// envelopes written while it ran outside of its transaction.
+	cascadeReadAttempts = 3
+)
+
+// errUnreadEnvelopes rolls back a DeleteSessionCascade attempt that found parts envelopes it has
+// not read yet.
+var errUnreadEnvelopes = errors.New("unread parts envelopes")
+
+// DeleteSessionCascadeResult reports what DeleteSessionCascade removed. Assets counts the distinct
+// assets whose references were released and Orphaned those left without any reference; Deferred
+// counts the orphans whose objects could not be deleted yet and are left to orphan collection.
+type DeleteSessionCascadeResult struct {
+	Messages int64
+	Assets   int
+	Orphaned int
+	Deferred int
+}
+
 type sessionRepo struct {
 	db                 *gorm.DB
 	assetReferenceRepo AssetReferenceRepo
@@ -69,70 +297,201 @@ func (r *sessionRepo) Create(ctx context.Context, s *model.Session) error {
 	return r.db.WithContext(ctx).Create(s).Error
 }
 
+// Delete soft-deletes the session. Its messages are hidden along with it because every
+// message read goes through the session first; rows and asset references are released later
+// by PurgeDeleted once the retention window has passed.
 func (r *sessionRepo) Delete(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) error {
-	// Use transaction to ensure atomicity: query messages, delete session, and decrement asset references
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// Verify session exists and belongs to project
-		var session model.Session
-		if err := tx.Where("id = ? AND project_id = ?", sessionID, projectID).First(&session).Error; err != nil {
-			return err
-		}
+	res := r.db.WithContext(ctx).Where("id = ? AND project_id = ?", sessionID, projectID).Delete(&model.Session{})
+	if res.Error != nil {
+		return fmt.Errorf("delete session: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
 
-		// Query all messages in transaction before deletion
-		var messages []model.Message
-		if err := tx.Where("session_id = ?", sessionID).Find(&messages).Error; err != nil {
-			return fmt.Errorf("query messages: %w", err)
+// DeleteSessionCascade hard-deletes the session, including when it is soft-deleted, together with
+// all of its messages. Revisions, flag audits, embeddings, enrichment jobs, tasks and events go
+// with them through ON DELETE CASCADE. Asset references held by the messages and their revisions
+// are released in the same transaction, so the rows and the counts never disagree. The objects of
+// assets left unreferenced are deleted once it commits, unless they were referenced within
+// unreferencedAssetGrace; those, and the ones whose deletion fails, stay as orphans with no
+// references for the OrphanAssetCollector. The references an archive of the session holds are
+// released too, and its object deleted. Messages shallow clones of the session share are handed
+// over to a clone and kept.
+//
+// Part-level assets are only known from the stored parts, which are read from S3 before the
+// session row is locked. Envelopes written in between are read after that transaction rolls back
+// and it is retried; the last attempt reads them under the lock.
+func (r *sessionRepo) DeleteSessionCascade(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) (*DeleteSessionCascadeResult, error) {
+	partLevel := make(map[string][]model.Asset)
+	readEnvelopes := func(envelopes []model.Asset) {
+		for _, env := range envelopes {
+			if _, ok := partLevel[env.S3Key]; ok || env.S3Key == "" {
+				continue
+			}
+			partLevel[env.S3Key] = r.collectPartLevelAssets(ctx, []model.Asset{env}, userKEK)
 		}
+	}
 
-		// Collect all assets from messages
-		assets := make([]model.Asset, 0)
-		for _, msg := range messages {
-			// Extract PartsAssetMeta (the asset that stores the parts JSON)
-			partsAssetMeta := msg.PartsAssetMeta.Data()
-			if partsAssetMeta.SHA256 != "" {
-				assets = append(assets, partsAssetMeta)
+	envelopes, err := cascadeEnvelopes(r.db.WithContext(ctx), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	readEnvelopes(envelopes)
+
+	var result *DeleteSessionCascadeResult
+	var orphaned []uuid.UUID
+	var archiveKey string
+	for attempt := 1; ; attempt++ {
+		var unread []model.Asset
+		result = &DeleteSessionCascadeResult{}
+		err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var session model.Session
+			if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "archive_key").
+				Where("id = ? AND project_id = ?", sessionID, projectID).
+				First(&session).Error; err != nil {
+				return err
 			}
+			archiveKey = session.ArchiveKey
 
-			// Download and parse parts to extract assets from individual parts
-			if r.s3 != nil && partsAssetMeta.S3Key != "" {
-				parts := []model.Part{}
-				if err := r.s3.DownloadJSON(ctx, partsAssetMeta.S3Key, &parts, userKEK); err != nil {
-					// Log error but continue with other messages
-					r.log.Warn("failed to download parts", zap.Error(err), zap.String("s3_key", partsAssetMeta.S3Key))
-					continue
-				}
+			// Rows shallow clones share leave the session before its references are counted.
+			if err := handOffSharedMessages(tx, []uuid.UUID{sessionID}); err != nil {
+				return err
+			}
 
-				// Extract assets from parts
-				for _, part := range parts {
-					if part.Asset != nil && part.Asset.SHA256 != "" {
-						assets = append(assets, *part.Asset)
-					}
+			partsAssets, err := cascadeEnvelopes(tx, sessionID)
+			if err != nil {
+				return err
+			}
+			for _, env := range partsAssets {
+				if _, ok := partLevel[env.S3Key]; !ok && env.S3Key != "" {
+					unread = append(unread, env)
 				}
 			}
-		}
+			if len(unread) > 0 {
+				if attempt < cascadeReadAttempts {
+					return errUnreadEnvelopes
+				}
+				readEnvelopes(unread)
+			}
+			assets := partsAssets
+			for _, env := range partsAssets {
+				assets = append(assets, partLevel[env.S3Key]...)
+			}
 
-		// Delete the session (messages will be automatically deleted by CASCADE)
-		if err := tx.Delete(&session).Error; err != nil {
-			return fmt.Errorf("delete session: %w", err)
-		}
+			res := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&model.Message{})
+			if res.Error != nil {
+				return fmt.Errorf("delete messages: %w", res.Error)
+			}
+			result.Messages = res.RowsAffected
+			if err := tx.Unscoped().Where("id = ?", sessionID).Delete(&model.Session{}).Error; err != nil {
+				return fmt.Errorf("delete session: %w", err)
+			}
 
-		// Note: BatchDecrementAssetRefs uses its own DB connection and may involve S3 operations
-		// The database operations within BatchDecrementAssetRefs will not be part of this transaction,
-		// but the session and messages deletion will be atomic
-		if len(assets) > 0 {
-			if err := r.assetReferenceRepo.BatchDecrementAssetRefs(ctx, projectID, assets); err != nil {
-				return fmt.Errorf("decrement asset references: %w", err)
+			released, ids, err := releaseAssetRefs(tx, projectID, assets)
+			if err != nil {
+				return err
 			}
+			result.Assets, orphaned = released, ids
+			return nil
+		})
+		if !errors.Is(err, errUnreadEnvelopes) {
+			break
 		}
+		readEnvelopes(unread)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.deleteArchiveObjects(ctx, []string{archiveKey})
 
-		return nil
-	})
+	result.Orphaned = len(orphaned)
+	if len(orphaned) == 0 {
+		return result, nil
+	}
+	deleted, err := r.assetReferenceRepo.DeleteUnreferencedAssets(ctx, projectID, orphaned, time.Now().Add(-unreferencedAssetGrace))
+	if err != nil {
+		r.log.Warn("failed to delete orphaned assets of deleted session, leaving them to orphan collection",
+			zap.Error(err), zap.String("session_id", sessionID.String()), zap.Int("assets", len(orphaned)))
+	}
+	result.Deferred = len(orphaned) - len(deleted)
+	return result, nil
+}
+
+// cascadeEnvelopes returns the parts envelopes DeleteSessionCascade releases: those of the
+// session's messages, soft-deleted ones included, of their revisions, and of its archive.
+func cascadeEnvelopes(tx *gorm.DB, sessionID uuid.UUID) ([]model.Asset, error) {
+	var metas []datatypes.JSONType[model.Asset]
+	if err := tx.Unscoped().Model(&model.Message{}).
+		Where("session_id = ?", sessionID).
+		Pluck("parts_asset_meta", &metas).Error; err != nil {
+		return nil, fmt.Errorf("query session messages: %w", err)
+	}
+	var revisionMetas []datatypes.JSONType[model.Asset]
+	if err := tx.Table("message_revisions r").
+		Joins("JOIN messages m ON m.id = r.message_id").
+		Where("m.session_id = ?", sessionID).
+		Pluck("r.parts_asset_meta", &revisionMetas).Error; err != nil {
+		return nil, fmt.Errorf("query message revisions: %w", err)
+	}
+	var session model.Session
+	if err := tx.Unscoped().Select("id", "archive_assets").Where("id = ?", sessionID).
+		Limit(1).Find(&session).Error; err != nil {
+		return nil, fmt.Errorf("query session archive: %w", err)
+	}
+
+	var envelopes []model.Asset
+	for _, meta := range append(metas, revisionMetas...) {
+		if a := meta.Data(); a.SHA256 != "" {
+			envelopes = append(envelopes, a)
+		}
+	}
+	return append(envelopes, session.ArchiveAssets...), nil
 }
 
 func (r *sessionRepo) Update(ctx context.Context, s *model.Session) error {
 	return r.db.WithContext(ctx).Where(&model.Session{ID: s.ID}).Updates(s).Error
 }
 
+// SetTemplate marks the session as a template or back as an ordinary session.
+func (r *sessionRepo) SetTemplate(ctx context.Context, sessionID uuid.UUID, isTemplate bool) error {
+	res := r.db.WithContext(ctx).Model(&model.Session{}).Where("id = ?", sessionID).UpdateColumn("is_template", isTemplate)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetAllowedPartTypes sets the part types the session's messages may carry; empty allows all.
+func (r *sessionRepo) SetAllowedPartTypes(ctx context.Context, sessionID uuid.UUID, types []string) error {
+	res := r.db.WithContext(ctx).Model(&model.Session{}).Where("id = ?", sessionID).
+		UpdateColumn("allowed_part_types", datatypes.JSONSlice[string](types))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetSystemPrompt sets the session's system prompt override; nil clears it.
+func (r *sessionRepo) SetSystemPrompt(ctx context.Context, sessionID uuid.UUID, prompt *string) error {
+	res := r.db.WithContext(ctx).Model(&model.Session{}).Where("id = ?", sessionID).UpdateColumn("system_prompt", prompt)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *sessionRepo) Get(ctx context.Context, s *model.Session) (*model.Session, error) {
 	return s, r.db.WithContext(ctx).First(s).Error
 }
@@ -148,8 +507,64 @@ func (r *sessionRepo) GetDisableTaskTracking(ctx context.Context, sessionID uuid
 	return result.DisableTaskTracking, err
 }
 
-func (r *sessionRepo) ListWithCursor(ctx context.Context, projectID uuid.UUID, userIdentifier string, filterByConfigs map[string]interface{}, afterCreatedAt time.Time, afterID uuid.UUID, limit int, timeDesc bool) ([]model.Session, error) {
+// UpdateLabels locks the project's session, lets update change its metadata and tags, and saves
+// both. It returns gorm.ErrRecordNotFound when the session does not exist in the project.
+func (r *sessionRepo) UpdateLabels(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, update func(s *model.Session) error) (*model.Session, error) {
+	var session model.Session
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND project_id = ?", sessionID, projectID).
+			First(&session).Error; err != nil {
+			return err
+		}
+		if err := update(&session); err != nil {
+			return err
+		}
+		if session.Metadata == nil {
+			session.Metadata = datatypes.JSONMap{}
+		}
+		if session.Tags == nil {
+			session.Tags = model.StringArray{}
+		}
+		return tx.Model(&session).Select("metadata", "tags", "updated_at").Updates(&session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// UpdateSummary stores the session summary and the last message it covers, provided the
+// session is still summarized up to expectedMarker; otherwise it returns ErrSummaryChanged.
+func (r *sessionRepo) UpdateSummary(ctx context.Context, sessionID uuid.UUID, expectedMarker *uuid.UUID, summary string, marker uuid.UUID) error {
+	q := r.db.WithContext(ctx).Model(&model.Session{}).Where("id = ?", sessionID)
+	if expectedMarker == nil {
+		q = q.Where("summarized_up_to_message_id IS NULL")
+	} else {
+		q = q.Where("summarized_up_to_message_id = ?", *expectedMarker)
+	}
+	res := q.Updates(map[string]any{
+		"summary":                     summary,
+		"summarized_up_to_message_id": marker,
+		"updated_at":                  time.Now(),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrSummaryChanged
+	}
+	return nil
+}
+
+func (r *sessionRepo) ListWithCursor(ctx context.Context, projectID uuid.UUID, userIdentifier string, filterByConfigs map[string]interface{}, filterByMetadata map[string]interface{}, tags []string, includeTemplates bool, includeArchived bool, afterAt time.Time, afterID uuid.UUID, limit int, timeDesc bool, orderBy SessionOrder, createdIn TimeRange) ([]model.Session, error) {
 	q := r.db.WithContext(ctx).Where("sessions.project_id = ?", projectID)
+	if !includeTemplates {
+		q = q.Where("sessions.is_template = ?", false)
+	}
+	if !includeArchived {
+		q = q.Where("sessions.archived = ?", false)
+	}
 
 	// Filter by user identifier if provided
 	if userIdentifier != "" {
@@ -167,78 +582,360 @@ func (r *sessionRepo) ListWithCursor(ctx context.Context, projectID uuid.UUID, u
 		}
 		q = q.Where("sessions.configs @> ?", string(jsonBytes))
 	}
+	if len(filterByMetadata) > 0 {
+		jsonBytes, err := json.Marshal(filterByMetadata)
+		if err != nil {
+			return nil, fmt.Errorf("marshal filter_by_metadata: %w", err)
+		}
+		q = q.Where("sessions.metadata @> ?", string(jsonBytes))
+	}
+	// Sessions must carry every requested tag
+	if len(tags) > 0 {
+		q = q.Where("sessions.tags @> ?::text[]", model.StringArray(tags))
+	}
 
-	// Apply cursor-based pagination filter if cursor is provided
-	if !afterCreatedAt.IsZero() && afterID != uuid.Nil {
+	q = createdIn.where(q, "sessions.created_at")
+
+	// Apply cursor-based pagination filter if cursor is provided; the cursor holds the sort key
+	// of the last session seen.
+	column := orderBy.column()
+	if !afterAt.IsZero() && afterID != uuid.Nil {
 		// Determine comparison operator based on sort direction
 		comparisonOp := ">"
 		if timeDesc {
 			comparisonOp = "<"
 		}
 		q = q.Where(
-			"(sessions.created_at "+comparisonOp+" ?) OR (sessions.created_at = ? AND sessions.id "+comparisonOp+" ?)",
-			afterCreatedAt, afterCreatedAt, afterID,
+			"("+column+" "+comparisonOp+" ?) OR ("+column+" = ? AND sessions.id "+comparisonOp+" ?)",
+			afterAt, afterAt, afterID,
 		)
 	}
 
 	// Apply ordering based on sort direction
-	orderBy := "sessions.created_at ASC, sessions.id ASC"
+	order := column + " ASC, sessions.id ASC"
 	if timeDesc {
-		orderBy = "sessions.created_at DESC, sessions.id DESC"
+		order = column + " DESC, sessions.id DESC"
 	}
 
 	var sessions []model.Session
-	return sessions, q.Order(orderBy).Limit(limit).Find(&sessions).Error
+	return sessions, q.Order(order).Limit(limit).Find(&sessions).Error
 }
 
-func (r *sessionRepo) CreateMessageWithAssets(ctx context.Context, msg *model.Message) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// First get the message parent id in session
+// CreateMessageWithAssets appends msg to the session. A message with an IdempotencyKey that a live
+// message of the session already holds is rejected with ErrDuplicateIdempotencyKey; keys older than
+// IdempotencyKeyTTL or held by deleted messages are released first. With maxSessionBytes above
+// zero, a message that would take the session's TotalBytes past it is rejected with a
+// *SessionQuotaError; the check and the increment are one statement, so concurrent writers cannot
+// overshoot the limit together.
+func (r *sessionRepo) CreateMessageWithAssets(ctx context.Context, msg *model.Message, maxSessionBytes int64) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if msg.IdempotencyKey != nil {
+			if err := tx.Unscoped().Model(&model.Message{}).
+				Where("session_id = ? AND idempotency_key = ?", msg.SessionID, *msg.IdempotencyKey).
+				Where("created_at < ? OR deleted_at IS NOT NULL", time.Now().Add(-IdempotencyKeyTTL)).
+				UpdateColumn("idempotency_key", nil).Error; err != nil {
+				return fmt.Errorf("release idempotency key: %w", err)
+			}
+		}
+
+		// First get the message parent id in session; a shallow clone's first message continues
+		// from its base message.
 		parent := model.Message{}
-		if err := tx.Select("id").Where(&model.Message{SessionID: msg.SessionID}).Order("created_at desc").Limit(1).Find(&parent).Error; err == nil {
+		if err := tx.Select("id").Where(&model.Message{SessionID: msg.SessionID}).Order("seq desc").Limit(1).Find(&parent).Error; err == nil {
 			if parent.ID != uuid.Nil {
 				msg.ParentID = &parent.ID
+			} else {
+				var session model.Session
+				if err := tx.Select("base_message_id").Where("id = ?", msg.SessionID).Limit(1).Find(&session).Error; err == nil {
+					msg.ParentID = session.BaseMessageID
+				}
 			}
 		}
 
+		seq, err := reserveMessageSeqs(tx, msg.SessionID, 1)
+		if err != nil {
+			return err
+		}
+		msg.Seq = seq
+		if err := addSessionBytesWithin(tx, msg.SessionID, msg.StorageBytes, maxSessionBytes); err != nil {
+			return err
+		}
+
 		// Create message
 		if err := tx.Create(msg).Error; err != nil {
 			return err
 		}
+		if err := addSessionMessages(tx, msg.SessionID, 1, msg.CreatedAt); err != nil {
+			return err
+		}
+
+		createdAt := msg.CreatedAt
+		return notifyMessageStream(tx, model.MessageStreamEvent{
+			Type:      model.StreamEventMessageCreated,
+			SessionID: msg.SessionID,
+			MessageID: msg.ID,
+			ParentID:  msg.ParentID,
+			Role:      msg.Role,
+			Streaming: msg.Streaming,
+			CreatedAt: &createdAt,
+		})
+	})
+	if err != nil && msg.IdempotencyKey != nil && isUniqueViolation(err) {
+		return ErrDuplicateIdempotencyKey
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "23505")
+}
+
+// BatchMessageError reports the message that made CreateMessagesBatch reject the whole batch.
+type BatchMessageError struct {
+	Index  int
+	Reason string
+}
+
+func (e *BatchMessageError) Error() string {
+	return fmt.Sprintf("message %d: %s", e.Index, e.Reason)
+}
+
+// CreateMessagesBatch inserts msgs into the session in one transaction, parents before children.
+// Each message's ID and ParentID are client-side temporary references: every message gets a fresh
+// ID, and a ParentID naming another message of the batch is rewritten to that message's new ID.
+// A ParentID outside the batch must be a live message of the session; a nil ParentID stays nil.
+// Messages without CreatedAt keep their input order. If any message is invalid nothing is written
+// and a *BatchMessageError names it. On success msgs holds the stored IDs, seqs and timestamps.
+func (r *sessionRepo) CreateMessagesBatch(ctx context.Context, sessionID uuid.UUID, msgs []model.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return createMessagesBatch(tx, sessionID, msgs)
+	})
+}
 
+// CreateSessionWithMessages creates s and inserts msgs into it with CreateMessagesBatch
+// semantics, all in one transaction: on any error neither the session nor a message is stored.
+func (r *sessionRepo) CreateSessionWithMessages(ctx context.Context, s *model.Session, msgs []model.Message) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		if err := createMessagesBatch(tx, s.ID, msgs); err != nil {
+			return err
+		}
+		s.TotalBytes += sumStorageBytes(msgs)
+		s.MessageCount += len(msgs)
+		if newest := newestCreatedAt(msgs); newest.After(s.LastMessageAt) {
+			s.LastMessageAt = newest
+		}
 		return nil
 	})
 }
 
-func (r *sessionRepo) ListBySessionWithCursor(ctx context.Context, sessionID uuid.UUID, afterCreatedAt time.Time, afterID uuid.UUID, limit int, timeDesc bool) ([]model.Message, error) {
-	q := r.db.WithContext(ctx).Where("session_id = ?", sessionID)
+func createMessagesBatch(tx *gorm.DB, sessionID uuid.UUID, msgs []model.Message) error {
+	tempIndex := make(map[uuid.UUID]int, len(msgs))
+	for i, m := range msgs {
+		if !model.IsStoredRole(m.Role) {
+			return &BatchMessageError{Index: i, Reason: fmt.Sprintf("invalid role %q", m.Role)}
+		}
+		if m.ID == uuid.Nil {
+			continue
+		}
+		if _, dup := tempIndex[m.ID]; dup {
+			return &BatchMessageError{Index: i, Reason: fmt.Sprintf("duplicate id %s", m.ID)}
+		}
+		tempIndex[m.ID] = i
+	}
+
+	order, err := batchTopologicalOrder(msgs, tempIndex)
+	if err != nil {
+		return err
+	}
+
+	// Parents outside the batch must already exist in the session.
+	external := map[uuid.UUID]bool{}
+	for _, m := range msgs {
+		if m.ParentID == nil {
+			continue
+		}
+		if _, ok := tempIndex[*m.ParentID]; !ok {
+			external[*m.ParentID] = true
+		}
+	}
+	if len(external) > 0 {
+		ids := make([]uuid.UUID, 0, len(external))
+		for id := range external {
+			ids = append(ids, id)
+		}
+		var found []uuid.UUID
+		if err := tx.Model(&model.Message{}).
+			Where("session_id = ? AND id IN ?", sessionID, ids).
+			Pluck("id", &found).Error; err != nil {
+			return err
+		}
+		for _, id := range found {
+			delete(external, id)
+		}
+		for i, m := range msgs {
+			if m.ParentID != nil && external[*m.ParentID] {
+				return &BatchMessageError{Index: i, Reason: fmt.Sprintf("parent %s not found in batch or session", *m.ParentID)}
+			}
+		}
+	}
+
+	realIDs := make([]uuid.UUID, len(msgs))
+	for i := range msgs {
+		realIDs[i] = model.NewMessageID()
+	}
+	firstSeq, err := reserveMessageSeqs(tx, sessionID, len(msgs))
+	if err != nil {
+		return err
+	}
+	base := time.Now()
+	ordered := make([]model.Message, 0, len(msgs))
+	for _, i := range order {
+		m := msgs[i]
+		m.ID = realIDs[i]
+		m.SessionID = sessionID
+		// Seqs follow the input order, like the default timestamps.
+		m.Seq = firstSeq + int64(i)
+		if m.ParentID != nil {
+			if j, ok := tempIndex[*m.ParentID]; ok {
+				parentID := realIDs[j]
+				m.ParentID = &parentID
+			}
+		}
+		if m.CreatedAt.IsZero() {
+			m.CreatedAt = base.Add(time.Duration(i) * time.Microsecond)
+		}
+		ordered = append(ordered, m)
+	}
+
+	if err := tx.CreateInBatches(ordered, 100).Error; err != nil {
+		return err
+	}
+	if err := addSessionBytes(tx, sessionID, sumStorageBytes(ordered)); err != nil {
+		return err
+	}
+	if err := addSessionMessages(tx, sessionID, len(ordered), newestCreatedAt(ordered)); err != nil {
+		return err
+	}
+	for k, i := range order {
+		createdAt := ordered[k].CreatedAt
+		if err := notifyMessageStream(tx, model.MessageStreamEvent{
+			Type:      model.StreamEventMessageCreated,
+			SessionID: sessionID,
+			MessageID: ordered[k].ID,
+			ParentID:  ordered[k].ParentID,
+			Role:      ordered[k].Role,
+			CreatedAt: &createdAt,
+		}); err != nil {
+			return err
+		}
+		msgs[i] = ordered[k]
+	}
+	return nil
+}
+
+// batchTopologicalOrder returns the indexes of msgs ordered so every in-batch parent precedes its
+// children. Parent links that loop are reported as a BatchMessageError.
+func batchTopologicalOrder(msgs []model.Message, tempIndex map[uuid.UUID]int) ([]int, error) {
+	children := make([][]int, len(msgs))
+	pending := make([]bool, len(msgs))
+	var ready []int
+	for i, m := range msgs {
+		if m.ParentID != nil {
+			if j, ok := tempIndex[*m.ParentID]; ok {
+				children[j] = append(children[j], i)
+				pending[i] = true
+				continue
+			}
+		}
+		ready = append(ready, i)
+	}
+
+	order := make([]int, 0, len(msgs))
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		order = append(order, i)
+		for _, c := range children[i] {
+			pending[c] = false
+			ready = append(ready, c)
+		}
+	}
+	if len(order) < len(msgs) {
+		for i, p := range pending {
+			if p {
+				return nil, &BatchMessageError{Index: i, Reason: ErrMessageCycle.Error()}
+			}
+		}
+	}
+	return order, nil
+}
+
+// ListBySessionWithCursor returns a keyset-paginated page of messages ordered by (seq, id).
+// The cursor is the (seq, id) tuple of the last message seen, so pages stay stable while
+// new messages are appended concurrently: rows after the cursor are never shifted by inserts.
+// A non-empty roles restricts the page to messages with one of those roles, and a non-empty
+// columns reads only those columns, leaving the other fields zero. A shallow clone's page includes
+// the messages it shares, which sort before its own.
+func (r *sessionRepo) ListBySessionWithCursor(ctx context.Context, sessionID uuid.UUID, afterSeq int64, afterID uuid.UUID, limit int, timeDesc bool, roles []string, columns []string, createdIn TimeRange, author AuthorFilter, annotated AnnotationFilter) ([]model.Message, error) {
+	q, err := sessionMessages(r.db.WithContext(ctx), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		q = q.Where("role IN ?", roles)
+	}
+	if len(columns) > 0 {
+		q = q.Select(columns)
+	}
+	// The range and the (seq, id) seek combine on idx_session_created and idx_session_seq.
+	q = annotated.where(author.where(createdIn.where(q, "created_at")))
 
 	// Apply cursor-based pagination filter if cursor is provided
-	if !afterCreatedAt.IsZero() && afterID != uuid.Nil {
+	if afterID != uuid.Nil {
 		// Determine comparison operator based on sort direction
 		comparisonOp := ">"
 		if timeDesc {
 			comparisonOp = "<"
 		}
-		q = q.Where(
-			"(created_at "+comparisonOp+" ?) OR (created_at = ? AND id "+comparisonOp+" ?)",
-			afterCreatedAt, afterCreatedAt, afterID,
-		)
+		// Row-value comparison lets PostgreSQL use idx_session_seq for the seek
+		q = q.Where("(seq, id) "+comparisonOp+" (?, ?)", afterSeq, afterID)
 	}
 
 	// Apply ordering based on sort direction
-	orderBy := "created_at ASC, id ASC"
+	orderBy := "seq ASC, id ASC"
 	if timeDesc {
-		orderBy = "created_at DESC, id DESC"
+		orderBy = "seq DESC, id DESC"
 	}
 
 	var items []model.Message
 	return items, q.Order(orderBy).Limit(limit).Find(&items).Error
 }
 
-func (r *sessionRepo) ListAllMessagesBySession(ctx context.Context, sessionID uuid.UUID) ([]model.Message, error) {
+// ListAllMessagesBySession returns every message of the session in seq order, filtered by roles,
+// createdIn and author and reading only columns as in ListBySessionWithCursor.
+func (r *sessionRepo) ListAllMessagesBySession(ctx context.Context, sessionID uuid.UUID, roles []string, columns []string, createdIn TimeRange, author AuthorFilter, annotated AnnotationFilter) ([]model.Message, error) {
 	var messages []model.Message
-	err := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Find(&messages).Error
+	q, err := sessionMessages(r.db.WithContext(ctx), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		q = q.Where("role IN ?", roles)
+	}
+	if len(columns) > 0 {
+		q = q.Select(columns)
+	}
+	err = annotated.where(author.where(createdIn.where(q, "created_at"))).Order("seq ASC, id ASC").Find(&messages).Error
 	return messages, err
 }
 
@@ -312,7 +1009,7 @@ func (r *sessionRepo) PopGeminiCallIDAndName(ctx context.Context, sessionID uuid
 			Where("session_id = ?", sessionID).
 			Where(keyPath + " IS NOT NULL").
 			Where(fmt.Sprintf("jsonb_array_length(%s) > 0", arrayPath)).
-			Order("created_at ASC, id ASC").
+			Order("seq ASC, id ASC").
 			Limit(1).
 			First(&msg).Error
 
@@ -416,12 +1113,28 @@ func (r *sessionRepo) PopGeminiCallIDAndName(ctx context.Context, sessionID uuid
 	return poppedID, poppedName, nil
 }
 
-// GetMessageByID retrieves a message by ID, verifying it belongs to the specified session.
+// GetMessageByID retrieves a message by ID, verifying it belongs to the specified session or is
+// shared with it by a shallow clone.
 // Returns gorm.ErrRecordNotFound if the message doesn't exist or doesn't belong to the session.
 func (r *sessionRepo) GetMessageByID(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) (*model.Message, error) {
+	var msg model.Message
+	q, err := sessionMessages(r.db.WithContext(ctx), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	err = q.Where("id = ?", messageID).First(&msg).Error
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// GetMessageByIdempotencyKey returns the live message of the session created with key within
+// IdempotencyKeyTTL, or gorm.ErrRecordNotFound.
+func (r *sessionRepo) GetMessageByIdempotencyKey(ctx context.Context, sessionID uuid.UUID, key string) (*model.Message, error) {
 	var msg model.Message
 	err := r.db.WithContext(ctx).
-		Where("id = ? AND session_id = ?", messageID, sessionID).
+		Where("session_id = ? AND idempotency_key = ? AND created_at >= ?", sessionID, key, time.Now().Add(-IdempotencyKeyTTL)).
 		First(&msg).Error
 	if err != nil {
 		return nil, err
@@ -429,21 +1142,1230 @@ func (r *sessionRepo) GetMessageByID(ctx context.Context, sessionID uuid.UUID, m
 	return &msg, nil
 }
 
-// UpdateMessageMeta updates the meta field of a message.
-func (r *sessionRepo) UpdateMessageMeta(ctx context.Context, messageID uuid.UUID, meta datatypes.JSONType[map[string]interface{}]) error {
-	return r.db.WithContext(ctx).
-		Model(&model.Message{}).
-		Where("id = ?", messageID).
-		Update("meta", meta).Error
+// GetMessageThread returns the chain of messages from the root down to messageID
+// (root first), so the index of each message is its depth in the tree.
+// Returns gorm.ErrRecordNotFound if the message doesn't belong to the session and
+// ErrMessageCycle if the parent links form a loop.
+func (r *sessionRepo) GetMessageThread(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.Message, error) {
+	return messageThread(r.db.WithContext(ctx), sessionID, messageID)
 }
 
-// CopySession creates a complete copy of a session with all its messages and tasks.
-// Uses SELECT FOR UPDATE to lock the session during the copy operation.
-// Returns CopySessionResult containing old and new session IDs.
-//
-// The operation is split into two phases to keep the lock window small:
-//  1. Transaction: lock session, create new session/messages/tasks, increment partsAsset refs.
-//  2. Post-transaction: download S3 parts to discover per-part assets, increment those refs.
+// GetMessageTreeStats returns the depth and live child count of each of messageIDs, in one
+// query over the session's tree. Depths count soft-deleted ancestors, which keep their place in
+// the tree; child counts only live children. Messages unreachable from a root, as on a corrupt
+// cycle, are left out.
+func (r *sessionRepo) GetMessageTreeStats(ctx context.Context, sessionID uuid.UUID, messageIDs []uuid.UUID) (map[uuid.UUID]model.MessageTreeStats, error) {
+	if len(messageIDs) == 0 {
+		return map[uuid.UUID]model.MessageTreeStats{}, nil
+	}
+	db := r.db.WithContext(ctx)
+	shared, err := sharedMessageIDs(db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID         uuid.UUID
+		Depth      int
+		ChildCount int
+	}
+	if err := db.Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT m.id, 0 AS depth
+			FROM messages m
+			WHERE m.parent_id IS NULL AND (m.session_id = ? OR m.id IN ?)
+			UNION ALL
+			SELECT c.id, t.depth + 1
+			FROM messages c
+			JOIN tree t ON c.parent_id = t.id
+			WHERE c.session_id = ? OR c.id IN ?
+		)
+		SELECT t.id, t.depth, COUNT(c.id) AS child_count
+		FROM tree t
+		LEFT JOIN messages c ON c.parent_id = t.id AND c.deleted_at IS NULL AND (c.session_id = ? OR c.id IN ?)
+		WHERE t.id IN ?
+		GROUP BY t.id, t.depth`,
+		sessionID, shared, sessionID, shared, sessionID, shared, messageIDs,
+	).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("query message tree stats: %w", err)
+	}
+	stats := make(map[uuid.UUID]model.MessageTreeStats, len(rows))
+	for _, row := range rows {
+		stats[row.ID] = model.MessageTreeStats{Depth: row.Depth, ChildCount: row.ChildCount}
+	}
+	return stats, nil
+}
+
+// ReparentMessage points messageID at newParentID, moving its whole subtree with it; a nil
+// newParentID makes it a root. It returns the message's new depth, 0 for a root. The session row
+// is locked so concurrent moves cannot combine into a cycle. Moving a message under itself or a
+// descendant returns ErrReparentCycle, and a parent from another session ErrMessageNotInSession.
+// A missing or deleted message or parent returns gorm.ErrRecordNotFound.
+func (r *sessionRepo) ReparentMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, newParentID *uuid.UUID) (int, error) {
+	depth := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").Where("id = ?", sessionID).
+			First(&model.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Select("id").
+			Where("id = ? AND session_id = ?", messageID, sessionID).
+			First(&model.Message{}).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return checkMessageWritable(tx, sessionID, messageID, err)
+			}
+			return err
+		}
+		if err := checkMessageWritable(tx, sessionID, messageID, nil); err != nil {
+			return err
+		}
+
+		if newParentID != nil {
+			var parent model.Message
+			if err := tx.Select("id", "session_id").Where("id = ?", *newParentID).First(&parent).Error; err != nil {
+				return err
+			}
+			if parent.SessionID != sessionID {
+				return ErrMessageNotInSession
+			}
+			// The new parent's ancestors must not include the moved message.
+			chain, err := messageThread(tx, sessionID, *newParentID)
+			if err != nil {
+				return err
+			}
+			for _, m := range chain {
+				if m.ID == messageID {
+					return ErrReparentCycle
+				}
+			}
+			depth = len(chain)
+		}
+
+		return tx.Model(&model.Message{}).Where("id = ?", messageID).
+			Updates(map[string]interface{}{"parent_id": newParentID, "updated_at": time.Now()}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return depth, nil
+}
+
+// SearchMessages runs a full-text search over the indexed text of messages in the project,
+// optionally scoped to one session, and returns hits in the given order; ties fall back to the
+// newest message. Messages without text parts have an empty SearchText and never match.
+func (r *sessionRepo) SearchMessages(ctx context.Context, projectID uuid.UUID, sessionID *uuid.UUID, query string, order MessageSearchOrder, limit int) ([]MessageSearchHit, error) {
+	tsv := fmt.Sprintf("to_tsvector('%s', m.search_text)", model.MessageSearchConfig)
+	rank := "ts_rank(" + tsv + ", q.query)"
+	q := r.db.WithContext(ctx).
+		Table("messages m").
+		Select("m.*, "+rank+" AS rank, "+order.scoreSQL(rank)+" AS score, "+
+			fmt.Sprintf("ts_headline('%s', m.search_text, q.query, 'StartSel=<mark>,StopSel=</mark>,MaxFragments=1,MaxWords=30,MinWords=10') AS snippet", model.MessageSearchConfig)).
+		Joins("JOIN sessions s ON s.id = m.session_id").
+		Joins(fmt.Sprintf("CROSS JOIN plainto_tsquery('%s', ?) AS q(query)", model.MessageSearchConfig), query).
+		Where("s.project_id = ? AND s.deleted_at IS NULL AND m.deleted_at IS NULL", projectID).
+		Where(tsv + " @@ q.query")
+	if sessionID != nil {
+		q = q.Where("m.session_id = ?", *sessionID)
+	}
+
+	switch order.Sort {
+	case MessageSearchSortRecency:
+		q = q.Order("m.created_at DESC, m.id DESC")
+	default:
+		q = q.Order("score DESC, m.created_at DESC, m.id DESC")
+	}
+
+	var hits []MessageSearchHit
+	err := q.Limit(limit).Scan(&hits).Error
+	return hits, err
+}
+
+// SearchMessagesBySession runs the full-text search of SearchMessages and groups the hits by
+// session. Groups are ordered by their newest matching message, newest first, and start after
+// (afterLatest, afterSessionID) unless afterLatest is zero; each carries at most hitsPerSession
+// hits ordered by rank. A non-empty userIdentifier restricts the search to that user's sessions.
+func (r *sessionRepo) SearchMessagesBySession(ctx context.Context, projectID uuid.UUID, userIdentifier string, query string, afterLatest time.Time, afterSessionID uuid.UUID, limit int, hitsPerSession int) ([]SessionSearchGroup, error) {
+	tsv := fmt.Sprintf("to_tsvector('%s', m.search_text)", model.MessageSearchConfig)
+	userJoin, userWhere := "", ""
+	args := []interface{}{query, projectID}
+	if userIdentifier != "" {
+		userJoin = "JOIN users u ON u.id = s.user_id"
+		userWhere = "AND u.identifier = ?"
+		args = append(args, userIdentifier)
+	}
+	cursorWhere := ""
+	if !afterLatest.IsZero() {
+		cursorWhere = "WHERE (latest_match_at, session_id) < (?, ?)"
+		args = append(args, afterLatest, afterSessionID)
+	}
+	args = append(args, limit, hitsPerSession)
+
+	var rows []struct {
+		MessageSearchHit
+		Title         string
+		MatchCount    int
+		LatestMatchAt time.Time
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		WITH hits AS (
+			SELECT m.*, q.query AS query, ts_rank(`+tsv+`, q.query) AS rank,
+				row_number() OVER (PARTITION BY m.session_id ORDER BY ts_rank(`+tsv+`, q.query) DESC, m.created_at DESC, m.id DESC) AS hit_index,
+				count(*) OVER (PARTITION BY m.session_id) AS match_count,
+				max(m.created_at) OVER (PARTITION BY m.session_id) AS latest_match_at
+			FROM messages m
+			JOIN sessions s ON s.id = m.session_id
+			`+userJoin+`
+			CROSS JOIN plainto_tsquery('`+model.MessageSearchConfig+`', ?) AS q(query)
+			WHERE s.project_id = ? AND s.deleted_at IS NULL AND m.deleted_at IS NULL `+userWhere+`
+				AND `+tsv+` @@ q.query
+		), groups AS (
+			SELECT DISTINCT session_id, latest_match_at FROM hits
+			`+cursorWhere+`
+			ORDER BY latest_match_at DESC, session_id DESC
+			LIMIT ?
+		)
+		SELECT h.*,
+			ts_headline('`+model.MessageSearchConfig+`', h.search_text, h.query, 'StartSel=<mark>,StopSel=</mark>,MaxFragments=1,MaxWords=30,MinWords=10') AS snippet,
+			COALESCE(s.metadata->>'`+model.SessionMetadataKeyTitle+`', '') AS title
+		FROM hits h
+		JOIN groups g ON g.session_id = h.session_id
+		JOIN sessions s ON s.id = h.session_id
+		WHERE h.hit_index <= ?
+		ORDER BY g.latest_match_at DESC, g.session_id DESC, h.hit_index`,
+		args...,
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []SessionSearchGroup
+	for _, row := range rows {
+		if n := len(groups); n == 0 || groups[n-1].SessionID != row.SessionID {
+			groups = append(groups, SessionSearchGroup{
+				SessionID:     row.SessionID,
+				Title:         row.Title,
+				MatchCount:    row.MatchCount,
+				LatestMatchAt: row.LatestMatchAt,
+			})
+		}
+		g := &groups[len(groups)-1]
+		g.Hits = append(g.Hits, row.MessageSearchHit)
+	}
+	return groups, nil
+}
+
+// messageThread walks parent links in a single recursive query. The visited path is
+// carried along so a cycle terminates the recursion instead of looping forever. The thread of
+// a shallow clone continues into the messages it shares.
+func messageThread(db *gorm.DB, sessionID uuid.UUID, messageID uuid.UUID) ([]model.Message, error) {
+	shared, err := sharedMessageIDs(db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var chain []model.Message
+	if err := db.Raw(`
+		WITH RECURSIVE ancestors AS (
+			SELECT m.*, 0 AS dist, ARRAY[m.id] AS path
+			FROM messages m
+			WHERE m.id = ? AND (m.session_id = ? OR m.id IN ?)
+			UNION ALL
+			SELECT p.*, a.dist + 1, a.path || p.id
+			FROM messages p
+			JOIN ancestors a ON p.id = a.parent_id
+			WHERE (p.session_id = ? OR p.id IN ?) AND NOT p.id = ANY(a.path)
+		)
+		SELECT * FROM ancestors ORDER BY dist DESC`,
+		messageID, sessionID, shared, sessionID, shared,
+	).Scan(&chain).Error; err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	// The recursion stops early on a revisit, leaving the top row pointing back into the chain.
+	if top := chain[0]; top.ParentID != nil {
+		for _, m := range chain {
+			if m.ID == *top.ParentID {
+				return nil, ErrMessageCycle
+			}
+		}
+	}
+	return chain, nil
+}
+
+// SetMessagePinned pins or unpins a message. Only the flag is written, so the message keeps its
+// seq, parent and updated_at. Returns gorm.ErrRecordNotFound if the message is not in the session.
+func (r *sessionRepo) SetMessagePinned(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, pinned bool) error {
+	res := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("id = ? AND session_id = ?", messageID, sessionID).
+		UpdateColumn("pinned", pinned)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListPinnedMessages returns the session's pinned messages in seq order.
+func (r *sessionRepo) ListPinnedMessages(ctx context.Context, sessionID uuid.UUID) ([]model.Message, error) {
+	var messages []model.Message
+	err := r.db.WithContext(ctx).
+		Where("session_id = ? AND pinned", sessionID).
+		Order("seq ASC, id ASC").
+		Find(&messages).Error
+	return messages, err
+}
+
+// SetMessageFlag sets the moderation flag of a message and records the change, with the flag it
+// replaces, as a MessageFlagAudit. Only the flag columns are written, so the message keeps its
+// updated_at. Returns gorm.ErrRecordNotFound if the message is not in the session.
+func (r *sessionRepo) SetMessageFlag(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, flagged bool, reason string, note string) (*model.MessageFlagAudit, error) {
+	var audit *model.MessageFlagAudit
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msg model.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "flagged", "flag_reason").
+			Where("id = ? AND session_id = ?", messageID, sessionID).
+			First(&msg).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Message{}).
+			Where("id = ?", messageID).
+			UpdateColumns(map[string]interface{}{"flagged": flagged, "flag_reason": reason}).Error; err != nil {
+			return err
+		}
+		audit = &model.MessageFlagAudit{
+			MessageID:          messageID,
+			PreviousFlagged:    msg.Flagged,
+			PreviousFlagReason: msg.FlagReason,
+			Flagged:            flagged,
+			FlagReason:         reason,
+			Note:               note,
+		}
+		return tx.Create(audit).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+// ListMessageFlagAudits returns the flag changes of a message in the session, oldest first.
+func (r *sessionRepo) ListMessageFlagAudits(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageFlagAudit, error) {
+	var audits []model.MessageFlagAudit
+	err := r.db.WithContext(ctx).
+		Joins("JOIN messages ON messages.id = message_flag_audits.message_id").
+		Where("message_flag_audits.message_id = ? AND messages.session_id = ?", messageID, sessionID).
+		Order("message_flag_audits.created_at ASC, message_flag_audits.id ASC").
+		Find(&audits).Error
+	return audits, err
+}
+
+// UpdateMessageMeta updates the meta field of a message.
+func (r *sessionRepo) UpdateMessageMeta(ctx context.Context, messageID uuid.UUID, meta datatypes.JSONType[map[string]interface{}]) error {
+	return r.db.WithContext(ctx).
+		Model(&model.Message{}).
+		Where("id = ?", messageID).
+		Update("meta", meta).Error
+}
+
+// UpdateMessageTokenCount stores a recomputed token count, e.g. after the message's parts change.
+func (r *sessionRepo) UpdateMessageTokenCount(ctx context.Context, messageID uuid.UUID, count int, encoding string) error {
+	return r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("id = ?", messageID).
+		Updates(map[string]interface{}{"token_count": count, "token_encoding": encoding}).Error
+}
+
+// lockStreamingMessage loads a message of the session with a row lock held for the rest of tx.
+func lockStreamingMessage(tx *gorm.DB, sessionID uuid.UUID, messageID uuid.UUID) (*model.Message, error) {
+	var msg model.Message
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND session_id = ?", messageID, sessionID).
+		First(&msg).Error; err != nil {
+		return nil, err
+	}
+	if !msg.Streaming {
+		return nil, ErrMessageNotStreaming
+	}
+	return &msg, nil
+}
+
+// AppendStreamText appends a text delta to a streaming message. The row lock serializes
+// concurrent appends so deltas land in call order and none are lost.
+func (r *sessionRepo) AppendStreamText(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, delta string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := lockStreamingMessage(tx, sessionID, messageID); err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Message{}).
+			Where("id = ?", messageID).
+			Update("stream_text", gorm.Expr("stream_text || ?", delta)).Error; err != nil {
+			return err
+		}
+		for _, chunk := range splitStreamDelta(delta, maxStreamDeltaBytes) {
+			if err := notifyMessageStream(tx, model.MessageStreamEvent{
+				Type:      model.StreamEventMessagePartAppended,
+				SessionID: sessionID,
+				MessageID: messageID,
+				Delta:     chunk,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FinalizeStreamingMessage locks a streaming message and lets finalize fill in its parts
+// asset and derived fields from StreamText. The message is then saved as complete.
+func (r *sessionRepo) FinalizeStreamingMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, finalize func(msg *model.Message) error) (*model.Message, error) {
+	var out *model.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		msg, err := lockStreamingMessage(tx, sessionID, messageID)
+		if err != nil {
+			return err
+		}
+		prevBytes := msg.StorageBytes
+		if err := finalize(msg); err != nil {
+			return err
+		}
+		msg.Streaming = false
+		msg.StreamText = ""
+		if err := tx.Model(msg).Select(
+			"parts_asset_meta", "search_text", "token_count", "token_encoding", "storage_bytes",
+			"session_task_process_status", "streaming", "stream_text",
+		).Updates(msg).Error; err != nil {
+			return err
+		}
+		if err := addSessionBytes(tx, sessionID, msg.StorageBytes-prevBytes); err != nil {
+			return err
+		}
+		out = msg
+		return notifyMessageStream(tx, model.MessageStreamEvent{
+			Type:      model.StreamEventMessageFinalized,
+			SessionID: sessionID,
+			MessageID: messageID,
+			ParentID:  msg.ParentID,
+			Role:      msg.Role,
+		})
+	})
+	return out, err
+}
+
+// UpdateMessageParts locks a message, saves its current parts as the next revision and lets update
+// fill in the new parts asset and derived fields; update sees the locked row and may reject the
+// edit. Only those columns, the version and updated_at are written, so created_at, the parent link
+// and the message's children are left as they were. The new revision is returned along with the
+// updated message, whose Version is one past the revision's.
+func (r *sessionRepo) UpdateMessageParts(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, update func(msg *model.Message) error) (*model.Message, *model.MessageRevision, error) {
+	var out *model.Message
+	var rev *model.MessageRevision
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msg model.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND session_id = ?", messageID, sessionID).
+			First(&msg).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return checkMessageWritable(tx, sessionID, messageID, err)
+			}
+			return err
+		}
+		if msg.Streaming {
+			return ErrMessageStreaming
+		}
+		if err := checkMessageWritable(tx, sessionID, messageID, nil); err != nil {
+			return err
+		}
+
+		var latest int
+		if err := tx.Model(&model.MessageRevision{}).
+			Where("message_id = ?", messageID).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error; err != nil {
+			return fmt.Errorf("read latest revision: %w", err)
+		}
+		rev = &model.MessageRevision{
+			MessageID:      messageID,
+			Version:        latest + 1,
+			PartsAssetMeta: msg.PartsAssetMeta,
+			TokenCount:     msg.TokenCount,
+		}
+		if err := tx.Create(rev).Error; err != nil {
+			return fmt.Errorf("create revision: %w", err)
+		}
+
+		prevBytes := msg.StorageBytes
+		if err := update(&msg); err != nil {
+			return err
+		}
+		msg.Version = rev.Version + 1
+		msg.UpdatedAt = time.Now()
+		if err := tx.Model(&msg).Select(
+			"parts_asset_meta", "search_text", "token_count", "token_encoding", "storage_bytes", "version", "updated_at",
+		).Updates(&msg).Error; err != nil {
+			return err
+		}
+		if err := addSessionBytes(tx, sessionID, msg.StorageBytes-prevBytes); err != nil {
+			return err
+		}
+		out = &msg
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, rev, nil
+}
+
+// ReplaceMessageParts swaps the message's parts object without recording a revision, for
+// system-generated rewrites such as enrichment results or template placeholder substitution.
+// Besides the parts asset and size, update may refresh the search text and token count. The
+// update only applies while the message still points at the parts object with expectedSHA256;
+// otherwise ErrPartsChanged is returned and the caller should read the parts again.
+func (r *sessionRepo) ReplaceMessageParts(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, expectedSHA256 string, update func(msg *model.Message) error) (*model.Message, error) {
+	var out *model.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msg model.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND session_id = ?", messageID, sessionID).
+			First(&msg).Error; err != nil {
+			return err
+		}
+		if msg.PartsAssetMeta.Data().SHA256 != expectedSHA256 {
+			return ErrPartsChanged
+		}
+
+		prevBytes := msg.StorageBytes
+		if err := update(&msg); err != nil {
+			return err
+		}
+		msg.UpdatedAt = time.Now()
+		if err := tx.Model(&msg).Select("parts_asset_meta", "storage_bytes", "search_text", "token_count", "token_encoding", "updated_at").Updates(&msg).Error; err != nil {
+			return err
+		}
+		if err := addSessionBytes(tx, sessionID, msg.StorageBytes-prevBytes); err != nil {
+			return err
+		}
+		out = &msg
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListMessageRevisions returns the saved revisions of a message, newest first.
+func (r *sessionRepo) ListMessageRevisions(ctx context.Context, messageID uuid.UUID) ([]model.MessageRevision, error) {
+	var revs []model.MessageRevision
+	err := r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("version DESC").
+		Find(&revs).Error
+	return revs, err
+}
+
+// maxStreamDeltaBytes bounds the delta carried by one append event. NOTIFY payloads are
+// capped below 8000 bytes and JSON escaping can grow text up to sixfold.
+const maxStreamDeltaBytes = 1024
+
+// notifyMessageStream queues ev on the session's stream channel. Postgres delivers it
+// only when tx commits, so subscribers never see rolled-back messages.
+func notifyMessageStream(tx *gorm.DB, ev model.MessageStreamEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return tx.Exec("SELECT pg_notify(?, ?)", model.MessageStreamChannel(ev.SessionID), string(payload)).Error
+}
+
+// splitStreamDelta cuts delta into chunks of at most maxBytes without splitting UTF-8 runes.
+func splitStreamDelta(delta string, maxBytes int) []string {
+	var chunks []string
+	for len(delta) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(delta[cut]) {
+			cut--
+		}
+		chunks = append(chunks, delta[:cut])
+		delta = delta[cut:]
+	}
+	return append(chunks, delta)
+}
+
+// SessionTokenTotals aggregates stored message token counts of a session.
+type SessionTokenTotals struct {
+	Total  int
+	ByRole map[string]int
+	// Encodings lists the distinct encodings the counts were computed with; "" marks uncounted messages.
+	Encodings []string
+}
+
+// SessionTokenTotal sums the stored token counts of the session's live messages, per role.
+func (r *sessionRepo) SessionTokenTotal(ctx context.Context, sessionID uuid.UUID) (*SessionTokenTotals, error) {
+	var rows []struct {
+		Role          string
+		TokenEncoding string
+		Tokens        int
+	}
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Select("role, token_encoding, COALESCE(SUM(token_count), 0) AS tokens").
+		Where("session_id = ?", sessionID).
+		Group("role, token_encoding").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	out := &SessionTokenTotals{ByRole: map[string]int{}}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		out.Total += row.Tokens
+		out.ByRole[row.Role] += row.Tokens
+		if !seen[row.TokenEncoding] {
+			seen[row.TokenEncoding] = true
+			out.Encodings = append(out.Encodings, row.TokenEncoding)
+		}
+	}
+	return out, nil
+}
+
+// DeleteMessage soft-deletes a message. Its children keep pointing at it, so tree
+// traversals that bypass the soft-delete scope still see the full path.
+func (r *sessionRepo) DeleteMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msg model.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "storage_bytes").
+			Where("id = ? AND session_id = ?", messageID, sessionID).
+			First(&msg).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return checkMessageWritable(tx, sessionID, messageID, err)
+			}
+			return err
+		}
+		if err := checkMessageWritable(tx, sessionID, messageID, nil); err != nil {
+			return err
+		}
+		if err := tx.Delete(&msg).Error; err != nil {
+			return err
+		}
+		if err := addSessionBytes(tx, sessionID, -msg.StorageBytes); err != nil {
+			return err
+		}
+		if err := addSessionMessages(tx, sessionID, -1, time.Time{}); err != nil {
+			return err
+		}
+		return notifyMessageStream(tx, model.MessageStreamEvent{
+			Type:      model.StreamEventMessageDeleted,
+			SessionID: sessionID,
+			MessageID: messageID,
+		})
+	})
+}
+
+// MessageFilter selects the live messages of a session for DeleteMessages. Its criteria combine:
+// a message must match all that are set.
+type MessageFilter struct {
+	// Roles are stored roles; tool results are stored under user.
+	Roles     []string
+	CreatedIn TimeRange
+	// Flagged, when set, matches on the moderation flag.
+	Flagged *bool
+}
+
+// IsEmpty reports whether the filter sets no criterion, so it would match every message.
+func (f MessageFilter) IsEmpty() bool {
+	return len(f.Roles) == 0 && f.CreatedIn.Since.IsZero() && f.CreatedIn.Until.IsZero() && f.Flagged == nil
+}
+
+// DeleteMessagesResult counts what DeleteMessages changed.
+type DeleteMessagesResult struct {
+	Deleted int64 `json:"deleted"`
+	// Reparented counts surviving children moved to the nearest surviving ancestor.
+	Reparented int64 `json:"reparented"`
+}
+
+// DeleteMessages soft-deletes the session's messages that match filter, in one transaction. With
+// cascade their live descendants are deleted with them; otherwise each surviving child of a deleted
+// message is moved to the nearest ancestor that survives, or made a root. A message.deleted event
+// is emitted for each deleted message and a message.reparented event for each moved child. The
+// session row is locked like in ReparentMessage. Matching a row shared with a shallow clone
+// returns ErrMessageShared and deletes nothing.
+func (r *sessionRepo) DeleteMessages(ctx context.Context, sessionID uuid.UUID, filter MessageFilter, cascade bool) (*DeleteMessagesResult, error) {
+	result := &DeleteMessagesResult{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			Where("id = ?", sessionID).First(&model.Session{}).Error; err != nil {
+			return err
+		}
+
+		q := tx.Model(&model.Message{}).Where("session_id = ?", sessionID)
+		if len(filter.Roles) > 0 {
+			q = q.Where("role IN ?", filter.Roles)
+		}
+		q = filter.CreatedIn.where(q, "created_at")
+		if filter.Flagged != nil {
+			q = q.Where("flagged = ?", *filter.Flagged)
+		}
+		var ids []uuid.UUID
+		if err := q.Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("query matching messages: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if cascade {
+			descendants, err := liveDescendantIDs(tx, sessionID, ids)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, descendants...)
+		}
+
+		cloned, err := clonedMessageIDs(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		for _, id := range cloned {
+			if slices.Contains(ids, id) {
+				return ErrMessageShared
+			}
+		}
+
+		var deleted []model.Message
+		if err := tx.Select("id", "parent_id", "storage_bytes").Where("id IN ?", ids).Find(&deleted).Error; err != nil {
+			return fmt.Errorf("load matching messages: %w", err)
+		}
+		parentOf := make(map[uuid.UUID]*uuid.UUID, len(deleted))
+		var bytes int64
+		for _, m := range deleted {
+			parentOf[m.ID] = m.ParentID
+			bytes += m.StorageBytes
+		}
+
+		// Surviving children are moved before their parents are deleted. Without cascade a
+		// descendant of a deleted message may itself be deleted, so the walk skips deleted
+		// ancestors.
+		var children []model.Message
+		if err := tx.Select("id", "parent_id").
+			Where("session_id = ? AND parent_id IN ? AND id NOT IN ?", sessionID, ids, ids).
+			Find(&children).Error; err != nil {
+			return fmt.Errorf("load children: %w", err)
+		}
+		for _, child := range children {
+			parent := child.ParentID
+			for steps := 0; parent != nil && steps <= len(parentOf); steps++ {
+				next, deletedParent := parentOf[*parent]
+				if !deletedParent {
+					break
+				}
+				parent = next
+			}
+			if err := tx.Model(&model.Message{}).Where("id = ?", child.ID).UpdateColumn("parent_id", parent).Error; err != nil {
+				return fmt.Errorf("reparent message %s: %w", child.ID, err)
+			}
+			if err := notifyMessageStream(tx, model.MessageStreamEvent{
+				Type:      model.StreamEventMessageReparented,
+				SessionID: sessionID,
+				MessageID: child.ID,
+				ParentID:  parent,
+			}); err != nil {
+				return err
+			}
+			result.Reparented++
+		}
+
+		res := tx.Where("id IN ?", ids).Delete(&model.Message{})
+		if res.Error != nil {
+			return fmt.Errorf("delete messages: %w", res.Error)
+		}
+		result.Deleted = res.RowsAffected
+		for _, m := range deleted {
+			if err := notifyMessageStream(tx, model.MessageStreamEvent{
+				Type:      model.StreamEventMessageDeleted,
+				SessionID: sessionID,
+				MessageID: m.ID,
+				ParentID:  m.ParentID,
+			}); err != nil {
+				return err
+			}
+		}
+		if err := addSessionBytes(tx, sessionID, -bytes); err != nil {
+			return err
+		}
+		return addSessionMessages(tx, sessionID, -int(res.RowsAffected), time.Time{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// liveDescendantIDs returns the live messages of the session below any of ids, ids excluded.
+func liveDescendantIDs(tx *gorm.DB, sessionID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error) {
+	var descendants []uuid.UUID
+	err := tx.Raw(`
+		WITH RECURSIVE below AS (
+			SELECT m.id FROM messages m
+			WHERE m.parent_id IN ? AND m.session_id = ? AND m.deleted_at IS NULL
+			UNION
+			SELECT m.id FROM messages m JOIN below b ON m.parent_id = b.id
+			WHERE m.session_id = ? AND m.deleted_at IS NULL
+		)
+		SELECT id FROM below WHERE id NOT IN ?`,
+		ids, sessionID, sessionID, ids,
+	).Scan(&descendants).Error
+	if err != nil {
+		return nil, fmt.Errorf("query descendants: %w", err)
+	}
+	return descendants, nil
+}
+
+// RestoreMessage clears the soft-delete marker of a message.
+// Returns gorm.ErrRecordNotFound if no soft-deleted message matches.
+func (r *sessionRepo) RestoreMessage(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msg model.Message
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "storage_bytes", "created_at").
+			Where("id = ? AND session_id = ? AND deleted_at IS NOT NULL", messageID, sessionID).
+			First(&msg).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&msg).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := addSessionBytes(tx, sessionID, msg.StorageBytes); err != nil {
+			return err
+		}
+		return addSessionMessages(tx, sessionID, 1, msg.CreatedAt)
+	})
+}
+
+// MergeDuplicateMessages folds duplicateIDs into survivorID: the children of the duplicates,
+// deleted ones included, are moved under the survivor, which takes the earliest CreatedAt of
+// the group and stays pinned if any duplicate was. The duplicates are then soft-deleted. Parts
+// are not touched. The session row is locked like ReparentMessage, and a survivor or duplicate
+// that is missing or deleted returns gorm.ErrRecordNotFound.
+func (r *sessionRepo) MergeDuplicateMessages(ctx context.Context, sessionID uuid.UUID, survivorID uuid.UUID, duplicateIDs []uuid.UUID) error {
+	if len(duplicateIDs) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").Where("id = ?", sessionID).
+			First(&model.Session{}).Error; err != nil {
+			return err
+		}
+		var dups []model.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "storage_bytes", "created_at", "pinned").
+			Where("id IN ? AND session_id = ?", duplicateIDs, sessionID).
+			Find(&dups).Error; err != nil {
+			return err
+		}
+		var survivor model.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "created_at", "pinned").
+			Where("id = ? AND session_id = ?", survivorID, sessionID).
+			First(&survivor).Error; err != nil {
+			return err
+		}
+		if len(dups) != len(duplicateIDs) {
+			return gorm.ErrRecordNotFound
+		}
+		for _, id := range duplicateIDs {
+			if err := checkMessageWritable(tx, sessionID, id, nil); err != nil {
+				return err
+			}
+		}
+
+		createdAt, pinned := survivor.CreatedAt, survivor.Pinned
+		var freed int64
+		for _, d := range dups {
+			if d.CreatedAt.Before(createdAt) {
+				createdAt = d.CreatedAt
+			}
+			pinned = pinned || d.Pinned
+			freed += d.StorageBytes
+		}
+
+		if err := tx.Unscoped().Model(&model.Message{}).
+			Where("session_id = ? AND parent_id IN ? AND id NOT IN ?", sessionID, duplicateIDs, duplicateIDs).
+			Updates(map[string]interface{}{"parent_id": survivorID, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Message{}).Where("id = ?", survivorID).
+			Updates(map[string]interface{}{"created_at": createdAt, "pinned": pinned, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", duplicateIDs).Delete(&model.Message{}).Error; err != nil {
+			return err
+		}
+		if err := addSessionBytes(tx, sessionID, -freed); err != nil {
+			return err
+		}
+		return addSessionMessages(tx, sessionID, -len(dups), time.Time{})
+	})
+}
+
+// addSessionBytes moves the session's TotalBytes by delta, never below zero. Messages stored before
+// sizes were tracked count as zero, so their deletion must not drive the counter negative.
+func addSessionBytes(tx *gorm.DB, sessionID uuid.UUID, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	return tx.Model(&model.Session{}).
+		Where("id = ?", sessionID).
+		Update("total_bytes", gorm.Expr("GREATEST(total_bytes + ?, 0)", delta)).Error
+}
+
+// SessionQuotaError reports a write that addSessionBytesWithin rejected: the session used Usage
+// bytes, Requested more were asked for, and Limit is the most it may hold.
+type SessionQuotaError struct {
+	Usage     int64
+	Requested int64
+	Limit     int64
+}
+
+func (e *SessionQuotaError) Error() string {
+	return fmt.Sprintf("session uses %d of %d bytes, %d more requested", e.Usage, e.Limit, e.Requested)
+}
+
+// addSessionBytesWithin grows the session's TotalBytes by delta unless that would take it past
+// limit, failing with a *SessionQuotaError. A limit <= 0, or a delta that does not grow the
+// session, falls back to addSessionBytes.
+func addSessionBytesWithin(tx *gorm.DB, sessionID uuid.UUID, delta int64, limit int64) error {
+	if limit <= 0 || delta <= 0 {
+		return addSessionBytes(tx, sessionID, delta)
+	}
+	res := tx.Model(&model.Session{}).
+		Where("id = ? AND total_bytes + ? <= ?", sessionID, delta, limit).
+		Update("total_bytes", gorm.Expr("total_bytes + ?", delta))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	var usage int64
+	if err := tx.Model(&model.Session{}).Where("id = ?", sessionID).Pluck("total_bytes", &usage).Error; err != nil {
+		return err
+	}
+	return &SessionQuotaError{Usage: usage, Requested: delta, Limit: limit}
+}
+
+// addSessionMessages moves the session's MessageCount by delta, never below zero. Adding messages
+// brings LastMessageAt forward to newest, the creation time of the newest one added; removing
+// messages recomputes it from the live messages left.
+func addSessionMessages(tx *gorm.DB, sessionID uuid.UUID, delta int, newest time.Time) error {
+	if delta == 0 {
+		return nil
+	}
+	lastMessageAt := gorm.Expr("GREATEST(last_message_at, ?)", newest)
+	if delta < 0 {
+		lastMessageAt = gorm.Expr(`GREATEST(created_at, (
+			SELECT MAX(m.created_at) FROM messages m
+			WHERE m.session_id = sessions.id AND m.deleted_at IS NULL
+		))`)
+	}
+	return tx.Model(&model.Session{}).
+		Where("id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"message_count":   gorm.Expr("GREATEST(message_count + ?, 0)", delta),
+			"last_message_at": lastMessageAt,
+		}).Error
+}
+
+// reserveMessageSeqs advances the session's LastMessageSeq by n and returns the first of the n
+// reserved values. The update row-locks the session until the transaction ends, so concurrent
+// inserts into one session get disjoint, increasing ranges. Archived sessions are rejected with
+// ErrSessionArchived and finalized ones with ErrSessionFinalized; the caller's transaction rolls
+// the advance back.
+func reserveMessageSeqs(tx *gorm.DB, sessionID uuid.UUID, n int) (int64, error) {
+	var row struct {
+		LastMessageSeq int64
+		Archived       bool
+		FinalizedAt    *time.Time
+	}
+	res := tx.Raw("UPDATE sessions SET last_message_seq = last_message_seq + ? WHERE id = ? RETURNING last_message_seq, archived, finalized_at", n, sessionID).Scan(&row)
+	if res.Error != nil {
+		return 0, fmt.Errorf("reserve message seq: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	if row.Archived {
+		return 0, ErrSessionArchived
+	}
+	if row.FinalizedAt != nil {
+		return 0, ErrSessionFinalized
+	}
+	return row.LastMessageSeq - int64(n) + 1, nil
+}
+
+// BackfillMessageSeqs numbers the messages of sessions stored before Seq existed by their
+// (created_at, id) order and sets each session's LastMessageSeq, batchSize sessions per
+// statement. Sessions still holding a message with seq 0 are renumbered in full. It returns
+// the number of sessions updated.
+func BackfillMessageSeqs(ctx context.Context, db *gorm.DB, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("BackfillMessageSeqs: batch size must be positive")
+	}
+	var total int64
+	for {
+		res := db.WithContext(ctx).Exec(`
+			WITH todo AS (
+				SELECT s.id FROM sessions s
+				WHERE EXISTS (SELECT 1 FROM messages m WHERE m.session_id = s.id AND m.seq = 0)
+				LIMIT ?
+				FOR UPDATE OF s
+			), numbered AS (
+				SELECT m.id, ROW_NUMBER() OVER (PARTITION BY m.session_id ORDER BY m.created_at, m.id) AS seq
+				FROM messages m
+				WHERE m.session_id IN (SELECT id FROM todo)
+			), renumbered AS (
+				UPDATE messages m SET seq = n.seq FROM numbered n
+				WHERE m.id = n.id
+				RETURNING m.session_id, m.seq
+			)
+			UPDATE sessions s SET last_message_seq = r.last_seq
+			FROM (SELECT session_id, MAX(seq) AS last_seq FROM renumbered GROUP BY session_id) r
+			WHERE s.id = r.session_id`, batchSize)
+		if res.Error != nil {
+			return total, fmt.Errorf("backfill message seqs: %w", res.Error)
+		}
+		total += res.RowsAffected
+		if res.RowsAffected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// BackfillMessageVersions sets the Version of messages edited before versions were stored to one
+// past their newest revision, batchSize messages per statement. It returns the number of messages
+// updated.
+func BackfillMessageVersions(ctx context.Context, db *gorm.DB, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("BackfillMessageVersions: batch size must be positive")
+	}
+	var total int64
+	for {
+		res := db.WithContext(ctx).Exec(`
+			UPDATE messages m SET version = r.latest + 1
+			FROM (
+				SELECT rv.message_id, MAX(rv.version) AS latest
+				FROM message_revisions rv
+				JOIN messages stale ON stale.id = rv.message_id
+				GROUP BY rv.message_id, stale.version
+				HAVING stale.version <= MAX(rv.version)
+				LIMIT ?
+			) r
+			WHERE m.id = r.message_id`, batchSize)
+		if res.Error != nil {
+			return total, fmt.Errorf("backfill message versions: %w", res.Error)
+		}
+		total += res.RowsAffected
+		if res.RowsAffected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func sumStorageBytes(msgs []model.Message) int64 {
+	var total int64
+	for _, m := range msgs {
+		total += m.StorageBytes
+	}
+	return total
+}
+
+// newestCreatedAt returns the latest CreatedAt of msgs, or the zero time when msgs is empty.
+func newestCreatedAt(msgs []model.Message) time.Time {
+	var newest time.Time
+	for _, m := range msgs {
+		if m.CreatedAt.After(newest) {
+			newest = m.CreatedAt
+		}
+	}
+	return newest
+}
+
+// ExpireIdempotencyKeys clears idempotency keys of messages created more than olderThan ago so the
+// unique index only covers keys that can still deduplicate a retry. It returns the number cleared.
+func (r *sessionRepo) ExpireIdempotencyKeys(ctx context.Context, olderThan time.Duration) (int64, error) {
+	res := r.db.WithContext(ctx).Unscoped().Model(&model.Message{}).
+		Where("idempotency_key IS NOT NULL AND created_at < ?", time.Now().Add(-olderThan)).
+		UpdateColumn("idempotency_key", nil)
+	if res.Error != nil {
+		return 0, fmt.Errorf("expire idempotency keys: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// purgedMessage is the subset of a message row PurgeDeleted needs to release its assets.
+type purgedMessage struct {
+	ID             uuid.UUID
+	ProjectID      uuid.UUID
+	PartsAssetMeta datatypes.JSONType[model.Asset]
+}
+
+// PurgeDeleted hard-deletes sessions and messages that were soft-deleted more than olderThan ago,
+// then releases their asset references. Purged sessions take their messages, tasks and events with
+// them through ON DELETE CASCADE. Live children of a purged message are re-attached to its nearest
+// surviving ancestor first so the cascade on parent_id does not remove them. Purged sessions that
+// were archived release the references their archive held, and the archive object is deleted.
+// Messages shallow clones of a purged session share are handed over to a clone and kept.
+//
+// Part-level assets are discovered by reading the parts envelope without a user KEK, so references
+// held by encrypted parts are left for orphan collection.
+func (r *sessionRepo) PurgeDeleted(ctx context.Context, olderThan time.Duration) (*PurgeDeletedResult, error) {
+	cutoff := time.Now().Add(-olderThan)
+	result := &PurgeDeletedResult{}
+	var purged, revisionAssets, archiveAssets []purgedMessage
+	var archiveKeys []string
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sessions []model.Session
+		if err := tx.Unscoped().Select("id", "project_id", "archive_key", "archive_assets").
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Limit(purgeBatchSize).
+			Find(&sessions).Error; err != nil {
+			return fmt.Errorf("query deleted sessions: %w", err)
+		}
+		sessionIDs := make([]uuid.UUID, 0, len(sessions))
+		for _, ss := range sessions {
+			sessionIDs = append(sessionIDs, ss.ID)
+			// Purged archives hold the references of their messages' parts objects.
+			for _, a := range ss.ArchiveAssets {
+				archiveAssets = append(archiveAssets, purgedMessage{ID: ss.ID, ProjectID: ss.ProjectID, PartsAssetMeta: datatypes.NewJSONType(a)})
+			}
+			if ss.ArchiveKey != "" {
+				archiveKeys = append(archiveKeys, ss.ArchiveKey)
+			}
+		}
+
+		if len(sessionIDs) > 0 {
+			if err := handOffSharedMessages(tx, sessionIDs); err != nil {
+				return err
+			}
+			if err := tx.Table("messages m").
+				Select("m.id, s.project_id, m.parts_asset_meta").
+				Joins("JOIN sessions s ON s.id = m.session_id").
+				Where("m.session_id IN ?", sessionIDs).
+				Scan(&purged).Error; err != nil {
+				return fmt.Errorf("query messages of deleted sessions: %w", err)
+			}
+		}
+		sessionMessages := int64(len(purged))
+
+		var messages []purgedMessage
+		if err := tx.Table("messages m").
+			Select("m.id, s.project_id, m.parts_asset_meta").
+			Joins("JOIN sessions s ON s.id = m.session_id").
+			Where("m.deleted_at IS NOT NULL AND m.deleted_at < ? AND s.deleted_at IS NULL", cutoff).
+			Limit(MaxCopyableMessages).
+			Scan(&messages).Error; err != nil {
+			return fmt.Errorf("query deleted messages: %w", err)
+		}
+
+		// Revisions go with their messages through ON DELETE CASCADE; keep their parts
+		// assets so the references they hold are released below.
+		if len(purged)+len(messages) > 0 {
+			ids := make([]uuid.UUID, 0, len(purged)+len(messages))
+			for _, m := range purged {
+				ids = append(ids, m.ID)
+			}
+			for _, m := range messages {
+				ids = append(ids, m.ID)
+			}
+			var revisions []purgedMessage
+			if err := tx.Table("message_revisions r").
+				Select("r.message_id AS id, s.project_id, r.parts_asset_meta").
+				Joins("JOIN messages m ON m.id = r.message_id").
+				Joins("JOIN sessions s ON s.id = m.session_id").
+				Where("r.message_id IN ?", ids).
+				Scan(&revisions).Error; err != nil {
+				return fmt.Errorf("query revisions of purged messages: %w", err)
+			}
+			revisionAssets = revisions
+		}
+
+		if len(messages) > 0 {
+			messageIDs := make([]uuid.UUID, 0, len(messages))
+			for _, m := range messages {
+				messageIDs = append(messageIDs, m.ID)
+			}
+
+			// Each pass lifts surviving children one level; the bound stops a corrupted cycle.
+			for i := 0; i <= len(messageIDs); i++ {
+				res := tx.Exec(`
+					UPDATE messages c SET parent_id = p.parent_id
+					FROM messages p
+					WHERE c.parent_id = p.id AND p.id IN ? AND c.id NOT IN ?`,
+					messageIDs, messageIDs,
+				)
+				if res.Error != nil {
+					return fmt.Errorf("reparent children: %w", res.Error)
+				}
+				if res.RowsAffected == 0 {
+					break
+				}
+			}
+
+			res := tx.Unscoped().Where("id IN ?", messageIDs).Delete(&model.Message{})
+			if res.Error != nil {
+				return fmt.Errorf("purge messages: %w", res.Error)
+			}
+			result.Messages = res.RowsAffected
+			purged = append(purged, messages...)
+		}
+
+		if len(sessionIDs) > 0 {
+			res := tx.Unscoped().Where("id IN ?", sessionIDs).Delete(&model.Session{})
+			if res.Error != nil {
+				return fmt.Errorf("purge sessions: %w", res.Error)
+			}
+			result.Sessions = res.RowsAffected
+			result.Messages += sessionMessages
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.deleteArchiveObjects(ctx, archiveKeys)
+
+	// Release asset references per project. Part-level assets are read before the
+	// decrement, because dropping the last envelope reference removes it from S3.
+	byProject := make(map[uuid.UUID][]model.Asset)
+	for _, m := range append(append(purged, revisionAssets...), archiveAssets...) {
+		if a := m.PartsAssetMeta.Data(); a.SHA256 != "" {
+			byProject[m.ProjectID] = append(byProject[m.ProjectID], a)
+		}
+	}
+	for projectID, partsAssets := range byProject {
+		assets := append(partsAssets, r.collectPartLevelAssets(ctx, partsAssets, nil)...)
+		if err := r.assetReferenceRepo.BatchDecrementAssetRefs(ctx, projectID, assets); err != nil {
+			return result, fmt.Errorf("decrement asset references: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// CopySession creates a complete copy of a session with all its messages and tasks.
+// Uses SELECT FOR UPDATE to lock the session during the copy operation.
+// Returns CopySessionResult containing old and new session IDs.
+//
+// The operation is split into two phases to keep the lock window small:
+//  1. Transaction: lock session, create new session/messages/tasks, increment partsAsset refs.
+//  2. Post-transaction: download S3 parts to discover per-part assets, increment those refs.
 func (r *sessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID, userKEK []byte) (*CopySessionResult, error) {
 	var result CopySessionResult
 	result.OldSessionID = sessionID
@@ -462,10 +2384,15 @@ func (r *sessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID, user
 		}
 		projectID = originalSession.ProjectID
 
-		// Get all messages from original session (ordered by created_at to preserve parent relationships)
+		// Get all messages from original session (ordered by seq to preserve parent relationships);
+		// a shallow clone's copy holds the messages it shares too.
+		scope, err := sessionMessages(tx, sessionID)
+		if err != nil {
+			return err
+		}
 		var originalMessages []model.Message
-		if err := tx.Where("session_id = ?", sessionID).
-			Order("created_at ASC, id ASC").
+		if err := scope.
+			Order("seq ASC, id ASC").
 			Find(&originalMessages).Error; err != nil {
 			return fmt.Errorf("failed to get messages: %w", err)
 		}
@@ -481,6 +2408,10 @@ func (r *sessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID, user
 			UserID:              originalSession.UserID,
 			DisableTaskTracking: originalSession.DisableTaskTracking,
 			Configs:             originalSession.Configs,
+			Metadata:            originalSession.Metadata,
+			Tags:                originalSession.Tags,
+			AllowedPartTypes:    originalSession.AllowedPartTypes,
+			LastMessageSeq:      int64(len(originalMessages)),
 		}
 		if err := tx.Create(&newSession).Error; err != nil {
 			return fmt.Errorf("failed to create new session: %w", err)
@@ -490,18 +2421,28 @@ func (r *sessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID, user
 		// Pre-assign new IDs so we can build the parent-ID mapping before inserting.
 		oldToNewMessageID := make(map[uuid.UUID]uuid.UUID, len(originalMessages))
 		for _, oldMsg := range originalMessages {
-			oldToNewMessageID[oldMsg.ID] = uuid.New()
+			oldToNewMessageID[oldMsg.ID] = model.NewMessageID()
 		}
 
 		// Build the new messages slice with remapped parent IDs.
 		newMessages := make([]model.Message, 0, len(originalMessages))
-		for _, oldMsg := range originalMessages {
+		for i, oldMsg := range originalMessages {
 			newMsg := model.Message{
 				ID:                       oldToNewMessageID[oldMsg.ID],
 				SessionID:                newSession.ID,
+				Seq:                      int64(i + 1),
 				Role:                     oldMsg.Role,
+				AuthorID:                 oldMsg.AuthorID,
+				AuthorType:               oldMsg.AuthorType,
 				PartsAssetMeta:           oldMsg.PartsAssetMeta,
 				Meta:                     oldMsg.Meta,
+				SearchText:               oldMsg.SearchText,
+				TokenCount:               oldMsg.TokenCount,
+				TokenEncoding:            oldMsg.TokenEncoding,
+				StorageBytes:             oldMsg.StorageBytes,
+				Pinned:                   oldMsg.Pinned,
+				Flagged:                  oldMsg.Flagged,
+				FlagReason:               oldMsg.FlagReason,
 				SessionTaskProcessStatus: "pending",
 				TaskID:                   nil,
 			}
@@ -531,6 +2472,12 @@ func (r *sessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID, user
 				return fmt.Errorf("failed to create messages: %w", err)
 			}
 		}
+		if err := addSessionBytes(tx, newSession.ID, sumStorageBytes(newMessages)); err != nil {
+			return fmt.Errorf("failed to record session size: %w", err)
+		}
+		if err := addSessionMessages(tx, newSession.ID, len(newMessages), newestCreatedAt(newMessages)); err != nil {
+			return fmt.Errorf("failed to record message count: %w", err)
+		}
 
 		// Copy tasks
 		var originalTasks []model.Task
@@ -599,29 +2546,167 @@ func (r *sessionRepo) CopySession(ctx context.Context, sessionID uuid.UUID, user
 	// Phase 2 (post-transaction): download S3 parts to discover per-part assets and
 	// increment their refs. This is done outside the transaction to avoid holding the
 	// session lock during N network round-trips.
-	if r.s3 != nil && len(partsAssets) > 0 {
-		var partLevelAssets []model.Asset
-		for _, partsAsset := range partsAssets {
-			if partsAsset.S3Key == "" {
-				continue
+	if err := r.incrementPartLevelAssetRefs(ctx, projectID, partsAssets, userKEK); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// collectPartLevelAssets downloads each parts envelope from S3 and returns the assets
+// attached to individual parts. Envelopes that cannot be read are logged and skipped.
+func (r *sessionRepo) collectPartLevelAssets(ctx context.Context, partsAssets []model.Asset, userKEK []byte) []model.Asset {
+	if r.s3 == nil {
+		return nil
+	}
+	var partLevelAssets []model.Asset
+	for _, partsAsset := range partsAssets {
+		if partsAsset.S3Key == "" {
+			continue
+		}
+		parts := []model.Part{}
+		if err := r.s3.DownloadJSON(ctx, partsAsset.S3Key, &parts, userKEK); err != nil {
+			r.log.Warn("failed to download parts for asset extraction",
+				zap.Error(err), zap.String("s3_key", partsAsset.S3Key))
+			continue
+		}
+		for _, part := range parts {
+			if part.Asset != nil && part.Asset.SHA256 != "" {
+				partLevelAssets = append(partLevelAssets, *part.Asset)
 			}
-			parts := []model.Part{}
-			if err := r.s3.DownloadJSON(ctx, partsAsset.S3Key, &parts, userKEK); err != nil {
-				r.log.Warn("failed to download parts for asset extraction",
-					zap.Error(err), zap.String("s3_key", partsAsset.S3Key))
+		}
+	}
+	return partLevelAssets
+}
+
+// incrementPartLevelAssetRefs increments the reference count of every asset attached
+// to an individual part of the given parts envelopes.
+func (r *sessionRepo) incrementPartLevelAssetRefs(ctx context.Context, projectID uuid.UUID, partsAssets []model.Asset, userKEK []byte) error {
+	partLevelAssets := r.collectPartLevelAssets(ctx, partsAssets, userKEK)
+	if len(partLevelAssets) > 0 {
+		if err := r.assetReferenceRepo.BatchIncrementAssetRefs(ctx, projectID, partLevelAssets); err != nil {
+			return fmt.Errorf("failed to increment part-level asset references: %w", err)
+		}
+	}
+	return nil
+}
+
+// ForkSession creates a new session containing the ancestor chain of fromMessageID,
+// from the root message down to and including fromMessageID. Copied messages get
+// fresh IDs with parent links remapped; parts envelopes and part-level assets are
+// shared with the source session and only their reference counts are incremented.
+// Returns ErrMessageNotInSession if fromMessageID does not belong to sessionID.
+func (r *sessionRepo) ForkSession(ctx context.Context, sessionID uuid.UUID, fromMessageID uuid.UUID, userKEK []byte) (*ForkSessionResult, error) {
+	result := ForkSessionResult{OldSessionID: sessionID}
+
+	var partsAssets []model.Asset
+	var projectID uuid.UUID
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var originalSession model.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", sessionID).
+			First(&originalSession).Error; err != nil {
+			return fmt.Errorf("failed to get session: %w", err)
+		}
+		projectID = originalSession.ProjectID
+
+		chain, err := messageThread(tx, sessionID, fromMessageID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMessageNotInSession
+			}
+			return fmt.Errorf("failed to get ancestor chain: %w", err)
+		}
+		if chain[len(chain)-1].DeletedAt.Valid {
+			return ErrMessageNotInSession
+		}
+
+		if len(chain) > MaxCopyableMessages {
+			return fmt.Errorf("%w (%d messages)", ErrSessionTooLarge, len(chain))
+		}
+
+		newSession := model.Session{
+			ProjectID:           originalSession.ProjectID,
+			UserID:              originalSession.UserID,
+			DisableTaskTracking: originalSession.DisableTaskTracking,
+			Configs:             originalSession.Configs,
+			Metadata:            originalSession.Metadata,
+			Tags:                originalSession.Tags,
+			AllowedPartTypes:    originalSession.AllowedPartTypes,
+		}
+		if err := tx.Create(&newSession).Error; err != nil {
+			return fmt.Errorf("failed to create new session: %w", err)
+		}
+		result.NewSessionID = newSession.ID
+
+		result.MessageIDMap = make(map[uuid.UUID]uuid.UUID, len(chain))
+		newMessages := make([]model.Message, 0, len(chain))
+		var prevID *uuid.UUID
+		for _, oldMsg := range chain {
+			// Soft-deleted ancestors are left out; their children link to the previous survivor.
+			if oldMsg.DeletedAt.Valid {
 				continue
 			}
-			for _, part := range parts {
-				if part.Asset != nil && part.Asset.SHA256 != "" {
-					partLevelAssets = append(partLevelAssets, *part.Asset)
-				}
+			newID := model.NewMessageID()
+			result.MessageIDMap[oldMsg.ID] = newID
+			newMessages = append(newMessages, model.Message{
+				ID:                       newID,
+				SessionID:                newSession.ID,
+				Seq:                      int64(len(newMessages) + 1),
+				ParentID:                 prevID,
+				Role:                     oldMsg.Role,
+				AuthorID:                 oldMsg.AuthorID,
+				AuthorType:               oldMsg.AuthorType,
+				PartsAssetMeta:           oldMsg.PartsAssetMeta,
+				Meta:                     oldMsg.Meta,
+				SearchText:               oldMsg.SearchText,
+				TokenCount:               oldMsg.TokenCount,
+				TokenEncoding:            oldMsg.TokenEncoding,
+				StorageBytes:             oldMsg.StorageBytes,
+				Pinned:                   oldMsg.Pinned,
+				Flagged:                  oldMsg.Flagged,
+				FlagReason:               oldMsg.FlagReason,
+				SessionTaskProcessStatus: model.MessageStatusPending,
+				// Keep the original timestamps so the fork lists in the same order.
+				CreatedAt: oldMsg.CreatedAt,
+			})
+			prevID = &newMessages[len(newMessages)-1].ID
+
+			partsAsset := oldMsg.PartsAssetMeta.Data()
+			if partsAsset.SHA256 != "" {
+				partsAssets = append(partsAssets, partsAsset)
 			}
 		}
-		if len(partLevelAssets) > 0 {
-			if err := r.assetReferenceRepo.BatchIncrementAssetRefs(ctx, projectID, partLevelAssets); err != nil {
-				return nil, fmt.Errorf("failed to increment part-level asset references: %w", err)
+
+		if err := tx.CreateInBatches(newMessages, 100).Error; err != nil {
+			return fmt.Errorf("failed to create messages: %w", err)
+		}
+		if err := tx.Model(&newSession).UpdateColumn("last_message_seq", len(newMessages)).Error; err != nil {
+			return fmt.Errorf("failed to record message seq: %w", err)
+		}
+		if err := addSessionBytes(tx, newSession.ID, sumStorageBytes(newMessages)); err != nil {
+			return fmt.Errorf("failed to record session size: %w", err)
+		}
+		if err := addSessionMessages(tx, newSession.ID, len(newMessages), newestCreatedAt(newMessages)); err != nil {
+			return fmt.Errorf("failed to record message count: %w", err)
+		}
+
+		if len(partsAssets) > 0 {
+			txAssetRepo := NewAssetReferenceRepo(tx, r.s3)
+			if err := txAssetRepo.BatchIncrementAssetRefs(ctx, projectID, partsAssets); err != nil {
+				return fmt.Errorf("failed to increment asset references: %w", err)
 			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.incrementPartLevelAssetRefs(ctx, projectID, partsAssets, userKEK); err != nil {
+		return nil, err
 	}
 
 	return &result, nil
@@ -644,3 +2729,14 @@ func (r *sessionRepo) HasFailedMessages(ctx context.Context, sessionID uuid.UUID
 	).Scan(&exists).Error
 	return exists, err
 }
+
+// OldestMessageCreatedAt returns the creation time of the oldest live message the session owns,
+// or nil when it owns none. Messages it shares with its base session are not counted.
+func (r *sessionRepo) OldestMessageCreatedAt(ctx context.Context, sessionID uuid.UUID) (*time.Time, error) {
+	var oldest *time.Time
+	err := r.db.WithContext(ctx).Raw(
+		"SELECT MIN(created_at) FROM messages WHERE session_id = ? AND deleted_at IS NULL",
+		sessionID,
+	).Scan(&oldest).Error
+	return oldest, err
+}
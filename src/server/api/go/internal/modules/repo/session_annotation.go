@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AnnotationFilter selects listed messages by their annotations. An empty Kind matches every
+// message; otherwise a message needs an annotation of that kind and, with a non-empty Value, of
+// that value too.
+type AnnotationFilter struct {
+	Kind  string
+	Value string
+}
+
+// where adds the annotation predicate to q.
+func (af AnnotationFilter) where(q *gorm.DB) *gorm.DB {
+	if af.Kind == "" {
+		return q
+	}
+	if af.Value == "" {
+		return q.Where("EXISTS (SELECT 1 FROM message_annotations a WHERE a.message_id = messages.id AND a.kind = ?)", af.Kind)
+	}
+	return q.Where("EXISTS (SELECT 1 FROM message_annotations a WHERE a.message_id = messages.id AND a.kind = ? AND a.value = ?)", af.Kind, af.Value)
+}
+
+// CreateMessageAnnotation adds the annotation to a message of the session. The message row is
+// share-locked so it cannot be hard-deleted underneath. Returns gorm.ErrRecordNotFound if the
+// message is not in the session.
+func (r *sessionRepo) CreateMessageAnnotation(ctx context.Context, sessionID uuid.UUID, a *model.MessageAnnotation) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var msg model.Message
+		if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Select("id").
+			Where("id = ? AND session_id = ?", a.MessageID, sessionID).
+			First(&msg).Error; err != nil {
+			return err
+		}
+		return tx.Create(a).Error
+	})
+}
+
+// ListMessageAnnotations returns the annotations of a message in the session, oldest first.
+func (r *sessionRepo) ListMessageAnnotations(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageAnnotation, error) {
+	annotations := []model.MessageAnnotation{}
+	err := r.db.WithContext(ctx).
+		Joins("JOIN messages ON messages.id = message_annotations.message_id").
+		Where("message_annotations.message_id = ? AND messages.session_id = ?", messageID, sessionID).
+		Order("message_annotations.created_at ASC, message_annotations.id ASC").
+		Find(&annotations).Error
+	return annotations, err
+}
+
+// DeleteMessageAnnotation deletes an annotation of a message in the session. Returns
+// gorm.ErrRecordNotFound if there is no such annotation.
+func (r *sessionRepo) DeleteMessageAnnotation(ctx context.Context, sessionID uuid.UUID, messageID uuid.UUID, annotationID uuid.UUID) error {
+	res := r.db.WithContext(ctx).
+		Where("id = ? AND message_id = ? AND message_id IN (SELECT id FROM messages WHERE session_id = ?)", annotationID, messageID, sessionID).
+		Delete(&model.MessageAnnotation{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountSessionReactions returns the number of reaction annotations of each value on the
+// session's live messages. Reactions no message carries are left out.
+func (r *sessionRepo) CountSessionReactions(ctx context.Context, sessionID uuid.UUID) (map[string]int64, error) {
+	var rows []struct {
+		Value string
+		Count int64
+	}
+	err := r.db.WithContext(ctx).Model(&model.MessageAnnotation{}).
+		Select("message_annotations.value, COUNT(*) AS count").
+		Joins("JOIN messages ON messages.id = message_annotations.message_id").
+		Where("messages.session_id = ? AND messages.deleted_at IS NULL AND message_annotations.kind = ?", sessionID, model.AnnotationKindReaction).
+		Group("message_annotations.value").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Value] = row.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func TestSessionRepo_MessageAnnotations(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_annotation",
+		SecretKeyHashPHC: "test_hash_annotation",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}, &model.MessageAnnotation{}))
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	root := uuid.New()
+	msgs := []model.Message{
+		{ID: root, Role: model.RoleUser, PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+		{ParentID: &root, Role: model.RoleAssistant, PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+	}
+	require.NoError(t, r.CreateMessagesBatch(ctx, ss.ID, msgs))
+	reply := msgs[1].ID
+
+	reviewer := uuid.New()
+	up := &model.MessageAnnotation{MessageID: reply, Kind: model.AnnotationKindReaction, Value: model.ReactionThumbsUp, AuthorID: &reviewer, AuthorType: model.AuthorTypeUser}
+	for _, a := range []*model.MessageAnnotation{
+		up,
+		{MessageID: reply, Kind: model.AnnotationKindReaction, Value: model.ReactionThumbsUp},
+		{MessageID: root, Kind: model.AnnotationKindReaction, Value: model.ReactionThumbsDown},
+		{MessageID: reply, Kind: model.AnnotationKindLabel, Value: "hallucination", Note: "cites a missing doc"},
+	} {
+		require.NoError(t, r.CreateMessageAnnotation(ctx, ss.ID, a))
+		assert.NotEqual(t, uuid.Nil, a.ID)
+	}
+
+	t.Run("message must be in the session", func(t *testing.T) {
+		err := r.CreateMessageAnnotation(ctx, uuid.New(), &model.MessageAnnotation{MessageID: reply, Kind: model.AnnotationKindNote, Note: "x"})
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("list oldest first", func(t *testing.T) {
+		annotations, err := r.ListMessageAnnotations(ctx, ss.ID, reply)
+		require.NoError(t, err)
+		require.Len(t, annotations, 3)
+		assert.Equal(t, up.ID, annotations[0].ID)
+		assert.Equal(t, &reviewer, annotations[0].AuthorID)
+
+		annotations, err = r.ListMessageAnnotations(ctx, uuid.New(), reply)
+		require.NoError(t, err)
+		assert.Empty(t, annotations)
+	})
+
+	t.Run("filter messages by annotation", func(t *testing.T) {
+		labeled, err := r.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{Kind: model.AnnotationKindLabel})
+		require.NoError(t, err)
+		require.Len(t, labeled, 1)
+		assert.Equal(t, reply, labeled[0].ID)
+
+		down, err := r.ListBySessionWithCursor(ctx, ss.ID, 0, uuid.Nil, 10, false, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{Kind: model.AnnotationKindReaction, Value: model.ReactionThumbsDown})
+		require.NoError(t, err)
+		require.Len(t, down, 1)
+		assert.Equal(t, root, down[0].ID)
+
+		reacted, err := r.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{Kind: model.AnnotationKindReaction})
+		require.NoError(t, err)
+		assert.Len(t, reacted, 2)
+	})
+
+	t.Run("count reactions", func(t *testing.T) {
+		counts, err := r.CountSessionReactions(ctx, ss.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{model.ReactionThumbsUp: 2, model.ReactionThumbsDown: 1}, counts)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.ErrorIs(t, r.DeleteMessageAnnotation(ctx, ss.ID, root, up.ID), gorm.ErrRecordNotFound, "annotation of another message")
+		require.NoError(t, r.DeleteMessageAnnotation(ctx, ss.ID, reply, up.ID))
+		assert.ErrorIs(t, r.DeleteMessageAnnotation(ctx, ss.ID, reply, up.ID), gorm.ErrRecordNotFound)
+
+		counts, err := r.CountSessionReactions(ctx, ss.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), counts[model.ReactionThumbsUp])
+	})
+
+	t.Run("deleted messages are not counted", func(t *testing.T) {
+		require.NoError(t, r.DeleteMessage(ctx, ss.ID, root))
+		counts, err := r.CountSessionReactions(ctx, ss.ID)
+		require.NoError(t, err)
+		assert.Zero(t, counts[model.ReactionThumbsDown])
+	})
+}
@@ -0,0 +1,408 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionArchiveVersion is the format version written into session archives.
+const SessionArchiveVersion = 1
+
+// archiveRestoreBatchSize bounds the rows inserted per statement when an archive is restored.
+const archiveRestoreBatchSize = 200
+
+// ErrSessionArchived is returned when archiving, or adding messages to, an archived session.
+var ErrSessionArchived = errors.New("session is archived")
+
+// ErrSessionNotArchived is returned when restoring a session that is not archived.
+var ErrSessionNotArchived = errors.New("session is not archived")
+
+// SessionArchive is the snapshot of a session's rows written to cold storage: every message,
+// soft-deleted ones included, with the columns the API does not expose, their revisions, flag
+// audits and annotations. Embeddings and part enrichments are not archived.
+type SessionArchive struct {
+	Version     int                       `json:"version"`
+	ProjectID   uuid.UUID                 `json:"project_id"`
+	SessionID   uuid.UUID                 `json:"session_id"`
+	ArchivedAt  time.Time                 `json:"archived_at"`
+	Messages    []ArchivedMessage         `json:"messages"`
+	Revisions   []ArchivedRevision        `json:"revisions,omitempty"`
+	FlagAudits  []model.MessageFlagAudit  `json:"flag_audits,omitempty"`
+	Annotations []model.MessageAnnotation `json:"annotations,omitempty"`
+
+	// Assets is the manifest of the objects the archive refers to: the parts objects of its
+	// messages and revisions, then the assets their parts point at. None are copied into it.
+	Assets []model.Asset `json:"assets"`
+}
+
+// ArchivedMessage is a message row as stored in a SessionArchive.
+type ArchivedMessage struct {
+	ID                       uuid.UUID      `json:"id"`
+	ParentID                 *uuid.UUID     `json:"parent_id,omitempty"`
+	Seq                      int64          `json:"seq"`
+	Role                     string         `json:"role"`
+	AuthorID                 *uuid.UUID     `json:"author_id,omitempty"`
+	AuthorType               string         `json:"author_type,omitempty"`
+	Meta                     map[string]any `json:"meta"`
+	PartsAssetMeta           model.Asset    `json:"parts_asset_meta"`
+	SearchText               string         `json:"search_text,omitempty"`
+	TokenCount               int            `json:"token_count"`
+	TokenEncoding            string         `json:"token_encoding,omitempty"`
+	StorageBytes             int64          `json:"storage_bytes"`
+	Version                  int            `json:"version"`
+	Pinned                   bool           `json:"pinned,omitempty"`
+	Flagged                  bool           `json:"flagged,omitempty"`
+	FlagReason               string         `json:"flag_reason,omitempty"`
+	IdempotencyKey           *string        `json:"idempotency_key,omitempty"`
+	TaskID                   *uuid.UUID     `json:"task_id,omitempty"`
+	SessionTaskProcessStatus string         `json:"session_task_process_status"`
+	CreatedAt                time.Time      `json:"created_at"`
+	UpdatedAt                time.Time      `json:"updated_at"`
+	DeletedAt                *time.Time     `json:"deleted_at,omitempty"`
+}
+
+// ArchivedRevision is a message revision row as stored in a SessionArchive.
+type ArchivedRevision struct {
+	ID             uuid.UUID   `json:"id"`
+	MessageID      uuid.UUID   `json:"message_id"`
+	Version        int         `json:"version"`
+	PartsAssetMeta model.Asset `json:"parts_asset_meta"`
+	TokenCount     int         `json:"token_count"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+func archiveMessage(m model.Message) ArchivedMessage {
+	am := ArchivedMessage{
+		ID:                       m.ID,
+		ParentID:                 m.ParentID,
+		Seq:                      m.Seq,
+		Role:                     m.Role,
+		AuthorID:                 m.AuthorID,
+		AuthorType:               m.AuthorType,
+		Meta:                     m.Meta.Data(),
+		PartsAssetMeta:           m.PartsAssetMeta.Data(),
+		SearchText:               m.SearchText,
+		TokenCount:               m.TokenCount,
+		TokenEncoding:            m.TokenEncoding,
+		StorageBytes:             m.StorageBytes,
+		Version:                  m.Version,
+		Pinned:                   m.Pinned,
+		Flagged:                  m.Flagged,
+		FlagReason:               m.FlagReason,
+		IdempotencyKey:           m.IdempotencyKey,
+		TaskID:                   m.TaskID,
+		SessionTaskProcessStatus: m.SessionTaskProcessStatus,
+		CreatedAt:                m.CreatedAt,
+		UpdatedAt:                m.UpdatedAt,
+	}
+	if m.DeletedAt.Valid {
+		deletedAt := m.DeletedAt.Time
+		am.DeletedAt = &deletedAt
+	}
+	return am
+}
+
+func (am ArchivedMessage) message(sessionID uuid.UUID) model.Message {
+	meta := am.Meta
+	if meta == nil {
+		meta = map[string]any{}
+	}
+	m := model.Message{
+		ID:                       am.ID,
+		SessionID:                sessionID,
+		ParentID:                 am.ParentID,
+		Seq:                      am.Seq,
+		Role:                     am.Role,
+		AuthorID:                 am.AuthorID,
+		AuthorType:               am.AuthorType,
+		Meta:                     datatypes.NewJSONType(meta),
+		PartsAssetMeta:           datatypes.NewJSONType(am.PartsAssetMeta),
+		SearchText:               am.SearchText,
+		TokenCount:               am.TokenCount,
+		TokenEncoding:            am.TokenEncoding,
+		StorageBytes:             am.StorageBytes,
+		Version:                  am.Version,
+		Pinned:                   am.Pinned,
+		Flagged:                  am.Flagged,
+		FlagReason:               am.FlagReason,
+		IdempotencyKey:           am.IdempotencyKey,
+		TaskID:                   am.TaskID,
+		SessionTaskProcessStatus: am.SessionTaskProcessStatus,
+		CreatedAt:                am.CreatedAt,
+		UpdatedAt:                am.UpdatedAt,
+	}
+	if am.DeletedAt != nil {
+		m.DeletedAt = gorm.DeletedAt{Time: *am.DeletedAt, Valid: true}
+	}
+	return m
+}
+
+// parentsFirst orders msgs so that every message comes after its parent, keeping the input
+// order otherwise, so they can be inserted in batches without breaking the parent_id foreign
+// key. Messages caught in a parent cycle are appended last.
+func parentsFirst(msgs []model.Message) []model.Message {
+	pending := make(map[uuid.UUID]bool, len(msgs))
+	for _, m := range msgs {
+		pending[m.ID] = true
+	}
+	ordered := make([]model.Message, 0, len(msgs))
+	rest := msgs
+	for len(rest) > 0 {
+		var next []model.Message
+		for _, m := range rest {
+			if m.ParentID != nil && pending[*m.ParentID] {
+				next = append(next, m)
+				continue
+			}
+			ordered = append(ordered, m)
+			delete(pending, m.ID)
+		}
+		if len(next) == len(rest) {
+			return append(ordered, next...)
+		}
+		rest = next
+	}
+	return ordered
+}
+
+// RestoreSessionResult reports what RestoreSession brought back.
+type RestoreSessionResult struct {
+	Session model.Session
+	// ArchiveKey is the archive object the session was restored from; it is no longer needed.
+	ArchiveKey string
+	// Messages counts the message rows inserted from the archive; zero unless it was purged.
+	Messages int
+}
+
+// ArchiveSession snapshots the session's rows and hands the snapshot to store, which writes it
+// to key, then marks the session archived. With purge, the message rows are deleted as well,
+// taking their revisions, flag audits, annotations, embeddings and enrichment jobs with them
+// through ON DELETE CASCADE. Their asset references are kept: the archive holds them from then
+// on, and the parts objects are recorded in the session's ArchiveAssets so a later hard delete
+// still releases them. The session stays locked throughout, so no message is written between the
+// snapshot and the purge. Sessions with a message still streaming cannot be archived.
+func (r *sessionRepo) ArchiveSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, key string, purge bool, userKEK []byte, store func(arc *SessionArchive) error) (*model.Session, error) {
+	var session model.Session
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND project_id = ?", sessionID, projectID).
+			First(&session).Error; err != nil {
+			return err
+		}
+		if session.Archived {
+			return ErrSessionArchived
+		}
+		if purge {
+			shared, err := sessionShared(tx, sessionID)
+			if err != nil {
+				return fmt.Errorf("check shallow clones: %w", err)
+			}
+			if shared {
+				return ErrSessionShared
+			}
+		}
+
+		var msgs []model.Message
+		if err := tx.Unscoped().Where("session_id = ?", sessionID).
+			Order("seq ASC, id ASC").
+			Find(&msgs).Error; err != nil {
+			return fmt.Errorf("query session messages: %w", err)
+		}
+		arc := &SessionArchive{
+			Version:    SessionArchiveVersion,
+			ProjectID:  projectID,
+			SessionID:  sessionID,
+			ArchivedAt: time.Now(),
+			Messages:   make([]ArchivedMessage, 0, len(msgs)),
+		}
+		var partsAssets []model.Asset
+		ids := make([]uuid.UUID, 0, len(msgs))
+		for _, m := range msgs {
+			if m.Streaming && !m.DeletedAt.Valid {
+				return ErrMessageStreaming
+			}
+			ids = append(ids, m.ID)
+			arc.Messages = append(arc.Messages, archiveMessage(m))
+			if a := m.PartsAssetMeta.Data(); a.SHA256 != "" {
+				partsAssets = append(partsAssets, a)
+			}
+		}
+
+		if len(ids) > 0 {
+			var revisions []model.MessageRevision
+			if err := tx.Where("message_id IN ?", ids).
+				Order("message_id ASC, version ASC").
+				Find(&revisions).Error; err != nil {
+				return fmt.Errorf("query message revisions: %w", err)
+			}
+			for _, rev := range revisions {
+				a := rev.PartsAssetMeta.Data()
+				arc.Revisions = append(arc.Revisions, ArchivedRevision{
+					ID:             rev.ID,
+					MessageID:      rev.MessageID,
+					Version:        rev.Version,
+					PartsAssetMeta: a,
+					TokenCount:     rev.TokenCount,
+					CreatedAt:      rev.CreatedAt,
+				})
+				if a.SHA256 != "" {
+					partsAssets = append(partsAssets, a)
+				}
+			}
+			if err := tx.Where("message_id IN ?", ids).
+				Order("created_at ASC, id ASC").
+				Find(&arc.FlagAudits).Error; err != nil {
+				return fmt.Errorf("query message flag audits: %w", err)
+			}
+			if err := tx.Where("message_id IN ?", ids).
+				Order("created_at ASC, id ASC").
+				Find(&arc.Annotations).Error; err != nil {
+				return fmt.Errorf("query message annotations: %w", err)
+			}
+		}
+		arc.Assets = append(append([]model.Asset{}, partsAssets...), r.collectPartLevelAssets(ctx, partsAssets, userKEK)...)
+
+		if err := store(arc); err != nil {
+			return fmt.Errorf("store session archive: %w", err)
+		}
+
+		if purge && len(ids) > 0 {
+			if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&model.Message{}).Error; err != nil {
+				return fmt.Errorf("purge archived messages: %w", err)
+			}
+		}
+		archivedAt := arc.ArchivedAt
+		session.Archived = true
+		session.ArchivedAt = &archivedAt
+		session.ArchiveKey = key
+		session.ArchivePurged = purge
+		session.ArchiveAssets = datatypes.NewJSONSlice([]model.Asset{})
+		if purge {
+			session.ArchiveAssets = datatypes.NewJSONSlice(partsAssets)
+		}
+		return tx.Model(&session).
+			Select("archived", "archived_at", "archive_key", "archive_purged", "archive_assets").
+			Updates(&session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RestoreSession clears the session's archived state. When its rows were purged, load reads
+// the archive from the given key and the messages, revisions, flag audits and annotations are
+// inserted again with their original IDs; the asset references the archive held pass back to
+// them.
+func (r *sessionRepo) RestoreSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, load func(key string) (*SessionArchive, error)) (*RestoreSessionResult, error) {
+	result := &RestoreSessionResult{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		session := &result.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND project_id = ?", sessionID, projectID).
+			First(session).Error; err != nil {
+			return err
+		}
+		if !session.Archived {
+			return ErrSessionNotArchived
+		}
+		result.ArchiveKey = session.ArchiveKey
+
+		if session.ArchivePurged {
+			arc, err := load(session.ArchiveKey)
+			if err != nil {
+				return fmt.Errorf("load session archive: %w", err)
+			}
+			if arc.SessionID != sessionID {
+				return fmt.Errorf("archive %s belongs to session %s", session.ArchiveKey, arc.SessionID)
+			}
+			if err := restoreArchivedRows(tx, sessionID, arc); err != nil {
+				return err
+			}
+			result.Messages = len(arc.Messages)
+		}
+
+		session.Archived = false
+		session.ArchivedAt = nil
+		session.ArchiveKey = ""
+		session.ArchivePurged = false
+		session.ArchiveAssets = datatypes.NewJSONSlice([]model.Asset{})
+		return tx.Model(session).
+			Select("archived", "archived_at", "archive_key", "archive_purged", "archive_assets").
+			Updates(session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// restoreArchivedRows inserts the rows of arc into the session.
+func restoreArchivedRows(tx *gorm.DB, sessionID uuid.UUID, arc *SessionArchive) error {
+	if len(arc.Messages) == 0 {
+		return nil
+	}
+	msgs := make([]model.Message, 0, len(arc.Messages))
+	for _, am := range arc.Messages {
+		msgs = append(msgs, am.message(sessionID))
+	}
+	msgs = parentsFirst(msgs)
+	if err := tx.CreateInBatches(&msgs, archiveRestoreBatchSize).Error; err != nil {
+		return fmt.Errorf("restore messages: %w", err)
+	}
+
+	if len(arc.Revisions) > 0 {
+		revisions := make([]model.MessageRevision, 0, len(arc.Revisions))
+		for _, ar := range arc.Revisions {
+			revisions = append(revisions, model.MessageRevision{
+				ID:             ar.ID,
+				MessageID:      ar.MessageID,
+				Version:        ar.Version,
+				PartsAssetMeta: datatypes.NewJSONType(ar.PartsAssetMeta),
+				TokenCount:     ar.TokenCount,
+				CreatedAt:      ar.CreatedAt,
+			})
+		}
+		if err := tx.CreateInBatches(&revisions, archiveRestoreBatchSize).Error; err != nil {
+			return fmt.Errorf("restore message revisions: %w", err)
+		}
+	}
+	if len(arc.FlagAudits) > 0 {
+		audits := append([]model.MessageFlagAudit{}, arc.FlagAudits...)
+		if err := tx.CreateInBatches(&audits, archiveRestoreBatchSize).Error; err != nil {
+			return fmt.Errorf("restore message flag audits: %w", err)
+		}
+	}
+	if len(arc.Annotations) > 0 {
+		annotations := append([]model.MessageAnnotation{}, arc.Annotations...)
+		if err := tx.CreateInBatches(&annotations, archiveRestoreBatchSize).Error; err != nil {
+			return fmt.Errorf("restore message annotations: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteArchiveObjects deletes the archive objects of hard-deleted sessions. Failures are only
+// logged: the sessions are gone, so a left-over archive is unreachable but harmless.
+func (r *sessionRepo) deleteArchiveObjects(ctx context.Context, keys []string) {
+	if r.s3 == nil {
+		return
+	}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := r.s3.DeleteObject(ctx, key); err != nil {
+			r.log.Warn("failed to delete session archive", zap.Error(err), zap.String("s3_key", key))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func TestParentsFirst(t *testing.T) {
+	root, mid, leaf, other := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	msgs := []model.Message{
+		{ID: leaf, ParentID: &mid},
+		{ID: other, ParentID: &root},
+		{ID: mid, ParentID: &root},
+		{ID: root},
+	}
+	var got []uuid.UUID
+	for _, m := range parentsFirst(msgs) {
+		got = append(got, m.ID)
+	}
+	assert.Equal(t, []uuid.UUID{root, other, mid, leaf}, got)
+
+	// A cycle cannot be ordered; its messages are kept rather than dropped.
+	a, b := uuid.New(), uuid.New()
+	cyclic := parentsFirst([]model.Message{{ID: a, ParentID: &b}, {ID: b, ParentID: &a}, {ID: root}})
+	require.Len(t, cyclic, 3)
+	assert.Equal(t, root, cyclic[0].ID)
+}
+
+func TestArchivedMessage_RoundTrip(t *testing.T) {
+	parent := uuid.New()
+	key := "retry-1"
+	deletedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := model.Message{
+		ID:             uuid.New(),
+		SessionID:      uuid.New(),
+		ParentID:       &parent,
+		Seq:            7,
+		Role:           model.RoleAssistant,
+		Meta:           datatypes.NewJSONType(map[string]any{"source": "web"}),
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "sha", S3Key: "parts/p/sha.json"}),
+		SearchText:     "hello",
+		TokenCount:     3,
+		StorageBytes:   120,
+		Version:        2,
+		Pinned:         true,
+		IdempotencyKey: &key,
+		DeletedAt:      gorm.DeletedAt{Time: deletedAt, Valid: true},
+	}
+
+	target := uuid.New()
+	back := archiveMessage(m).message(target)
+	assert.Equal(t, target, back.SessionID)
+	back.SessionID = m.SessionID
+	assert.Equal(t, m, back)
+
+	empty := ArchivedMessage{ID: uuid.New()}.message(target)
+	assert.NotNil(t, empty.Meta.Data(), "a missing meta restores as an empty object")
+	assert.False(t, empty.DeletedAt.Valid)
+}
+
+func TestSessionRepo_ArchiveAndRestore(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_archive",
+		SecretKeyHashPHC: "test_hash_archive",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}, &model.MessageRevision{}, &model.MessageFlagAudit{}, &model.MessageAnnotation{}, &model.AssetReference{}))
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	newSession := func() (*model.Session, []model.Message) {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID, LastMessageSeq: 2}
+		require.NoError(t, db.Create(ss).Error)
+		root := model.Message{ID: uuid.New(), SessionID: ss.ID, Seq: 1, Role: model.RoleUser,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "archive-" + uuid.NewString()})}
+		reply := model.Message{ID: uuid.New(), SessionID: ss.ID, Seq: 2, Role: model.RoleAssistant, ParentID: &root.ID, Version: 2,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "archive-" + uuid.NewString()})}
+		require.NoError(t, db.Create(&root).Error)
+		require.NoError(t, db.Create(&reply).Error)
+		require.NoError(t, db.Create(&model.MessageRevision{MessageID: reply.ID, Version: 1,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "archive-" + uuid.NewString()})}).Error)
+		require.NoError(t, db.Create(&model.MessageAnnotation{MessageID: reply.ID, Kind: model.AnnotationKindReaction, Value: model.ReactionThumbsUp}).Error)
+		return ss, []model.Message{root, reply}
+	}
+	listed := func(includeArchived bool) []uuid.UUID {
+		sessions, err := r.ListWithCursor(ctx, project.ID, "", nil, nil, nil, false, includeArchived, time.Time{}, uuid.Nil, 10, false, SessionOrderCreatedAt, TimeRange{})
+		require.NoError(t, err)
+		var out []uuid.UUID
+		for _, s := range sessions {
+			out = append(out, s.ID)
+		}
+		return out
+	}
+
+	t.Run("purge and restore", func(t *testing.T) {
+		ss, msgs := newSession()
+		var stored *SessionArchive
+		archived, err := r.ArchiveSession(ctx, project.ID, ss.ID, "archives/key", true, nil, func(arc *SessionArchive) error {
+			stored = arc
+			return nil
+		})
+		require.NoError(t, err)
+		assert.True(t, archived.Archived)
+		assert.True(t, archived.ArchivePurged)
+		require.NotNil(t, stored)
+		assert.Len(t, stored.Messages, 2)
+		assert.Len(t, stored.Revisions, 1)
+		assert.Len(t, stored.Annotations, 1)
+		assert.Len(t, stored.Assets, 3)
+		assert.Len(t, archived.ArchiveAssets, 3, "the archive holds the references of every parts object")
+
+		var count int64
+		require.NoError(t, db.Unscoped().Model(&model.Message{}).Where("session_id = ?", ss.ID).Count(&count).Error)
+		assert.Zero(t, count)
+		assert.NotContains(t, listed(false), ss.ID)
+		assert.Contains(t, listed(true), ss.ID)
+
+		err = r.CreateMessageWithAssets(ctx, &model.Message{SessionID: ss.ID, Role: model.RoleUser, PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}, 0)
+		assert.ErrorIs(t, err, ErrSessionArchived)
+		_, err = r.ArchiveSession(ctx, project.ID, ss.ID, "archives/key", true, nil, func(*SessionArchive) error { return nil })
+		assert.ErrorIs(t, err, ErrSessionArchived)
+
+		result, err := r.RestoreSession(ctx, project.ID, ss.ID, func(key string) (*SessionArchive, error) {
+			assert.Equal(t, "archives/key", key)
+			return stored, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.Messages)
+		assert.Equal(t, "archives/key", result.ArchiveKey)
+		assert.False(t, result.Session.Archived)
+
+		restored, err := r.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		require.Len(t, restored, 2)
+		assert.Equal(t, msgs[0].ID, restored[0].ID)
+		assert.Equal(t, msgs[1].ID, restored[1].ID)
+		assert.Equal(t, &msgs[0].ID, restored[1].ParentID)
+		revisions, err := r.ListMessageRevisions(ctx, msgs[1].ID)
+		require.NoError(t, err)
+		assert.Len(t, revisions, 1)
+		annotations, err := r.ListMessageAnnotations(ctx, ss.ID, msgs[1].ID)
+		require.NoError(t, err)
+		assert.Len(t, annotations, 1)
+		assert.Contains(t, listed(false), ss.ID)
+
+		_, err = r.RestoreSession(ctx, project.ID, ss.ID, nil)
+		assert.ErrorIs(t, err, ErrSessionNotArchived)
+	})
+
+	t.Run("archive without purge keeps rows", func(t *testing.T) {
+		ss, _ := newSession()
+		_, err := r.ArchiveSession(ctx, project.ID, ss.ID, "archives/key", false, nil, func(*SessionArchive) error { return nil })
+		require.NoError(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&model.Message{}).Where("session_id = ?", ss.ID).Count(&count).Error)
+		assert.Equal(t, int64(2), count)
+
+		result, err := r.RestoreSession(ctx, project.ID, ss.ID, func(string) (*SessionArchive, error) {
+			t.Fatal("an unpurged archive is not read back")
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.Zero(t, result.Messages)
+	})
+
+	t.Run("streaming message blocks archiving", func(t *testing.T) {
+		ss, _ := newSession()
+		require.NoError(t, db.Create(&model.Message{SessionID: ss.ID, Seq: 3, Role: model.RoleAssistant, Streaming: true,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}).Error)
+		_, err := r.ArchiveSession(ctx, project.ID, ss.ID, "archives/key", true, nil, func(*SessionArchive) error { return nil })
+		assert.ErrorIs(t, err, ErrMessageStreaming)
+	})
+
+	t.Run("other project", func(t *testing.T) {
+		ss, _ := newSession()
+		_, err := r.ArchiveSession(ctx, uuid.New(), ss.ID, "archives/key", true, nil, func(*SessionArchive) error { return nil })
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
@@ -0,0 +1,339 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMessageShared is returned when editing, moving or deleting a message whose row a shallow
+// clone shares with its source session.
+var ErrMessageShared = errors.New("message is shared with a shallow clone")
+
+// ErrSessionShared is returned when purging the message rows of a session that shallow clones
+// still share.
+var ErrSessionShared = errors.New("session shares messages with a shallow clone")
+
+// CloneSessionShallowResult contains the result of a shallow clone
+type CloneSessionShallowResult struct {
+	OldSessionID  uuid.UUID
+	NewSessionID  uuid.UUID
+	BaseMessageID uuid.UUID
+	// SharedMessages is the number of message rows on the shared thread.
+	SharedMessages int
+}
+
+// CloneSessionShallow creates a session that shares the thread ending at fromMessageID with the
+// source instead of copying it; uuid.Nil branches at the source's newest message, and a source
+// without messages gives a clone without a base. Nothing is written but the new session row:
+// the clone points at its base message, its own messages continue from there, and reads of the
+// clone return the shared rows followed by its own. A message that is itself shared with the
+// source becomes the base directly, on the session owning its row.
+//
+// The shared rows are copy-on-write in the sense that nothing is copied until a write would make
+// the sessions disagree:
+//   - Writes that add messages to either session only add rows to that session.
+//   - Editing, moving or deleting a shared message, from either side, returns ErrMessageShared.
+//     Pins and flags are per row and show in both sessions.
+//   - Hard-deleting a session hands the rows its clones still share over to one of them rather
+//     than deleting them (see handOffSharedMessages), and purging them into an archive returns
+//     ErrSessionShared. Asset references follow the rows, so they are held once however many
+//     sessions share them.
+//
+// Returns ErrMessageNotInSession if fromMessageID is not a live message of the session's thread.
+func (r *sessionRepo) CloneSessionShallow(ctx context.Context, sessionID uuid.UUID, fromMessageID uuid.UUID) (*CloneSessionShallowResult, error) {
+	result := CloneSessionShallowResult{OldSessionID: sessionID}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var source model.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", sessionID).
+			First(&source).Error; err != nil {
+			return fmt.Errorf("failed to get session: %w", err)
+		}
+		if source.Archived {
+			return ErrSessionArchived
+		}
+
+		scope, err := sessionMessages(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		var base model.Message
+		q := scope.Select("id", "session_id", "streaming")
+		if fromMessageID != uuid.Nil {
+			q = q.Where("id = ?", fromMessageID)
+		} else {
+			q = q.Order("seq DESC, id DESC")
+		}
+		if err := q.Limit(1).Find(&base).Error; err != nil {
+			return fmt.Errorf("failed to get branch point: %w", err)
+		}
+		if base.ID == uuid.Nil && fromMessageID != uuid.Nil {
+			return ErrMessageNotInSession
+		}
+		if base.Streaming {
+			return ErrMessageStreaming
+		}
+
+		clone := model.Session{
+			ProjectID:           source.ProjectID,
+			UserID:              source.UserID,
+			DisableTaskTracking: source.DisableTaskTracking,
+			Configs:             source.Configs,
+			Metadata:            source.Metadata,
+			Tags:                source.Tags,
+			AllowedPartTypes:    source.AllowedPartTypes,
+		}
+		if base.ID != uuid.Nil {
+			chain, err := messageThread(tx, sessionID, base.ID)
+			if err != nil {
+				return fmt.Errorf("failed to get shared thread: %w", err)
+			}
+			// The clone's own messages must sort after every shared one.
+			for _, m := range chain {
+				clone.LastMessageSeq = max(clone.LastMessageSeq, m.Seq)
+			}
+			clone.BaseSessionID, clone.BaseMessageID = &base.SessionID, &base.ID
+			result.BaseMessageID = base.ID
+			result.SharedMessages = len(chain)
+		}
+		if err := tx.Create(&clone).Error; err != nil {
+			return fmt.Errorf("failed to create new session: %w", err)
+		}
+		result.NewSessionID = clone.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// sharedMessageIDs returns the IDs of the rows a shallow clone shares: its base message and the
+// base's ancestors, whichever sessions own them. It is empty for other sessions.
+func sharedMessageIDs(db *gorm.DB, sessionID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Raw(`
+		WITH RECURSIVE shared AS (
+			SELECT base_message_id AS id FROM sessions WHERE id = ? AND base_message_id IS NOT NULL
+			UNION
+			SELECT m.parent_id FROM messages m JOIN shared s ON m.id = s.id WHERE m.parent_id IS NOT NULL
+		)
+		SELECT id FROM shared`,
+		sessionID,
+	).Scan(&ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("query shared messages: %w", err)
+	}
+	return ids, nil
+}
+
+// sessionMessages scopes a message query to what the session reads: its own rows and, for a
+// shallow clone, the rows it shares. Plain sessions keep the plain session_id filter so their
+// queries still seek on the session indexes.
+func sessionMessages(db *gorm.DB, sessionID uuid.UUID) (*gorm.DB, error) {
+	shared, err := sharedMessageIDs(db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(shared) == 0 {
+		return db.Where("session_id = ?", sessionID), nil
+	}
+	return db.Where("(session_id = ? OR id IN ?)", sessionID, shared), nil
+}
+
+// clonedMessageIDs returns the rows of sessionID that its shallow clones share. A clone's
+// thread only enters another session through its base message, so these are the threads of the
+// bases of the clones of sessionID.
+func clonedMessageIDs(db *gorm.DB, sessionID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Raw(`
+		WITH RECURSIVE shared AS (
+			SELECT base_message_id AS id FROM sessions WHERE base_session_id = ?
+			UNION
+			SELECT m.parent_id FROM messages m JOIN shared s ON m.id = s.id
+			WHERE m.session_id = ? AND m.parent_id IS NOT NULL
+		)
+		SELECT id FROM shared`,
+		sessionID, sessionID,
+	).Scan(&ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("query cloned messages: %w", err)
+	}
+	return ids, nil
+}
+
+// messageShared reports whether a shallow clone shares the row of messageID, owned by sessionID.
+func messageShared(db *gorm.DB, sessionID uuid.UUID, messageID uuid.UUID) (bool, error) {
+	ids, err := clonedMessageIDs(db, sessionID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(ids, messageID), nil
+}
+
+// checkMessageWritable returns ErrMessageShared when messageID is a shared row, seen either from
+// the source owning it or from a clone reading it. notFound is returned when the message is
+// neither in the session nor shared with it.
+func checkMessageWritable(tx *gorm.DB, sessionID uuid.UUID, messageID uuid.UUID, notFound error) error {
+	var owner uuid.UUID
+	if err := tx.Unscoped().Model(&model.Message{}).Select("session_id").
+		Where("id = ?", messageID).Limit(1).Scan(&owner).Error; err != nil {
+		return err
+	}
+	if owner == sessionID {
+		shared, err := messageShared(tx, sessionID, messageID)
+		if err != nil {
+			return err
+		}
+		if shared {
+			return ErrMessageShared
+		}
+		return nil
+	}
+	ids, err := sharedMessageIDs(tx, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == messageID {
+			return ErrMessageShared
+		}
+	}
+	return notFound
+}
+
+// sessionShared reports whether shallow clones share any message row of sessionID.
+func sessionShared(db *gorm.DB, sessionID uuid.UUID) (bool, error) {
+	var shared bool
+	err := db.Raw("SELECT EXISTS(SELECT 1 FROM sessions WHERE base_session_id = ?)", sessionID).Scan(&shared).Error
+	return shared, err
+}
+
+// handOffSharedMessages runs before the message rows of sessionIDs are hard-deleted. The rows
+// shallow clones outside sessionIDs still share are moved into those clones, with their
+// revisions and embeddings, instead of being deleted; oldest clone first, so a clone that
+// shares a thread with an older one becomes a clone of it. Asset references move with the rows.
+func handOffSharedMessages(tx *gorm.DB, sessionIDs []uuid.UUID) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	var hasEmbeddings bool
+	if err := tx.Raw("SELECT to_regclass('message_embeddings') IS NOT NULL").Scan(&hasEmbeddings).Error; err != nil {
+		return fmt.Errorf("check embeddings table: %w", err)
+	}
+
+	// Handing a thread off may leave a clone based on another session of sessionIDs, which the
+	// next round handles; every round moves rows out of sessionIDs, so the loop ends.
+	for {
+		var clones []model.Session
+		if err := tx.Unscoped().Select("id", "base_session_id", "base_message_id").
+			Where("base_session_id IN ? AND id NOT IN ?", sessionIDs, sessionIDs).
+			Order("created_at ASC, id ASC").
+			Find(&clones).Error; err != nil {
+			return fmt.Errorf("query shallow clones: %w", err)
+		}
+		if len(clones) == 0 {
+			return nil
+		}
+		for _, c := range clones {
+			if err := handOffToClone(tx, c, hasEmbeddings); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sharedRow is the subset of a message row handOffToClone moves.
+type sharedRow struct {
+	ID           uuid.UUID
+	ParentID     *uuid.UUID
+	StorageBytes int64
+	DeletedAt    *time.Time
+}
+
+// handOffToClone moves the rows of the clone's shared thread that its base session owns into the
+// clone and rebases the clone on the first ancestor left outside it, if any.
+func handOffToClone(tx *gorm.DB, clone model.Session, hasEmbeddings bool) error {
+	var owner uuid.UUID
+	if err := tx.Unscoped().Model(&model.Message{}).Select("session_id").
+		Where("id = ?", *clone.BaseMessageID).Limit(1).Scan(&owner).Error; err != nil {
+		return fmt.Errorf("query base message: %w", err)
+	}
+	if owner != *clone.BaseSessionID {
+		// An older clone took the base message over.
+		return tx.Unscoped().Model(&model.Session{}).Where("id = ?", clone.ID).
+			UpdateColumn("base_session_id", owner).Error
+	}
+
+	var rows []sharedRow
+	if err := tx.Raw(`
+		WITH RECURSIVE chain AS (
+			SELECT id, parent_id, storage_bytes, deleted_at FROM messages WHERE id = ?
+			UNION
+			SELECT p.id, p.parent_id, p.storage_bytes, p.deleted_at
+			FROM messages p JOIN chain c ON p.id = c.parent_id
+			WHERE p.session_id = ?
+		)
+		SELECT * FROM chain`,
+		*clone.BaseMessageID, owner,
+	).Scan(&rows).Error; err != nil {
+		return fmt.Errorf("query shared thread: %w", err)
+	}
+	ids := make(map[uuid.UUID]bool, len(rows))
+	moved := make([]uuid.UUID, 0, len(rows))
+	for _, m := range rows {
+		ids[m.ID] = true
+		moved = append(moved, m.ID)
+	}
+	var liveBytes int64
+	var live int
+	var newBase *uuid.UUID
+	for _, m := range rows {
+		if m.DeletedAt == nil {
+			liveBytes += m.StorageBytes
+			live++
+		}
+		if m.ParentID != nil && !ids[*m.ParentID] {
+			newBase = m.ParentID
+		}
+	}
+
+	// Idempotency keys are scoped to the session the message was sent to.
+	if err := tx.Unscoped().Model(&model.Message{}).Where("id IN ?", moved).
+		UpdateColumns(map[string]interface{}{"session_id": clone.ID, "idempotency_key": nil}).Error; err != nil {
+		return fmt.Errorf("move shared messages: %w", err)
+	}
+	if hasEmbeddings {
+		if err := tx.Model(&model.MessageEmbedding{}).Where("message_id IN ?", moved).
+			UpdateColumn("session_id", clone.ID).Error; err != nil {
+			return fmt.Errorf("move shared message embeddings: %w", err)
+		}
+	}
+	if err := addSessionBytes(tx, clone.ID, liveBytes); err != nil {
+		return err
+	}
+	// The shared thread predates the clone, so its LastMessageAt stands.
+	if err := addSessionMessages(tx, clone.ID, live, time.Time{}); err != nil {
+		return err
+	}
+
+	rebase := map[string]interface{}{"base_session_id": nil, "base_message_id": nil}
+	if newBase != nil {
+		var baseOwner uuid.UUID
+		if err := tx.Unscoped().Model(&model.Message{}).Select("session_id").
+			Where("id = ?", *newBase).Limit(1).Scan(&baseOwner).Error; err != nil {
+			return fmt.Errorf("query new base message: %w", err)
+		}
+		rebase = map[string]interface{}{"base_session_id": baseOwner, "base_message_id": *newBase}
+	}
+	return tx.Unscoped().Model(&model.Session{}).Where("id = ?", clone.ID).UpdateColumns(rebase).Error
+}
@@ -0,0 +1,42 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// ListSessionsForExport returns a page of the project's sessions after (afterCreatedAt, afterID)
+// in (created_at, id) order. Unlike ListWithCursor it keeps templates and archived sessions, and
+// soft-deleted sessions too when includeDeleted is set. A zero position starts from the first
+// session; a zero afterID alone includes every session created at afterCreatedAt.
+func (r *sessionRepo) ListSessionsForExport(ctx context.Context, projectID uuid.UUID, afterCreatedAt time.Time, afterID uuid.UUID, limit int, includeDeleted bool) ([]model.Session, error) {
+	q := r.db.WithContext(ctx)
+	if includeDeleted {
+		q = q.Unscoped()
+	}
+	q = q.Where("project_id = ?", projectID)
+	if !afterCreatedAt.IsZero() || afterID != uuid.Nil {
+		q = q.Where("(created_at, id) > (?, ?)", afterCreatedAt, afterID)
+	}
+	var sessions []model.Session
+	return sessions, q.Order("created_at ASC, id ASC").Limit(limit).Find(&sessions).Error
+}
+
+// ListMessagesForExport returns a page of the messages the session owns after (afterSeq, afterID)
+// in seq order, soft-deleted ones included when includeDeleted is set. Rows a shallow clone shares
+// are left to the session owning them, so a project export lists every row once.
+func (r *sessionRepo) ListMessagesForExport(ctx context.Context, sessionID uuid.UUID, afterSeq int64, afterID uuid.UUID, limit int, includeDeleted bool) ([]model.Message, error) {
+	q := r.db.WithContext(ctx)
+	if includeDeleted {
+		q = q.Unscoped()
+	}
+	q = q.Where("session_id = ?", sessionID)
+	if afterID != uuid.Nil {
+		q = q.Where("(seq, id) > (?, ?)", afterSeq, afterID)
+	}
+	var messages []model.Message
+	return messages, q.Order("seq ASC, id ASC").Limit(limit).Find(&messages).Error
+}
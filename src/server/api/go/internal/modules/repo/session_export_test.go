@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+func TestSessionRepo_ListForExport(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_export",
+		SecretKeyHashPHC: "test_hash_export",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	base := time.Now().UTC().Truncate(time.Second)
+	live := &model.Session{ID: uuid.New(), ProjectID: project.ID, CreatedAt: base}
+	template := &model.Session{ID: uuid.New(), ProjectID: project.ID, IsTemplate: true, CreatedAt: base.Add(time.Second)}
+	archived := &model.Session{ID: uuid.New(), ProjectID: project.ID, Archived: true, CreatedAt: base.Add(2 * time.Second)}
+	deleted := &model.Session{ID: uuid.New(), ProjectID: project.ID, CreatedAt: base.Add(3 * time.Second)}
+	for _, ss := range []*model.Session{live, template, archived, deleted} {
+		require.NoError(t, db.Create(ss).Error)
+	}
+	require.NoError(t, db.Delete(deleted).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	sessionIDs := func(sessions []model.Session) []uuid.UUID {
+		out := make([]uuid.UUID, len(sessions))
+		for i, s := range sessions {
+			out[i] = s.ID
+		}
+		return out
+	}
+
+	sessions, err := r.ListSessionsForExport(ctx, project.ID, time.Time{}, uuid.Nil, 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{live.ID, template.ID, archived.ID}, sessionIDs(sessions), "templates and archived sessions are exported")
+
+	sessions, err = r.ListSessionsForExport(ctx, project.ID, time.Time{}, uuid.Nil, 10, true)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{live.ID, template.ID, archived.ID, deleted.ID}, sessionIDs(sessions))
+
+	sessions, err = r.ListSessionsForExport(ctx, project.ID, template.CreatedAt, template.ID, 1, false)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{archived.ID}, sessionIDs(sessions), "pages seek past the cursor")
+
+	sessions, err = r.ListSessionsForExport(ctx, project.ID, template.CreatedAt, uuid.Nil, 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{template.ID, archived.ID}, sessionIDs(sessions), "a bare timestamp includes its sessions")
+
+	newMsg := func(seq int64) *model.Message {
+		m := &model.Message{ID: uuid.New(), SessionID: live.ID, Seq: seq, Role: model.RoleUser, PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	first, removed, last := newMsg(1), newMsg(2), newMsg(3)
+	require.NoError(t, db.Delete(removed).Error)
+	messageIDs := func(msgs []model.Message) []uuid.UUID {
+		out := make([]uuid.UUID, len(msgs))
+		for i, m := range msgs {
+			out[i] = m.ID
+		}
+		return out
+	}
+
+	msgs, err := r.ListMessagesForExport(ctx, live.ID, 0, uuid.Nil, 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{first.ID, last.ID}, messageIDs(msgs))
+
+	msgs, err = r.ListMessagesForExport(ctx, live.ID, first.Seq, first.ID, 10, true)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{removed.ID, last.ID}, messageIDs(msgs))
+	assert.True(t, msgs[0].DeletedAt.Valid)
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrSessionFinalized is returned when adding messages to a finalized session.
+var ErrSessionFinalized = errors.New("session is finalized")
+
+// FinalizeSession locks the session against message writes by setting its FinalizedAt, and
+// returns it. Finalizing a finalized session changes nothing. The session row is locked like
+// in ArchiveSession, so appends that reserved their seq first commit before it, and those that
+// come after are rejected. Sessions with a message still streaming cannot be finalized.
+func (r *sessionRepo) FinalizeSession(ctx context.Context, sessionID uuid.UUID) (*model.Session, error) {
+	var session model.Session
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", sessionID).
+			First(&session).Error; err != nil {
+			return err
+		}
+		if session.FinalizedAt != nil {
+			return nil
+		}
+		var streaming int64
+		if err := tx.Model(&model.Message{}).
+			Where("session_id = ? AND streaming = ?", sessionID, true).
+			Count(&streaming).Error; err != nil {
+			return fmt.Errorf("check streaming messages: %w", err)
+		}
+		if streaming > 0 {
+			return ErrMessageStreaming
+		}
+		now := time.Now()
+		if err := tx.Model(&model.Session{}).Where("id = ?", sessionID).
+			UpdateColumn("finalized_at", now).Error; err != nil {
+			return fmt.Errorf("finalize session: %w", err)
+		}
+		session.FinalizedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CreateImmutabilityOverride records an override of the session's immutability.
+func (r *sessionRepo) CreateImmutabilityOverride(ctx context.Context, o *model.ImmutabilityOverride) error {
+	if err := r.db.WithContext(ctx).Create(o).Error; err != nil {
+		return fmt.Errorf("create immutability override: %w", err)
+	}
+	return nil
+}
+
+// ListImmutabilityOverrides returns the overrides recorded for the session in the project,
+// oldest first. They are listed even once the session is deleted.
+func (r *sessionRepo) ListImmutabilityOverrides(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) ([]model.ImmutabilityOverride, error) {
+	overrides := []model.ImmutabilityOverride{}
+	if err := r.db.WithContext(ctx).Where("project_id = ? AND session_id = ?", projectID, sessionID).
+		Order("created_at ASC, id ASC").
+		Find(&overrides).Error; err != nil {
+		return nil, fmt.Errorf("list immutability overrides: %w", err)
+	}
+	return overrides, nil
+}
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+func TestSessionRepo_FinalizeSession(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_finalize",
+		SecretKeyHashPHC: "test_hash_finalize",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}, &model.ImmutabilityOverride{}))
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	newMessage := func() *model.Message {
+		return &model.Message{Role: model.RoleUser, PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}
+	}
+
+	t.Run("finalized sessions reject new messages", func(t *testing.T) {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		msg := newMessage()
+		msg.SessionID = ss.ID
+		require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+
+		finalized, err := r.FinalizeSession(ctx, ss.ID)
+		require.NoError(t, err)
+		require.NotNil(t, finalized.FinalizedAt)
+
+		again, err := r.FinalizeSession(ctx, ss.ID)
+		require.NoError(t, err)
+		assert.True(t, again.FinalizedAt.Equal(*finalized.FinalizedAt), "finalizing again keeps the first time")
+
+		next := newMessage()
+		next.SessionID = ss.ID
+		assert.ErrorIs(t, r.CreateMessageWithAssets(ctx, next, 0), ErrSessionFinalized)
+	})
+
+	t.Run("streaming message blocks finalize", func(t *testing.T) {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		msg := newMessage()
+		msg.SessionID, msg.Streaming = ss.ID, true
+		require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+
+		_, err := r.FinalizeSession(ctx, ss.ID)
+		assert.ErrorIs(t, err, ErrMessageStreaming)
+	})
+
+	t.Run("overrides outlive the session", func(t *testing.T) {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		require.NoError(t, r.CreateImmutabilityOverride(ctx, &model.ImmutabilityOverride{
+			ProjectID: project.ID, SessionID: ss.ID, Action: model.OverrideDeleteMessages, Reason: "gdpr request",
+		}))
+		require.NoError(t, db.Unscoped().Delete(&model.Session{}, "id = ?", ss.ID).Error)
+
+		overrides, err := r.ListImmutabilityOverrides(ctx, project.ID, ss.ID)
+		require.NoError(t, err)
+		require.Len(t, overrides, 1)
+		assert.Equal(t, "gdpr request", overrides[0].Reason)
+
+		other, err := r.ListImmutabilityOverrides(ctx, uuid.New(), ss.ID)
+		require.NoError(t, err)
+		assert.Empty(t, other, "overrides are scoped to their project")
+	})
+}
@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionLockPrefix prefixes the Redis key holding a session's lock: String holder ID with the
+// lock's TTL.
+const sessionLockPrefix = "session:lock:"
+
+// SessionLockRepo keeps the advisory session locks in Redis, so every instance sees the same
+// holder and an abandoned lock expires with its key.
+type SessionLockRepo interface {
+	// Acquire takes the lock of sessionID for holderID for ttl, or renews it for ttl when holderID
+	// already holds it. It returns the lock as it stands and whether holderID holds it.
+	Acquire(ctx context.Context, sessionID uuid.UUID, holderID string, ttl time.Duration) (*model.SessionLock, bool, error)
+	// Release drops the lock of sessionID when holderID holds it and reports whether it did.
+	Release(ctx context.Context, sessionID uuid.UUID, holderID string) (bool, error)
+	// Get returns the lock of sessionID, or nil when the session is not locked.
+	Get(ctx context.Context, sessionID uuid.UUID) (*model.SessionLock, error)
+}
+
+type sessionLockRepo struct {
+	redis *redis.Client
+	now   func() time.Time
+}
+
+func NewSessionLockRepo(rdb *redis.Client) SessionLockRepo {
+	return &sessionLockRepo{redis: rdb, now: time.Now}
+}
+
+// acquireSessionLockScript sets the lock when it is free or already held by ARGV[1], and returns
+// {acquired, holder, remaining ttl in ms}.
+var acquireSessionLockScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return {1, ARGV[1], tonumber(ARGV[2])}
+end
+return {0, holder, redis.call('PTTL', KEYS[1])}
+`)
+
+// releaseSessionLockScript deletes the lock only when ARGV[1] holds it.
+var releaseSessionLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func sessionLockKey(sessionID uuid.UUID) string {
+	return sessionLockPrefix + sessionID.String()
+}
+
+func (r *sessionLockRepo) Acquire(ctx context.Context, sessionID uuid.UUID, holderID string, ttl time.Duration) (*model.SessionLock, bool, error) {
+	if holderID == "" {
+		return nil, false, errors.New("acquire session lock: holder id is required")
+	}
+	if ttl < time.Millisecond {
+		return nil, false, errors.New("acquire session lock: ttl must be at least 1ms")
+	}
+	res, err := acquireSessionLockScript.Run(ctx, r.redis, []string{sessionLockKey(sessionID)}, holderID, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return nil, false, fmt.Errorf("acquire session lock: %w", err)
+	}
+	if len(res) != 3 {
+		return nil, false, fmt.Errorf("acquire session lock: unexpected reply %v", res)
+	}
+	acquired, _ := res[0].(int64)
+	holder, _ := res[1].(string)
+	remaining, _ := res[2].(int64)
+	return r.lock(sessionID, holder, remaining), acquired == 1, nil
+}
+
+func (r *sessionLockRepo) Release(ctx context.Context, sessionID uuid.UUID, holderID string) (bool, error) {
+	n, err := releaseSessionLockScript.Run(ctx, r.redis, []string{sessionLockKey(sessionID)}, holderID).Int64()
+	if err != nil {
+		return false, fmt.Errorf("release session lock: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (r *sessionLockRepo) Get(ctx context.Context, sessionID uuid.UUID) (*model.SessionLock, error) {
+	key := sessionLockKey(sessionID)
+	pipe := r.redis.Pipeline()
+	holder := pipe.Get(ctx, key)
+	remaining := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("get session lock: %w", err)
+	}
+	if errors.Is(holder.Err(), redis.Nil) || remaining.Val() < 0 {
+		// No lock, or it expired between the two reads.
+		return nil, nil
+	}
+	return r.lock(sessionID, holder.Val(), remaining.Val().Milliseconds()), nil
+}
+
+// lock builds the lock held by holder for remainingMs more milliseconds.
+func (r *sessionLockRepo) lock(sessionID uuid.UUID, holder string, remainingMs int64) *model.SessionLock {
+	return &model.SessionLock{
+		SessionID: sessionID,
+		HolderID:  holder,
+		ExpiresAt: r.now().Add(time.Duration(remainingMs) * time.Millisecond).UTC(),
+	}
+}
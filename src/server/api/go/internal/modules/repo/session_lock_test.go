@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionLockRepo_Contention(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := setupMiniRedis(t)
+	locks := NewSessionLockRepo(rdb)
+	sessionID := uuid.New()
+
+	lock, ok, err := locks.Acquire(ctx, sessionID, "agent-a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "agent-a", lock.HolderID)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), lock.ExpiresAt, 2*time.Second)
+
+	lock, ok, err = locks.Acquire(ctx, sessionID, "agent-b", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok, "another holder cannot take a held lock")
+	assert.Equal(t, "agent-a", lock.HolderID, "the current holder is reported")
+
+	released, err := locks.Release(ctx, sessionID, "agent-b")
+	require.NoError(t, err)
+	assert.False(t, released, "only the holder releases")
+
+	released, err = locks.Release(ctx, sessionID, "agent-a")
+	require.NoError(t, err)
+	assert.True(t, released)
+	lock, err = locks.Get(ctx, sessionID)
+	require.NoError(t, err)
+	assert.Nil(t, lock)
+
+	_, ok, err = locks.Acquire(ctx, sessionID, "agent-b", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok, "a released lock is free")
+
+	_, ok, err = locks.Acquire(ctx, uuid.New(), "agent-a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok, "locks are per session")
+}
+
+func TestSessionLockRepo_ConcurrentAcquire(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := setupMiniRedis(t)
+	locks := NewSessionLockRepo(rdb)
+	sessionID := uuid.New()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var winners []string
+	for _, holder := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := locks.Acquire(ctx, sessionID, holder, time.Minute)
+			assert.NoError(t, err)
+			if ok {
+				mu.Lock()
+				winners = append(winners, holder)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, winners, 1)
+	lock, err := locks.Get(ctx, sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, winners[0], lock.HolderID)
+}
+
+func TestSessionLockRepo_Expiry(t *testing.T) {
+	ctx := context.Background()
+	mr, rdb := setupMiniRedis(t)
+	locks := NewSessionLockRepo(rdb)
+	sessionID := uuid.New()
+
+	_, ok, err := locks.Acquire(ctx, sessionID, "crashed", 10*time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	mr.FastForward(6 * time.Second)
+	lock, _, err := locks.Acquire(ctx, sessionID, "waiting", 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "crashed", lock.HolderID)
+	assert.WithinDuration(t, time.Now().Add(4*time.Second), lock.ExpiresAt, 2*time.Second)
+
+	mr.FastForward(5 * time.Second)
+	lock, err = locks.Get(ctx, sessionID)
+	require.NoError(t, err)
+	assert.Nil(t, lock, "the lock of a crashed holder expires")
+
+	_, ok, err = locks.Acquire(ctx, sessionID, "waiting", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestSessionLockRepo_Renew(t *testing.T) {
+	ctx := context.Background()
+	mr, rdb := setupMiniRedis(t)
+	locks := NewSessionLockRepo(rdb)
+	sessionID := uuid.New()
+
+	_, _, err := locks.Acquire(ctx, sessionID, "agent", 10*time.Second)
+	require.NoError(t, err)
+	mr.FastForward(8 * time.Second)
+	_, ok, err := locks.Acquire(ctx, sessionID, "agent", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok, "the holder renews its lock")
+
+	mr.FastForward(8 * time.Second)
+	lock, err := locks.Get(ctx, sessionID)
+	require.NoError(t, err)
+	require.NotNil(t, lock, "renewal restarted the ttl")
+	assert.Equal(t, "agent", lock.HolderID)
+
+	_, _, err = locks.Acquire(ctx, sessionID, "", time.Minute)
+	assert.Error(t, err)
+	_, _, err = locks.Acquire(ctx, sessionID, "agent", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,254 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMergeIntoSelf is returned when a session is listed among the sessions merged into it.
+var ErrMergeIntoSelf = errors.New("session cannot be merged into itself")
+
+// SessionMergeMode selects where MergeSessions puts the root messages of the merged sessions.
+type SessionMergeMode string
+
+const (
+	// SessionMergeAttach moves each root under the target's leaf, its newest live message, so the
+	// merged sessions continue the target's conversation one after the other.
+	SessionMergeAttach SessionMergeMode = "attach"
+	// SessionMergeBranches keeps the roots as roots, each merged session a separate branch.
+	SessionMergeBranches SessionMergeMode = "branches"
+)
+
+// MergeSessionsResult reports what MergeSessions changed.
+type MergeSessionsResult struct {
+	Session *model.Session `json:"session"`
+	// Moved counts the messages moved into the target, deleted ones included.
+	Moved int64 `json:"moved"`
+	// LeafID is the target's leaf after an attach merge, the newest live message moved; nil in
+	// branches mode or when there was no message to attach to.
+	LeafID *uuid.UUID `json:"leaf_id,omitempty"`
+}
+
+// mergedRow is the subset of a message row MergeSessions moves.
+type mergedRow struct {
+	ID           uuid.UUID
+	ParentID     *uuid.UUID
+	Role         string
+	StorageBytes int64
+	CreatedAt    time.Time
+	DeletedAt    *time.Time
+}
+
+// MergeSessions moves every message of sourceIDs, deleted ones included, into targetID and
+// soft-deletes the sources, in one transaction. The sources are taken in the order given and
+// their messages renumbered in (seq, id) order after the target's last seq, so reads list them
+// after the target's own. Embeddings and enrichment jobs follow their messages, as do revisions,
+// audits and annotations keyed by message; asset references are per project, so they stand.
+// Tasks and events stay with the source, so the moved messages lose their TaskID, and
+// idempotency keys, scoped to the session the message was sent to, are released.
+//
+// In attach mode the roots of each source are moved under the target's leaf, which then becomes
+// the source's newest live message; a target without live messages attaches under its base
+// message if it is a shallow clone, else keeps the roots. Listeners of the target get a
+// message.created event for each live message moved.
+//
+// All sessions must be in the project, else gorm.ErrRecordNotFound is returned. Archived ones
+// return ErrSessionArchived, finalized ones ErrSessionFinalized, a source with a message still
+// streaming ErrMessageStreaming, and a source that is or has a shallow clone ErrSessionShared.
+// The session rows are locked in id order so concurrent merges and appends serialize.
+func (r *sessionRepo) MergeSessions(ctx context.Context, projectID uuid.UUID, targetID uuid.UUID, sourceIDs []uuid.UUID, mode SessionMergeMode) (*MergeSessionsResult, error) {
+	sources := make([]uuid.UUID, 0, len(sourceIDs))
+	for _, id := range sourceIDs {
+		if id == targetID {
+			return nil, ErrMergeIntoSelf
+		}
+		if !slices.Contains(sources, id) {
+			sources = append(sources, id)
+		}
+	}
+
+	result := &MergeSessionsResult{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sessions []model.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ? AND project_id = ?", append([]uuid.UUID{targetID}, sources...), projectID).
+			Order("id ASC").
+			Find(&sessions).Error; err != nil {
+			return fmt.Errorf("lock sessions: %w", err)
+		}
+		if len(sessions) != len(sources)+1 {
+			return gorm.ErrRecordNotFound
+		}
+		var target model.Session
+		for _, s := range sessions {
+			if s.Archived {
+				return ErrSessionArchived
+			}
+			if s.FinalizedAt != nil {
+				return ErrSessionFinalized
+			}
+			if s.ID == targetID {
+				target = s
+				continue
+			}
+			if s.BaseSessionID != nil {
+				return ErrSessionShared
+			}
+			shared, err := sessionShared(tx, s.ID)
+			if err != nil {
+				return fmt.Errorf("check shallow clones: %w", err)
+			}
+			if shared {
+				return ErrSessionShared
+			}
+		}
+		if len(sources) == 0 {
+			result.Session = &target
+			return nil
+		}
+		var streaming int64
+		if err := tx.Model(&model.Message{}).
+			Where("session_id IN ? AND streaming = ?", sources, true).
+			Count(&streaming).Error; err != nil {
+			return fmt.Errorf("check streaming messages: %w", err)
+		}
+		if streaming > 0 {
+			return ErrMessageStreaming
+		}
+
+		var leaf *uuid.UUID
+		if mode == SessionMergeAttach {
+			var leaves []uuid.UUID
+			if err := tx.Model(&model.Message{}).
+				Where("session_id = ?", targetID).
+				Order("seq DESC, id DESC").Limit(1).
+				Pluck("id", &leaves).Error; err != nil {
+				return fmt.Errorf("query target leaf: %w", err)
+			}
+			if len(leaves) > 0 {
+				leaf = &leaves[0]
+			} else {
+				leaf = target.BaseMessageID
+			}
+		}
+		var hasEmbeddings, hasEnrichments bool
+		if err := tx.Raw("SELECT to_regclass('message_embeddings') IS NOT NULL, to_regclass('part_enrichments') IS NOT NULL").
+			Row().Scan(&hasEmbeddings, &hasEnrichments); err != nil {
+			return fmt.Errorf("check embedding tables: %w", err)
+		}
+
+		for _, src := range sources {
+			var rows []mergedRow
+			if err := tx.Unscoped().Model(&model.Message{}).
+				Select("id", "parent_id", "role", "storage_bytes", "created_at", "deleted_at").
+				Where("session_id = ?", src).
+				Order("seq ASC, id ASC").
+				Scan(&rows).Error; err != nil {
+				return fmt.Errorf("load messages of session %s: %w", src, err)
+			}
+			if len(rows) == 0 {
+				continue
+			}
+			first, err := reserveMessageSeqs(tx, targetID, len(rows))
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec(`
+				UPDATE messages m
+				SET session_id = ?, seq = ? + r.rn - 1, task_id = NULL, idempotency_key = NULL, updated_at = ?
+				FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY seq ASC, id ASC) AS rn FROM messages WHERE session_id = ?) r
+				WHERE m.id = r.id`,
+				targetID, first, time.Now(), src,
+			).Error; err != nil {
+				return fmt.Errorf("move messages of session %s: %w", src, err)
+			}
+			if hasEmbeddings {
+				if err := tx.Model(&model.MessageEmbedding{}).Where("session_id = ?", src).
+					UpdateColumn("session_id", targetID).Error; err != nil {
+					return fmt.Errorf("move message embeddings: %w", err)
+				}
+			}
+			if hasEnrichments {
+				if err := tx.Model(&model.PartEnrichment{}).Where("session_id = ?", src).
+					UpdateColumn("session_id", targetID).Error; err != nil {
+					return fmt.Errorf("move part enrichments: %w", err)
+				}
+			}
+
+			var roots []uuid.UUID
+			for _, m := range rows {
+				if m.ParentID == nil {
+					roots = append(roots, m.ID)
+				}
+			}
+			if leaf != nil && len(roots) > 0 {
+				if err := tx.Unscoped().Model(&model.Message{}).Where("id IN ?", roots).
+					UpdateColumn("parent_id", *leaf).Error; err != nil {
+					return fmt.Errorf("attach merged roots: %w", err)
+				}
+			}
+
+			var live []model.Message
+			for _, m := range rows {
+				if m.DeletedAt != nil {
+					continue
+				}
+				parent := m.ParentID
+				if parent == nil && leaf != nil {
+					parent = leaf
+				}
+				live = append(live, model.Message{ID: m.ID, ParentID: parent, Role: m.Role, StorageBytes: m.StorageBytes, CreatedAt: m.CreatedAt})
+			}
+			for _, m := range live {
+				createdAt := m.CreatedAt
+				if err := notifyMessageStream(tx, model.MessageStreamEvent{
+					Type:      model.StreamEventMessageCreated,
+					SessionID: targetID,
+					MessageID: m.ID,
+					ParentID:  m.ParentID,
+					Role:      m.Role,
+					CreatedAt: &createdAt,
+				}); err != nil {
+					return err
+				}
+			}
+			if err := addSessionBytes(tx, targetID, sumStorageBytes(live)); err != nil {
+				return err
+			}
+			if err := addSessionMessages(tx, targetID, len(live), newestCreatedAt(live)); err != nil {
+				return err
+			}
+			if mode == SessionMergeAttach && len(live) > 0 {
+				leaf = &live[len(live)-1].ID
+			}
+			result.Moved += int64(len(rows))
+		}
+
+		if err := tx.Model(&model.Session{}).Where("id IN ?", sources).
+			UpdateColumns(map[string]interface{}{"message_count": 0, "total_bytes": 0}).Error; err != nil {
+			return fmt.Errorf("reset merged session counters: %w", err)
+		}
+		if err := tx.Where("id IN ?", sources).Delete(&model.Session{}).Error; err != nil {
+			return fmt.Errorf("delete merged sessions: %w", err)
+		}
+		result.LeafID = leaf
+		var merged model.Session
+		if err := tx.Where("id = ?", targetID).First(&merged).Error; err != nil {
+			return err
+		}
+		result.Session = &merged
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
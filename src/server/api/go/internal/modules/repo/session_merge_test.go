@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+func TestSessionRepo_MergeSessions(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_merge",
+		SecretKeyHashPHC: "test_hash_merge",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	newSession := func() *model.Session {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		return ss
+	}
+	// Each message continues from the session's newest one.
+	add := func(sessionID uuid.UUID) *model.Message {
+		msg := &model.Message{
+			SessionID:      sessionID,
+			Role:           model.RoleUser,
+			StorageBytes:   10,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		}
+		require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+		return msg
+	}
+	listed := func(sessionID uuid.UUID) []model.Message {
+		msgs, err := r.ListAllMessagesBySession(ctx, sessionID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		return msgs
+	}
+
+	t.Run("attach appends after the target leaf", func(t *testing.T) {
+		target, a, b := newSession(), newSession(), newSession()
+		t1 := add(target.ID)
+		t2 := add(target.ID)
+		a1 := add(a.ID)
+		a2 := add(a.ID)
+		gone := add(a.ID)
+		require.NoError(t, r.DeleteMessage(ctx, a.ID, gone.ID))
+		b1 := add(b.ID)
+
+		result, err := r.MergeSessions(ctx, project.ID, target.ID, []uuid.UUID{a.ID, b.ID}, SessionMergeAttach)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), result.Moved, "deleted messages move too")
+		require.NotNil(t, result.LeafID)
+		assert.Equal(t, b1.ID, *result.LeafID)
+		assert.Equal(t, 5, result.Session.MessageCount)
+		assert.Equal(t, int64(50), result.Session.TotalBytes)
+		assert.Equal(t, int64(6), result.Session.LastMessageSeq)
+
+		msgs := listed(target.ID)
+		ids := make([]uuid.UUID, len(msgs))
+		for i, m := range msgs {
+			ids[i] = m.ID
+		}
+		assert.Equal(t, []uuid.UUID{t1.ID, t2.ID, a1.ID, a2.ID, b1.ID}, ids)
+		for i := 1; i < len(msgs); i++ {
+			assert.Greater(t, msgs[i].Seq, msgs[i-1].Seq)
+		}
+		assert.Equal(t, t2.ID, *msgs[2].ParentID, "source root attaches under the target leaf")
+		assert.Equal(t, a2.ID, *msgs[4].ParentID, "next source attaches under the previous one")
+
+		_, err = r.Get(ctx, &model.Session{ID: a.ID})
+		assert.Error(t, err, "sources are soft-deleted")
+	})
+
+	t.Run("branches keeps roots", func(t *testing.T) {
+		target, a := newSession(), newSession()
+		add(target.ID)
+		a1 := add(a.ID)
+
+		result, err := r.MergeSessions(ctx, project.ID, target.ID, []uuid.UUID{a.ID}, SessionMergeBranches)
+		require.NoError(t, err)
+		assert.Nil(t, result.LeafID)
+		moved, err := r.GetMessageByID(ctx, target.ID, a1.ID)
+		require.NoError(t, err)
+		assert.Nil(t, moved.ParentID)
+	})
+
+	t.Run("rejects merging into itself", func(t *testing.T) {
+		target := newSession()
+		_, err := r.MergeSessions(ctx, project.ID, target.ID, []uuid.UUID{target.ID}, SessionMergeAttach)
+		assert.ErrorIs(t, err, ErrMergeIntoSelf)
+	})
+
+	t.Run("rejects finalized sources", func(t *testing.T) {
+		target, a := newSession(), newSession()
+		add(a.ID)
+		require.NoError(t, db.Model(&model.Session{}).Where("id = ?", a.ID).UpdateColumn("finalized_at", time.Now()).Error)
+
+		_, err := r.MergeSessions(ctx, project.ID, target.ID, []uuid.UUID{a.ID}, SessionMergeAttach)
+		assert.ErrorIs(t, err, ErrSessionFinalized)
+		assert.Len(t, listed(a.ID), 1, "nothing moves")
+	})
+
+	t.Run("rejects sessions of other projects", func(t *testing.T) {
+		target := newSession()
+		_, err := r.MergeSessions(ctx, project.ID, target.ID, []uuid.UUID{uuid.New()}, SessionMergeAttach)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+)
+
+// SessionStatsDrift reports a session whose MessageCount or LastMessageAt disagrees with its live
+// messages.
+type SessionStatsDrift struct {
+	SessionID           uuid.UUID `json:"session_id"`
+	MessageCount        int       `json:"message_count"`
+	ActualMessageCount  int       `json:"actual_message_count"`
+	LastMessageAt       time.Time `json:"last_message_at"`
+	ActualLastMessageAt time.Time `json:"actual_last_message_at"`
+}
+
+// sessionStatsDriftSQL recomputes the counters of the listed sessions from the live messages they
+// own and returns those that drifted.
+const sessionStatsDriftSQL = `
+	SELECT s.id AS session_id, s.message_count, s.last_message_at,
+		COUNT(m.id) AS actual_message_count,
+		GREATEST(s.created_at, MAX(m.created_at)) AS actual_last_message_at
+	FROM sessions s
+	LEFT JOIN messages m ON m.session_id = s.id AND m.deleted_at IS NULL
+	WHERE s.id IN ?
+	GROUP BY s.id
+	HAVING s.message_count <> COUNT(m.id)
+		OR s.last_message_at <> GREATEST(s.created_at, MAX(m.created_at))`
+
+// CheckSessionStats compares the MessageCount and LastMessageAt of every session, soft-deleted
+// ones included, with the live messages it owns, batchSize sessions at a time, and returns the
+// sessions that disagree. With repair their counters are set to the actual values; each batch is
+// row-locked first so writes in flight are counted once they commit. Purged archives are skipped:
+// their messages live in cold storage.
+func CheckSessionStats(ctx context.Context, db *gorm.DB, batchSize int, repair bool) ([]SessionStatsDrift, error) {
+	var drifts []SessionStatsDrift
+	err := walkSessionStats(ctx, db, batchSize, repair, func(batch []SessionStatsDrift) {
+		drifts = append(drifts, batch...)
+	})
+	return drifts, err
+}
+
+// BackfillSessionStats sets the MessageCount and LastMessageAt of sessions stored before they
+// were tracked, and repairs any other drift, batchSize sessions at a time. It returns the number
+// of sessions updated.
+func BackfillSessionStats(ctx context.Context, db *gorm.DB, batchSize int) (int64, error) {
+	var total int64
+	err := walkSessionStats(ctx, db, batchSize, true, func(batch []SessionStatsDrift) {
+		total += int64(len(batch))
+	})
+	return total, err
+}
+
+// walkSessionStats checks the sessions in ID order and passes the drift of each batch to report.
+func walkSessionStats(ctx context.Context, db *gorm.DB, batchSize int, repair bool, report func([]SessionStatsDrift)) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("check session stats: batch size must be positive")
+	}
+	after := uuid.Nil
+	for {
+		var ids []uuid.UUID
+		if err := db.WithContext(ctx).Unscoped().Model(&model.Session{}).
+			Where("id > ? AND archive_purged = ?", after, false).
+			Order("id").Limit(batchSize).
+			Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("list sessions: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		var drifts []SessionStatsDrift
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if repair {
+				if err := tx.Exec("SELECT id FROM sessions WHERE id IN ? ORDER BY id FOR UPDATE", ids).Error; err != nil {
+					return fmt.Errorf("lock sessions: %w", err)
+				}
+			}
+			if err := tx.Raw(sessionStatsDriftSQL, ids).Scan(&drifts).Error; err != nil {
+				return fmt.Errorf("count session messages: %w", err)
+			}
+			if !repair {
+				return nil
+			}
+			for _, d := range drifts {
+				if err := tx.Unscoped().Model(&model.Session{}).Where("id = ?", d.SessionID).
+					UpdateColumns(map[string]interface{}{
+						"message_count":   d.ActualMessageCount,
+						"last_message_at": d.ActualLastMessageAt,
+					}).Error; err != nil {
+					return fmt.Errorf("repair session %s: %w", d.SessionID, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(drifts) > 0 {
+			report(drifts)
+		}
+		if len(ids) < batchSize {
+			return nil
+		}
+		after = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+func TestSessionRepo_SessionStats(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_stats",
+		SecretKeyHashPHC: "test_hash_stats",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	base := time.Now().UTC().Truncate(time.Second)
+	idle := &model.Session{ID: uuid.New(), ProjectID: project.ID, CreatedAt: base.Add(-time.Hour)}
+	busy := &model.Session{ID: uuid.New(), ProjectID: project.ID, CreatedAt: base.Add(-2 * time.Hour)}
+	for _, ss := range []*model.Session{idle, busy} {
+		require.NoError(t, db.Create(ss).Error)
+	}
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	load := func(id uuid.UUID) model.Session {
+		var s model.Session
+		require.NoError(t, db.Where("id = ?", id).First(&s).Error)
+		return s
+	}
+
+	root := uuid.New()
+	msgs := []model.Message{
+		{ID: root, Role: "user", CreatedAt: base.Add(-time.Minute), PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+		{ParentID: &root, Role: "assistant", CreatedAt: base, PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+	}
+	require.NoError(t, r.CreateMessagesBatch(ctx, busy.ID, msgs))
+	s := load(busy.ID)
+	assert.Equal(t, 2, s.MessageCount)
+	assert.True(t, s.LastMessageAt.Equal(base))
+
+	t.Run("listing sorts by last activity", func(t *testing.T) {
+		sessions, err := r.ListWithCursor(ctx, project.ID, "", nil, nil, nil, false, false, time.Time{}, uuid.Nil, 10, true, SessionOrderLastMessageAt, TimeRange{})
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, []uuid.UUID{busy.ID, idle.ID}, []uuid.UUID{sessions[0].ID, sessions[1].ID})
+	})
+
+	t.Run("delete and restore", func(t *testing.T) {
+		require.NoError(t, r.DeleteMessage(ctx, busy.ID, msgs[1].ID))
+		s := load(busy.ID)
+		assert.Equal(t, 1, s.MessageCount)
+		assert.True(t, s.LastMessageAt.Equal(base.Add(-time.Minute)), "falls back to the newest live message")
+
+		require.NoError(t, r.RestoreMessage(ctx, busy.ID, msgs[1].ID))
+		s = load(busy.ID)
+		assert.Equal(t, 2, s.MessageCount)
+		assert.True(t, s.LastMessageAt.Equal(base))
+	})
+
+	t.Run("check finds and repairs drift", func(t *testing.T) {
+		require.NoError(t, db.Model(&model.Session{}).Where("id = ?", busy.ID).
+			UpdateColumns(map[string]interface{}{"message_count": 7, "last_message_at": base.Add(time.Hour)}).Error)
+
+		drifts, err := CheckSessionStats(ctx, db, 1, false)
+		require.NoError(t, err)
+		var found *SessionStatsDrift
+		for i := range drifts {
+			if drifts[i].SessionID == busy.ID {
+				found = &drifts[i]
+			}
+		}
+		require.NotNil(t, found)
+		assert.Equal(t, 7, found.MessageCount)
+		assert.Equal(t, 2, found.ActualMessageCount)
+		assert.True(t, found.ActualLastMessageAt.Equal(base))
+		assert.Equal(t, 7, load(busy.ID).MessageCount, "a check alone changes nothing")
+
+		n, err := BackfillSessionStats(ctx, db, 1)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, n, int64(1))
+		s := load(busy.ID)
+		assert.Equal(t, 2, s.MessageCount)
+		assert.True(t, s.LastMessageAt.Equal(base))
+	})
+
+	t.Run("cascade delete", func(t *testing.T) {
+		res, err := r.DeleteMessages(ctx, busy.ID, MessageFilter{Roles: []string{"user"}}, true)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), res.Deleted)
+		s := load(busy.ID)
+		assert.Equal(t, 0, s.MessageCount)
+		assert.True(t, s.LastMessageAt.Equal(busy.CreatedAt), "an empty session falls back to its creation")
+	})
+}
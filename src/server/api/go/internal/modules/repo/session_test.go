@@ -2,11 +2,17 @@ package repo
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	dbpkg "github.com/memodb-io/Acontext/internal/infra/db"
 	"github.com/memodb-io/Acontext/internal/modules/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -17,7 +23,7 @@ import (
 )
 
 // setupSessionTestDB creates a test database connection for session tests
-func setupSessionTestDB(t *testing.T) *gorm.DB {
+func setupSessionTestDB(t testing.TB) *gorm.DB {
 	// Skip if no test database is configured
 	dsn := "host=localhost user=acontext password=helloworld dbname=acontext port=15432 sslmode=disable"
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
@@ -37,7 +43,7 @@ func setupSessionTestDB(t *testing.T) *gorm.DB {
 }
 
 // cleanupSessionTestDB cleans up test data
-func cleanupSessionTestDB(t *testing.T, db *gorm.DB, projectID uuid.UUID) {
+func cleanupSessionTestDB(t testing.TB, db *gorm.DB, projectID uuid.UUID) {
 	// Clean up in reverse order of foreign key dependencies
 	db.Exec("DELETE FROM sessions WHERE project_id = ?", projectID)
 	db.Exec("DELETE FROM projects WHERE id = ?", projectID)
@@ -434,6 +440,30 @@ func (m *MockAssetReferenceRepoForCopy) ListS3KeysByProject(ctx context.Context,
 	return nil, nil
 }
 
+func (m *MockAssetReferenceRepoForCopy) FindAssetByHash(ctx context.Context, projectID uuid.UUID, sha256 string) (*model.Asset, error) {
+	return nil, nil
+}
+
+func (m *MockAssetReferenceRepoForCopy) SetAssetMeta(ctx context.Context, projectID uuid.UUID, sha256 string, meta map[string]any) error {
+	return nil
+}
+
+func (m *MockAssetReferenceRepoForCopy) GetByIDs(ctx context.Context, projectID uuid.UUID, ids []uuid.UUID) ([]model.AssetReference, error) {
+	return nil, nil
+}
+
+func (m *MockAssetReferenceRepoForCopy) RegisterAsset(ctx context.Context, projectID uuid.UUID, asset model.Asset) (*model.AssetReference, bool, error) {
+	return nil, false, nil
+}
+
+func (m *MockAssetReferenceRepoForCopy) CollectOrphanedAssets(ctx context.Context, cutoff time.Time, limit int, dryRun bool) ([]model.AssetReference, error) {
+	return nil, nil
+}
+
+func (m *MockAssetReferenceRepoForCopy) DeleteUnreferencedAssets(ctx context.Context, projectID uuid.UUID, ids []uuid.UUID, _ time.Time) ([]model.AssetReference, error) {
+	return nil, nil
+}
+
 // TestSessionRepo_CopySession tests the CopySession method with comprehensive scenarios
 func TestSessionRepo_CopySession(t *testing.T) {
 	db := setupSessionTestDB(t)
@@ -1007,3 +1037,2284 @@ func TestSessionRepo_CopySession(t *testing.T) {
 		assert.True(t, newSession.DisableTaskTracking)
 	})
 }
+
+// TestSessionRepo_ForkSession tests forking a session at a given message
+func TestSessionRepo_ForkSession(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_fork_at",
+		SecretKeyHashPHC: "test_hash_fork_at",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}, &model.Task{}, &model.AssetReference{}))
+
+	// Build a tree: root -> a -> b, and a sibling branch root -> c
+	originalSession := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(originalSession).Error)
+
+	newMsg := func(role string, parent *uuid.UUID) *model.Message {
+		m := &model.Message{
+			ID:        uuid.New(),
+			SessionID: originalSession.ID,
+			Role:      role,
+			ParentID:  parent,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{
+				SHA256: "fork-sha-" + uuid.NewString(),
+				S3Key:  "parts/fork.json",
+			}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	root := newMsg("user", nil)
+	a := newMsg("assistant", &root.ID)
+	b := newMsg("user", &a.ID)
+	c := newMsg("assistant", &root.ID)
+
+	repo := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	t.Run("fork copies only the ancestor chain", func(t *testing.T) {
+		result, err := repo.ForkSession(ctx, originalSession.ID, b.ID, nil)
+		require.NoError(t, err)
+		assert.Equal(t, originalSession.ID, result.OldSessionID)
+		require.Len(t, result.MessageIDMap, 3)
+		assert.Contains(t, result.MessageIDMap, root.ID)
+		assert.Contains(t, result.MessageIDMap, a.ID)
+		assert.Contains(t, result.MessageIDMap, b.ID)
+		assert.NotContains(t, result.MessageIDMap, c.ID)
+
+		var forked []model.Message
+		require.NoError(t, db.Where("session_id = ?", result.NewSessionID).Find(&forked).Error)
+		require.Len(t, forked, 3)
+		byID := make(map[uuid.UUID]model.Message, len(forked))
+		for _, m := range forked {
+			byID[m.ID] = m
+		}
+		assert.Nil(t, byID[result.MessageIDMap[root.ID]].ParentID)
+		assert.Equal(t, result.MessageIDMap[root.ID], *byID[result.MessageIDMap[a.ID]].ParentID)
+		assert.Equal(t, result.MessageIDMap[a.ID], *byID[result.MessageIDMap[b.ID]].ParentID)
+	})
+
+	t.Run("fork from root yields one message", func(t *testing.T) {
+		result, err := repo.ForkSession(ctx, originalSession.ID, root.ID, nil)
+		require.NoError(t, err)
+		assert.Len(t, result.MessageIDMap, 1)
+
+		var count int64
+		require.NoError(t, db.Model(&model.Message{}).Where("session_id = ?", result.NewSessionID).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("message from another session", func(t *testing.T) {
+		other := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(other).Error)
+
+		_, err := repo.ForkSession(ctx, other.ID, b.ID, nil)
+		assert.ErrorIs(t, err, ErrMessageNotInSession)
+	})
+}
+
+// TestSessionRepo_GetMessageThread tests walking a branch up to the root
+func TestSessionRepo_GetMessageThread(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_thread",
+		SecretKeyHashPHC: "test_hash_thread",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}))
+
+	session := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(session).Error)
+
+	newMsg := func(parent *uuid.UUID) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      session.ID,
+			Role:           "user",
+			ParentID:       parent,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	root := newMsg(nil)
+	mid := newMsg(&root.ID)
+	leaf := newMsg(&mid.ID)
+
+	repo := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	t.Run("root first", func(t *testing.T) {
+		chain, err := repo.GetMessageThread(ctx, session.ID, leaf.ID)
+		require.NoError(t, err)
+		require.Len(t, chain, 3)
+		assert.Equal(t, root.ID, chain[0].ID)
+		assert.Equal(t, mid.ID, chain[1].ID)
+		assert.Equal(t, leaf.ID, chain[2].ID)
+	})
+
+	t.Run("unknown message", func(t *testing.T) {
+		_, err := repo.GetMessageThread(ctx, session.ID, uuid.New())
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("cycle is detected", func(t *testing.T) {
+		a := newMsg(nil)
+		b := newMsg(&a.ID)
+		require.NoError(t, db.Model(&model.Message{}).Where("id = ?", a.ID).Update("parent_id", b.ID).Error)
+
+		_, err := repo.GetMessageThread(ctx, session.ID, b.ID)
+		assert.ErrorIs(t, err, ErrMessageCycle)
+	})
+}
+
+// TestSessionRepo_SoftDelete tests soft-delete, restore and purge of messages and sessions
+func TestSessionRepo_SoftDelete(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_soft_delete",
+		SecretKeyHashPHC: "test_hash_soft_delete",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}, &model.AssetReference{}))
+
+	newSession := func() *model.Session {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		return ss
+	}
+	newMsg := func(sessionID uuid.UUID, parent *uuid.UUID) *model.Message {
+		m := &model.Message{
+			ID:        uuid.New(),
+			SessionID: sessionID,
+			Role:      "user",
+			ParentID:  parent,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{
+				SHA256: "soft-delete-sha-" + uuid.NewString(),
+			}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+
+	var decremented []model.Asset
+	assetRepo := &MockAssetReferenceRepoForCopy{}
+	repo := NewSessionRepo(db, &mockDecrementRecorder{MockAssetReferenceRepoForCopy: assetRepo, got: &decremented}, nil, logger)
+
+	t.Run("soft-deleted parent still traversable by admin queries", func(t *testing.T) {
+		ss := newSession()
+		root := newMsg(ss.ID, nil)
+		mid := newMsg(ss.ID, &root.ID)
+		leaf := newMsg(ss.ID, &mid.ID)
+
+		require.NoError(t, repo.DeleteMessage(ctx, ss.ID, mid.ID))
+
+		msgs, err := repo.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		assert.Len(t, msgs, 2)
+
+		chain, err := repo.GetMessageThread(ctx, ss.ID, leaf.ID)
+		require.NoError(t, err)
+		require.Len(t, chain, 3)
+		assert.True(t, chain[1].DeletedAt.Valid)
+
+		var children []model.Message
+		require.NoError(t, db.Unscoped().Where("parent_id = ?", mid.ID).Find(&children).Error)
+		require.Len(t, children, 1)
+		assert.Equal(t, leaf.ID, children[0].ID)
+	})
+
+	t.Run("restore message", func(t *testing.T) {
+		ss := newSession()
+		m := newMsg(ss.ID, nil)
+
+		require.NoError(t, repo.DeleteMessage(ctx, ss.ID, m.ID))
+		require.NoError(t, repo.RestoreMessage(ctx, ss.ID, m.ID))
+		assert.ErrorIs(t, repo.RestoreMessage(ctx, ss.ID, m.ID), gorm.ErrRecordNotFound)
+
+		_, err := repo.GetMessageByID(ctx, ss.ID, m.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("soft-deleted session is hidden", func(t *testing.T) {
+		ss := newSession()
+		newMsg(ss.ID, nil)
+
+		require.NoError(t, repo.Delete(ctx, project.ID, ss.ID, nil))
+		_, err := repo.Get(ctx, &model.Session{ID: ss.ID})
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.ErrorIs(t, repo.Delete(ctx, project.ID, ss.ID, nil), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("purge hard-deletes past retention and keeps live children", func(t *testing.T) {
+		decremented = nil
+
+		ss := newSession()
+		root := newMsg(ss.ID, nil)
+		mid := newMsg(ss.ID, &root.ID)
+		leaf := newMsg(ss.ID, &mid.ID)
+		require.NoError(t, repo.DeleteMessage(ctx, ss.ID, mid.ID))
+
+		gone := newSession()
+		newMsg(gone.ID, nil)
+		require.NoError(t, repo.Delete(ctx, project.ID, gone.ID, nil))
+
+		past := time.Now().Add(-48 * time.Hour)
+		require.NoError(t, db.Exec("UPDATE messages SET deleted_at = ? WHERE id = ?", past, mid.ID).Error)
+		require.NoError(t, db.Exec("UPDATE sessions SET deleted_at = ? WHERE id = ?", past, gone.ID).Error)
+
+		result, err := repo.PurgeDeleted(ctx, 24*time.Hour)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, result.Sessions, int64(1))
+		assert.GreaterOrEqual(t, result.Messages, int64(2))
+		assert.GreaterOrEqual(t, len(decremented), 2)
+
+		var count int64
+		require.NoError(t, db.Unscoped().Model(&model.Message{}).Where("id = ?", mid.ID).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+		require.NoError(t, db.Unscoped().Model(&model.Session{}).Where("id = ?", gone.ID).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+
+		var survivor model.Message
+		require.NoError(t, db.Where("id = ?", leaf.ID).First(&survivor).Error)
+		require.NotNil(t, survivor.ParentID)
+		assert.Equal(t, root.ID, *survivor.ParentID)
+	})
+}
+
+// mockUnreferencedDeleter records the asset IDs passed to DeleteUnreferencedAssets and fails
+// like an unreachable object store when err is set.
+type mockUnreferencedDeleter struct {
+	*MockAssetReferenceRepoForCopy
+	got []uuid.UUID
+	err error
+}
+
+func (m *mockUnreferencedDeleter) DeleteUnreferencedAssets(ctx context.Context, projectID uuid.UUID, ids []uuid.UUID, _ time.Time) ([]model.AssetReference, error) {
+	m.got = append(m.got, ids...)
+	if m.err != nil {
+		return nil, m.err
+	}
+	refs := make([]model.AssetReference, len(ids))
+	for i, id := range ids {
+		refs[i] = model.AssetReference{ID: id}
+	}
+	return refs, nil
+}
+
+func TestSessionRepo_DeleteSessionCascade(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_cascade",
+		SecretKeyHashPHC: "test_hash_cascade",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}, &model.AssetReference{}))
+
+	newAsset := func(refs int) model.Asset {
+		asset := model.Asset{SHA256: "cascade-sha-" + uuid.NewString(), S3Key: "assets/" + uuid.NewString()}
+		require.NoError(t, db.Create(&model.AssetReference{
+			ProjectID: project.ID, SHA256: asset.SHA256, S3Key: asset.S3Key, RefCount: refs,
+			AssetMeta: datatypes.NewJSONType(asset), LastReferencedAt: time.Now(),
+		}).Error)
+		return asset
+	}
+	refCount := func(asset model.Asset) int {
+		var ref model.AssetReference
+		require.NoError(t, db.Where("project_id = ? AND sha256 = ?", project.ID, asset.SHA256).First(&ref).Error)
+		return ref.RefCount
+	}
+	newSessionWith := func(assets ...model.Asset) *model.Session {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		var parent *uuid.UUID
+		for _, asset := range assets {
+			m := &model.Message{ID: uuid.New(), SessionID: ss.ID, Role: model.RoleUser, ParentID: parent, PartsAssetMeta: datatypes.NewJSONType(asset)}
+			require.NoError(t, db.Create(m).Error)
+			parent = &m.ID
+		}
+		return ss
+	}
+
+	t.Run("releases references and deletes orphaned assets", func(t *testing.T) {
+		own, shared := newAsset(1), newAsset(2)
+		ss := newSessionWith(own, shared)
+		assetRepo := &mockUnreferencedDeleter{MockAssetReferenceRepoForCopy: &MockAssetReferenceRepoForCopy{}}
+		r := NewSessionRepo(db, assetRepo, nil, logger)
+
+		result, err := r.DeleteSessionCascade(ctx, project.ID, ss.ID, nil)
+		require.NoError(t, err)
+		assert.Equal(t, &DeleteSessionCascadeResult{Messages: 2, Assets: 2, Orphaned: 1}, result)
+		assert.Equal(t, 0, refCount(own))
+		assert.Equal(t, 1, refCount(shared))
+		assert.Len(t, assetRepo.got, 1)
+
+		var count int64
+		require.NoError(t, db.Unscoped().Model(&model.Message{}).Where("session_id = ?", ss.ID).Count(&count).Error)
+		assert.Zero(t, count)
+		require.NoError(t, db.Unscoped().Model(&model.Session{}).Where("id = ?", ss.ID).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+
+	t.Run("storage failure still commits", func(t *testing.T) {
+		own := newAsset(1)
+		ss := newSessionWith(own)
+		r := NewSessionRepo(db, &mockUnreferencedDeleter{MockAssetReferenceRepoForCopy: &MockAssetReferenceRepoForCopy{}, err: errors.New("s3 unavailable")}, nil, logger)
+
+		result, err := r.DeleteSessionCascade(ctx, project.ID, ss.ID, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Deferred, "the orphan is left for the collector")
+		assert.Equal(t, 0, refCount(own))
+		_, err = r.Get(ctx, &model.Session{ID: ss.ID})
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+		_, err := r.DeleteSessionCascade(ctx, project.ID, uuid.New(), nil)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+// mockDecrementRecorder records assets passed to BatchDecrementAssetRefs
+type mockDecrementRecorder struct {
+	*MockAssetReferenceRepoForCopy
+	got *[]model.Asset
+}
+
+func (m *mockDecrementRecorder) BatchDecrementAssetRefs(ctx context.Context, projectID uuid.UUID, assets []model.Asset) error {
+	*m.got = append(*m.got, assets...)
+	return nil
+}
+
+// TestSessionRepo_SearchMessages tests full-text search over message text
+func TestSessionRepo_SearchMessages(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_search",
+		SecretKeyHashPHC: "test_hash_search",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}))
+	require.NoError(t, db.Exec(model.MessageSearchIndexDDL).Error)
+
+	s1 := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	s2 := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(s1).Error)
+	require.NoError(t, db.Create(s2).Error)
+
+	newMsg := func(sessionID uuid.UUID, text string) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      sessionID,
+			Role:           "user",
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+			SearchText:     text,
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	hit1 := newMsg(s1.ID, "the refund policy allows returns within thirty days")
+	hit2 := newMsg(s2.ID, "ask about the refund")
+	newMsg(s1.ID, "unrelated weather chat")
+	newMsg(s1.ID, "") // tool-call or image-only message
+
+	repo := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	t.Run("project wide", func(t *testing.T) {
+		hits, err := repo.SearchMessages(ctx, project.ID, nil, "refund", MessageSearchOrder{}, 10)
+		require.NoError(t, err)
+		require.Len(t, hits, 2)
+		ids := []uuid.UUID{hits[0].ID, hits[1].ID}
+		assert.ElementsMatch(t, []uuid.UUID{hit1.ID, hit2.ID}, ids)
+		assert.Contains(t, hits[0].Snippet, "<mark>refund</mark>")
+		assert.Greater(t, hits[0].Rank, 0.0)
+	})
+
+	t.Run("session scoped", func(t *testing.T) {
+		hits, err := repo.SearchMessages(ctx, project.ID, &s2.ID, "refund", MessageSearchOrder{}, 10)
+		require.NoError(t, err)
+		require.Len(t, hits, 1)
+		assert.Equal(t, hit2.ID, hits[0].ID)
+	})
+
+	t.Run("other project sees nothing", func(t *testing.T) {
+		hits, err := repo.SearchMessages(ctx, uuid.New(), nil, "refund", MessageSearchOrder{}, 10)
+		require.NoError(t, err)
+		assert.Empty(t, hits)
+	})
+}
+
+func TestSessionRepo_SearchMessages_Sort(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_search_sort",
+		SecretKeyHashPHC: "test_hash_search_sort",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}))
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	newMsg := func(text string, age time.Duration) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      ss.ID,
+			Role:           "user",
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+			SearchText:     text,
+			CreatedAt:      time.Now().Add(-age),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	// The old message matches far better; the recent one mentions the term once.
+	old := newMsg("refund refund refund: the refund policy covers every refund", 60*24*time.Hour)
+	recent := newMsg("a question about a refund and several other unrelated things", time.Hour)
+	newMsg("refunds are not mentioned here in any form", 0)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	search := func(order MessageSearchOrder) []MessageSearchHit {
+		hits, err := r.SearchMessages(ctx, project.ID, nil, "refund", order, 10)
+		require.NoError(t, err)
+		require.Len(t, hits, 2)
+		return hits
+	}
+
+	relevance := search(MessageSearchOrder{Sort: MessageSearchSortRelevance})
+	assert.Equal(t, []uuid.UUID{old.ID, recent.ID}, []uuid.UUID{relevance[0].ID, relevance[1].ID})
+	assert.Equal(t, relevance[0].Rank, relevance[0].Score)
+
+	recency := search(MessageSearchOrder{Sort: MessageSearchSortRecency})
+	assert.Equal(t, []uuid.UUID{recent.ID, old.ID}, []uuid.UUID{recency[0].ID, recency[1].ID})
+
+	blended := search(MessageSearchOrder{Sort: MessageSearchSortBlended, HalfLife: 7 * 24 * time.Hour})
+	assert.Equal(t, []uuid.UUID{recent.ID, old.ID}, []uuid.UUID{blended[0].ID, blended[1].ID}, "eight half-lives outweigh the better match")
+	assert.Less(t, blended[1].Score, blended[1].Rank)
+
+	// With a long half-life the decay is too weak to overturn the text rank.
+	slow := search(MessageSearchOrder{Sort: MessageSearchSortBlended, HalfLife: 10 * 365 * 24 * time.Hour})
+	assert.Equal(t, []uuid.UUID{old.ID, recent.ID}, []uuid.UUID{slow[0].ID, slow[1].ID})
+}
+
+func TestMessageSearchOrder_ScoreSQL(t *testing.T) {
+	assert.Equal(t, "r", MessageSearchOrder{Sort: MessageSearchSortRelevance, HalfLife: time.Hour}.scoreSQL("r"))
+	assert.Equal(t, "r", MessageSearchOrder{Sort: MessageSearchSortBlended}.scoreSQL("r"), "no half-life, no decay")
+	got := MessageSearchOrder{Sort: MessageSearchSortBlended, HalfLife: time.Hour}.scoreSQL("r")
+	assert.Contains(t, got, "r * power(0.5::float8, LEAST(")
+	assert.Contains(t, got, "/ 3600.000000, 1000)")
+}
+
+// TestSessionRepo_SearchMessagesBySession tests full-text search grouped by session
+func TestSessionRepo_SearchMessagesBySession(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_search_grouped",
+		SecretKeyHashPHC: "test_hash_search_grouped",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.User{}, &model.Message{}))
+	require.NoError(t, db.Exec(model.MessageSearchIndexDDL).Error)
+
+	alice := &model.User{ID: uuid.New(), ProjectID: project.ID, Identifier: "alice"}
+	require.NoError(t, db.Create(alice).Error)
+
+	aliceOld := &model.Session{ID: uuid.New(), ProjectID: project.ID, UserID: &alice.ID, Metadata: datatypes.JSONMap{"title": "Refund question"}}
+	aliceNew := &model.Session{ID: uuid.New(), ProjectID: project.ID, UserID: &alice.ID}
+	other := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	for _, ss := range []*model.Session{aliceOld, aliceNew, other} {
+		require.NoError(t, db.Create(ss).Error)
+	}
+
+	base := time.Now().Add(-time.Hour).UTC().Truncate(time.Microsecond)
+	newMsg := func(sessionID uuid.UUID, text string, at time.Time) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      sessionID,
+			Role:           "user",
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+			SearchText:     text,
+			CreatedAt:      at,
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	best := newMsg(aliceOld.ID, "refund refund refund please", base)
+	newMsg(aliceOld.ID, "is a refund possible", base.Add(time.Minute))
+	newMsg(aliceOld.ID, "thanks for the help", base.Add(2*time.Minute))
+	newest := newMsg(aliceNew.ID, "second refund request", base.Add(10*time.Minute))
+	newMsg(other.ID, "refund for someone else", base.Add(20*time.Minute))
+
+	repo := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	t.Run("grouped and scoped to the user", func(t *testing.T) {
+		groups, err := repo.SearchMessagesBySession(ctx, project.ID, "alice", "refund", time.Time{}, uuid.Nil, 10, 1)
+		require.NoError(t, err)
+		require.Len(t, groups, 2)
+
+		assert.Equal(t, aliceNew.ID, groups[0].SessionID)
+		assert.Equal(t, "", groups[0].Title)
+		require.Len(t, groups[0].Hits, 1)
+		assert.Equal(t, newest.ID, groups[0].Hits[0].ID)
+
+		assert.Equal(t, aliceOld.ID, groups[1].SessionID)
+		assert.Equal(t, "Refund question", groups[1].Title)
+		assert.Equal(t, 2, groups[1].MatchCount)
+		require.Len(t, groups[1].Hits, 1, "hits are capped per session")
+		assert.Equal(t, best.ID, groups[1].Hits[0].ID)
+		assert.Contains(t, groups[1].Hits[0].Snippet, "<mark>refund</mark>")
+	})
+
+	t.Run("paginates groups", func(t *testing.T) {
+		first, err := repo.SearchMessagesBySession(ctx, project.ID, "", "refund", time.Time{}, uuid.Nil, 1, 3)
+		require.NoError(t, err)
+		require.Len(t, first, 1)
+		assert.Equal(t, other.ID, first[0].SessionID)
+
+		rest, err := repo.SearchMessagesBySession(ctx, project.ID, "", "refund", first[0].LatestMatchAt, first[0].SessionID, 10, 3)
+		require.NoError(t, err)
+		require.Len(t, rest, 2)
+		assert.Equal(t, aliceNew.ID, rest[0].SessionID)
+		assert.Equal(t, aliceOld.ID, rest[1].SessionID)
+		assert.Len(t, rest[1].Hits, 2)
+	})
+
+	t.Run("unknown user sees nothing", func(t *testing.T) {
+		groups, err := repo.SearchMessagesBySession(ctx, project.ID, "mallory", "refund", time.Time{}, uuid.Nil, 10, 3)
+		require.NoError(t, err)
+		assert.Empty(t, groups)
+	})
+}
+
+func TestSessionRepo_SessionTokenTotal(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_token_total",
+		SecretKeyHashPHC: "test_hash_token_total",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	newMsg := func(role string, tokens int) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      ss.ID,
+			Role:           role,
+			TokenCount:     tokens,
+			TokenEncoding:  "o200k_base",
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "token-total-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	newMsg("user", 5)
+	newMsg("assistant", 7)
+	newMsg("user", 3)
+	deleted := newMsg("assistant", 100)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	require.NoError(t, r.DeleteMessage(ctx, ss.ID, deleted.ID))
+
+	totals, err := r.SessionTokenTotal(ctx, ss.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 15, totals.Total)
+	assert.Equal(t, map[string]int{"user": 8, "assistant": 7}, totals.ByRole)
+	assert.Equal(t, []string{"o200k_base"}, totals.Encodings)
+
+	legacy := newMsg("user", 0)
+	require.NoError(t, db.Model(legacy).Update("token_encoding", "").Error)
+	totals, err = r.SessionTokenTotal(ctx, ss.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"o200k_base", ""}, totals.Encodings)
+
+	require.NoError(t, r.UpdateMessageTokenCount(ctx, legacy.ID, 4, "o200k_base"))
+	totals, err = r.SessionTokenTotal(ctx, ss.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 19, totals.Total)
+	assert.Equal(t, []string{"o200k_base"}, totals.Encodings)
+}
+
+func TestSessionRepo_SetMessageFlag(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_message_flag",
+		SecretKeyHashPHC: "test_hash_message_flag",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}, &model.MessageFlagAudit{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+	msg := &model.Message{
+		ID:             uuid.New(),
+		SessionID:      ss.ID,
+		Role:           "user",
+		Flagged:        true,
+		FlagReason:     "contains blocked term",
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "message-flag-sha"}),
+	}
+	require.NoError(t, db.Create(msg).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	audit, err := r.SetMessageFlag(ctx, ss.ID, msg.ID, false, "", "false positive")
+	require.NoError(t, err)
+	assert.True(t, audit.PreviousFlagged)
+	assert.Equal(t, "contains blocked term", audit.PreviousFlagReason)
+	assert.False(t, audit.Flagged)
+
+	var stored model.Message
+	require.NoError(t, db.First(&stored, "id = ?", msg.ID).Error)
+	assert.False(t, stored.Flagged)
+	assert.Empty(t, stored.FlagReason)
+
+	audits, err := r.ListMessageFlagAudits(ctx, ss.ID, msg.ID)
+	require.NoError(t, err)
+	require.Len(t, audits, 1)
+	assert.Equal(t, "false positive", audits[0].Note)
+
+	_, err = r.SetMessageFlag(ctx, uuid.New(), msg.ID, true, "spam", "")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	audits, err = r.ListMessageFlagAudits(ctx, uuid.New(), msg.ID)
+	require.NoError(t, err)
+	assert.Empty(t, audits, "audits are scoped to the message's session")
+}
+
+func TestSessionRepo_StreamingAppend(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_streaming",
+		SecretKeyHashPHC: "test_hash_streaming",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	newStreaming := func() *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      ss.ID,
+			Role:           "assistant",
+			Streaming:      true,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	t.Run("100 sequential appends concatenate exactly", func(t *testing.T) {
+		msg := newStreaming()
+		var want strings.Builder
+		for i := 0; i < 100; i++ {
+			delta := fmt.Sprintf("d%d;", i)
+			want.WriteString(delta)
+			require.NoError(t, r.AppendStreamText(ctx, ss.ID, msg.ID, delta))
+		}
+
+		var got model.Message
+		require.NoError(t, db.First(&got, "id = ?", msg.ID).Error)
+		assert.Equal(t, want.String(), got.StreamText)
+	})
+
+	t.Run("concurrent appends are not lost", func(t *testing.T) {
+		msg := newStreaming()
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, r.AppendStreamText(ctx, ss.ID, msg.ID, fmt.Sprintf("<%02d>", i)))
+			}(i)
+		}
+		wg.Wait()
+
+		var got model.Message
+		require.NoError(t, db.First(&got, "id = ?", msg.ID).Error)
+		assert.Len(t, got.StreamText, 20*4)
+		for i := 0; i < 20; i++ {
+			assert.Contains(t, got.StreamText, fmt.Sprintf("<%02d>", i))
+		}
+	})
+
+	t.Run("finalize clears buffer and rejects further appends", func(t *testing.T) {
+		msg := newStreaming()
+		require.NoError(t, r.AppendStreamText(ctx, ss.ID, msg.ID, "hello"))
+
+		var seen string
+		out, err := r.FinalizeStreamingMessage(ctx, ss.ID, msg.ID, func(m *model.Message) error {
+			seen = m.StreamText
+			m.SearchText = m.StreamText
+			m.PartsAssetMeta = datatypes.NewJSONType(model.Asset{SHA256: "finalized-sha", S3Key: "parts/finalized"})
+			m.SessionTaskProcessStatus = model.MessageStatusPending
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "hello", seen)
+		assert.False(t, out.Streaming)
+
+		var got model.Message
+		require.NoError(t, db.First(&got, "id = ?", msg.ID).Error)
+		assert.False(t, got.Streaming)
+		assert.Empty(t, got.StreamText)
+		assert.Equal(t, "hello", got.SearchText)
+		assert.Equal(t, "parts/finalized", got.PartsAssetMeta.Data().S3Key)
+
+		assert.ErrorIs(t, r.AppendStreamText(ctx, ss.ID, msg.ID, "more"), ErrMessageNotStreaming)
+		_, err = r.FinalizeStreamingMessage(ctx, ss.ID, msg.ID, func(*model.Message) error { return nil })
+		assert.ErrorIs(t, err, ErrMessageNotStreaming)
+	})
+
+	t.Run("unknown message", func(t *testing.T) {
+		assert.ErrorIs(t, r.AppendStreamText(ctx, ss.ID, uuid.New(), "x"), gorm.ErrRecordNotFound)
+	})
+}
+
+func TestSplitStreamDelta(t *testing.T) {
+	assert.Equal(t, []string{"abc"}, splitStreamDelta("abc", 8))
+	assert.Equal(t, []string{""}, splitStreamDelta("", 8))
+	assert.Equal(t, []string{"abcd", "efgh", "i"}, splitStreamDelta("abcdefghi", 4))
+
+	// "é" is two bytes; a cut must never land inside it.
+	chunks := splitStreamDelta("aéééé", 4)
+	assert.Equal(t, "aéééé", strings.Join(chunks, ""))
+	for _, c := range chunks {
+		assert.True(t, utf8.ValidString(c), "chunk %q splits a rune", c)
+		assert.LessOrEqual(t, len(c), 4)
+	}
+}
+
+func TestSessionRepo_StreamNotifications(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_stream_notify",
+		SecretKeyHashPHC: "test_hash_stream_notify",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	listener := dbpkg.NewListener(db, logger)
+	listener.Start()
+	defer listener.Stop()
+
+	channel := model.MessageStreamChannel(ss.ID)
+	payloads, unsubscribe := listener.Subscribe(channel)
+	defer unsubscribe()
+
+	// LISTEN is issued asynchronously; probe until the channel is live.
+	require.Eventually(t, func() bool {
+		if db.Exec("SELECT pg_notify(?, ?)", channel, "probe").Error != nil {
+			return false
+		}
+		select {
+		case p := <-payloads:
+			return p == "probe"
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	next := func() model.MessageStreamEvent {
+		t.Helper()
+		for {
+			select {
+			case p := <-payloads:
+				if p == "probe" {
+					continue // late probe from the readiness loop
+				}
+				var ev model.MessageStreamEvent
+				require.NoError(t, json.Unmarshal([]byte(p), &ev))
+				return ev
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for stream event")
+				return model.MessageStreamEvent{}
+			}
+		}
+	}
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	msg := &model.Message{
+		SessionID:      ss.ID,
+		Role:           "assistant",
+		Streaming:      true,
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+	}
+	require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+
+	ev := next()
+	assert.Equal(t, model.StreamEventMessageCreated, ev.Type)
+	assert.Equal(t, msg.ID, ev.MessageID)
+	assert.Equal(t, ss.ID, ev.SessionID)
+	assert.True(t, ev.Streaming)
+	require.NotNil(t, ev.CreatedAt)
+
+	long := strings.Repeat("x", maxStreamDeltaBytes+10)
+	require.NoError(t, r.AppendStreamText(ctx, ss.ID, msg.ID, long))
+	first, second := next(), next()
+	assert.Equal(t, model.StreamEventMessagePartAppended, first.Type)
+	assert.Equal(t, long, first.Delta+second.Delta)
+
+	_, err := r.FinalizeStreamingMessage(ctx, ss.ID, msg.ID, func(m *model.Message) error { return nil })
+	require.NoError(t, err)
+	ev = next()
+	assert.Equal(t, model.StreamEventMessageFinalized, ev.Type)
+	assert.Equal(t, msg.ID, ev.MessageID)
+
+	// A rolled-back append must not be delivered.
+	assert.Error(t, r.AppendStreamText(ctx, ss.ID, msg.ID, "late"))
+	select {
+	case p := <-payloads:
+		if p != "probe" {
+			t.Fatalf("unexpected event after finalize: %s", p)
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestBatchTopologicalOrder(t *testing.T) {
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	msgs := []model.Message{
+		{ID: c, ParentID: &b, Role: "user"},
+		{ID: b, ParentID: &a, Role: "assistant"},
+		{ID: a, Role: "user"},
+	}
+	index := map[uuid.UUID]int{c: 0, b: 1, a: 2}
+
+	order, err := batchTopologicalOrder(msgs, index)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1, 0}, order)
+
+	msgs[2].ParentID = &c
+	_, err = batchTopologicalOrder(msgs, index)
+	var batchErr *BatchMessageError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Contains(t, batchErr.Reason, "cycle")
+}
+
+func TestSessionRepo_CreateMessagesBatch(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_batch",
+		SecretKeyHashPHC: "test_hash_batch",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	countMessages := func() int64 {
+		var n int64
+		require.NoError(t, db.Model(&model.Message{}).Where("session_id = ?", ss.ID).Count(&n).Error)
+		return n
+	}
+
+	t.Run("resolves temporary parent ids", func(t *testing.T) {
+		root, reply := uuid.New(), uuid.New()
+		msgs := []model.Message{
+			// Child listed before its parent: insertion must still go parent first.
+			{ID: reply, ParentID: &root, Role: "assistant", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+			{ID: root, Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+		}
+		require.NoError(t, r.CreateMessagesBatch(ctx, ss.ID, msgs))
+
+		assert.NotEqual(t, reply, msgs[0].ID)
+		assert.NotEqual(t, root, msgs[1].ID)
+		require.NotNil(t, msgs[0].ParentID)
+		assert.Equal(t, msgs[1].ID, *msgs[0].ParentID)
+		assert.True(t, msgs[0].CreatedAt.Before(msgs[1].CreatedAt), "input order is kept")
+
+		var stored model.Message
+		require.NoError(t, db.First(&stored, "id = ?", msgs[0].ID).Error)
+		assert.Equal(t, msgs[1].ID, *stored.ParentID)
+	})
+
+	t.Run("parent may be an existing session message", func(t *testing.T) {
+		existing := &model.Message{SessionID: ss.ID, Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}
+		require.NoError(t, db.Create(existing).Error)
+
+		msgs := []model.Message{{ParentID: &existing.ID, Role: "assistant", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}}
+		require.NoError(t, r.CreateMessagesBatch(ctx, ss.ID, msgs))
+		assert.Equal(t, existing.ID, *msgs[0].ParentID)
+	})
+
+	t.Run("invalid message rolls back the batch", func(t *testing.T) {
+		before := countMessages()
+		missing := uuid.New()
+
+		cases := []struct {
+			name  string
+			msgs  []model.Message
+			index int
+		}{
+			{
+				name: "bad role",
+				msgs: []model.Message{
+					{Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+					{Role: "system", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+				},
+				index: 1,
+			},
+			{
+				name: "dangling parent",
+				msgs: []model.Message{
+					{Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+					{Role: "assistant", ParentID: &missing, PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+				},
+				index: 1,
+			},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := r.CreateMessagesBatch(ctx, ss.ID, tc.msgs)
+				var batchErr *BatchMessageError
+				require.ErrorAs(t, err, &batchErr)
+				assert.Equal(t, tc.index, batchErr.Index)
+				assert.Equal(t, before, countMessages())
+			})
+		}
+	})
+}
+
+// BenchmarkSessionRepo_CreateMessages compares a 1,000-message batch insert with inserting one at a time.
+func BenchmarkSessionRepo_CreateMessages(b *testing.B) {
+	db := setupSessionTestDB(b)
+	if db == nil {
+		return // Benchmark was skipped
+	}
+
+	logger := zap.NewNop()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "bench_hmac_batch",
+		SecretKeyHashPHC: "bench_hash_batch",
+	}
+	require.NoError(b, db.Create(project).Error)
+	defer cleanupSessionTestDB(b, db, project.ID)
+
+	require.NoError(b, db.AutoMigrate(&model.Message{}))
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	const n = 1000
+	chain := func() []model.Message {
+		msgs := make([]model.Message, n)
+		for i := range msgs {
+			msgs[i] = model.Message{ID: uuid.New(), Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}
+			if i > 0 {
+				msgs[i].ParentID = &msgs[i-1].ID
+			}
+		}
+		return msgs
+	}
+	newSession := func() uuid.UUID {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(b, db.Create(ss).Error)
+		return ss.ID
+	}
+
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			sessionID, msgs := newSession(), chain()
+			b.StartTimer()
+			require.NoError(b, r.CreateMessagesBatch(ctx, sessionID, msgs))
+		}
+	})
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			sessionID, msgs := newSession(), chain()
+			b.StartTimer()
+			for j := range msgs {
+				msgs[j].ID = uuid.Nil
+				msgs[j].SessionID = sessionID
+				require.NoError(b, r.CreateMessageWithAssets(ctx, &msgs[j], 0))
+			}
+		}
+	})
+}
+
+// BenchmarkSessionRepo_CreateMessages_IDVersion compares 1,000-message batch inserts with random
+// v4 and time-ordered v7 message IDs. Every run adds to the same table, so later batches insert
+// into a large primary key index, where v7 IDs append at its right edge.
+func BenchmarkSessionRepo_CreateMessages_IDVersion(b *testing.B) {
+	db := setupSessionTestDB(b)
+	if db == nil {
+		return // Benchmark was skipped
+	}
+
+	ctx := context.Background()
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "bench_hmac_idversion",
+		SecretKeyHashPHC: "bench_hash_idversion",
+	}
+	require.NoError(b, db.Create(project).Error)
+	defer cleanupSessionTestDB(b, db, project.ID)
+	defer model.UseUUIDv7MessageIDs(false)
+
+	require.NoError(b, db.AutoMigrate(&model.Message{}))
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, zap.NewNop())
+
+	const n = 1000
+	for _, v7 := range []bool{false, true} {
+		name := "v4"
+		if v7 {
+			name = "v7"
+		}
+		b.Run(name, func(b *testing.B) {
+			model.UseUUIDv7MessageIDs(v7)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+				require.NoError(b, db.Create(ss).Error)
+				msgs := make([]model.Message, n)
+				for j := range msgs {
+					msgs[j] = model.Message{Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})}
+				}
+				b.StartTimer()
+				require.NoError(b, r.CreateMessagesBatch(ctx, ss.ID, msgs))
+			}
+			b.ReportMetric(float64(b.N*n)/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
+
+func TestSessionRepo_TotalBytes(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_total_bytes",
+		SecretKeyHashPHC: "test_hash_total_bytes",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	totalBytes := func() int64 {
+		var got model.Session
+		require.NoError(t, db.First(&got, "id = ?", ss.ID).Error)
+		return got.TotalBytes
+	}
+	newMsg := func(size int64) *model.Message {
+		m := &model.Message{
+			SessionID:      ss.ID,
+			Role:           "user",
+			StorageBytes:   size,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "total-bytes-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, r.CreateMessageWithAssets(ctx, m, 0))
+		return m
+	}
+
+	first := newMsg(100)
+	newMsg(50)
+	assert.Equal(t, int64(150), totalBytes())
+
+	require.NoError(t, r.DeleteMessage(ctx, ss.ID, first.ID))
+	assert.Equal(t, int64(50), totalBytes())
+
+	require.NoError(t, r.RestoreMessage(ctx, ss.ID, first.ID))
+	assert.Equal(t, int64(150), totalBytes())
+
+	_, _, err := r.UpdateMessageParts(ctx, ss.ID, first.ID, func(m *model.Message) error {
+		m.StorageBytes = 30
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(80), totalBytes())
+
+	// A message stored before sizes were tracked must not drive the counter negative.
+	legacy := newMsg(0)
+	require.NoError(t, db.Model(&model.Session{}).Where("id = ?", ss.ID).Update("total_bytes", 0).Error)
+	require.NoError(t, db.Model(legacy).Update("storage_bytes", 500).Error)
+	require.NoError(t, r.DeleteMessage(ctx, ss.ID, legacy.ID))
+	assert.Equal(t, int64(0), totalBytes())
+
+	// A limit rejects the message that would pass it and stores nothing.
+	require.NoError(t, r.CreateMessageWithAssets(ctx, &model.Message{
+		SessionID: ss.ID, Role: "user", StorageBytes: 60,
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "total-bytes-sha-" + uuid.NewString()}),
+	}, 100))
+	over := &model.Message{
+		SessionID: ss.ID, Role: "user", StorageBytes: 50,
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "total-bytes-sha-" + uuid.NewString()}),
+	}
+	var quotaErr *SessionQuotaError
+	require.ErrorAs(t, r.CreateMessageWithAssets(ctx, over, 100), &quotaErr)
+	assert.Equal(t, SessionQuotaError{Usage: 60, Requested: 50, Limit: 100}, *quotaErr)
+	assert.Equal(t, int64(60), totalBytes())
+	var stored int64
+	require.NoError(t, db.Model(&model.Message{}).Where("id = ?", over.ID).Count(&stored).Error)
+	assert.Zero(t, stored)
+}
+
+func TestSessionRepo_IdempotencyKey(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_idempotency",
+		SecretKeyHashPHC: "test_hash_idempotency",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	newMsg := func(key string) *model.Message {
+		return &model.Message{
+			SessionID:      ss.ID,
+			Role:           "user",
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "idempotency-sha-" + uuid.NewString()}),
+			IdempotencyKey: &key,
+		}
+	}
+	countKey := func(key string) int64 {
+		var n int64
+		require.NoError(t, db.Model(&model.Message{}).
+			Where("session_id = ? AND idempotency_key = ?", ss.ID, key).Count(&n).Error)
+		return n
+	}
+
+	t.Run("concurrent creates store one row", func(t *testing.T) {
+		const key = "retry-1"
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = r.CreateMessageWithAssets(ctx, newMsg(key), 0)
+			}(i)
+		}
+		wg.Wait()
+
+		var created, duplicates int
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, ErrDuplicateIdempotencyKey):
+				duplicates++
+			default:
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		assert.Equal(t, 1, created)
+		assert.Equal(t, 1, duplicates)
+		assert.Equal(t, int64(1), countKey(key))
+
+		got, err := r.GetMessageByIdempotencyKey(ctx, ss.ID, key)
+		require.NoError(t, err)
+		assert.Equal(t, key, *got.IdempotencyKey)
+	})
+
+	t.Run("keys are scoped to the session", func(t *testing.T) {
+		other := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(other).Error)
+		m := newMsg("retry-1")
+		m.SessionID = other.ID
+		require.NoError(t, r.CreateMessageWithAssets(ctx, m, 0))
+	})
+
+	t.Run("expired keys are released", func(t *testing.T) {
+		const key = "retry-expired"
+		first := newMsg(key)
+		require.NoError(t, r.CreateMessageWithAssets(ctx, first, 0))
+		require.NoError(t, db.Model(first).UpdateColumn("created_at", time.Now().Add(-IdempotencyKeyTTL-time.Hour)).Error)
+
+		_, err := r.GetMessageByIdempotencyKey(ctx, ss.ID, key)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		require.NoError(t, r.CreateMessageWithAssets(ctx, newMsg(key), 0), "an expired key can be reused")
+		assert.Equal(t, int64(1), countKey(key))
+	})
+
+	t.Run("expire clears old keys", func(t *testing.T) {
+		const key = "retry-purge"
+		m := newMsg(key)
+		require.NoError(t, r.CreateMessageWithAssets(ctx, m, 0))
+		require.NoError(t, db.Model(m).UpdateColumn("created_at", time.Now().Add(-IdempotencyKeyTTL-time.Hour)).Error)
+
+		n, err := r.ExpireIdempotencyKeys(ctx, IdempotencyKeyTTL)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, n, int64(1))
+		assert.Equal(t, int64(0), countKey(key))
+	})
+}
+
+func TestSessionRepo_ListMessagesByRole(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_list_by_role",
+		SecretKeyHashPHC: "test_hash_list_by_role",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	base := time.Now().Add(-time.Hour)
+	var assistantIDs []uuid.UUID
+	for i := 0; i < 6; i++ {
+		role := model.RoleUser
+		if i%2 == 1 {
+			role = model.RoleAssistant
+		}
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      ss.ID,
+			Seq:            int64(i + 1),
+			Role:           role,
+			CreatedAt:      base.Add(time.Duration(i) * time.Second),
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "role-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		if role == model.RoleAssistant {
+			assistantIDs = append(assistantIDs, m.ID)
+		}
+	}
+
+	repo := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	roles := []string{model.RoleAssistant}
+
+	page, err := repo.ListBySessionWithCursor(ctx, ss.ID, 0, uuid.Nil, 2, false, roles, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, assistantIDs[:2], []uuid.UUID{page[0].ID, page[1].ID})
+
+	last := page[len(page)-1]
+	page, err = repo.ListBySessionWithCursor(ctx, ss.ID, last.Seq, last.ID, 2, false, roles, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+	require.NoError(t, err)
+	require.Len(t, page, 1, "the cursor continues within the filtered set")
+	assert.Equal(t, assistantIDs[2], page[0].ID)
+
+	all, err := repo.ListAllMessagesBySession(ctx, ss.ID, []string{model.RoleUser, model.RoleAssistant}, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+	require.NoError(t, err)
+	assert.Len(t, all, 6)
+
+	t.Run("created range", func(t *testing.T) {
+		createdIn := TimeRange{Since: base.Add(time.Second), Until: base.Add(4 * time.Second)}
+		page, err := repo.ListBySessionWithCursor(ctx, ss.ID, 0, uuid.Nil, 2, false, roles, nil, createdIn, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, assistantIDs[:2], []uuid.UUID{page[0].ID, page[1].ID})
+
+		page, err = repo.ListBySessionWithCursor(ctx, ss.ID, page[1].Seq, page[1].ID, 2, false, roles, nil, createdIn, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		assert.Empty(t, page, "the last assistant message was created after until")
+
+		all, err := repo.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{Since: base.Add(4 * time.Second)}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		assert.Len(t, all, 2)
+	})
+}
+
+func TestSessionRepo_UpdateSummary(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_update_summary",
+		SecretKeyHashPHC: "test_hash_update_summary",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}))
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	repo := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	first, second := uuid.New(), uuid.New()
+
+	require.NoError(t, repo.UpdateSummary(ctx, ss.ID, nil, "one", first))
+	assert.ErrorIs(t, repo.UpdateSummary(ctx, ss.ID, nil, "stale", second), ErrSummaryChanged)
+	require.NoError(t, repo.UpdateSummary(ctx, ss.ID, &first, "one two", second))
+
+	got, err := repo.Get(ctx, &model.Session{ID: ss.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "one two", got.Summary)
+	assert.Equal(t, second, *got.SummarizedUpToMessageID)
+}
+
+func TestSessionRepo_ReparentMessage(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_reparent",
+		SecretKeyHashPHC: "test_hash_reparent",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	newSession := func() *model.Session {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		return ss
+	}
+	newMsg := func(sessionID uuid.UUID, parent *uuid.UUID) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      sessionID,
+			Role:           model.RoleUser,
+			ParentID:       parent,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "reparent-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+
+	// root -> a -> b -> c, and root -> d
+	ss := newSession()
+	root := newMsg(ss.ID, nil)
+	a := newMsg(ss.ID, &root.ID)
+	b := newMsg(ss.ID, &a.ID)
+	c := newMsg(ss.ID, &b.ID)
+	d := newMsg(ss.ID, &root.ID)
+
+	repo := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	t.Run("moves the subtree", func(t *testing.T) {
+		depth, err := repo.ReparentMessage(ctx, ss.ID, b.ID, &d.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, depth)
+
+		chain, err := repo.GetMessageThread(ctx, ss.ID, c.ID)
+		require.NoError(t, err)
+		ids := make([]uuid.UUID, len(chain))
+		for i, m := range chain {
+			ids[i] = m.ID
+		}
+		assert.Equal(t, []uuid.UUID{root.ID, d.ID, b.ID, c.ID}, ids)
+	})
+
+	t.Run("rejects moving under a descendant", func(t *testing.T) {
+		_, err := repo.ReparentMessage(ctx, ss.ID, d.ID, &c.ID)
+		assert.ErrorIs(t, err, ErrReparentCycle)
+	})
+
+	t.Run("rejects a parent from another session", func(t *testing.T) {
+		other := newMsg(newSession().ID, nil)
+		_, err := repo.ReparentMessage(ctx, ss.ID, a.ID, &other.ID)
+		assert.ErrorIs(t, err, ErrMessageNotInSession)
+	})
+
+	t.Run("nil parent makes a root", func(t *testing.T) {
+		depth, err := repo.ReparentMessage(ctx, ss.ID, a.ID, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 0, depth)
+
+		var got model.Message
+		require.NoError(t, db.First(&got, "id = ?", a.ID).Error)
+		assert.Nil(t, got.ParentID)
+	})
+
+	t.Run("unknown message", func(t *testing.T) {
+		_, err := repo.ReparentMessage(ctx, ss.ID, uuid.New(), &root.ID)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestSessionRepo_MessageSeq(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_message_seq",
+		SecretKeyHashPHC: "test_hash_message_seq",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	t.Run("stores assign increasing seqs", func(t *testing.T) {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+
+		single := &model.Message{
+			SessionID:      ss.ID,
+			Role:           model.RoleUser,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "seq-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, r.CreateMessageWithAssets(ctx, single, 0))
+		assert.Equal(t, int64(1), single.Seq)
+
+		batch := []model.Message{
+			{Role: model.RoleAssistant, PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "seq-sha-" + uuid.NewString()})},
+			{Role: model.RoleUser, PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "seq-sha-" + uuid.NewString()})},
+		}
+		require.NoError(t, r.CreateMessagesBatch(ctx, ss.ID, batch))
+		assert.Equal(t, []int64{2, 3}, []int64{batch[0].Seq, batch[1].Seq})
+
+		var got model.Session
+		require.NoError(t, db.First(&got, "id = ?", ss.ID).Error)
+		assert.Equal(t, int64(3), got.LastMessageSeq)
+	})
+
+	t.Run("backfill numbers legacy messages by created_at", func(t *testing.T) {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+
+		base := time.Now().Add(-time.Hour)
+		ids := make([]uuid.UUID, 3)
+		for i := range ids {
+			m := &model.Message{
+				ID:             uuid.New(),
+				SessionID:      ss.ID,
+				Role:           model.RoleUser,
+				CreatedAt:      base.Add(time.Duration(i) * time.Second),
+				PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "seq-legacy-sha-" + uuid.NewString()}),
+			}
+			require.NoError(t, db.Create(m).Error)
+			ids[i] = m.ID
+		}
+
+		n, err := BackfillMessageSeqs(ctx, db, 1000)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, n, int64(1))
+
+		page, err := r.ListBySessionWithCursor(ctx, ss.ID, 0, uuid.Nil, 10, false, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		require.Len(t, page, 3)
+		for i, m := range page {
+			assert.Equal(t, ids[i], m.ID)
+			assert.Equal(t, int64(i+1), m.Seq)
+		}
+
+		next := &model.Message{
+			SessionID:      ss.ID,
+			Role:           model.RoleAssistant,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "seq-legacy-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, r.CreateMessageWithAssets(ctx, next, 0))
+		assert.Equal(t, int64(4), next.Seq, "new messages continue after the backfilled seqs")
+
+		n, err = BackfillMessageSeqs(ctx, db, 1000)
+		require.NoError(t, err)
+		assert.Zero(t, n, "backfill is idempotent")
+	})
+}
+
+func TestSessionRepo_Templates(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_templates",
+		SecretKeyHashPHC: "test_hash_templates",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}))
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	plain := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	tpl := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(plain).Error)
+	require.NoError(t, db.Create(tpl).Error)
+	require.NoError(t, r.SetTemplate(ctx, tpl.ID, true))
+
+	ids := func(includeTemplates bool) []uuid.UUID {
+		sessions, err := r.ListWithCursor(ctx, project.ID, "", nil, nil, nil, includeTemplates, false, time.Time{}, uuid.Nil, 10, false, SessionOrderCreatedAt, TimeRange{})
+		require.NoError(t, err)
+		var out []uuid.UUID
+		for _, s := range sessions {
+			out = append(out, s.ID)
+		}
+		return out
+	}
+	assert.Equal(t, []uuid.UUID{plain.ID}, ids(false))
+	assert.ElementsMatch(t, []uuid.UUID{plain.ID, tpl.ID}, ids(true))
+
+	require.NoError(t, r.SetTemplate(ctx, tpl.ID, false))
+	assert.Len(t, ids(false), 2)
+
+	assert.ErrorIs(t, r.SetTemplate(ctx, uuid.New(), true), gorm.ErrRecordNotFound)
+}
+
+func TestSessionRepo_MessageVersion(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_message_version",
+		SecretKeyHashPHC: "test_hash_message_version",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}, &model.MessageRevision{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	msg := &model.Message{
+		SessionID:      ss.ID,
+		Role:           "user",
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "version-sha-" + uuid.NewString()}),
+	}
+	require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+	assert.Equal(t, 1, msg.Version)
+
+	for want := 2; want <= 3; want++ {
+		updated, rev, err := r.UpdateMessageParts(ctx, ss.ID, msg.ID, func(m *model.Message) error {
+			assert.Equal(t, want-1, m.Version, "update sees the version being replaced")
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, want-1, rev.Version)
+		assert.Equal(t, want, updated.Version)
+	}
+
+	// A message edited before versions were stored catches up to its revisions.
+	require.NoError(t, db.Model(msg).Update("version", 1).Error)
+	n, err := BackfillMessageVersions(ctx, db, 10)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, n, int64(1))
+	got, err := r.GetMessageByID(ctx, ss.ID, msg.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, got.Version)
+}
+
+func TestSessionRepo_MessagePinning(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_message_pinning",
+		SecretKeyHashPHC: "test_hash_message_pinning",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	var msgs []*model.Message
+	var parentID *uuid.UUID
+	for i := 0; i < 3; i++ {
+		msg := &model.Message{
+			SessionID:      ss.ID,
+			ParentID:       parentID,
+			Role:           "user",
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "pinning-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+		msgs = append(msgs, msg)
+		parentID = &msg.ID
+	}
+
+	require.NoError(t, r.SetMessagePinned(ctx, ss.ID, msgs[2].ID, true))
+	require.NoError(t, r.SetMessagePinned(ctx, ss.ID, msgs[0].ID, true))
+	pinned, err := r.ListPinnedMessages(ctx, ss.ID)
+	require.NoError(t, err)
+	require.Len(t, pinned, 2)
+	assert.Equal(t, msgs[0].ID, pinned[0].ID, "pinned messages list in seq order")
+	assert.Equal(t, msgs[2].ID, pinned[1].ID)
+
+	require.NoError(t, r.SetMessagePinned(ctx, ss.ID, msgs[0].ID, false))
+	got, err := r.GetMessageByID(ctx, ss.ID, msgs[0].ID)
+	require.NoError(t, err)
+	assert.False(t, got.Pinned)
+	assert.Equal(t, msgs[0].Seq, got.Seq)
+	child, err := r.GetMessageByID(ctx, ss.ID, msgs[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, msgs[0].ID, *child.ParentID, "unpinning leaves children attached")
+
+	assert.ErrorIs(t, r.SetMessagePinned(ctx, uuid.New(), msgs[1].ID, true), gorm.ErrRecordNotFound)
+}
+
+func TestSessionRepo_ListMessageColumns(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_message_columns",
+		SecretKeyHashPHC: "test_hash_message_columns",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	msg := &model.Message{
+		SessionID:      ss.ID,
+		Role:           "user",
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "columns-sha-" + uuid.NewString()}),
+	}
+	require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+
+	columns := model.MessageFieldsColumns([]string{"role"})
+	page, err := r.ListBySessionWithCursor(ctx, ss.ID, 0, uuid.Nil, 10, false, nil, columns, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, msg.ID, page[0].ID)
+	assert.Equal(t, "user", page[0].Role)
+	assert.Equal(t, uuid.Nil, page[0].SessionID, "unselected columns stay zero")
+	assert.Empty(t, page[0].PartsAssetMeta.Data().SHA256)
+
+	all, err := r.ListAllMessagesBySession(ctx, ss.ID, nil, columns, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, msg.Seq, all[0].Seq)
+	assert.Empty(t, all[0].PartsAssetMeta.Data().SHA256)
+}
+
+func TestSessionRepo_MergeDuplicateMessages(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_merge_duplicates",
+		SecretKeyHashPHC: "test_hash_merge_duplicates",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	create := func(parentID *uuid.UUID, role string, createdAt time.Time) *model.Message {
+		msg := &model.Message{
+			SessionID:      ss.ID,
+			ParentID:       parentID,
+			Role:           role,
+			CreatedAt:      createdAt,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{SHA256: "merge-sha-" + uuid.NewString()}),
+		}
+		require.NoError(t, r.CreateMessageWithAssets(ctx, msg, 0))
+		return msg
+	}
+
+	// question <- retry <- retry2 <- reply, plus a deleted branch under the first retry.
+	base := time.Now().Add(-time.Hour).UTC().Truncate(time.Millisecond)
+	question := create(nil, "user", base.Add(time.Second))
+	retry := create(&question.ID, "user", base)
+	require.NoError(t, r.SetMessagePinned(ctx, ss.ID, retry.ID, true))
+	retry2 := create(&retry.ID, "user", base.Add(2*time.Second))
+	reply := create(&retry2.ID, "assistant", base.Add(3*time.Second))
+	deletedBranch := create(&retry.ID, "assistant", base.Add(4*time.Second))
+	require.NoError(t, r.DeleteMessage(ctx, ss.ID, deletedBranch.ID))
+
+	require.NoError(t, r.MergeDuplicateMessages(ctx, ss.ID, question.ID, []uuid.UUID{retry.ID, retry2.ID}))
+
+	got, err := r.GetMessageByID(ctx, ss.ID, question.ID)
+	require.NoError(t, err)
+	assert.True(t, got.CreatedAt.Equal(base), "survivor keeps the earliest created_at")
+	assert.True(t, got.Pinned, "a pinned duplicate keeps the survivor pinned")
+
+	child, err := r.GetMessageByID(ctx, ss.ID, reply.ID)
+	require.NoError(t, err)
+	require.NotNil(t, child.ParentID)
+	assert.Equal(t, question.ID, *child.ParentID, "children of duplicates move to the survivor")
+
+	thread, err := r.GetMessageThread(ctx, ss.ID, reply.ID)
+	require.NoError(t, err)
+	assert.Len(t, thread, 2, "the reply is not orphaned")
+
+	var hidden model.Message
+	require.NoError(t, db.Unscoped().Where("id = ?", deletedBranch.ID).First(&hidden).Error)
+	assert.Equal(t, question.ID, *hidden.ParentID, "deleted children are rewired too")
+
+	_, err = r.GetMessageByID(ctx, ss.ID, retry.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	assert.ErrorIs(t, r.MergeDuplicateMessages(ctx, ss.ID, question.ID, []uuid.UUID{retry.ID}), gorm.ErrRecordNotFound,
+		"already merged duplicates are gone")
+}
+
+// TestSessionRepo_CloneSessionShallow tests that clones read shared messages and never write them
+func TestSessionRepo_CloneSessionShallow(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_clone_shallow",
+		SecretKeyHashPHC: "test_hash_clone_shallow",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+
+	source := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(source).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	newMsg := func(sessionID uuid.UUID, parent *uuid.UUID) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      sessionID,
+			Role:           "user",
+			ParentID:       parent,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		}
+		require.NoError(t, r.CreateMessageWithAssets(ctx, m, 0))
+		return m
+	}
+	root := newMsg(source.ID, nil)
+	mid := newMsg(source.ID, &root.ID)
+	tail := newMsg(source.ID, &mid.ID)
+
+	result, err := r.CloneSessionShallow(ctx, source.ID, mid.ID)
+	require.NoError(t, err)
+	assert.Equal(t, source.ID, result.OldSessionID)
+	assert.Equal(t, mid.ID, result.BaseMessageID)
+	assert.Equal(t, 2, result.SharedMessages)
+
+	var ownCount int64
+	require.NoError(t, db.Model(&model.Message{}).Where("session_id = ?", result.NewSessionID).Count(&ownCount).Error)
+	assert.Zero(t, ownCount, "a shallow clone copies no rows")
+
+	t.Run("clone lists the shared chain", func(t *testing.T) {
+		msgs, err := r.ListAllMessagesBySession(ctx, result.NewSessionID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		assert.Equal(t, root.ID, msgs[0].ID)
+		assert.Equal(t, mid.ID, msgs[1].ID)
+	})
+
+	t.Run("first clone message continues from the base", func(t *testing.T) {
+		reply := newMsg(result.NewSessionID, nil)
+		require.NotNil(t, reply.ParentID)
+		assert.Equal(t, mid.ID, *reply.ParentID)
+		assert.Greater(t, reply.Seq, mid.Seq)
+
+		msgs, err := r.ListAllMessagesBySession(ctx, source.ID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		assert.Len(t, msgs, 3, "the source does not see the clone's messages")
+	})
+
+	t.Run("shared messages cannot be edited from either side", func(t *testing.T) {
+		assert.ErrorIs(t, r.DeleteMessage(ctx, result.NewSessionID, mid.ID), ErrMessageShared)
+		assert.ErrorIs(t, r.DeleteMessage(ctx, source.ID, root.ID), ErrMessageShared)
+		assert.NoError(t, r.DeleteMessage(ctx, source.ID, tail.ID), "unshared messages stay writable")
+	})
+
+	t.Run("purging the source is refused", func(t *testing.T) {
+		_, err := r.ArchiveSession(ctx, project.ID, source.ID, "archives/clone.json", true, nil,
+			func(*SessionArchive) error { return nil })
+		assert.ErrorIs(t, err, ErrSessionShared)
+	})
+
+	t.Run("deleting the source hands the chain to the clone", func(t *testing.T) {
+		_, err := r.DeleteSessionCascade(ctx, project.ID, source.ID, nil)
+		require.NoError(t, err)
+
+		msgs, err := r.ListAllMessagesBySession(ctx, result.NewSessionID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		require.Len(t, msgs, 3)
+		for _, m := range msgs {
+			assert.Equal(t, result.NewSessionID, m.SessionID)
+		}
+
+		var clone model.Session
+		require.NoError(t, db.First(&clone, "id = ?", result.NewSessionID).Error)
+		assert.Nil(t, clone.BaseMessageID)
+		assert.Nil(t, clone.BaseSessionID)
+	})
+}
+
+// TestSessionRepo_GetMessageTreeStats tests depth and child counts across reparenting and deletes
+func TestSessionRepo_GetMessageTreeStats(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_tree_stats",
+		SecretKeyHashPHC: "test_hash_tree_stats",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	newMsg := func(parent *uuid.UUID) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      ss.ID,
+			Role:           model.RoleUser,
+			ParentID:       parent,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	// root -> a -> b, root -> c
+	root := newMsg(nil)
+	a := newMsg(&root.ID)
+	b := newMsg(&a.ID)
+	c := newMsg(&root.ID)
+	ids := []uuid.UUID{root.ID, a.ID, b.ID, c.ID}
+
+	stats, err := r.GetMessageTreeStats(ctx, ss.ID, ids)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]model.MessageTreeStats{
+		root.ID: {Depth: 0, ChildCount: 2},
+		a.ID:    {Depth: 1, ChildCount: 1},
+		b.ID:    {Depth: 2, ChildCount: 0},
+		c.ID:    {Depth: 1, ChildCount: 0},
+	}, stats)
+
+	t.Run("reparenting moves the subtree", func(t *testing.T) {
+		_, err := r.ReparentMessage(ctx, ss.ID, a.ID, &c.ID)
+		require.NoError(t, err)
+
+		stats, err := r.GetMessageTreeStats(ctx, ss.ID, ids)
+		require.NoError(t, err)
+		assert.Equal(t, model.MessageTreeStats{Depth: 0, ChildCount: 1}, stats[root.ID])
+		assert.Equal(t, model.MessageTreeStats{Depth: 1, ChildCount: 1}, stats[c.ID])
+		assert.Equal(t, model.MessageTreeStats{Depth: 2, ChildCount: 1}, stats[a.ID])
+		assert.Equal(t, model.MessageTreeStats{Depth: 3, ChildCount: 0}, stats[b.ID])
+	})
+
+	t.Run("deleted children are not counted", func(t *testing.T) {
+		require.NoError(t, r.DeleteMessage(ctx, ss.ID, a.ID))
+
+		stats, err := r.GetMessageTreeStats(ctx, ss.ID, []uuid.UUID{c.ID, b.ID})
+		require.NoError(t, err)
+		assert.Equal(t, model.MessageTreeStats{Depth: 1, ChildCount: 0}, stats[c.ID])
+		assert.Equal(t, 3, stats[b.ID].Depth, "a deleted ancestor keeps its place")
+	})
+
+	t.Run("forked copies start their own tree", func(t *testing.T) {
+		result, err := r.ForkSession(ctx, ss.ID, c.ID, nil)
+		require.NoError(t, err)
+
+		newC := result.MessageIDMap[c.ID]
+		stats, err := r.GetMessageTreeStats(ctx, result.NewSessionID, []uuid.UUID{newC})
+		require.NoError(t, err)
+		assert.Equal(t, model.MessageTreeStats{Depth: 1, ChildCount: 0}, stats[newC])
+	})
+}
+
+func TestSessionRepo_DeleteMessages(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_bulk_delete",
+		SecretKeyHashPHC: "test_hash_bulk_delete",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	newSession := func() *model.Session {
+		ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(ss).Error)
+		return ss
+	}
+	newMsg := func(sessionID uuid.UUID, role string, parent *uuid.UUID) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      sessionID,
+			Role:           role,
+			ParentID:       parent,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	parentOf := func(id uuid.UUID) *uuid.UUID {
+		var m model.Message
+		require.NoError(t, db.Unscoped().First(&m, "id = ?", id).Error)
+		return m.ParentID
+	}
+
+	t.Run("orphans move to the nearest surviving ancestor", func(t *testing.T) {
+		// root(user) -> a(assistant) -> b(assistant) -> c(user)
+		ss := newSession()
+		root := newMsg(ss.ID, model.RoleUser, nil)
+		a := newMsg(ss.ID, model.RoleAssistant, &root.ID)
+		b := newMsg(ss.ID, model.RoleAssistant, &a.ID)
+		c := newMsg(ss.ID, model.RoleUser, &b.ID)
+
+		out, err := r.DeleteMessages(ctx, ss.ID, MessageFilter{Roles: []string{model.RoleAssistant}}, false)
+		require.NoError(t, err)
+		assert.Equal(t, &DeleteMessagesResult{Deleted: 2, Reparented: 1}, out)
+		assert.Equal(t, &root.ID, parentOf(c.ID))
+
+		msgs, err := r.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+		require.NoError(t, err)
+		assert.Len(t, msgs, 2)
+	})
+
+	t.Run("deleting a root makes its children roots", func(t *testing.T) {
+		ss := newSession()
+		root := newMsg(ss.ID, model.RoleAssistant, nil)
+		child := newMsg(ss.ID, model.RoleUser, &root.ID)
+
+		_, err := r.DeleteMessages(ctx, ss.ID, MessageFilter{Roles: []string{model.RoleAssistant}}, false)
+		require.NoError(t, err)
+		assert.Nil(t, parentOf(child.ID))
+	})
+
+	t.Run("cascade deletes descendants", func(t *testing.T) {
+		ss := newSession()
+		root := newMsg(ss.ID, model.RoleUser, nil)
+		a := newMsg(ss.ID, model.RoleAssistant, &root.ID)
+		newMsg(ss.ID, model.RoleUser, &a.ID)
+
+		out, err := r.DeleteMessages(ctx, ss.ID, MessageFilter{Roles: []string{model.RoleAssistant}}, true)
+		require.NoError(t, err)
+		assert.Equal(t, &DeleteMessagesResult{Deleted: 2}, out)
+	})
+
+	t.Run("time range and flagged", func(t *testing.T) {
+		ss := newSession()
+		old := newMsg(ss.ID, model.RoleUser, nil)
+		recent := newMsg(ss.ID, model.RoleUser, &old.ID)
+		newMsg(ss.ID, model.RoleUser, &recent.ID)
+		past := time.Now().Add(-31 * 24 * time.Hour)
+		require.NoError(t, db.Model(&model.Message{}).Where("id = ?", old.ID).UpdateColumn("created_at", past).Error)
+		require.NoError(t, db.Model(&model.Message{}).Where("id = ?", recent.ID).UpdateColumn("flagged", true).Error)
+
+		out, err := r.DeleteMessages(ctx, ss.ID, MessageFilter{CreatedIn: TimeRange{Until: time.Now().Add(-30 * 24 * time.Hour)}}, false)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), out.Deleted)
+		assert.Nil(t, parentOf(recent.ID))
+
+		flagged := true
+		out, err = r.DeleteMessages(ctx, ss.ID, MessageFilter{Flagged: &flagged}, false)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), out.Deleted)
+	})
+
+	t.Run("shared messages are not deleted", func(t *testing.T) {
+		ss := newSession()
+		root := newMsg(ss.ID, model.RoleUser, nil)
+		_, err := r.CloneSessionShallow(ctx, ss.ID, root.ID)
+		require.NoError(t, err)
+
+		_, err = r.DeleteMessages(ctx, ss.ID, MessageFilter{Roles: []string{model.RoleUser}}, false)
+		assert.ErrorIs(t, err, ErrMessageShared)
+		assert.Nil(t, parentOf(root.ID))
+	})
+}
+
+func TestSessionRepo_ListMessagesByAuthor(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_message_author",
+		SecretKeyHashPHC: "test_hash_message_author",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	ss := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+	require.NoError(t, db.Create(ss).Error)
+
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+	planner, coder := uuid.New(), uuid.New()
+	newMsg := func(authorID *uuid.UUID, authorType string) *model.Message {
+		m := &model.Message{
+			ID:             uuid.New(),
+			SessionID:      ss.ID,
+			Role:           model.RoleAssistant,
+			AuthorID:       authorID,
+			AuthorType:     authorType,
+			PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		}
+		require.NoError(t, db.Create(m).Error)
+		return m
+	}
+	legacy := newMsg(nil, "")
+	fromPlanner := newMsg(&planner, model.AuthorTypeAgent)
+	fromCoder := newMsg(&coder, model.AuthorTypeAgent)
+	fromSystem := newMsg(nil, model.AuthorTypeSystem)
+
+	ids := func(msgs []model.Message) []uuid.UUID {
+		out := make([]uuid.UUID, len(msgs))
+		for i, m := range msgs {
+			out[i] = m.ID
+		}
+		return out
+	}
+
+	all, err := r.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{}, AuthorFilter{}, AnnotationFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{legacy.ID, fromPlanner.ID, fromCoder.ID, fromSystem.ID}, ids(all))
+
+	var stored model.Message
+	require.NoError(t, db.First(&stored, "id = ?", legacy.ID).Error)
+	assert.Nil(t, stored.AuthorID)
+	assert.Empty(t, stored.AuthorType)
+	var nullType int64
+	require.NoError(t, db.Model(&model.Message{}).Where("id = ? AND author_type IS NULL", legacy.ID).Count(&nullType).Error)
+	assert.Equal(t, int64(1), nullType, "unattributed messages keep a null author")
+
+	agents, err := r.ListAllMessagesBySession(ctx, ss.ID, nil, nil, TimeRange{}, AuthorFilter{Type: model.AuthorTypeAgent}, AnnotationFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{fromPlanner.ID, fromCoder.ID}, ids(agents))
+
+	page, err := r.ListBySessionWithCursor(ctx, ss.ID, 0, uuid.Nil, 10, false, []string{model.RoleAssistant}, nil, TimeRange{}, AuthorFilter{ID: &coder}, AnnotationFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{fromCoder.ID}, ids(page))
+}
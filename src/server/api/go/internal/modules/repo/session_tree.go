@@ -0,0 +1,190 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons ValidateTree reports a parent link as broken.
+const (
+	// BrokenParentMissing is a ParentID naming no message at all.
+	BrokenParentMissing = "missing"
+	// BrokenParentCrossSession is a ParentID naming a message of another session, other than the
+	// base message a shallow clone continues from.
+	BrokenParentCrossSession = "cross_session"
+)
+
+// BrokenParentLink is a live message whose ParentID ValidateTree rejects.
+type BrokenParentLink struct {
+	MessageID uuid.UUID `json:"message_id"`
+	ParentID  uuid.UUID `json:"parent_id"`
+	// ParentSessionID is the session owning the parent; it is nil when the parent is missing.
+	ParentSessionID *uuid.UUID `json:"parent_session_id,omitempty"`
+	Reason          string     `json:"reason"`
+	// CreatedAt is the creation time of the message.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TreeRepairMode selects what RepairTree does with the messages of broken parent links.
+type TreeRepairMode string
+
+const (
+	// TreeRepairReattach moves each orphan under the session root: the base message of a shallow
+	// clone, else the session's first live root message. Without either the orphans become roots.
+	TreeRepairReattach TreeRepairMode = "reattach"
+	// TreeRepairDelete soft-deletes each orphan together with its live descendants.
+	TreeRepairDelete TreeRepairMode = "delete"
+)
+
+// RepairTreeResult reports what RepairTree changed.
+type RepairTreeResult struct {
+	Repaired []BrokenParentLink `json:"repaired"`
+	// RootID is the message a reattach repair moved the orphans under; nil when they became roots.
+	RootID *uuid.UUID `json:"root_id,omitempty"`
+	// Deleted counts the messages a delete repair soft-deleted, descendants included.
+	Deleted int64 `json:"deleted"`
+}
+
+// ValidateTree returns the live messages of the session whose ParentID names a message that does
+// not exist or belongs to another session, in seq order. Parents that are soft-deleted are valid:
+// deleting a message leaves its children pointing at it. Creation rejects such links, so they only
+// come from legacy rows or imports that stopped partway.
+func (r *sessionRepo) ValidateTree(ctx context.Context, sessionID uuid.UUID) ([]BrokenParentLink, error) {
+	return brokenParentLinks(r.db.WithContext(ctx), sessionID)
+}
+
+// RepairTree fixes the links ValidateTree reports, as mode says, in one transaction and returns
+// them. A message.reparented event is emitted for each reattached orphan and a message.deleted
+// event for each deleted message. The session row is locked like in ReparentMessage. An orphan
+// whose row a shallow clone shares returns ErrMessageShared and changes nothing.
+func (r *sessionRepo) RepairTree(ctx context.Context, sessionID uuid.UUID, mode TreeRepairMode) (*RepairTreeResult, error) {
+	result := &RepairTreeResult{Repaired: []BrokenParentLink{}}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var session model.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "base_message_id").
+			Where("id = ?", sessionID).First(&session).Error; err != nil {
+			return err
+		}
+		links, err := brokenParentLinks(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, len(links))
+		for i, l := range links {
+			ids[i] = l.MessageID
+		}
+		if mode == TreeRepairDelete {
+			descendants, err := liveDescendantIDs(tx, sessionID, ids)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, descendants...)
+		}
+		cloned, err := clonedMessageIDs(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		for _, id := range cloned {
+			if slices.Contains(ids, id) {
+				return ErrMessageShared
+			}
+		}
+
+		switch mode {
+		case TreeRepairReattach:
+			root := session.BaseMessageID
+			if root == nil {
+				var roots []uuid.UUID
+				if err := tx.Model(&model.Message{}).
+					Where("session_id = ? AND parent_id IS NULL", sessionID).
+					Order("seq ASC, id ASC").Limit(1).
+					Pluck("id", &roots).Error; err != nil {
+					return fmt.Errorf("query session root: %w", err)
+				}
+				if len(roots) > 0 {
+					root = &roots[0]
+				}
+			}
+			for _, l := range links {
+				if err := tx.Model(&model.Message{}).Where("id = ?", l.MessageID).
+					Updates(map[string]interface{}{"parent_id": root, "updated_at": time.Now()}).Error; err != nil {
+					return fmt.Errorf("reattach message %s: %w", l.MessageID, err)
+				}
+				if err := notifyMessageStream(tx, model.MessageStreamEvent{
+					Type:      model.StreamEventMessageReparented,
+					SessionID: sessionID,
+					MessageID: l.MessageID,
+					ParentID:  root,
+				}); err != nil {
+					return err
+				}
+			}
+			result.RootID = root
+		case TreeRepairDelete:
+			var deleted []model.Message
+			if err := tx.Select("id", "parent_id", "storage_bytes").Where("id IN ?", ids).Find(&deleted).Error; err != nil {
+				return fmt.Errorf("load orphaned messages: %w", err)
+			}
+			res := tx.Where("id IN ?", ids).Delete(&model.Message{})
+			if res.Error != nil {
+				return fmt.Errorf("delete orphaned messages: %w", res.Error)
+			}
+			result.Deleted = res.RowsAffected
+			for _, m := range deleted {
+				if err := notifyMessageStream(tx, model.MessageStreamEvent{
+					Type:      model.StreamEventMessageDeleted,
+					SessionID: sessionID,
+					MessageID: m.ID,
+					ParentID:  m.ParentID,
+				}); err != nil {
+					return err
+				}
+			}
+			if err := addSessionBytes(tx, sessionID, -sumStorageBytes(deleted)); err != nil {
+				return err
+			}
+			if err := addSessionMessages(tx, sessionID, -int(res.RowsAffected), time.Time{}); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown tree repair mode %q", mode)
+		}
+		result.Repaired = links
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// brokenParentLinks returns the live messages of the session with a parent that is missing or in
+// another session; a shallow clone's link to its base message is exempt.
+func brokenParentLinks(db *gorm.DB, sessionID uuid.UUID) ([]BrokenParentLink, error) {
+	links := []BrokenParentLink{}
+	err := db.Raw(`
+		SELECT m.id AS message_id, m.parent_id, p.session_id AS parent_session_id, m.created_at,
+			CASE WHEN p.id IS NULL THEN ? ELSE ? END AS reason
+		FROM messages m
+		JOIN sessions s ON s.id = m.session_id
+		LEFT JOIN messages p ON p.id = m.parent_id
+		WHERE m.session_id = ? AND m.deleted_at IS NULL AND m.parent_id IS NOT NULL
+			AND (p.id IS NULL OR (p.session_id <> m.session_id AND m.parent_id IS DISTINCT FROM s.base_message_id))
+		ORDER BY m.seq ASC, m.id ASC`,
+		BrokenParentMissing, BrokenParentCrossSession, sessionID,
+	).Scan(&links).Error
+	if err != nil {
+		return nil, fmt.Errorf("query broken parent links: %w", err)
+	}
+	return links, nil
+}
@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func TestSessionRepo_ValidateAndRepairTree(t *testing.T) {
+	db := setupSessionTestDB(t)
+	if db == nil {
+		return // Test was skipped
+	}
+
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	project := &model.Project{
+		ID:               uuid.New(),
+		SecretKeyHMAC:    "test_hmac_tree",
+		SecretKeyHashPHC: "test_hash_tree",
+	}
+	require.NoError(t, db.Create(project).Error)
+	defer cleanupSessionTestDB(t, db, project.ID)
+
+	require.NoError(t, db.AutoMigrate(&model.Session{}, &model.Message{}))
+	r := NewSessionRepo(db, &MockAssetReferenceRepoForCopy{}, nil, logger)
+
+	// corrupt builds root <- a <- a2 and root <- b, then points a at a message of another session
+	// and b at a message that does not exist, as a partial import would leave them.
+	corrupt := func(t *testing.T) (session *model.Session, root, a, a2, b, foreign uuid.UUID) {
+		session = &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		other := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		for _, s := range []*model.Session{session, other} {
+			require.NoError(t, db.Create(s).Error)
+		}
+		root, a, a2, b, foreign = uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+		require.NoError(t, r.CreateMessagesBatch(ctx, session.ID, []model.Message{
+			{ID: root, Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{}), StorageBytes: 10},
+			{ID: a, ParentID: &root, Role: "assistant", PartsAssetMeta: datatypes.NewJSONType(model.Asset{}), StorageBytes: 10},
+			{ID: a2, ParentID: &a, Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{}), StorageBytes: 10},
+			{ID: b, ParentID: &root, Role: "assistant", PartsAssetMeta: datatypes.NewJSONType(model.Asset{}), StorageBytes: 10},
+		}))
+		require.NoError(t, r.CreateMessagesBatch(ctx, other.ID, []model.Message{
+			{ID: foreign, Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+		}))
+
+		require.NoError(t, db.Model(&model.Message{}).Where("id = ?", a).UpdateColumn("parent_id", foreign).Error)
+		require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+			// Skip the parent foreign key to store a dangling link.
+			if err := tx.Exec("SET LOCAL session_replication_role = replica").Error; err != nil {
+				return err
+			}
+			return tx.Model(&model.Message{}).Where("id = ?", b).UpdateColumn("parent_id", uuid.New()).Error
+		}))
+		return session, root, a, a2, b, foreign
+	}
+	load := func(id uuid.UUID) model.Session {
+		var s model.Session
+		require.NoError(t, db.Where("id = ?", id).First(&s).Error)
+		return s
+	}
+
+	t.Run("validate reports missing and cross-session parents", func(t *testing.T) {
+		session, _, a, _, b, foreign := corrupt(t)
+
+		links, err := r.ValidateTree(ctx, session.ID)
+		require.NoError(t, err)
+		require.Len(t, links, 2)
+		assert.Equal(t, a, links[0].MessageID)
+		assert.Equal(t, foreign, links[0].ParentID)
+		assert.Equal(t, BrokenParentCrossSession, links[0].Reason)
+		require.NotNil(t, links[0].ParentSessionID)
+		assert.Equal(t, b, links[1].MessageID)
+		assert.Equal(t, BrokenParentMissing, links[1].Reason)
+		assert.Nil(t, links[1].ParentSessionID)
+	})
+
+	t.Run("reattach moves orphans under the root", func(t *testing.T) {
+		session, root, a, a2, b, _ := corrupt(t)
+
+		result, err := r.RepairTree(ctx, session.ID, TreeRepairReattach)
+		require.NoError(t, err)
+		assert.Len(t, result.Repaired, 2)
+		require.NotNil(t, result.RootID)
+		assert.Equal(t, root, *result.RootID)
+
+		for _, id := range []uuid.UUID{a, b} {
+			var m model.Message
+			require.NoError(t, db.Where("id = ?", id).First(&m).Error)
+			require.NotNil(t, m.ParentID)
+			assert.Equal(t, root, *m.ParentID)
+		}
+		var child model.Message
+		require.NoError(t, db.Where("id = ?", a2).First(&child).Error)
+		assert.Equal(t, a, *child.ParentID, "descendants keep their parent")
+
+		links, err := r.ValidateTree(ctx, session.ID)
+		require.NoError(t, err)
+		assert.Empty(t, links)
+		assert.Equal(t, 4, load(session.ID).MessageCount)
+	})
+
+	t.Run("delete removes orphans with their descendants", func(t *testing.T) {
+		session, root, _, _, _, _ := corrupt(t)
+
+		result, err := r.RepairTree(ctx, session.ID, TreeRepairDelete)
+		require.NoError(t, err)
+		assert.Len(t, result.Repaired, 2)
+		assert.Equal(t, int64(3), result.Deleted)
+
+		var live []uuid.UUID
+		require.NoError(t, db.Model(&model.Message{}).Where("session_id = ?", session.ID).Pluck("id", &live).Error)
+		assert.Equal(t, []uuid.UUID{root}, live)
+		s := load(session.ID)
+		assert.Equal(t, 1, s.MessageCount)
+		assert.Equal(t, int64(10), s.TotalBytes)
+	})
+
+	t.Run("intact tree is left alone", func(t *testing.T) {
+		session := &model.Session{ID: uuid.New(), ProjectID: project.ID}
+		require.NoError(t, db.Create(session).Error)
+		root := uuid.New()
+		require.NoError(t, r.CreateMessagesBatch(ctx, session.ID, []model.Message{
+			{ID: root, Role: "user", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+			{ParentID: &root, Role: "assistant", PartsAssetMeta: datatypes.NewJSONType(model.Asset{})},
+		}))
+
+		result, err := r.RepairTree(ctx, session.ID, TreeRepairDelete)
+		require.NoError(t, err)
+		assert.Empty(t, result.Repaired)
+		assert.Zero(t, result.Deleted)
+	})
+}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -35,14 +37,36 @@ type ArtifactService interface {
 }
 
 type artifactService struct {
-	r               repo.ArtifactRepo
-	s3              *blob.S3Deps
-	agentSkillsRepo repo.AgentSkillsRepo
-	log             *zap.Logger
+	r                  repo.ArtifactRepo
+	s3                 *blob.S3Deps
+	agentSkillsRepo    repo.AgentSkillsRepo
+	assetReferenceRepo repo.AssetReferenceRepo
+	log                *zap.Logger
 }
 
-func NewArtifactService(r repo.ArtifactRepo, s3 *blob.S3Deps, agentSkillsRepo repo.AgentSkillsRepo, log *zap.Logger) ArtifactService {
-	return &artifactService{r: r, s3: s3, agentSkillsRepo: agentSkillsRepo, log: log}
+func NewArtifactService(r repo.ArtifactRepo, s3 *blob.S3Deps, agentSkillsRepo repo.AgentSkillsRepo, assetReferenceRepo repo.AssetReferenceRepo, log *zap.Logger) ArtifactService {
+	return &artifactService{r: r, s3: s3, agentSkillsRepo: agentSkillsRepo, assetReferenceRepo: assetReferenceRepo, log: log}
+}
+
+// storeContent uploads content for a new artifact. When the project already stores an asset with
+// the same content hash, that asset is reused instead and deduplicated is true. Encrypted uploads
+// are never shared, matching S3Deps deduplication.
+func (s *artifactService) storeContent(ctx context.Context, projectID uuid.UUID, filename string, content []byte, userKEK []byte) (asset *model.Asset, deduplicated bool, err error) {
+	if userKEK == nil && s.assetReferenceRepo != nil {
+		sum := sha256.Sum256(content)
+		existing, err := s.assetReferenceRepo.FindAssetByHash(ctx, projectID, hex.EncodeToString(sum[:]))
+		if err != nil {
+			s.log.Warn("asset hash lookup failed, uploading", zap.Error(err))
+		} else if existing != nil {
+			return existing, true, nil
+		}
+	}
+
+	asset, err = s.s3.UploadBytes(ctx, "disks/"+projectID.String(), filename, content, userKEK)
+	if err != nil {
+		return nil, false, err
+	}
+	return asset, false, nil
 }
 
 // touchSkillUpdatedAt is best-effort: logs a warning on failure but does not propagate the error.
@@ -89,7 +113,17 @@ func (s *artifactService) Create(ctx context.Context, in CreateArtifactInput) (*
 		}
 	}
 
-	asset, err := s.s3.UploadFormFile(ctx, "disks/"+in.ProjectID.String(), in.FileHeader, in.UserKEK)
+	file, err := in.FileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open uploaded file: %w", err)
+	}
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read uploaded file: %w", err)
+	}
+
+	asset, deduplicated, err := s.storeContent(ctx, in.ProjectID, in.FileHeader.Filename, content, in.UserKEK)
 	if err != nil {
 		return nil, fmt.Errorf("upload file to S3: %w", err)
 	}
@@ -99,16 +133,9 @@ func (s *artifactService) Create(ctx context.Context, in CreateArtifactInput) (*
 	var textContent string
 	parser := fileparser.NewFileParser()
 	if parser.CanParseFile(in.FileHeader.Filename, asset.MIME) {
-		file, err := in.FileHeader.Open()
-		if err == nil {
-			content, readErr := io.ReadAll(file)
-			file.Close()
-			if readErr == nil {
-				fileContent, parseErr := parser.ParseFile(in.FileHeader.Filename, asset.MIME, content)
-				if parseErr == nil && fileContent != nil {
-					textContent = fileContent.Raw
-				}
-			}
+		fileContent, parseErr := parser.ParseFile(in.FileHeader.Filename, asset.MIME, content)
+		if parseErr == nil && fileContent != nil {
+			textContent = fileContent.Raw
 		}
 	}
 	encoded, err := encryptionpkg.EncodeContent(in.UserKEK, textContent)
@@ -141,6 +168,7 @@ func (s *artifactService) Create(ctx context.Context, in CreateArtifactInput) (*
 	if err := s.r.Create(ctx, in.ProjectID, artifact); err != nil {
 		return nil, fmt.Errorf("create artifact record: %w", err)
 	}
+	artifact.Deduplicated = deduplicated
 
 	s.touchSkillUpdatedAt(ctx, in.DiskID)
 	return artifact, nil
@@ -159,7 +187,7 @@ func (s *artifactService) CreateFromBytes(ctx context.Context, in CreateArtifact
 	}
 
 	// Upload bytes to S3 with deduplication
-	asset, err := s.s3.UploadBytes(ctx, "disks/"+in.ProjectID.String(), in.Filename, in.Content, in.UserKEK)
+	asset, deduplicated, err := s.storeContent(ctx, in.ProjectID, in.Filename, in.Content, in.UserKEK)
 	if err != nil {
 		return nil, fmt.Errorf("upload bytes to S3: %w", err)
 	}
@@ -201,6 +229,7 @@ func (s *artifactService) CreateFromBytes(ctx context.Context, in CreateArtifact
 	if err := s.r.Create(ctx, in.ProjectID, artifact); err != nil {
 		return nil, fmt.Errorf("create artifact record: %w", err)
 	}
+	artifact.Deduplicated = deduplicated
 
 	s.touchSkillUpdatedAt(ctx, in.DiskID)
 	return artifact, nil
@@ -839,3 +839,35 @@ func TestArtifactService_GlobArtifacts(t *testing.T) {
 		})
 	}
 }
+
+func TestArtifactService_CreateFromBytes_Deduplicated(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	diskID := uuid.New()
+	content := []byte("shared content")
+	stored := &model.Asset{Bucket: "b", S3Key: "assets/p/2024/01/01/abc.txt", SHA256: "abc", MIME: "text/plain", SizeB: int64(len(content))}
+
+	mockRepo := &MockArtifactRepo{}
+	mockRepo.On("ExistsByPathAndFilename", ctx, diskID, "/", "copy.txt", (*uuid.UUID)(nil)).Return(false, nil)
+	mockRepo.On("Create", ctx, projectID, mock.MatchedBy(func(a *model.Artifact) bool {
+		return a.AssetMeta.Data().S3Key == stored.S3Key
+	})).Return(nil)
+	assetRefs := &MockAssetReferenceRepo{}
+	assetRefs.On("FindAssetByHash", ctx, projectID, mock.AnythingOfType("string")).Return(stored, nil)
+
+	// s3 stays nil: a deduplicated upload must not touch storage.
+	svc := &artifactService{r: mockRepo, assetReferenceRepo: assetRefs, log: zap.NewNop()}
+	artifact, err := svc.CreateFromBytes(ctx, CreateArtifactFromBytesInput{
+		ProjectID: projectID,
+		DiskID:    diskID,
+		Path:      "/",
+		Filename:  "copy.txt",
+		Content:   content,
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, artifact.Deduplicated)
+	assert.Equal(t, "shared content", artifact.AssetMeta.Data().Content)
+	mockRepo.AssertExpectations(t)
+	assetRefs.AssertExpectations(t)
+}
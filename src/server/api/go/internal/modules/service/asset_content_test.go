@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+
+	"github.com/memodb-io/Acontext/internal/infra/blob"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// fakeContentStore serves one object, as ciphertext when encrypted is set.
+type fakeContentStore struct {
+	data      []byte
+	encrypted bool
+	opened    []*blob.ByteRange
+	downloads int
+}
+
+func (f *fakeContentStore) HeadObject(ctx context.Context, key string) (*blob.ObjectInfo, error) {
+	if f.data == nil {
+		return nil, blob.ErrObjectNotFound
+	}
+	size := int64(len(f.data))
+	if f.encrypted {
+		size += 28 // nonce and tag
+	}
+	return &blob.ObjectInfo{Size: size, ContentType: "application/octet-stream", ETag: "etag", Encrypted: f.encrypted}, nil
+}
+
+func (f *fakeContentStore) OpenObject(ctx context.Context, key string, rng *blob.ByteRange) (io.ReadCloser, error) {
+	f.opened = append(f.opened, rng)
+	data := f.data
+	if rng != nil {
+		data = data[rng.Start : rng.End+1]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *fakeContentStore) DownloadFile(ctx context.Context, key string, userKEK []byte) ([]byte, error) {
+	if userKEK == nil {
+		return nil, errors.New("encrypted object but no user KEK provided")
+	}
+	f.downloads++
+	return f.data, nil
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		header        string
+		want          *blob.ByteRange
+		unsatisfiable bool
+	}{
+		{header: "", want: nil},
+		{header: "bytes=0-99", want: &blob.ByteRange{Start: 0, End: 99}},
+		{header: "bytes=10-", want: &blob.ByteRange{Start: 10, End: 99}},
+		{header: "bytes=90-500", want: &blob.ByteRange{Start: 90, End: 99}},
+		{header: "bytes=-10", want: &blob.ByteRange{Start: 90, End: 99}},
+		{header: "bytes=-500", want: &blob.ByteRange{Start: 0, End: 99}},
+		{header: "bytes=100-", unsatisfiable: true},
+		{header: "bytes=-0", unsatisfiable: true},
+		{header: "bytes=0-1,5-9", want: nil},
+		{header: "bytes=9-1", want: nil},
+		{header: "items=0-1", want: nil},
+		{header: "bytes=abc", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRangeHeader(tt.header, 100)
+			if tt.unsatisfiable {
+				var rerr *RangeNotSatisfiableError
+				require.ErrorAs(t, err, &rerr)
+				assert.Equal(t, int64(100), rerr.Size)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAssetContentService_OpenAssetContent(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	assetID := uuid.New()
+	data := []byte("0123456789")
+
+	newRefs := func() *MockAssetReferenceRepo {
+		refs := &MockAssetReferenceRepo{}
+		refs.On("GetByIDs", ctx, projectID, []uuid.UUID{assetID}).Return([]model.AssetReference{{
+			ID: assetID, ProjectID: projectID, S3Key: "assets/p/clip.mp4",
+			AssetMeta: datatypes.NewJSONType(model.Asset{MIME: "video/mp4"}),
+		}}, nil)
+		return refs
+	}
+	read := func(t *testing.T, c *AssetContent) string {
+		defer c.Body.Close()
+		b, err := io.ReadAll(c.Body)
+		require.NoError(t, err)
+		assert.Equal(t, c.Len(), int64(len(b)))
+		return string(b)
+	}
+
+	t.Run("whole plain object", func(t *testing.T) {
+		store := &fakeContentStore{data: data}
+		c, err := NewAssetContentService(newRefs(), store).OpenAssetContent(ctx, OpenAssetContentInput{ProjectID: projectID, AssetID: assetID})
+		require.NoError(t, err)
+		assert.Equal(t, "video/mp4", c.MIME)
+		assert.Nil(t, c.Range)
+		assert.Equal(t, "0123456789", read(t, c))
+	})
+
+	t.Run("plain range is served by storage", func(t *testing.T) {
+		store := &fakeContentStore{data: data}
+		c, err := NewAssetContentService(newRefs(), store).OpenAssetContent(ctx, OpenAssetContentInput{ProjectID: projectID, AssetID: assetID, Range: "bytes=2-4"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), c.Size)
+		assert.Equal(t, "234", read(t, c))
+		assert.Equal(t, []*blob.ByteRange{{Start: 2, End: 4}}, store.opened)
+	})
+
+	t.Run("encrypted range is cut from the plaintext", func(t *testing.T) {
+		store := &fakeContentStore{data: data, encrypted: true}
+		c, err := NewAssetContentService(newRefs(), store).OpenAssetContent(ctx, OpenAssetContentInput{
+			ProjectID: projectID, AssetID: assetID, Range: "bytes=-3", UserKEK: []byte("kek"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), c.Size, "the plaintext size, not the ciphertext's")
+		assert.Equal(t, "789", read(t, c))
+		assert.Empty(t, store.opened)
+	})
+
+	t.Run("range of a large encrypted object is rejected", func(t *testing.T) {
+		store := &fakeContentStore{data: data, encrypted: true}
+		svc := NewAssetContentService(newRefs(), store).(*assetContentService)
+		svc.maxEncryptedRange = int64(len(data))
+
+		_, err := svc.OpenAssetContent(ctx, OpenAssetContentInput{
+			ProjectID: projectID, AssetID: assetID, Range: "bytes=0-1", UserKEK: []byte("kek"),
+		})
+		assert.ErrorIs(t, err, ErrEncryptedRangeLarge)
+		assert.Zero(t, store.downloads)
+
+		c, err := svc.OpenAssetContent(ctx, OpenAssetContentInput{ProjectID: projectID, AssetID: assetID, UserKEK: []byte("kek")})
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", read(t, c), "the whole object is still served")
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		_, err := NewAssetContentService(newRefs(), &fakeContentStore{data: data}).OpenAssetContent(ctx, OpenAssetContentInput{
+			ProjectID: projectID, AssetID: assetID, Range: "bytes=10-",
+		})
+		var rerr *RangeNotSatisfiableError
+		require.ErrorAs(t, err, &rerr)
+		assert.Equal(t, int64(10), rerr.Size)
+	})
+
+	t.Run("unknown asset", func(t *testing.T) {
+		refs := &MockAssetReferenceRepo{}
+		refs.On("GetByIDs", ctx, projectID, mock.Anything).Return([]model.AssetReference{}, nil)
+		_, err := NewAssetContentService(refs, &fakeContentStore{data: data}).OpenAssetContent(ctx, OpenAssetContentInput{ProjectID: projectID, AssetID: assetID})
+		assert.ErrorIs(t, err, ErrAssetNotFound)
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		_, err := NewAssetContentService(newRefs(), &fakeContentStore{}).OpenAssetContent(ctx, OpenAssetContentInput{ProjectID: projectID, AssetID: assetID})
+		assert.ErrorIs(t, err, ErrAssetNotFound)
+	})
+}
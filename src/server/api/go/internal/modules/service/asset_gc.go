@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	collectOrphansLockKey = "collect_orphan_assets:lock" // Distributed orphan collection lock
+	collectOrphansBatch   = 500                          // Assets deleted per transaction
+	dryRunOrphansLimit    = 1000                         // Assets listed by a dry run
+
+	// MinOrphanAssetAge is the smallest accepted olderThan. Reference increments are buffered in
+	// Redis before they reach the database, so a younger asset may already be linked.
+	MinOrphanAssetAge = time.Hour
+)
+
+type AssetGCService interface {
+	CollectOrphanedAssets(ctx context.Context, olderThan time.Duration, dryRun bool) (*CollectOrphanedAssetsOutput, error)
+}
+
+type assetGCService struct {
+	assetReferenceRepo repo.AssetReferenceRepo
+}
+
+func NewAssetGCService(assetReferenceRepo repo.AssetReferenceRepo) AssetGCService {
+	return &assetGCService{assetReferenceRepo: assetReferenceRepo}
+}
+
+type CollectOrphanedAssetsOutput struct {
+	DryRun bool  `json:"dry_run"`
+	Count  int   `json:"count"`
+	Bytes  int64 `json:"bytes"`
+	// Assets lists what a dry run would delete. Truncated reports that more assets qualify.
+	Assets    []model.AssetReference `json:"assets,omitempty"`
+	Truncated bool                   `json:"truncated,omitempty"`
+}
+
+// CollectOrphanedAssets deletes assets that no message or artifact references and that have not
+// been created or referenced within olderThan, first from object storage and then from the
+// database. With dryRun it only reports the assets that would be deleted.
+func (s *assetGCService) CollectOrphanedAssets(ctx context.Context, olderThan time.Duration, dryRun bool) (*CollectOrphanedAssetsOutput, error) {
+	if olderThan < MinOrphanAssetAge {
+		return nil, fmt.Errorf("%w: older_than must be at least %s", ErrInvalidOrphanAge, MinOrphanAssetAge)
+	}
+	cutoff := time.Now().Add(-olderThan)
+	out := &CollectOrphanedAssetsOutput{DryRun: dryRun}
+
+	if dryRun {
+		refs, err := s.assetReferenceRepo.CollectOrphanedAssets(ctx, cutoff, dryRunOrphansLimit+1, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(refs) > dryRunOrphansLimit {
+			refs = refs[:dryRunOrphansLimit]
+			out.Truncated = true
+		}
+		out.Assets = refs
+		out.Count, out.Bytes = len(refs), sumAssetBytes(refs)
+		return out, nil
+	}
+
+	for {
+		refs, err := s.assetReferenceRepo.CollectOrphanedAssets(ctx, cutoff, collectOrphansBatch, false)
+		if err != nil {
+			return out, err
+		}
+		out.Count += len(refs)
+		out.Bytes += sumAssetBytes(refs)
+		if len(refs) < collectOrphansBatch {
+			return out, nil
+		}
+	}
+}
+
+func sumAssetBytes(refs []model.AssetReference) int64 {
+	var total int64
+	for _, ref := range refs {
+		total += ref.AssetMeta.Data().SizeB
+	}
+	return total
+}
+
+// OrphanAssetCollector periodically collects assets that stayed unreferenced longer than the
+// configured retention window.
+type OrphanAssetCollector interface {
+	Start()
+	Stop()
+}
+
+type orphanAssetCollector struct {
+	svc       AssetGCService
+	redis     *redis.Client
+	log       *zap.Logger
+	olderThan time.Duration
+	interval  time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewOrphanAssetCollector(svc AssetGCService, rdb *redis.Client, cfg *config.Config, log *zap.Logger) OrphanAssetCollector {
+	return &orphanAssetCollector{
+		svc:       svc,
+		redis:     rdb,
+		log:       log,
+		olderThan: time.Duration(cfg.Retention.OrphanAssetHours) * time.Hour,
+		interval:  time.Duration(cfg.Retention.PurgeIntervalSec) * time.Second,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins the background collection goroutine. Collection is disabled when the
+// retention window is below MinOrphanAssetAge or the interval is not positive.
+func (p *orphanAssetCollector) Start() {
+	if p.olderThan < MinOrphanAssetAge || p.interval <= 0 {
+		close(p.done)
+		return
+	}
+	go p.run()
+}
+
+// Stop signals the collector to exit and waits for an in-flight run to finish.
+func (p *orphanAssetCollector) Stop() {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	close(p.stop)
+	<-p.done
+}
+
+func (p *orphanAssetCollector) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.collectOnce()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// collectOnce acquires a distributed lock so only one pod collects per interval.
+func (p *orphanAssetCollector) collectOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	if p.redis != nil {
+		ok, err := p.redis.SetNX(ctx, collectOrphansLockKey, "1", p.interval).Result()
+		if err != nil {
+			p.log.Error("OrphanAssetCollector: failed to acquire lock", zap.Error(err))
+			return
+		}
+		if !ok {
+			return // Another pod collected within this interval.
+		}
+	}
+
+	out, err := p.svc.CollectOrphanedAssets(ctx, p.olderThan, false)
+	if err != nil {
+		p.log.Error("OrphanAssetCollector: collection failed", zap.Error(err))
+		return
+	}
+	if out.Count > 0 {
+		p.log.Info("OrphanAssetCollector: collected orphaned assets",
+			zap.Int("assets", out.Count), zap.Int64("bytes", out.Bytes))
+	}
+}
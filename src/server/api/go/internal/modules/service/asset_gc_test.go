@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/datatypes"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+func orphanRefs(n int, size int64) []model.AssetReference {
+	refs := make([]model.AssetReference, n)
+	for i := range refs {
+		refs[i] = model.AssetReference{ID: uuid.New(), AssetMeta: datatypes.NewJSONType(model.Asset{SizeB: size})}
+	}
+	return refs
+}
+
+func TestAssetGCService_CollectOrphanedAssets(t *testing.T) {
+	ctx := context.Background()
+	olderThan := 24 * time.Hour
+	cutoffNear := mock.MatchedBy(func(c time.Time) bool {
+		return c.Sub(time.Now().Add(-olderThan)).Abs() < time.Minute
+	})
+
+	t.Run("rejects short grace period", func(t *testing.T) {
+		refs := &MockAssetReferenceRepo{}
+		_, err := NewAssetGCService(refs).CollectOrphanedAssets(ctx, time.Minute, false)
+		assert.ErrorIs(t, err, ErrInvalidOrphanAge)
+		refs.AssertNotCalled(t, "CollectOrphanedAssets")
+	})
+
+	t.Run("dry run reports without deleting", func(t *testing.T) {
+		refs := &MockAssetReferenceRepo{}
+		refs.On("CollectOrphanedAssets", ctx, cutoffNear, dryRunOrphansLimit+1, true).Return(orphanRefs(3, 10), nil).Once()
+
+		out, err := NewAssetGCService(refs).CollectOrphanedAssets(ctx, olderThan, true)
+		assert.NoError(t, err)
+		assert.True(t, out.DryRun)
+		assert.Equal(t, 3, out.Count)
+		assert.Equal(t, int64(30), out.Bytes)
+		assert.Len(t, out.Assets, 3)
+		assert.False(t, out.Truncated)
+		refs.AssertExpectations(t)
+	})
+
+	t.Run("dry run truncates long listings", func(t *testing.T) {
+		refs := &MockAssetReferenceRepo{}
+		refs.On("CollectOrphanedAssets", ctx, cutoffNear, dryRunOrphansLimit+1, true).Return(orphanRefs(dryRunOrphansLimit+1, 1), nil).Once()
+
+		out, err := NewAssetGCService(refs).CollectOrphanedAssets(ctx, olderThan, true)
+		assert.NoError(t, err)
+		assert.Len(t, out.Assets, dryRunOrphansLimit)
+		assert.True(t, out.Truncated)
+	})
+
+	t.Run("collects in batches until exhausted", func(t *testing.T) {
+		refs := &MockAssetReferenceRepo{}
+		refs.On("CollectOrphanedAssets", ctx, cutoffNear, collectOrphansBatch, false).Return(orphanRefs(collectOrphansBatch, 2), nil).Once()
+		refs.On("CollectOrphanedAssets", ctx, cutoffNear, collectOrphansBatch, false).Return(orphanRefs(7, 2), nil).Once()
+
+		out, err := NewAssetGCService(refs).CollectOrphanedAssets(ctx, olderThan, false)
+		assert.NoError(t, err)
+		assert.False(t, out.DryRun)
+		assert.Equal(t, collectOrphansBatch+7, out.Count)
+		assert.Equal(t, int64(2*(collectOrphansBatch+7)), out.Bytes)
+		assert.Empty(t, out.Assets)
+		refs.AssertExpectations(t)
+	})
+}
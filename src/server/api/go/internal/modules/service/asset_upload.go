@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/blob"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/memodb-io/Acontext/internal/pkg/mediameta"
+	"github.com/memodb-io/Acontext/internal/pkg/metrics"
+	"github.com/memodb-io/Acontext/internal/pkg/utils/mime"
+)
+
+// projectConfigMaxUploadSize is the project_config key that lowers the presigned upload size limit
+const projectConfigMaxUploadSize = "max_upload_size_bytes"
+
+// project_config keys that lower the per-message part limits
+const (
+	projectConfigMaxMessageParts      = "max_message_parts"
+	projectConfigMaxMessagePartsBytes = "max_message_parts_bytes"
+)
+
+// purgeUploadsBatch bounds how many expired uploads one purge step deletes
+const purgeUploadsBatch = 500
+
+// UploadObjectStore is the object storage used by presigned uploads; *blob.S3Deps implements it.
+type UploadObjectStore interface {
+	PresignPutSized(ctx context.Context, key, contentType string, size int64, expire time.Duration) (string, error)
+	HashObject(ctx context.Context, key string) (*model.Asset, error)
+	DownloadFile(ctx context.Context, key string, userKEK []byte) ([]byte, error)
+	DeleteObjects(ctx context.Context, keys []string) error
+}
+
+type AssetUploadService interface {
+	CreatePresignedUpload(ctx context.Context, in CreatePresignedUploadInput) (*CreatePresignedUploadOutput, error)
+	ConfirmAsset(ctx context.Context, projectID uuid.UUID, uploadID uuid.UUID) (*model.AssetUpload, error)
+	PurgeExpiredUploads(ctx context.Context) (int64, error)
+}
+
+type assetUploadService struct {
+	uploadRepo         repo.AssetUploadRepo
+	assetReferenceRepo repo.AssetReferenceRepo
+	store              UploadObjectStore
+	cfg                *config.Config
+	log                *zap.Logger
+	mediaMeta          *mediameta.Registry
+}
+
+func NewAssetUploadService(uploadRepo repo.AssetUploadRepo, assetReferenceRepo repo.AssetReferenceRepo, store UploadObjectStore, cfg *config.Config, log *zap.Logger, mediaMeta *mediameta.Registry) AssetUploadService {
+	return &assetUploadService{
+		uploadRepo:         uploadRepo,
+		assetReferenceRepo: assetReferenceRepo,
+		store:              store,
+		cfg:                cfg,
+		log:                log,
+		mediaMeta:          mediaMeta,
+	}
+}
+
+type CreatePresignedUploadInput struct {
+	Project   *model.Project
+	MIME      string
+	SizeBytes int64
+}
+
+type CreatePresignedUploadOutput struct {
+	Upload *model.AssetUpload `json:"upload"`
+	// UploadURL accepts one PUT whose Content-Type and Content-Length match the upload.
+	UploadURL string `json:"upload_url"`
+}
+
+// CreatePresignedUpload validates the requested object and returns a presigned PUT URL together
+// with a pending upload record. The URL and the record expire at the same time.
+// Envelope-encrypted projects cannot use it: the object would reach storage unencrypted.
+func (s *assetUploadService) CreatePresignedUpload(ctx context.Context, in CreatePresignedUploadInput) (*CreatePresignedUploadOutput, error) {
+	if in.Project.EncryptionEnabled {
+		return nil, ErrUploadEncrypted
+	}
+	mimeType, err := normalizeUploadMIME(in.MIME)
+	if err != nil {
+		return nil, err
+	}
+	if !uploadMIMEAllowed(mimeType, s.cfg.Upload.AllowedMIMETypes) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadMIMENotAllowed, mimeType)
+	}
+	if in.SizeBytes <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive", ErrUploadInvalid)
+	}
+	if limit := uploadSizeLimit(in.Project, s.cfg.Upload.MaxSizeBytes); in.SizeBytes > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrUploadTooLarge, in.SizeBytes, limit)
+	}
+
+	ttl := time.Duration(s.cfg.Upload.PendingTTLSec) * time.Second
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	id := uuid.New()
+	upload := &model.AssetUpload{
+		ID:        id,
+		ProjectID: in.Project.ID,
+		S3Key:     fmt.Sprintf("assets/%s/uploads/%s/%s", in.Project.ID, time.Now().UTC().Format("2006/01/02"), id),
+		MIME:      mimeType,
+		SizeB:     in.SizeBytes,
+		Status:    model.AssetUploadStatusPending,
+		AssetMeta: datatypes.NewJSONType(model.Asset{}),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	url, err := s.store.PresignPutSized(ctx, upload.S3Key, mimeType, in.SizeBytes, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("presign upload: %w", err)
+	}
+	if err := s.uploadRepo.Create(ctx, upload); err != nil {
+		return nil, fmt.Errorf("create upload record: %w", err)
+	}
+	return &CreatePresignedUploadOutput{Upload: upload, UploadURL: url}, nil
+}
+
+// ConfirmAsset checks that the client finished the upload, records the object's actual size and
+// MIME type, and registers it as a project asset. Content the project already stores is
+// deduplicated: the new object is removed and the upload points at the existing asset.
+// Confirming an already confirmed upload returns it unchanged.
+func (s *assetUploadService) ConfirmAsset(ctx context.Context, projectID uuid.UUID, uploadID uuid.UUID) (*model.AssetUpload, error) {
+	upload, err := s.uploadRepo.Get(ctx, projectID, uploadID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("get upload: %w", err)
+	}
+	if upload.Status == model.AssetUploadStatusConfirmed {
+		return upload, nil
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+
+	asset, err := s.store.HashObject(ctx, upload.S3Key)
+	if err != nil {
+		if errors.Is(err, blob.ErrObjectNotFound) {
+			return nil, ErrUploadObjectMissing
+		}
+		return nil, fmt.Errorf("inspect uploaded object: %w", err)
+	}
+	if asset.SizeB != upload.SizeB {
+		return nil, fmt.Errorf("%w: uploaded %d bytes, expected %d", ErrUploadInvalid, asset.SizeB, upload.SizeB)
+	}
+	// The object's MIME type is sniffed from its content; it must agree with the declared one.
+	if !mime.Compatible(upload.MIME, asset.MIME) {
+		return nil, fmt.Errorf("%w: declared %s, detected %s", ErrMIMEMismatch, upload.MIME, asset.MIME)
+	}
+	if mimeType, err := normalizeUploadMIME(asset.MIME); err == nil && !mime.IsGeneric(mimeType) {
+		asset.MIME = mimeType
+	} else {
+		asset.MIME = upload.MIME
+	}
+	s.describeUpload(ctx, upload.S3Key, asset)
+
+	ref, created, err := s.assetReferenceRepo.RegisterAsset(ctx, projectID, *asset)
+	if err != nil {
+		return nil, fmt.Errorf("register asset: %w", err)
+	}
+	if !created {
+		if err := s.store.DeleteObjects(ctx, []string{upload.S3Key}); err != nil {
+			s.log.Warn("failed to delete duplicate upload", zap.String("s3_key", upload.S3Key), zap.Error(err))
+		}
+		stored := ref.AssetMeta.Data()
+		stored.SHA256 = ref.SHA256
+		stored.S3Key = ref.S3Key
+		stored.Content = ""
+		asset = &stored
+	}
+
+	now := time.Now()
+	upload.MIME = asset.MIME
+	upload.SizeB = asset.SizeB
+	upload.AssetMeta = datatypes.NewJSONType(*asset)
+	upload.AssetID = &ref.ID
+	upload.ConfirmedAt = &now
+	ok, err := s.uploadRepo.Confirm(ctx, upload)
+	if err != nil {
+		return nil, fmt.Errorf("confirm upload: %w", err)
+	}
+	if !ok {
+		// A concurrent confirm won, or the upload was collected in between.
+		current, err := s.uploadRepo.Get(ctx, projectID, uploadID)
+		if err != nil || current.Status != model.AssetUploadStatusConfirmed {
+			return nil, ErrUploadExpired
+		}
+		return current, nil
+	}
+	metrics.AssetBytesUploaded.Add(float64(upload.SizeB), metrics.AssetSourceUpload)
+	return upload, nil
+}
+
+// describeUpload extracts the intrinsic metadata of an uploaded media object of up to
+// AssetMeta.SyncMaxBytes into asset.Meta; larger objects are described by the enrichment worker
+// once a message holds them. Failures leave Meta nil rather than failing the confirmation.
+func (s *assetUploadService) describeUpload(ctx context.Context, key string, asset *model.Asset) {
+	if s.mediaMeta == nil || asset.SizeB > s.cfg.AssetMeta.SyncMaxBytes || !s.mediaMeta.Accepts(asset.MIME) {
+		return
+	}
+	content, err := s.store.DownloadFile(ctx, key, nil)
+	if err == nil {
+		asset.Meta, err = s.mediaMeta.Extract(ctx, asset.MIME, content)
+	}
+	if err != nil {
+		asset.Meta = nil
+		s.log.Debug("asset metadata extraction failed", zap.String("s3_key", key), zap.String("mime", asset.MIME), zap.Error(err))
+	}
+}
+
+// PurgeExpiredUploads deletes pending uploads that were never confirmed in time, together with
+// any object the client wrote to their keys. It returns the number of uploads removed.
+func (s *assetUploadService) PurgeExpiredUploads(ctx context.Context) (int64, error) {
+	var total int64
+	for {
+		expired, err := s.uploadRepo.ListExpiredPending(ctx, time.Now(), purgeUploadsBatch)
+		if err != nil {
+			return total, fmt.Errorf("list expired uploads: %w", err)
+		}
+		if len(expired) == 0 {
+			return total, nil
+		}
+
+		keys := make([]string, 0, len(expired))
+		ids := make([]uuid.UUID, 0, len(expired))
+		for _, u := range expired {
+			keys = append(keys, u.S3Key)
+			ids = append(ids, u.ID)
+		}
+		// Objects first: a leftover row is retried next run, a leftover object would leak.
+		if err := s.store.DeleteObjects(ctx, keys); err != nil {
+			return total, fmt.Errorf("delete expired upload objects: %w", err)
+		}
+		n, err := s.uploadRepo.DeletePending(ctx, ids)
+		if err != nil {
+			return total, fmt.Errorf("delete expired uploads: %w", err)
+		}
+		total += n
+		if len(expired) < purgeUploadsBatch {
+			return total, nil
+		}
+	}
+}
+
+// normalizeUploadMIME returns the canonical form of a declared upload MIME type
+func normalizeUploadMIME(raw string) (string, error) {
+	mediaType, err := mime.Normalize(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid MIME type %q", ErrUploadInvalid, raw)
+	}
+	return mediaType, nil
+}
+
+// uploadMIMEAllowed matches mimeType against allowed entries, where "type/*" covers a whole family
+func uploadMIMEAllowed(mimeType string, allowed []string) bool {
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == mimeType || a == "*/*" {
+			return true
+		}
+		if family, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mimeType, family+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadSizeLimit returns the project's upload size limit. A project may lower the deployment
+// limit through project_config.max_upload_size_bytes but never raise it.
+func uploadSizeLimit(project *model.Project, deploymentMax int64) int64 {
+	return projectLimit(project, projectConfigMaxUploadSize, deploymentMax)
+}
+
+// projectLimit returns deploymentMax, lowered by the positive project_config value under key.
+// A deployment limit <= 0 means unlimited, so any project value applies; a nil project keeps
+// the deployment limit.
+func projectLimit(project *model.Project, key string, deploymentMax int64) int64 {
+	if project == nil {
+		return deploymentMax
+	}
+	limit := deploymentMax
+	pc, _ := project.Configs["project_config"].(map[string]interface{})
+	if v, ok := pc[key].(float64); ok && v > 0 && (limit <= 0 || int64(v) < limit) {
+		limit = int64(v)
+	}
+	return limit
+}
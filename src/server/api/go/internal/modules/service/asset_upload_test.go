@@ -0,0 +1,344 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/blob"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/mediameta"
+	"github.com/memodb-io/Acontext/internal/pkg/metrics"
+)
+
+// MockAssetUploadRepo is a mock implementation of AssetUploadRepo
+type MockAssetUploadRepo struct {
+	mock.Mock
+}
+
+func (m *MockAssetUploadRepo) Create(ctx context.Context, u *model.AssetUpload) error {
+	args := m.Called(ctx, u)
+	return args.Error(0)
+}
+
+func (m *MockAssetUploadRepo) Get(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.AssetUpload, error) {
+	args := m.Called(ctx, projectID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.AssetUpload), args.Error(1)
+}
+
+func (m *MockAssetUploadRepo) Confirm(ctx context.Context, u *model.AssetUpload) (bool, error) {
+	args := m.Called(ctx, u)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAssetUploadRepo) ListExpiredPending(ctx context.Context, before time.Time, limit int) ([]model.AssetUpload, error) {
+	args := m.Called(ctx, before, limit)
+	return args.Get(0).([]model.AssetUpload), args.Error(1)
+}
+
+func (m *MockAssetUploadRepo) DeletePending(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// fakeUploadStore records presign and delete calls and serves a fixed object.
+type fakeUploadStore struct {
+	object     *model.Asset
+	content    []byte
+	hashErr    error
+	presigned  []string
+	deleted    []string
+	presignURL string
+}
+
+func (f *fakeUploadStore) PresignPutSized(ctx context.Context, key, contentType string, size int64, expire time.Duration) (string, error) {
+	f.presigned = append(f.presigned, key)
+	return f.presignURL, nil
+}
+
+func (f *fakeUploadStore) HashObject(ctx context.Context, key string) (*model.Asset, error) {
+	if f.hashErr != nil {
+		return nil, f.hashErr
+	}
+	a := *f.object
+	a.S3Key = key
+	return &a, nil
+}
+
+func (f *fakeUploadStore) DownloadFile(ctx context.Context, key string, userKEK []byte) ([]byte, error) {
+	return f.content, nil
+}
+
+func (f *fakeUploadStore) DeleteObjects(ctx context.Context, keys []string) error {
+	f.deleted = append(f.deleted, keys...)
+	return nil
+}
+
+func newTestUploadService(uploads *MockAssetUploadRepo, refs *MockAssetReferenceRepo, store *fakeUploadStore) *assetUploadService {
+	cfg := &config.Config{Upload: config.UploadCfg{
+		MaxSizeBytes:     100,
+		AllowedMIMETypes: []string{"image/*", "application/pdf"},
+		PendingTTLSec:    60,
+	}}
+	return NewAssetUploadService(uploads, refs, store, cfg, zap.NewNop(), nil).(*assetUploadService)
+}
+
+func TestAssetUploadService_CreatePresignedUpload(t *testing.T) {
+	projectID := uuid.New()
+	project := &model.Project{ID: projectID}
+
+	tests := []struct {
+		name    string
+		project *model.Project
+		mime    string
+		size    int64
+		wantErr error
+	}{
+		{name: "wildcard family allowed", project: project, mime: "image/PNG", size: 10},
+		{name: "exact type with params allowed", project: project, mime: "application/pdf; charset=binary", size: 100},
+		{name: "disallowed type", project: project, mime: "text/html", size: 10, wantErr: ErrUploadMIMENotAllowed},
+		{name: "malformed type", project: project, mime: "not a mime", size: 10, wantErr: ErrUploadInvalid},
+		{name: "too large", project: project, mime: "image/png", size: 101, wantErr: ErrUploadTooLarge},
+		{name: "zero size", project: project, mime: "image/png", size: 0, wantErr: ErrUploadInvalid},
+		{
+			name: "project limit lowers deployment limit",
+			project: &model.Project{ID: projectID, Configs: datatypes.JSONMap{
+				"project_config": map[string]interface{}{"max_upload_size_bytes": float64(50)},
+			}},
+			mime: "image/png", size: 60, wantErr: ErrUploadTooLarge,
+		},
+		{name: "encrypted project", project: &model.Project{ID: projectID, EncryptionEnabled: true}, mime: "image/png", size: 10, wantErr: ErrUploadEncrypted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploads := &MockAssetUploadRepo{}
+			store := &fakeUploadStore{presignURL: "https://s3.example/put"}
+			s := newTestUploadService(uploads, &MockAssetReferenceRepo{}, store)
+			if tt.wantErr == nil {
+				uploads.On("Create", mock.Anything, mock.AnythingOfType("*model.AssetUpload")).Return(nil)
+			}
+
+			out, err := s.CreatePresignedUpload(context.Background(), CreatePresignedUploadInput{Project: tt.project, MIME: tt.mime, SizeBytes: tt.size})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Empty(t, store.presigned, "no URL may be issued for a rejected upload")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "https://s3.example/put", out.UploadURL)
+			assert.Equal(t, model.AssetUploadStatusPending, out.Upload.Status)
+			assert.Equal(t, tt.size, out.Upload.SizeB)
+			assert.NotContains(t, out.Upload.MIME, ";")
+			assert.Equal(t, []string{out.Upload.S3Key}, store.presigned)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), out.Upload.ExpiresAt, 5*time.Second)
+			uploads.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAssetUploadService_ConfirmAsset(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	uploadID := uuid.New()
+
+	pending := func() *model.AssetUpload {
+		return &model.AssetUpload{
+			ID:        uploadID,
+			ProjectID: projectID,
+			S3Key:     "assets/p/uploads/u",
+			MIME:      "image/png",
+			SizeB:     10,
+			Status:    model.AssetUploadStatusPending,
+			ExpiresAt: time.Now().Add(time.Minute),
+		}
+	}
+	object := &model.Asset{Bucket: "b", SHA256: "abc", MIME: "image/png", SizeB: 10}
+
+	t.Run("registers new asset", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		refs := &MockAssetReferenceRepo{}
+		store := &fakeUploadStore{object: object}
+		refID := uuid.New()
+		uploads.On("Get", ctx, projectID, uploadID).Return(pending(), nil)
+		refs.On("RegisterAsset", ctx, projectID, mock.AnythingOfType("model.Asset")).
+			Return(&model.AssetReference{ID: refID, SHA256: "abc", S3Key: "assets/p/uploads/u"}, true, nil)
+		uploads.On("Confirm", ctx, mock.AnythingOfType("*model.AssetUpload")).Return(true, nil)
+		uploaded := metrics.AssetBytesUploaded.Value(metrics.AssetSourceUpload)
+
+		u, err := newTestUploadService(uploads, refs, store).ConfirmAsset(ctx, projectID, uploadID)
+		assert.NoError(t, err)
+		assert.Equal(t, uploaded+10, metrics.AssetBytesUploaded.Value(metrics.AssetSourceUpload))
+		assert.Equal(t, refID, *u.AssetID)
+		assert.Equal(t, "abc", u.AssetMeta.Data().SHA256)
+		assert.NotNil(t, u.ConfirmedAt)
+		assert.Empty(t, store.deleted)
+	})
+
+	t.Run("describes small media", func(t *testing.T) {
+		var img bytes.Buffer
+		require.NoError(t, png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 3))))
+		tests := []struct {
+			name    string
+			content []byte
+			want    map[string]any
+		}{
+			{"readable", img.Bytes(), map[string]any{mediameta.KeyWidth: 4, mediameta.KeyHeight: 3, mediameta.KeyCodec: "png"}},
+			{"unreadable is stored without metadata", []byte("not a png"), nil},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				uploads := &MockAssetUploadRepo{}
+				refs := &MockAssetReferenceRepo{}
+				store := &fakeUploadStore{object: object, content: tc.content}
+				var registered model.Asset
+				uploads.On("Get", ctx, projectID, uploadID).Return(pending(), nil)
+				refs.On("RegisterAsset", ctx, projectID, mock.AnythingOfType("model.Asset")).Run(func(args mock.Arguments) {
+					registered = args.Get(2).(model.Asset)
+				}).Return(&model.AssetReference{ID: uuid.New(), SHA256: "abc", S3Key: "assets/p/uploads/u"}, true, nil)
+				uploads.On("Confirm", ctx, mock.AnythingOfType("*model.AssetUpload")).Return(true, nil)
+
+				svc := newTestUploadService(uploads, refs, store)
+				svc.mediaMeta = mediameta.NewDefaultRegistry()
+				svc.cfg.AssetMeta.SyncMaxBytes = 100
+
+				u, err := svc.ConfirmAsset(ctx, projectID, uploadID)
+				require.NoError(t, err)
+				assert.Equal(t, tc.want, registered.Meta)
+				assert.Equal(t, tc.want, u.AssetMeta.Data().Meta)
+			})
+		}
+	})
+
+	t.Run("duplicate content reuses stored asset", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		refs := &MockAssetReferenceRepo{}
+		store := &fakeUploadStore{object: object}
+		refID := uuid.New()
+		uploads.On("Get", ctx, projectID, uploadID).Return(pending(), nil)
+		refs.On("RegisterAsset", ctx, projectID, mock.AnythingOfType("model.Asset")).
+			Return(&model.AssetReference{ID: refID, SHA256: "abc", S3Key: "assets/p/canonical.png",
+				AssetMeta: datatypes.NewJSONType(model.Asset{Bucket: "b", MIME: "image/png", SizeB: 10})}, false, nil)
+		uploads.On("Confirm", ctx, mock.AnythingOfType("*model.AssetUpload")).Return(true, nil)
+
+		u, err := newTestUploadService(uploads, refs, store).ConfirmAsset(ctx, projectID, uploadID)
+		assert.NoError(t, err)
+		assert.Equal(t, "assets/p/canonical.png", u.AssetMeta.Data().S3Key)
+		assert.Equal(t, []string{"assets/p/uploads/u"}, store.deleted)
+	})
+
+	t.Run("already confirmed is idempotent", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		done := pending()
+		done.Status = model.AssetUploadStatusConfirmed
+		uploads.On("Get", ctx, projectID, uploadID).Return(done, nil)
+
+		u, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, &fakeUploadStore{}).ConfirmAsset(ctx, projectID, uploadID)
+		assert.NoError(t, err)
+		assert.Equal(t, done, u)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		uploads.On("Get", ctx, projectID, uploadID).Return(nil, gorm.ErrRecordNotFound)
+
+		_, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, &fakeUploadStore{}).ConfirmAsset(ctx, projectID, uploadID)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		old := pending()
+		old.ExpiresAt = time.Now().Add(-time.Second)
+		uploads.On("Get", ctx, projectID, uploadID).Return(old, nil)
+
+		_, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, &fakeUploadStore{}).ConfirmAsset(ctx, projectID, uploadID)
+		assert.ErrorIs(t, err, ErrUploadExpired)
+	})
+
+	t.Run("object missing", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		uploads.On("Get", ctx, projectID, uploadID).Return(pending(), nil)
+		store := &fakeUploadStore{hashErr: blob.ErrObjectNotFound}
+
+		_, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, store).ConfirmAsset(ctx, projectID, uploadID)
+		assert.ErrorIs(t, err, ErrUploadObjectMissing)
+	})
+
+	t.Run("size mismatch", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		uploads.On("Get", ctx, projectID, uploadID).Return(pending(), nil)
+		store := &fakeUploadStore{object: &model.Asset{SHA256: "abc", MIME: "image/png", SizeB: 9}}
+
+		_, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, store).ConfirmAsset(ctx, projectID, uploadID)
+		assert.ErrorIs(t, err, ErrUploadInvalid)
+	})
+
+	t.Run("content disagrees with declared MIME", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		uploads.On("Get", ctx, projectID, uploadID).Return(pending(), nil)
+		store := &fakeUploadStore{object: &model.Asset{SHA256: "abc", MIME: "image/jpeg", SizeB: 10}}
+
+		_, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, store).ConfirmAsset(ctx, projectID, uploadID)
+		assert.ErrorIs(t, err, ErrMIMEMismatch)
+		uploads.AssertNotCalled(t, "Confirm", mock.Anything, mock.Anything)
+	})
+
+	t.Run("generic detection keeps declared MIME", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		refs := &MockAssetReferenceRepo{}
+		store := &fakeUploadStore{object: &model.Asset{SHA256: "abc", MIME: "application/octet-stream", SizeB: 10}}
+		uploads.On("Get", ctx, projectID, uploadID).Return(pending(), nil)
+		refs.On("RegisterAsset", ctx, projectID, mock.MatchedBy(func(a model.Asset) bool { return a.MIME == "image/png" })).
+			Return(&model.AssetReference{ID: uuid.New(), SHA256: "abc", S3Key: "assets/p/uploads/u"}, true, nil)
+		uploads.On("Confirm", ctx, mock.AnythingOfType("*model.AssetUpload")).Return(true, nil)
+
+		_, err := newTestUploadService(uploads, refs, store).ConfirmAsset(ctx, projectID, uploadID)
+		assert.NoError(t, err)
+		refs.AssertExpectations(t)
+	})
+}
+
+func TestAssetUploadService_PurgeExpiredUploads(t *testing.T) {
+	ctx := context.Background()
+	a, b := uuid.New(), uuid.New()
+
+	t.Run("deletes objects then rows", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		store := &fakeUploadStore{}
+		uploads.On("ListExpiredPending", ctx, mock.AnythingOfType("time.Time"), purgeUploadsBatch).
+			Return([]model.AssetUpload{{ID: a, S3Key: "k1"}, {ID: b, S3Key: "k2"}}, nil).Once()
+		uploads.On("DeletePending", ctx, []uuid.UUID{a, b}).Return(int64(2), nil)
+
+		n, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, store).PurgeExpiredUploads(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+		assert.Equal(t, []string{"k1", "k2"}, store.deleted)
+		uploads.AssertExpectations(t)
+	})
+
+	t.Run("list error", func(t *testing.T) {
+		uploads := &MockAssetUploadRepo{}
+		uploads.On("ListExpiredPending", ctx, mock.AnythingOfType("time.Time"), purgeUploadsBatch).
+			Return([]model.AssetUpload(nil), errors.New("db down"))
+
+		_, err := newTestUploadService(uploads, &MockAssetReferenceRepo{}, &fakeUploadStore{}).PurgeExpiredUploads(ctx)
+		assert.Error(t, err)
+	})
+}
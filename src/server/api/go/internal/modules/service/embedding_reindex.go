@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// JobKindEmbeddingReindex is the job kind that runs embedding reindexes.
+const JobKindEmbeddingReindex = "embedding.reindex"
+
+// reindexBatchesPerJob bounds the batches one reindex job embeds before it hands the rest of the
+// run to a new job, so one large project does not hold a worker for long.
+const reindexBatchesPerJob = 10
+
+type ReindexEmbeddingsInput struct {
+	Project *model.Project
+	// SessionID limits the reindex to one session; nil reindexes the whole project.
+	SessionID *uuid.UUID
+	// Model is the model to embed with; empty uses the configured default.
+	Model string
+	// Force re-embeds messages that already have an embedding of Model.
+	Force bool
+}
+
+type reindexJobPayload struct {
+	ProjectID uuid.UUID `json:"project_id"`
+	ReindexID uuid.UUID `json:"reindex_id"`
+}
+
+// ReindexEmbeddings starts a background run that embeds the text of the live messages of the
+// project, or of one session, with a model; see RunReindexJob. A project runs one reindex at a
+// time; a run whose job ends in the dead letters stays running until the job is retried.
+// Encrypted projects are refused because the worker cannot read their messages.
+func (s *messageEmbeddingService) ReindexEmbeddings(ctx context.Context, in ReindexEmbeddingsInput) (*model.EmbeddingReindex, error) {
+	if in.Project.EncryptionEnabled {
+		return nil, ErrReindexEncrypted
+	}
+	if s.embedder == nil {
+		return nil, ErrEmbedderUnavailable
+	}
+	if in.SessionID != nil {
+		session, err := s.sessionRepo.Get(ctx, &model.Session{ID: *in.SessionID})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrSessionNotFound
+			}
+			return nil, fmt.Errorf("failed to get session: %w", err)
+		}
+		if session.ProjectID != in.Project.ID {
+			return nil, ErrSessionNotFound
+		}
+	}
+	embeddingModel := strings.TrimSpace(in.Model)
+	if embeddingModel == "" {
+		embeddingModel = s.cfg.Embedding.Model
+	}
+
+	ri := &model.EmbeddingReindex{
+		ProjectID: in.Project.ID,
+		SessionID: in.SessionID,
+		Model:     embeddingModel,
+		Force:     in.Force,
+	}
+	if err := s.embeddingRepo.CreateReindex(ctx, ri); err != nil {
+		if errors.Is(err, repo.ErrReindexRunning) {
+			return nil, ErrReindexRunning
+		}
+		return nil, mapEmbeddingErr(err)
+	}
+	if err := s.enqueueReindex(ctx, ri); err != nil {
+		s.failReindex(ctx, ri, err)
+		return nil, err
+	}
+	return ri, nil
+}
+
+func (s *messageEmbeddingService) GetEmbeddingReindex(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.EmbeddingReindex, error) {
+	ri, err := s.embeddingRepo.GetReindex(ctx, projectID, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReindexNotFound
+		}
+		return nil, err
+	}
+	return ri, nil
+}
+
+// RunReindexJob is the JobHandler of JobKindEmbeddingReindex. It embeds up to
+// reindexBatchesPerJob batches from the run's checkpoint, saving the checkpoint after each, then
+// queues a job for the rest, so a run that is interrupted resumes where it stopped. Messages
+// without text, and unless the run is forced those already embedded with its model, are
+// skipped. A dimension mismatch fails the run; other errors fail the job, which is retried.
+func (s *messageEmbeddingService) RunReindexJob(ctx context.Context, payload json.RawMessage) error {
+	var p reindexJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("decode reindex payload: %w", err)
+	}
+	ri, err := s.embeddingRepo.GetReindex(ctx, p.ProjectID, p.ReindexID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if ri.Status != model.EmbeddingReindexRunning {
+		return nil
+	}
+	if s.embedder == nil {
+		s.failReindex(ctx, ri, ErrEmbedderUnavailable)
+		return nil
+	}
+
+	batchSize := max(s.cfg.Embedding.BatchSize, 1)
+	for range reindexBatchesPerJob {
+		batch, err := s.embeddingRepo.NextReindexBatch(ctx, ri, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return s.finishReindex(ctx, ri)
+		}
+		if err := s.embedBatch(ctx, ri, batch); err != nil {
+			if errors.Is(err, repo.ErrEmbeddingDimMismatch) {
+				s.failReindex(ctx, ri, err)
+				return nil
+			}
+			ri.Error = err.Error()
+			if saveErr := s.embeddingRepo.SaveReindexProgress(ctx, ri); saveErr != nil {
+				s.log.Warn("save reindex error", zap.String("reindex_id", ri.ID.String()), zap.Error(saveErr))
+			}
+			return err
+		}
+		ri.AfterMessageID = batch[len(batch)-1].ID
+		ri.Error = ""
+		if err := s.embeddingRepo.SaveReindexProgress(ctx, ri); err != nil {
+			return fmt.Errorf("save reindex progress: %w", err)
+		}
+		if len(batch) < batchSize {
+			return s.finishReindex(ctx, ri)
+		}
+	}
+	return s.enqueueReindex(ctx, ri)
+}
+
+// embedBatch embeds the messages of the batch that need it and counts the rest as skipped. The
+// counters are only updated once the whole batch is stored, so a retried batch counts once.
+func (s *messageEmbeddingService) embedBatch(ctx context.Context, ri *model.EmbeddingReindex, batch []repo.ReindexCandidate) error {
+	todo := make([]repo.ReindexCandidate, 0, len(batch))
+	texts := make([]string, 0, len(batch))
+	for _, c := range batch {
+		if strings.TrimSpace(c.SearchText) == "" || (c.Embedded && !ri.Force) {
+			continue
+		}
+		todo = append(todo, c)
+		texts = append(texts, c.SearchText)
+	}
+	if len(todo) > 0 {
+		vecs, err := s.embedder.Embed(ctx, ri.Model, texts)
+		if err != nil {
+			return err
+		}
+		for i, c := range todo {
+			if err := s.embeddingRepo.Upsert(ctx, &model.MessageEmbedding{
+				MessageID: c.ID,
+				SessionID: c.SessionID,
+				ProjectID: ri.ProjectID,
+				Model:     ri.Model,
+				Embedding: vecs[i],
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	ri.Embedded += int64(len(todo))
+	ri.Skipped += int64(len(batch) - len(todo))
+	return nil
+}
+
+func (s *messageEmbeddingService) enqueueReindex(ctx context.Context, ri *model.EmbeddingReindex) error {
+	payload, err := json.Marshal(reindexJobPayload{ProjectID: ri.ProjectID, ReindexID: ri.ID})
+	if err != nil {
+		return err
+	}
+	if _, err := s.jobQueue.EnqueueJob(ctx, JobKindEmbeddingReindex, payload); err != nil {
+		return fmt.Errorf("enqueue reindex: %w", err)
+	}
+	return nil
+}
+
+func (s *messageEmbeddingService) finishReindex(ctx context.Context, ri *model.EmbeddingReindex) error {
+	now := time.Now()
+	ri.Status = model.EmbeddingReindexDone
+	ri.FinishedAt = &now
+	if err := s.embeddingRepo.SaveReindexProgress(ctx, ri); err != nil {
+		return fmt.Errorf("save reindex progress: %w", err)
+	}
+	return nil
+}
+
+// failReindex ends the run with cause; the messages embedded so far keep their embeddings.
+func (s *messageEmbeddingService) failReindex(ctx context.Context, ri *model.EmbeddingReindex, cause error) {
+	now := time.Now()
+	ri.Status = model.EmbeddingReindexFailed
+	ri.Error = mapEmbeddingErr(cause).Error()
+	ri.FinishedAt = &now
+	if err := s.embeddingRepo.SaveReindexProgress(ctx, ri); err != nil {
+		s.log.Warn("save failed reindex", zap.String("reindex_id", ri.ID.String()), zap.Error(err))
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type MockMessageEmbeddingRepo struct {
+	mock.Mock
+}
+
+func (m *MockMessageEmbeddingRepo) Upsert(ctx context.Context, e *model.MessageEmbedding) error {
+	return m.Called(ctx, e).Error(0)
+}
+
+func (m *MockMessageEmbeddingRepo) SearchSimilar(ctx context.Context, projectID uuid.UUID, sessionID *uuid.UUID, embeddingModel string, vector model.Vector, topK int) ([]repo.SimilarMessage, error) {
+	args := m.Called(ctx, projectID, sessionID, embeddingModel, vector, topK)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repo.SimilarMessage), args.Error(1)
+}
+
+func (m *MockMessageEmbeddingRepo) CreateReindex(ctx context.Context, ri *model.EmbeddingReindex) error {
+	return m.Called(ctx, ri).Error(0)
+}
+
+func (m *MockMessageEmbeddingRepo) GetReindex(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.EmbeddingReindex, error) {
+	args := m.Called(ctx, projectID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.EmbeddingReindex), args.Error(1)
+}
+
+func (m *MockMessageEmbeddingRepo) NextReindexBatch(ctx context.Context, ri *model.EmbeddingReindex, limit int) ([]repo.ReindexCandidate, error) {
+	args := m.Called(ctx, ri, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repo.ReindexCandidate), args.Error(1)
+}
+
+func (m *MockMessageEmbeddingRepo) SaveReindexProgress(ctx context.Context, ri *model.EmbeddingReindex) error {
+	return m.Called(ctx, ri).Error(0)
+}
+
+// fakeEmbedder embeds each text as a vector of its length.
+type fakeEmbedder struct {
+	err   error
+	calls [][]string
+}
+
+func (f *fakeEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	f.calls = append(f.calls, texts)
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = []float32{float32(len(t)), 1}
+	}
+	return out, nil
+}
+
+// recordingJobQueue records the jobs enqueued.
+type recordingJobQueue struct {
+	JobQueue
+	kinds []string
+}
+
+func (q *recordingJobQueue) EnqueueJob(ctx context.Context, kind string, payload json.RawMessage) (*model.Job, error) {
+	q.kinds = append(q.kinds, kind)
+	return &model.Job{Kind: kind}, nil
+}
+
+func TestMessageEmbeddingService_RunReindexJob(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	cfg := &config.Config{Embedding: config.EmbeddingCfg{BatchSize: 3}}
+	payload, _ := json.Marshal(reindexJobPayload{ProjectID: projectID, ReindexID: uuid.New()})
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+
+	t.Run("embeds what is missing and finishes", func(t *testing.T) {
+		ri := &model.EmbeddingReindex{ProjectID: projectID, Model: "m", Status: model.EmbeddingReindexRunning}
+		r := &MockMessageEmbeddingRepo{}
+		r.On("GetReindex", ctx, projectID, mock.Anything).Return(ri, nil)
+		r.On("NextReindexBatch", ctx, ri, 3).Return([]repo.ReindexCandidate{
+			{ID: ids[0], SessionID: sessionID, SearchText: "hello"},
+			{ID: ids[1], SessionID: sessionID, SearchText: "  "},
+			{ID: ids[2], SessionID: sessionID, SearchText: "done", Embedded: true},
+		}, nil).Once()
+		r.On("NextReindexBatch", ctx, ri, 3).Return([]repo.ReindexCandidate{}, nil).Once()
+		r.On("Upsert", ctx, mock.MatchedBy(func(e *model.MessageEmbedding) bool {
+			return e.MessageID == ids[0] && e.Model == "m" && e.SessionID == sessionID
+		})).Return(nil).Once()
+		r.On("SaveReindexProgress", ctx, ri).Return(nil)
+		emb := &fakeEmbedder{}
+		q := &recordingJobQueue{}
+
+		svc := NewMessageEmbeddingService(nil, r, emb, q, cfg, zap.NewNop())
+		require.NoError(t, svc.RunReindexJob(ctx, payload))
+		assert.Equal(t, [][]string{{"hello"}}, emb.calls)
+		assert.Equal(t, model.EmbeddingReindexDone, ri.Status)
+		assert.Equal(t, int64(1), ri.Embedded)
+		assert.Equal(t, int64(2), ri.Skipped)
+		assert.Equal(t, ids[2], ri.AfterMessageID)
+		assert.Empty(t, q.kinds)
+		r.AssertExpectations(t)
+	})
+
+	t.Run("force re-embeds and a full job continues in a new one", func(t *testing.T) {
+		ri := &model.EmbeddingReindex{ProjectID: projectID, Model: "m", Force: true, Status: model.EmbeddingReindexRunning}
+		r := &MockMessageEmbeddingRepo{}
+		r.On("GetReindex", ctx, projectID, mock.Anything).Return(ri, nil)
+		r.On("NextReindexBatch", ctx, ri, 1).Return([]repo.ReindexCandidate{{ID: ids[0], SearchText: "x", Embedded: true}}, nil)
+		r.On("Upsert", ctx, mock.Anything).Return(nil)
+		r.On("SaveReindexProgress", ctx, ri).Return(nil)
+		q := &recordingJobQueue{}
+
+		svc := NewMessageEmbeddingService(nil, r, &fakeEmbedder{}, q, &config.Config{Embedding: config.EmbeddingCfg{BatchSize: 1}}, zap.NewNop())
+		require.NoError(t, svc.RunReindexJob(ctx, payload))
+		assert.Equal(t, model.EmbeddingReindexRunning, ri.Status)
+		assert.Equal(t, int64(reindexBatchesPerJob), ri.Embedded)
+		assert.Equal(t, []string{JobKindEmbeddingReindex}, q.kinds)
+	})
+
+	t.Run("provider errors retry from the checkpoint", func(t *testing.T) {
+		ri := &model.EmbeddingReindex{ProjectID: projectID, Model: "m", Status: model.EmbeddingReindexRunning}
+		r := &MockMessageEmbeddingRepo{}
+		r.On("GetReindex", ctx, projectID, mock.Anything).Return(ri, nil)
+		r.On("NextReindexBatch", ctx, ri, 3).Return([]repo.ReindexCandidate{{ID: ids[0], SearchText: "x"}}, nil)
+		r.On("SaveReindexProgress", ctx, ri).Return(nil)
+
+		svc := NewMessageEmbeddingService(nil, r, &fakeEmbedder{err: errors.New("rate limited")}, &recordingJobQueue{}, cfg, zap.NewNop())
+		assert.Error(t, svc.RunReindexJob(ctx, payload))
+		assert.Equal(t, model.EmbeddingReindexRunning, ri.Status)
+		assert.Equal(t, "rate limited", ri.Error)
+		assert.Equal(t, uuid.Nil, ri.AfterMessageID)
+		assert.Zero(t, ri.Embedded)
+	})
+
+	t.Run("dimension mismatch fails the run", func(t *testing.T) {
+		ri := &model.EmbeddingReindex{ProjectID: projectID, Model: "m", Status: model.EmbeddingReindexRunning}
+		r := &MockMessageEmbeddingRepo{}
+		r.On("GetReindex", ctx, projectID, mock.Anything).Return(ri, nil)
+		r.On("NextReindexBatch", ctx, ri, 3).Return([]repo.ReindexCandidate{{ID: ids[0], SearchText: "x"}}, nil)
+		r.On("Upsert", ctx, mock.Anything).Return(repo.ErrEmbeddingDimMismatch)
+		r.On("SaveReindexProgress", ctx, ri).Return(nil)
+
+		svc := NewMessageEmbeddingService(nil, r, &fakeEmbedder{}, &recordingJobQueue{}, cfg, zap.NewNop())
+		require.NoError(t, svc.RunReindexJob(ctx, payload))
+		assert.Equal(t, model.EmbeddingReindexFailed, ri.Status)
+		assert.NotNil(t, ri.FinishedAt)
+	})
+
+	t.Run("finished runs are left alone", func(t *testing.T) {
+		r := &MockMessageEmbeddingRepo{}
+		r.On("GetReindex", ctx, projectID, mock.Anything).Return(&model.EmbeddingReindex{Status: model.EmbeddingReindexDone}, nil)
+
+		svc := NewMessageEmbeddingService(nil, r, &fakeEmbedder{}, &recordingJobQueue{}, cfg, zap.NewNop())
+		require.NoError(t, svc.RunReindexJob(ctx, payload))
+		r.AssertNotCalled(t, "NextReindexBatch", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestMessageEmbeddingService_ReindexEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	project := &model.Project{ID: uuid.New()}
+	cfg := &config.Config{Embedding: config.EmbeddingCfg{Model: "default-model", BatchSize: 10}}
+
+	t.Run("uses the default model and queues a job", func(t *testing.T) {
+		r := &MockMessageEmbeddingRepo{}
+		r.On("CreateReindex", ctx, mock.MatchedBy(func(ri *model.EmbeddingReindex) bool {
+			return ri.ProjectID == project.ID && ri.Model == "default-model" && ri.SessionID == nil
+		})).Return(nil)
+		q := &recordingJobQueue{}
+
+		ri, err := NewMessageEmbeddingService(nil, r, &fakeEmbedder{}, q, cfg, zap.NewNop()).
+			ReindexEmbeddings(ctx, ReindexEmbeddingsInput{Project: project})
+		require.NoError(t, err)
+		assert.Equal(t, "default-model", ri.Model)
+		assert.Equal(t, []string{JobKindEmbeddingReindex}, q.kinds)
+	})
+
+	t.Run("one run at a time", func(t *testing.T) {
+		r := &MockMessageEmbeddingRepo{}
+		r.On("CreateReindex", ctx, mock.Anything).Return(repo.ErrReindexRunning)
+
+		_, err := NewMessageEmbeddingService(nil, r, &fakeEmbedder{}, &recordingJobQueue{}, cfg, zap.NewNop()).
+			ReindexEmbeddings(ctx, ReindexEmbeddingsInput{Project: project, Model: "m"})
+		assert.ErrorIs(t, err, ErrReindexRunning)
+	})
+
+	t.Run("refuses encrypted projects and missing providers", func(t *testing.T) {
+		svc := NewMessageEmbeddingService(nil, &MockMessageEmbeddingRepo{}, &fakeEmbedder{}, &recordingJobQueue{}, cfg, zap.NewNop())
+		_, err := svc.ReindexEmbeddings(ctx, ReindexEmbeddingsInput{Project: &model.Project{ID: project.ID, EncryptionEnabled: true}})
+		assert.ErrorIs(t, err, ErrReindexEncrypted)
+
+		svc = NewMessageEmbeddingService(nil, &MockMessageEmbeddingRepo{}, nil, &recordingJobQueue{}, cfg, zap.NewNop())
+		_, err = svc.ReindexEmbeddings(ctx, ReindexEmbeddingsInput{Project: project})
+		assert.ErrorIs(t, err, ErrEmbedderUnavailable)
+	})
+}
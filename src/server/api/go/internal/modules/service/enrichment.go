@@ -0,0 +1,358 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/blob"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/memodb-io/Acontext/internal/pkg/enricher"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// enrichmentWriteRetries bounds how often a result is re-applied when the message's parts change
+// while it is being written back.
+const enrichmentWriteRetries = 3
+
+// EnrichmentService queues media parts for the registered enrichers, runs the queued jobs and
+// writes their results back into the parts' Meta.
+type EnrichmentService interface {
+	EnqueueParts(ctx context.Context, in EnqueueEnrichmentInput) error
+	GetEnrichmentStatus(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) ([]model.PartEnrichment, error)
+	RetryEnrichment(ctx context.Context, in RetryEnrichmentInput) (int64, error)
+	ProcessPending(ctx context.Context, limit int) (int, error)
+}
+
+type EnqueueEnrichmentInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	Parts     []model.Part
+	// Replace drops the message's earlier jobs, for parts that replaced previous ones.
+	Replace bool
+}
+
+type RetryEnrichmentInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	// PartIndex limits the retry to one part; nil retries every failed job of the message.
+	PartIndex *int
+}
+
+type enrichmentService struct {
+	enrichmentRepo     repo.PartEnrichmentRepo
+	sessionRepo        repo.SessionRepo
+	assetReferenceRepo repo.AssetReferenceRepo
+	assetRefBuffer     repo.AssetRefBuffer
+	registry           *enricher.Registry
+	s3                 *blob.S3Deps
+	cfg                *config.Config
+	log                *zap.Logger
+}
+
+func NewEnrichmentService(enrichmentRepo repo.PartEnrichmentRepo, sessionRepo repo.SessionRepo, assetReferenceRepo repo.AssetReferenceRepo, assetRefBuffer repo.AssetRefBuffer, registry *enricher.Registry, s3 *blob.S3Deps, cfg *config.Config, log *zap.Logger) EnrichmentService {
+	return &enrichmentService{
+		enrichmentRepo:     enrichmentRepo,
+		sessionRepo:        sessionRepo,
+		assetReferenceRepo: assetReferenceRepo,
+		assetRefBuffer:     assetRefBuffer,
+		registry:           registry,
+		s3:                 s3,
+		cfg:                cfg,
+		log:                log,
+	}
+}
+
+// isEnrichablePart reports whether p is a media part backed by a stored asset.
+func isEnrichablePart(p model.Part) bool {
+	switch p.Type {
+	case model.PartTypeImage, model.PartTypeAudio, model.PartTypeVideo:
+		return p.Asset != nil && p.Asset.S3Key != ""
+	}
+	return false
+}
+
+// EnqueueParts queues a job for every registered enricher that accepts a media part's asset,
+// skipping enrichers whose result the part already carries.
+func (s *enrichmentService) EnqueueParts(ctx context.Context, in EnqueueEnrichmentInput) error {
+	var jobs []model.PartEnrichment
+	for idx, p := range in.Parts {
+		if !isEnrichablePart(p) {
+			continue
+		}
+		for _, e := range s.registry.For(*p.Asset) {
+			if _, ok := p.Meta[e.Name()]; ok {
+				continue
+			}
+			jobs = append(jobs, model.PartEnrichment{
+				ProjectID: in.ProjectID,
+				SessionID: in.SessionID,
+				MessageID: in.MessageID,
+				PartIndex: idx,
+				Enricher:  e.Name(),
+				AssetMeta: datatypes.NewJSONType(*p.Asset),
+				Status:    model.EnrichmentStatusPending,
+			})
+		}
+	}
+	if len(jobs) == 0 && !in.Replace {
+		return nil
+	}
+	if err := s.enrichmentRepo.ReplaceForMessage(ctx, in.MessageID, jobs); err != nil {
+		return fmt.Errorf("enqueue enrichment: %w", err)
+	}
+	return nil
+}
+
+// GetEnrichmentStatus returns the enrichment jobs of a message, one per part and enricher.
+func (s *enrichmentService) GetEnrichmentStatus(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) ([]model.PartEnrichment, error) {
+	if err := s.checkMessage(ctx, projectID, sessionID, messageID); err != nil {
+		return nil, err
+	}
+	jobs, err := s.enrichmentRepo.ListByMessage(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("list enrichments: %w", err)
+	}
+	return jobs, nil
+}
+
+// RetryEnrichment re-queues failed jobs of a message and returns how many were re-queued.
+func (s *enrichmentService) RetryEnrichment(ctx context.Context, in RetryEnrichmentInput) (int64, error) {
+	if err := s.checkMessage(ctx, in.ProjectID, in.SessionID, in.MessageID); err != nil {
+		return 0, err
+	}
+	n, err := s.enrichmentRepo.RetryFailed(ctx, in.MessageID, in.PartIndex)
+	if err != nil {
+		return 0, fmt.Errorf("retry enrichments: %w", err)
+	}
+	return n, nil
+}
+
+func (s *enrichmentService) checkMessage(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) error {
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: sessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.ProjectID != projectID {
+		return ErrSessionNotFound
+	}
+	if _, err := s.sessionRepo.GetMessageByID(ctx, sessionID, messageID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMessageNotFound
+		}
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	return nil
+}
+
+// ProcessPending claims up to limit queued jobs and runs them. It returns the number of jobs claimed.
+func (s *enrichmentService) ProcessPending(ctx context.Context, limit int) (int, error) {
+	staleBefore := time.Now().Add(-time.Duration(s.cfg.Enrichment.JobTimeoutSec) * time.Second)
+	jobs, err := s.enrichmentRepo.ClaimPending(ctx, staleBefore, limit)
+	if err != nil {
+		return 0, fmt.Errorf("claim enrichments: %w", err)
+	}
+
+	for _, job := range jobs {
+		status, errMsg := model.EnrichmentStatusDone, ""
+		if err := s.runJob(ctx, job); err != nil {
+			status, errMsg = model.EnrichmentStatusFailed, err.Error()
+			s.log.Warn("enrichment failed",
+				zap.String("message_id", job.MessageID.String()),
+				zap.Int("part_index", job.PartIndex),
+				zap.String("enricher", job.Enricher),
+				zap.Error(err))
+		}
+		if err := s.enrichmentRepo.Complete(ctx, job.ID, status, errMsg); err != nil {
+			s.log.Error("record enrichment outcome", zap.String("job_id", job.ID.String()), zap.Error(err))
+		}
+	}
+	return len(jobs), nil
+}
+
+func (s *enrichmentService) runJob(ctx context.Context, job model.PartEnrichment) error {
+	e, ok := s.registry.Get(job.Enricher)
+	if !ok {
+		return fmt.Errorf("enricher %q is not registered", job.Enricher)
+	}
+	asset := job.AssetMeta.Data()
+	if limit := s.cfg.Enrichment.MaxAssetBytes; limit > 0 && asset.SizeB > limit {
+		return fmt.Errorf("asset of %d bytes exceeds the enrichment limit of %d bytes", asset.SizeB, limit)
+	}
+
+	content, err := s.s3.DownloadFile(ctx, asset.S3Key, nil)
+	if err != nil {
+		return fmt.Errorf("download asset: %w", err)
+	}
+	result, err := e.Process(ctx, asset, content)
+	if err != nil {
+		return err
+	}
+	return s.writeResult(ctx, job, result)
+}
+
+// writeResult stores result in the Meta of the job's part, or in its Asset.Meta for
+// enricher.AssetMetadata, as a new parts object. The write is retried when the parts change
+// concurrently, and dropped once the part no longer holds the asset the job was queued for.
+func (s *enrichmentService) writeResult(ctx context.Context, job model.PartEnrichment, result map[string]any) error {
+	assetSHA := job.AssetMeta.Data().SHA256
+	for attempt := 0; attempt < enrichmentWriteRetries; attempt++ {
+		msg, err := s.sessionRepo.GetMessageByID(ctx, job.SessionID, job.MessageID)
+		if err != nil {
+			return fmt.Errorf("get message: %w", err)
+		}
+		current := msg.PartsAssetMeta.Data()
+		var parts []model.Part
+		if err := s.s3.DownloadJSON(ctx, current.S3Key, &parts, nil); err != nil {
+			return fmt.Errorf("download parts: %w", err)
+		}
+		if job.PartIndex >= len(parts) || parts[job.PartIndex].Asset == nil || parts[job.PartIndex].Asset.SHA256 != assetSHA {
+			return errors.New("part changed since the enrichment was queued")
+		}
+
+		part := &parts[job.PartIndex]
+		if job.Enricher == enricher.AssetMetadataName {
+			part.Asset.Meta = result
+		} else {
+			if part.Meta == nil {
+				part.Meta = map[string]any{}
+			}
+			part.Meta[job.Enricher] = result
+		}
+
+		prepared, err := s.s3.PrepareJSONAsset("parts/"+job.ProjectID.String(), parts)
+		if err != nil {
+			return fmt.Errorf("prepare parts asset: %w", err)
+		}
+		if err := s.s3.UploadPrepared(ctx, prepared, nil); err != nil {
+			return fmt.Errorf("upload parts asset: %w", err)
+		}
+
+		_, err = s.sessionRepo.ReplaceMessageParts(ctx, job.SessionID, job.MessageID, current.SHA256, func(m *model.Message) error {
+			m.PartsAssetMeta = datatypes.NewJSONType(prepared.Asset)
+			m.StorageBytes = messageStorageBytes(prepared.Asset, parts)
+			return nil
+		})
+		if errors.Is(err, repo.ErrPartsChanged) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("replace parts: %w", err)
+		}
+
+		// The message now holds the new parts object instead of the old one.
+		if err := s.assetRefBuffer.Enqueue(ctx, job.ProjectID, []model.Asset{prepared.Asset}); err != nil {
+			s.log.Error("failed to enqueue asset ref increments",
+				zap.String("project_id", job.ProjectID.String()), zap.Error(err))
+		}
+		if err := s.assetReferenceRepo.DecrementAssetRef(ctx, job.ProjectID, current); err != nil {
+			s.log.Warn("release replaced parts asset", zap.String("sha256", current.SHA256), zap.Error(err))
+		}
+		if job.Enricher == enricher.AssetMetadataName {
+			// Later messages linking the stored asset get the metadata without extracting it again.
+			if err := s.assetReferenceRepo.SetAssetMeta(ctx, job.ProjectID, assetSHA, result); err != nil {
+				s.log.Warn("store asset metadata", zap.String("sha256", assetSHA), zap.Error(err))
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w after %d attempts", repo.ErrPartsChanged, enrichmentWriteRetries)
+}
+
+// EnrichmentWorker periodically runs queued enrichment jobs.
+type EnrichmentWorker interface {
+	Start()
+	Stop()
+}
+
+type enrichmentWorker struct {
+	svc       EnrichmentService
+	log       *zap.Logger
+	interval  time.Duration
+	batchSize int
+	timeout   time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewEnrichmentWorker(svc EnrichmentService, cfg *config.Config, log *zap.Logger) EnrichmentWorker {
+	return &enrichmentWorker{
+		svc:       svc,
+		log:       log,
+		interval:  time.Duration(cfg.Enrichment.PollIntervalSec) * time.Second,
+		batchSize: cfg.Enrichment.BatchSize,
+		timeout:   time.Duration(cfg.Enrichment.JobTimeoutSec) * time.Second,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins the background worker goroutine. The worker is disabled when the poll
+// interval, batch size or job timeout is not positive.
+func (w *enrichmentWorker) Start() {
+	if w.interval <= 0 || w.batchSize <= 0 || w.timeout <= 0 {
+		close(w.done)
+		return
+	}
+	go w.run()
+}
+
+// Stop signals the worker to exit and waits for an in-flight batch to finish.
+func (w *enrichmentWorker) Stop() {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	close(w.stop)
+	<-w.done
+}
+
+func (w *enrichmentWorker) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.drain()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// drain runs batches until the queue is empty or the worker is stopped. Jobs are claimed
+// with SKIP LOCKED, so every pod can drain concurrently.
+func (w *enrichmentWorker) drain() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+		n, err := w.svc.ProcessPending(ctx, w.batchSize)
+		cancel()
+		if err != nil {
+			w.log.Error("EnrichmentWorker: processing failed", zap.Error(err))
+			return
+		}
+		if n < w.batchSize {
+			return
+		}
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/enricher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type MockPartEnrichmentRepo struct {
+	mock.Mock
+}
+
+func (m *MockPartEnrichmentRepo) ReplaceForMessage(ctx context.Context, messageID uuid.UUID, jobs []model.PartEnrichment) error {
+	args := m.Called(ctx, messageID, jobs)
+	return args.Error(0)
+}
+
+func (m *MockPartEnrichmentRepo) ListByMessage(ctx context.Context, messageID uuid.UUID) ([]model.PartEnrichment, error) {
+	args := m.Called(ctx, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.PartEnrichment), args.Error(1)
+}
+
+func (m *MockPartEnrichmentRepo) ClaimPending(ctx context.Context, staleBefore time.Time, limit int) ([]model.PartEnrichment, error) {
+	args := m.Called(ctx, staleBefore, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.PartEnrichment), args.Error(1)
+}
+
+func (m *MockPartEnrichmentRepo) Complete(ctx context.Context, id uuid.UUID, status string, errMsg string) error {
+	args := m.Called(ctx, id, status, errMsg)
+	return args.Error(0)
+}
+
+func (m *MockPartEnrichmentRepo) RetryFailed(ctx context.Context, messageID uuid.UUID, partIndex *int) (int64, error) {
+	args := m.Called(ctx, messageID, partIndex)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockPartEnrichmentRepo) CountPending(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type mimeEnricher struct {
+	name   string
+	prefix string
+}
+
+func (e mimeEnricher) Name() string { return e.name }
+
+func (e mimeEnricher) Accepts(asset model.Asset) bool { return strings.HasPrefix(asset.MIME, e.prefix) }
+
+func (e mimeEnricher) Process(ctx context.Context, asset model.Asset, content []byte) (map[string]any, error) {
+	return map[string]any{"text": "recognized"}, nil
+}
+
+func newTestEnrichmentService(t *testing.T, enrichments *MockPartEnrichmentRepo, sessions *MockSessionRepo) EnrichmentService {
+	registry := enricher.NewRegistry()
+	require.NoError(t, registry.Register(mimeEnricher{name: model.MetaKeyOCR, prefix: "image/"}))
+	require.NoError(t, registry.Register(mimeEnricher{name: model.MetaKeyCaption, prefix: "image/"}))
+	require.NoError(t, registry.Register(mimeEnricher{name: model.MetaKeyASR, prefix: "audio/"}))
+	cfg := &config.Config{Enrichment: config.EnrichmentCfg{JobTimeoutSec: 600}}
+	return NewEnrichmentService(enrichments, sessions, &MockAssetReferenceRepo{}, &MockAssetRefBuffer{}, registry, nil, cfg, zap.NewNop())
+}
+
+func TestEnrichmentService_EnqueueParts(t *testing.T) {
+	ctx := context.Background()
+	messageID := uuid.New()
+	image := &model.Asset{S3Key: "assets/p/img.png", MIME: "image/png", SHA256: "img"}
+	audio := &model.Asset{S3Key: "assets/p/a.mp3", MIME: "audio/mpeg", SHA256: "aud"}
+
+	t.Run("queues accepting enrichers per media part", func(t *testing.T) {
+		enrichments := &MockPartEnrichmentRepo{}
+		var queued []model.PartEnrichment
+		enrichments.On("ReplaceForMessage", ctx, messageID, mock.Anything).Run(func(args mock.Arguments) {
+			queued = args.Get(2).([]model.PartEnrichment)
+		}).Return(nil).Once()
+		svc := newTestEnrichmentService(t, enrichments, &MockSessionRepo{})
+
+		err := svc.EnqueueParts(ctx, EnqueueEnrichmentInput{
+			MessageID: messageID,
+			Parts: []model.Part{
+				{Type: model.PartTypeText, Text: "look"},
+				{Type: model.PartTypeImage, Asset: image, Meta: map[string]any{model.MetaKeyCaption: "already there"}},
+				{Type: model.PartTypeAudio, Asset: audio},
+				{Type: model.PartTypeFile, Asset: image},
+			},
+		})
+		assert.NoError(t, err)
+
+		var got []string
+		for _, j := range queued {
+			assert.Equal(t, model.EnrichmentStatusPending, j.Status)
+			got = append(got, fmt.Sprintf("%s@%d", j.Enricher, j.PartIndex))
+		}
+		assert.Equal(t, []string{"ocr@1", "asr@2"}, got)
+	})
+
+	t.Run("nothing to queue for a new message", func(t *testing.T) {
+		enrichments := &MockPartEnrichmentRepo{}
+		svc := newTestEnrichmentService(t, enrichments, &MockSessionRepo{})
+
+		err := svc.EnqueueParts(ctx, EnqueueEnrichmentInput{
+			MessageID: messageID,
+			Parts:     []model.Part{{Type: model.PartTypeText, Text: "hi"}},
+		})
+		assert.NoError(t, err)
+		enrichments.AssertNotCalled(t, "ReplaceForMessage", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("replacing parts drops earlier jobs", func(t *testing.T) {
+		enrichments := &MockPartEnrichmentRepo{}
+		enrichments.On("ReplaceForMessage", ctx, messageID, []model.PartEnrichment(nil)).Return(nil).Once()
+		svc := newTestEnrichmentService(t, enrichments, &MockSessionRepo{})
+
+		err := svc.EnqueueParts(ctx, EnqueueEnrichmentInput{
+			MessageID: messageID,
+			Parts:     []model.Part{{Type: model.PartTypeText, Text: "hi"}},
+			Replace:   true,
+		})
+		assert.NoError(t, err)
+		enrichments.AssertExpectations(t)
+	})
+}
+
+func TestEnrichmentService_GetEnrichmentStatus(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	messageID := uuid.New()
+
+	t.Run("lists jobs of the message", func(t *testing.T) {
+		sessions := &MockSessionRepo{}
+		sessions.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		sessions.On("GetMessageByID", ctx, sessionID, messageID).Return(&model.Message{ID: messageID}, nil)
+		enrichments := &MockPartEnrichmentRepo{}
+		jobs := []model.PartEnrichment{{MessageID: messageID, Enricher: model.MetaKeyOCR, Status: model.EnrichmentStatusFailed, Error: "boom"}}
+		enrichments.On("ListByMessage", ctx, messageID).Return(jobs, nil)
+
+		got, err := newTestEnrichmentService(t, enrichments, sessions).GetEnrichmentStatus(ctx, projectID, sessionID, messageID)
+		assert.NoError(t, err)
+		assert.Equal(t, jobs, got)
+	})
+
+	t.Run("session of another project", func(t *testing.T) {
+		sessions := &MockSessionRepo{}
+		sessions.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: uuid.New()}, nil)
+
+		_, err := newTestEnrichmentService(t, &MockPartEnrichmentRepo{}, sessions).GetEnrichmentStatus(ctx, projectID, sessionID, messageID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("missing message", func(t *testing.T) {
+		sessions := &MockSessionRepo{}
+		sessions.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		sessions.On("GetMessageByID", ctx, sessionID, messageID).Return(nil, gorm.ErrRecordNotFound)
+
+		_, err := newTestEnrichmentService(t, &MockPartEnrichmentRepo{}, sessions).GetEnrichmentStatus(ctx, projectID, sessionID, messageID)
+		assert.ErrorIs(t, err, ErrMessageNotFound)
+	})
+}
+
+func TestEnrichmentService_RetryEnrichment(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	messageID := uuid.New()
+	partIndex := 1
+
+	sessions := &MockSessionRepo{}
+	sessions.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+	sessions.On("GetMessageByID", ctx, sessionID, messageID).Return(&model.Message{ID: messageID}, nil)
+	enrichments := &MockPartEnrichmentRepo{}
+	enrichments.On("RetryFailed", ctx, messageID, &partIndex).Return(int64(2), nil)
+
+	n, err := newTestEnrichmentService(t, enrichments, sessions).RetryEnrichment(ctx, RetryEnrichmentInput{
+		ProjectID: projectID,
+		SessionID: sessionID,
+		MessageID: messageID,
+		PartIndex: &partIndex,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
+
+func TestEnrichmentService_ProcessPending(t *testing.T) {
+	ctx := context.Background()
+	staleNear := mock.MatchedBy(func(ts time.Time) bool {
+		return ts.Sub(time.Now().Add(-10*time.Minute)).Abs() < time.Minute
+	})
+
+	t.Run("unregistered enricher fails its job", func(t *testing.T) {
+		job := model.PartEnrichment{ID: uuid.New(), MessageID: uuid.New(), Enricher: "translate", AssetMeta: datatypes.NewJSONType(model.Asset{})}
+		enrichments := &MockPartEnrichmentRepo{}
+		enrichments.On("ClaimPending", ctx, staleNear, 5).Return([]model.PartEnrichment{job}, nil)
+		enrichments.On("Complete", ctx, job.ID, model.EnrichmentStatusFailed, `enricher "translate" is not registered`).Return(nil).Once()
+
+		n, err := newTestEnrichmentService(t, enrichments, &MockSessionRepo{}).ProcessPending(ctx, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		enrichments.AssertExpectations(t)
+	})
+
+	t.Run("oversized asset fails its job", func(t *testing.T) {
+		job := model.PartEnrichment{ID: uuid.New(), Enricher: model.MetaKeyOCR, AssetMeta: datatypes.NewJSONType(model.Asset{SizeB: 2048})}
+		enrichments := &MockPartEnrichmentRepo{}
+		enrichments.On("ClaimPending", ctx, staleNear, 5).Return([]model.PartEnrichment{job}, nil)
+		enrichments.On("Complete", ctx, job.ID, model.EnrichmentStatusFailed, mock.MatchedBy(func(msg string) bool {
+			return strings.Contains(msg, "exceeds the enrichment limit")
+		})).Return(nil).Once()
+
+		registry := enricher.NewRegistry()
+		require.NoError(t, registry.Register(mimeEnricher{name: model.MetaKeyOCR, prefix: "image/"}))
+		cfg := &config.Config{Enrichment: config.EnrichmentCfg{JobTimeoutSec: 600, MaxAssetBytes: 1024}}
+		svc := NewEnrichmentService(enrichments, &MockSessionRepo{}, &MockAssetReferenceRepo{}, &MockAssetRefBuffer{}, registry, nil, cfg, zap.NewNop())
+
+		_, err := svc.ProcessPending(ctx, 5)
+		assert.NoError(t, err)
+		enrichments.AssertExpectations(t)
+	})
+
+	t.Run("claim failure", func(t *testing.T) {
+		enrichments := &MockPartEnrichmentRepo{}
+		enrichments.On("ClaimPending", ctx, staleNear, 5).Return(nil, errors.New("db down"))
+
+		_, err := newTestEnrichmentService(t, enrichments, &MockSessionRepo{}).ProcessPending(ctx, 5)
+		assert.ErrorContains(t, err, "db down")
+	})
+}
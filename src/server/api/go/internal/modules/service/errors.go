@@ -9,6 +9,124 @@ var (
 	ErrSessionTooLarge = errors.New("session exceeds maximum copyable size")
 	ErrCopyFailed      = errors.New("failed to copy session")
 
+	// Fork-related errors
+	ErrMessageNotInSession = errors.New("message does not belong to session")
+
+	// Shallow clone errors
+	ErrMessageShared = errors.New("message is shared with a shallow clone")
+	ErrSessionShared = errors.New("session shares messages with a shallow clone")
+
+	// Message tree errors
+	ErrMessageNotFound       = errors.New("message not found")
+	ErrMessageCycle          = errors.New("message parent chain contains a cycle")
+	ErrReparentCycle         = errors.New("message cannot be moved under its own subtree")
+	ErrInvalidTreeRepairMode = errors.New("tree repair mode must be reattach or delete")
+
+	// Message author errors
+	ErrInvalidAuthor = errors.New("invalid message author")
+
+	// Message annotation errors
+	ErrInvalidAnnotation  = errors.New("invalid message annotation")
+	ErrAnnotationNotFound = errors.New("annotation not found")
+
+	// Bulk delete errors
+	ErrEmptyMessageFilter = errors.New("at least one of role, since, until or flagged is required")
+
+	// Streaming errors
+	ErrMessageNotStreaming = errors.New("message is not streaming")
+	ErrStreamingEncrypted  = errors.New("streaming is not available for encrypted projects")
+	ErrMessageStreaming    = errors.New("message is still streaming")
+
+	// Message asset errors
+	ErrAssetNotFound        = errors.New("asset not found")
+	ErrAssetAttachEncrypted = errors.New("attaching stored assets is not available for encrypted projects")
+	ErrEncryptedRangeLarge  = errors.New("range requests are not available for large encrypted assets")
+	ErrMessagePartsChanged  = errors.New("message parts changed concurrently")
+	ErrVersionConflict      = errors.New("message version conflict")
+	ErrInvalidPatch         = errors.New("invalid parts patch")
+	ErrPatchTestFailed      = errors.New("parts patch test failed")
+	ErrPatchSensitive       = errors.New("parts patch addresses a sensitive part")
+
+	// Vector search errors
+	ErrVectorUnsupported    = errors.New("vector search is not supported on this deployment")
+	ErrEmbeddingDimMismatch = errors.New("embedding dimension mismatch")
+	ErrEmbedderUnavailable  = errors.New("no embedding provider is configured")
+	ErrReindexEncrypted     = errors.New("embedding reindex is not available for encrypted projects")
+	ErrReindexRunning       = errors.New("an embedding reindex is already running for the project")
+	ErrReindexNotFound      = errors.New("embedding reindex not found")
+
+	// Import errors
+	ErrInvalidImport = errors.New("invalid import")
+
+	// Presigned upload errors
+	ErrUploadInvalid        = errors.New("invalid upload")
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadExpired        = errors.New("upload has expired")
+	ErrUploadTooLarge       = errors.New("upload exceeds maximum size")
+	ErrUploadMIMENotAllowed = errors.New("upload MIME type is not allowed")
+	ErrUploadObjectMissing  = errors.New("uploaded object not found")
+	ErrUploadEncrypted      = errors.New("presigned uploads are not available for encrypted projects")
+
+	// MIME errors
+	ErrInvalidMIME  = errors.New("invalid MIME type")
+	ErrMIMEMismatch = errors.New("declared MIME type does not match the content")
+
+	// Asset GC errors
+	ErrInvalidOrphanAge = errors.New("invalid orphan age")
+
+	// Job queue errors
+	ErrUnknownJobKind    = errors.New("no handler is registered for the job kind")
+	ErrInvalidJobPayload = errors.New("job payload is not valid JSON")
+	ErrJobNotFound       = errors.New("job not found")
+
+	// Quota errors
+	ErrQuotaExceeded   = errors.New("storage quota exceeded")
+	ErrMessageTooLarge = errors.New("message exceeds a size limit")
+
+	// Session label errors
+	ErrInvalidTag = errors.New("invalid tag")
+
+	// Part-type allowlist errors
+	ErrInvalidPartType = errors.New("invalid part type")
+
+	// Summary errors
+	ErrSummarizerUnavailable = errors.New("no summarizer is configured")
+	ErrSummaryEncrypted      = errors.New("summaries are not available for encrypted projects")
+	ErrSummaryConflict       = errors.New("session was summarized concurrently")
+
+	// Template errors
+	ErrNotTemplate             = errors.New("session is not a template")
+	ErrInvalidTemplateOverride = errors.New("invalid template override")
+
+	// System prompt errors
+	ErrSystemPromptTooLong = errors.New("system prompt exceeds maximum length")
+	ErrProjectNotFound     = errors.New("project not found")
+
+	// Archive errors
+	ErrSessionArchived    = errors.New("session is archived")
+	ErrSessionNotArchived = errors.New("session is not archived")
+
+	// Immutability errors
+	ErrSessionFinalized = errors.New("session is finalized")
+	ErrMessageImmutable = errors.New("message is past its immutability window")
+	ErrInvalidOverride  = errors.New("immutability override requires a reason")
+
+	// Merge errors
+	ErrMergeIntoSelf    = errors.New("session cannot be merged into itself")
+	ErrNoMergeSources   = errors.New("at least one source session is required")
+	ErrInvalidMergeMode = errors.New("merge mode must be attach or branches")
+
+	// Session lock errors
+	ErrSessionLockNotHeld      = errors.New("session lock is not held by the caller")
+	ErrInvalidLockHolder       = errors.New("lock holder id is required")
+	ErrInvalidLockTTL          = errors.New("lock ttl is out of range")
+	ErrSessionLocksUnavailable = errors.New("session locks are not available")
+
+	// Project key errors
+	ErrProjectKeyNotFound = errors.New("project key not found")
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrInvalidKeyName     = errors.New("invalid project key name")
+
 	// General session errors
 	ErrUnauthorized = errors.New("unauthorized access to session")
 )
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	maxDeadJobsListed = 200              // Bounds how many dead jobs one listing returns
+	jobOutcomeTimeout = 10 * time.Second // Bounds recording the outcome of one run
+)
+
+// JobHandler runs one job of the kind it is registered for. A returned error, or a panic, fails
+// the run; the job is retried until it runs out of attempts. Handlers must be idempotent: a job is
+// run again when its worker dies before recording the outcome.
+type JobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// JobRegistry holds the handlers of the job kinds this process runs. It is safe for concurrent
+// use, so handlers may be registered after the worker has started.
+type JobRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+}
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{handlers: make(map[string]JobHandler)}
+}
+
+// Register sets the handler of kind. Kinds must be unique.
+func (r *JobRegistry) Register(kind string, h JobHandler) error {
+	if kind == "" {
+		return errors.New("job kind must not be empty")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handlers[kind]; ok {
+		return fmt.Errorf("job kind %q is already registered", kind)
+	}
+	r.handlers[kind] = h
+	return nil
+}
+
+// Get returns the handler registered for kind.
+func (r *JobRegistry) Get(kind string) (JobHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[kind]
+	return h, ok
+}
+
+// Kinds returns the registered kinds in name order.
+func (r *JobRegistry) Kinds() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	kinds := make([]string, 0, len(r.handlers))
+	for kind := range r.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// JobQueue is a durable queue of background jobs stored in the database.
+type JobQueue interface {
+	EnqueueJob(ctx context.Context, kind string, payload json.RawMessage) (*model.Job, error)
+	ProcessDue(ctx context.Context, limit int) (int, error)
+	ListDeadJobs(ctx context.Context, kind string, limit int) ([]model.Job, error)
+	RetryDeadJob(ctx context.Context, id uuid.UUID) error
+}
+
+type jobQueue struct {
+	jobRepo  repo.JobRepo
+	registry *JobRegistry
+	cfg      *config.Config
+	log      *zap.Logger
+	now      func() time.Time
+	timeout  time.Duration
+}
+
+func NewJobQueue(jobRepo repo.JobRepo, registry *JobRegistry, cfg *config.Config, log *zap.Logger) JobQueue {
+	return &jobQueue{
+		jobRepo:  jobRepo,
+		registry: registry,
+		cfg:      cfg,
+		log:      log,
+		now:      time.Now,
+		timeout:  time.Duration(cfg.Jobs.JobTimeoutSec) * time.Second,
+	}
+}
+
+// EnqueueJob queues a job of a registered kind, due immediately. An empty payload is stored as
+// an empty object.
+func (q *jobQueue) EnqueueJob(ctx context.Context, kind string, payload json.RawMessage) (*model.Job, error) {
+	if _, ok := q.registry.Get(kind); !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownJobKind, kind)
+	}
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+	if !json.Valid(payload) {
+		return nil, ErrInvalidJobPayload
+	}
+
+	job := &model.Job{
+		Kind:        kind,
+		Payload:     datatypes.JSON(payload),
+		Status:      model.JobStatusPending,
+		MaxAttempts: max(q.cfg.Jobs.MaxAttempts, 1),
+		RunAt:       q.now(),
+	}
+	if err := q.jobRepo.Enqueue(ctx, job); err != nil {
+		return nil, fmt.Errorf("enqueue job: %w", err)
+	}
+	return job, nil
+}
+
+// ProcessDue claims up to limit due jobs of the registered kinds and runs them one after the
+// other, each within the job timeout. A job that succeeds is deleted; one that fails is
+// rescheduled after a backoff, or moved to the dead letters once it has used its attempts. The
+// outcome is recorded even when the run used up its timeout. It returns the number of jobs
+// claimed.
+func (q *jobQueue) ProcessDue(ctx context.Context, limit int) (int, error) {
+	now := q.now()
+	staleBefore := now.Add(-q.timeout)
+	jobs, err := q.jobRepo.Claim(ctx, q.registry.Kinds(), now, staleBefore, limit)
+	if err != nil {
+		return 0, fmt.Errorf("claim jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		runCtx, cancel := context.WithTimeout(ctx, q.timeout)
+		runErr := q.run(runCtx, job)
+		cancel()
+		q.recordOutcome(ctx, job, runErr)
+	}
+	return len(jobs), nil
+}
+
+// recordOutcome deletes, reschedules or buries job after a run, on a context of its own so a run
+// that used up its timeout is still recorded instead of being reclaimed as stale.
+func (q *jobQueue) recordOutcome(ctx context.Context, job model.Job, runErr error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobOutcomeTimeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case runErr == nil:
+		err = q.jobRepo.Delete(ctx, job.ID)
+	case job.Attempts >= job.MaxAttempts:
+		q.log.Error("job dead-lettered",
+			zap.String("job_id", job.ID.String()),
+			zap.String("kind", job.Kind),
+			zap.Int("attempts", job.Attempts),
+			zap.Error(runErr))
+		err = q.jobRepo.Bury(ctx, job.ID, runErr.Error())
+	default:
+		delay := q.backoff(job.Attempts)
+		q.log.Warn("job failed, retrying",
+			zap.String("job_id", job.ID.String()),
+			zap.String("kind", job.Kind),
+			zap.Int("attempts", job.Attempts),
+			zap.Duration("retry_in", delay),
+			zap.Error(runErr))
+		err = q.jobRepo.Reschedule(ctx, job.ID, q.now().Add(delay), runErr.Error())
+	}
+	if err != nil {
+		q.log.Error("record job outcome", zap.String("job_id", job.ID.String()), zap.Error(err))
+	}
+}
+
+// run calls the job's handler, turning a panic into an error so one bad job cannot stop the worker.
+func (q *jobQueue) run(ctx context.Context, job model.Job) (err error) {
+	handler, ok := q.registry.Get(job.Kind)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownJobKind, job.Kind)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, json.RawMessage(job.Payload))
+}
+
+// backoff returns the delay before the retry that follows the attempt-th failed run: the base
+// delay doubled for every earlier failure, capped at the configured maximum.
+func (q *jobQueue) backoff(attempt int) time.Duration {
+	base := time.Duration(q.cfg.Jobs.BackoffBaseSec) * time.Second
+	ceiling := time.Duration(q.cfg.Jobs.BackoffMaxSec) * time.Second
+	delay := base
+	for i := 1; i < attempt && delay < ceiling; i++ {
+		delay *= 2
+	}
+	if ceiling > 0 && delay > ceiling {
+		delay = ceiling
+	}
+	return delay
+}
+
+// ListDeadJobs returns dead jobs, of kind when it is set, most recently failed first.
+func (q *jobQueue) ListDeadJobs(ctx context.Context, kind string, limit int) ([]model.Job, error) {
+	if limit <= 0 || limit > maxDeadJobsListed {
+		limit = maxDeadJobsListed
+	}
+	jobs, err := q.jobRepo.ListDead(ctx, kind, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list dead jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// RetryDeadJob queues a dead job again with a fresh set of attempts.
+func (q *jobQueue) RetryDeadJob(ctx context.Context, id uuid.UUID) error {
+	if err := q.jobRepo.RequeueDead(ctx, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrJobNotFound
+		}
+		return fmt.Errorf("requeue job: %w", err)
+	}
+	return nil
+}
+
+// JobWorker periodically runs due jobs.
+type JobWorker interface {
+	Start()
+	Stop()
+}
+
+type jobWorker struct {
+	queue     JobQueue
+	log       *zap.Logger
+	interval  time.Duration
+	batchSize int
+	timeout   time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewJobWorker(queue JobQueue, cfg *config.Config, log *zap.Logger) JobWorker {
+	return &jobWorker{
+		queue:     queue,
+		log:       log,
+		interval:  time.Duration(cfg.Jobs.PollIntervalSec) * time.Second,
+		batchSize: cfg.Jobs.BatchSize,
+		timeout:   time.Duration(cfg.Jobs.JobTimeoutSec) * time.Second,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins the background worker goroutine. The worker is disabled when the poll
+// interval, batch size or job timeout is not positive.
+func (w *jobWorker) Start() {
+	if w.interval <= 0 || w.batchSize <= 0 || w.timeout <= 0 {
+		close(w.done)
+		return
+	}
+	go w.run()
+}
+
+// Stop signals the worker to exit and waits for an in-flight batch to finish.
+func (w *jobWorker) Stop() {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	close(w.stop)
+	<-w.done
+}
+
+func (w *jobWorker) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.drain()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// drain runs batches until no job is due or the worker is stopped. Jobs are claimed with
+// SKIP LOCKED, so every pod can drain concurrently. ProcessDue bounds each job by the job
+// timeout, not the batch.
+func (w *jobWorker) drain() {
+	for {
+		n, err := w.queue.ProcessDue(context.Background(), w.batchSize)
+		if err != nil {
+			w.log.Error("JobWorker: processing failed", zap.Error(err))
+			return
+		}
+		if n < w.batchSize {
+			return
+		}
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+	}
+}
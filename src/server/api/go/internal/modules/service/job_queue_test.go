@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type MockJobRepo struct {
+	mock.Mock
+}
+
+func (m *MockJobRepo) Enqueue(ctx context.Context, job *model.Job) error {
+	return m.Called(ctx, job).Error(0)
+}
+
+func (m *MockJobRepo) Claim(ctx context.Context, kinds []string, now time.Time, staleBefore time.Time, limit int) ([]model.Job, error) {
+	args := m.Called(ctx, kinds, now, staleBefore, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.Job), args.Error(1)
+}
+
+func (m *MockJobRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockJobRepo) Reschedule(ctx context.Context, id uuid.UUID, runAt time.Time, errMsg string) error {
+	return m.Called(ctx, id, runAt, errMsg).Error(0)
+}
+
+func (m *MockJobRepo) Bury(ctx context.Context, id uuid.UUID, errMsg string) error {
+	return m.Called(ctx, id, errMsg).Error(0)
+}
+
+func (m *MockJobRepo) ListDead(ctx context.Context, kind string, limit int) ([]model.Job, error) {
+	args := m.Called(ctx, kind, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.Job), args.Error(1)
+}
+
+func (m *MockJobRepo) RequeueDead(ctx context.Context, id uuid.UUID) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func newTestJobQueue(r *MockJobRepo, registry *JobRegistry, now time.Time) *jobQueue {
+	cfg := &config.Config{Jobs: config.JobsCfg{JobTimeoutSec: 600, MaxAttempts: 3, BackoffBaseSec: 10, BackoffMaxSec: 60}}
+	q := NewJobQueue(r, registry, cfg, zap.NewNop()).(*jobQueue)
+	q.now = func() time.Time { return now }
+	return q
+}
+
+func TestJobRegistry(t *testing.T) {
+	r := NewJobRegistry()
+	noop := func(context.Context, json.RawMessage) error { return nil }
+	require.NoError(t, r.Register("thumbnail", noop))
+	require.NoError(t, r.Register("asset_gc", noop))
+	assert.Error(t, r.Register("thumbnail", noop))
+	assert.Error(t, r.Register("", noop))
+
+	_, ok := r.Get("asset_gc")
+	assert.True(t, ok)
+	_, ok = r.Get("ocr")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"asset_gc", "thumbnail"}, r.Kinds())
+}
+
+func TestJobQueue_EnqueueJob(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	registry := NewJobRegistry()
+	require.NoError(t, registry.Register("thumbnail", func(context.Context, json.RawMessage) error { return nil }))
+
+	t.Run("queues a due job", func(t *testing.T) {
+		r := &MockJobRepo{}
+		r.On("Enqueue", ctx, mock.MatchedBy(func(j *model.Job) bool {
+			return j.Kind == "thumbnail" && string(j.Payload) == `{"size":128}` &&
+				j.Status == model.JobStatusPending && j.MaxAttempts == 3 && j.RunAt.Equal(now)
+		})).Return(nil)
+
+		job, err := newTestJobQueue(r, registry, now).EnqueueJob(ctx, "thumbnail", json.RawMessage(`{"size":128}`))
+		require.NoError(t, err)
+		assert.Equal(t, "thumbnail", job.Kind)
+		r.AssertExpectations(t)
+	})
+
+	t.Run("empty payload is an empty object", func(t *testing.T) {
+		r := &MockJobRepo{}
+		r.On("Enqueue", ctx, mock.MatchedBy(func(j *model.Job) bool { return string(j.Payload) == "{}" })).Return(nil)
+
+		_, err := newTestJobQueue(r, registry, now).EnqueueJob(ctx, "thumbnail", nil)
+		require.NoError(t, err)
+		r.AssertExpectations(t)
+	})
+
+	t.Run("unknown kind", func(t *testing.T) {
+		r := &MockJobRepo{}
+		_, err := newTestJobQueue(r, registry, now).EnqueueJob(ctx, "ocr", nil)
+		assert.ErrorIs(t, err, ErrUnknownJobKind)
+		r.AssertNotCalled(t, "Enqueue", mock.Anything, mock.Anything)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		r := &MockJobRepo{}
+		_, err := newTestJobQueue(r, registry, now).EnqueueJob(ctx, "thumbnail", json.RawMessage(`{"size":`))
+		assert.ErrorIs(t, err, ErrInvalidJobPayload)
+		r.AssertNotCalled(t, "Enqueue", mock.Anything, mock.Anything)
+	})
+}
+
+func TestJobQueue_ProcessDue(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	job := func(attempts int) model.Job {
+		return model.Job{ID: uuid.New(), Kind: "thumbnail", Payload: datatypes.JSON(`{"n":1}`), Status: model.JobStatusRunning, Attempts: attempts, MaxAttempts: 3}
+	}
+
+	tests := []struct {
+		name    string
+		handler JobHandler
+		job     model.Job
+		expect  func(r *MockJobRepo, j model.Job)
+	}{
+		{
+			name: "success deletes the job",
+			handler: func(_ context.Context, payload json.RawMessage) error {
+				if string(payload) != `{"n":1}` {
+					return errors.New("unexpected payload")
+				}
+				return nil
+			},
+			job:    job(1),
+			expect: func(r *MockJobRepo, j model.Job) { r.On("Delete", mock.Anything, j.ID).Return(nil) },
+		},
+		{
+			name:    "failure is retried after a backoff",
+			handler: func(context.Context, json.RawMessage) error { return errors.New("boom") },
+			job:     job(2),
+			expect: func(r *MockJobRepo, j model.Job) {
+				r.On("Reschedule", mock.Anything, j.ID, now.Add(20*time.Second), "boom").Return(nil)
+			},
+		},
+		{
+			name:    "last attempt is dead-lettered",
+			handler: func(context.Context, json.RawMessage) error { return errors.New("boom") },
+			job:     job(3),
+			expect:  func(r *MockJobRepo, j model.Job) { r.On("Bury", mock.Anything, j.ID, "boom").Return(nil) },
+		},
+		{
+			name:    "panic fails the run",
+			handler: func(context.Context, json.RawMessage) error { panic("nil map") },
+			job:     job(1),
+			expect: func(r *MockJobRepo, j model.Job) {
+				r.On("Reschedule", mock.Anything, j.ID, now.Add(10*time.Second), "job handler panicked: nil map").Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewJobRegistry()
+			require.NoError(t, registry.Register("thumbnail", tt.handler))
+			r := &MockJobRepo{}
+			r.On("Claim", ctx, []string{"thumbnail"}, now, now.Add(-600*time.Second), 10).Return([]model.Job{tt.job}, nil)
+			tt.expect(r, tt.job)
+
+			n, err := newTestJobQueue(r, registry, now).ProcessDue(ctx, 10)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			r.AssertExpectations(t)
+		})
+	}
+
+	t.Run("each job gets its own timeout and its outcome is recorded", func(t *testing.T) {
+		slow, next := job(1), job(1)
+		slow.Kind = "slow"
+		registry := NewJobRegistry()
+		require.NoError(t, registry.Register("slow", func(ctx context.Context, _ json.RawMessage) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		require.NoError(t, registry.Register("thumbnail", func(ctx context.Context, _ json.RawMessage) error {
+			return ctx.Err()
+		}))
+		live := mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() == nil })
+		r := &MockJobRepo{}
+		r.On("Claim", ctx, mock.Anything, mock.Anything, mock.Anything, 10).Return([]model.Job{slow, next}, nil)
+		r.On("Reschedule", live, slow.ID, mock.Anything, context.DeadlineExceeded.Error()).Return(nil)
+		r.On("Delete", live, next.ID).Return(nil)
+
+		q := newTestJobQueue(r, registry, now)
+		q.timeout = 10 * time.Millisecond
+		n, err := q.ProcessDue(ctx, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		r.AssertExpectations(t)
+	})
+
+	t.Run("claim error", func(t *testing.T) {
+		registry := NewJobRegistry()
+		require.NoError(t, registry.Register("thumbnail", func(context.Context, json.RawMessage) error { return nil }))
+		r := &MockJobRepo{}
+		r.On("Claim", ctx, mock.Anything, mock.Anything, mock.Anything, 10).Return(nil, errors.New("db down"))
+
+		_, err := newTestJobQueue(r, registry, now).ProcessDue(ctx, 10)
+		assert.ErrorContains(t, err, "db down")
+	})
+}
+
+func TestJobQueue_Backoff(t *testing.T) {
+	q := newTestJobQueue(&MockJobRepo{}, NewJobRegistry(), time.Now())
+	assert.Equal(t, 10*time.Second, q.backoff(1))
+	assert.Equal(t, 20*time.Second, q.backoff(2))
+	assert.Equal(t, 40*time.Second, q.backoff(3))
+	assert.Equal(t, 60*time.Second, q.backoff(4), "capped at the maximum")
+	assert.Equal(t, 60*time.Second, q.backoff(40))
+}
+
+func TestJobQueue_DeadJobs(t *testing.T) {
+	ctx := context.Background()
+	r := &MockJobRepo{}
+	q := newTestJobQueue(r, NewJobRegistry(), time.Now())
+
+	dead := []model.Job{{ID: uuid.New(), Kind: "thumbnail", Status: model.JobStatusDead}}
+	r.On("ListDead", ctx, "thumbnail", maxDeadJobsListed).Return(dead, nil)
+	jobs, err := q.ListDeadJobs(ctx, "thumbnail", 0)
+	require.NoError(t, err)
+	assert.Equal(t, dead, jobs)
+
+	missing := uuid.New()
+	r.On("RequeueDead", ctx, dead[0].ID).Return(nil)
+	r.On("RequeueDead", ctx, missing).Return(gorm.ErrRecordNotFound)
+	assert.NoError(t, q.RetryDeadJob(ctx, dead[0].ID))
+	assert.ErrorIs(t, q.RetryDeadJob(ctx, missing), ErrJobNotFound)
+}
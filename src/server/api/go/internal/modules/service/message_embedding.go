@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/memodb-io/Acontext/internal/pkg/embedder"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type MessageEmbeddingService interface {
+	UpsertEmbedding(ctx context.Context, in UpsertEmbeddingInput) (*model.MessageEmbedding, error)
+	SearchSimilar(ctx context.Context, in SearchSimilarInput) ([]SimilarMessageResult, error)
+	ReindexEmbeddings(ctx context.Context, in ReindexEmbeddingsInput) (*model.EmbeddingReindex, error)
+	GetEmbeddingReindex(ctx context.Context, projectID uuid.UUID, id uuid.UUID) (*model.EmbeddingReindex, error)
+	RunReindexJob(ctx context.Context, payload json.RawMessage) error
+}
+
+type UpsertEmbeddingInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	// Model names the model the embedding was made with; empty for embeddings of unnamed models.
+	Model     string
+	Embedding []float32
+}
+
+type SearchSimilarInput struct {
+	ProjectID uuid.UUID
+	SessionID *uuid.UUID
+	// Model selects the embeddings compared with Vector; only those of one model are comparable.
+	Model  string
+	Vector []float32
+	TopK   int
+}
+
+// SimilarMessageResult is a single vector search hit; lower distance means more similar
+type SimilarMessageResult struct {
+	MessageID uuid.UUID `json:"message_id"`
+	SessionID uuid.UUID `json:"session_id"`
+	Role      string    `json:"role"`
+	Distance  float64   `json:"distance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type messageEmbeddingService struct {
+	sessionRepo   repo.SessionRepo
+	embeddingRepo repo.MessageEmbeddingRepo
+	embedder      embedder.Embedder
+	jobQueue      JobQueue
+	cfg           *config.Config
+	log           *zap.Logger
+}
+
+// NewMessageEmbeddingService returns the embedding service. emb may be nil, in which case
+// reindexes fail with ErrEmbedderUnavailable.
+func NewMessageEmbeddingService(sessionRepo repo.SessionRepo, embeddingRepo repo.MessageEmbeddingRepo, emb embedder.Embedder, jobQueue JobQueue, cfg *config.Config, log *zap.Logger) MessageEmbeddingService {
+	return &messageEmbeddingService{
+		sessionRepo:   sessionRepo,
+		embeddingRepo: embeddingRepo,
+		embedder:      emb,
+		jobQueue:      jobQueue,
+		cfg:           cfg,
+		log:           log,
+	}
+}
+
+func (s *messageEmbeddingService) UpsertEmbedding(ctx context.Context, in UpsertEmbeddingInput) (*model.MessageEmbedding, error) {
+	if len(in.Embedding) == 0 {
+		return nil, fmt.Errorf("embedding is required")
+	}
+
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: in.SessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.ProjectID != in.ProjectID {
+		return nil, ErrSessionNotFound
+	}
+
+	msg, err := s.sessionRepo.GetMessageByID(ctx, in.SessionID, in.MessageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	e := &model.MessageEmbedding{
+		MessageID: msg.ID,
+		SessionID: in.SessionID,
+		ProjectID: in.ProjectID,
+		Model:     in.Model,
+		Embedding: in.Embedding,
+	}
+	if err := s.embeddingRepo.Upsert(ctx, e); err != nil {
+		return nil, mapEmbeddingErr(err)
+	}
+	return e, nil
+}
+
+func (s *messageEmbeddingService) SearchSimilar(ctx context.Context, in SearchSimilarInput) ([]SimilarMessageResult, error) {
+	if len(in.Vector) == 0 {
+		return nil, fmt.Errorf("vector is required")
+	}
+	if in.SessionID != nil {
+		session, err := s.sessionRepo.Get(ctx, &model.Session{ID: *in.SessionID})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrSessionNotFound
+			}
+			return nil, fmt.Errorf("failed to get session: %w", err)
+		}
+		if session.ProjectID != in.ProjectID {
+			return nil, ErrSessionNotFound
+		}
+	}
+
+	hits, err := s.embeddingRepo.SearchSimilar(ctx, in.ProjectID, in.SessionID, in.Model, in.Vector, in.TopK)
+	if err != nil {
+		return nil, mapEmbeddingErr(err)
+	}
+
+	out := make([]SimilarMessageResult, 0, len(hits))
+	for _, h := range hits {
+		out = append(out, SimilarMessageResult{
+			MessageID: h.ID,
+			SessionID: h.SessionID,
+			Role:      h.Role,
+			Distance:  h.Distance,
+			CreatedAt: h.CreatedAt,
+		})
+	}
+	return out, nil
+}
+
+// mapEmbeddingErr translates repo-level vector errors into service errors.
+func mapEmbeddingErr(err error) error {
+	switch {
+	case errors.Is(err, repo.ErrVectorUnsupported):
+		return ErrVectorUnsupported
+	case errors.Is(err, repo.ErrEmbeddingDimMismatch):
+		detail := strings.TrimPrefix(err.Error(), repo.ErrEmbeddingDimMismatch.Error()+": ")
+		return fmt.Errorf("%w (%s)", ErrEmbeddingDimMismatch, detail)
+	}
+	return err
+}
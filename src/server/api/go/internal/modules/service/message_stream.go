@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/memodb-io/Acontext/internal/pkg/metrics"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// NotificationSubscriber delivers payloads NOTIFYed on a Postgres channel; db.Listener implements it.
+type NotificationSubscriber interface {
+	Subscribe(channel string) (payloads <-chan string, unsubscribe func())
+}
+
+type MessageStreamService interface {
+	// Subscribe streams the session's message events until unsubscribe is called.
+	// The events channel is closed once the subscription ends; when it ends for another
+	// reason, such as the subscriber falling behind, a StreamEventResync event comes last.
+	Subscribe(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (events <-chan model.MessageStreamEvent, unsubscribe func(), err error)
+}
+
+type messageStreamService struct {
+	sessionRepo repo.SessionRepo
+	subscriber  NotificationSubscriber
+	log         *zap.Logger
+}
+
+func NewMessageStreamService(sessionRepo repo.SessionRepo, subscriber NotificationSubscriber, log *zap.Logger) MessageStreamService {
+	return &messageStreamService{
+		sessionRepo: sessionRepo,
+		subscriber:  subscriber,
+		log:         log,
+	}
+}
+
+func (s *messageStreamService) Subscribe(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (<-chan model.MessageStreamEvent, func(), error) {
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: sessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrSessionNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.ProjectID != projectID {
+		return nil, nil, ErrSessionNotFound
+	}
+
+	payloads, unsubscribe := s.subscriber.Subscribe(model.MessageStreamChannel(sessionID))
+	events := make(chan model.MessageStreamEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		for payload := range payloads {
+			var ev model.MessageStreamEvent
+			if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+				s.log.Warn("skipping malformed message stream event", zap.String("session_id", sessionID.String()), zap.Error(err))
+				continue
+			}
+			select {
+			case events <- ev:
+			case <-done:
+				return
+			}
+		}
+		// done is closed before unsubscribe, so a subscription ended by the caller is told apart.
+		select {
+		case <-done:
+			return
+		default:
+		}
+		select {
+		case events <- model.MessageStreamEvent{Type: model.StreamEventResync, SessionID: sessionID}:
+		case <-done:
+		}
+	}()
+
+	metrics.SSESubscribers.Inc()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			metrics.SSESubscribers.Dec()
+			close(done)
+			unsubscribe()
+		})
+	}
+	return events, stop, nil
+}
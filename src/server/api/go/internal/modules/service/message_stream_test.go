@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// fakeSubscriber hands out one buffered payload channel per Subscribe call
+type fakeSubscriber struct {
+	channel      string
+	payloads     chan string
+	unsubscribed bool
+}
+
+func (f *fakeSubscriber) Subscribe(channel string) (<-chan string, func()) {
+	f.channel = channel
+	f.payloads = make(chan string, 8)
+	return f.payloads, func() {
+		if !f.unsubscribed {
+			f.unsubscribed = true
+			close(f.payloads)
+		}
+	}
+}
+
+func TestMessageStreamService_Subscribe(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	t.Run("decodes events and skips malformed payloads", func(t *testing.T) {
+		sessionRepo := &MockSessionRepo{}
+		sessionRepo.On("Get", mock.Anything, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		sub := &fakeSubscriber{}
+		svc := NewMessageStreamService(sessionRepo, sub, zap.NewNop())
+		subscribers := metrics.SSESubscribers.Value()
+
+		events, unsubscribe, err := svc.Subscribe(ctx, projectID, sessionID)
+		assert.NoError(t, err)
+		assert.Equal(t, model.MessageStreamChannel(sessionID), sub.channel)
+		assert.Equal(t, subscribers+1, metrics.SSESubscribers.Value())
+
+		sub.payloads <- "not json"
+		sub.payloads <- `{"type":"message.part.appended","delta":"hi"}`
+
+		select {
+		case ev := <-events:
+			assert.Equal(t, model.StreamEventMessagePartAppended, ev.Type)
+			assert.Equal(t, "hi", ev.Delta)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+
+		unsubscribe()
+		unsubscribe()
+		assert.True(t, sub.unsubscribed)
+		assert.Equal(t, subscribers, metrics.SSESubscribers.Value(), "unsubscribing twice is counted once")
+		_, open := <-events
+		assert.False(t, open)
+	})
+
+	t.Run("a subscription the server ends closes with a resync event", func(t *testing.T) {
+		sessionRepo := &MockSessionRepo{}
+		sessionRepo.On("Get", mock.Anything, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		sub := &fakeSubscriber{}
+		svc := NewMessageStreamService(sessionRepo, sub, zap.NewNop())
+
+		events, unsubscribe, err := svc.Subscribe(ctx, projectID, sessionID)
+		assert.NoError(t, err)
+		defer unsubscribe()
+		close(sub.payloads) // as db.Listener does for a subscriber that fell behind
+		sub.unsubscribed = true
+
+		select {
+		case ev := <-events:
+			assert.Equal(t, model.StreamEventResync, ev.Type)
+			assert.Equal(t, sessionID, ev.SessionID)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for resync")
+		}
+		_, open := <-events
+		assert.False(t, open)
+	})
+
+	t.Run("session of another project", func(t *testing.T) {
+		sessionRepo := &MockSessionRepo{}
+		sessionRepo.On("Get", mock.Anything, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: uuid.New()}, nil)
+		svc := NewMessageStreamService(sessionRepo, &fakeSubscriber{}, zap.NewNop())
+
+		_, _, err := svc.Subscribe(ctx, projectID, sessionID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("missing session", func(t *testing.T) {
+		sessionRepo := &MockSessionRepo{}
+		sessionRepo.On("Get", mock.Anything, mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		svc := NewMessageStreamService(sessionRepo, &fakeSubscriber{}, zap.NewNop())
+
+		_, _, err := svc.Subscribe(ctx, projectID, sessionID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+}
@@ -2,8 +2,6 @@ package service
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"errors"
 	"net/http"
 	"net/url"
@@ -16,9 +14,8 @@ import (
 	encryptionpkg "github.com/memodb-io/Acontext/internal/infra/crypto"
 	"github.com/memodb-io/Acontext/internal/modules/model"
 	"github.com/memodb-io/Acontext/internal/modules/repo"
-	"github.com/memodb-io/Acontext/internal/pkg/utils/secrets"
-	"github.com/memodb-io/Acontext/internal/pkg/utils/tokens"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 type ProjectService interface {
@@ -30,6 +27,9 @@ type ProjectService interface {
 	AnalyzeUsages(ctx context.Context, projectID uuid.UUID, intervalDays int, fields []string) (*AnalyzeUsagesOutput, error)
 	AnalyzeStatistics(ctx context.Context, projectID uuid.UUID) (*AnalyzeStatisticsOutput, error)
 	AnalyzeMetrics(ctx context.Context, projectID uuid.UUID, requestURL string, requestMethod string, requestHeaders http.Header) (*http.Response, error)
+	// SetDefaultSystemPrompt sets the system prompt BuildContext injects into the project's
+	// sessions that have none; an empty text removes it.
+	SetDefaultSystemPrompt(ctx context.Context, projectID uuid.UUID, text string) error
 }
 
 type projectService struct {
@@ -63,34 +63,12 @@ func (s *projectService) Create(ctx context.Context, configs map[string]interfac
 		return nil, errors.New("secret pepper is not configured")
 	}
 
-	// Generate 16-byte auth_secret for compact token format
-	authSecretRaw := make([]byte, encryptionpkg.CompactAuthSecretLen)
-	if _, err := rand.Read(authSecretRaw); err != nil {
-		return nil, err
-	}
-	authSecretHex := hex.EncodeToString(authSecretRaw) // 32 hex chars
-
 	// Generate master_key (32 bytes) — used directly as KEK for S3 encryption
 	masterKey, err := encryptionpkg.GenerateMasterKey()
 	if err != nil {
 		return nil, err
 	}
-
-	// Derive wrapping key and pack compact token
-	wrappingKey, err := encryptionpkg.DeriveUserKEK(authSecretHex, pepper)
-	if err != nil {
-		return nil, err
-	}
-	compactBody, err := encryptionpkg.PackCompactToken(authSecretRaw, masterKey, wrappingKey)
-	if err != nil {
-		return nil, err
-	}
-
-	// Generate HMAC for lookup (based on hex auth_secret)
-	lookup := tokens.HMAC256Hex(pepper, authSecretHex)
-
-	// Hash the auth_secret with PHC format
-	phc, err := secrets.HashSecret(authSecretHex, pepper)
+	cred, err := newProjectCredential(s.cfg, masterKey)
 	if err != nil {
 		return nil, err
 	}
@@ -101,8 +79,8 @@ func (s *projectService) Create(ctx context.Context, configs map[string]interfac
 	}
 
 	project := &model.Project{
-		SecretKeyHMAC:    lookup,
-		SecretKeyHashPHC: phc,
+		SecretKeyHMAC:    cred.lookup,
+		SecretKeyHashPHC: cred.phc,
 		Configs:          datatypes.JSONMap(configs),
 	}
 
@@ -110,12 +88,9 @@ func (s *projectService) Create(ctx context.Context, configs map[string]interfac
 		return nil, err
 	}
 
-	// Compact token format: sk-ac-{base64url(0x01 | auth_16B | aes_kw(mk))}
-	token := s.cfg.Root.ProjectBearerTokenPrefix + compactBody
-
 	return &CreateProjectOutput{
 		ProjectID: project.ID,
-		SecretKey: token,
+		SecretKey: cred.token,
 	}, nil
 }
 
@@ -126,6 +101,26 @@ func (s *projectService) Delete(ctx context.Context, projectID uuid.UUID) error
 	return s.r.Delete(ctx, projectID)
 }
 
+func (s *projectService) SetDefaultSystemPrompt(ctx context.Context, projectID uuid.UUID, text string) error {
+	if projectID == uuid.Nil {
+		return errors.New("project id is empty")
+	}
+	if len(text) > MaxSystemPromptLen {
+		return ErrSystemPromptTooLong
+	}
+	var value interface{}
+	if text != "" {
+		value = text
+	}
+	if err := s.r.SetProjectConfig(ctx, projectID, projectConfigDefaultSystemPrompt, value); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProjectNotFound
+		}
+		return err
+	}
+	return nil
+}
+
 // RotateSecretKey rotates the auth_secret and re-wraps the master_key.
 // If masterKey is nil, a new master_key is generated (for legacy keys without encryption).
 func (s *projectService) RotateSecretKey(ctx context.Context, projectID uuid.UUID, masterKey []byte) (*UpdateSecretKeyOutput, error) {
@@ -151,42 +146,21 @@ func (s *projectService) RotateSecretKey(ctx context.Context, projectID uuid.UUI
 		}
 	}
 
-	// Generate new 16-byte auth_secret for compact format
-	authSecretRaw := make([]byte, encryptionpkg.CompactAuthSecretLen)
-	if _, err := rand.Read(authSecretRaw); err != nil {
-		return nil, err
-	}
-	authSecretHex := hex.EncodeToString(authSecretRaw)
-
-	// Derive wrapping key and pack compact token
-	wrappingKey, err := encryptionpkg.DeriveUserKEK(authSecretHex, pepper)
-	if err != nil {
-		return nil, err
-	}
-	compactBody, err := encryptionpkg.PackCompactToken(authSecretRaw, masterKey, wrappingKey)
-	if err != nil {
-		return nil, err
-	}
-
-	// Compute HMAC and PHC for new auth_secret
-	lookup := tokens.HMAC256Hex(pepper, authSecretHex)
-	phc, err := secrets.HashSecret(authSecretHex, pepper)
+	cred, err := newProjectCredential(s.cfg, masterKey)
 	if err != nil {
 		return nil, err
 	}
 
 	// Update project
-	project.SecretKeyHMAC = lookup
-	project.SecretKeyHashPHC = phc
+	project.SecretKeyHMAC = cred.lookup
+	project.SecretKeyHashPHC = cred.phc
 
 	if err := s.r.Update(ctx, project); err != nil {
 		return nil, err
 	}
 
-	token := s.cfg.Root.ProjectBearerTokenPrefix + compactBody
-
 	return &UpdateSecretKeyOutput{
-		SecretKey: token,
+		SecretKey: cred.token,
 	}, nil
 }
 
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	encryptionpkg "github.com/memodb-io/Acontext/internal/infra/crypto"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/memodb-io/Acontext/internal/pkg/utils/secrets"
+	"github.com/memodb-io/Acontext/internal/pkg/utils/tokens"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ProjectKeyService manages the scoped API keys of a project; see model.ProjectKey.
+type ProjectKeyService interface {
+	Create(ctx context.Context, in CreateProjectKeyInput) (*CreateProjectKeyOutput, error)
+	List(ctx context.Context, projectID uuid.UUID) ([]model.ProjectKey, error)
+	// Delete revokes a key and returns it, so the caller can drop its cached auth entry.
+	Delete(ctx context.Context, projectID uuid.UUID, keyID uuid.UUID) (*model.ProjectKey, error)
+}
+
+type projectKeyService struct {
+	r   repo.ProjectKeyRepo
+	cfg *config.Config
+}
+
+func NewProjectKeyService(r repo.ProjectKeyRepo, cfg *config.Config) ProjectKeyService {
+	return &projectKeyService{r: r, cfg: cfg}
+}
+
+type CreateProjectKeyInput struct {
+	ProjectID uuid.UUID
+	Name      string
+	// Scopes are the scopes the key holds, each one of model.Scopes; none gives a key that only
+	// reads redacted content.
+	Scopes []string
+	// MasterKey is the project's master key, taken from the compact secret key of the caller. The
+	// new key wraps it so it can read and write encrypted data. Without it the key is issued in the
+	// legacy format, which has no encryption support.
+	MasterKey []byte
+}
+
+type CreateProjectKeyOutput struct {
+	Key *model.ProjectKey `json:"key"`
+	// SecretKey is the bearer token of the key; it is only returned here.
+	SecretKey string `json:"secret_key"`
+}
+
+func (s *projectKeyService) Create(ctx context.Context, in CreateProjectKeyInput) (*CreateProjectKeyOutput, error) {
+	if len(in.Name) > model.MaxProjectKeyNameLength {
+		return nil, fmt.Errorf("%w: exceeds %d characters", ErrInvalidKeyName, model.MaxProjectKeyNameLength)
+	}
+	scopes := make([]string, 0, len(in.Scopes))
+	for _, scope := range in.Scopes {
+		if !slices.Contains(model.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	cred, err := newProjectCredential(s.cfg, in.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &model.ProjectKey{
+		ProjectID:        in.ProjectID,
+		Name:             in.Name,
+		SecretKeyHMAC:    cred.lookup,
+		SecretKeyHashPHC: cred.phc,
+		Scopes:           datatypes.JSONSlice[string](scopes),
+	}
+	if err := s.r.Create(ctx, key); err != nil {
+		return nil, fmt.Errorf("create project key: %w", err)
+	}
+	return &CreateProjectKeyOutput{Key: key, SecretKey: cred.token}, nil
+}
+
+func (s *projectKeyService) List(ctx context.Context, projectID uuid.UUID) ([]model.ProjectKey, error) {
+	keys, err := s.r.ListByProject(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("list project keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *projectKeyService) Delete(ctx context.Context, projectID uuid.UUID, keyID uuid.UUID) (*model.ProjectKey, error) {
+	key, err := s.r.Delete(ctx, projectID, keyID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProjectKeyNotFound
+		}
+		return nil, fmt.Errorf("delete project key: %w", err)
+	}
+	return key, nil
+}
+
+// projectCredential is a freshly generated project bearer token with the HMAC it is looked up by
+// and the PHC hash it is verified against.
+type projectCredential struct {
+	token  string
+	lookup string
+	phc    string
+}
+
+// newProjectCredential generates a project bearer token. With a master key the token is in the
+// compact format, sk-ac-{base64url(0x01 | auth_16B | aes_kw(mk))}, which carries the master key
+// wrapped by a key derived from its auth secret; without one it is a legacy sk-ac-{auth_secret}
+// token.
+func newProjectCredential(cfg *config.Config, masterKey []byte) (*projectCredential, error) {
+	pepper := cfg.Root.SecretPepper
+	if pepper == "" {
+		return nil, errors.New("secret pepper is not configured")
+	}
+
+	// Generate 16-byte auth_secret for compact token format
+	authSecretRaw := make([]byte, encryptionpkg.CompactAuthSecretLen)
+	if _, err := rand.Read(authSecretRaw); err != nil {
+		return nil, err
+	}
+	authSecretHex := hex.EncodeToString(authSecretRaw) // 32 hex chars
+
+	body := authSecretHex
+	if masterKey != nil {
+		// Derive wrapping key and pack compact token
+		wrappingKey, err := encryptionpkg.DeriveUserKEK(authSecretHex, pepper)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = encryptionpkg.PackCompactToken(authSecretRaw, masterKey, wrappingKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// HMAC for lookup and PHC hash, both based on hex auth_secret
+	phc, err := secrets.HashSecret(authSecretHex, pepper)
+	if err != nil {
+		return nil, err
+	}
+	return &projectCredential{
+		token:  cfg.Root.ProjectBearerTokenPrefix + body,
+		lookup: tokens.HMAC256Hex(pepper, authSecretHex),
+		phc:    phc,
+	}, nil
+}
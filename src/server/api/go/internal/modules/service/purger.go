@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	purgeLockKey = "purge_deleted:lock" // Distributed purge lock
+	purgeTimeout = 5 * time.Minute      // Upper bound for a single purge run
+)
+
+// DeletedPurger periodically hard-deletes sessions and messages whose soft delete is
+// older than the configured retention window.
+type DeletedPurger interface {
+	Start()
+	Stop()
+}
+
+type deletedPurger struct {
+	svc       SessionService
+	redis     *redis.Client
+	log       *zap.Logger
+	retention time.Duration
+	interval  time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewDeletedPurger(svc SessionService, rdb *redis.Client, cfg *config.Config, log *zap.Logger) DeletedPurger {
+	return &deletedPurger{
+		svc:       svc,
+		redis:     rdb,
+		log:       log,
+		retention: time.Duration(cfg.Retention.SoftDeleteHours) * time.Hour,
+		interval:  time.Duration(cfg.Retention.PurgeIntervalSec) * time.Second,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins the background purge goroutine. Purging is disabled when the
+// retention window or interval is not positive.
+func (p *deletedPurger) Start() {
+	if p.retention <= 0 || p.interval <= 0 {
+		close(p.done)
+		return
+	}
+	go p.run()
+}
+
+// Stop signals the purger to exit and waits for an in-flight run to finish.
+func (p *deletedPurger) Stop() {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	close(p.stop)
+	<-p.done
+}
+
+func (p *deletedPurger) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.purgeOnce()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// purgeOnce acquires a distributed lock so only one pod purges per interval.
+func (p *deletedPurger) purgeOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	if p.redis != nil {
+		ok, err := p.redis.SetNX(ctx, purgeLockKey, "1", p.interval).Result()
+		if err != nil {
+			p.log.Error("DeletedPurger: failed to acquire purge lock", zap.Error(err))
+			return
+		}
+		if !ok {
+			return // Another pod purged within this interval.
+		}
+	}
+
+	out, err := p.svc.PurgeDeleted(ctx, p.retention)
+	if err != nil {
+		p.log.Error("DeletedPurger: purge failed", zap.Error(err))
+		return
+	}
+	if out.Sessions > 0 || out.Messages > 0 {
+		p.log.Info("DeletedPurger: purged soft-deleted rows",
+			zap.Int64("sessions", out.Sessions), zap.Int64("messages", out.Messages))
+	}
+	if out.IdempotencyKeys > 0 {
+		p.log.Info("DeletedPurger: expired idempotency keys", zap.Int64("keys", out.IdempotencyKeys))
+	}
+}
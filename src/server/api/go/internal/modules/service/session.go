@@ -1,14 +1,22 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
+	"path"
+	"regexp"
+	"slices"
 	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bytedance/sonic"
 	"github.com/go-playground/validator/v10"
@@ -19,8 +27,18 @@ import (
 	mq "github.com/memodb-io/Acontext/internal/infra/queue"
 	"github.com/memodb-io/Acontext/internal/modules/model"
 	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/memodb-io/Acontext/internal/pkg/bundle"
 	"github.com/memodb-io/Acontext/internal/pkg/editor"
+	"github.com/memodb-io/Acontext/internal/pkg/hook"
+	"github.com/memodb-io/Acontext/internal/pkg/jsonpatch"
+	"github.com/memodb-io/Acontext/internal/pkg/mediameta"
+	"github.com/memodb-io/Acontext/internal/pkg/metrics"
+	"github.com/memodb-io/Acontext/internal/pkg/moderation"
 	"github.com/memodb-io/Acontext/internal/pkg/paging"
+	"github.com/memodb-io/Acontext/internal/pkg/summarizer"
+	"github.com/memodb-io/Acontext/internal/pkg/tokenizer"
+	"github.com/memodb-io/Acontext/internal/pkg/toolschema"
+	"github.com/memodb-io/Acontext/internal/pkg/utils/mime"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"gorm.io/datatypes"
@@ -29,17 +47,75 @@ import (
 
 type SessionService interface {
 	Create(ctx context.Context, ss *model.Session) error
-	Delete(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) error
+	Delete(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte, override *ImmutabilityOverride) error
+	DeleteSessionCascade(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte, override *ImmutabilityOverride) (*DeleteSessionCascadeOutput, error)
 	UpdateByID(ctx context.Context, ss *model.Session) error
 	GetByID(ctx context.Context, ss *model.Session) (*model.Session, error)
 	List(ctx context.Context, in ListSessionsInput) (*ListSessionsOutput, error)
 	StoreMessage(ctx context.Context, in StoreMessageInput) (*model.Message, error)
 	GetMessages(ctx context.Context, in GetMessagesInput) (*GetMessagesOutput, error)
 	GetAllMessages(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) ([]model.Message, error)
+	GetMessageThread(ctx context.Context, in GetMessageThreadInput) ([]ThreadMessage, error)
+	DiffBranches(ctx context.Context, in DiffBranchesInput) (*DiffBranchesOutput, error)
+	ReparentMessage(ctx context.Context, in ReparentMessageInput) (*ReparentMessageOutput, error)
+	ValidateToolPairing(ctx context.Context, in ValidateToolPairingInput) ([]editor.ToolPairingIssue, error)
+	ValidateTree(ctx context.Context, in ValidateTreeInput) ([]repo.BrokenParentLink, error)
+	RepairTree(ctx context.Context, in RepairTreeInput) (*repo.RepairTreeResult, error)
+	ExportSession(ctx context.Context, in ExportSessionInput) (*ExportSessionOutput, error)
+	ReplaySession(ctx context.Context, in ReplaySessionInput) (*ReplaySessionOutput, error)
+	ImportSession(ctx context.Context, in ImportSessionInput) (*ImportSessionOutput, error)
+	ExportSessionBundle(ctx context.Context, in ExportSessionBundleInput) (*SessionBundle, error)
+	ExportProject(ctx context.Context, in ExportProjectInput, emit func(rec *ExportRecord) error) error
+	ImportSessionBundle(ctx context.Context, in ImportSessionBundleInput) (*ImportSessionOutput, error)
+	SearchMessages(ctx context.Context, in SearchMessagesInput) ([]MessageSearchResult, error)
+	SearchHistory(ctx context.Context, in SearchHistoryInput) (*SearchHistoryOutput, error)
+	GetSessionTokens(ctx context.Context, in GetSessionTokensInput) (*SessionTokensOutput, error)
+	CheckQuota(ctx context.Context, sessionID uuid.UUID, additionalBytes int64) error
+	GetStorageUsage(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (*SessionStorageUsage, error)
+	BuildContext(ctx context.Context, in BuildContextInput) (*editor.ContextSelection, error)
+	SummarizeSession(ctx context.Context, in SummarizeSessionInput) (*SummarizeSessionOutput, error)
+	StartStreamingMessage(ctx context.Context, in StartStreamingMessageInput) (*model.Message, error)
+	AppendMessagePart(ctx context.Context, in AppendMessagePartInput) error
+	FinalizeMessage(ctx context.Context, in FinalizeMessageInput) (*model.Message, error)
+	UpdateMessageParts(ctx context.Context, in UpdateMessagePartsInput) (*model.Message, error)
+	PatchMessageParts(ctx context.Context, in PatchMessagePartsInput) (*model.Message, error)
+	GetMessageRevisions(ctx context.Context, in GetMessageRevisionsInput) ([]model.MessageRevision, error)
+	GetMessageParts(ctx context.Context, in GetMessagePartsInput) (*GetMessagePartsOutput, error)
+	AttachAssets(ctx context.Context, in MessageAssetsInput) (*model.Message, error)
+	DetachAssets(ctx context.Context, in MessageAssetsInput) (*model.Message, error)
 	GetSessionObservingStatus(ctx context.Context, sessionID string) (*model.MessageObservingStatus, error)
-	PatchMessageMeta(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, patchMeta map[string]interface{}) (map[string]interface{}, error)
+	PatchMessageMeta(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, patchMeta map[string]interface{}, override *ImmutabilityOverride) (map[string]interface{}, error)
+	DeleteMessage(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, override *ImmutabilityOverride) error
+	DeleteMessages(ctx context.Context, in DeleteMessagesInput) (*repo.DeleteMessagesResult, error)
+	RestoreMessage(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, override *ImmutabilityOverride) error
+	DedupeConsecutive(ctx context.Context, in DedupeConsecutiveInput) (*DedupeConsecutiveOutput, error)
+	SetMessagePinned(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, pinned bool) error
+	ListPinnedMessages(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) ([]model.Message, error)
+	SetMessageFlag(ctx context.Context, in SetMessageFlagInput) (*model.MessageFlagAudit, error)
+	ListMessageFlagAudits(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageFlagAudit, error)
+	AddMessageAnnotation(ctx context.Context, in AddMessageAnnotationInput) (*model.MessageAnnotation, error)
+	ListMessageAnnotations(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageAnnotation, error)
+	DeleteMessageAnnotation(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, annotationID uuid.UUID) error
+	CountSessionReactions(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (map[string]int64, error)
+	PurgeDeleted(ctx context.Context, olderThan time.Duration) (*PurgeDeletedOutput, error)
 	PatchConfigs(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, patchConfigs map[string]interface{}) (map[string]interface{}, error)
+	SetMetadata(ctx context.Context, in SetSessionMetadataInput) (map[string]interface{}, error)
+	UpdateTags(ctx context.Context, in UpdateSessionTagsInput) ([]string, error)
 	CopySession(ctx context.Context, in CopySessionInput) (*CopySessionOutput, error)
+	ForkSession(ctx context.Context, in ForkSessionInput) (*ForkSessionOutput, error)
+	CloneSessionShallow(ctx context.Context, in CloneSessionShallowInput) (*CloneSessionShallowOutput, error)
+	SetTemplate(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, isTemplate bool) error
+	SetSystemPrompt(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, prompt *string) error
+	SetAllowedPartTypes(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, types []string) ([]string, error)
+	InstantiateTemplate(ctx context.Context, in InstantiateTemplateInput) (*InstantiateTemplateOutput, error)
+	ArchiveSession(ctx context.Context, in ArchiveSessionInput) (*model.Session, error)
+	RestoreSession(ctx context.Context, in RestoreSessionInput) (*RestoreSessionOutput, error)
+	FinalizeSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (*model.Session, error)
+	MergeSessions(ctx context.Context, in MergeSessionsInput) (*repo.MergeSessionsResult, error)
+	AcquireSessionLock(ctx context.Context, in AcquireSessionLockInput) (*model.SessionLock, error)
+	ReleaseSessionLock(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, holderID string) error
+	GetSessionLock(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (*model.SessionLock, error)
+	ListImmutabilityOverrides(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) ([]model.ImmutabilityOverride, error)
 	DownloadAsset(ctx context.Context, s3Key string, userKEK []byte) ([]byte, error)
 }
 
@@ -54,6 +130,48 @@ type CopySessionOutput struct {
 	NewSessionID uuid.UUID `json:"new_session_id"`
 }
 
+type PurgeDeletedOutput struct {
+	Sessions        int64 `json:"sessions"`
+	Messages        int64 `json:"messages"`
+	IdempotencyKeys int64 `json:"idempotency_keys"`
+}
+
+// DeleteSessionCascadeOutput reports what a cascading session delete removed; see
+// repo.DeleteSessionCascadeResult.
+type DeleteSessionCascadeOutput struct {
+	Messages       int64 `json:"messages"`
+	Assets         int   `json:"assets"`
+	OrphanedAssets int   `json:"orphaned_assets"`
+	DeferredAssets int   `json:"deferred_assets"`
+}
+
+type ForkSessionInput struct {
+	ProjectID     uuid.UUID
+	SessionID     uuid.UUID
+	FromMessageID uuid.UUID
+	UserKEK       []byte
+}
+
+type ForkSessionOutput struct {
+	OldSessionID uuid.UUID               `json:"old_session_id"`
+	NewSessionID uuid.UUID               `json:"new_session_id"`
+	MessageIDMap map[uuid.UUID]uuid.UUID `json:"message_id_map"`
+}
+
+type CloneSessionShallowInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	// FromMessageID is the branch point; uuid.Nil branches at the newest message.
+	FromMessageID uuid.UUID
+}
+
+type CloneSessionShallowOutput struct {
+	OldSessionID   uuid.UUID  `json:"old_session_id"`
+	NewSessionID   uuid.UUID  `json:"new_session_id"`
+	BaseMessageID  *uuid.UUID `json:"base_message_id,omitempty"`
+	SharedMessages int        `json:"shared_messages"`
+}
+
 type sessionService struct {
 	sessionRepo        repo.SessionRepo
 	sessionEventRepo   repo.SessionEventRepo
@@ -65,6 +183,13 @@ type sessionService struct {
 	cfg                *config.Config
 	redis              *redis.Client
 	materialSvc        MaterialService
+	enrichment         EnrichmentService
+	summarizer         summarizer.Summarizer
+	toolSchemas        *toolschema.Registry
+	hooks              *hook.Chain
+	moderation         moderation.Provider
+	mediaMeta          *mediameta.Registry
+	sessionLocks       repo.SessionLockRepo
 }
 
 const (
@@ -78,7 +203,7 @@ const (
 	cachePrefixEncrypted byte = 0x01
 )
 
-func NewSessionService(sessionRepo repo.SessionRepo, sessionEventRepo repo.SessionEventRepo, assetReferenceRepo repo.AssetReferenceRepo, assetRefBuffer repo.AssetRefBuffer, log *zap.Logger, s3 *blob.S3Deps, publisher *mq.Publisher, cfg *config.Config, redis *redis.Client, materialSvc MaterialService) SessionService {
+func NewSessionService(sessionRepo repo.SessionRepo, sessionEventRepo repo.SessionEventRepo, assetReferenceRepo repo.AssetReferenceRepo, assetRefBuffer repo.AssetRefBuffer, log *zap.Logger, s3 *blob.S3Deps, publisher *mq.Publisher, cfg *config.Config, redis *redis.Client, materialSvc MaterialService, enrichment EnrichmentService, summarizer summarizer.Summarizer, toolSchemas *toolschema.Registry, hooks *hook.Chain, moderation moderation.Provider, mediaMeta *mediameta.Registry, sessionLocks repo.SessionLockRepo) SessionService {
 	return &sessionService{
 		sessionRepo:        sessionRepo,
 		sessionEventRepo:   sessionEventRepo,
@@ -90,6 +215,13 @@ func NewSessionService(sessionRepo repo.SessionRepo, sessionEventRepo repo.Sessi
 		cfg:                cfg,
 		redis:              redis,
 		materialSvc:        materialSvc,
+		enrichment:         enrichment,
+		summarizer:         summarizer,
+		toolSchemas:        toolSchemas,
+		hooks:              hooks,
+		moderation:         moderation,
+		mediaMeta:          mediaMeta,
+		sessionLocks:       sessionLocks,
 	}
 }
 
@@ -97,18 +229,48 @@ func (s *sessionService) Create(ctx context.Context, ss *model.Session) error {
 	return s.sessionRepo.Create(ctx, ss)
 }
 
-func (s *sessionService) Delete(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) error {
+// Delete soft-deletes the session. A finalized session, or one holding messages past the
+// immutability window, is only deleted with an override.
+func (s *sessionService) Delete(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte, override *ImmutabilityOverride) error {
 	if len(sessionID) == 0 {
 		return errors.New("space id is empty")
 	}
+	if err := s.checkSessionDeletable(ctx, projectID, sessionID, model.OverrideDeleteSession, override); err != nil {
+		return err
+	}
 
 	if err := s.sessionRepo.Delete(ctx, projectID, sessionID, userKEK); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
 		return fmt.Errorf("delete session: %w", err)
 	}
 
 	return nil
 }
 
+// DeleteSessionCascade hard-deletes the session with its messages right away instead of leaving
+// them to PurgeDeleted, and frees the storage of assets nothing else references. It is checked
+// against immutability like Delete.
+func (s *sessionService) DeleteSessionCascade(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte, override *ImmutabilityOverride) (*DeleteSessionCascadeOutput, error) {
+	if err := s.checkSessionDeletable(ctx, projectID, sessionID, model.OverridePurgeSession, override); err != nil {
+		return nil, err
+	}
+	result, err := s.sessionRepo.DeleteSessionCascade(ctx, projectID, sessionID, userKEK)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("delete session cascade: %w", err)
+	}
+	return &DeleteSessionCascadeOutput{
+		Messages:       result.Messages,
+		Assets:         result.Assets,
+		OrphanedAssets: result.Orphaned,
+		DeferredAssets: result.Deferred,
+	}, nil
+}
+
 func (s *sessionService) UpdateByID(ctx context.Context, ss *model.Session) error {
 	return s.sessionRepo.Update(ctx, ss)
 }
@@ -121,12 +283,21 @@ func (s *sessionService) GetByID(ctx context.Context, ss *model.Session) (*model
 }
 
 type ListSessionsInput struct {
-	ProjectID       uuid.UUID              `json:"project_id"`
-	User            string                 `json:"user"`
-	FilterByConfigs map[string]interface{} `json:"filter_by_configs"` // Filter by configs JSONB containment
-	Limit           int                    `json:"limit"`
-	Cursor          string                 `json:"cursor"`
-	TimeDesc        bool                   `json:"time_desc"`
+	ProjectID        uuid.UUID              `json:"project_id"`
+	User             string                 `json:"user"`
+	FilterByConfigs  map[string]interface{} `json:"filter_by_configs"`  // Filter by configs JSONB containment
+	FilterByMetadata map[string]interface{} `json:"filter_by_metadata"` // Filter by metadata JSONB containment
+	Tags             []string               `json:"tags"`               // Sessions must carry every tag
+	IncludeTemplates bool                   `json:"include_templates"`  // Also list template sessions
+	IncludeArchived  bool                   `json:"include_archived"`   // Also list archived sessions
+	Limit            int                    `json:"limit"`
+	Cursor           string                 `json:"cursor"`
+	TimeDesc         bool                   `json:"time_desc"`
+	// OrderBy is the sort key; empty sorts by creation time.
+	OrderBy repo.SessionOrder `json:"order_by,omitempty"`
+	// Since and Until optionally bound the sessions' created_at, inclusively; zero leaves a side open.
+	Since time.Time `json:"since,omitempty"`
+	Until time.Time `json:"until,omitempty"`
 }
 
 type ListSessionsOutput struct {
@@ -136,7 +307,7 @@ type ListSessionsOutput struct {
 }
 
 func (s *sessionService) List(ctx context.Context, in ListSessionsInput) (*ListSessionsOutput, error) {
-	// Parse cursor (createdAt, id); an empty cursor indicates starting from the latest
+	// Parse cursor (sort key, id); an empty cursor indicates starting from the latest
 	var afterT time.Time
 	var afterID uuid.UUID
 	var err error
@@ -148,7 +319,7 @@ func (s *sessionService) List(ctx context.Context, in ListSessionsInput) (*ListS
 	}
 
 	// Query limit+1 is used to determine has_more
-	sessions, err := s.sessionRepo.ListWithCursor(ctx, in.ProjectID, in.User, in.FilterByConfigs, afterT, afterID, in.Limit+1, in.TimeDesc)
+	sessions, err := s.sessionRepo.ListWithCursor(ctx, in.ProjectID, in.User, in.FilterByConfigs, in.FilterByMetadata, in.Tags, in.IncludeTemplates, in.IncludeArchived, afterT, afterID, in.Limit+1, in.TimeDesc, in.OrderBy, repo.TimeRange{Since: in.Since, Until: in.Until})
 	if err != nil {
 		return nil, err
 	}
@@ -161,7 +332,11 @@ func (s *sessionService) List(ctx context.Context, in ListSessionsInput) (*ListS
 		out.HasMore = true
 		out.Items = sessions[:in.Limit]
 		last := out.Items[len(out.Items)-1]
-		out.NextCursor = paging.EncodeCursor(last.CreatedAt, last.ID)
+		sortKey := last.CreatedAt
+		if in.OrderBy == repo.SessionOrderLastMessageAt {
+			sortKey = last.LastMessageAt
+		}
+		out.NextCursor = paging.EncodeCursor(sortKey, last.ID)
 	}
 
 	return out, nil
@@ -176,6 +351,43 @@ type StoreMessageInput struct {
 	MessageMeta map[string]interface{} // Message-level metadata (e.g., name, source_format)
 	Files       map[string]*multipart.FileHeader
 	UserKEK     []byte // optional: for envelope encryption
+	// TokenEncoding names the tokenizer used for the stored token count (default tokenizer.DefaultEncoding)
+	TokenEncoding string
+	// IdempotencyKey, when set, makes a repeated store with the same key return the first message.
+	IdempotencyKey string
+	// Project, when set, lets its project_config lower the message part limits.
+	Project *model.Project
+	// AuthorID and AuthorType attribute the message explicitly, e.g. to the agent that generated
+	// it. When both are empty the author is derived by messageAuthor.
+	AuthorID   *uuid.UUID
+	AuthorType string
+	// LockHolderID, when set, stores the message only while this holder holds the session lock.
+	LockHolderID string
+}
+
+// validateAuthor checks an explicit message author: a type is required with an ID and must be
+// one of model.AuthorTypes.
+func validateAuthor(authorID *uuid.UUID, authorType string) error {
+	if authorID != nil && authorType == "" {
+		return fmt.Errorf("%w: author_type is required with author_id", ErrInvalidAuthor)
+	}
+	if authorType != "" && !model.IsAuthorType(authorType) {
+		return fmt.Errorf("%w: unknown author_type %q, expected one of %s", ErrInvalidAuthor, authorType, strings.Join(model.AuthorTypes, ", "))
+	}
+	return nil
+}
+
+// messageAuthor returns the author of a message stored without an explicit one. System messages
+// are attributed to the system and user messages to the session's user, the principal the
+// request acts for; anything else is left unattributed.
+func messageAuthor(session *model.Session, role string, meta map[string]interface{}) (*uuid.UUID, string) {
+	if original, _ := meta[model.MsgMetaOriginalRole].(string); original == "system" {
+		return nil, model.AuthorTypeSystem
+	}
+	if role == model.RoleUser && session.UserID != nil {
+		return session.UserID, model.AuthorTypeUser
+	}
+	return nil, ""
 }
 
 type StoreMQPublishJSON struct {
@@ -292,6 +504,7 @@ func (s *sessionService) validateAndResolveGeminiToolResult(ctx context.Context,
 }
 
 func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput) (*model.Message, error) {
+	start := time.Now()
 	// Validate session exists and belongs to project before performing expensive operations
 	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: in.SessionID})
 	if err != nil {
@@ -305,6 +518,36 @@ func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput)
 	if session.ProjectID != in.ProjectID {
 		return nil, fmt.Errorf("session does not belong to project")
 	}
+	if session.Archived {
+		return nil, ErrSessionArchived
+	}
+	if session.FinalizedAt != nil {
+		return nil, ErrSessionFinalized
+	}
+	if err := validateAuthor(in.AuthorID, in.AuthorType); err != nil {
+		return nil, err
+	}
+	if in.IdempotencyKey != "" {
+		existing, err := s.sessionRepo.GetMessageByIdempotencyKey(ctx, in.SessionID, in.IdempotencyKey)
+		if err == nil {
+			return redactMessage(ctx, s.replayMessage(ctx, in, existing)), nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("get message by idempotency key: %w", err)
+		}
+	}
+	// A retry of a store that succeeded replays it even if the lock has lapsed since.
+	if in.LockHolderID != "" {
+		if err := s.checkSessionLock(ctx, in.SessionID, in.LockHolderID); err != nil {
+			return nil, err
+		}
+	}
+
+	// The part count is checked before any file is read; the size once the parts are serialized.
+	limits := s.messageLimits(in.Project)
+	if err := limits.check(len(in.Parts), 0); err != nil {
+		return nil, err
+	}
 
 	parts := make([]model.Part, 0, len(in.Parts))
 	var uploadedAssets []model.Asset
@@ -321,38 +564,56 @@ func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput)
 			}
 		}
 
-		part := model.Part{
-			Type: partIn.Type,
-			Meta: partIn.Meta,
+		part, prepared, err := s.buildPart(ctx, in.ProjectID, partIn, in.Files, idx, in.UserKEK)
+		if err != nil {
+			return nil, err
 		}
-
-		if partIn.FileField != "" {
-			fh, ok := in.Files[partIn.FileField]
-			if !ok || fh == nil {
-				return nil, fmt.Errorf("parts[%d]: missing uploaded file %s", idx, partIn.FileField)
-			}
-
-			// Pre-compute asset metadata without S3 calls
-			prepared, err := s.s3.PrepareFormFileAsset("assets/"+in.ProjectID.String(), fh)
-			if err != nil {
-				return nil, fmt.Errorf("prepare %s failed: %w", partIn.FileField, err)
-			}
-
-			pendingUploads = append(pendingUploads, prepared)
-			uploadedAssets = append(uploadedAssets, prepared.Asset)
-			part.Asset = &prepared.Asset
-			part.Filename = fh.Filename
+		if part.Asset != nil {
+			uploadedAssets = append(uploadedAssets, *part.Asset)
 		}
-
-		if partIn.Text != "" {
-			part.Text = partIn.Text
+		if prepared != nil {
+			pendingUploads = append(pendingUploads, prepared)
 		}
 
 		parts = append(parts, part)
 	}
 
+	// Prepare message metadata
+	messageMeta := in.MessageMeta
+	if messageMeta == nil {
+		messageMeta = make(map[string]interface{})
+	}
+
+	msg := model.Message{
+		SessionID:  in.SessionID,
+		Role:       in.Role,
+		AuthorID:   in.AuthorID,
+		AuthorType: in.AuthorType,
+		Meta:       datatypes.NewJSONType(messageMeta), // Store message-level metadata
+		Parts:      parts,
+	}
+	if msg.AuthorType == "" {
+		msg.AuthorID, msg.AuthorType = messageAuthor(session, msg.Role, messageMeta)
+	}
+	// Hooks see the message before anything is derived from its parts, so their edits
+	// are what gets validated, stored, indexed and counted.
+	if err := s.hooks.BeforeCreate(&msg); err != nil {
+		return nil, err
+	}
+	parts = msg.Parts
+	if err := model.ValidateParts(parts); err != nil {
+		return nil, err
+	}
+	if err := session.CheckPartTypes(parts); err != nil {
+		return nil, err
+	}
+	if err := s.validateToolArguments(in.SessionID, parts); err != nil {
+		return nil, err
+	}
+	s.moderate(ctx, &msg)
+
 	// Pre-compute parts JSON asset metadata without S3 calls
-	partsAssetPrepared, err := s.s3.PrepareJSONAsset("parts/"+in.ProjectID.String(), parts)
+	partsAssetPrepared, err := s.preparePartsAsset("parts/"+in.ProjectID.String(), parts)
 	if err != nil {
 		return nil, fmt.Errorf("prepare parts asset failed: %w", err)
 	}
@@ -360,6 +621,15 @@ func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput)
 	pendingUploads = append(pendingUploads, partsAssetPrepared)
 	partsAsset := partsAssetPrepared.Asset
 	uploadedAssets = append(uploadedAssets, partsAsset)
+	if err := limits.check(len(parts), partsAsset.SizeB); err != nil {
+		return nil, err
+	}
+
+	// Fail fast before caching and uploads; CreateMessageWithAssets enforces the limit atomically.
+	storageBytes := messageStorageBytes(partsAsset, parts)
+	if err := s.quotaErr(session.TotalBytes, storageBytes); err != nil {
+		return nil, err
+	}
 
 	// Cache parts data in Redis before responding (uses pre-computed SHA256)
 	if s.redis != nil {
@@ -368,6 +638,57 @@ func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput)
 		}
 	}
 
+	msg.PartsAssetMeta = datatypes.NewJSONType(partsAsset)
+	msg.StorageBytes = storageBytes
+	if in.UserKEK == nil {
+		msg.SearchText = searchTextFromParts(parts)
+	}
+
+	encoding := in.TokenEncoding
+	if encoding == "" {
+		encoding = tokenizer.DefaultEncoding
+	}
+	tokenCount, err := tokenizer.CountPartsTokens(parts, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("count tokens: %w", err)
+	}
+	msg.TokenCount = tokenCount
+	msg.TokenEncoding = encoding
+
+	// Check if task tracking is disabled for this session
+	disableTaskTracking, err := s.sessionRepo.GetDisableTaskTracking(ctx, in.SessionID)
+	if err != nil {
+		s.log.Error("failed to get disable_task_tracking for session", zap.Error(err))
+	} else if disableTaskTracking {
+		msg.SessionTaskProcessStatus = model.MessageStatusDisableTracking
+	}
+
+	if in.IdempotencyKey != "" {
+		msg.IdempotencyKey = &in.IdempotencyKey
+	}
+
+	if err := s.sessionRepo.CreateMessageWithAssets(ctx, &msg, s.cfg.Quota.MaxSessionBytes); err != nil {
+		var quotaErr *repo.SessionQuotaError
+		if errors.As(err, &quotaErr) {
+			return nil, &QuotaExceededError{Scope: QuotaScopeSession, Usage: quotaErr.Usage, Requested: quotaErr.Requested, Limit: quotaErr.Limit}
+		}
+		if errors.Is(err, repo.ErrDuplicateIdempotencyKey) {
+			// A concurrent request with the same key won the insert.
+			existing, getErr := s.sessionRepo.GetMessageByIdempotencyKey(ctx, in.SessionID, in.IdempotencyKey)
+			if getErr != nil {
+				return nil, fmt.Errorf("get message by idempotency key: %w", getErr)
+			}
+			return redactMessage(ctx, s.replayMessage(ctx, in, existing)), nil
+		}
+		if errors.Is(err, repo.ErrSessionArchived) {
+			return nil, ErrSessionArchived
+		}
+		if errors.Is(err, repo.ErrSessionFinalized) {
+			return nil, ErrSessionFinalized
+		}
+		return nil, err
+	}
+
 	// Upload all assets to S3 asynchronously — not on the request critical path.
 	// Since S3 keys are content-addressed (SHA256), uploads are idempotent.
 	go func() {
@@ -379,7 +700,9 @@ func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput)
 					zap.String("s3_key", p.Asset.S3Key),
 					zap.String("sha256", p.Asset.SHA256),
 					zap.Error(err))
+				continue
 			}
+			metrics.AssetBytesUploaded.Add(float64(len(p.Content)), metrics.AssetSourceMessage)
 		}
 	}()
 
@@ -388,32 +711,7 @@ func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput)
 		s.log.Error("failed to enqueue asset ref increments",
 			zap.String("project_id", in.ProjectID.String()), zap.Error(err))
 	}
-
-	// Prepare message metadata
-	messageMeta := in.MessageMeta
-	if messageMeta == nil {
-		messageMeta = make(map[string]interface{})
-	}
-
-	msg := model.Message{
-		SessionID:      in.SessionID,
-		Role:           in.Role,
-		Meta:           datatypes.NewJSONType(messageMeta), // Store message-level metadata
-		PartsAssetMeta: datatypes.NewJSONType(partsAsset),
-		Parts:          parts,
-	}
-
-	// Check if task tracking is disabled for this session
-	disableTaskTracking, err := s.sessionRepo.GetDisableTaskTracking(ctx, in.SessionID)
-	if err != nil {
-		s.log.Error("failed to get disable_task_tracking for session", zap.Error(err))
-	} else if disableTaskTracking {
-		msg.SessionTaskProcessStatus = model.MessageStatusDisableTracking
-	}
-
-	if err := s.sessionRepo.CreateMessageWithAssets(ctx, &msg); err != nil {
-		return nil, err
-	}
+	s.enqueueEnrichment(ctx, in.ProjectID, &msg, in.UserKEK, false)
 
 	if !disableTaskTracking && s.publisher != nil {
 		mqMsg := StoreMQPublishJSON{
@@ -432,337 +730,3135 @@ func (s *sessionService) StoreMessage(ctx context.Context, in StoreMessageInput)
 		}
 	}
 
-	return &msg, nil
+	metrics.MessagesCreated.Inc(msg.Role)
+	metrics.MessageCreateSeconds.Observe(time.Since(start).Seconds())
+	s.hooks.AfterCreate(&msg)
+	return redactMessage(ctx, &msg), nil
 }
 
-type GetMessagesInput struct {
-	ProjectID                     uuid.UUID               `json:"project_id"`
-	SessionID                     uuid.UUID               `json:"session_id"`
-	Limit                         int                     `json:"limit"`
-	Cursor                        string                  `json:"cursor"`
-	WithAssetPublicURL            bool                    `json:"with_public_url"`
-	AssetExpire                   time.Duration           `json:"asset_expire"`
-	TimeDesc                      bool                    `json:"time_desc"`
-	WithEvents                    bool                    `json:"with_events"`
-	EditStrategies                []editor.StrategyConfig `json:"edit_strategies,omitempty"`
-	PinEditingStrategiesAtMessage string                  `json:"pin_editing_strategies_at_message,omitempty"`
-	UserKEK                       []byte                  `json:"-"` // optional: for envelope encryption (decrypting parts)
+// moderate records the moderation provider's verdict on msg. Moderation only marks messages:
+// when the provider fails the message is stored unflagged.
+func (s *sessionService) moderate(ctx context.Context, msg *model.Message) {
+	if s.moderation == nil {
+		return
+	}
+	res, err := s.moderation.Moderate(ctx, msg.Role, msg.Parts)
+	if err != nil {
+		s.log.Warn("moderate message", zap.String("session_id", msg.SessionID.String()), zap.Error(err))
+		return
+	}
+	if res.Flagged {
+		msg.Flagged, msg.FlagReason = true, res.Reason
+	}
 }
 
-type PublicURL struct {
-	URL      string    `json:"url"`
-	ExpireAt time.Time `json:"expire_at"`
+// validateToolArguments checks tool-call arguments against the registered tool schemas and
+// returns a *toolschema.ArgumentsError for calls that violate them. Calls to tools without a
+// schema pass, with a warning when cfg.ToolSchema.WarnUnregistered is set.
+func (s *sessionService) validateToolArguments(sessionID uuid.UUID, parts []model.Part) error {
+	unregistered, err := s.toolSchemas.ValidateParts(parts)
+	if s.cfg != nil && s.cfg.ToolSchema.WarnUnregistered {
+		for _, name := range unregistered {
+			s.log.Warn("tool call has no registered argument schema", zap.String("session_id", sessionID.String()), zap.String("tool", name))
+		}
+	}
+	return err
 }
 
-type GetMessagesOutput struct {
-	Items           []model.Message      `json:"items"`
-	Events          []model.SessionEvent `json:"events,omitempty"`
-	NextCursor      string               `json:"next_cursor,omitempty"`
-	HasMore         bool                 `json:"has_more"`
-	PublicURLs      map[string]PublicURL `json:"public_urls,omitempty"` // file_name -> url
-	EditAtMessageID string               `json:"edit_at_message_id,omitempty"`
+// enqueueEnrichment queues the media parts of msg for the registered enrichers; replace drops the
+// jobs of parts msg held before. Encrypted projects are skipped because the worker has no user
+// KEK to read their assets with. Failures are logged and never fail the write.
+func (s *sessionService) enqueueEnrichment(ctx context.Context, projectID uuid.UUID, msg *model.Message, userKEK []byte, replace bool) {
+	if s.enrichment == nil || userKEK != nil {
+		return
+	}
+	if err := s.enrichment.EnqueueParts(ctx, EnqueueEnrichmentInput{
+		ProjectID: projectID,
+		SessionID: msg.SessionID,
+		MessageID: msg.ID,
+		Parts:     msg.Parts,
+		Replace:   replace,
+	}); err != nil {
+		s.log.Error("failed to enqueue part enrichment", zap.String("message_id", msg.ID.String()), zap.Error(err))
+	}
 }
 
-func (s *sessionService) GetMessages(ctx context.Context, in GetMessagesInput) (*GetMessagesOutput, error) {
-	// Verify session exists and belongs to project
-	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: in.SessionID})
-	if err != nil {
-		return nil, fmt.Errorf("session not found")
+// replayMessage returns the message an earlier store with in.IdempotencyKey created, with its parts.
+func (s *sessionService) replayMessage(ctx context.Context, in StoreMessageInput, msg *model.Message) *model.Message {
+	if parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), msg.PartsAssetMeta.Data(), in.UserKEK); ok {
+		msg.Parts = parts
 	}
-	if session.ProjectID != in.ProjectID {
-		return nil, fmt.Errorf("session not found")
+	msg.Replayed = true
+	return msg
+}
+
+// buildPart turns partIn into a stored part. A file part links the project's stored asset with the
+// same content when there is one, and otherwise returns the upload that still has to be performed.
+func (s *sessionService) buildPart(ctx context.Context, projectID uuid.UUID, partIn *PartIn, files map[string]*multipart.FileHeader, idx int, userKEK []byte) (model.Part, *blob.PreparedUpload, error) {
+	part := model.Part{
+		Type: partIn.Type,
+		Meta: partIn.Meta,
 	}
 
-	var msgs []model.Message
+	var pending *blob.PreparedUpload
+	if partIn.FileField != "" {
+		fh, ok := files[partIn.FileField]
+		if !ok || fh == nil {
+			return part, nil, fmt.Errorf("parts[%d]: missing uploaded file %s", idx, partIn.FileField)
+		}
+		if limit := s.cfg.Quota.MaxAssetBytes; limit > 0 && fh.Size > limit {
+			return part, nil, &QuotaExceededError{Scope: QuotaScopeAsset, Requested: fh.Size, Limit: limit}
+		}
 
-	// Retrieve messages based on limit
-	if in.Limit <= 0 {
-		// If limit <= 0, retrieve all messages
-		msgs, err = s.sessionRepo.ListAllMessagesBySession(ctx, in.SessionID)
+		// Pre-compute asset metadata without S3 calls
+		prepared, err := s.s3.PrepareFormFileAsset("assets/"+projectID.String(), fh)
 		if err != nil {
-			return nil, err
+			return part, nil, fmt.Errorf("prepare %s failed: %w", partIn.FileField, err)
 		}
-	} else {
-		// Parse cursor (createdAt, id); an empty cursor indicates starting from the latest
-		var afterT time.Time
-		var afterID uuid.UUID
-		if in.Cursor != "" {
-			afterT, afterID, err = paging.DecodeCursor(in.Cursor)
+
+		if declared := part.GetMetaString(model.MetaKeyMediaType); declared != "" {
+			canonical, assetMIME, err := resolveDeclaredMIME(declared, prepared.Asset.MIME)
 			if err != nil {
-				return nil, err
+				return part, nil, fmt.Errorf("parts[%d]: %w", idx, err)
 			}
+			part.Meta[model.MetaKeyMediaType] = canonical
+			prepared.Asset.MIME = assetMIME
 		}
 
-		// Query limit+1 is used to determine has_more
-		msgs, err = s.sessionRepo.ListBySessionWithCursor(ctx, in.SessionID, afterT, afterID, in.Limit+1, in.TimeDesc)
-		if err != nil {
-			return nil, err
+		part.Filename = fh.Filename
+		if stored := s.findStoredAsset(ctx, projectID, prepared.Asset.SHA256, userKEK); stored != nil {
+			// Identical content is already stored: link the shared asset instead of uploading.
+			part.Asset = stored
+		} else {
+			pending = prepared
+			part.Asset = &prepared.Asset
 		}
+		s.describeAsset(ctx, part.Asset, prepared.Content)
 	}
 
-	// Load parts for each message, filtering out those with failed loads
-	n := 0
-	for i, m := range msgs {
-		meta := m.PartsAssetMeta.Data()
-		parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), meta, in.UserKEK)
-		if !ok {
-			continue // Drop messages with failed parts loading
-		}
-		msgs[i].Parts = parts
-		msgs[n] = msgs[i]
-		n++
+	if partIn.Text != "" {
+		part.Text = partIn.Text
 	}
-	msgs = msgs[:n]
+	return part, pending, nil
+}
 
-	// Always sort messages from old to new (ascending by created_at)
-	// regardless of the in.TimeDesc parameter used for cursor pagination
-	sort.Slice(msgs, func(i, j int) bool {
-		if msgs[i].CreatedAt.Equal(msgs[j].CreatedAt) {
-			return msgs[i].ID.String() < msgs[j].ID.String()
-		}
-		return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
-	})
+// resolveDeclaredMIME checks the media type a part declares against the MIME type sniffed from
+// its content. It returns the declared type in canonical form and the MIME type to store on the
+// asset: the detected one, unless detection found nothing more specific than binary or text.
+func resolveDeclaredMIME(declared, detected string) (canonical string, assetMIME string, err error) {
+	canonical, err = mime.Normalize(declared)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidMIME, declared)
+	}
+	if !mime.Compatible(canonical, detected) {
+		return "", "", fmt.Errorf("%w: declared %s, detected %s", ErrMIMEMismatch, canonical, detected)
+	}
+	if mime.IsGeneric(detected) {
+		return canonical, canonical, nil
+	}
+	return canonical, detected, nil
+}
 
-	// Build output with pagination info
-	out := &GetMessagesOutput{
-		Items:   msgs,
-		HasMore: false,
+type StartStreamingMessageInput struct {
+	ProjectID   uuid.UUID
+	SessionID   uuid.UUID
+	MessageMeta map[string]interface{}
+	UserKEK     []byte
+	// AuthorID and AuthorType optionally attribute the reply, e.g. to the agent streaming it.
+	AuthorID   *uuid.UUID
+	AuthorType string
+}
+
+// StartStreamingMessage creates an empty assistant message that text deltas can be appended to.
+// Deltas are buffered in the database, so streaming is unavailable with envelope encryption.
+func (s *sessionService) StartStreamingMessage(ctx context.Context, in StartStreamingMessageInput) (*model.Message, error) {
+	if in.UserKEK != nil {
+		return nil, ErrStreamingEncrypted
 	}
-	if in.Limit > 0 && len(msgs) > in.Limit {
-		out.HasMore = true
-		out.Items = msgs[:in.Limit]
-		last := out.Items[len(out.Items)-1]
-		out.NextCursor = paging.EncodeCursor(last.CreatedAt, last.ID)
+	if err := validateAuthor(in.AuthorID, in.AuthorType); err != nil {
+		return nil, err
+	}
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	// Streamed messages finalize into a single text part.
+	if err := session.CheckPartTypes([]model.Part{{Type: model.PartTypeText}}); err != nil {
+		return nil, err
 	}
 
-	// Fetch events if requested
-	if in.WithEvents && len(out.Items) > 0 {
-		first := out.Items[0].CreatedAt
-		last := out.Items[len(out.Items)-1].CreatedAt
-		minTime, maxTime := first, last
-		if first.After(last) {
-			minTime, maxTime = last, first
+	messageMeta := in.MessageMeta
+	if messageMeta == nil {
+		messageMeta = make(map[string]interface{})
+	}
+	msg := model.Message{
+		SessionID:      in.SessionID,
+		Role:           model.RoleAssistant,
+		AuthorID:       in.AuthorID,
+		AuthorType:     in.AuthorType,
+		Meta:           datatypes.NewJSONType(messageMeta),
+		PartsAssetMeta: datatypes.NewJSONType(model.Asset{}),
+		Parts:          []model.Part{},
+		Streaming:      true,
+		// Keep the task pipeline away until the message is complete; FinalizeMessage resets it.
+		SessionTaskProcessStatus: model.MessageStatusDisableTracking,
+	}
+	if err := s.sessionRepo.CreateMessageWithAssets(ctx, &msg, s.cfg.Quota.MaxSessionBytes); err != nil {
+		if errors.Is(err, repo.ErrSessionArchived) {
+			return nil, ErrSessionArchived
 		}
-		events, err := s.sessionEventRepo.ListBySessionInTimeWindow(ctx, in.SessionID, minTime, maxTime)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch events: %w", err)
+		if errors.Is(err, repo.ErrSessionFinalized) {
+			return nil, ErrSessionFinalized
 		}
-		out.Events = events
+		return nil, err
 	}
+	metrics.MessagesCreated.Inc(msg.Role)
+	return &msg, nil
+}
 
-	// Apply edit strategies if provided (before format conversion)
-	if len(in.EditStrategies) > 0 {
-		result, err := editor.ApplyStrategiesWithPin(out.Items, in.EditStrategies, in.PinEditingStrategiesAtMessage)
-		if err != nil {
-			return nil, fmt.Errorf("failed to apply edit strategies: %w", err)
-		}
-		out.Items = result.Messages
-		out.EditAtMessageID = result.EditAtMessageID
-	} else if len(out.Items) > 0 {
+type AppendMessagePartInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	Delta     string
+}
+
+// AppendMessagePart appends a text delta to the last text part of a streaming message.
+func (s *sessionService) AppendMessagePart(ctx context.Context, in AppendMessagePartInput) error {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.AppendStreamText(ctx, in.SessionID, in.MessageID, in.Delta); err != nil {
+		return mapStreamingErr(err)
+	}
+	return nil
+}
+
+type FinalizeMessageInput struct {
+	ProjectID     uuid.UUID
+	SessionID     uuid.UUID
+	MessageID     uuid.UUID
+	TokenEncoding string // optional: defaults to tokenizer.DefaultEncoding
+}
+
+// FinalizeMessage turns the buffered text of a streaming message into its parts asset
+// and marks it complete, handing it to the task pipeline like a regular message.
+func (s *sessionService) FinalizeMessage(ctx context.Context, in FinalizeMessageInput) (*model.Message, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	encoding := in.TokenEncoding
+	if encoding == "" {
+		encoding = tokenizer.DefaultEncoding
+	}
+
+	var prepared *blob.PreparedUpload
+	var parts []model.Part
+	disableTaskTracking := false
+	msg, err := s.sessionRepo.FinalizeStreamingMessage(ctx, in.SessionID, in.MessageID, func(m *model.Message) error {
+		parts = []model.Part{}
+		if m.StreamText != "" {
+			parts = append(parts, model.Part{Type: model.PartTypeText, Text: m.StreamText})
+		}
+
+		var err error
+		prepared, err = s.preparePartsAsset("parts/"+in.ProjectID.String(), parts)
+		if err != nil {
+			return fmt.Errorf("prepare parts asset failed: %w", err)
+		}
+		// Upload before commit so a finalized message never points at a missing object.
+		if err := s.s3.UploadPrepared(ctx, prepared, nil); err != nil {
+			return fmt.Errorf("upload parts asset: %w", err)
+		}
+		m.PartsAssetMeta = datatypes.NewJSONType(prepared.Asset)
+		m.StorageBytes = messageStorageBytes(prepared.Asset, parts)
+		m.SearchText = searchTextFromParts(parts)
+		m.TokenCount, err = tokenizer.CountPartsTokens(parts, encoding)
+		if err != nil {
+			return fmt.Errorf("count tokens: %w", err)
+		}
+		m.TokenEncoding = encoding
+
+		disableTaskTracking, err = s.sessionRepo.GetDisableTaskTracking(ctx, in.SessionID)
+		if err != nil {
+			s.log.Error("failed to get disable_task_tracking for session", zap.Error(err))
+		}
+		m.SessionTaskProcessStatus = model.MessageStatusPending
+		if disableTaskTracking {
+			m.SessionTaskProcessStatus = model.MessageStatusDisableTracking
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, mapStreamingErr(err)
+	}
+	msg.Parts = parts
+
+	if s.redis != nil {
+		if err := s.cachePartsInRedis(ctx, in.ProjectID.String(), prepared.Asset.SHA256, parts, nil); err != nil {
+			s.log.Warn("failed to cache parts in Redis", zap.String("sha256", prepared.Asset.SHA256), zap.Error(err))
+		}
+	}
+	if err := s.assetRefBuffer.Enqueue(ctx, in.ProjectID, []model.Asset{prepared.Asset}); err != nil {
+		s.log.Error("failed to enqueue asset ref increments",
+			zap.String("project_id", in.ProjectID.String()), zap.Error(err))
+	}
+
+	if !disableTaskTracking && s.publisher != nil {
+		mqMsg := StoreMQPublishJSON{
+			ProjectID: in.ProjectID,
+			SessionID: in.SessionID,
+			MessageID: msg.ID,
+		}
+		if err := s.publisher.PublishJSON(ctx, s.cfg.RabbitMQ.ExchangeName.SessionMessage, s.cfg.RabbitMQ.RoutingKey.SessionMessageInsert, mqMsg); err != nil {
+			s.log.Error("publish session message", zap.Error(err))
+		}
+	}
+
+	return msg, nil
+}
+
+// mapStreamingErr translates repo errors of the streaming and editing flows into service errors.
+func mapStreamingErr(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrMessageNotFound
+	case errors.Is(err, repo.ErrMessageNotStreaming):
+		return ErrMessageNotStreaming
+	case errors.Is(err, repo.ErrMessageStreaming):
+		return ErrMessageStreaming
+	case errors.Is(err, repo.ErrMessageShared):
+		return ErrMessageShared
+	}
+	return err
+}
+
+type UpdateMessagePartsInput struct {
+	ProjectID     uuid.UUID
+	SessionID     uuid.UUID
+	MessageID     uuid.UUID
+	Parts         []PartIn
+	Files         map[string]*multipart.FileHeader
+	UserKEK       []byte
+	TokenEncoding string // optional: defaults to tokenizer.DefaultEncoding
+	// ExpectedVersion is the message version the edit is based on; 0 skips the check.
+	ExpectedVersion int
+	// Override lets the change through a finalized session or past the immutability window.
+	Override *ImmutabilityOverride
+}
+
+// UpdateMessageParts replaces a message's parts and keeps the previous ones as a revision.
+// Its role, meta, parent and children are unchanged. The new parts are uploaded before the
+// edit commits; the previous parts object stays referenced by the revision. A stale
+// ExpectedVersion fails with a *VersionConflictError.
+func (s *sessionService) UpdateMessageParts(ctx context.Context, in UpdateMessagePartsInput) (*model.Message, error) {
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	encoding := in.TokenEncoding
+	if encoding == "" {
+		encoding = tokenizer.DefaultEncoding
+	}
+
+	parts := make([]model.Part, 0, len(in.Parts))
+	var assets []model.Asset
+	var pendingUploads []*blob.PreparedUpload
+	for idx := range in.Parts {
+		part, prepared, err := s.buildPart(ctx, in.ProjectID, &in.Parts[idx], in.Files, idx, in.UserKEK)
+		if err != nil {
+			return nil, err
+		}
+		if part.Asset != nil {
+			assets = append(assets, *part.Asset)
+		}
+		if prepared != nil {
+			pendingUploads = append(pendingUploads, prepared)
+		}
+		parts = append(parts, part)
+	}
+	if err := model.ValidateParts(parts); err != nil {
+		return nil, err
+	}
+	if err := session.CheckPartTypes(parts); err != nil {
+		return nil, err
+	}
+	if err := s.validateToolArguments(in.SessionID, parts); err != nil {
+		return nil, err
+	}
+
+	current, err := s.sessionRepo.GetMessageByID(ctx, in.SessionID, in.MessageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if err := s.checkMutable(ctx, session, &current.ID, model.OverrideUpdateParts, in.Override, current.CreatedAt); err != nil {
+		return nil, err
+	}
+	return s.commitMessageParts(ctx, messagePartsEdit{
+		ProjectID:       in.ProjectID,
+		SessionID:       in.SessionID,
+		Current:         current,
+		Parts:           parts,
+		Assets:          assets,
+		PendingUploads:  pendingUploads,
+		Encoding:        encoding,
+		ExpectedVersion: in.ExpectedVersion,
+		UserKEK:         in.UserKEK,
+	})
+}
+
+// messagePartsEdit is a new version of a message's parts, ready to be committed.
+type messagePartsEdit struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	Current   *model.Message
+	Parts     []model.Part
+	// Assets are the part assets the new version references; PendingUploads are the ones not yet stored.
+	Assets         []model.Asset
+	PendingUploads []*blob.PreparedUpload
+	Encoding       string
+	// CheckCurrent rejects the edit with ErrMessagePartsChanged when the message no longer has the
+	// parts of Current, for edits that derive the new parts from the old ones.
+	CheckCurrent bool
+	// ExpectedVersion rejects the edit with a *VersionConflictError unless the message is still at
+	// this version; 0 skips the check.
+	ExpectedVersion int
+	UserKEK         []byte
+}
+
+// VersionConflictError reports an edit based on a message version that is no longer current.
+type VersionConflictError struct {
+	Expected int `json:"expected_version"`
+	Current  int `json:"current_version"`
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s: expected version %d, message is at version %d", ErrVersionConflict, e.Expected, e.Current)
+}
+
+func (e *VersionConflictError) Unwrap() error { return ErrVersionConflict }
+
+func checkMessageVersion(m *model.Message, expected int) error {
+	if expected > 0 && m.Version != expected {
+		return &VersionConflictError{Expected: expected, Current: m.Version}
+	}
+	return nil
+}
+
+// commitMessageParts uploads the parts object of an edit and points the message at it, keeping
+// the previous parts as a revision.
+func (s *sessionService) commitMessageParts(ctx context.Context, e messagePartsEdit) (*model.Message, error) {
+	// Fail a stale edit before anything is uploaded; the locked row is checked again below.
+	if err := checkMessageVersion(e.Current, e.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	partsPrepared, err := s.preparePartsAsset("parts/"+e.ProjectID.String(), e.Parts)
+	if err != nil {
+		return nil, fmt.Errorf("prepare parts asset failed: %w", err)
+	}
+	pendingUploads := append(slices.Clone(e.PendingUploads), partsPrepared)
+	assets := append(slices.Clone(e.Assets), partsPrepared.Asset)
+	tokenCount, err := tokenizer.CountPartsTokens(e.Parts, e.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("count tokens: %w", err)
+	}
+
+	// Only growth counts against the quota, so check it before anything is uploaded.
+	storageBytes := messageStorageBytes(partsPrepared.Asset, e.Parts)
+	if err := s.CheckQuota(ctx, e.SessionID, storageBytes-e.Current.StorageBytes); err != nil {
+		return nil, err
+	}
+
+	// Upload before commit so an edited message never points at a missing object.
+	for _, p := range pendingUploads {
+		if err := s.s3.UploadPrepared(ctx, p, e.UserKEK); err != nil {
+			return nil, fmt.Errorf("upload %s: %w", p.Asset.S3Key, err)
+		}
+	}
+
+	currentSHA := e.Current.PartsAssetMeta.Data().SHA256
+	msg, _, err := s.sessionRepo.UpdateMessageParts(ctx, e.SessionID, e.Current.ID, func(m *model.Message) error {
+		if err := checkMessageVersion(m, e.ExpectedVersion); err != nil {
+			return err
+		}
+		if e.CheckCurrent && m.PartsAssetMeta.Data().SHA256 != currentSHA {
+			return ErrMessagePartsChanged
+		}
+		m.PartsAssetMeta = datatypes.NewJSONType(partsPrepared.Asset)
+		m.StorageBytes = storageBytes
+		m.SearchText = ""
+		if e.UserKEK == nil {
+			m.SearchText = searchTextFromParts(e.Parts)
+		}
+		m.TokenCount = tokenCount
+		m.TokenEncoding = e.Encoding
+		return nil
+	})
+	if err != nil {
+		return nil, mapStreamingErr(err)
+	}
+	msg.Parts = e.Parts
+
+	if s.redis != nil {
+		if err := s.cachePartsInRedis(ctx, e.ProjectID.String(), partsPrepared.Asset.SHA256, e.Parts, e.UserKEK); err != nil {
+			s.log.Warn("failed to cache parts in Redis", zap.String("sha256", partsPrepared.Asset.SHA256), zap.Error(err))
+		}
+	}
+	if err := s.assetRefBuffer.Enqueue(ctx, e.ProjectID, assets); err != nil {
+		s.log.Error("failed to enqueue asset ref increments",
+			zap.String("project_id", e.ProjectID.String()), zap.Error(err))
+	}
+	s.enqueueEnrichment(ctx, e.ProjectID, msg, e.UserKEK, true)
+
+	return redactMessage(ctx, msg), nil
+}
+
+// AssetsNotFoundError lists the requested asset IDs that do not exist in the project.
+type AssetsNotFoundError struct {
+	AssetIDs []uuid.UUID `json:"asset_ids"`
+}
+
+func (e *AssetsNotFoundError) Error() string {
+	ids := make([]string, len(e.AssetIDs))
+	for i, id := range e.AssetIDs {
+		ids[i] = id.String()
+	}
+	return fmt.Sprintf("%s: %s", ErrAssetNotFound, strings.Join(ids, ", "))
+}
+
+func (e *AssetsNotFoundError) Unwrap() error { return ErrAssetNotFound }
+
+type MessageAssetsInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	AssetIDs  []uuid.UUID
+	// ExpectedVersion is the message version the edit is based on; 0 skips the check.
+	ExpectedVersion int
+	UserKEK         []byte
+	// Override lets the change through a finalized session or past the immutability window.
+	Override *ImmutabilityOverride
+}
+
+// AttachAssets links stored assets to an existing message by appending a media part for each,
+// typed from the asset's MIME type. Assets the message already links are skipped. The edit is
+// recorded as a revision like any other parts edit, and its part references are added with it.
+func (s *sessionService) AttachAssets(ctx context.Context, in MessageAssetsInput) (*model.Message, error) {
+	current, parts, refs, err := s.loadMessageAssets(ctx, in, model.OverrideAttachAssets)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := make(map[string]bool, len(parts))
+	for _, p := range parts {
+		if p.Asset != nil {
+			linked[p.Asset.SHA256] = true
+		}
+	}
+	var assets []model.Asset
+	for _, ref := range refs {
+		if linked[ref.SHA256] {
+			continue
+		}
+		linked[ref.SHA256] = true
+		asset := ref.AssetMeta.Data()
+		asset.SHA256 = ref.SHA256
+		asset.S3Key = ref.S3Key
+		// Extracted text belongs to whichever entity stored the asset first.
+		asset.Content = ""
+		parts = append(parts, model.NewAssetPart(asset, ""))
+		assets = append(assets, asset)
+	}
+	if len(assets) == 0 {
+		current.Parts = parts
+		return redactMessage(ctx, current), nil
+	}
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.CheckPartTypes(parts); err != nil {
+		return nil, err
+	}
+	return s.commitAssetEdit(ctx, in, current, parts, assets)
+}
+
+// DetachAssets removes the media parts that link the given assets from a message. The assets
+// themselves are kept: the revision the edit records still references them, and orphan
+// collection deletes them once nothing does.
+func (s *sessionService) DetachAssets(ctx context.Context, in MessageAssetsInput) (*model.Message, error) {
+	current, parts, refs, err := s.loadMessageAssets(ctx, in, model.OverrideDetachAssets)
+	if err != nil {
+		return nil, err
+	}
+
+	detach := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		detach[ref.SHA256] = true
+	}
+	kept := make([]model.Part, 0, len(parts))
+	for _, p := range parts {
+		if p.Asset != nil && detach[p.Asset.SHA256] {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if len(kept) == len(parts) {
+		current.Parts = parts
+		return redactMessage(ctx, current), nil
+	}
+	if err := model.ValidateParts(kept); err != nil {
+		return nil, err
+	}
+	var assets []model.Asset
+	for _, p := range kept {
+		if p.Asset != nil {
+			assets = append(assets, *p.Asset)
+		}
+	}
+	return s.commitAssetEdit(ctx, in, current, kept, assets)
+}
+
+// loadMessageAssets checks an attach or detach request and returns the message, its current
+// parts and the requested asset rows, in request order. Every asset must exist in the project.
+// action is the edit checkMutable records an override as.
+func (s *sessionService) loadMessageAssets(ctx context.Context, in MessageAssetsInput, action string) (*model.Message, []model.Part, []model.AssetReference, error) {
+	if in.UserKEK != nil {
+		return nil, nil, nil, ErrAssetAttachEncrypted
+	}
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	found, err := s.assetReferenceRepo.GetByIDs(ctx, in.ProjectID, in.AssetIDs)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get assets: %w", err)
+	}
+	byID := make(map[uuid.UUID]model.AssetReference, len(found))
+	for _, ref := range found {
+		byID[ref.ID] = ref
+	}
+	refs := make([]model.AssetReference, 0, len(in.AssetIDs))
+	missing := &AssetsNotFoundError{}
+	for _, id := range in.AssetIDs {
+		ref, ok := byID[id]
+		if !ok {
+			if !slices.Contains(missing.AssetIDs, id) {
+				missing.AssetIDs = append(missing.AssetIDs, id)
+			}
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	if len(missing.AssetIDs) > 0 {
+		return nil, nil, nil, missing
+	}
+
+	current, err := s.sessionRepo.GetMessageByID(ctx, in.SessionID, in.MessageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil, ErrMessageNotFound
+		}
+		return nil, nil, nil, err
+	}
+	if current.Streaming {
+		return nil, nil, nil, ErrMessageStreaming
+	}
+	if err := checkMessageVersion(current, in.ExpectedVersion); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := s.checkMutable(ctx, session, &current.ID, action, in.Override, current.CreatedAt); err != nil {
+		return nil, nil, nil, err
+	}
+	parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), current.PartsAssetMeta.Data(), nil)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("load parts of message %s", current.ID)
+	}
+	return current, parts, refs, nil
+}
+
+// commitAssetEdit commits parts derived from the message's current parts. Nothing new is
+// uploaded: every part asset is already stored.
+func (s *sessionService) commitAssetEdit(ctx context.Context, in MessageAssetsInput, current *model.Message, parts []model.Part, assets []model.Asset) (*model.Message, error) {
+	encoding := current.TokenEncoding
+	if encoding == "" {
+		encoding = tokenizer.DefaultEncoding
+	}
+	return s.commitMessageParts(ctx, messagePartsEdit{
+		ProjectID:       in.ProjectID,
+		SessionID:       in.SessionID,
+		Current:         current,
+		Parts:           parts,
+		Assets:          assets,
+		Encoding:        encoding,
+		CheckCurrent:    true,
+		ExpectedVersion: in.ExpectedVersion,
+	})
+}
+
+type PatchMessagePartsInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	// Patch is an RFC 6902 JSON Patch document applied to the message's parts array.
+	Patch   []byte
+	UserKEK []byte
+	// ExpectedVersion is the message version the edit is based on; 0 skips the check.
+	ExpectedVersion int
+	// Override lets the change through a finalized session or past the immutability window.
+	Override *ImmutabilityOverride
+}
+
+// PatchMessageParts applies a JSON Patch to a message's parts, in the acontext format, and
+// commits the result like UpdateMessageParts, keeping the previous parts as a revision. The
+// patched parts must decode into valid parts and may only reference assets the message already
+// has; new assets are added through the upload or attach endpoints. A principal without
+// model.ScopeReadSensitive may not address sensitive parts, which it only sees redacted: such an
+// operation returns ErrPatchSensitive. A failing test operation returns ErrPatchTestFailed and
+// any other patch that cannot be applied ErrInvalidPatch.
+func (s *sessionService) PatchMessageParts(ctx context.Context, in PatchMessagePartsInput) (*model.Message, error) {
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.sessionRepo.GetMessageByID(ctx, in.SessionID, in.MessageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if current.Streaming {
+		return nil, ErrMessageStreaming
+	}
+	if err := checkMessageVersion(current, in.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	if err := s.checkMutable(ctx, session, &current.ID, model.OverridePatchParts, in.Override, current.CreatedAt); err != nil {
+		return nil, err
+	}
+	parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), current.PartsAssetMeta.Data(), in.UserKEK)
+	if !ok {
+		return nil, fmt.Errorf("load parts of message %s", current.ID)
+	}
+
+	var check jsonpatch.Check
+	if !canReadSensitive(ctx) {
+		check = checkSensitivePatch
+	}
+	patched, err := patchParts(parts, in.Patch, check)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.ValidateParts(patched); err != nil {
+		return nil, err
+	}
+	if err := session.CheckPartTypes(patched); err != nil {
+		return nil, err
+	}
+	if err := s.validateToolArguments(in.SessionID, patched); err != nil {
+		return nil, err
+	}
+
+	encoding := current.TokenEncoding
+	if encoding == "" {
+		encoding = tokenizer.DefaultEncoding
+	}
+	var assets []model.Asset
+	for _, p := range patched {
+		if p.Asset != nil {
+			assets = append(assets, *p.Asset)
+		}
+	}
+	return s.commitMessageParts(ctx, messagePartsEdit{
+		ProjectID:       in.ProjectID,
+		SessionID:       in.SessionID,
+		Current:         current,
+		Parts:           patched,
+		Assets:          assets,
+		Encoding:        encoding,
+		CheckCurrent:    true,
+		ExpectedVersion: in.ExpectedVersion,
+		UserKEK:         in.UserKEK,
+	})
+}
+
+// patchParts applies patch to parts and decodes the result strictly, so a patch that leaves
+// something other than an array of parts, or adds members parts do not have, is rejected. check,
+// when not nil, is run before each operation.
+func patchParts(parts []model.Part, patch []byte, check jsonpatch.Check) ([]model.Part, error) {
+	doc, err := json.Marshal(parts)
+	if err != nil {
+		return nil, fmt.Errorf("encode parts: %w", err)
+	}
+	out, err := jsonpatch.ApplyChecked(doc, patch, check)
+	if err != nil {
+		if errors.Is(err, ErrPatchSensitive) {
+			return nil, err
+		}
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return nil, fmt.Errorf("%w: %v", ErrPatchTestFailed, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.DisallowUnknownFields()
+	var patched []model.Part
+	if err := dec.Decode(&patched); err != nil {
+		return nil, fmt.Errorf("%w: patched parts: %v", ErrInvalidPatch, err)
+	}
+	if len(patched) == 0 {
+		return nil, fmt.Errorf("%w: a message needs at least one part", ErrInvalidPatch)
+	}
+
+	// Asset members point at stored objects, so a patch may move or drop them but not make up new ones.
+	// Assets are compared by their JSON, which covers the Meta map a map key cannot hold.
+	assetKey := func(a *model.Asset) string {
+		b, _ := json.Marshal(a)
+		return string(b)
+	}
+	known := make(map[string]bool)
+	for _, p := range parts {
+		if p.Asset != nil {
+			known[assetKey(p.Asset)] = true
+		}
+	}
+	for i, p := range patched {
+		if p.Asset != nil && !known[assetKey(p.Asset)] {
+			return nil, fmt.Errorf("%w: parts[%d] references an asset the message does not have", ErrInvalidPatch, i)
+		}
+	}
+	return patched, nil
+}
+
+type GetMessageRevisionsInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	UserKEK   []byte
+}
+
+// GetMessageRevisions returns the previous versions of a message's parts, newest first.
+// A message that was never edited has no revisions.
+func (s *sessionService) GetMessageRevisions(ctx context.Context, in GetMessageRevisionsInput) ([]model.MessageRevision, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	if _, err := s.sessionRepo.GetMessageByID(ctx, in.SessionID, in.MessageID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	revs, err := s.sessionRepo.ListMessageRevisions(ctx, in.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("list revisions: %w", err)
+	}
+	for i := range revs {
+		parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), revs[i].PartsAssetMeta.Data(), in.UserKEK)
+		if !ok {
+			return nil, fmt.Errorf("load parts of revision %d", revs[i].Version)
+		}
+		revs[i].Parts = parts
+	}
+	if !canReadSensitive(ctx) {
+		for i := range revs {
+			revs[i].Parts = model.RedactForPrincipal(model.Message{Parts: revs[i].Parts}, nil).Parts
+		}
+	}
+	return revs, nil
+}
+
+type GetMessagePartsInput struct {
+	ProjectID   uuid.UUID
+	SessionID   uuid.UUID
+	MessageID   uuid.UUID
+	Type        model.PartType
+	AssetExpire time.Duration
+	UserKEK     []byte
+}
+
+type GetMessagePartsOutput struct {
+	Items      []model.Part         `json:"items"`
+	PublicURLs map[string]PublicURL `json:"public_urls,omitempty"` // asset sha256 -> url
+}
+
+// GetMessageParts returns the message's parts of the given type in order, with material URLs
+// for the assets of media parts. A message with no such parts yields an empty list.
+func (s *sessionService) GetMessageParts(ctx context.Context, in GetMessagePartsInput) (*GetMessagePartsOutput, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	msg, err := s.sessionRepo.GetMessageByID(ctx, in.SessionID, in.MessageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), msg.PartsAssetMeta.Data(), in.UserKEK)
+	if !ok {
+		return nil, fmt.Errorf("load parts of message %s", in.MessageID)
+	}
+	msg.Parts = parts
+
+	// Redacting turns a sensitive media part into text, so it is done on the selected parts to
+	// keep their places in the list.
+	typed := []model.Message{{Parts: msg.PartsOfType(in.Type)}}
+	redactMessages(ctx, typed, nil)
+	out := &GetMessagePartsOutput{Items: typed[0].Parts}
+	if out.Items == nil {
+		out.Items = []model.Part{}
+	}
+	switch in.Type {
+	case model.PartTypeImage, model.PartTypeAudio, model.PartTypeVideo, model.PartTypeFile:
+		if s.materialSvc != nil && len(out.Items) > 0 {
+			out.PublicURLs, err = s.buildPublicURLs(ctx, []model.Message{{Parts: out.Items}}, in.AssetExpire, in.UserKEK)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+type GetMessagesInput struct {
+	ProjectID                     uuid.UUID               `json:"project_id"`
+	SessionID                     uuid.UUID               `json:"session_id"`
+	Limit                         int                     `json:"limit"`
+	Cursor                        string                  `json:"cursor"`
+	WithAssetPublicURL            bool                    `json:"with_public_url"`
+	AssetExpire                   time.Duration           `json:"asset_expire"`
+	TimeDesc                      bool                    `json:"time_desc"`
+	WithEvents                    bool                    `json:"with_events"`
+	EditStrategies                []editor.StrategyConfig `json:"edit_strategies,omitempty"`
+	PinEditingStrategiesAtMessage string                  `json:"pin_editing_strategies_at_message,omitempty"`
+	Roles                         []string                `json:"roles,omitempty"` // optional: only return messages with these roles
+	// Since and Until optionally bound the messages' created_at, inclusively; zero leaves a side open.
+	Since time.Time `json:"since,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+	// AuthorID and AuthorType optionally keep only the messages of one author or kind of author.
+	AuthorID   *uuid.UUID `json:"author_id,omitempty"`
+	AuthorType string     `json:"author_type,omitempty"`
+	// AnnotationKind and AnnotationValue optionally keep only the messages carrying a matching
+	// annotation; an empty AnnotationValue matches any annotation of the kind.
+	AnnotationKind  string `json:"annotation_kind,omitempty"`
+	AnnotationValue string `json:"annotation_value,omitempty"`
+	// Fields optionally limits the messages to these fields, named as in model.MessageFieldColumns.
+	// Only their columns are read, and parts are loaded only when "parts" is among them.
+	Fields []string `json:"fields,omitempty"`
+	// WithTreeStats adds each returned message's depth and child count to the output.
+	WithTreeStats bool   `json:"with_tree_stats,omitempty"`
+	UserKEK       []byte `json:"-"` // optional: for envelope encryption (decrypting parts)
+}
+
+type PublicURL struct {
+	URL      string    `json:"url"`
+	ExpireAt time.Time `json:"expire_at"`
+}
+
+type GetMessagesOutput struct {
+	Items           []model.Message      `json:"items"`
+	Events          []model.SessionEvent `json:"events,omitempty"`
+	NextCursor      string               `json:"next_cursor,omitempty"`
+	HasMore         bool                 `json:"has_more"`
+	PublicURLs      map[string]PublicURL `json:"public_urls,omitempty"` // file_name -> url
+	EditAtMessageID string               `json:"edit_at_message_id,omitempty"`
+	// TreeStats is keyed by message ID; only set with GetMessagesInput.WithTreeStats.
+	TreeStats map[uuid.UUID]model.MessageTreeStats `json:"tree_stats,omitempty"`
+}
+
+func (s *sessionService) GetMessages(ctx context.Context, in GetMessagesInput) (*GetMessagesOutput, error) {
+	// Verify session exists and belongs to project
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: in.SessionID})
+	if err != nil {
+		return nil, fmt.Errorf("session not found")
+	}
+	if session.ProjectID != in.ProjectID {
+		return nil, fmt.Errorf("session not found")
+	}
+
+	var msgs []model.Message
+	columns := model.MessageFieldsColumns(in.Fields)
+	createdIn := repo.TimeRange{Since: in.Since, Until: in.Until}
+	author := repo.AuthorFilter{ID: in.AuthorID, Type: in.AuthorType}
+	annotated := repo.AnnotationFilter{Kind: in.AnnotationKind, Value: in.AnnotationValue}
+	withParts := len(in.Fields) == 0 || slices.Contains(in.Fields, "parts")
+
+	// Retrieve messages based on limit
+	if in.Limit <= 0 {
+		// If limit <= 0, retrieve all messages
+		msgs, err = s.sessionRepo.ListAllMessagesBySession(ctx, in.SessionID, in.Roles, columns, createdIn, author, annotated)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Parse cursor (seq, id); an empty cursor indicates starting from the latest
+		var afterSeq int64
+		var afterID uuid.UUID
+		if in.Cursor != "" {
+			afterSeq, afterID, err = paging.DecodeSeqCursor(in.Cursor)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Query limit+1 is used to determine has_more
+		msgs, err = s.sessionRepo.ListBySessionWithCursor(ctx, in.SessionID, afterSeq, afterID, in.Limit+1, in.TimeDesc, in.Roles, columns, createdIn, author, annotated)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Load parts for each message, filtering out those with failed loads
+	if withParts {
+		n := 0
+		for i, m := range msgs {
+			meta := m.PartsAssetMeta.Data()
+			parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), meta, in.UserKEK)
+			if !ok {
+				continue // Drop messages with failed parts loading
+			}
+			msgs[i].Parts = parts
+			msgs[n] = msgs[i]
+			n++
+		}
+		msgs = msgs[:n]
+	}
+
+	// Always sort messages from old to new (ascending by seq)
+	// regardless of the in.TimeDesc parameter used for cursor pagination
+	sortMessages(msgs)
+	redactMessages(ctx, msgs, nil)
+
+	// Build output with pagination info
+	out := &GetMessagesOutput{
+		Items:   msgs,
+		HasMore: false,
+	}
+	if in.Limit > 0 && len(msgs) > in.Limit {
+		out.HasMore = true
+		out.Items = msgs[:in.Limit]
+		last := out.Items[len(out.Items)-1]
+		out.NextCursor = paging.EncodeSeqCursor(last.Seq, last.ID)
+	}
+
+	// Fetch events if requested
+	if in.WithEvents && len(out.Items) > 0 {
+		first := out.Items[0].CreatedAt
+		last := out.Items[len(out.Items)-1].CreatedAt
+		minTime, maxTime := first, last
+		if first.After(last) {
+			minTime, maxTime = last, first
+		}
+		events, err := s.sessionEventRepo.ListBySessionInTimeWindow(ctx, in.SessionID, minTime, maxTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch events: %w", err)
+		}
+		out.Events = events
+	}
+
+	// Apply edit strategies if provided (before format conversion)
+	if len(in.EditStrategies) > 0 {
+		result, err := editor.ApplyStrategiesWithPin(out.Items, in.EditStrategies, in.PinEditingStrategiesAtMessage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply edit strategies: %w", err)
+		}
+		out.Items = result.Messages
+		out.EditAtMessageID = result.EditAtMessageID
+	} else if len(out.Items) > 0 {
 		// No strategies, but still set EditAtMessageID to the last message
 		out.EditAtMessageID = out.Items[len(out.Items)-1].ID.String()
 	}
 
-	// Generate material URLs for assets if requested (works for both encrypted and non-encrypted)
-	if in.WithAssetPublicURL && s.materialSvc != nil {
-		out.PublicURLs = make(map[string]PublicURL)
-		// Encode userKEK to base64 for material service
-		var userKEKB64 string
-		if in.UserKEK != nil {
-			userKEKB64 = base64.StdEncoding.EncodeToString(in.UserKEK)
+	if in.WithTreeStats {
+		ids := make([]uuid.UUID, len(out.Items))
+		for i, m := range out.Items {
+			ids[i] = m.ID
+		}
+		out.TreeStats, err = s.sessionRepo.GetMessageTreeStats(ctx, in.SessionID, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Generate material URLs for assets if requested (works for both encrypted and non-encrypted)
+	if in.WithAssetPublicURL && withParts && s.materialSvc != nil {
+		out.PublicURLs, err = s.buildPublicURLs(ctx, out.Items, in.AssetExpire, in.UserKEK)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// buildPublicURLs creates a material URL for every part asset of msgs, keyed by asset SHA256.
+func (s *sessionService) buildPublicURLs(ctx context.Context, msgs []model.Message, expire time.Duration, userKEK []byte) (map[string]PublicURL, error) {
+	urls := make(map[string]PublicURL)
+	// Encode userKEK to base64 for material service
+	var userKEKB64 string
+	if userKEK != nil {
+		userKEKB64 = base64.StdEncoding.EncodeToString(userKEK)
+	}
+	for _, m := range msgs {
+		for _, p := range m.Parts {
+			if p.Asset == nil {
+				continue
+			}
+			url, expireAt, err := s.materialSvc.CreateMaterialURL(ctx, p.Asset.S3Key, userKEKB64, expire, p.Asset.MIME, p.Filename)
+			if err != nil {
+				return nil, fmt.Errorf("create material url for asset %s: %w", p.Asset.S3Key, err)
+			}
+			urls[p.Asset.SHA256] = PublicURL{
+				URL:      url,
+				ExpireAt: expireAt,
+			}
+			if err := s.fillThumbnailURLs(ctx, p, expire, urls); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return urls, nil
+}
+
+// fillThumbnailURLs mints URLs for the part's thumbnails and writes them into the
+// thumbnails Meta entry, so previews render without fetching the full image.
+// Thumbnails are stored unencrypted, so their URLs carry no KEK.
+func (s *sessionService) fillThumbnailURLs(ctx context.Context, p model.Part, expire time.Duration, urls map[string]PublicURL) error {
+	thumbs := p.Thumbnails()
+	if len(thumbs) == 0 {
+		return nil
+	}
+	for i := range thumbs {
+		t := &thumbs[i]
+		pu, ok := urls[t.Asset.SHA256]
+		if !ok {
+			url, expireAt, err := s.materialSvc.CreateMaterialURL(ctx, t.Asset.S3Key, "", expire, t.Asset.MIME, path.Base(t.Asset.S3Key))
+			if err != nil {
+				return fmt.Errorf("create material url for thumbnail %s: %w", t.Asset.S3Key, err)
+			}
+			pu = PublicURL{URL: url, ExpireAt: expireAt}
+			urls[t.Asset.SHA256] = pu
+		}
+		t.URL = pu.URL
+		t.ExpireAt = &pu.ExpireAt
+	}
+	p.Meta[model.MetaKeyThumbnails] = map[string]any{"items": thumbs}
+	return nil
+}
+
+type ExportSessionInput struct {
+	ProjectID   uuid.UUID
+	SessionID   uuid.UUID
+	AssetExpire time.Duration
+	UserKEK     []byte
+}
+
+type ExportSessionOutput struct {
+	// Messages is the main branch from root to the newest message, with parts loaded.
+	Messages   []model.Message
+	PublicURLs map[string]PublicURL
+}
+
+// ExportSession collects the session's main branch - the thread ending at its newest
+// message - for conversion to an external format. Unlike GetMessages, a message whose
+// parts fail to load fails the export instead of being skipped.
+func (s *sessionService) ExportSession(ctx context.Context, in ExportSessionInput) (*ExportSessionOutput, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+
+	msgs, _, err := s.loadBranch(ctx, in.ProjectID, in.SessionID, nil, in.UserKEK)
+	if err != nil {
+		return nil, err
+	}
+	redactMessages(ctx, msgs, nil)
+	out := &ExportSessionOutput{Messages: msgs}
+	if s.materialSvc != nil {
+		out.PublicURLs, err = s.buildPublicURLs(ctx, out.Messages, in.AssetExpire, in.UserKEK)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// loadBranch returns the live messages from the root down to leafID, with parts loaded, and the
+// leaf it used. A nil leafID selects the main branch, which ends at the newest message; an empty
+// session has no branch and returns no messages and a nil leaf. A message whose parts fail to
+// load fails the call.
+func (s *sessionService) loadBranch(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, leafID *uuid.UUID, userKEK []byte) ([]model.Message, *uuid.UUID, error) {
+	if leafID == nil {
+		latest, err := s.sessionRepo.ListBySessionWithCursor(ctx, sessionID, 0, uuid.Nil, 1, true, nil, nil, repo.TimeRange{}, repo.AuthorFilter{}, repo.AnnotationFilter{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get latest message: %w", err)
+		}
+		if len(latest) == 0 {
+			return []model.Message{}, nil, nil
+		}
+		leafID = &latest[0].ID
+	}
+
+	chain, err := s.sessionRepo.GetMessageThread(ctx, sessionID, *leafID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrMessageNotFound
+		}
+		if errors.Is(err, repo.ErrMessageCycle) {
+			return nil, nil, ErrMessageCycle
+		}
+		return nil, nil, fmt.Errorf("failed to get message thread: %w", err)
+	}
+	if chain[len(chain)-1].DeletedAt.Valid {
+		return nil, nil, ErrMessageNotFound
+	}
+
+	msgs := make([]model.Message, 0, len(chain))
+	for _, m := range chain {
+		if m.DeletedAt.Valid {
+			continue
+		}
+		parts, ok := s.loadPartsForMessage(ctx, projectID.String(), m.PartsAssetMeta.Data(), userKEK)
+		if !ok {
+			return nil, nil, fmt.Errorf("failed to load parts of message %s", m.ID)
+		}
+		m.Parts = parts
+		msgs = append(msgs, m)
+	}
+	return msgs, leafID, nil
+}
+
+type ReplaySessionInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	// BranchMessageID is the message the replayed branch ends at; nil replays the main branch.
+	BranchMessageID *uuid.UUID
+	UserKEK         []byte
+}
+
+type ReplaySessionOutput struct {
+	// LeafMessageID is the message the branch ends at, nil for an empty session.
+	LeafMessageID *uuid.UUID
+	Turns         []editor.ReplayTurn
+}
+
+// ReplaySession splits a branch of the session into the recorded turns an evaluation harness
+// can re-run: each turn pairs the input context with the assistant output that followed it.
+// The result depends only on the stored messages, so replaying the same branch twice yields
+// the same turns.
+func (s *sessionService) ReplaySession(ctx context.Context, in ReplaySessionInput) (*ReplaySessionOutput, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	msgs, leafID, err := s.loadBranch(ctx, in.ProjectID, in.SessionID, in.BranchMessageID, in.UserKEK)
+	if err != nil {
+		return nil, err
+	}
+	redactMessages(ctx, msgs, nil)
+	return &ReplaySessionOutput{LeafMessageID: leafID, Turns: editor.ReplayTurns(msgs)}, nil
+}
+
+// ImportMessageIn is one message of an imported conversation, already normalized.
+type ImportMessageIn struct {
+	Role  string
+	Parts []PartIn
+	Meta  map[string]interface{}
+}
+
+type ImportSessionInput struct {
+	ProjectID uuid.UUID
+	Messages  []ImportMessageIn
+	UserKEK   []byte
+}
+
+type ImportSessionOutput struct {
+	Session    model.Session `json:"session"`
+	MessageIDs []uuid.UUID   `json:"message_ids"`
+}
+
+// ImportSession creates a new session holding in.Messages as one linear parent chain.
+// Inline base64 media is decoded and stored as assets. Assets are uploaded before the
+// rows are written, so a failed upload leaves no session behind.
+func (s *sessionService) ImportSession(ctx context.Context, in ImportSessionInput) (*ImportSessionOutput, error) {
+	if len(in.Messages) == 0 {
+		return nil, errors.New("import requires at least one message")
+	}
+
+	projectKey := in.ProjectID.String()
+	var uploadedAssets []model.Asset
+	var pendingUploads []*blob.PreparedUpload
+	msgs := make([]model.Message, 0, len(in.Messages))
+	partsByMsg := make([][]model.Part, 0, len(in.Messages))
+	var prevID *uuid.UUID
+	var totalBytes int64
+
+	for i, mi := range in.Messages {
+		parts := make([]model.Part, 0, len(mi.Parts))
+		for j, partIn := range mi.Parts {
+			part := model.Part{Type: partIn.Type, Text: partIn.Text, Meta: partIn.Meta}
+			prepared, err := s.prepareInlineAsset(projectKey, &part, fmt.Sprintf("%s-%d-%d", part.Type, i, j))
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d].parts[%d]: %w", i, j, err)
+			}
+			if prepared != nil {
+				if stored := s.findStoredAsset(ctx, in.ProjectID, prepared.Asset.SHA256, in.UserKEK); stored != nil {
+					part.Asset = stored
+				} else {
+					pendingUploads = append(pendingUploads, prepared)
+				}
+				s.describeAsset(ctx, part.Asset, prepared.Content)
+				uploadedAssets = append(uploadedAssets, *part.Asset)
+			}
+			parts = append(parts, part)
+		}
+		if err := model.ValidateParts(parts); err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+
+		partsAssetPrepared, err := s.preparePartsAsset("parts/"+projectKey, parts)
+		if err != nil {
+			return nil, fmt.Errorf("prepare parts asset failed: %w", err)
+		}
+		pendingUploads = append(pendingUploads, partsAssetPrepared)
+		uploadedAssets = append(uploadedAssets, partsAssetPrepared.Asset)
+
+		messageMeta := mi.Meta
+		if messageMeta == nil {
+			messageMeta = make(map[string]interface{})
+		}
+		// Temporary IDs: CreateSessionWithMessages assigns the stored ones.
+		tempID := uuid.New()
+		msg := model.Message{
+			ID:             tempID,
+			ParentID:       prevID,
+			Role:           mi.Role,
+			Meta:           datatypes.NewJSONType(messageMeta),
+			PartsAssetMeta: datatypes.NewJSONType(partsAssetPrepared.Asset),
+			Parts:          parts,
+			StorageBytes:   messageStorageBytes(partsAssetPrepared.Asset, parts),
+		}
+		totalBytes += msg.StorageBytes
+		if in.UserKEK == nil {
+			msg.SearchText = searchTextFromParts(parts)
+		}
+		tokenCount, err := tokenizer.CountPartsTokens(parts, tokenizer.DefaultEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("count tokens: %w", err)
+		}
+		msg.TokenCount = tokenCount
+		msg.TokenEncoding = tokenizer.DefaultEncoding
+
+		msgs = append(msgs, msg)
+		partsByMsg = append(partsByMsg, parts)
+		prevID = &tempID
+	}
+	if err := s.quotaErr(0, totalBytes); err != nil {
+		return nil, err
+	}
+
+	for _, p := range pendingUploads {
+		if err := s.s3.UploadPrepared(ctx, p, in.UserKEK); err != nil {
+			return nil, fmt.Errorf("upload %s failed: %w", p.Asset.S3Key, err)
+		}
+	}
+
+	session := model.Session{ProjectID: in.ProjectID}
+	if err := s.sessionRepo.CreateSessionWithMessages(ctx, &session, msgs); err != nil {
+		var batchErr *repo.BatchMessageError
+		if errors.As(err, &batchErr) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImport, batchErr.Error())
+		}
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+
+	return s.finishImport(ctx, in.ProjectID, &session, msgs, partsByMsg, uploadedAssets, in.UserKEK), nil
+}
+
+// finishImport runs the follow-up of a stored import: it adds the asset references, caches the
+// parts and hands each message to the task pipeline unless the session disables tracking.
+func (s *sessionService) finishImport(ctx context.Context, projectID uuid.UUID, session *model.Session, msgs []model.Message, partsByMsg [][]model.Part, assets []model.Asset, userKEK []byte) *ImportSessionOutput {
+	projectKey := projectID.String()
+	if err := s.assetRefBuffer.Enqueue(ctx, projectID, assets); err != nil {
+		s.log.Error("failed to enqueue asset ref increments",
+			zap.String("project_id", projectKey), zap.Error(err))
+	}
+
+	out := &ImportSessionOutput{Session: *session, MessageIDs: make([]uuid.UUID, 0, len(msgs))}
+	for i, msg := range msgs {
+		out.MessageIDs = append(out.MessageIDs, msg.ID)
+		metrics.MessagesCreated.Inc(msg.Role)
+		if s.redis != nil {
+			sha := msg.PartsAssetMeta.Data().SHA256
+			if err := s.cachePartsInRedis(ctx, projectKey, sha, partsByMsg[i], userKEK); err != nil {
+				s.log.Warn("failed to cache parts in Redis", zap.String("sha256", sha), zap.Error(err))
+			}
+		}
+		if s.publisher != nil && !session.DisableTaskTracking {
+			mqMsg := StoreMQPublishJSON{
+				ProjectID: projectID,
+				SessionID: session.ID,
+				MessageID: msg.ID,
+			}
+			if userKEK != nil {
+				mqMsg.UserKEK = base64.StdEncoding.EncodeToString(userKEK)
+			}
+			if err := s.publisher.PublishJSON(ctx, s.cfg.RabbitMQ.ExchangeName.SessionMessage, s.cfg.RabbitMQ.RoutingKey.SessionMessageInsert, mqMsg); err != nil {
+				s.log.Error("publish session message", zap.Error(err))
+			}
+		}
+	}
+	return out
+}
+
+type ExportSessionBundleInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	UserKEK   []byte
+}
+
+// SessionBundle is a session read for export, ready to be streamed as a zip bundle.
+type SessionBundle struct {
+	SessionID uuid.UUID
+	session   bundle.Session
+	assets    map[string]model.Asset
+	download  func(ctx context.Context, key string) ([]byte, error)
+}
+
+// Write streams the bundle to w. Assets are downloaded and written one at a time, so only the
+// largest asset is held in memory. An error leaves w with a truncated archive.
+func (b *SessionBundle) Write(ctx context.Context, w io.Writer) error {
+	bw := bundle.NewWriter(w, b.SessionID)
+	if err := bw.WriteSession(b.session); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(b.assets))
+	for name := range b.assets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content, err := b.download(ctx, b.assets[name].S3Key)
+		if err != nil {
+			return fmt.Errorf("download %s: %w", b.assets[name].S3Key, err)
+		}
+		if err := bw.WriteAsset(name, content); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	return bw.Close()
+}
+
+// ExportSessionBundle reads every live message of the session, branches included, for a zip
+// bundle that ImportSessionBundle restores. Parts are loaded up front, so a message whose parts
+// fail to load fails the export before anything is streamed.
+func (s *sessionService) ExportSessionBundle(ctx context.Context, in ExportSessionBundleInput) (*SessionBundle, error) {
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: in.SessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("get session: %w", err)
+	}
+	if session.ProjectID != in.ProjectID {
+		return nil, ErrSessionNotFound
+	}
+
+	msgs, err := s.sessionRepo.ListAllMessagesBySession(ctx, in.SessionID, nil, nil, repo.TimeRange{}, repo.AuthorFilter{}, repo.AnnotationFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	for i, m := range msgs {
+		parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), m.PartsAssetMeta.Data(), in.UserKEK)
+		if !ok {
+			return nil, fmt.Errorf("failed to load parts of message %s", m.ID)
+		}
+		msgs[i].Parts = parts
+	}
+	sortMessages(msgs)
+	// Redacted parts carry no asset, so the bundle leaves the assets only they used out.
+	redactMessages(ctx, msgs, nil)
+
+	bundled, assets := bundle.FromMessages(msgs)
+	return &SessionBundle{
+		SessionID: session.ID,
+		session: bundle.Session{
+			Configs:             session.Configs,
+			Metadata:            session.Metadata,
+			Tags:                session.Tags,
+			DisableTaskTracking: session.DisableTaskTracking,
+			Messages:            bundled,
+		},
+		assets: assets,
+		download: func(ctx context.Context, key string) ([]byte, error) {
+			return s.s3.DownloadFile(ctx, key, in.UserKEK)
+		},
+	}, nil
+}
+
+type ImportSessionBundleInput struct {
+	ProjectID uuid.UUID
+	Bundle    io.ReaderAt
+	Size      int64
+	UserKEK   []byte
+}
+
+// ImportSessionBundle restores a bundle written by ExportSessionBundle as a new session with the
+// bundled settings, messages and parent links. Every file is checked against the manifest, which
+// may not list files larger than Upload.MaxSizeBytes or more than Upload.MaxBundleBytes in all.
+// Assets are restored one at a time; content the project already stores is linked instead of
+// uploaded again. Message parts objects are uploaded before the rows are written.
+func (s *sessionService) ImportSessionBundle(ctx context.Context, in ImportSessionBundleInput) (*ImportSessionOutput, error) {
+	br, err := bundle.NewReader(in.Bundle, in.Size, bundle.Limits{MaxFileBytes: s.cfg.Upload.MaxSizeBytes, MaxTotalBytes: s.cfg.Upload.MaxBundleBytes})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	bs, err := br.Session()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if len(bs.Messages) == 0 {
+		return nil, fmt.Errorf("%w: bundle has no messages", ErrInvalidImport)
+	}
+	tags, err := NormalizeTags(bs.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	// Check the limits against the manifest sizes before any asset is read.
+	var assetBytes int64
+	sized := make(map[string]bool)
+	for _, bm := range bs.Messages {
+		for _, bp := range bm.Parts {
+			if bp.AssetPath == "" || sized[bp.AssetPath] {
+				continue
+			}
+			sized[bp.AssetPath] = true
+			size := br.AssetSize(bp.AssetPath)
+			if limit := s.cfg.Quota.MaxAssetBytes; limit > 0 && size > limit {
+				return nil, &QuotaExceededError{Scope: QuotaScopeAsset, Requested: size, Limit: limit}
+			}
+			assetBytes += size
+		}
+	}
+	if err := s.quotaErr(0, assetBytes); err != nil {
+		return nil, err
+	}
+
+	projectKey := in.ProjectID.String()
+	restored := make(map[string]model.Asset)
+	var refs []model.Asset
+	var pendingUploads []*blob.PreparedUpload
+	msgs := make([]model.Message, 0, len(bs.Messages))
+	partsByMsg := make([][]model.Part, 0, len(bs.Messages))
+	var totalBytes int64
+
+	for i, bm := range bs.Messages {
+		parts := make([]model.Part, 0, len(bm.Parts))
+		for _, bp := range bm.Parts {
+			part := model.Part{Type: bp.Type, Text: bp.Text, Filename: bp.Filename, Meta: bp.Meta}
+			if bp.AssetPath != "" {
+				asset, ok := restored[bp.AssetPath]
+				if !ok {
+					asset, err = s.restoreBundleAsset(ctx, in, br, bp)
+					if err != nil {
+						return nil, err
+					}
+					restored[bp.AssetPath] = asset
+				}
+				part.Asset = &asset
+				refs = append(refs, asset)
+			}
+			parts = append(parts, part)
+		}
+		if err := model.ValidateParts(parts); err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+
+		partsPrepared, err := s.preparePartsAsset("parts/"+projectKey, parts)
+		if err != nil {
+			return nil, fmt.Errorf("prepare parts asset failed: %w", err)
+		}
+		pendingUploads = append(pendingUploads, partsPrepared)
+		refs = append(refs, partsPrepared.Asset)
+
+		meta := bm.Meta
+		if meta == nil {
+			meta = make(map[string]interface{})
+		}
+		tokenCount, err := tokenizer.CountPartsTokens(parts, tokenizer.DefaultEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("count tokens: %w", err)
+		}
+		// Bundled IDs are temporary: CreateSessionWithMessages assigns the stored ones.
+		msg := model.Message{
+			ID:             bm.ID,
+			ParentID:       bm.ParentID,
+			Role:           bm.Role,
+			Meta:           datatypes.NewJSONType(meta),
+			PartsAssetMeta: datatypes.NewJSONType(partsPrepared.Asset),
+			Parts:          parts,
+			StorageBytes:   messageStorageBytes(partsPrepared.Asset, parts),
+			TokenCount:     tokenCount,
+			TokenEncoding:  tokenizer.DefaultEncoding,
+			Pinned:         bm.Pinned,
+			CreatedAt:      bm.CreatedAt,
+		}
+		if in.UserKEK == nil {
+			msg.SearchText = searchTextFromParts(parts)
+		}
+		totalBytes += msg.StorageBytes
+		msgs = append(msgs, msg)
+		partsByMsg = append(partsByMsg, parts)
+	}
+	if err := s.quotaErr(0, totalBytes); err != nil {
+		return nil, err
+	}
+
+	for _, p := range pendingUploads {
+		if err := s.s3.UploadPrepared(ctx, p, in.UserKEK); err != nil {
+			return nil, fmt.Errorf("upload %s failed: %w", p.Asset.S3Key, err)
+		}
+	}
+
+	session := model.Session{
+		ProjectID:           in.ProjectID,
+		Configs:             bs.Configs,
+		Metadata:            bs.Metadata,
+		Tags:                tags,
+		DisableTaskTracking: bs.DisableTaskTracking,
+	}
+	if session.Metadata == nil {
+		session.Metadata = datatypes.JSONMap{}
+	}
+	if err := s.sessionRepo.CreateSessionWithMessages(ctx, &session, msgs); err != nil {
+		var batchErr *repo.BatchMessageError
+		if errors.As(err, &batchErr) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImport, batchErr.Error())
+		}
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	return s.finishImport(ctx, in.ProjectID, &session, msgs, partsByMsg, refs, in.UserKEK), nil
+}
+
+// restoreBundleAsset stores the asset a bundled part names and returns it. When the project
+// already stores the same content, the stored asset is linked instead.
+func (s *sessionService) restoreBundleAsset(ctx context.Context, in ImportSessionBundleInput, br *bundle.Reader, bp bundle.Part) (model.Asset, error) {
+	content, err := br.ReadAsset(bp.AssetPath)
+	if err != nil {
+		return model.Asset{}, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	prepared := s.s3.PrepareBytesAsset("assets/"+in.ProjectID.String(), path.Base(bp.AssetPath), content)
+	if stored := s.findStoredAsset(ctx, in.ProjectID, prepared.Asset.SHA256, in.UserKEK); stored != nil {
+		s.describeAsset(ctx, stored, content)
+		return *stored, nil
+	}
+	if bp.MIME != "" {
+		prepared.Asset.MIME = bp.MIME
+	}
+	s.describeAsset(ctx, &prepared.Asset, content)
+	if err := s.s3.UploadPrepared(ctx, prepared, in.UserKEK); err != nil {
+		return model.Asset{}, fmt.Errorf("upload %s failed: %w", prepared.Asset.S3Key, err)
+	}
+	return prepared.Asset, nil
+}
+
+// describeAsset extracts the intrinsic metadata of a media asset of up to AssetMeta.SyncMaxBytes
+// into its Meta; larger assets are described by the enrichment worker. A failed extraction
+// leaves Meta nil rather than failing the upload.
+func (s *sessionService) describeAsset(ctx context.Context, asset *model.Asset, content []byte) {
+	if s.mediaMeta == nil || asset.Meta != nil || asset.SizeB > s.cfg.AssetMeta.SyncMaxBytes || !s.mediaMeta.Accepts(asset.MIME) {
+		return
+	}
+	meta, err := s.mediaMeta.Extract(ctx, asset.MIME, content)
+	if err != nil {
+		s.log.Debug("asset metadata extraction failed",
+			zap.String("sha256", asset.SHA256), zap.String("mime", asset.MIME), zap.Error(err))
+		return
+	}
+	asset.Meta = meta
+}
+
+// findStoredAsset returns the project's already stored asset with the given content hash, or nil
+// when there is none or the upload is encrypted (encrypted objects are never shared).
+// Lookup failures are logged and treated as a miss so the upload proceeds.
+func (s *sessionService) findStoredAsset(ctx context.Context, projectID uuid.UUID, sha256 string, userKEK []byte) *model.Asset {
+	if userKEK != nil || s.assetReferenceRepo == nil {
+		return nil
+	}
+	stored, err := s.assetReferenceRepo.FindAssetByHash(ctx, projectID, sha256)
+	if err != nil {
+		s.log.Warn("asset hash lookup failed", zap.String("sha256", sha256), zap.Error(err))
+		return nil
+	}
+	return stored
+}
+
+// prepareInlineAsset moves base64 data carried in the meta of an image or file part into
+// a prepared asset, leaving the media type in meta. It returns nil for any other part.
+func (s *sessionService) prepareInlineAsset(projectKey string, part *model.Part, name string) (*blob.PreparedUpload, error) {
+	if part.Type != model.PartTypeImage && part.Type != model.PartTypeFile {
+		return nil, nil
+	}
+	if part.GetMetaString(model.MetaKeySourceType) != "base64" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(part.GetMetaString(model.MetaKeyData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64 data: %v", ErrInvalidImport, err)
+	}
+
+	mediaType := part.GetMetaString(model.MetaKeyMediaType)
+	if mediaType != "" {
+		canonical, err := mime.Normalize(mediaType)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidMIME, mediaType)
+		}
+		mediaType = canonical
+	}
+	if _, subtype, ok := strings.Cut(mediaType, "/"); ok && subtype != "" {
+		name += "." + subtype
+	}
+	prepared := s.s3.PrepareBytesAsset("assets/"+projectKey, name, data)
+	if mediaType != "" {
+		_, assetMIME, err := resolveDeclaredMIME(mediaType, prepared.Asset.MIME)
+		if err != nil {
+			return nil, err
+		}
+		prepared.Asset.MIME = assetMIME
+	}
+
+	meta := make(map[string]interface{}, len(part.Meta))
+	for k, v := range part.Meta {
+		if k == model.MetaKeyData || k == model.MetaKeySourceType {
+			continue
+		}
+		meta[k] = v
+	}
+	if mediaType != "" {
+		meta[model.MetaKeyMediaType] = mediaType
+	}
+	part.Meta = meta
+	part.Asset = &prepared.Asset
+	part.Filename = name
+	return prepared, nil
+}
+
+// DownloadAsset downloads and decrypts an asset from S3 by its key.
+func (s *sessionService) DownloadAsset(ctx context.Context, s3Key string, userKEK []byte) ([]byte, error) {
+	if s.s3 == nil {
+		return nil, errors.New("S3 not configured")
+	}
+	return s.s3.DownloadFile(ctx, s3Key, userKEK)
+}
+
+// cachePartsInRedis stores message parts in Redis with a fixed TTL.
+// When userKEK is provided, the serialized JSON is encrypted before caching.
+// Format: prefix_byte | payload
+//   - 0x00 | json_data (plaintext)
+//   - 0x01 | wrappedDEK_len (2 bytes BE) | wrappedDEK | ciphertext (encrypted)
+func (s *sessionService) cachePartsInRedis(ctx context.Context, projectID string, sha256 string, parts []model.Part, userKEK []byte) error {
+	if s.redis == nil {
+		return errors.New("redis client is not available")
+	}
+
+	// Serialize parts to JSON
+	jsonData, err := sonic.Marshal(parts)
+	if err != nil {
+		return fmt.Errorf("marshal parts to JSON: %w", err)
+	}
+
+	var cacheData []byte
+	if userKEK != nil {
+		// Encrypt the JSON data
+		ciphertext, encMeta, err := crypto.EncryptData(userKEK, jsonData)
+		if err != nil {
+			return fmt.Errorf("encrypt parts for cache: %w", err)
+		}
+		wrappedDEK := []byte(encMeta.UserWrappedDEK)
+		// Frame: 0x01 | wrappedDEK_len (2 bytes BE) | wrappedDEK | ciphertext
+		cacheData = make([]byte, 1+2+len(wrappedDEK)+len(ciphertext))
+		cacheData[0] = cachePrefixEncrypted
+		binary.BigEndian.PutUint16(cacheData[1:3], uint16(len(wrappedDEK)))
+		copy(cacheData[3:3+len(wrappedDEK)], wrappedDEK)
+		copy(cacheData[3+len(wrappedDEK):], ciphertext)
+	} else {
+		// Frame: 0x00 | json_data
+		cacheData = make([]byte, 1+len(jsonData))
+		cacheData[0] = cachePrefixPlaintext
+		copy(cacheData[1:], jsonData)
+	}
+
+	// Use project ID + SHA256 as Redis key for project-scoped content-based caching
+	redisKey := redisKeyPrefixParts + projectID + ":" + sha256
+
+	// Store in Redis with fixed TTL
+	if err := s.redis.Set(ctx, redisKey, cacheData, defaultPartsCacheTTL).Err(); err != nil {
+		return fmt.Errorf("set Redis key %s: %w", redisKey, err)
+	}
+
+	return nil
+}
+
+// getPartsFromRedis retrieves message parts from Redis cache.
+// When userKEK is provided and the cached data is encrypted, it decrypts before unmarshalling.
+// Returns (nil, redis.Nil) on cache miss, which is a normal condition.
+func (s *sessionService) getPartsFromRedis(ctx context.Context, projectID string, sha256 string, userKEK []byte) ([]model.Part, error) {
+	if s.redis == nil {
+		return nil, errors.New("redis client is not available")
+	}
+
+	redisKey := redisKeyPrefixParts + projectID + ":" + sha256
+
+	// Get from Redis
+	val, err := s.redis.Get(ctx, redisKey).Bytes()
+	if err != nil {
+		// redis.Nil means key doesn't exist (cache miss), which is normal
+		if err == redis.Nil {
+			return nil, redis.Nil
+		}
+		// Other errors are actual Redis errors
+		return nil, fmt.Errorf("get Redis key %s: %w", redisKey, err)
+	}
+
+	if len(val) == 0 {
+		return nil, fmt.Errorf("empty cached data for key %s", redisKey)
+	}
+
+	var jsonData []byte
+	switch val[0] {
+	case cachePrefixPlaintext:
+		// Plaintext: strip prefix byte
+		jsonData = val[1:]
+	case cachePrefixEncrypted:
+		// Encrypted: 0x01 | wrappedDEK_len (2 bytes BE) | wrappedDEK | ciphertext
+		if userKEK == nil {
+			return nil, fmt.Errorf("encrypted cache entry but no user KEK provided")
+		}
+		if len(val) < 3 {
+			return nil, fmt.Errorf("malformed encrypted cache entry: too short")
+		}
+		wrappedDEKLen := int(binary.BigEndian.Uint16(val[1:3]))
+		if len(val) < 3+wrappedDEKLen {
+			return nil, fmt.Errorf("malformed encrypted cache entry: wrappedDEK length exceeds data")
+		}
+		wrappedDEK := string(val[3 : 3+wrappedDEKLen])
+		ciphertext := val[3+wrappedDEKLen:]
+		encMeta := &crypto.EncryptedMeta{
+			Algo:           "AES-256-GCM",
+			UserWrappedDEK: wrappedDEK,
+		}
+		jsonData, err = crypto.DecryptData(userKEK, ciphertext, encMeta)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt cached parts: %w", err)
+		}
+	default:
+		// Legacy cache entry without prefix byte — treat as raw plaintext JSON
+		jsonData = val
+	}
+
+	// Deserialize JSON to parts
+	var parts []model.Part
+	if err := sonic.Unmarshal(jsonData, &parts); err != nil {
+		return nil, fmt.Errorf("unmarshal parts from JSON: %w", err)
+	}
+
+	return parts, nil
+}
+
+// loadPartsForMessage loads parts from cache/S3. Returns (parts, ok) where
+// ok=false means a load failure (the message should be skipped), and ok=true
+// with an empty slice means the message legitimately has no content parts.
+// userKEK is the optional user key-encryption-key for decrypting envelope-encrypted parts.
+func (s *sessionService) loadPartsForMessage(ctx context.Context, projectID string, meta model.Asset, userKEK []byte) ([]model.Part, bool) {
+	parts := []model.Part{}
+	cacheHit := false
+
+	// Streaming messages have no parts asset until they are finalized
+	if meta.S3Key == "" {
+		return parts, true
+	}
+
+	// Try to get parts from Redis cache first, fallback to S3 if not found
+	if s.redis != nil {
+		if cachedParts, err := s.getPartsFromRedis(ctx, projectID, meta.SHA256, userKEK); err == nil {
+			parts = cachedParts
+			cacheHit = true
+		} else if err != redis.Nil {
+			// Log actual Redis errors (not cache misses)
+			s.log.Warn("failed to get parts from Redis", zap.String("sha256", meta.SHA256), zap.Error(err))
+		}
+	}
+
+	// If cache miss, download from S3
+	if !cacheHit && s.s3 != nil {
+		if err := s.s3.DownloadJSON(ctx, meta.S3Key, &parts, userKEK); err != nil {
+			s.log.Warn("failed to download parts from S3", zap.String("sha256", meta.SHA256), zap.Error(err))
+			return nil, false
+		}
+		if err := model.DecompressParts(parts); err != nil {
+			s.log.Warn("failed to decompress parts", zap.String("sha256", meta.SHA256), zap.Error(err))
+			return nil, false
+		}
+		// Cache the parts in Redis after successful S3 download
+		if s.redis != nil {
+			if err := s.cachePartsInRedis(ctx, projectID, meta.SHA256, parts, userKEK); err != nil {
+				// Log error but don't fail the request if Redis caching fails
+				s.log.Warn("failed to cache parts in Redis", zap.String("sha256", meta.SHA256), zap.Error(err))
+			}
+		}
+	}
+
+	return parts, true
+}
+
+// GetAllMessages retrieves all messages for a session and loads their parts
+func (s *sessionService) GetAllMessages(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) ([]model.Message, error) {
+	// Get all messages from repository
+	msgs, err := s.sessionRepo.ListAllMessagesBySession(ctx, sessionID, nil, nil, repo.TimeRange{}, repo.AuthorFilter{}, repo.AnnotationFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	// Load parts for each message, filtering out those with failed loads
+	n := 0
+	for i, m := range msgs {
+		meta := m.PartsAssetMeta.Data()
+		parts, ok := s.loadPartsForMessage(ctx, projectID.String(), meta, userKEK)
+		if !ok {
+			continue
+		}
+		msgs[i].Parts = parts
+		msgs[n] = msgs[i]
+		n++
+	}
+	msgs = msgs[:n]
+
+	// Sort messages from old to new (ascending by seq)
+	sortMessages(msgs)
+	redactMessages(ctx, msgs, nil)
+
+	return msgs, nil
+}
+
+// sortMessages orders messages by Seq, falling back to (CreatedAt, ID) for messages with equal
+// seqs, which only happens for rows not yet backfilled.
+func sortMessages(msgs []model.Message) {
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].Seq != msgs[j].Seq {
+			return msgs[i].Seq < msgs[j].Seq
+		}
+		if !msgs[i].CreatedAt.Equal(msgs[j].CreatedAt) {
+			return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
+		}
+		return msgs[i].ID.String() < msgs[j].ID.String()
+	})
+}
+
+type GetMessageThreadInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	UserKEK   []byte
+}
+
+// ThreadMessage is a message on a root-to-leaf path together with its depth (root = 0).
+type ThreadMessage struct {
+	model.Message
+	Depth int `json:"depth"`
+}
+
+// GetMessageThread returns the path of messages from the root down to in.MessageID,
+// with parts loaded. Messages whose parts fail to load are kept with empty parts, and
+// soft-deleted ancestors are omitted without shifting the depth of the others.
+func (s *sessionService) GetMessageThread(ctx context.Context, in GetMessageThreadInput) ([]ThreadMessage, error) {
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: in.SessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.ProjectID != in.ProjectID {
+		return nil, ErrSessionNotFound
+	}
+
+	chain, err := s.sessionRepo.GetMessageThread(ctx, in.SessionID, in.MessageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		if errors.Is(err, repo.ErrMessageCycle) {
+			return nil, ErrMessageCycle
+		}
+		return nil, fmt.Errorf("failed to get message thread: %w", err)
+	}
+	if chain[len(chain)-1].DeletedAt.Valid {
+		return nil, ErrMessageNotFound
+	}
+
+	out := make([]ThreadMessage, 0, len(chain))
+	for depth, m := range chain {
+		// Soft-deleted ancestors stay in the walk so depths keep their tree position.
+		if m.DeletedAt.Valid {
+			continue
+		}
+		if parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), m.PartsAssetMeta.Data(), in.UserKEK); ok {
+			m.Parts = parts
+		} else {
+			m.Parts = []model.Part{}
+		}
+		out = append(out, ThreadMessage{Message: m, Depth: depth})
+	}
+	redactThread(ctx, out)
+	return out, nil
+}
+
+type DiffBranchesInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	LeafA     uuid.UUID
+	LeafB     uuid.UUID
+	UserKEK   []byte
+}
+
+type DiffBranchesOutput struct {
+	// ForkPoint is the deepest live message both branches share; nil when they share none.
+	ForkPoint *ThreadMessage `json:"fork_point"`
+	// TailA and TailB are the messages below the fork, in order, down to each leaf. A tail is
+	// empty when its leaf is an ancestor of the other one.
+	TailA []ThreadMessage `json:"tail_a"`
+	TailB []ThreadMessage `json:"tail_b"`
+}
+
+// DiffBranches compares the branches ending at in.LeafA and in.LeafB: it finds where their
+// root-to-leaf paths diverge and returns the two diverging tails, with parts loaded. A leaf
+// outside the session, or soft-deleted, returns ErrMessageNotInSession. Soft-deleted messages
+// on either path are omitted.
+func (s *sessionService) DiffBranches(ctx context.Context, in DiffBranchesInput) (*DiffBranchesOutput, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+
+	thread := func(leafID uuid.UUID) ([]model.Message, error) {
+		chain, err := s.sessionRepo.GetMessageThread(ctx, in.SessionID, leafID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrMessageNotInSession, leafID)
+			}
+			if errors.Is(err, repo.ErrMessageCycle) {
+				return nil, ErrMessageCycle
+			}
+			return nil, fmt.Errorf("failed to get message thread: %w", err)
+		}
+		if chain[len(chain)-1].DeletedAt.Valid {
+			return nil, fmt.Errorf("%w: %s", ErrMessageNotInSession, leafID)
+		}
+		return chain, nil
+	}
+	chainA, err := thread(in.LeafA)
+	if err != nil {
+		return nil, err
+	}
+	chainB, err := thread(in.LeafB)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := sharedPrefixLen(chainA, chainB)
+	load := func(chain []model.Message, from int) []ThreadMessage {
+		out := make([]ThreadMessage, 0, len(chain)-from)
+		for depth := from; depth < len(chain); depth++ {
+			m := chain[depth]
+			if m.DeletedAt.Valid {
+				continue
+			}
+			if parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), m.PartsAssetMeta.Data(), in.UserKEK); ok {
+				m.Parts = parts
+			} else {
+				m.Parts = []model.Part{}
+			}
+			out = append(out, ThreadMessage{Message: m, Depth: depth})
+		}
+		redactThread(ctx, out)
+		return out
+	}
+
+	out := &DiffBranchesOutput{TailA: load(chainA, shared), TailB: load(chainB, shared)}
+	for depth := shared - 1; depth >= 0; depth-- {
+		if !chainA[depth].DeletedAt.Valid {
+			out.ForkPoint = &load(chainA[:depth+1], depth)[0]
+			break
+		}
+	}
+	return out, nil
+}
+
+// sharedPrefixLen returns how many messages two root-first chains have in common from the root.
+func sharedPrefixLen(a, b []model.Message) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n].ID == b[n].ID {
+		n++
+	}
+	return n
+}
+
+type ReparentMessageInput struct {
+	ProjectID   uuid.UUID
+	SessionID   uuid.UUID
+	MessageID   uuid.UUID
+	NewParentID *uuid.UUID // nil makes the message a root
+	// Override lets the change through a finalized session or past the immutability window.
+	Override *ImmutabilityOverride
+}
+
+type ReparentMessageOutput struct {
+	MessageID uuid.UUID  `json:"message_id"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	Depth     int        `json:"depth"`
+}
+
+// ReparentMessage moves a message, together with its subtree, under a new parent in the same
+// session and reports its new depth.
+func (s *sessionService) ReparentMessage(ctx context.Context, in ReparentMessageInput) (*ReparentMessageOutput, error) {
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if in.NewParentID != nil && *in.NewParentID == in.MessageID {
+		return nil, ErrReparentCycle
+	}
+	createdAt, err := s.messageAge(ctx, session, in.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkMutable(ctx, session, &in.MessageID, model.OverrideReparent, in.Override, createdAt...); err != nil {
+		return nil, err
+	}
+
+	depth, err := s.sessionRepo.ReparentMessage(ctx, in.SessionID, in.MessageID, in.NewParentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrMessageNotFound
+		case errors.Is(err, repo.ErrMessageNotInSession):
+			return nil, ErrMessageNotInSession
+		case errors.Is(err, repo.ErrReparentCycle):
+			return nil, ErrReparentCycle
+		case errors.Is(err, repo.ErrMessageCycle):
+			return nil, ErrMessageCycle
+		case errors.Is(err, repo.ErrMessageShared):
+			return nil, ErrMessageShared
+		}
+		return nil, fmt.Errorf("reparent message: %w", err)
+	}
+	return &ReparentMessageOutput{MessageID: in.MessageID, ParentID: in.NewParentID, Depth: depth}, nil
+}
+
+type ValidateToolPairingInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	UserKEK   []byte
+}
+
+// ValidateToolPairing reports the session's tool-call parts that no downstream tool-result
+// answers and the tool-result parts that answer no upstream call. An empty result means every
+// call is paired.
+func (s *sessionService) ValidateToolPairing(ctx context.Context, in ValidateToolPairingInput) ([]editor.ToolPairingIssue, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	msgs, err := s.GetAllMessages(ctx, in.ProjectID, in.SessionID, in.UserKEK)
+	if err != nil {
+		return nil, err
+	}
+	issues := editor.ValidateToolPairing(msgs)
+	if issues == nil {
+		issues = []editor.ToolPairingIssue{}
+	}
+	return issues, nil
+}
+
+type ValidateTreeInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+}
+
+// ValidateTree reports the session's live messages whose parent is missing or belongs to another
+// session. An empty result means the tree is intact.
+func (s *sessionService) ValidateTree(ctx context.Context, in ValidateTreeInput) ([]repo.BrokenParentLink, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	links, err := s.sessionRepo.ValidateTree(ctx, in.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("validate tree: %w", err)
+	}
+	return links, nil
+}
+
+type RepairTreeInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	Mode      repo.TreeRepairMode
+	// Override lets the change through a finalized session or past the immutability window.
+	Override *ImmutabilityOverride
+}
+
+// RepairTree reattaches or deletes the messages ValidateTree reports, as Mode says. Under an
+// immutability window the orphans are checked by their age; the descendants a delete repair
+// removes with them are taken to be younger.
+func (s *sessionService) RepairTree(ctx context.Context, in RepairTreeInput) (*repo.RepairTreeResult, error) {
+	if in.Mode != repo.TreeRepairReattach && in.Mode != repo.TreeRepairDelete {
+		return nil, ErrInvalidTreeRepairMode
+	}
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	var createdAt []time.Time
+	if s.needsMessageAge(session) {
+		links, err := s.sessionRepo.ValidateTree(ctx, in.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("validate tree: %w", err)
+		}
+		for _, l := range links {
+			createdAt = append(createdAt, l.CreatedAt)
+		}
+	}
+	if err := s.checkMutable(ctx, session, nil, model.OverrideRepairTree, in.Override, createdAt...); err != nil {
+		return nil, err
+	}
+	result, err := s.sessionRepo.RepairTree(ctx, in.SessionID, in.Mode)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrSessionNotFound
+		case errors.Is(err, repo.ErrMessageShared):
+			return nil, ErrMessageShared
+		}
+		return nil, fmt.Errorf("repair tree: %w", err)
+	}
+	return result, nil
+}
+
+type SearchMessagesInput struct {
+	ProjectID uuid.UUID
+	SessionID *uuid.UUID // optional: restrict the search to one session
+	Query     string
+	Sort      repo.MessageSearchSort // empty sorts by relevance
+	Limit     int
+}
+
+type MessageSearchResult struct {
+	MessageID uuid.UUID `json:"message_id"`
+	SessionID uuid.UUID `json:"session_id"`
+	Role      string    `json:"role"`
+	Snippet   string    `json:"snippet"`
+	Rank      float64   `json:"rank"`
+	Score     float64   `json:"score"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SearchMessages performs full-text search over message text parts in the project. The blended
+// sort decays each hit's rank with the configured search half-life.
+func (s *sessionService) SearchMessages(ctx context.Context, in SearchMessagesInput) ([]MessageSearchResult, error) {
+	if in.SessionID != nil {
+		if err := s.checkSessionProject(ctx, in.ProjectID, *in.SessionID); err != nil {
+			return nil, err
+		}
+	}
+
+	order := repo.MessageSearchOrder{Sort: in.Sort, HalfLife: time.Duration(s.cfg.Search.RecencyHalfLifeHours * float64(time.Hour))}
+	if order.Sort == "" {
+		order.Sort = repo.MessageSearchSortRelevance
+	}
+	hits, err := s.sessionRepo.SearchMessages(ctx, in.ProjectID, in.SessionID, in.Query, order, in.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+
+	out := make([]MessageSearchResult, 0, len(hits))
+	for _, h := range hits {
+		out = append(out, messageSearchResult(h))
+	}
+	return out, nil
+}
+
+func messageSearchResult(h repo.MessageSearchHit) MessageSearchResult {
+	return MessageSearchResult{
+		MessageID: h.ID,
+		SessionID: h.SessionID,
+		Role:      h.Role,
+		Snippet:   h.Snippet,
+		Rank:      h.Rank,
+		Score:     h.Score,
+		CreatedAt: h.CreatedAt,
+	}
+}
+
+type SearchHistoryInput struct {
+	ProjectID      uuid.UUID
+	User           string // optional: only sessions owned by this user identifier
+	Query          string
+	Limit          int // sessions per page
+	HitsPerSession int
+	Cursor         string
+}
+
+type SessionSearchResult struct {
+	SessionID     uuid.UUID             `json:"session_id"`
+	Title         string                `json:"title"`
+	MatchCount    int                   `json:"match_count"`
+	LatestMatchAt time.Time             `json:"latest_match_at"`
+	Hits          []MessageSearchResult `json:"hits"`
+}
+
+type SearchHistoryOutput struct {
+	Items      []SessionSearchResult `json:"items"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+	HasMore    bool                  `json:"has_more"`
+}
+
+// SearchHistory runs a full-text search across every session of the project, or only those of
+// in.User, and groups the matches by session. Sessions with the most recent matches come first;
+// each lists its best hits by rank. A session's title is its metadata title, "" when unset.
+func (s *sessionService) SearchHistory(ctx context.Context, in SearchHistoryInput) (*SearchHistoryOutput, error) {
+	var afterT time.Time
+	var afterID uuid.UUID
+	if in.Cursor != "" {
+		var err error
+		if afterT, afterID, err = paging.DecodeCursor(in.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := s.sessionRepo.SearchMessagesBySession(ctx, in.ProjectID, in.User, in.Query, afterT, afterID, in.Limit+1, in.HitsPerSession)
+	if err != nil {
+		return nil, fmt.Errorf("search history: %w", err)
+	}
+
+	out := &SearchHistoryOutput{Items: make([]SessionSearchResult, 0, len(groups))}
+	if len(groups) > in.Limit {
+		out.HasMore = true
+		groups = groups[:in.Limit]
+		last := groups[len(groups)-1]
+		out.NextCursor = paging.EncodeCursor(last.LatestMatchAt, last.SessionID)
+	}
+	for _, g := range groups {
+		hits := make([]MessageSearchResult, 0, len(g.Hits))
+		for _, h := range g.Hits {
+			hits = append(hits, messageSearchResult(h))
+		}
+		out.Items = append(out.Items, SessionSearchResult{
+			SessionID:     g.SessionID,
+			Title:         g.Title,
+			MatchCount:    g.MatchCount,
+			LatestMatchAt: g.LatestMatchAt,
+			Hits:          hits,
+		})
+	}
+	return out, nil
+}
+
+// searchTextFromParts joins the text of text parts for the full-text index.
+// Parts without text (tool calls, media, ...) contribute nothing, and sensitive parts are left
+// out so search snippets cannot reveal what reads redact.
+func searchTextFromParts(parts []model.Part) string {
+	visible := make([]model.Part, 0, len(parts))
+	for _, p := range parts {
+		if !p.IsSensitive() {
+			visible = append(visible, p)
+		}
+	}
+	return (&model.Message{Parts: visible}).ConcatText()
+}
+
+type GetSessionTokensInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	Encoding  string // optional: defaults to tokenizer.DefaultEncoding
+	UserKEK   []byte
+}
+
+type SessionTokensOutput struct {
+	Encoding string         `json:"encoding"`
+	Total    int            `json:"total"`
+	ByRole   map[string]int `json:"by_role"`
+}
+
+// GetSessionTokens returns the session's token total and per-role breakdown.
+// Stored counts are used when they were all computed with the requested encoding;
+// otherwise every message is recounted, and messages that had no count yet are
+// backfilled with the new one.
+func (s *sessionService) GetSessionTokens(ctx context.Context, in GetSessionTokensInput) (*SessionTokensOutput, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	encoding := in.Encoding
+	if encoding == "" {
+		encoding = tokenizer.DefaultEncoding
+	}
+	if err := tokenizer.ValidateEncoding(encoding); err != nil {
+		return nil, err
+	}
+
+	totals, err := s.sessionRepo.SessionTokenTotal(ctx, in.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("sum session tokens: %w", err)
+	}
+	if len(totals.Encodings) == 0 || (len(totals.Encodings) == 1 && totals.Encodings[0] == encoding) {
+		return &SessionTokensOutput{Encoding: encoding, Total: totals.Total, ByRole: totals.ByRole}, nil
+	}
+
+	msgs, err := s.GetAllMessages(ctx, in.ProjectID, in.SessionID, in.UserKEK)
+	if err != nil {
+		return nil, err
+	}
+	out := &SessionTokensOutput{Encoding: encoding, ByRole: map[string]int{}}
+	for _, m := range msgs {
+		count, err := tokenizer.CountPartsTokens(m.Parts, encoding)
+		if err != nil {
+			return nil, fmt.Errorf("count tokens for message %s: %w", m.ID, err)
+		}
+		out.Total += count
+		out.ByRole[m.Role] += count
+		if m.TokenEncoding == "" {
+			if err := s.sessionRepo.UpdateMessageTokenCount(ctx, m.ID, count, encoding); err != nil {
+				s.log.Warn("backfill message token count", zap.String("message_id", m.ID.String()), zap.Error(err))
+			}
+		}
+	}
+	return out, nil
+}
+
+// MaxSystemPromptLen is the longest default or per-session system prompt accepted, in bytes.
+const MaxSystemPromptLen = 32 * 1024
+
+// projectConfigDefaultSystemPrompt is the project_config key holding the default system prompt
+const projectConfigDefaultSystemPrompt = "default_system_prompt"
+
+type BuildContextInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MaxTokens int
+	Strategy  string // one of the editor.ContextStrategy* names
+	// UseSummary replaces the messages covered by the session summary with the summary itself,
+	// prepended as a system message.
+	UseSummary bool
+	// IncludeFlagged keeps messages flagged by moderation, which are left out by default.
+	IncludeFlagged bool
+	// Project, when set, supplies the default system prompt from its project_config.
+	Project *model.Project
+	// NoSystemPrompt turns off the injection of the session or project system prompt.
+	NoSystemPrompt bool
+	// PersistSystemPrompt stores an injected system prompt as the session's next message
+	// instead of a synthetic one, so later calls find it in the session.
+	PersistSystemPrompt bool
+	UserKEK             []byte
+}
+
+// BuildContext selects the session messages to include in a prompt within a token budget. When
+// the session has no system message of its own, its system prompt, or else the project's
+// default, is put first.
+func (s *sessionService) BuildContext(ctx context.Context, in BuildContextInput) (*editor.ContextSelection, error) {
+	if err := editor.ValidateContextStrategy(in.Strategy); err != nil {
+		return nil, err
+	}
+	if in.MaxTokens <= 0 {
+		return nil, fmt.Errorf("max_tokens must be > 0, got %d", in.MaxTokens)
+	}
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := s.GetAllMessages(ctx, in.ProjectID, in.SessionID, in.UserKEK)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := s.contextSystemPrompt(ctx, in, session, msgs)
+	if err != nil {
+		return nil, err
+	}
+	keep := func(m model.Message) bool { return in.IncludeFlagged || !m.Flagged }
+	if !in.UseSummary || session.SummarizedUpToMessageID == nil {
+		return editor.SelectContextWithSystemPrompt(filterMessages(msgs, keep), prompt, "", in.MaxTokens, in.Strategy)
+	}
+	covered := indexOfMessage(msgs, *session.SummarizedUpToMessageID)
+	if covered < 0 {
+		// The summary ends at a message that no longer exists, so it cannot be placed.
+		return editor.SelectContextWithSystemPrompt(filterMessages(msgs, keep), prompt, "", in.MaxTokens, in.Strategy)
+	}
+	rest := filterMessages(msgs[covered+1:], keep)
+	if in.Strategy == editor.ContextStrategySystemPinned {
+		// Pinned messages stay in the prompt even when the summary covers them.
+		pinned := filterMessages(msgs[:covered+1], func(m model.Message) bool { return m.Pinned && keep(m) })
+		rest = append(pinned, rest...)
+	}
+	return editor.SelectContextWithSystemPrompt(rest, prompt, session.Summary, in.MaxTokens, in.Strategy)
+}
+
+// contextSystemPrompt returns the system message BuildContext puts first, or nil when injection
+// is off, the session has a system message of its own or no prompt applies. The session's
+// SystemPrompt wins over the project default. The message is synthetic unless
+// in.PersistSystemPrompt has it stored first.
+func (s *sessionService) contextSystemPrompt(ctx context.Context, in BuildContextInput, session *model.Session, msgs []model.Message) (*model.Message, error) {
+	if in.NoSystemPrompt || editor.HasSystemMessage(msgs) {
+		return nil, nil
+	}
+	text := defaultSystemPrompt(in.Project)
+	if session.SystemPrompt != nil {
+		text = *session.SystemPrompt
+	}
+	if text == "" {
+		return nil, nil
+	}
+	if !in.PersistSystemPrompt {
+		msg := editor.SystemPromptMessage(msgs, text)
+		return &msg, nil
+	}
+	stored, err := s.StoreMessage(ctx, StoreMessageInput{
+		ProjectID:   in.ProjectID,
+		SessionID:   in.SessionID,
+		Role:        model.RoleUser,
+		Parts:       []PartIn{{Type: model.PartTypeText, Text: text}},
+		Format:      model.FormatAcontext,
+		MessageMeta: map[string]interface{}{model.MsgMetaOriginalRole: "system"},
+		UserKEK:     in.UserKEK,
+		Project:     in.Project,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persist system prompt: %w", err)
+	}
+	return stored, nil
+}
+
+// defaultSystemPrompt returns the project's project_config.default_system_prompt, or "".
+func defaultSystemPrompt(project *model.Project) string {
+	if project == nil {
+		return ""
+	}
+	pc, _ := project.Configs["project_config"].(map[string]interface{})
+	text, _ := pc[projectConfigDefaultSystemPrompt].(string)
+	return text
+}
+
+// filterMessages returns the messages keep accepts, in order, in a new slice.
+func filterMessages(msgs []model.Message, keep func(model.Message) bool) []model.Message {
+	out := make([]model.Message, 0, len(msgs))
+	for _, m := range msgs {
+		if keep(m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+type SummarizeSessionInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	// KeepRecent is the number of newest messages left out of the summary; 0 folds in all of them.
+	KeepRecent int
+	UserKEK    []byte
+}
+
+type SummarizeSessionOutput struct {
+	Summary                 string     `json:"summary"`
+	SummarizedUpToMessageID *uuid.UUID `json:"summarized_up_to_message_id,omitempty"`
+	// FoldedMessages is the number of messages folded into the summary by this call.
+	FoldedMessages int `json:"folded_messages"`
+	// TokensBefore counts the previous summary plus the folded messages, TokensAfter the new
+	// summary; TokensSaved is the difference.
+	TokensBefore int `json:"tokens_before"`
+	TokensAfter  int `json:"tokens_after"`
+	TokensSaved  int `json:"tokens_saved"`
+}
+
+// SummarizeSession folds the session's older messages into its rolling summary. Only messages
+// after SummarizedUpToMessageID are summarized, on top of the existing summary; if that message
+// no longer exists the summary is rebuilt from the first message.
+func (s *sessionService) SummarizeSession(ctx context.Context, in SummarizeSessionInput) (*SummarizeSessionOutput, error) {
+	if s.summarizer == nil {
+		return nil, ErrSummarizerUnavailable
+	}
+	if in.UserKEK != nil {
+		return nil, ErrSummaryEncrypted
+	}
+	if in.KeepRecent < 0 {
+		return nil, fmt.Errorf("keep_recent must be >= 0, got %d", in.KeepRecent)
+	}
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := s.GetAllMessages(ctx, in.ProjectID, in.SessionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The summary is stored on the session for every key of the project to read, so it is built
+	// without the content of sensitive parts whatever the scopes of the caller.
+	for i := range msgs {
+		msgs[i] = model.RedactForPrincipal(msgs[i], nil)
+	}
+	previous, start := session.Summary, 0
+	if session.SummarizedUpToMessageID != nil {
+		if i := indexOfMessage(msgs, *session.SummarizedUpToMessageID); i >= 0 {
+			start = i + 1
+		} else {
+			previous = ""
+		}
+	}
+	out := &SummarizeSessionOutput{Summary: session.Summary, SummarizedUpToMessageID: session.SummarizedUpToMessageID}
+	end := len(msgs) - in.KeepRecent
+	if end <= start {
+		return out, nil
+	}
+	folded := msgs[start:end]
+
+	before, err := tokenizer.CountTokensWithEncoding(tokenizer.DefaultEncoding, previous)
+	if err != nil {
+		return nil, fmt.Errorf("count summary tokens: %w", err)
+	}
+	for _, m := range folded {
+		n, err := storedMessageTokens(m)
+		if err != nil {
+			return nil, fmt.Errorf("count tokens for message %s: %w", m.ID, err)
+		}
+		before += n
+	}
+
+	summary, err := s.summarizer.Summarize(ctx, previous, folded)
+	if err != nil {
+		return nil, fmt.Errorf("summarize session: %w", err)
+	}
+	after, err := tokenizer.CountTokensWithEncoding(tokenizer.DefaultEncoding, summary)
+	if err != nil {
+		return nil, fmt.Errorf("count summary tokens: %w", err)
+	}
+
+	marker := folded[len(folded)-1].ID
+	if err := s.sessionRepo.UpdateSummary(ctx, in.SessionID, session.SummarizedUpToMessageID, summary, marker); err != nil {
+		if errors.Is(err, repo.ErrSummaryChanged) {
+			return nil, ErrSummaryConflict
+		}
+		return nil, fmt.Errorf("store summary: %w", err)
+	}
+
+	out.Summary = summary
+	out.SummarizedUpToMessageID = &marker
+	out.FoldedMessages = len(folded)
+	out.TokensBefore = before
+	out.TokensAfter = after
+	out.TokensSaved = before - after
+	return out, nil
+}
+
+func indexOfMessage(msgs []model.Message, id uuid.UUID) int {
+	for i := range msgs {
+		if msgs[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// storedMessageTokens returns the message's stored token count, counting its parts with the
+// default encoding when it was stored without one.
+func storedMessageTokens(m model.Message) (int, error) {
+	if m.TokenEncoding != "" {
+		return m.TokenCount, nil
+	}
+	return tokenizer.CountPartsTokens(m.Parts, tokenizer.DefaultEncoding)
+}
+
+// GetSessionObservingStatus retrieves observing status for a specific session
+func (s *sessionService) GetSessionObservingStatus(
+	ctx context.Context,
+	sessionID string,
+) (*model.MessageObservingStatus, error) {
+
+	if sessionID == "" {
+		return nil, fmt.Errorf("session ID is required")
+	}
+
+	if ctx == nil {
+		return nil, fmt.Errorf("context cannot be nil")
+	}
+
+	status, err := s.sessionRepo.GetObservingStatus(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session observing status: %w", err)
+	}
+
+	if status == nil {
+		return nil, fmt.Errorf("repository returned nil status")
+	}
+
+	return status, nil
+}
+
+// PatchMessageMeta updates message metadata using patch semantics.
+// Only updates keys present in patchMeta. Use nil value to delete a key.
+// Returns the updated user meta (only user-provided metadata, not system fields).
+func (s *sessionService) PatchMessageMeta(
+	ctx context.Context,
+	projectID uuid.UUID,
+	sessionID uuid.UUID,
+	messageID uuid.UUID,
+	patchMeta map[string]interface{},
+	override *ImmutabilityOverride,
+) (map[string]interface{}, error) {
+	// Verify session exists and belongs to project
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: sessionID})
+	if err != nil {
+		return nil, fmt.Errorf("session not found")
+	}
+	if session.ProjectID != projectID {
+		return nil, fmt.Errorf("session not found")
+	}
+
+	// Get the message (also verifies it belongs to the session)
+	msg, err := s.sessionRepo.GetMessageByID(ctx, sessionID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("message not found")
+	}
+	if err := s.checkMutable(ctx, session, &msg.ID, model.OverridePatchMeta, override, msg.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	// Get existing meta
+	existingMeta := msg.Meta.Data()
+	if existingMeta == nil {
+		existingMeta = make(map[string]interface{})
+	}
+
+	// Extract existing user meta
+	var userMeta map[string]interface{}
+	if um, ok := existingMeta[model.UserMetaKey].(map[string]interface{}); ok {
+		userMeta = um
+	} else {
+		userMeta = make(map[string]interface{})
+	}
+
+	// Apply patch: merge new keys, delete keys with nil value
+	for k, v := range patchMeta {
+		if v == nil {
+			delete(userMeta, k) // null value = delete key
+		} else {
+			userMeta[k] = v // add or update key
+		}
+	}
+
+	// Update the __user_meta__ field
+	existingMeta[model.UserMetaKey] = userMeta
+
+	// Save to database
+	if err := s.sessionRepo.UpdateMessageMeta(ctx, messageID, datatypes.NewJSONType(existingMeta)); err != nil {
+		return nil, fmt.Errorf("failed to update message meta: %w", err)
+	}
+
+	return userMeta, nil
+}
+
+// DeleteMessage soft-deletes a message in the session.
+func (s *sessionService) DeleteMessage(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, override *ImmutabilityOverride) error {
+	session, err := s.getSessionInProject(ctx, projectID, sessionID)
+	if err != nil {
+		return err
+	}
+	createdAt, err := s.messageAge(ctx, session, messageID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkMutable(ctx, session, &messageID, model.OverrideDeleteMessage, override, createdAt...); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.DeleteMessage(ctx, sessionID, messageID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMessageNotFound
+		}
+		if errors.Is(err, repo.ErrMessageShared) {
+			return ErrMessageShared
+		}
+		return fmt.Errorf("delete message: %w", err)
+	}
+	return nil
+}
+
+type DeleteMessagesInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	// Roles, Since/Until and Flagged select the messages; a message must match all that are set,
+	// and at least one must be.
+	Roles   []string
+	Since   time.Time
+	Until   time.Time
+	Flagged *bool
+	// Cascade deletes the descendants of matching messages too, instead of reparenting them to
+	// the nearest surviving ancestor.
+	Cascade bool
+	// Override lets the change through a finalized session or past the immutability window.
+	Override *ImmutabilityOverride
+}
+
+// DeleteMessages soft-deletes the session's messages matching the filter of in, returning how many
+// were deleted and how many surviving children were reparented. Under an immutability window the
+// filter must set a Since within the window, as nothing else keeps older messages out of it.
+func (s *sessionService) DeleteMessages(ctx context.Context, in DeleteMessagesInput) (*repo.DeleteMessagesResult, error) {
+	filter := repo.MessageFilter{
+		Roles:     in.Roles,
+		CreatedIn: repo.TimeRange{Since: in.Since, Until: in.Until},
+		Flagged:   in.Flagged,
+	}
+	if filter.IsEmpty() {
+		return nil, ErrEmptyMessageFilter
+	}
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkMutable(ctx, session, nil, model.OverrideDeleteMessages, in.Override, in.Since); err != nil {
+		return nil, err
+	}
+	out, err := s.sessionRepo.DeleteMessages(ctx, in.SessionID, filter, in.Cascade)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		if errors.Is(err, repo.ErrMessageShared) {
+			return nil, ErrMessageShared
+		}
+		return nil, fmt.Errorf("delete messages: %w", err)
+	}
+	return out, nil
+}
+
+type DedupeConsecutiveInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	// DryRun reports the merges without applying them.
+	DryRun  bool
+	UserKEK []byte
+	// Override lets the change through a finalized session or past the immutability window.
+	Override *ImmutabilityOverride
+}
+
+// MessageMerge is one merge DedupeConsecutive applied or, in a dry run, would apply.
+type MessageMerge struct {
+	editor.DuplicateRun
+	// ReparentedIDs are the live children of the duplicates, which move under the survivor.
+	ReparentedIDs []uuid.UUID `json:"reparented_ids"`
+	// MergedAssets counts the duplicates' assets the survivor did not link yet.
+	MergedAssets int `json:"merged_assets"`
+}
+
+type DedupeConsecutiveOutput struct {
+	DryRun bool           `json:"dry_run"`
+	Merges []MessageMerge `json:"merges"`
+}
+
+// DedupeConsecutive collapses messages that repeat their parent, as found by
+// editor.FindConsecutiveDuplicates. The survivor gains the asset parts only the duplicates
+// link, through a parts edit that records a revision, and the repository then moves the
+// duplicates' children under it and soft-deletes them. Merges are applied one run at a time,
+// so a failure leaves the earlier runs merged.
+func (s *sessionService) DedupeConsecutive(ctx context.Context, in DedupeConsecutiveInput) (*DedupeConsecutiveOutput, error) {
+	session, err := s.getSessionInProject(ctx, in.ProjectID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := s.sessionRepo.ListAllMessagesBySession(ctx, in.SessionID, nil, nil, repo.TimeRange{}, repo.AuthorFilter{}, repo.AnnotationFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("list messages: %w", err)
+	}
+	byID := make(map[uuid.UUID]int, len(msgs))
+	for i := range msgs {
+		parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), msgs[i].PartsAssetMeta.Data(), in.UserKEK)
+		if !ok {
+			return nil, fmt.Errorf("failed to load parts of message %s", msgs[i].ID)
+		}
+		msgs[i].Parts = parts
+		byID[msgs[i].ID] = i
+	}
+
+	runs := editor.FindConsecutiveDuplicates(msgs)
+	if !in.DryRun && len(runs) > 0 {
+		// Every survivor and duplicate is checked before the first run is merged.
+		var createdAt []time.Time
+		for _, run := range runs {
+			createdAt = append(createdAt, msgs[byID[run.SurvivorID]].CreatedAt)
+			for _, id := range run.DuplicateIDs {
+				createdAt = append(createdAt, msgs[byID[id]].CreatedAt)
+			}
+		}
+		if err := s.checkMutable(ctx, session, nil, model.OverrideDedupe, in.Override, createdAt...); err != nil {
+			return nil, err
+		}
+	}
+
+	out := &DedupeConsecutiveOutput{DryRun: in.DryRun, Merges: []MessageMerge{}}
+	for _, run := range runs {
+		survivor := msgs[byID[run.SurvivorID]]
+		duplicate := make(map[uuid.UUID]bool, len(run.DuplicateIDs))
+		for _, id := range run.DuplicateIDs {
+			duplicate[id] = true
 		}
-		for _, m := range out.Items {
-			for _, p := range m.Parts {
-				if p.Asset == nil {
-					continue
-				}
-				url, expireAt, err := s.materialSvc.CreateMaterialURL(ctx, p.Asset.S3Key, userKEKB64, in.AssetExpire, p.Asset.MIME, p.Filename)
-				if err != nil {
-					return nil, fmt.Errorf("create material url for asset %s: %w", p.Asset.S3Key, err)
+
+		parts := slices.Clone(survivor.Parts)
+		linked := make(map[string]bool)
+		for _, p := range parts {
+			if p.Asset != nil {
+				linked[p.Asset.SHA256] = true
+			}
+		}
+		merged := 0
+		for _, id := range run.DuplicateIDs {
+			for _, p := range msgs[byID[id]].Parts {
+				if p.Asset != nil && !linked[p.Asset.SHA256] {
+					linked[p.Asset.SHA256] = true
+					parts = append(parts, p)
+					merged++
 				}
-				out.PublicURLs[p.Asset.SHA256] = PublicURL{
-					URL:      url,
-					ExpireAt: expireAt,
+			}
+		}
+
+		merge := MessageMerge{DuplicateRun: run, ReparentedIDs: []uuid.UUID{}, MergedAssets: merged}
+		for _, m := range msgs {
+			if m.ParentID != nil && duplicate[*m.ParentID] && !duplicate[m.ID] {
+				merge.ReparentedIDs = append(merge.ReparentedIDs, m.ID)
+			}
+		}
+		out.Merges = append(out.Merges, merge)
+		if in.DryRun {
+			continue
+		}
+
+		if merged > 0 {
+			var assets []model.Asset
+			for _, p := range parts {
+				if p.Asset != nil {
+					assets = append(assets, *p.Asset)
 				}
 			}
+			encoding := survivor.TokenEncoding
+			if encoding == "" {
+				encoding = tokenizer.DefaultEncoding
+			}
+			if _, err := s.commitMessageParts(ctx, messagePartsEdit{
+				ProjectID:    in.ProjectID,
+				SessionID:    in.SessionID,
+				Current:      &survivor,
+				Parts:        parts,
+				Assets:       assets,
+				Encoding:     encoding,
+				CheckCurrent: true,
+				UserKEK:      in.UserKEK,
+			}); err != nil {
+				return nil, fmt.Errorf("merge assets into message %s: %w", survivor.ID, err)
+			}
+		}
+		if err := s.sessionRepo.MergeDuplicateMessages(ctx, in.SessionID, run.SurvivorID, run.DuplicateIDs); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrMessageNotFound
+			}
+			if errors.Is(err, repo.ErrMessageShared) {
+				return nil, ErrMessageShared
+			}
+			return nil, fmt.Errorf("merge duplicates of message %s: %w", survivor.ID, err)
 		}
 	}
-
 	return out, nil
 }
 
-// DownloadAsset downloads and decrypts an asset from S3 by its key.
-func (s *sessionService) DownloadAsset(ctx context.Context, s3Key string, userKEK []byte) ([]byte, error) {
-	if s.s3 == nil {
-		return nil, errors.New("S3 not configured")
+// SetMessagePinned pins or unpins a message in the session.
+func (s *sessionService) SetMessagePinned(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, pinned bool) error {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return err
 	}
-	return s.s3.DownloadFile(ctx, s3Key, userKEK)
+	if err := s.sessionRepo.SetMessagePinned(ctx, sessionID, messageID, pinned); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMessageNotFound
+		}
+		return fmt.Errorf("set message pinned: %w", err)
+	}
+	return nil
 }
 
-// cachePartsInRedis stores message parts in Redis with a fixed TTL.
-// When userKEK is provided, the serialized JSON is encrypted before caching.
-// Format: prefix_byte | payload
-//   - 0x00 | json_data (plaintext)
-//   - 0x01 | wrappedDEK_len (2 bytes BE) | wrappedDEK | ciphertext (encrypted)
-func (s *sessionService) cachePartsInRedis(ctx context.Context, projectID string, sha256 string, parts []model.Part, userKEK []byte) error {
-	if s.redis == nil {
-		return errors.New("redis client is not available")
-	}
+type SetMessageFlagInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	Flagged   bool
+	// Reason is stored as the message's FlagReason; it is cleared when Flagged is false.
+	Reason string
+	// Note explains the change in the audit trail.
+	Note string
+}
 
-	// Serialize parts to JSON
-	jsonData, err := sonic.Marshal(parts)
-	if err != nil {
-		return fmt.Errorf("marshal parts to JSON: %w", err)
+// SetMessageFlag overrides the moderation flag of a message, e.g. to clear a false positive,
+// and returns the audit entry recording the change.
+func (s *sessionService) SetMessageFlag(ctx context.Context, in SetMessageFlagInput) (*model.MessageFlagAudit, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
 	}
-
-	var cacheData []byte
-	if userKEK != nil {
-		// Encrypt the JSON data
-		ciphertext, encMeta, err := crypto.EncryptData(userKEK, jsonData)
-		if err != nil {
-			return fmt.Errorf("encrypt parts for cache: %w", err)
-		}
-		wrappedDEK := []byte(encMeta.UserWrappedDEK)
-		// Frame: 0x01 | wrappedDEK_len (2 bytes BE) | wrappedDEK | ciphertext
-		cacheData = make([]byte, 1+2+len(wrappedDEK)+len(ciphertext))
-		cacheData[0] = cachePrefixEncrypted
-		binary.BigEndian.PutUint16(cacheData[1:3], uint16(len(wrappedDEK)))
-		copy(cacheData[3:3+len(wrappedDEK)], wrappedDEK)
-		copy(cacheData[3+len(wrappedDEK):], ciphertext)
-	} else {
-		// Frame: 0x00 | json_data
-		cacheData = make([]byte, 1+len(jsonData))
-		cacheData[0] = cachePrefixPlaintext
-		copy(cacheData[1:], jsonData)
+	reason := in.Reason
+	if !in.Flagged {
+		reason = ""
 	}
-
-	// Use project ID + SHA256 as Redis key for project-scoped content-based caching
-	redisKey := redisKeyPrefixParts + projectID + ":" + sha256
-
-	// Store in Redis with fixed TTL
-	if err := s.redis.Set(ctx, redisKey, cacheData, defaultPartsCacheTTL).Err(); err != nil {
-		return fmt.Errorf("set Redis key %s: %w", redisKey, err)
+	audit, err := s.sessionRepo.SetMessageFlag(ctx, in.SessionID, in.MessageID, in.Flagged, reason, in.Note)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("set message flag: %w", err)
 	}
-
-	return nil
+	return audit, nil
 }
 
-// getPartsFromRedis retrieves message parts from Redis cache.
-// When userKEK is provided and the cached data is encrypted, it decrypts before unmarshalling.
-// Returns (nil, redis.Nil) on cache miss, which is a normal condition.
-func (s *sessionService) getPartsFromRedis(ctx context.Context, projectID string, sha256 string, userKEK []byte) ([]model.Part, error) {
-	if s.redis == nil {
-		return nil, errors.New("redis client is not available")
+// ListMessageFlagAudits returns the flag overrides of a message, oldest first.
+func (s *sessionService) ListMessageFlagAudits(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageFlagAudit, error) {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return nil, err
 	}
-
-	redisKey := redisKeyPrefixParts + projectID + ":" + sha256
-
-	// Get from Redis
-	val, err := s.redis.Get(ctx, redisKey).Bytes()
+	audits, err := s.sessionRepo.ListMessageFlagAudits(ctx, sessionID, messageID)
 	if err != nil {
-		// redis.Nil means key doesn't exist (cache miss), which is normal
-		if err == redis.Nil {
-			return nil, redis.Nil
-		}
-		// Other errors are actual Redis errors
-		return nil, fmt.Errorf("get Redis key %s: %w", redisKey, err)
+		return nil, fmt.Errorf("list message flag audits: %w", err)
 	}
+	return audits, nil
+}
 
-	if len(val) == 0 {
-		return nil, fmt.Errorf("empty cached data for key %s", redisKey)
-	}
+type AddMessageAnnotationInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	MessageID uuid.UUID
+	Kind      string
+	// Value is the reaction or label; it must be empty for a note.
+	Value string
+	// Note is the text of a note, or an optional comment on a reaction or label.
+	Note       string
+	AuthorID   *uuid.UUID
+	AuthorType string
+}
 
-	var jsonData []byte
-	switch val[0] {
-	case cachePrefixPlaintext:
-		// Plaintext: strip prefix byte
-		jsonData = val[1:]
-	case cachePrefixEncrypted:
-		// Encrypted: 0x01 | wrappedDEK_len (2 bytes BE) | wrappedDEK | ciphertext
-		if userKEK == nil {
-			return nil, fmt.Errorf("encrypted cache entry but no user KEK provided")
+// validateAnnotation checks an annotation's kind against its value and note.
+func validateAnnotation(in AddMessageAnnotationInput) error {
+	switch in.Kind {
+	case model.AnnotationKindReaction:
+		if !model.IsReaction(in.Value) {
+			return fmt.Errorf("%w: unknown reaction %q, expected one of %s", ErrInvalidAnnotation, in.Value, strings.Join(model.Reactions, ", "))
 		}
-		if len(val) < 3 {
-			return nil, fmt.Errorf("malformed encrypted cache entry: too short")
+	case model.AnnotationKindLabel:
+		if in.Value == "" {
+			return fmt.Errorf("%w: a label needs a value", ErrInvalidAnnotation)
 		}
-		wrappedDEKLen := int(binary.BigEndian.Uint16(val[1:3]))
-		if len(val) < 3+wrappedDEKLen {
-			return nil, fmt.Errorf("malformed encrypted cache entry: wrappedDEK length exceeds data")
+		if len(in.Value) > model.MaxAnnotationValueLen {
+			return fmt.Errorf("%w: label exceeds %d bytes", ErrInvalidAnnotation, model.MaxAnnotationValueLen)
 		}
-		wrappedDEK := string(val[3 : 3+wrappedDEKLen])
-		ciphertext := val[3+wrappedDEKLen:]
-		encMeta := &crypto.EncryptedMeta{
-			Algo:           "AES-256-GCM",
-			UserWrappedDEK: wrappedDEK,
+	case model.AnnotationKindNote:
+		if in.Value != "" {
+			return fmt.Errorf("%w: a note has no value", ErrInvalidAnnotation)
 		}
-		jsonData, err = crypto.DecryptData(userKEK, ciphertext, encMeta)
-		if err != nil {
-			return nil, fmt.Errorf("decrypt cached parts: %w", err)
+		if in.Note == "" {
+			return fmt.Errorf("%w: a note needs text", ErrInvalidAnnotation)
 		}
 	default:
-		// Legacy cache entry without prefix byte — treat as raw plaintext JSON
-		jsonData = val
+		return fmt.Errorf("%w: unknown kind %q, expected one of %s", ErrInvalidAnnotation, in.Kind, strings.Join(model.AnnotationKinds, ", "))
 	}
+	return validateAuthor(in.AuthorID, in.AuthorType)
+}
 
-	// Deserialize JSON to parts
-	var parts []model.Part
-	if err := sonic.Unmarshal(jsonData, &parts); err != nil {
-		return nil, fmt.Errorf("unmarshal parts from JSON: %w", err)
+// AddMessageAnnotation attaches a reaction, label or note to a message. A message can collect
+// any number of annotations, several from the same author included.
+func (s *sessionService) AddMessageAnnotation(ctx context.Context, in AddMessageAnnotationInput) (*model.MessageAnnotation, error) {
+	in.Value = strings.TrimSpace(in.Value)
+	if err := validateAnnotation(in); err != nil {
+		return nil, err
 	}
-
-	return parts, nil
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+	a := &model.MessageAnnotation{
+		MessageID:  in.MessageID,
+		Kind:       in.Kind,
+		Value:      in.Value,
+		Note:       in.Note,
+		AuthorID:   in.AuthorID,
+		AuthorType: in.AuthorType,
+	}
+	if err := s.sessionRepo.CreateMessageAnnotation(ctx, in.SessionID, a); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("add message annotation: %w", err)
+	}
+	return a, nil
 }
 
-// loadPartsForMessage loads parts from cache/S3. Returns (parts, ok) where
-// ok=false means a load failure (the message should be skipped), and ok=true
-// with an empty slice means the message legitimately has no content parts.
-// userKEK is the optional user key-encryption-key for decrypting envelope-encrypted parts.
-func (s *sessionService) loadPartsForMessage(ctx context.Context, projectID string, meta model.Asset, userKEK []byte) ([]model.Part, bool) {
-	parts := []model.Part{}
-	cacheHit := false
+// ListMessageAnnotations returns the annotations of a message, oldest first.
+func (s *sessionService) ListMessageAnnotations(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID) ([]model.MessageAnnotation, error) {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return nil, err
+	}
+	annotations, err := s.sessionRepo.ListMessageAnnotations(ctx, sessionID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("list message annotations: %w", err)
+	}
+	return annotations, nil
+}
 
-	// Try to get parts from Redis cache first, fallback to S3 if not found
-	if s.redis != nil {
-		if cachedParts, err := s.getPartsFromRedis(ctx, projectID, meta.SHA256, userKEK); err == nil {
-			parts = cachedParts
-			cacheHit = true
-		} else if err != redis.Nil {
-			// Log actual Redis errors (not cache misses)
-			s.log.Warn("failed to get parts from Redis", zap.String("sha256", meta.SHA256), zap.Error(err))
+// DeleteMessageAnnotation removes an annotation from a message.
+func (s *sessionService) DeleteMessageAnnotation(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, annotationID uuid.UUID) error {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.DeleteMessageAnnotation(ctx, sessionID, messageID, annotationID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAnnotationNotFound
 		}
+		return fmt.Errorf("delete message annotation: %w", err)
 	}
+	return nil
+}
 
-	// If cache miss, download from S3
-	if !cacheHit && s.s3 != nil {
-		if err := s.s3.DownloadJSON(ctx, meta.S3Key, &parts, userKEK); err != nil {
-			s.log.Warn("failed to download parts from S3", zap.String("sha256", meta.SHA256), zap.Error(err))
-			return nil, false
-		}
-		// Cache the parts in Redis after successful S3 download
-		if s.redis != nil {
-			if err := s.cachePartsInRedis(ctx, projectID, meta.SHA256, parts, userKEK); err != nil {
-				// Log error but don't fail the request if Redis caching fails
-				s.log.Warn("failed to cache parts in Redis", zap.String("sha256", meta.SHA256), zap.Error(err))
-			}
+// CountSessionReactions returns how many reactions of each value the session's messages carry.
+// Every value of model.Reactions is present, with zero when no message carries it.
+func (s *sessionService) CountSessionReactions(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (map[string]int64, error) {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return nil, err
+	}
+	counts, err := s.sessionRepo.CountSessionReactions(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("count session reactions: %w", err)
+	}
+	for _, r := range model.Reactions {
+		if _, ok := counts[r]; !ok {
+			counts[r] = 0
 		}
 	}
-
-	return parts, true
+	return counts, nil
 }
 
-// GetAllMessages retrieves all messages for a session and loads their parts
-func (s *sessionService) GetAllMessages(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) ([]model.Message, error) {
-	// Get all messages from repository
-	msgs, err := s.sessionRepo.ListAllMessagesBySession(ctx, sessionID)
+// ListPinnedMessages returns the session's pinned messages with their parts, oldest first.
+// Messages whose parts fail to load are left out, as in GetAllMessages.
+func (s *sessionService) ListPinnedMessages(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, userKEK []byte) ([]model.Message, error) {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return nil, err
+	}
+	msgs, err := s.sessionRepo.ListPinnedMessages(ctx, sessionID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list messages: %w", err)
+		return nil, fmt.Errorf("list pinned messages: %w", err)
 	}
-
-	// Load parts for each message, filtering out those with failed loads
 	n := 0
 	for i, m := range msgs {
-		meta := m.PartsAssetMeta.Data()
-		parts, ok := s.loadPartsForMessage(ctx, projectID.String(), meta, userKEK)
+		parts, ok := s.loadPartsForMessage(ctx, projectID.String(), m.PartsAssetMeta.Data(), userKEK)
 		if !ok {
 			continue
 		}
@@ -770,102 +3866,220 @@ func (s *sessionService) GetAllMessages(ctx context.Context, projectID uuid.UUID
 		msgs[n] = msgs[i]
 		n++
 	}
-	msgs = msgs[:n]
+	redactMessages(ctx, msgs[:n], nil)
+	return msgs[:n], nil
+}
 
-	// Sort messages from old to new (ascending by created_at)
-	sort.Slice(msgs, func(i, j int) bool {
-		if msgs[i].CreatedAt.Equal(msgs[j].CreatedAt) {
-			return msgs[i].ID.String() < msgs[j].ID.String()
+// RestoreMessage undoes a soft delete of a message that has not been purged yet. Only
+// finalization guards it: restoring brings back a message as it was, so the window does not.
+func (s *sessionService) RestoreMessage(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, messageID uuid.UUID, override *ImmutabilityOverride) error {
+	session, err := s.getSessionInProject(ctx, projectID, sessionID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkMutable(ctx, session, &messageID, model.OverrideRestoreMessage, override); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.RestoreMessage(ctx, sessionID, messageID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMessageNotFound
 		}
-		return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
-	})
+		return fmt.Errorf("restore message: %w", err)
+	}
+	return nil
+}
 
-	return msgs, nil
+// PurgeDeleted hard-deletes sessions and messages soft-deleted more than olderThan ago, and
+// releases message idempotency keys older than repo.IdempotencyKeyTTL.
+func (s *sessionService) PurgeDeleted(ctx context.Context, olderThan time.Duration) (*PurgeDeletedOutput, error) {
+	result, err := s.sessionRepo.PurgeDeleted(ctx, olderThan)
+	if err != nil {
+		return nil, fmt.Errorf("purge deleted: %w", err)
+	}
+	keys, err := s.sessionRepo.ExpireIdempotencyKeys(ctx, repo.IdempotencyKeyTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &PurgeDeletedOutput{Sessions: result.Sessions, Messages: result.Messages, IdempotencyKeys: keys}, nil
 }
 
-// GetSessionObservingStatus retrieves observing status for a specific session
-func (s *sessionService) GetSessionObservingStatus(
-	ctx context.Context,
-	sessionID string,
-) (*model.MessageObservingStatus, error) {
+// checkSessionProject verifies the session exists and belongs to the project.
+func (s *sessionService) checkSessionProject(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) error {
+	_, err := s.getSessionInProject(ctx, projectID, sessionID)
+	return err
+}
 
-	if sessionID == "" {
-		return nil, fmt.Errorf("session ID is required")
+// getSessionInProject loads the session, reporting ErrSessionNotFound when it belongs to another project.
+func (s *sessionService) getSessionInProject(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (*model.Session, error) {
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: sessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
+	if session.ProjectID != projectID {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
 
-	if ctx == nil {
-		return nil, fmt.Errorf("context cannot be nil")
+// Quota scopes reported by QuotaExceededError.
+const (
+	QuotaScopeSession = "session"
+	QuotaScopeAsset   = "asset"
+)
+
+// QuotaExceededError reports a write that would take a session, or a single asset, past its
+// configured storage limit. It matches ErrQuotaExceeded with errors.Is.
+type QuotaExceededError struct {
+	Scope     string `json:"scope"`
+	Usage     int64  `json:"usage_bytes"`
+	Requested int64  `json:"requested_bytes"`
+	Limit     int64  `json:"limit_bytes"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Scope == QuotaScopeAsset {
+		return fmt.Sprintf("%s: asset of %d bytes exceeds the %d byte limit", ErrQuotaExceeded, e.Requested, e.Limit)
 	}
+	return fmt.Sprintf("%s: session uses %d of %d bytes, %d more requested", ErrQuotaExceeded, e.Usage, e.Limit, e.Requested)
+}
 
-	status, err := s.sessionRepo.GetObservingStatus(ctx, sessionID)
+func (e *QuotaExceededError) Unwrap() error { return ErrQuotaExceeded }
+
+// CheckQuota reports a *QuotaExceededError when adding additionalBytes would take the session
+// past the configured session limit. Shrinking writes and unlimited deployments always pass.
+func (s *sessionService) CheckQuota(ctx context.Context, sessionID uuid.UUID, additionalBytes int64) error {
+	if s.cfg.Quota.MaxSessionBytes <= 0 || additionalBytes <= 0 {
+		return nil
+	}
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: sessionID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get session observing status: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	return s.quotaErr(session.TotalBytes, additionalBytes)
+}
+
+func (s *sessionService) quotaErr(usage int64, additionalBytes int64) error {
+	limit := s.cfg.Quota.MaxSessionBytes
+	if limit <= 0 || additionalBytes <= 0 || usage+additionalBytes <= limit {
+		return nil
 	}
+	return &QuotaExceededError{Scope: QuotaScopeSession, Usage: usage, Requested: additionalBytes, Limit: limit}
+}
+
+// Message limits reported by MessageLimitError.
+const (
+	MessageLimitParts      = "parts"
+	MessageLimitPartsBytes = "parts_bytes"
+)
+
+// MessageLimitError reports a message with more parts, or larger serialized parts, than its
+// project allows. It matches ErrMessageTooLarge with errors.Is.
+type MessageLimitError struct {
+	Limit string `json:"limit"`
+	Size  int64  `json:"size"`
+	Max   int64  `json:"max"`
+}
 
-	if status == nil {
-		return nil, fmt.Errorf("repository returned nil status")
+func (e *MessageLimitError) Error() string {
+	if e.Limit == MessageLimitParts {
+		return fmt.Sprintf("%s: %d parts exceed the limit of %d", ErrMessageTooLarge, e.Size, e.Max)
 	}
+	return fmt.Sprintf("%s: parts of %d bytes exceed the %d byte limit", ErrMessageTooLarge, e.Size, e.Max)
+}
 
-	return status, nil
+func (e *MessageLimitError) Unwrap() error { return ErrMessageTooLarge }
+
+// messageLimits caps the parts of one message; a value <= 0 disables that cap.
+type messageLimits struct {
+	maxParts      int64
+	maxPartsBytes int64
 }
 
-// PatchMessageMeta updates message metadata using patch semantics.
-// Only updates keys present in patchMeta. Use nil value to delete a key.
-// Returns the updated user meta (only user-provided metadata, not system fields).
-func (s *sessionService) PatchMessageMeta(
-	ctx context.Context,
-	projectID uuid.UUID,
-	sessionID uuid.UUID,
-	messageID uuid.UUID,
-	patchMeta map[string]interface{},
-) (map[string]interface{}, error) {
-	// Verify session exists and belongs to project
-	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: sessionID})
-	if err != nil {
-		return nil, fmt.Errorf("session not found")
+// messageLimits returns the deployment's message limits as lowered by the project's config.
+func (s *sessionService) messageLimits(project *model.Project) messageLimits {
+	return messageLimits{
+		maxParts:      projectLimit(project, projectConfigMaxMessageParts, int64(s.cfg.Quota.MaxMessageParts)),
+		maxPartsBytes: projectLimit(project, projectConfigMaxMessagePartsBytes, s.cfg.Quota.MaxMessagePartsBytes),
 	}
-	if session.ProjectID != projectID {
-		return nil, fmt.Errorf("session not found")
+}
+
+// check reports a *MessageLimitError when count parts serializing to size bytes break a limit.
+func (l messageLimits) check(count int, size int64) error {
+	if l.maxParts > 0 && int64(count) > l.maxParts {
+		return &MessageLimitError{Limit: MessageLimitParts, Size: int64(count), Max: l.maxParts}
+	}
+	if l.maxPartsBytes > 0 && size > l.maxPartsBytes {
+		return &MessageLimitError{Limit: MessageLimitPartsBytes, Size: size, Max: l.maxPartsBytes}
 	}
+	return nil
+}
 
-	// Get the message (also verifies it belongs to the session)
-	msg, err := s.sessionRepo.GetMessageByID(ctx, sessionID, messageID)
+// preparePartsAsset is PrepareJSONAsset for a parts object: text parts over the configured
+// threshold are stored compressed, which loadPartsForMessage undoes. parts is not modified, so
+// search text and token counts are still taken from the plain text.
+func (s *sessionService) preparePartsAsset(keyPrefix string, parts []model.Part) (*blob.PreparedUpload, error) {
+	threshold := 0
+	if s.cfg != nil {
+		threshold = s.cfg.PartCompression.TextThresholdBytes
+	}
+	stored, saved, err := model.CompressParts(parts, threshold)
 	if err != nil {
-		return nil, fmt.Errorf("message not found")
+		return nil, err
 	}
-
-	// Get existing meta
-	existingMeta := msg.Meta.Data()
-	if existingMeta == nil {
-		existingMeta = make(map[string]interface{})
+	prepared, err := s.s3.PrepareJSONAsset(keyPrefix, stored)
+	if err != nil {
+		return nil, err
 	}
-
-	// Extract existing user meta
-	var userMeta map[string]interface{}
-	if um, ok := existingMeta[model.UserMetaKey].(map[string]interface{}); ok {
-		userMeta = um
-	} else {
-		userMeta = make(map[string]interface{})
+	if saved > 0 {
+		s.log.Debug("compressed text parts", zap.String("sha256", prepared.Asset.SHA256), zap.Int64("saved_bytes", saved), zap.Int64("stored_bytes", prepared.Asset.SizeB))
 	}
+	return prepared, nil
+}
 
-	// Apply patch: merge new keys, delete keys with nil value
-	for k, v := range patchMeta {
-		if v == nil {
-			delete(userMeta, k) // null value = delete key
-		} else {
-			userMeta[k] = v // add or update key
+// messageStorageBytes is what a message adds to its session's TotalBytes: its parts object and
+// every asset the parts reference, shared ones included.
+func messageStorageBytes(partsAsset model.Asset, parts []model.Part) int64 {
+	total := partsAsset.SizeB
+	for _, p := range parts {
+		if p.Asset != nil {
+			total += p.Asset.SizeB
 		}
 	}
+	return total
+}
 
-	// Update the __user_meta__ field
-	existingMeta[model.UserMetaKey] = userMeta
+type SessionStorageUsage struct {
+	SessionID  uuid.UUID `json:"session_id"`
+	TotalBytes int64     `json:"total_bytes"`
+	// LimitBytes and MaxAssetBytes are 0 when the limit is disabled.
+	LimitBytes    int64 `json:"limit_bytes"`
+	MaxAssetBytes int64 `json:"max_asset_bytes"`
+}
 
-	// Save to database
-	if err := s.sessionRepo.UpdateMessageMeta(ctx, messageID, datatypes.NewJSONType(existingMeta)); err != nil {
-		return nil, fmt.Errorf("failed to update message meta: %w", err)
+// GetStorageUsage returns the session's storage usage and the limits that apply to it.
+func (s *sessionService) GetStorageUsage(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (*SessionStorageUsage, error) {
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: sessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
-
-	return userMeta, nil
+	if session.ProjectID != projectID {
+		return nil, ErrSessionNotFound
+	}
+	return &SessionStorageUsage{
+		SessionID:     sessionID,
+		TotalBytes:    session.TotalBytes,
+		LimitBytes:    max(s.cfg.Quota.MaxSessionBytes, 0),
+		MaxAssetBytes: max(s.cfg.Quota.MaxAssetBytes, 0),
+	}, nil
 }
 
 // PatchConfigs updates session configs using patch semantics.
@@ -917,6 +4131,113 @@ func (s *sessionService) PatchConfigs(
 	return existingConfigs, nil
 }
 
+// MaxTagLength is the longest accepted session tag, in characters.
+const MaxTagLength = 64
+
+// NormalizeTags trims and lowercases tags, drops empty ones and removes duplicates, keeping the
+// first occurrence's position.
+func NormalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return nil, fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidTag, tag, MaxTagLength)
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		out = append(out, tag)
+	}
+	return out, nil
+}
+
+type SetSessionMetadataInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	Metadata  map[string]interface{}
+	// Merge keeps existing keys and deletes those set to null; otherwise Metadata replaces them all.
+	Merge bool
+}
+
+// SetMetadata replaces or merges the session's metadata and returns the result.
+func (s *sessionService) SetMetadata(ctx context.Context, in SetSessionMetadataInput) (map[string]interface{}, error) {
+	session, err := s.sessionRepo.UpdateLabels(ctx, in.ProjectID, in.SessionID, func(ss *model.Session) error {
+		metadata := datatypes.JSONMap{}
+		if in.Merge {
+			for k, v := range ss.Metadata {
+				metadata[k] = v
+			}
+		}
+		for k, v := range in.Metadata {
+			if v == nil {
+				delete(metadata, k)
+			} else {
+				metadata[k] = v
+			}
+		}
+		ss.Metadata = metadata
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to update session metadata: %w", err)
+	}
+	return session.Metadata, nil
+}
+
+type UpdateSessionTagsInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	Add       []string
+	Remove    []string
+}
+
+// UpdateTags adds and removes session tags and returns the resulting tags. A tag in both lists is
+// removed.
+func (s *sessionService) UpdateTags(ctx context.Context, in UpdateSessionTagsInput) ([]string, error) {
+	add, err := NormalizeTags(in.Add)
+	if err != nil {
+		return nil, err
+	}
+	remove, err := NormalizeTags(in.Remove)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.sessionRepo.UpdateLabels(ctx, in.ProjectID, in.SessionID, func(ss *model.Session) error {
+		// Stored tags are already normalized; seen also holds the tags to drop.
+		seen := make(map[string]struct{}, len(ss.Tags)+len(add)+len(remove))
+		for _, tag := range remove {
+			seen[tag] = struct{}{}
+		}
+		tags := make([]string, 0, len(ss.Tags)+len(add))
+		for _, group := range [][]string{ss.Tags, add} {
+			for _, tag := range group {
+				if _, ok := seen[tag]; !ok {
+					seen[tag] = struct{}{}
+					tags = append(tags, tag)
+				}
+			}
+		}
+		ss.Tags = model.StringArray(tags)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to update session tags: %w", err)
+	}
+	return session.Tags, nil
+}
+
 // CopySession creates a complete copy of a session with all its messages and tasks.
 // Returns CopySessionOutput containing old and new session IDs.
 func (s *sessionService) CopySession(ctx context.Context, in CopySessionInput) (*CopySessionOutput, error) {
@@ -947,3 +4268,292 @@ func (s *sessionService) CopySession(ctx context.Context, in CopySessionInput) (
 		NewSessionID: result.NewSessionID,
 	}, nil
 }
+
+// SetSystemPrompt overrides the project's default system prompt for the session. An empty
+// prompt keeps BuildContext from injecting one; nil goes back to the project default.
+func (s *sessionService) SetSystemPrompt(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, prompt *string) error {
+	if prompt != nil && len(*prompt) > MaxSystemPromptLen {
+		return ErrSystemPromptTooLong
+	}
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.SetSystemPrompt(ctx, sessionID, prompt); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("set system prompt: %w", err)
+	}
+	return nil
+}
+
+// NormalizeAllowedPartTypes checks a part-type allowlist against the known part types and removes
+// duplicates, keeping the first occurrence's position. An empty list allows every type.
+func NormalizeAllowedPartTypes(types []string) ([]string, error) {
+	out := make([]string, 0, len(types))
+	for _, t := range types {
+		if !model.IsPartType(t) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPartType, t)
+		}
+		if !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// SetAllowedPartTypes restricts the part types new and edited messages of the session may carry,
+// and returns the normalized list. An empty list lifts the restriction. Existing messages are
+// left as they are.
+func (s *sessionService) SetAllowedPartTypes(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, types []string) ([]string, error) {
+	types, err := NormalizeAllowedPartTypes(types)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return nil, err
+	}
+	if err := s.sessionRepo.SetAllowedPartTypes(ctx, sessionID, types); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("set allowed part types: %w", err)
+	}
+	return types, nil
+}
+
+// SetTemplate marks the session as a reusable template, or turns a template back into an
+// ordinary session.
+func (s *sessionService) SetTemplate(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, isTemplate bool) error {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.SetTemplate(ctx, sessionID, isTemplate); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("set template: %w", err)
+	}
+	return nil
+}
+
+// templatePlaceholder matches a {{name}} variable in a template's text parts.
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// templateVariableName is the form accepted for override keys.
+var templateVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type InstantiateTemplateInput struct {
+	ProjectID  uuid.UUID
+	TemplateID uuid.UUID
+	Overrides  map[string]string // Values for {{name}} placeholders in text parts
+	UserKEK    []byte
+}
+
+type InstantiateTemplateOutput struct {
+	TemplateID uuid.UUID `json:"template_id"`
+	SessionID  uuid.UUID `json:"session_id"`
+	// SubstitutedMessages counts the messages whose text had placeholders replaced.
+	SubstitutedMessages int `json:"substituted_messages"`
+}
+
+// InstantiateTemplate creates a session from a template by copying its messages with fresh IDs.
+// Copies share the template's parts objects; only messages whose text parts contain an
+// overridden {{name}} placeholder get a new parts object with the values substituted.
+// Placeholders without an override are left as they are.
+func (s *sessionService) InstantiateTemplate(ctx context.Context, in InstantiateTemplateInput) (*InstantiateTemplateOutput, error) {
+	for name := range in.Overrides {
+		if !templateVariableName.MatchString(name) {
+			return nil, fmt.Errorf("%w: %q is not a valid variable name", ErrInvalidTemplateOverride, name)
+		}
+	}
+	tpl, err := s.getSessionInProject(ctx, in.ProjectID, in.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	if !tpl.IsTemplate {
+		return nil, ErrNotTemplate
+	}
+
+	result, err := s.sessionRepo.CopySession(ctx, in.TemplateID, in.UserKEK)
+	if err != nil {
+		if errors.Is(err, repo.ErrSessionTooLarge) {
+			return nil, fmt.Errorf("%w: %v", ErrSessionTooLarge, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrCopyFailed, err)
+	}
+	out := &InstantiateTemplateOutput{TemplateID: in.TemplateID, SessionID: result.NewSessionID}
+	if len(in.Overrides) == 0 {
+		return out, nil
+	}
+
+	msgs, err := s.GetAllMessages(ctx, in.ProjectID, result.NewSessionID, in.UserKEK)
+	if err == nil {
+		for _, m := range msgs {
+			parts, changed := substituteTemplateParts(m.Parts, in.Overrides)
+			if !changed {
+				continue
+			}
+			if err = s.replaceTemplateParts(ctx, in, result.NewSessionID, m, parts); err != nil {
+				err = fmt.Errorf("substitute message %s: %w", m.ID, err)
+				break
+			}
+			out.SubstitutedMessages++
+		}
+	}
+	if err != nil {
+		// Don't leave a half-substituted session behind.
+		if delErr := s.sessionRepo.Delete(ctx, in.ProjectID, result.NewSessionID, in.UserKEK); delErr != nil {
+			s.log.Error("failed to remove partially instantiated session",
+				zap.String("session_id", result.NewSessionID.String()), zap.Error(delErr))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// substituteTemplateParts returns a copy of parts with overridden placeholders replaced in text
+// parts, and whether anything changed. parts itself is left untouched.
+func substituteTemplateParts(parts []model.Part, overrides map[string]string) ([]model.Part, bool) {
+	var out []model.Part
+	for i, part := range parts {
+		if part.Type != model.PartTypeText || !strings.Contains(part.Text, "{{") {
+			continue
+		}
+		text := templatePlaceholder.ReplaceAllStringFunc(part.Text, func(m string) string {
+			name := templatePlaceholder.FindStringSubmatch(m)[1]
+			if v, ok := overrides[name]; ok {
+				return v
+			}
+			return m
+		})
+		if text == part.Text {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(parts)
+		}
+		out[i].Text = text
+	}
+	if out == nil {
+		return parts, false
+	}
+	return out, true
+}
+
+// replaceTemplateParts uploads parts as msg's new parts object and releases the one it shared
+// with the template.
+func (s *sessionService) replaceTemplateParts(ctx context.Context, in InstantiateTemplateInput, sessionID uuid.UUID, msg model.Message, parts []model.Part) error {
+	if s.s3 == nil {
+		return errors.New("object storage is not configured")
+	}
+	projectKey := in.ProjectID.String()
+	encoding := msg.TokenEncoding
+	if encoding == "" {
+		encoding = tokenizer.DefaultEncoding
+	}
+	tokenCount, err := tokenizer.CountPartsTokens(parts, encoding)
+	if err != nil {
+		return fmt.Errorf("count tokens: %w", err)
+	}
+	prepared, err := s.preparePartsAsset("parts/"+projectKey, parts)
+	if err != nil {
+		return fmt.Errorf("prepare parts asset: %w", err)
+	}
+	if err := s.s3.UploadPrepared(ctx, prepared, in.UserKEK); err != nil {
+		return fmt.Errorf("upload parts asset: %w", err)
+	}
+
+	current := msg.PartsAssetMeta.Data()
+	if _, err := s.sessionRepo.ReplaceMessageParts(ctx, sessionID, msg.ID, current.SHA256, func(m *model.Message) error {
+		m.PartsAssetMeta = datatypes.NewJSONType(prepared.Asset)
+		m.StorageBytes = messageStorageBytes(prepared.Asset, parts)
+		m.SearchText = ""
+		if in.UserKEK == nil {
+			m.SearchText = searchTextFromParts(parts)
+		}
+		m.TokenCount = tokenCount
+		m.TokenEncoding = encoding
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if s.redis != nil {
+		if err := s.cachePartsInRedis(ctx, projectKey, prepared.Asset.SHA256, parts, in.UserKEK); err != nil {
+			s.log.Warn("failed to cache parts in Redis", zap.String("sha256", prepared.Asset.SHA256), zap.Error(err))
+		}
+	}
+	if err := s.assetRefBuffer.Enqueue(ctx, in.ProjectID, []model.Asset{prepared.Asset}); err != nil {
+		s.log.Error("failed to enqueue asset ref increments",
+			zap.String("project_id", projectKey), zap.Error(err))
+	}
+	if err := s.assetReferenceRepo.DecrementAssetRef(ctx, in.ProjectID, current); err != nil {
+		s.log.Warn("release template parts asset", zap.String("sha256", current.SHA256), zap.Error(err))
+	}
+	return nil
+}
+
+// ForkSession creates a new session from the ancestor chain ending at in.FromMessageID.
+// Returns ForkSessionOutput containing the new session ID and the old→new message ID mapping.
+func (s *sessionService) ForkSession(ctx context.Context, in ForkSessionInput) (*ForkSessionOutput, error) {
+	session, err := s.sessionRepo.Get(ctx, &model.Session{ID: in.SessionID})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.ProjectID != in.ProjectID {
+		return nil, ErrSessionNotFound
+	}
+
+	result, err := s.sessionRepo.ForkSession(ctx, in.SessionID, in.FromMessageID, in.UserKEK)
+	if err != nil {
+		if errors.Is(err, repo.ErrMessageNotInSession) {
+			return nil, ErrMessageNotInSession
+		}
+		if errors.Is(err, repo.ErrSessionTooLarge) {
+			return nil, fmt.Errorf("%w: %v", ErrSessionTooLarge, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrCopyFailed, err)
+	}
+
+	return &ForkSessionOutput{
+		OldSessionID: result.OldSessionID,
+		NewSessionID: result.NewSessionID,
+		MessageIDMap: result.MessageIDMap,
+	}, nil
+}
+
+// CloneSessionShallow creates a session that shares the thread ending at in.FromMessageID with
+// the source instead of copying it. See repo.SessionRepo.CloneSessionShallow for how shared
+// messages behave on writes and deletes.
+func (s *sessionService) CloneSessionShallow(ctx context.Context, in CloneSessionShallowInput) (*CloneSessionShallowOutput, error) {
+	if err := s.checkSessionProject(ctx, in.ProjectID, in.SessionID); err != nil {
+		return nil, err
+	}
+
+	result, err := s.sessionRepo.CloneSessionShallow(ctx, in.SessionID, in.FromMessageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repo.ErrMessageNotInSession):
+			return nil, ErrMessageNotInSession
+		case errors.Is(err, repo.ErrMessageStreaming):
+			return nil, ErrMessageStreaming
+		case errors.Is(err, repo.ErrSessionArchived):
+			return nil, ErrSessionArchived
+		}
+		return nil, fmt.Errorf("%w: %v", ErrCopyFailed, err)
+	}
+
+	out := &CloneSessionShallowOutput{
+		OldSessionID:   result.OldSessionID,
+		NewSessionID:   result.NewSessionID,
+		SharedMessages: result.SharedMessages,
+	}
+	if result.BaseMessageID != uuid.Nil {
+		out.BaseMessageID = &result.BaseMessageID
+	}
+	return out, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// sessionArchiveKey is the S3 key of a session's archive object.
+func sessionArchiveKey(projectID uuid.UUID, sessionID uuid.UUID) string {
+	return fmt.Sprintf("archives/%s/%s.json.gz", projectID, sessionID)
+}
+
+// encodeSessionArchive serializes arc as gzip-compressed JSON.
+func encodeSessionArchive(arc *repo.SessionArchive) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(arc); err != nil {
+		return nil, fmt.Errorf("encode session archive: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress session archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeSessionArchive reads an archive written by encodeSessionArchive.
+func decodeSessionArchive(data []byte) (*repo.SessionArchive, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompress session archive: %w", err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress session archive: %w", err)
+	}
+	var arc repo.SessionArchive
+	if err := json.Unmarshal(raw, &arc); err != nil {
+		return nil, fmt.Errorf("decode session archive: %w", err)
+	}
+	if arc.Version < 1 || arc.Version > repo.SessionArchiveVersion {
+		return nil, fmt.Errorf("unsupported session archive version %d", arc.Version)
+	}
+	return &arc, nil
+}
+
+// mapArchiveErr translates repo errors of archiving and restoring into service errors.
+func mapArchiveErr(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrSessionNotFound
+	case errors.Is(err, repo.ErrSessionArchived):
+		return ErrSessionArchived
+	case errors.Is(err, repo.ErrSessionNotArchived):
+		return ErrSessionNotArchived
+	case errors.Is(err, repo.ErrMessageStreaming):
+		return ErrMessageStreaming
+	case errors.Is(err, repo.ErrSessionShared):
+		return ErrSessionShared
+	}
+	return err
+}
+
+type ArchiveSessionInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	// Purge deletes the session's message rows once the archive is written, leaving a stub.
+	Purge   bool
+	UserKEK []byte
+}
+
+// ArchiveSession writes the session's messages and asset manifest to a compressed object in
+// cold storage and marks the session archived: it drops out of listings and takes no new
+// messages until RestoreSession. With Purge the message rows are deleted too; their assets stay
+// in storage, referenced by the archive. The archive is encrypted with the user KEK when set.
+func (s *sessionService) ArchiveSession(ctx context.Context, in ArchiveSessionInput) (*model.Session, error) {
+	key := sessionArchiveKey(in.ProjectID, in.SessionID)
+	session, err := s.sessionRepo.ArchiveSession(ctx, in.ProjectID, in.SessionID, key, in.Purge, in.UserKEK, func(arc *repo.SessionArchive) error {
+		data, err := encodeSessionArchive(arc)
+		if err != nil {
+			return err
+		}
+		_, err = s.s3.UploadFileWithStorageClass(ctx, key, data, "application/gzip", s.cfg.Archive.StorageClass, in.UserKEK)
+		return err
+	})
+	if err != nil {
+		return nil, mapArchiveErr(err)
+	}
+	return session, nil
+}
+
+type RestoreSessionInput struct {
+	ProjectID uuid.UUID
+	SessionID uuid.UUID
+	UserKEK   []byte
+}
+
+type RestoreSessionOutput struct {
+	Session *model.Session `json:"session"`
+	// RestoredMessages counts the messages rehydrated from the archive; zero unless it was purged.
+	RestoredMessages int `json:"restored_messages"`
+}
+
+// RestoreSession brings an archived session back: purged messages are rehydrated from the
+// archive with their original IDs, the session is listed again and the archive object is
+// deleted.
+func (s *sessionService) RestoreSession(ctx context.Context, in RestoreSessionInput) (*RestoreSessionOutput, error) {
+	result, err := s.sessionRepo.RestoreSession(ctx, in.ProjectID, in.SessionID, func(key string) (*repo.SessionArchive, error) {
+		data, err := s.s3.DownloadFile(ctx, key, in.UserKEK)
+		if err != nil {
+			return nil, fmt.Errorf("download session archive: %w", err)
+		}
+		return decodeSessionArchive(data)
+	})
+	if err != nil {
+		return nil, mapArchiveErr(err)
+	}
+
+	// The session no longer needs the archive; one left behind is overwritten by the next archive.
+	if result.ArchiveKey != "" {
+		if err := s.s3.DeleteObject(ctx, result.ArchiveKey); err != nil {
+			s.log.Warn("failed to delete restored session archive",
+				zap.Error(err), zap.String("session_id", in.SessionID.String()), zap.String("s3_key", result.ArchiveKey))
+		}
+	}
+	return &RestoreSessionOutput{Session: &result.Session, RestoredMessages: result.Messages}, nil
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func TestSessionArchive_EncodeDecode(t *testing.T) {
+	arc := &repo.SessionArchive{
+		Version:    repo.SessionArchiveVersion,
+		ProjectID:  uuid.New(),
+		SessionID:  uuid.New(),
+		ArchivedAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		Messages:   []repo.ArchivedMessage{{ID: uuid.New(), Seq: 1, Role: model.RoleUser, Meta: map[string]any{}}},
+		Assets:     []model.Asset{{SHA256: "sha", S3Key: "parts/p/sha.json"}},
+	}
+	data, err := encodeSessionArchive(arc)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x1f, 0x8b}, data[:2], "archives are gzip-compressed")
+
+	back, err := decodeSessionArchive(data)
+	require.NoError(t, err)
+	assert.Equal(t, arc, back)
+
+	_, err = decodeSessionArchive([]byte(`{"version":1}`))
+	assert.Error(t, err, "uncompressed data is rejected")
+
+	future := *arc
+	future.Version = repo.SessionArchiveVersion + 1
+	data, err = encodeSessionArchive(&future)
+	require.NoError(t, err)
+	_, err = decodeSessionArchive(data)
+	assert.ErrorContains(t, err, "unsupported session archive version")
+}
+
+func TestSessionService_ArchiveSession(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	key := sessionArchiveKey(projectID, sessionID)
+
+	newSvc := func(r *MockSessionRepo) SessionService {
+		return NewSessionService(r, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, &config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+
+	t.Run("archives under the session key", func(t *testing.T) {
+		r := &MockSessionRepo{}
+		archived := &model.Session{ID: sessionID, ProjectID: projectID, Archived: true, ArchivePurged: true}
+		r.On("ArchiveSession", ctx, projectID, sessionID, key, true, []byte(nil), mock.Anything).Return(archived, nil)
+
+		got, err := newSvc(r).ArchiveSession(ctx, ArchiveSessionInput{ProjectID: projectID, SessionID: sessionID, Purge: true})
+		require.NoError(t, err)
+		assert.Equal(t, archived, got)
+		r.AssertExpectations(t)
+	})
+
+	errCases := []struct {
+		name    string
+		repoErr error
+		want    error
+	}{
+		{"unknown session", gorm.ErrRecordNotFound, ErrSessionNotFound},
+		{"already archived", repo.ErrSessionArchived, ErrSessionArchived},
+		{"streaming message", repo.ErrMessageStreaming, ErrMessageStreaming},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &MockSessionRepo{}
+			r.On("ArchiveSession", ctx, projectID, sessionID, key, false, []byte(nil), mock.Anything).Return(nil, tc.repoErr)
+
+			_, err := newSvc(r).ArchiveSession(ctx, ArchiveSessionInput{ProjectID: projectID, SessionID: sessionID})
+			assert.ErrorIs(t, err, tc.want)
+		})
+	}
+
+	t.Run("storage failure is returned", func(t *testing.T) {
+		r := &MockSessionRepo{}
+		storeErr := errors.New("s3 unavailable")
+		r.On("ArchiveSession", ctx, projectID, sessionID, key, false, []byte(nil), mock.Anything).Return(nil, storeErr)
+
+		_, err := newSvc(r).ArchiveSession(ctx, ArchiveSessionInput{ProjectID: projectID, SessionID: sessionID})
+		assert.ErrorIs(t, err, storeErr)
+	})
+}
+
+func TestSessionService_RestoreSession(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	newSvc := func(r *MockSessionRepo) SessionService {
+		return NewSessionService(r, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, &config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+
+	t.Run("restored", func(t *testing.T) {
+		r := &MockSessionRepo{}
+		r.On("RestoreSession", ctx, projectID, sessionID, mock.Anything).Return(&repo.RestoreSessionResult{
+			Session:  model.Session{ID: sessionID, ProjectID: projectID},
+			Messages: 4,
+		}, nil)
+
+		out, err := newSvc(r).RestoreSession(ctx, RestoreSessionInput{ProjectID: projectID, SessionID: sessionID})
+		require.NoError(t, err)
+		assert.Equal(t, 4, out.RestoredMessages)
+		assert.Equal(t, sessionID, out.Session.ID)
+	})
+
+	t.Run("not archived", func(t *testing.T) {
+		r := &MockSessionRepo{}
+		r.On("RestoreSession", ctx, projectID, sessionID, mock.Anything).Return(nil, repo.ErrSessionNotArchived)
+
+		_, err := newSvc(r).RestoreSession(ctx, RestoreSessionInput{ProjectID: projectID, SessionID: sessionID})
+		assert.ErrorIs(t, err, ErrSessionNotArchived)
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		r := &MockSessionRepo{}
+		r.On("RestoreSession", ctx, projectID, sessionID, mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+
+		_, err := newSvc(r).RestoreSession(ctx, RestoreSessionInput{ProjectID: projectID, SessionID: sessionID})
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+}
+
+func TestSessionService_StoreMessage_Archived(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	r := &MockSessionRepo{}
+	r.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID, Archived: true}, nil)
+	svc := NewSessionService(r, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, &config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	_, err := svc.StoreMessage(ctx, StoreMessageInput{
+		ProjectID: projectID,
+		SessionID: sessionID,
+		Role:      model.RoleUser,
+		Parts:     []PartIn{{Type: "text", Text: "hello"}},
+	})
+	assert.ErrorIs(t, err, ErrSessionArchived)
+	r.AssertNotCalled(t, "CreateMessageWithAssets", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/paging"
+	"gorm.io/gorm"
+)
+
+// Page sizes of the project export: a page of sessions is held while their messages are walked
+// a page at a time, so memory stays flat however many messages the project has.
+const (
+	exportSessionPageSize = 100
+	exportMessagePageSize = 200
+)
+
+// ExportAssetMode selects how the assets of exported messages are manifested.
+type ExportAssetMode string
+
+const (
+	// ExportAssetsURL lists each asset with a time-limited download URL.
+	ExportAssetsURL ExportAssetMode = "url"
+	// ExportAssetsInline embeds each asset's content, base64-encoded, in the message record.
+	ExportAssetsInline ExportAssetMode = "inline"
+)
+
+// Record types of a project export, in the order they are written: each session is followed
+// by its messages, and an end record closes a complete export.
+const (
+	ExportRecordSession = "session"
+	ExportRecordMessage = "message"
+	ExportRecordEnd     = "end"
+)
+
+// ExportRecord is one line of a project export.
+type ExportRecord struct {
+	Type string `json:"type"`
+	// Cursor resumes the export after this record; it is empty on the end record.
+	Cursor  string         `json:"cursor,omitempty"`
+	Session *model.Session `json:"session,omitempty"`
+	Message *model.Message `json:"message,omitempty"`
+	// DeletedAt is set on the soft-deleted sessions and messages an IncludeDeleted export lists.
+	DeletedAt *time.Time    `json:"deleted_at,omitempty"`
+	Assets    []ExportAsset `json:"assets,omitempty"`
+}
+
+// ExportAsset is the manifest entry of an asset referenced by an exported message's parts.
+type ExportAsset struct {
+	SHA256   string     `json:"sha256"`
+	MIME     string     `json:"mime"`
+	SizeB    int64      `json:"size_b"`
+	Filename string     `json:"filename,omitempty"`
+	URL      string     `json:"url,omitempty"`
+	ExpireAt *time.Time `json:"expire_at,omitempty"`
+	Content  []byte     `json:"content,omitempty"`
+}
+
+type ExportProjectInput struct {
+	ProjectID uuid.UUID
+	// Cursor is the cursor of the last record received from an interrupted export.
+	Cursor         string
+	Assets         ExportAssetMode
+	IncludeDeleted bool
+	AssetExpire    time.Duration
+	UserKEK        []byte
+}
+
+// exportPosition is the place of a record in a project export. A zero MessageID is the session
+// record itself, before any of its messages.
+type exportPosition struct {
+	SessionCreatedAt time.Time
+	SessionID        uuid.UUID
+	MessageSeq       int64
+	MessageID        uuid.UUID
+}
+
+// exportCursorTag leads export cursors so list cursors are never read as one.
+const exportCursorTag = "export"
+
+func (p exportPosition) cursor() string {
+	raw := fmt.Sprintf("%s|%d|%s|%d|%s", exportCursorTag, p.SessionCreatedAt.UTC().UnixNano(), p.SessionID, p.MessageSeq, p.MessageID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeExportCursor(s string) (exportPosition, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return exportPosition{}, fmt.Errorf("%w: %v", paging.ErrInvalidCursor, err)
+	}
+	parts := strings.Split(string(b), "|")
+	if len(parts) != 5 || parts[0] != exportCursorTag {
+		return exportPosition{}, fmt.Errorf("%w: bad cursor", paging.ErrInvalidCursor)
+	}
+	ns, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return exportPosition{}, fmt.Errorf("%w: %v", paging.ErrInvalidCursor, err)
+	}
+	sessionID, err := uuid.Parse(parts[2])
+	if err != nil {
+		return exportPosition{}, fmt.Errorf("%w: %v", paging.ErrInvalidCursor, err)
+	}
+	seq, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return exportPosition{}, fmt.Errorf("%w: %v", paging.ErrInvalidCursor, err)
+	}
+	messageID, err := uuid.Parse(parts[4])
+	if err != nil {
+		return exportPosition{}, fmt.Errorf("%w: %v", paging.ErrInvalidCursor, err)
+	}
+	return exportPosition{SessionCreatedAt: time.Unix(0, ns).UTC(), SessionID: sessionID, MessageSeq: seq, MessageID: messageID}, nil
+}
+
+// ExportProject walks every session of the project and its messages, oldest session first and
+// messages in seq order, and passes each as a record to emit, then an end record. Sessions and
+// messages are read a page at a time. Templates and archived sessions are included; the rows of
+// a purged archive live in cold storage and are not. Soft-deleted sessions and messages are
+// skipped unless IncludeDeleted is set. Starting from a Cursor resumes after that record: an
+// invalid cursor fails with paging.ErrInvalidCursor before anything is emitted. A message whose
+// parts fail to load fails the export; the records emitted so far stay valid to resume from.
+func (s *sessionService) ExportProject(ctx context.Context, in ExportProjectInput, emit func(rec *ExportRecord) error) error {
+	var resume *exportPosition
+	afterCreatedAt, afterID := time.Time{}, uuid.Nil
+	if in.Cursor != "" {
+		pos, err := decodeExportCursor(in.Cursor)
+		if err != nil {
+			return err
+		}
+		// Start at the cursor's timestamp so its session is listed again to finish its messages.
+		resume, afterCreatedAt = &pos, pos.SessionCreatedAt
+	}
+
+	for {
+		sessions, err := s.sessionRepo.ListSessionsForExport(ctx, in.ProjectID, afterCreatedAt, afterID, exportSessionPageSize, in.IncludeDeleted)
+		if err != nil {
+			return fmt.Errorf("list sessions: %w", err)
+		}
+		for i := range sessions {
+			session := &sessions[i]
+			pos := exportPosition{SessionCreatedAt: session.CreatedAt, SessionID: session.ID}
+			if resume != nil && session.CreatedAt.Equal(resume.SessionCreatedAt) {
+				if cmp := bytes.Compare(session.ID[:], resume.SessionID[:]); cmp < 0 {
+					continue
+				} else if cmp == 0 {
+					pos.MessageSeq, pos.MessageID = resume.MessageSeq, resume.MessageID
+					if err := s.exportMessages(ctx, in, pos, emit); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			rec := &ExportRecord{Type: ExportRecordSession, Cursor: pos.cursor(), Session: session, DeletedAt: deletedAt(session.DeletedAt)}
+			if err := emit(rec); err != nil {
+				return err
+			}
+			if err := s.exportMessages(ctx, in, pos, emit); err != nil {
+				return err
+			}
+		}
+		if len(sessions) < exportSessionPageSize {
+			break
+		}
+		last := sessions[len(sessions)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+	}
+	return emit(&ExportRecord{Type: ExportRecordEnd})
+}
+
+// exportMessages emits the messages of the session at pos after its message position.
+func (s *sessionService) exportMessages(ctx context.Context, in ExportProjectInput, pos exportPosition, emit func(rec *ExportRecord) error) error {
+	for {
+		msgs, err := s.sessionRepo.ListMessagesForExport(ctx, pos.SessionID, pos.MessageSeq, pos.MessageID, exportMessagePageSize, in.IncludeDeleted)
+		if err != nil {
+			return fmt.Errorf("list messages of session %s: %w", pos.SessionID, err)
+		}
+		for i := range msgs {
+			m := &msgs[i]
+			parts, ok := s.loadPartsForMessage(ctx, in.ProjectID.String(), m.PartsAssetMeta.Data(), in.UserKEK)
+			if !ok {
+				return fmt.Errorf("failed to load parts of message %s", m.ID)
+			}
+			m.Parts = parts
+			m = redactMessage(ctx, m)
+			assets, err := s.exportAssets(ctx, in, m)
+			if err != nil {
+				return err
+			}
+			pos.MessageSeq, pos.MessageID = m.Seq, m.ID
+			rec := &ExportRecord{Type: ExportRecordMessage, Cursor: pos.cursor(), Message: m, DeletedAt: deletedAt(m.DeletedAt), Assets: assets}
+			if err := emit(rec); err != nil {
+				return err
+			}
+		}
+		if len(msgs) < exportMessagePageSize {
+			return nil
+		}
+	}
+}
+
+// exportAssets builds the manifest of the assets the message's parts reference, in part order
+// and listing each asset once. Only one inline asset is held in memory at a time.
+func (s *sessionService) exportAssets(ctx context.Context, in ExportProjectInput, m *model.Message) ([]ExportAsset, error) {
+	var urls map[string]PublicURL
+	if in.Assets != ExportAssetsInline && s.materialSvc != nil {
+		var err error
+		if urls, err = s.buildPublicURLs(ctx, []model.Message{*m}, in.AssetExpire, in.UserKEK); err != nil {
+			return nil, err
+		}
+	}
+	var assets []ExportAsset
+	seen := make(map[string]bool)
+	for _, p := range m.Parts {
+		if p.Asset == nil || seen[p.Asset.SHA256] {
+			continue
+		}
+		seen[p.Asset.SHA256] = true
+		a := ExportAsset{SHA256: p.Asset.SHA256, MIME: p.Asset.MIME, SizeB: p.Asset.SizeB, Filename: p.Filename}
+		if in.Assets == ExportAssetsInline {
+			content, err := s.s3.DownloadFile(ctx, p.Asset.S3Key, in.UserKEK)
+			if err != nil {
+				return nil, fmt.Errorf("download asset %s: %w", p.Asset.S3Key, err)
+			}
+			a.Content = content
+		} else if pu, ok := urls[p.Asset.SHA256]; ok {
+			a.URL, a.ExpireAt = pu.URL, &pu.ExpireAt
+		}
+		assets = append(assets, a)
+	}
+	return assets, nil
+}
+
+func deletedAt(d gorm.DeletedAt) *time.Time {
+	if !d.Valid {
+		return nil
+	}
+	return &d.Time
+}
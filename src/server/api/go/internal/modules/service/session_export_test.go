@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/paging"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+func TestExportCursor(t *testing.T) {
+	pos := exportPosition{SessionCreatedAt: time.Date(2025, 3, 1, 12, 0, 0, 1000, time.UTC), SessionID: uuid.New(), MessageSeq: 7, MessageID: uuid.New()}
+	got, err := decodeExportCursor(pos.cursor())
+	require.NoError(t, err)
+	assert.True(t, got.SessionCreatedAt.Equal(pos.SessionCreatedAt))
+	assert.Equal(t, pos.SessionID, got.SessionID)
+	assert.Equal(t, pos.MessageSeq, got.MessageSeq)
+	assert.Equal(t, pos.MessageID, got.MessageID)
+
+	for _, bad := range []string{"!!", paging.EncodeCursor(time.Now(), uuid.New()), paging.EncodeSeqCursor(3, uuid.New())} {
+		_, err := decodeExportCursor(bad)
+		assert.ErrorIs(t, err, paging.ErrInvalidCursor, bad)
+	}
+}
+
+func TestSessionService_ExportProject(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Sessions created together are ordered by id.
+	s0 := model.Session{ID: uuid.MustParse("00000000-0000-0000-0000-000000000001"), ProjectID: projectID, CreatedAt: created}
+	s1 := model.Session{ID: uuid.MustParse("00000000-0000-0000-0000-000000000002"), ProjectID: projectID, CreatedAt: created}
+	s2 := model.Session{ID: uuid.New(), ProjectID: projectID, CreatedAt: created.Add(time.Minute), IsTemplate: true}
+
+	image := model.Asset{SHA256: "img-sha", S3Key: "assets/img.png", MIME: "image/png", SizeB: 10}
+	partsMeta := datatypes.NewJSONType(model.Asset{SHA256: "export-sha", S3Key: "parts/export-sha.json"})
+	m1 := model.Message{ID: uuid.New(), SessionID: s1.ID, Seq: 1, Role: model.RoleUser, PartsAssetMeta: partsMeta}
+	m2 := model.Message{ID: uuid.New(), SessionID: s1.ID, Seq: 2, Role: model.RoleAssistant, PartsAssetMeta: partsMeta}
+
+	newService := func(t *testing.T, mockRepo *MockSessionRepo) SessionService {
+		rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		data, err := json.Marshal([]model.Part{model.NewTextPart("look"), model.NewAssetPart(image, "a.png"), model.NewAssetPart(image, "a.png")})
+		require.NoError(t, err)
+		require.NoError(t, rdb.Set(ctx, "message:parts:"+projectID.String()+":export-sha", append([]byte{0x00}, data...), time.Hour).Err())
+		return NewSessionService(mockRepo, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, &config.Config{}, rdb, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+	collect := func(svc SessionService, in ExportProjectInput) ([]*ExportRecord, error) {
+		var recs []*ExportRecord
+		err := svc.ExportProject(ctx, in, func(rec *ExportRecord) error {
+			recs = append(recs, rec)
+			return nil
+		})
+		return recs, err
+	}
+	types := func(recs []*ExportRecord) []string {
+		out := make([]string, len(recs))
+		for i, rec := range recs {
+			out[i] = rec.Type
+		}
+		return out
+	}
+
+	var cursorAfterM1 string
+	t.Run("full export", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("ListSessionsForExport", ctx, projectID, time.Time{}, uuid.Nil, exportSessionPageSize, false).Return([]model.Session{s1, s2}, nil)
+		mockRepo.On("ListMessagesForExport", ctx, s1.ID, int64(0), uuid.Nil, exportMessagePageSize, false).Return([]model.Message{m1, m2}, nil)
+		mockRepo.On("ListMessagesForExport", ctx, s2.ID, int64(0), uuid.Nil, exportMessagePageSize, false).Return([]model.Message{}, nil)
+
+		recs, err := collect(newService(t, mockRepo), ExportProjectInput{ProjectID: projectID})
+		require.NoError(t, err)
+		require.Equal(t, []string{ExportRecordSession, ExportRecordMessage, ExportRecordMessage, ExportRecordSession, ExportRecordEnd}, types(recs))
+		assert.Equal(t, s1.ID, recs[0].Session.ID)
+		assert.Equal(t, m1.ID, recs[1].Message.ID)
+		assert.Len(t, recs[1].Message.Parts, 3)
+		assert.Equal(t, []ExportAsset{{SHA256: "img-sha", MIME: "image/png", SizeB: 10, Filename: "a.png"}}, recs[1].Assets, "each asset is listed once")
+		assert.Equal(t, s2.ID, recs[3].Session.ID)
+		assert.Empty(t, recs[4].Cursor)
+		cursorAfterM1 = recs[1].Cursor
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("resume after a message", func(t *testing.T) {
+		require.NotEmpty(t, cursorAfterM1)
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("ListSessionsForExport", ctx, projectID, mock.MatchedBy(func(at time.Time) bool { return at.Equal(created) }), uuid.Nil, exportSessionPageSize, false).
+			Return([]model.Session{s0, s1, s2}, nil)
+		mockRepo.On("ListMessagesForExport", ctx, s1.ID, m1.Seq, m1.ID, exportMessagePageSize, false).Return([]model.Message{m2}, nil)
+		mockRepo.On("ListMessagesForExport", ctx, s2.ID, int64(0), uuid.Nil, exportMessagePageSize, false).Return([]model.Message{}, nil)
+
+		recs, err := collect(newService(t, mockRepo), ExportProjectInput{ProjectID: projectID, Cursor: cursorAfterM1})
+		require.NoError(t, err)
+		require.Equal(t, []string{ExportRecordMessage, ExportRecordSession, ExportRecordEnd}, types(recs), "sessions before the cursor are skipped")
+		assert.Equal(t, m2.ID, recs[0].Message.ID)
+		assert.Equal(t, s2.ID, recs[1].Session.ID)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("deleted rows are marked", func(t *testing.T) {
+		deleted := s2
+		deleted.DeletedAt.Time, deleted.DeletedAt.Valid = created.Add(time.Hour), true
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("ListSessionsForExport", ctx, projectID, time.Time{}, uuid.Nil, exportSessionPageSize, true).Return([]model.Session{deleted}, nil)
+		mockRepo.On("ListMessagesForExport", ctx, s2.ID, int64(0), uuid.Nil, exportMessagePageSize, true).Return([]model.Message{}, nil)
+
+		recs, err := collect(newService(t, mockRepo), ExportProjectInput{ProjectID: projectID, IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Len(t, recs, 2)
+		require.NotNil(t, recs[0].DeletedAt)
+		assert.True(t, recs[0].DeletedAt.Equal(deleted.DeletedAt.Time))
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		recs, err := collect(newService(t, mockRepo), ExportProjectInput{ProjectID: projectID, Cursor: "not-a-cursor"})
+		assert.ErrorIs(t, err, paging.ErrInvalidCursor)
+		assert.Empty(t, recs)
+		mockRepo.AssertNotCalled(t, "ListSessionsForExport", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"gorm.io/gorm"
+)
+
+// ImmutabilityOverride lets an admin change through when the session is finalized or the
+// message is past the immutability window. Each use is recorded as a model.ImmutabilityOverride.
+type ImmutabilityOverride struct {
+	Reason string
+}
+
+// FinalizeSession locks the session so no messages can be added to it and its messages can no
+// longer be edited or deleted; see repo.SessionRepo.FinalizeSession. Finalizing is permanent.
+func (s *sessionService) FinalizeSession(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) (*model.Session, error) {
+	if err := s.checkSessionProject(ctx, projectID, sessionID); err != nil {
+		return nil, err
+	}
+	session, err := s.sessionRepo.FinalizeSession(ctx, sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrSessionNotFound
+		case errors.Is(err, repo.ErrMessageStreaming):
+			return nil, ErrMessageStreaming
+		}
+		return nil, fmt.Errorf("finalize session: %w", err)
+	}
+	return session, nil
+}
+
+// ListImmutabilityOverrides returns the overrides recorded for the session, oldest first. The
+// session is not looked up, so the log of a deleted session can still be read.
+func (s *sessionService) ListImmutabilityOverrides(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID) ([]model.ImmutabilityOverride, error) {
+	return s.sessionRepo.ListImmutabilityOverrides(ctx, projectID, sessionID)
+}
+
+// messageWindow is how long after creation a message can still be changed; zero disables the
+// window.
+func (s *sessionService) messageWindow() time.Duration {
+	if s.cfg == nil || s.cfg.Immutability.MessageWindowSec <= 0 {
+		return 0
+	}
+	return time.Duration(s.cfg.Immutability.MessageWindowSec) * time.Second
+}
+
+// checkMutable checks a change to messages of the session created at createdAt against the
+// session's finalization and the immutability window, failing with ErrSessionFinalized or
+// ErrMessageImmutable. A change that would fail goes through with an override, which is recorded
+// before the change is applied, so a change that fails later still leaves its override on record.
+// messageID names the message changed; it is nil for changes to several messages.
+func (s *sessionService) checkMutable(ctx context.Context, session *model.Session, messageID *uuid.UUID, action string, override *ImmutabilityOverride, createdAt ...time.Time) error {
+	err := s.immutableErr(session, createdAt...)
+	if err == nil || override == nil {
+		return err
+	}
+	reason := strings.TrimSpace(override.Reason)
+	if reason == "" {
+		return ErrInvalidOverride
+	}
+	return s.sessionRepo.CreateImmutabilityOverride(ctx, &model.ImmutabilityOverride{
+		ProjectID: session.ProjectID,
+		SessionID: session.ID,
+		MessageID: messageID,
+		Action:    action,
+		Reason:    reason,
+	})
+}
+
+func (s *sessionService) immutableErr(session *model.Session, createdAt ...time.Time) error {
+	if session.FinalizedAt != nil {
+		return ErrSessionFinalized
+	}
+	window := s.messageWindow()
+	if window == 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-window)
+	for _, t := range createdAt {
+		if t.Before(cutoff) {
+			return ErrMessageImmutable
+		}
+	}
+	return nil
+}
+
+// checkSessionDeletable checks deleting the whole session with checkMutable, against the age of
+// its oldest message.
+func (s *sessionService) checkSessionDeletable(ctx context.Context, projectID uuid.UUID, sessionID uuid.UUID, action string, override *ImmutabilityOverride) error {
+	session, err := s.getSessionInProject(ctx, projectID, sessionID)
+	if err != nil {
+		return err
+	}
+	var createdAt []time.Time
+	if s.needsMessageAge(session) {
+		oldest, err := s.sessionRepo.OldestMessageCreatedAt(ctx, sessionID)
+		if err != nil {
+			return fmt.Errorf("get oldest message: %w", err)
+		}
+		if oldest != nil {
+			createdAt = append(createdAt, *oldest)
+		}
+	}
+	return s.checkMutable(ctx, session, nil, action, override, createdAt...)
+}
+
+// needsMessageAge reports whether checkMutable needs the creation time of the messages changed,
+// so callers only load them while a window applies.
+func (s *sessionService) needsMessageAge(session *model.Session) bool {
+	return session.FinalizedAt == nil && s.messageWindow() > 0
+}
+
+// messageAge returns the creation time of the message for checkMutable, or nothing when no window
+// applies and the message need not be loaded.
+func (s *sessionService) messageAge(ctx context.Context, session *model.Session, messageID uuid.UUID) ([]time.Time, error) {
+	if !s.needsMessageAge(session) {
+		return nil, nil
+	}
+	msg, err := s.sessionRepo.GetMessageByID(ctx, session.ID, messageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return []time.Time{msg.CreatedAt}, nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSessionService_Immutability(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	messageID := uuid.New()
+	finalizedAt := time.Now().Add(-time.Hour)
+	windowed := &config.Config{Immutability: config.ImmutabilityCfg{MessageWindowSec: 3600}}
+
+	newSvc := func(mockRepo *MockSessionRepo, cfg *config.Config) SessionService {
+		return NewSessionService(mockRepo, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+
+	t.Run("finalized session rejects deletes", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID, FinalizedAt: &finalizedAt}, nil)
+
+		err := newSvc(mockRepo, &config.Config{}).DeleteMessage(ctx, projectID, sessionID, messageID, nil)
+		assert.ErrorIs(t, err, ErrSessionFinalized)
+		mockRepo.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("finalized session rejects new messages", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID, FinalizedAt: &finalizedAt}, nil)
+
+		_, err := newSvc(mockRepo, &config.Config{}).StoreMessage(ctx, StoreMessageInput{ProjectID: projectID, SessionID: sessionID, Role: model.RoleUser})
+		assert.ErrorIs(t, err, ErrSessionFinalized)
+	})
+
+	t.Run("override is recorded", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID, FinalizedAt: &finalizedAt}, nil)
+		mockRepo.On("CreateImmutabilityOverride", ctx, mock.MatchedBy(func(o *model.ImmutabilityOverride) bool {
+			return o.ProjectID == projectID && o.SessionID == sessionID && o.MessageID != nil && *o.MessageID == messageID &&
+				o.Action == model.OverrideRestoreMessage && o.Reason == "restore after review"
+		})).Return(nil)
+		mockRepo.On("RestoreMessage", ctx, sessionID, messageID).Return(nil)
+
+		err := newSvc(mockRepo, &config.Config{}).RestoreMessage(ctx, projectID, sessionID, messageID, &ImmutabilityOverride{Reason: " restore after review "})
+		require.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("override needs a reason", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID, FinalizedAt: &finalizedAt}, nil)
+
+		err := newSvc(mockRepo, &config.Config{}).DeleteMessage(ctx, projectID, sessionID, messageID, &ImmutabilityOverride{Reason: "  "})
+		assert.ErrorIs(t, err, ErrInvalidOverride)
+		mockRepo.AssertNotCalled(t, "CreateImmutabilityOverride", mock.Anything, mock.Anything)
+	})
+
+	t.Run("window rejects old messages", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		mockRepo.On("GetMessageByID", ctx, sessionID, messageID).Return(&model.Message{ID: messageID, CreatedAt: time.Now().Add(-2 * time.Hour)}, nil)
+
+		err := newSvc(mockRepo, windowed).DeleteMessage(ctx, projectID, sessionID, messageID, nil)
+		assert.ErrorIs(t, err, ErrMessageImmutable)
+		mockRepo.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("window allows recent messages", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		mockRepo.On("GetMessageByID", ctx, sessionID, messageID).Return(&model.Message{ID: messageID, CreatedAt: time.Now().Add(-time.Minute)}, nil)
+		mockRepo.On("DeleteMessage", ctx, sessionID, messageID).Return(nil)
+
+		require.NoError(t, newSvc(mockRepo, windowed).DeleteMessage(ctx, projectID, sessionID, messageID, nil))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("bulk delete needs since within window", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		since := time.Now().Add(-time.Minute)
+		mockRepo.On("DeleteMessages", ctx, sessionID, mock.Anything, false).Return(&repo.DeleteMessagesResult{Deleted: 1}, nil)
+		svc := newSvc(mockRepo, windowed)
+
+		_, err := svc.DeleteMessages(ctx, DeleteMessagesInput{ProjectID: projectID, SessionID: sessionID, Roles: []string{model.RoleUser}})
+		assert.ErrorIs(t, err, ErrMessageImmutable)
+
+		out, err := svc.DeleteMessages(ctx, DeleteMessagesInput{ProjectID: projectID, SessionID: sessionID, Since: since})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), out.Deleted)
+	})
+
+	t.Run("finalized session is only deleted with an override", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID, FinalizedAt: &finalizedAt}, nil)
+		svc := newSvc(mockRepo, &config.Config{})
+
+		assert.ErrorIs(t, svc.Delete(ctx, projectID, sessionID, nil, nil), ErrSessionFinalized)
+		_, err := svc.DeleteSessionCascade(ctx, projectID, sessionID, nil, nil)
+		assert.ErrorIs(t, err, ErrSessionFinalized)
+		mockRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		mockRepo.AssertNotCalled(t, "DeleteSessionCascade", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		mockRepo.On("CreateImmutabilityOverride", ctx, mock.MatchedBy(func(o *model.ImmutabilityOverride) bool {
+			return o.SessionID == sessionID && o.MessageID == nil && o.Action == model.OverridePurgeSession && o.Reason == "gdpr request"
+		})).Return(nil)
+		mockRepo.On("DeleteSessionCascade", ctx, projectID, sessionID, []byte(nil)).Return(&repo.DeleteSessionCascadeResult{Messages: 2}, nil)
+		out, err := svc.DeleteSessionCascade(ctx, projectID, sessionID, nil, &ImmutabilityOverride{Reason: "gdpr request"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), out.Messages)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("window rejects deleting a session with old messages", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		oldest := time.Now().Add(-2 * time.Hour)
+		mockRepo.On("OldestMessageCreatedAt", ctx, sessionID).Return(&oldest, nil)
+		svc := newSvc(mockRepo, windowed)
+
+		assert.ErrorIs(t, svc.Delete(ctx, projectID, sessionID, nil, nil), ErrMessageImmutable)
+		mockRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		mockRepo.On("CreateImmutabilityOverride", ctx, mock.MatchedBy(func(o *model.ImmutabilityOverride) bool {
+			return o.Action == model.OverrideDeleteSession
+		})).Return(nil)
+		mockRepo.On("Delete", ctx, projectID, sessionID, []byte(nil)).Return(nil)
+		require.NoError(t, svc.Delete(ctx, projectID, sessionID, nil, &ImmutabilityOverride{Reason: "cleanup"}))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("window allows deleting an empty session", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		mockRepo.On("OldestMessageCreatedAt", ctx, sessionID).Return(nil, nil)
+		mockRepo.On("Delete", ctx, projectID, sessionID, []byte(nil)).Return(nil)
+
+		require.NoError(t, newSvc(mockRepo, windowed).Delete(ctx, projectID, sessionID, nil, nil))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("repair checks orphan age", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		mockRepo.On("ValidateTree", ctx, sessionID).Return([]repo.BrokenParentLink{{MessageID: messageID, CreatedAt: time.Now().Add(-2 * time.Hour)}}, nil)
+
+		_, err := newSvc(mockRepo, windowed).RepairTree(ctx, RepairTreeInput{ProjectID: projectID, SessionID: sessionID, Mode: repo.TreeRepairDelete})
+		assert.ErrorIs(t, err, ErrMessageImmutable)
+		mockRepo.AssertNotCalled(t, "RepairTree", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestSessionService_FinalizeSession(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	tests := []struct {
+		name    string
+		repoErr error
+		wantErr error
+	}{
+		{"finalizes", nil, nil},
+		{"streaming message", repo.ErrMessageStreaming, ErrMessageStreaming},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &MockSessionRepo{}
+			mockRepo.On("Get", ctx, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+			now := time.Now()
+			if tc.repoErr != nil {
+				mockRepo.On("FinalizeSession", ctx, sessionID).Return(nil, tc.repoErr)
+			} else {
+				mockRepo.On("FinalizeSession", ctx, sessionID).Return(&model.Session{ID: sessionID, FinalizedAt: &now}, nil)
+			}
+			svc := NewSessionService(mockRepo, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, &config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+			session, err := svc.FinalizeSession(ctx, projectID, sessionID)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, session.FinalizedAt)
+		})
+	}
+}
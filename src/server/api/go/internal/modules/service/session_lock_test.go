@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestSessionServiceWithLocks(t *testing.T, mockRepo *MockSessionRepo) (SessionService, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	locks := repo.NewSessionLockRepo(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	cfg := &config.Config{SessionLock: config.SessionLockCfg{DefaultTTLSec: 60, MaxTTLSec: 3600}}
+	svc := NewSessionService(mockRepo, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, locks)
+	return svc, mr
+}
+
+func TestSessionService_SessionLock(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	newSvc := func(t *testing.T) (SessionService, *miniredis.Miniredis) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("Get", mock.Anything, &model.Session{ID: sessionID}).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		return newTestSessionServiceWithLocks(t, mockRepo)
+	}
+	acquire := func(svc SessionService, holder string, ttl time.Duration) (*model.SessionLock, error) {
+		return svc.AcquireSessionLock(ctx, AcquireSessionLockInput{ProjectID: projectID, SessionID: sessionID, HolderID: holder, TTL: ttl})
+	}
+
+	t.Run("contention", func(t *testing.T) {
+		svc, _ := newSvc(t)
+
+		lock, err := acquire(svc, "agent-a", 0)
+		require.NoError(t, err)
+		assert.Equal(t, "agent-a", lock.HolderID)
+
+		_, err = acquire(svc, "agent-b", 0)
+		var locked *SessionLockError
+		require.ErrorAs(t, err, &locked)
+		assert.ErrorIs(t, err, ErrSessionLockNotHeld)
+		assert.Equal(t, "agent-a", locked.Lock.HolderID)
+
+		assert.ErrorIs(t, svc.ReleaseSessionLock(ctx, projectID, sessionID, "agent-b"), ErrSessionLockNotHeld)
+		require.NoError(t, svc.ReleaseSessionLock(ctx, projectID, sessionID, "agent-a"))
+
+		lock, err = acquire(svc, "agent-b", 0)
+		require.NoError(t, err)
+		assert.Equal(t, "agent-b", lock.HolderID)
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		svc, mr := newSvc(t)
+
+		_, err := acquire(svc, "agent-a", 5*time.Second)
+		require.NoError(t, err)
+		mr.FastForward(6 * time.Second)
+
+		lock, err := svc.GetSessionLock(ctx, projectID, sessionID)
+		require.NoError(t, err)
+		assert.Nil(t, lock)
+		_, err = acquire(svc, "agent-b", 0)
+		require.NoError(t, err, "a crashed holder does not block the session")
+	})
+
+	t.Run("validates input", func(t *testing.T) {
+		svc, _ := newSvc(t)
+
+		_, err := acquire(svc, " ", 0)
+		assert.ErrorIs(t, err, ErrInvalidLockHolder)
+		_, err = acquire(svc, "agent-a", 2*time.Hour)
+		assert.ErrorIs(t, err, ErrInvalidLockTTL)
+		_, err = acquire(svc, "agent-a", -time.Second)
+		assert.ErrorIs(t, err, ErrInvalidLockTTL)
+	})
+
+	t.Run("other project", func(t *testing.T) {
+		svc, _ := newSvc(t)
+
+		_, err := svc.AcquireSessionLock(ctx, AcquireSessionLockInput{ProjectID: uuid.New(), SessionID: sessionID, HolderID: "agent-a"})
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("unavailable without redis", func(t *testing.T) {
+		svc := NewSessionService(&MockSessionRepo{}, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, &config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+		_, err := acquire(svc, "agent-a", 0)
+		assert.ErrorIs(t, err, ErrSessionLocksUnavailable)
+	})
+}
+
+func TestSessionService_StoreMessage_RequiresLock(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	sessionID := uuid.New()
+
+	mockRepo := &MockSessionRepo{}
+	mockRepo.On("Get", mock.Anything, &model.Session{ID: sessionID}).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+	svc, _ := newTestSessionServiceWithLocks(t, mockRepo)
+	_, err := svc.AcquireSessionLock(ctx, AcquireSessionLockInput{ProjectID: projectID, SessionID: sessionID, HolderID: "agent-a"})
+	require.NoError(t, err)
+
+	for _, holder := range []string{"agent-b", "agent-a "} {
+		_, err = svc.StoreMessage(ctx, StoreMessageInput{
+			ProjectID:    projectID,
+			SessionID:    sessionID,
+			Role:         "user",
+			Parts:        []PartIn{{Type: "text", Text: "hi"}},
+			LockHolderID: holder,
+		})
+		var locked *SessionLockError
+		require.ErrorAs(t, err, &locked, holder)
+		assert.Equal(t, "agent-a", locked.Lock.HolderID)
+	}
+	mockRepo.AssertNotCalled(t, "CreateWithAssetRefs")
+}
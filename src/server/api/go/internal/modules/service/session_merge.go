@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"gorm.io/gorm"
+)
+
+type MergeSessionsInput struct {
+	ProjectID uuid.UUID
+	TargetID  uuid.UUID
+	SourceIDs []uuid.UUID
+	// Mode is where the roots of the sources go; empty attaches them under the target's leaf.
+	Mode repo.SessionMergeMode
+}
+
+// MergeSessions moves the messages of SourceIDs into TargetID and soft-deletes the sources; see
+// repo.SessionRepo.MergeSessions.
+func (s *sessionService) MergeSessions(ctx context.Context, in MergeSessionsInput) (*repo.MergeSessionsResult, error) {
+	if in.Mode == "" {
+		in.Mode = repo.SessionMergeAttach
+	}
+	if in.Mode != repo.SessionMergeAttach && in.Mode != repo.SessionMergeBranches {
+		return nil, ErrInvalidMergeMode
+	}
+	if len(in.SourceIDs) == 0 {
+		return nil, ErrNoMergeSources
+	}
+	result, err := s.sessionRepo.MergeSessions(ctx, in.ProjectID, in.TargetID, in.SourceIDs, in.Mode)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrSessionNotFound
+		case errors.Is(err, repo.ErrMergeIntoSelf):
+			return nil, ErrMergeIntoSelf
+		case errors.Is(err, repo.ErrSessionArchived):
+			return nil, ErrSessionArchived
+		case errors.Is(err, repo.ErrSessionFinalized):
+			return nil, ErrSessionFinalized
+		case errors.Is(err, repo.ErrMessageStreaming):
+			return nil, ErrMessageStreaming
+		case errors.Is(err, repo.ErrSessionShared):
+			return nil, ErrSessionShared
+		}
+		return nil, fmt.Errorf("merge sessions: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func TestSessionService_MergeSessions(t *testing.T) {
+	ctx := context.Background()
+	projectID := uuid.New()
+	targetID := uuid.New()
+	sources := []uuid.UUID{uuid.New(), uuid.New()}
+
+	newSvc := func(mockRepo *MockSessionRepo) SessionService {
+		return NewSessionService(mockRepo, nil, &MockAssetReferenceRepo{}, nil, zap.NewNop(), nil, nil, &config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+
+	t.Run("defaults to attach", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		mockRepo.On("MergeSessions", ctx, projectID, targetID, sources, repo.SessionMergeAttach).
+			Return(&repo.MergeSessionsResult{Session: &model.Session{ID: targetID}, Moved: 3}, nil)
+
+		result, err := newSvc(mockRepo).MergeSessions(ctx, MergeSessionsInput{ProjectID: projectID, TargetID: targetID, SourceIDs: sources})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), result.Moved)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("validates input", func(t *testing.T) {
+		mockRepo := &MockSessionRepo{}
+		svc := newSvc(mockRepo)
+
+		_, err := svc.MergeSessions(ctx, MergeSessionsInput{ProjectID: projectID, TargetID: targetID})
+		assert.ErrorIs(t, err, ErrNoMergeSources)
+		_, err = svc.MergeSessions(ctx, MergeSessionsInput{ProjectID: projectID, TargetID: targetID, SourceIDs: sources, Mode: "zip"})
+		assert.ErrorIs(t, err, ErrInvalidMergeMode)
+		mockRepo.AssertNotCalled(t, "MergeSessions")
+	})
+
+	tests := []struct {
+		name    string
+		repoErr error
+		wantErr error
+	}{
+		{"not found", gorm.ErrRecordNotFound, ErrSessionNotFound},
+		{"into itself", repo.ErrMergeIntoSelf, ErrMergeIntoSelf},
+		{"archived", repo.ErrSessionArchived, ErrSessionArchived},
+		{"finalized", repo.ErrSessionFinalized, ErrSessionFinalized},
+		{"streaming", repo.ErrMessageStreaming, ErrMessageStreaming},
+		{"shared", repo.ErrSessionShared, ErrSessionShared},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &MockSessionRepo{}
+			mockRepo.On("MergeSessions", ctx, projectID, targetID, sources, repo.SessionMergeBranches).Return(nil, tc.repoErr)
+
+			_, err := newSvc(mockRepo).MergeSessions(ctx, MergeSessionsInput{
+				ProjectID: projectID, TargetID: targetID, SourceIDs: sources, Mode: repo.SessionMergeBranches,
+			})
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/jsonpatch"
+)
+
+// canReadSensitive reports whether the principal ctx serves may read sensitive parts; see
+// model.ScopesFromContext.
+func canReadSensitive(ctx context.Context) bool {
+	return slices.Contains(model.ScopesFromContext(ctx), model.ScopeReadSensitive)
+}
+
+// redactMessages applies model.RedactForPrincipal to msgs with the scopes ctx carries, and drops
+// the entries of urls, keyed by asset SHA256, that only redacted parts used. Every method
+// returning message content calls it, so REST and gRPC callers read alike.
+func redactMessages(ctx context.Context, msgs []model.Message, urls map[string]PublicURL) {
+	if canReadSensitive(ctx) {
+		return
+	}
+	for i := range msgs {
+		msgs[i] = model.RedactForPrincipal(msgs[i], nil)
+	}
+	pruneRedactedURLs(msgs, urls)
+}
+
+// redactMessage is redactMessages for a single message; msg may be nil.
+func redactMessage(ctx context.Context, msg *model.Message) *model.Message {
+	if msg == nil || canReadSensitive(ctx) {
+		return msg
+	}
+	redacted := model.RedactForPrincipal(*msg, nil)
+	return &redacted
+}
+
+// redactThread is redactMessages for the messages of a thread or branch diff.
+func redactThread(ctx context.Context, items []ThreadMessage) {
+	if canReadSensitive(ctx) {
+		return
+	}
+	for i := range items {
+		items[i].Message = model.RedactForPrincipal(items[i].Message, nil)
+	}
+}
+
+func pruneRedactedURLs(msgs []model.Message, urls map[string]PublicURL) {
+	if len(urls) == 0 {
+		return
+	}
+	used := map[string]bool{}
+	for _, m := range msgs {
+		for _, p := range m.Parts {
+			if p.Asset != nil {
+				used[p.Asset.SHA256] = true
+			}
+		}
+	}
+	for sha := range urls {
+		if !used[sha] {
+			delete(urls, sha)
+		}
+	}
+}
+
+// checkSensitivePatch is the jsonpatch.Check PatchMessageParts runs for a principal without
+// model.ScopeReadSensitive. Such a principal reads sensitive parts redacted, so it may not read
+// them through the patch either (copy or move from them, test them) nor change them, their
+// sensitive flag included; it may still insert parts next to them. Operations are checked
+// against the parts as the earlier operations left them, so indices cannot be shifted past the
+// check. An operation on the whole array is refused while it holds a sensitive part.
+func checkSensitivePatch(doc any, op jsonpatch.Operation) error {
+	parts, _ := doc.([]any)
+	addresses := func(pointer string, insert bool) error {
+		tokens, err := jsonpatch.ParsePointer(pointer)
+		if err != nil {
+			// Left for the patch to reject.
+			return nil
+		}
+		if len(tokens) == 0 {
+			if slices.ContainsFunc(parts, isSensitivePartValue) {
+				return fmt.Errorf("%w: the parts hold a sensitive part", ErrPatchSensitive)
+			}
+			return nil
+		}
+		if insert && len(tokens) == 1 {
+			return nil
+		}
+		i, err := strconv.Atoi(tokens[0])
+		if err != nil || i < 0 || i >= len(parts) {
+			return nil
+		}
+		if isSensitivePartValue(parts[i]) {
+			return fmt.Errorf("%w: part %d", ErrPatchSensitive, i)
+		}
+		return nil
+	}
+
+	insert := op.Op == "add" || op.Op == "move" || op.Op == "copy"
+	if err := addresses(op.Path, insert); err != nil {
+		return err
+	}
+	if op.Op == "move" || op.Op == "copy" {
+		return addresses(op.From, false)
+	}
+	return nil
+}
+
+// isSensitivePartValue is model.Part.IsSensitive for a part decoded by jsonpatch.
+func isSensitivePartValue(v any) bool {
+	part, _ := v.(map[string]any)
+	meta, _ := part["meta"].(map[string]any)
+	sensitive, _ := meta[string(model.MetaKeySensitive)].(bool)
+	return sensitive
+}
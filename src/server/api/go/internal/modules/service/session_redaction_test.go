@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestSessionService_Redaction(t *testing.T) {
+	projectID := uuid.New()
+	sessionID := uuid.New()
+	messageID := uuid.New()
+	sensitive := map[string]any{model.MetaKeySensitive: true}
+	secretImg := &model.Asset{SHA256: "secret-sha", S3Key: "assets/secret.png", MIME: "image/png"}
+	stored := []model.Part{
+		{Type: model.PartTypeText, Text: "visible"},
+		{Type: model.PartTypeText, Text: "confidential", Meta: sensitive},
+		{Type: model.PartTypeImage, Asset: secretImg, Meta: sensitive},
+	}
+	partsMeta := datatypes.NewJSONType(model.Asset{SHA256: "parts-sha", S3Key: "parts/parts-sha.json"})
+	message := model.Message{ID: messageID, SessionID: sessionID, Role: model.RoleUser, PartsAssetMeta: partsMeta, CreatedAt: time.Now()}
+
+	// newSvc serves the message above, its parts cached so no S3 is needed. Material URLs are
+	// only expected for assets the caller may read.
+	newSvc := func(t *testing.T, material *MockMaterialService) *sessionService {
+		svc, _ := newTestSessionServiceWithRedis(t)
+		require.NoError(t, svc.cachePartsInRedis(context.Background(), projectID.String(), "parts-sha", stored, nil))
+		r := &MockSessionRepo{}
+		r.On("Get", mock.Anything, mock.Anything).Return(&model.Session{ID: sessionID, ProjectID: projectID}, nil)
+		r.On("ListAllMessagesBySession", mock.Anything, sessionID, []string(nil), []string(nil), allTime, anyAuthor, anyAnnotation).
+			Return([]model.Message{message}, nil)
+		r.On("GetMessageThread", mock.Anything, sessionID, messageID).Return([]model.Message{message}, nil)
+		r.On("GetMessageByID", mock.Anything, sessionID, messageID).Return(&message, nil)
+		r.On("ListMessageRevisions", mock.Anything, messageID).Return([]model.MessageRevision{
+			{MessageID: messageID, Version: 1, PartsAssetMeta: partsMeta},
+		}, nil)
+		r.On("ListPinnedMessages", mock.Anything, sessionID).Return([]model.Message{message}, nil)
+		svc.sessionRepo = r
+		if material != nil {
+			svc.materialSvc = material
+		}
+		return svc
+	}
+	texts := func(parts []model.Part) []string {
+		out := make([]string, len(parts))
+		for i, p := range parts {
+			out[i] = p.Text
+		}
+		return out
+	}
+	redacted := []string{"visible", model.RedactedText, model.RedactedText}
+
+	reads := []struct {
+		name  string
+		parts func(ctx context.Context, svc *sessionService) ([]model.Part, error)
+	}{
+		{"GetMessages", func(ctx context.Context, svc *sessionService) ([]model.Part, error) {
+			out, err := svc.GetMessages(ctx, GetMessagesInput{ProjectID: projectID, SessionID: sessionID})
+			if err != nil {
+				return nil, err
+			}
+			return out.Items[0].Parts, nil
+		}},
+		{"GetAllMessages", func(ctx context.Context, svc *sessionService) ([]model.Part, error) {
+			msgs, err := svc.GetAllMessages(ctx, projectID, sessionID, nil)
+			if err != nil {
+				return nil, err
+			}
+			return msgs[0].Parts, nil
+		}},
+		{"GetMessageThread", func(ctx context.Context, svc *sessionService) ([]model.Part, error) {
+			items, err := svc.GetMessageThread(ctx, GetMessageThreadInput{ProjectID: projectID, SessionID: sessionID, MessageID: messageID})
+			if err != nil {
+				return nil, err
+			}
+			return items[0].Parts, nil
+		}},
+		{"DiffBranches", func(ctx context.Context, svc *sessionService) ([]model.Part, error) {
+			out, err := svc.DiffBranches(ctx, DiffBranchesInput{ProjectID: projectID, SessionID: sessionID, LeafA: messageID, LeafB: messageID})
+			if err != nil {
+				return nil, err
+			}
+			return out.ForkPoint.Parts, nil
+		}},
+		{"GetMessageRevisions", func(ctx context.Context, svc *sessionService) ([]model.Part, error) {
+			revs, err := svc.GetMessageRevisions(ctx, GetMessageRevisionsInput{ProjectID: projectID, SessionID: sessionID, MessageID: messageID})
+			if err != nil {
+				return nil, err
+			}
+			return revs[0].Parts, nil
+		}},
+		{"ListPinnedMessages", func(ctx context.Context, svc *sessionService) ([]model.Part, error) {
+			msgs, err := svc.ListPinnedMessages(ctx, projectID, sessionID, nil)
+			if err != nil {
+				return nil, err
+			}
+			return msgs[0].Parts, nil
+		}},
+	}
+	for _, read := range reads {
+		t.Run(read.name, func(t *testing.T) {
+			parts, err := read.parts(context.Background(), newSvc(t, nil))
+			require.NoError(t, err)
+			assert.Equal(t, redacted, texts(parts), "a context without scopes reads redacted")
+			assert.True(t, parts[2].GetMetaBool(model.MetaKeyRedacted))
+			assert.Nil(t, parts[2].Asset)
+
+			full := model.WithScopes(context.Background(), model.Scopes)
+			parts, err = read.parts(full, newSvc(t, nil))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"visible", "confidential", ""}, texts(parts))
+			assert.Equal(t, secretImg, parts[2].Asset)
+		})
+	}
+
+	t.Run("no material url for a redacted asset", func(t *testing.T) {
+		material := &MockMaterialService{}
+		ctx := model.WithScopes(context.Background(), []string{})
+		out, err := newSvc(t, material).GetMessages(ctx, GetMessagesInput{
+			ProjectID: projectID, SessionID: sessionID, WithAssetPublicURL: true, AssetExpire: time.Hour,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, redacted, texts(out.Items[0].Parts))
+		assert.Empty(t, out.PublicURLs)
+		material.AssertNotCalled(t, "CreateMaterialURL")
+	})
+
+	t.Run("GetMessageParts keeps the places of redacted parts", func(t *testing.T) {
+		out, err := newSvc(t, nil).GetMessageParts(context.Background(), GetMessagePartsInput{
+			ProjectID: projectID, SessionID: sessionID, MessageID: messageID, Type: model.PartTypeImage,
+		})
+		require.NoError(t, err)
+		require.Len(t, out.Items, 1)
+		assert.Equal(t, model.RedactedText, out.Items[0].Text)
+		assert.Nil(t, out.Items[0].Asset)
+	})
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const purgeUploadsLockKey = "purge_uploads:lock" // Distributed pending-upload purge lock
+
+// PendingUploadPurger periodically removes presigned uploads that were never confirmed
+// before they expired, along with any object written to their keys.
+type PendingUploadPurger interface {
+	Start()
+	Stop()
+}
+
+type pendingUploadPurger struct {
+	svc      AssetUploadService
+	redis    *redis.Client
+	log      *zap.Logger
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewPendingUploadPurger(svc AssetUploadService, rdb *redis.Client, cfg *config.Config, log *zap.Logger) PendingUploadPurger {
+	return &pendingUploadPurger{
+		svc:      svc,
+		redis:    rdb,
+		log:      log,
+		interval: time.Duration(cfg.Upload.GCIntervalSec) * time.Second,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins the background purge goroutine. Purging is disabled when the interval is not positive.
+func (p *pendingUploadPurger) Start() {
+	if p.interval <= 0 {
+		close(p.done)
+		return
+	}
+	go p.run()
+}
+
+// Stop signals the purger to exit and waits for an in-flight run to finish.
+func (p *pendingUploadPurger) Stop() {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	close(p.stop)
+	<-p.done
+}
+
+func (p *pendingUploadPurger) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.purgeOnce()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// purgeOnce acquires a distributed lock so only one pod purges per interval.
+func (p *pendingUploadPurger) purgeOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	if p.redis != nil {
+		ok, err := p.redis.SetNX(ctx, purgeUploadsLockKey, "1", p.interval).Result()
+		if err != nil {
+			p.log.Error("PendingUploadPurger: failed to acquire purge lock", zap.Error(err))
+			return
+		}
+		if !ok {
+			return // Another pod purged within this interval.
+		}
+	}
+
+	n, err := p.svc.PurgeExpiredUploads(ctx)
+	if err != nil {
+		p.log.Error("PendingUploadPurger: purge failed", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		p.log.Info("PendingUploadPurger: purged expired uploads", zap.Int64("uploads", n))
+	}
+}
@@ -0,0 +1,349 @@
+// Package bundle reads and writes session bundles: zip archives holding a session's messages
+// together with the asset binaries their parts reference, as a portable backup.
+//
+// A bundle contains messages.json, one file per asset under assets/, and manifest.json, which
+// is written last and lists the SHA-256 and size of every other file.
+package bundle
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+const (
+	// FormatVersion is the bundle layout written by Writer and the only one Reader accepts.
+	FormatVersion = 1
+
+	ManifestPath = "manifest.json"
+	MessagesPath = "messages.json"
+	AssetsDir    = "assets/"
+
+	// MaxMetadataBytes bounds manifest.json and messages.json when reading.
+	MaxMetadataBytes = 256 << 20
+)
+
+// ErrInvalidBundle is returned for archives that are not well-formed bundles.
+var ErrInvalidBundle = errors.New("invalid session bundle")
+
+// Manifest describes a bundle's files.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	SessionID     uuid.UUID `json:"session_id"`
+	ExportedAt    time.Time `json:"exported_at"`
+	MessageCount  int       `json:"message_count"`
+	Files         []File    `json:"files"`
+}
+
+// File is a manifest entry.
+type File struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	SizeB  int64  `json:"size_b"`
+}
+
+// Session is the content of messages.json.
+type Session struct {
+	Configs             map[string]any `json:"configs,omitempty"`
+	Metadata            map[string]any `json:"metadata,omitempty"`
+	Tags                []string       `json:"tags,omitempty"`
+	DisableTaskTracking bool           `json:"disable_task_tracking,omitempty"`
+	Messages            []Message      `json:"messages"`
+}
+
+// Message is a bundled message. ParentID refers to another message of the bundle.
+type Message struct {
+	ID        uuid.UUID      `json:"id"`
+	ParentID  *uuid.UUID     `json:"parent_id,omitempty"`
+	Role      string         `json:"role"`
+	Meta      map[string]any `json:"meta,omitempty"`
+	Pinned    bool           `json:"pinned,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	Parts     []Part         `json:"parts"`
+}
+
+// Part is a bundled part. A part with an asset names its file in AssetPath, relative to the
+// bundle root.
+type Part struct {
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	Filename  string         `json:"filename,omitempty"`
+	Meta      map[string]any `json:"meta,omitempty"`
+	AssetPath string         `json:"asset_path,omitempty"`
+	MIME      string         `json:"mime,omitempty"`
+}
+
+var assetExt = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// AssetPath returns the bundle path of an asset: its content hash under AssetsDir, keeping the
+// extension of its storage key.
+func AssetPath(asset model.Asset) string {
+	ext := strings.ToLower(path.Ext(asset.S3Key))
+	if !assetExt.MatchString(ext) {
+		ext = ""
+	}
+	return AssetsDir + asset.SHA256 + ext
+}
+
+// FromMessages converts messages with loaded parts into their bundled form. It also returns the
+// distinct assets the parts reference, keyed by bundle path. A parent link to a message outside
+// msgs, such as a deleted one, is dropped so the bundle stays self-contained.
+func FromMessages(msgs []model.Message) ([]Message, map[string]model.Asset) {
+	ids := make(map[uuid.UUID]bool, len(msgs))
+	for _, m := range msgs {
+		ids[m.ID] = true
+	}
+	out := make([]Message, 0, len(msgs))
+	assets := make(map[string]model.Asset)
+	for _, m := range msgs {
+		parentID := m.ParentID
+		if parentID != nil && !ids[*parentID] {
+			parentID = nil
+		}
+		bm := Message{
+			ID:        m.ID,
+			ParentID:  parentID,
+			Role:      m.Role,
+			Meta:      m.Meta.Data(),
+			Pinned:    m.Pinned,
+			CreatedAt: m.CreatedAt,
+			Parts:     make([]Part, 0, len(m.Parts)),
+		}
+		for _, p := range m.Parts {
+			bp := Part{Type: p.Type, Text: p.Text, Filename: p.Filename, Meta: p.Meta}
+			if p.Asset != nil && p.Asset.SHA256 != "" {
+				bp.AssetPath = AssetPath(*p.Asset)
+				bp.MIME = p.Asset.MIME
+				assets[bp.AssetPath] = *p.Asset
+			}
+			bm.Parts = append(bm.Parts, bp)
+		}
+		out = append(out, bm)
+	}
+	return out, assets
+}
+
+// Writer streams a bundle into a zip archive. Write the session first, then each asset, and
+// Close to add the manifest.
+type Writer struct {
+	zw       *zip.Writer
+	manifest Manifest
+	written  map[string]bool
+}
+
+func NewWriter(w io.Writer, sessionID uuid.UUID) *Writer {
+	return &Writer{
+		zw: zip.NewWriter(w),
+		manifest: Manifest{
+			FormatVersion: FormatVersion,
+			SessionID:     sessionID,
+			ExportedAt:    time.Now().UTC(),
+			Files:         []File{},
+		},
+		written: make(map[string]bool),
+	}
+}
+
+// WriteSession writes messages.json.
+func (w *Writer) WriteSession(s Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+	w.manifest.MessageCount = len(s.Messages)
+	return w.writeFile(MessagesPath, data)
+}
+
+// WriteAsset writes an asset file at its bundle path. Writing a path twice is a no-op.
+func (w *Writer) WriteAsset(name string, content []byte) error {
+	if w.written[name] {
+		return nil
+	}
+	if !strings.HasPrefix(name, AssetsDir) {
+		return fmt.Errorf("asset path %q is outside %s", name, AssetsDir)
+	}
+	return w.writeFile(name, content)
+}
+
+// Close writes the manifest and finishes the archive. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	data, err := json.Marshal(w.manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	fw, err := w.zw.Create(ManifestPath)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func (w *Writer) writeFile(name string, content []byte) error {
+	fw, err := w.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(content); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	w.manifest.Files = append(w.manifest.Files, File{Path: name, SHA256: hex.EncodeToString(sum[:]), SizeB: int64(len(content))})
+	w.written[name] = true
+	return nil
+}
+
+// Limits bounds what a Reader expands a bundle to, whatever sizes the manifest claims. Zero
+// disables a limit.
+type Limits struct {
+	// MaxFileBytes bounds the uncompressed size of each file.
+	MaxFileBytes int64
+	// MaxTotalBytes bounds the uncompressed size of all listed files together.
+	MaxTotalBytes int64
+}
+
+// Reader reads a bundle, verifying every file against the manifest.
+type Reader struct {
+	Manifest Manifest
+	zipFiles map[string]*zip.File
+	entries  map[string]File
+}
+
+// NewReader opens a bundle and reads its manifest. Every file the manifest lists must be present,
+// and within limits. Files are later read up to their manifest size, so the limits bound how
+// much a bundle can expand to, however well it compresses.
+func NewReader(r io.ReaderAt, size int64, limits Limits) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	br := &Reader{zipFiles: make(map[string]*zip.File, len(zr.File)), entries: make(map[string]File)}
+	for _, f := range zr.File {
+		br.zipFiles[f.Name] = f
+	}
+
+	mf, ok := br.zipFiles[ManifestPath]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidBundle, ManifestPath)
+	}
+	data, err := readZipFile(mf, MaxMetadataBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &br.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBundle, ManifestPath, err)
+	}
+	if br.Manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidBundle, br.Manifest.FormatVersion)
+	}
+	var total int64
+	for _, f := range br.Manifest.Files {
+		if _, ok := br.zipFiles[f.Path]; !ok {
+			return nil, fmt.Errorf("%w: %s is listed in the manifest but missing", ErrInvalidBundle, f.Path)
+		}
+		if f.SizeB < 0 {
+			return nil, fmt.Errorf("%w: %s has a negative size", ErrInvalidBundle, f.Path)
+		}
+		if limits.MaxFileBytes > 0 && f.SizeB > limits.MaxFileBytes {
+			return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrInvalidBundle, f.Path, limits.MaxFileBytes)
+		}
+		total += f.SizeB
+		if limits.MaxTotalBytes > 0 && total > limits.MaxTotalBytes {
+			return nil, fmt.Errorf("%w: bundle expands to more than %d bytes", ErrInvalidBundle, limits.MaxTotalBytes)
+		}
+		br.entries[f.Path] = f
+	}
+	if _, ok := br.entries[MessagesPath]; !ok {
+		return nil, fmt.Errorf("%w: manifest does not list %s", ErrInvalidBundle, MessagesPath)
+	}
+	return br, nil
+}
+
+// Session reads messages.json. Every asset path a part names must be listed in the manifest.
+func (r *Reader) Session() (*Session, error) {
+	data, err := r.read(MessagesPath, MaxMetadataBytes)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBundle, MessagesPath, err)
+	}
+	for i, m := range s.Messages {
+		for j, p := range m.Parts {
+			if p.AssetPath == "" {
+				continue
+			}
+			if _, ok := r.entries[p.AssetPath]; !ok || !strings.HasPrefix(p.AssetPath, AssetsDir) {
+				return nil, fmt.Errorf("%w: messages[%d].parts[%d]: unknown asset %q", ErrInvalidBundle, i, j, p.AssetPath)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// AssetSize returns the manifest size of the asset at name.
+func (r *Reader) AssetSize(name string) int64 {
+	return r.entries[name].SizeB
+}
+
+// ReadAsset returns the content of the asset at name after checking it against the manifest.
+func (r *Reader) ReadAsset(name string) ([]byte, error) {
+	if !strings.HasPrefix(name, AssetsDir) {
+		return nil, fmt.Errorf("%w: %q is not an asset", ErrInvalidBundle, name)
+	}
+	entry, ok := r.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown asset %q", ErrInvalidBundle, name)
+	}
+	return r.read(name, entry.SizeB)
+}
+
+// read returns a listed file, rejecting it when it is larger than limit or does not match its
+// manifest entry.
+func (r *Reader) read(name string, limit int64) ([]byte, error) {
+	entry := r.entries[name]
+	data, err := readZipFile(r.zipFiles[name], limit)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != entry.SizeB || hex.EncodeToString(sum[:]) != entry.SHA256 {
+		return nil, fmt.Errorf("%w: %s does not match its checksum", ErrInvalidBundle, name)
+	}
+	return data, nil
+}
+
+// readZipFile reads f, failing once it yields more than limit bytes; the size the zip header
+// declares is checked first but not trusted.
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrInvalidBundle, f.Name, limit)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: open %s: %v", ErrInvalidBundle, f.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: read %s: %v", ErrInvalidBundle, f.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrInvalidBundle, f.Name, limit)
+	}
+	return data, nil
+}
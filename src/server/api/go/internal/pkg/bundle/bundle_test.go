@@ -0,0 +1,167 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestAssetPath(t *testing.T) {
+	assert.Equal(t, "assets/abc.png", AssetPath(model.Asset{SHA256: "abc", S3Key: "assets/p/2024/01/01/abc.PNG"}))
+	assert.Equal(t, "assets/abc", AssetPath(model.Asset{SHA256: "abc", S3Key: "assets/p/abc"}))
+	assert.Equal(t, "assets/abc", AssetPath(model.Asset{SHA256: "abc", S3Key: "assets/p/abc.not-an-ext!"}))
+}
+
+func TestRoundTrip(t *testing.T) {
+	rootID := uuid.New()
+	image := model.Asset{SHA256: "img", S3Key: "assets/p/img.png", MIME: "image/png"}
+	msgs := []model.Message{
+		{
+			ID:        rootID,
+			Role:      model.RoleUser,
+			Meta:      datatypes.NewJSONType(map[string]any{"source": "test"}),
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Parts: []model.Part{
+				model.NewTextPart("look"),
+				{Type: model.PartTypeImage, Asset: &image, Filename: "img.png"},
+			},
+		},
+		{
+			ID:       uuid.New(),
+			ParentID: &rootID,
+			Role:     model.RoleAssistant,
+			Parts:    []model.Part{{Type: model.PartTypeImage, Asset: &image}},
+		},
+	}
+	bundled, assets := FromMessages(msgs)
+	require.Len(t, assets, 1, "shared assets are bundled once")
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, uuid.New())
+	require.NoError(t, w.WriteSession(Session{Tags: []string{"a"}, Messages: bundled}))
+	for name := range assets {
+		require.NoError(t, w.WriteAsset(name, []byte("png-bytes")))
+		require.NoError(t, w.WriteAsset(name, []byte("png-bytes")))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), Limits{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Manifest.MessageCount)
+	assert.Len(t, r.Manifest.Files, 2)
+
+	s, err := r.Session()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, s.Tags)
+	require.Len(t, s.Messages, 2)
+	assert.Equal(t, rootID, *s.Messages[1].ParentID)
+	assert.Equal(t, "test", s.Messages[0].Meta["source"])
+	part := s.Messages[0].Parts[1]
+	assert.Equal(t, "assets/img.png", part.AssetPath)
+	assert.Equal(t, "image/png", part.MIME)
+	assert.Equal(t, "img.png", part.Filename)
+
+	content, err := r.ReadAsset(part.AssetPath)
+	require.NoError(t, err)
+	assert.Equal(t, "png-bytes", string(content))
+	assert.Equal(t, int64(len("png-bytes")), r.AssetSize(part.AssetPath))
+
+	_, err = r.ReadAsset(MessagesPath)
+	assert.ErrorIs(t, err, ErrInvalidBundle)
+}
+
+// rewrite copies a bundle, replacing or dropping files.
+func rewrite(t *testing.T, src []byte, replace map[string][]byte) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		content, ok := replace[f.Name]
+		if !ok {
+			content, err = readZipFile(f, MaxMetadataBytes)
+			require.NoError(t, err)
+		}
+		if content == nil {
+			continue
+		}
+		fw, err := zw.Create(f.Name)
+		require.NoError(t, err)
+		_, err = fw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestReader_Rejects(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, uuid.New())
+	require.NoError(t, w.WriteSession(Session{Messages: []Message{{
+		ID: uuid.New(), Role: model.RoleUser,
+		Parts: []Part{{Type: model.PartTypeFile, AssetPath: "assets/doc.pdf", MIME: "application/pdf"}},
+	}}}))
+	require.NoError(t, w.WriteAsset("assets/doc.pdf", []byte("pdf")))
+	require.NoError(t, w.Close())
+	valid := buf.Bytes()
+
+	open := func(data []byte) (*Reader, error) {
+		return NewReader(bytes.NewReader(data), int64(len(data)), Limits{})
+	}
+
+	t.Run("not a zip", func(t *testing.T) {
+		_, err := open([]byte("nope"))
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("missing manifest", func(t *testing.T) {
+		_, err := open(rewrite(t, valid, map[string][]byte{ManifestPath: nil}))
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("listed file missing", func(t *testing.T) {
+		_, err := open(rewrite(t, valid, map[string][]byte{"assets/doc.pdf": nil}))
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("tampered asset", func(t *testing.T) {
+		r, err := open(rewrite(t, valid, map[string][]byte{"assets/doc.pdf": []byte("pdx")}))
+		require.NoError(t, err)
+		_, err = r.ReadAsset("assets/doc.pdf")
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("oversized asset", func(t *testing.T) {
+		r, err := open(rewrite(t, valid, map[string][]byte{"assets/doc.pdf": []byte("pdf and more")}))
+		require.NoError(t, err)
+		_, err = r.ReadAsset("assets/doc.pdf")
+		assert.ErrorContains(t, err, "exceeds")
+	})
+
+	t.Run("manifest size over the file limit", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader(valid), int64(len(valid)), Limits{MaxFileBytes: 2})
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+		assert.ErrorContains(t, err, "exceeds 2 bytes")
+	})
+
+	t.Run("manifest sizes over the total limit", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader(valid), int64(len(valid)), Limits{MaxFileBytes: 1 << 20, MaxTotalBytes: 10})
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+		assert.ErrorContains(t, err, "more than 10 bytes")
+	})
+
+	t.Run("tampered messages", func(t *testing.T) {
+		r, err := open(rewrite(t, valid, map[string][]byte{MessagesPath: []byte(`{"messages":[]}`)}))
+		require.NoError(t, err)
+		_, err = r.Session()
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+}
@@ -16,12 +16,16 @@ type AcontextMessage struct {
 	SessionID                string         `json:"session_id"`
 	ParentID                 *string        `json:"parent_id"` // Nullable for message threading
 	Role                     string         `json:"role"`
+	AuthorID                 *string        `json:"author_id,omitempty"`   // Set for attributed messages
+	AuthorType               string         `json:"author_type,omitempty"` // user, agent or system
 	Parts                    []model.Part   `json:"parts"`
 	SessionTaskProcessStatus string         `json:"session_task_process_status"` // Task processing state
 	Meta                     map[string]any `json:"meta,omitempty"`
 	TaskID                   *string        `json:"task_id"`
-	CreatedAt                string         `json:"created_at"` // ISO 8601 timestamp for UI compatibility
-	UpdatedAt                string         `json:"updated_at"` // ISO 8601 timestamp
+	Flagged                  bool           `json:"flagged,omitempty"`     // Set when moderation flagged the message
+	FlagReason               string         `json:"flag_reason,omitempty"` // Why moderation flagged the message
+	CreatedAt                string         `json:"created_at"`            // ISO 8601 timestamp for UI compatibility
+	UpdatedAt                string         `json:"updated_at"`            // ISO 8601 timestamp
 }
 
 // Convert converts internal model.Message to Acontext format
@@ -29,34 +33,86 @@ func (c *AcontextConverter) Convert(messages []model.Message, publicURLs map[str
 	result := make([]AcontextMessage, len(messages))
 
 	for i, msg := range messages {
-		acontextMsg := AcontextMessage{
-			ID:                       msg.ID.String(),
-			SessionID:                msg.SessionID.String(),
-			Role:                     msg.Role,
-			Parts:                    msg.Parts,
-			SessionTaskProcessStatus: msg.SessionTaskProcessStatus,
-			CreatedAt:                msg.CreatedAt.Format("2006-01-02T15:04:05.999999Z07:00"), // ISO 8601 / RFC3339
-			UpdatedAt:                msg.UpdatedAt.Format("2006-01-02T15:04:05.999999Z07:00"),
-		}
+		result[i] = toAcontextMessage(msg)
+	}
+	return result, nil
+}
 
-		// Convert ParentID if present
-		if msg.ParentID != nil {
-			parentIDStr := msg.ParentID.String()
-			acontextMsg.ParentID = &parentIDStr
+// SelectAcontextFields converts messages to Acontext format keeping only the given fields, named
+// as in model.MessageFieldColumns. The messages are expected to be read with just those columns.
+func SelectAcontextFields(messages []model.Message, fields []string) []map[string]any {
+	result := make([]map[string]any, len(messages))
+	for i, msg := range messages {
+		m := toAcontextMessage(msg)
+		item := make(map[string]any, len(fields))
+		for _, f := range fields {
+			switch f {
+			case "id":
+				item[f] = m.ID
+			case "session_id":
+				item[f] = m.SessionID
+			case "parent_id":
+				item[f] = m.ParentID
+			case "role":
+				item[f] = m.Role
+			case "author":
+				item["author_id"] = m.AuthorID
+				item["author_type"] = m.AuthorType
+			case "parts":
+				item[f] = m.Parts
+			case "session_task_process_status":
+				item[f] = m.SessionTaskProcessStatus
+			case "meta":
+				item[f] = m.Meta
+			case "task_id":
+				item[f] = m.TaskID
+			case "flagged":
+				item[f] = m.Flagged
+				item["flag_reason"] = m.FlagReason
+			case "created_at":
+				item[f] = m.CreatedAt
+			case "updated_at":
+				item[f] = m.UpdatedAt
+			}
 		}
+		result[i] = item
+	}
+	return result
+}
 
-		if msg.TaskID != nil {
-			taskIDStr := msg.TaskID.String()
-			acontextMsg.TaskID = &taskIDStr
-		}
+func toAcontextMessage(msg model.Message) AcontextMessage {
+	acontextMsg := AcontextMessage{
+		ID:                       msg.ID.String(),
+		SessionID:                msg.SessionID.String(),
+		Role:                     msg.Role,
+		AuthorType:               msg.AuthorType,
+		Parts:                    msg.Parts,
+		SessionTaskProcessStatus: msg.SessionTaskProcessStatus,
+		Flagged:                  msg.Flagged,
+		FlagReason:               msg.FlagReason,
+		CreatedAt:                msg.CreatedAt.Format("2006-01-02T15:04:05.999999Z07:00"), // ISO 8601 / RFC3339
+		UpdatedAt:                msg.UpdatedAt.Format("2006-01-02T15:04:05.999999Z07:00"),
+	}
 
-		// Convert meta if present - handle datatypes.JSONType
-		if metaData := msg.Meta.Data(); len(metaData) > 0 {
-			acontextMsg.Meta = metaData
-		}
+	// Convert ParentID if present
+	if msg.ParentID != nil {
+		parentIDStr := msg.ParentID.String()
+		acontextMsg.ParentID = &parentIDStr
+	}
 
-		result[i] = acontextMsg
+	if msg.AuthorID != nil {
+		authorIDStr := msg.AuthorID.String()
+		acontextMsg.AuthorID = &authorIDStr
 	}
 
-	return result, nil
+	if msg.TaskID != nil {
+		taskIDStr := msg.TaskID.String()
+		acontextMsg.TaskID = &taskIDStr
+	}
+
+	// Convert meta if present - handle datatypes.JSONType
+	if metaData := msg.Meta.Data(); len(metaData) > 0 {
+		acontextMsg.Meta = metaData
+	}
+	return acontextMsg
 }
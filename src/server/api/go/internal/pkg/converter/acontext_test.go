@@ -348,3 +348,21 @@ func TestAcontextConverter_Convert_SessionTaskProcessStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectAcontextFields(t *testing.T) {
+	parentID := uuid.New()
+	msg := model.Message{
+		ID:        uuid.New(),
+		ParentID:  &parentID,
+		Role:      model.RoleAssistant,
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	items := SelectAcontextFields([]model.Message{msg}, []string{"id", "parent_id", "created_at"})
+	require.Len(t, items, 1)
+	assert.Len(t, items[0], 3)
+	assert.Equal(t, msg.ID.String(), items[0]["id"])
+	assert.Equal(t, parentID.String(), *items[0]["parent_id"].(*string))
+	assert.Equal(t, "2024-01-02T03:04:05Z", items[0]["created_at"])
+	assert.NotContains(t, items[0], "parts")
+}
@@ -81,6 +81,7 @@ type GetMessagesOutput struct {
 	ThisTimeTokens  int                          `json:"this_time_tokens"`             // Token count for returned messages
 	EditAtMessageID string                       `json:"edit_at_message_id,omitempty"` // Message ID where edit strategies were applied
 	PublicURLs      map[string]service.PublicURL `json:"public_urls,omitempty"`        // Asset public URLs (only for acontext format)
+	TreeStats       []model.MessageTreeStats     `json:"tree_stats,omitempty"`         // Depth and child count of each message (same order as items/ids), with include=tree_stats
 }
 
 // GetConvertedMessagesOutput wraps the converted messages with metadata
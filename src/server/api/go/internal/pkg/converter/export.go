@@ -0,0 +1,191 @@
+package converter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	openai "github.com/openai/openai-go/v3"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+// UnsupportedPartsError lists the parts of a session that the export format cannot represent.
+// Entries are part types, qualified with the message kind when only that kind rejects them.
+type UnsupportedPartsError struct {
+	Format    model.MessageFormat
+	PartTypes []string
+}
+
+func (e *UnsupportedPartsError) Error() string {
+	return fmt.Sprintf("session contains parts that cannot be exported to %s: %s", e.Format, strings.Join(e.PartTypes, ", "))
+}
+
+// ValidateExportFormat checks if format can be used with ExportSession
+func ValidateExportFormat(format string) (model.MessageFormat, error) {
+	switch mf := model.MessageFormat(format); mf {
+	case model.FormatOpenAI, model.FormatMarkdown:
+		return mf, nil
+	default:
+		return "", fmt.Errorf("invalid export format: %s, supported formats: openai, markdown", format)
+	}
+}
+
+// OpenAIExport is a session in the OpenAI chat-completions request shape
+type OpenAIExport struct {
+	Messages []openai.ChatCompletionMessageParamUnion `json:"messages"`
+}
+
+// ExportOptions tunes how formats that have a choice represent media
+type ExportOptions struct {
+	// InlineImages embeds stored images as base64 data URLs instead of linking to their asset URL (markdown only).
+	InlineImages bool
+}
+
+// ExportSession converts an already flattened, chronological message list to format.
+// Unlike ConvertMessages it never drops content silently: parts the format has no
+// slot for are reported as an *UnsupportedPartsError.
+func ExportSession(messages []model.Message, format model.MessageFormat, publicURLs map[string]service.PublicURL, opts ExportOptions) (interface{}, error) {
+	switch format {
+	case model.FormatOpenAI:
+		return ExportOpenAI(messages, publicURLs)
+	case model.FormatMarkdown:
+		return ExportMarkdown(messages, publicURLs, opts), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// ExportOpenAI converts messages to an OpenAIExport. Tool results become one `tool`
+// message each, and media parts OpenAI cannot inline (stored audio, files, video, or
+// images without a URL) are kept as text blocks referencing their asset.
+func ExportOpenAI(messages []model.Message, publicURLs map[string]service.PublicURL) (*OpenAIExport, error) {
+	if unsupported := openAIUnsupportedParts(messages); len(unsupported) > 0 {
+		return nil, &UnsupportedPartsError{Format: model.FormatOpenAI, PartTypes: unsupported}
+	}
+
+	prepared := make([]model.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role != model.RoleUser || isSystemRole(msg) {
+			prepared = append(prepared, msg)
+			continue
+		}
+
+		// Tool results go first, each as its own tool message, then the remaining user content.
+		rest := make([]model.Part, 0, len(msg.Parts))
+		for _, part := range msg.Parts {
+			if part.Type == model.PartTypeToolResult {
+				toolMsg := msg
+				toolMsg.Parts = []model.Part{part}
+				prepared = append(prepared, toolMsg)
+				continue
+			}
+			rest = append(rest, assetReferencePart(part, publicURLs))
+		}
+		if len(rest) > 0 || len(msg.Parts) == 0 {
+			userMsg := msg
+			userMsg.Parts = rest
+			prepared = append(prepared, userMsg)
+		}
+	}
+
+	converted, err := (&OpenAIConverter{}).Convert(prepared, publicURLs)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAIExport{Messages: converted.([]openai.ChatCompletionMessageParamUnion)}, nil
+}
+
+// openAIUnsupportedParts returns the sorted, distinct labels of parts with no OpenAI representation
+func openAIUnsupportedParts(messages []model.Message) []string {
+	seen := map[string]bool{}
+	for _, msg := range messages {
+		for _, part := range msg.Parts {
+			var ok bool
+			kind := msg.Role
+			switch {
+			case isSystemRole(msg):
+				kind = "system"
+				ok = part.Type == model.PartTypeText
+			case msg.Role == model.RoleAssistant:
+				switch part.Type {
+				case model.PartTypeText, model.PartTypeThinking, model.PartTypeToolCall:
+					ok = true
+				}
+			default:
+				switch part.Type {
+				case model.PartTypeText, model.PartTypeToolResult:
+					ok = true
+				case model.PartTypeImage, model.PartTypeAudio, model.PartTypeFile, model.PartTypeVideo:
+					// Media needs inline data, a URL, or at least a stored asset to point at.
+					ok = part.Asset != nil || openAIInlineMedia(part)
+				}
+			}
+			if ok {
+				continue
+			}
+			label := part.Type
+			if isMediaPart(part.Type) || kind == "system" {
+				label = fmt.Sprintf("%s (%s message)", part.Type, kind)
+			}
+			seen[label] = true
+		}
+	}
+
+	out := make([]string, 0, len(seen))
+	for label := range seen {
+		out = append(out, label)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// assetReferencePart replaces a media part OpenAI cannot carry natively with a text
+// block that names its asset, so the reference survives the export.
+func assetReferencePart(part model.Part, publicURLs map[string]service.PublicURL) model.Part {
+	if !isMediaPart(part.Type) || part.Asset == nil {
+		return part
+	}
+	url := GetAssetURL(part.Asset, publicURLs)
+	if openAIInlineMedia(part) || (part.Type == model.PartTypeImage && url != "") {
+		return part
+	}
+
+	ref := url
+	if ref == "" {
+		ref = "asset:" + part.Asset.SHA256
+	}
+	label := part.Type
+	if part.Filename != "" {
+		label += " " + part.Filename
+	}
+	return model.Part{Type: model.PartTypeText, Text: fmt.Sprintf("[%s: %s]", label, ref)}
+}
+
+// openAIInlineMedia reports whether a media part carries data OpenAI accepts inline
+func openAIInlineMedia(part model.Part) bool {
+	switch part.Type {
+	case model.PartTypeImage:
+		return part.GetMetaString(model.MetaKeyURL) != ""
+	case model.PartTypeAudio:
+		return part.GetMetaString(model.MetaKeyData) != ""
+	case model.PartTypeFile:
+		return part.GetMetaString(model.MetaKeyFileID) != "" || part.GetMetaString(model.MetaKeyFileData) != ""
+	}
+	return false
+}
+
+func isMediaPart(partType string) bool {
+	switch partType {
+	case model.PartTypeImage, model.PartTypeAudio, model.PartTypeFile, model.PartTypeVideo:
+		return true
+	}
+	return false
+}
+
+// isSystemRole reports messages the OpenAI converter restores as system or developer messages
+func isSystemRole(msg model.Message) bool {
+	role := (&OpenAIConverter{}).getOriginalRole(msg)
+	return role == "system" || role == "developer"
+}
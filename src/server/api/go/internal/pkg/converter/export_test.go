@@ -0,0 +1,131 @@
+package converter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportJSON runs ExportOpenAI and returns the JSON-decoded messages
+func exportJSON(t *testing.T, messages []model.Message, publicURLs map[string]service.PublicURL) []map[string]any {
+	t.Helper()
+	out, err := ExportOpenAI(messages, publicURLs)
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(out)
+	require.NoError(t, err)
+	var decoded struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	return decoded.Messages
+}
+
+func TestExportOpenAI_ToolCallsAndResults(t *testing.T) {
+	messages := []model.Message{
+		createTestMessage(model.RoleUser, []model.Part{{Type: model.PartTypeText, Text: "weather in SF and NY?"}}, nil),
+		createTestMessage(model.RoleAssistant, []model.Part{
+			{Type: model.PartTypeToolCall, Meta: map[string]any{model.MetaKeyID: "call_1", model.MetaKeyName: "weather", model.MetaKeyArguments: `{"city":"SF"}`}},
+			{Type: model.PartTypeToolCall, Meta: map[string]any{model.MetaKeyID: "call_2", model.MetaKeyName: "weather", model.MetaKeyArguments: `{"city":"NY"}`}},
+		}, nil),
+		createTestMessage(model.RoleUser, []model.Part{
+			{Type: model.PartTypeToolResult, Text: "sunny", Meta: map[string]any{model.MetaKeyToolCallID: "call_1"}},
+			{Type: model.PartTypeToolResult, Text: "rainy", Meta: map[string]any{model.MetaKeyToolCallID: "call_2"}},
+			{Type: model.PartTypeText, Text: "summarize please"},
+		}, nil),
+	}
+
+	got := exportJSON(t, messages, nil)
+	require.Len(t, got, 5)
+	assert.Equal(t, "user", got[0]["role"])
+	assert.Equal(t, "assistant", got[1]["role"])
+	assert.Len(t, got[1]["tool_calls"], 2)
+	assert.Equal(t, "tool", got[2]["role"])
+	assert.Equal(t, "call_1", got[2]["tool_call_id"])
+	assert.Equal(t, "sunny", got[2]["content"])
+	assert.Equal(t, "tool", got[3]["role"])
+	assert.Equal(t, "call_2", got[3]["tool_call_id"])
+	assert.Equal(t, "user", got[4]["role"])
+	assert.Equal(t, "summarize please", got[4]["content"])
+}
+
+func TestExportOpenAI_MediaAssets(t *testing.T) {
+	img := &model.Asset{SHA256: "img-sha", MIME: "image/png"}
+	doc := &model.Asset{SHA256: "doc-sha", MIME: "application/pdf"}
+	publicURLs := map[string]service.PublicURL{
+		"img-sha": {URL: "https://cdn.example/img.png"},
+		"doc-sha": {URL: "https://cdn.example/report.pdf"},
+	}
+
+	messages := []model.Message{
+		createTestMessage(model.RoleUser, []model.Part{
+			{Type: model.PartTypeImage, Asset: img},
+			{Type: model.PartTypeFile, Asset: doc, Filename: "report.pdf"},
+			{Type: model.PartTypeVideo, Asset: &model.Asset{SHA256: "vid-sha"}},
+		}, nil),
+	}
+
+	got := exportJSON(t, messages, publicURLs)
+	require.Len(t, got, 1)
+	content := got[0]["content"].([]any)
+	require.Len(t, content, 3)
+
+	image := content[0].(map[string]any)
+	assert.Equal(t, "image_url", image["type"])
+	assert.Equal(t, "https://cdn.example/img.png", image["image_url"].(map[string]any)["url"])
+
+	assert.Equal(t, "[file report.pdf: https://cdn.example/report.pdf]", content[1].(map[string]any)["text"])
+	assert.Equal(t, "[video: asset:vid-sha]", content[2].(map[string]any)["text"])
+}
+
+func TestExportOpenAI_UnsupportedParts(t *testing.T) {
+	messages := []model.Message{
+		createTestMessage(model.RoleUser, []model.Part{
+			{Type: model.PartTypeData, Meta: map[string]any{"k": "v"}},
+			{Type: model.PartTypeVideo},
+		}, nil),
+		createTestMessage(model.RoleAssistant, []model.Part{
+			{Type: model.PartTypeImage, Asset: &model.Asset{SHA256: "x"}},
+			{Type: model.PartTypeRedactedThinking},
+			{Type: model.PartTypeData},
+		}, nil),
+		createTestMessage(model.RoleUser, []model.Part{{Type: model.PartTypeText, Text: "fine"}}, nil),
+	}
+
+	_, err := ExportOpenAI(messages, nil)
+	var unsupported *UnsupportedPartsError
+	require.ErrorAs(t, err, &unsupported)
+	assert.Equal(t, []string{"data", "image (assistant message)", "redacted_thinking", "video (user message)"}, unsupported.PartTypes)
+	assert.Contains(t, err.Error(), "redacted_thinking")
+}
+
+func TestExportOpenAI_SystemMessage(t *testing.T) {
+	system := createTestMessage(model.RoleUser, []model.Part{{Type: model.PartTypeText, Text: "be brief"}}, map[string]any{model.MsgMetaOriginalRole: "system"})
+	got := exportJSON(t, []model.Message{system}, nil)
+	require.Len(t, got, 1)
+	assert.Equal(t, "system", got[0]["role"])
+	assert.Equal(t, "be brief", got[0]["content"])
+
+	system.Parts = append(system.Parts, model.Part{Type: model.PartTypeImage, Meta: map[string]any{model.MetaKeyURL: "https://x"}})
+	_, err := ExportOpenAI([]model.Message{system}, nil)
+	var unsupported *UnsupportedPartsError
+	require.ErrorAs(t, err, &unsupported)
+	assert.Equal(t, []string{"image (system message)"}, unsupported.PartTypes)
+}
+
+func TestValidateExportFormat(t *testing.T) {
+	f, err := ValidateExportFormat("openai")
+	require.NoError(t, err)
+	assert.Equal(t, model.FormatOpenAI, f)
+
+	f, err = ValidateExportFormat("markdown")
+	require.NoError(t, err)
+	assert.Equal(t, model.FormatMarkdown, f)
+
+	_, err = ValidateExportFormat("anthropic")
+	assert.Error(t, err)
+}
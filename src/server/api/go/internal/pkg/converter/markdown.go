@@ -0,0 +1,285 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+// MarkdownExport is a session rendered as a Markdown transcript
+type MarkdownExport struct {
+	Markdown string `json:"markdown"`
+}
+
+// ExportMarkdown renders messages as a transcript for people to read: a heading per message
+// naming its role, text inline, images embedded, other media as links to their asset, and tool
+// calls, tool results and data in fenced code blocks. Message text is escaped so it reads as
+// written and cannot break the structure of the transcript. With InlineImages, stored image
+// assets of up to maxInlineImageBytes are embedded as base64 data URLs instead of linking to
+// their asset URL; an image that cannot be downloaded keeps its link, and images sent by URL
+// are always linked, never fetched.
+func ExportMarkdown(messages []model.Message, publicURLs map[string]service.PublicURL, opts ExportOptions) *MarkdownExport {
+	blocks := make([]string, 0, len(messages)*2)
+	for _, msg := range messages {
+		heading := "## " + markdownRoleLabel(msg)
+		if !msg.CreatedAt.IsZero() {
+			heading += "\n\n*" + msg.CreatedAt.UTC().Format("2006-01-02 15:04:05 UTC") + "*"
+		}
+		blocks = append(blocks, heading)
+
+		if len(msg.Parts) == 0 {
+			blocks = append(blocks, "*(no content)*")
+		}
+		for _, part := range msg.Parts {
+			if block := markdownPart(part, publicURLs, opts); block != "" {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	if len(blocks) == 0 {
+		return &MarkdownExport{Markdown: ""}
+	}
+	return &MarkdownExport{Markdown: strings.Join(blocks, "\n\n") + "\n"}
+}
+
+// markdownRoleLabel names the speaker of msg: the original role of system and developer
+// messages, tool for user messages that only carry tool results, and the role otherwise.
+func markdownRoleLabel(msg model.Message) string {
+	role := (&OpenAIConverter{}).getOriginalRole(msg)
+	if role == "" {
+		role = msg.Role
+		if msg.Role == model.RoleUser && len(msg.Parts) > 0 && allToolResults(msg.Parts) {
+			role = "tool"
+		}
+	}
+	if role == "" {
+		return "Unknown"
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+func allToolResults(parts []model.Part) bool {
+	for _, part := range parts {
+		if part.Type != model.PartTypeToolResult {
+			return false
+		}
+	}
+	return true
+}
+
+func markdownPart(part model.Part, publicURLs map[string]service.PublicURL, opts ExportOptions) string {
+	switch part.Type {
+	case model.PartTypeText:
+		return escapeMarkdown(part.Text)
+	case model.PartTypeThinking:
+		return markdownQuote("**Thinking**\n\n" + escapeMarkdown(part.Text))
+	case model.PartTypeRedactedThinking:
+		return markdownQuote("*Redacted thinking*")
+	case model.PartTypeToolCall:
+		title := "**Tool call** " + markdownCode(part.Name())
+		if id := part.ID(); id != "" {
+			title += " (" + markdownCode(id) + ")"
+		}
+		args := part.Arguments()
+		if args == "" {
+			if raw, ok := part.Meta[model.MetaKeyArguments]; ok && raw != nil {
+				b, _ := json.Marshal(raw)
+				args = string(b)
+			}
+		}
+		if args == "" {
+			return title
+		}
+		return title + "\n\n" + markdownJSONBlock(args)
+	case model.PartTypeToolResult:
+		title := "**Tool result**"
+		if id := part.ToolCallID(); id != "" {
+			title += " for " + markdownCode(id)
+		}
+		if part.IsError() {
+			title += " (error)"
+		}
+		return title + "\n\n" + markdownCodeBlock("", part.Text)
+	case model.PartTypeData:
+		title := "**Data**"
+		if dataType, ok := part.Meta[model.MetaKeyDataType].(string); ok && dataType != "" {
+			title += " " + markdownCode(dataType)
+		}
+		b, err := json.MarshalIndent(part.Meta, "", "  ")
+		if err != nil {
+			return title
+		}
+		return title + "\n\n" + markdownCodeBlock("json", string(b))
+	case model.PartTypeImage:
+		return markdownImage(part, publicURLs, opts)
+	case model.PartTypeAudio, model.PartTypeVideo, model.PartTypeFile:
+		return markdownMediaLink(part, publicURLs)
+	default:
+		return escapeMarkdown(fmt.Sprintf("[%s part]", part.Type))
+	}
+}
+
+// maxInlineImageBytes bounds an image embedded by an export with InlineImages.
+const maxInlineImageBytes = 5 << 20
+
+// markdownImage embeds an image from its asset URL, or from the URL it was sent with when it
+// has no stored asset.
+func markdownImage(part model.Part, publicURLs map[string]service.PublicURL, opts ExportOptions) string {
+	alt := part.Filename
+	if alt == "" {
+		alt = "image"
+	}
+	src := GetAssetURL(part.Asset, publicURLs)
+	// Only stored assets are inlined: their URL points at our storage, while a URL sent with
+	// the message could point anywhere, internal services included.
+	if opts.InlineImages && src != "" && part.Asset.SizeB <= maxInlineImageBytes {
+		if data, mediaType := downloadImageAsBase64(src, maxInlineImageBytes); data != "" {
+			if part.Asset.MIME != "" {
+				mediaType = part.Asset.MIME
+			}
+			src = "data:" + mediaType + ";base64," + data
+		}
+	}
+	if src == "" {
+		src = part.GetMetaString(model.MetaKeyURL)
+	}
+	if src == "" {
+		return markdownMediaLink(part, publicURLs)
+	}
+	return "![" + escapeMarkdownInline(alt) + "](" + markdownDestination(src) + ")"
+}
+
+// markdownMediaLink links to the asset of a media part, or names it when it has no URL.
+func markdownMediaLink(part model.Part, publicURLs map[string]service.PublicURL) string {
+	label := part.Type
+	if part.Filename != "" {
+		label += " " + part.Filename
+	}
+	if url := GetAssetURL(part.Asset, publicURLs); url != "" {
+		return "[" + escapeMarkdownInline(label) + "](" + markdownDestination(url) + ")"
+	}
+	ref := "inline"
+	switch {
+	case part.Asset != nil:
+		ref = "asset:" + part.Asset.SHA256
+	case part.GetMetaString(model.MetaKeyFileID) != "":
+		ref = "file_id:" + part.GetMetaString(model.MetaKeyFileID)
+	}
+	return escapeMarkdownInline(fmt.Sprintf("[%s: %s]", label, ref))
+}
+
+// markdownEscaper backslash-escapes the characters that start inline Markdown constructs.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `|`, `\|`, `~`, `\~`, `#`, `\#`, `&`, `\&`,
+)
+
+// markdownListMarker matches an ordered list marker at the start of a line.
+var markdownListMarker = regexp.MustCompile(`^(\d{1,9})([.)])`)
+
+// escapeMarkdown escapes text so it renders literally, line breaks included: inline markup is
+// escaped, lines cannot open a block (heading, list, quote, code), and single newlines become
+// hard breaks.
+func escapeMarkdown(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = escapeMarkdownLine(line)
+	}
+	for i := 0; i < len(lines)-1; i++ {
+		if lines[i] != "" && lines[i+1] != "" {
+			lines[i] += `\`
+		}
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+func escapeMarkdownLine(line string) string {
+	trimmed := strings.TrimLeft(line, " \t")
+	if strings.TrimSpace(trimmed) == "" {
+		return ""
+	}
+	// Indentation would open a code block; non-breaking spaces keep it without the markup.
+	indent := strings.NewReplacer(" ", "\u00a0", "\t", "\u00a0\u00a0\u00a0\u00a0").Replace(line[:len(line)-len(trimmed)])
+
+	escaped := markdownEscaper.Replace(trimmed)
+	switch {
+	case strings.HasPrefix(trimmed, "-"), strings.HasPrefix(trimmed, "+"), strings.HasPrefix(trimmed, "="):
+		escaped = `\` + escaped
+	case markdownListMarker.MatchString(trimmed):
+		escaped = markdownListMarker.ReplaceAllString(escaped, `$1\$2`)
+	}
+	return indent + escaped
+}
+
+// escapeMarkdownInline escapes text that must stay on one line, such as link text.
+func escapeMarkdownInline(text string) string {
+	return markdownEscaper.Replace(strings.Join(strings.Fields(text), " "))
+}
+
+// markdownQuote renders markdown as a block quote.
+func markdownQuote(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// markdownCode renders text as a code span, with a fence longer than any run of backticks it
+// contains.
+func markdownCode(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return "` `"
+	}
+	fence := strings.Repeat("`", longestRun(text, '`')+1)
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+	return fence + text + fence
+}
+
+// markdownCodeBlock renders text as a fenced code block, with a fence longer than any run of
+// backticks it contains so the text cannot close it.
+func markdownCodeBlock(lang, text string) string {
+	fence := strings.Repeat("`", max(3, longestRun(text, '`')+1))
+	text = strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	return fence + lang + "\n" + text + "\n" + fence
+}
+
+// markdownJSONBlock renders JSON indented in a code block; other text is kept as is.
+func markdownJSONBlock(text string) string {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(text), "", "  "); err != nil {
+		return markdownCodeBlock("", text)
+	}
+	return markdownCodeBlock("json", b.String())
+}
+
+// markdownDestination renders url as a link destination in angle brackets, which may hold
+// spaces and parentheses but not angle brackets or line breaks.
+func markdownDestination(url string) string {
+	return "<" + strings.NewReplacer("<", "%3C", ">", "%3E", "\n", "", "\r", "").Replace(url) + ">"
+}
+
+func longestRun(s string, c byte) int {
+	longest, run := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
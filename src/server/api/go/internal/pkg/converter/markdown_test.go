@@ -0,0 +1,129 @@
+package converter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportMarkdown_Transcript(t *testing.T) {
+	system := createTestMessage(model.RoleUser, []model.Part{{Type: model.PartTypeText, Text: "be brief"}}, map[string]any{model.MsgMetaOriginalRole: "system"})
+	user := createTestMessage(model.RoleUser, []model.Part{{Type: model.PartTypeText, Text: "weather in SF?"}}, nil)
+	user.CreatedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	messages := []model.Message{
+		system,
+		user,
+		createTestMessage(model.RoleAssistant, []model.Part{
+			{Type: model.PartTypeThinking, Text: "look it up"},
+			{Type: model.PartTypeToolCall, Meta: map[string]any{model.MetaKeyID: "call_1", model.MetaKeyName: "weather", model.MetaKeyArguments: `{"city":"SF"}`}},
+		}, nil),
+		createTestMessage(model.RoleUser, []model.Part{
+			{Type: model.PartTypeToolResult, Text: "sunny", Meta: map[string]any{model.MetaKeyToolCallID: "call_1", model.MetaKeyIsError: true}},
+		}, nil),
+	}
+
+	got := ExportMarkdown(messages, nil, ExportOptions{}).Markdown
+	assert.Contains(t, got, "## System\n")
+	assert.Contains(t, got, "## User\n\n*2026-01-02 03:04:05 UTC*\n\nweather in SF?\n")
+	assert.Contains(t, got, "## Assistant\n")
+	assert.Contains(t, got, "> **Thinking**\n>\n> look it up")
+	assert.Contains(t, got, "**Tool call** `weather` (`call_1`)\n\n```json\n{\n  \"city\": \"SF\"\n}\n```")
+	assert.Contains(t, got, "## Tool\n")
+	assert.Contains(t, got, "**Tool result** for `call_1` (error)\n\n```\nsunny\n```")
+}
+
+func TestExportMarkdown_EscapesText(t *testing.T) {
+	text := "# not a heading\n- not a list\n1. nor this\n    not code\n**bold** [link](x) <b>tag</b> a_b `code`"
+	got := ExportMarkdown([]model.Message{
+		createTestMessage(model.RoleUser, []model.Part{{Type: model.PartTypeText, Text: text}}, nil),
+	}, nil, ExportOptions{}).Markdown
+
+	want := strings.Join([]string{
+		`\# not a heading\`,
+		`\- not a list\`,
+		`1\. nor this\`,
+		"\u00a0\u00a0\u00a0\u00a0not code\\", // indentation kept without opening a code block
+		"\\*\\*bold\\*\\* \\[link\\](x) \\<b\\>tag\\</b\\> a\\_b \\`code\\`",
+	}, "\n")
+	assert.Contains(t, got, want)
+}
+
+func TestExportMarkdown_FencesOutlastContent(t *testing.T) {
+	result := "```\nrm -rf /\n```\n## injected"
+	got := ExportMarkdown([]model.Message{
+		createTestMessage(model.RoleUser, []model.Part{
+			{Type: model.PartTypeToolResult, Text: result, Meta: map[string]any{model.MetaKeyToolCallID: "id`1"}},
+		}, nil),
+	}, nil, ExportOptions{}).Markdown
+
+	assert.Contains(t, got, "for ``id`1``")
+	assert.Contains(t, got, "````\n"+result+"\n````")
+}
+
+func TestExportMarkdown_Media(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	publicURLs := map[string]service.PublicURL{
+		"img-sha": {URL: srv.URL + "/img.png"},
+		"doc-sha": {URL: "https://cdn.example/report (final).pdf"},
+	}
+	messages := []model.Message{
+		createTestMessage(model.RoleUser, []model.Part{
+			{Type: model.PartTypeImage, Asset: &model.Asset{SHA256: "img-sha"}, Filename: "chart_[1].png"},
+			{Type: model.PartTypeFile, Asset: &model.Asset{SHA256: "doc-sha"}, Filename: "report.pdf"},
+			{Type: model.PartTypeVideo, Asset: &model.Asset{SHA256: "vid-sha"}},
+		}, nil),
+	}
+
+	linked := ExportMarkdown(messages, publicURLs, ExportOptions{}).Markdown
+	assert.Contains(t, linked, "![chart\\_\\[1\\].png](<"+srv.URL+"/img.png>)")
+	assert.Contains(t, linked, "[file report.pdf](<https://cdn.example/report (final).pdf>)")
+	assert.Contains(t, linked, `\[video: asset:vid-sha\]`)
+
+	inlined := ExportMarkdown(messages, publicURLs, ExportOptions{InlineImages: true}).Markdown
+	assert.Contains(t, inlined, "](<data:image/png;base64,cG5n>)")
+	assert.NotContains(t, inlined, srv.URL)
+}
+
+func TestExportMarkdown_InlinesOnlyStoredImages(t *testing.T) {
+	var fetched []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.Path)
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/big.png" {
+			_, _ = w.Write(make([]byte, maxInlineImageBytes+1))
+			return
+		}
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	publicURLs := map[string]service.PublicURL{
+		"big-sha":  {URL: srv.URL + "/big.png"},
+		"huge-sha": {URL: srv.URL + "/huge.png"},
+	}
+	messages := []model.Message{
+		createTestMessage(model.RoleUser, []model.Part{
+			{Type: model.PartTypeImage, Meta: map[string]any{model.MetaKeyURL: srv.URL + "/internal.png"}},
+			{Type: model.PartTypeImage, Asset: &model.Asset{SHA256: "huge-sha", SizeB: maxInlineImageBytes + 1}},
+			{Type: model.PartTypeImage, Asset: &model.Asset{SHA256: "big-sha"}},
+		}, nil),
+	}
+
+	got := ExportMarkdown(messages, publicURLs, ExportOptions{InlineImages: true}).Markdown
+	assert.Contains(t, got, "](<"+srv.URL+"/internal.png>)", "an image sent by URL is linked")
+	assert.Contains(t, got, "](<"+srv.URL+"/huge.png>)", "an asset over the limit is linked")
+	assert.Contains(t, got, "](<"+srv.URL+"/big.png>)", "a download over the limit is linked")
+	assert.NotContains(t, got, "data:")
+	assert.Equal(t, []string{"/big.png"}, fetched)
+}
@@ -0,0 +1,66 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/editor"
+)
+
+// ReplayExport is a session branch split into turns for offline evaluation
+type ReplayExport struct {
+	LeafMessageID *string             `json:"leaf_message_id"`
+	Format        model.MessageFormat `json:"format"`
+	Turns         []ReplayTurn        `json:"turns"`
+}
+
+// ReplayTurn holds one turn's input context and assistant output, each in the export format
+type ReplayTurn struct {
+	Index  int         `json:"index"`
+	Input  interface{} `json:"input"`
+	Output interface{} `json:"output"`
+}
+
+// ValidateReplayFormat checks if format can be used with ExportReplay
+func ValidateReplayFormat(format string) (model.MessageFormat, error) {
+	switch mf := model.MessageFormat(format); mf {
+	case model.FormatAcontext, model.FormatOpenAI:
+		return mf, nil
+	default:
+		return "", fmt.Errorf("invalid replay format: %s, supported formats: acontext, openai", format)
+	}
+}
+
+// ExportReplay converts replay turns to format. No public URLs are used, so the same turns
+// always convert to the same output; the openai format reports parts it cannot represent
+// as an *UnsupportedPartsError, as ExportSession does.
+func ExportReplay(leafMessageID *string, turns []editor.ReplayTurn, format model.MessageFormat) (*ReplayExport, error) {
+	out := &ReplayExport{LeafMessageID: leafMessageID, Turns: make([]ReplayTurn, 0, len(turns)), Format: format}
+	for _, t := range turns {
+		input, err := exportReplayMessages(t.Input, format)
+		if err != nil {
+			return nil, err
+		}
+		output, err := exportReplayMessages(t.Output, format)
+		if err != nil {
+			return nil, err
+		}
+		out.Turns = append(out.Turns, ReplayTurn{Index: t.Index, Input: input, Output: output})
+	}
+	return out, nil
+}
+
+func exportReplayMessages(messages []model.Message, format model.MessageFormat) (interface{}, error) {
+	switch format {
+	case model.FormatAcontext:
+		return (&AcontextConverter{}).Convert(messages, nil)
+	case model.FormatOpenAI:
+		exported, err := ExportOpenAI(messages, nil)
+		if err != nil {
+			return nil, err
+		}
+		return exported.Messages, nil
+	default:
+		return nil, fmt.Errorf("unsupported replay format: %s", format)
+	}
+}
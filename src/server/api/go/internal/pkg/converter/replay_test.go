@@ -0,0 +1,73 @@
+package converter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/editor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportReplay(t *testing.T) {
+	messages := []model.Message{
+		{ID: uuid.New(), Role: model.RoleUser, Parts: []model.Part{model.NewTextPart("weather?")}},
+		{ID: uuid.New(), Role: model.RoleAssistant, Parts: []model.Part{{Type: model.PartTypeToolCall, Meta: map[string]any{model.MetaKeyID: "call_1", model.MetaKeyName: "get_weather", "arguments": "{}"}}}},
+		{ID: uuid.New(), Role: model.RoleUser, Parts: []model.Part{{Type: model.PartTypeToolResult, Text: "sunny", Meta: map[string]any{model.MetaKeyToolCallID: "call_1"}}}},
+		{ID: uuid.New(), Role: model.RoleAssistant, Parts: []model.Part{model.NewTextPart("It is sunny.")}},
+	}
+	turns := editor.ReplayTurns(messages)
+	leafID := messages[3].ID.String()
+
+	t.Run("openai", func(t *testing.T) {
+		out, err := ExportReplay(&leafID, turns, model.FormatOpenAI)
+		require.NoError(t, err)
+		raw, err := json.Marshal(out)
+		require.NoError(t, err)
+
+		var decoded struct {
+			Turns []struct {
+				Input  []map[string]any `json:"input"`
+				Output []map[string]any `json:"output"`
+			} `json:"turns"`
+		}
+		require.NoError(t, json.Unmarshal(raw, &decoded))
+		require.Len(t, decoded.Turns, 2)
+		last := decoded.Turns[1]
+		require.Len(t, last.Input, 3)
+		assert.Equal(t, "user", last.Input[0]["role"])
+		assert.Equal(t, "assistant", last.Input[1]["role"])
+		assert.Equal(t, "tool", last.Input[2]["role"])
+		assert.Equal(t, "call_1", last.Input[2]["tool_call_id"])
+		assert.Equal(t, "It is sunny.", last.Output[0]["content"])
+
+		again, err := ExportReplay(&leafID, turns, model.FormatOpenAI)
+		require.NoError(t, err)
+		rawAgain, err := json.Marshal(again)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(raw), string(rawAgain), "replays are deterministic")
+	})
+
+	t.Run("acontext", func(t *testing.T) {
+		out, err := ExportReplay(&leafID, turns, model.FormatAcontext)
+		require.NoError(t, err)
+		require.Len(t, out.Turns, 2)
+		assert.Len(t, out.Turns[1].Input, 3)
+		assert.Equal(t, messages[3].ID.String(), out.Turns[1].Output.([]AcontextMessage)[0].ID)
+	})
+
+	t.Run("unsupported parts", func(t *testing.T) {
+		bad := []model.Message{
+			{Role: model.RoleUser, Parts: []model.Part{{Type: model.PartTypeData, Meta: map[string]any{"k": "v"}}}},
+			{Role: model.RoleAssistant, Parts: []model.Part{model.NewTextPart("ok")}},
+		}
+		_, err := ExportReplay(nil, editor.ReplayTurns(bad), model.FormatOpenAI)
+		var unsupported *UnsupportedPartsError
+		assert.ErrorAs(t, err, &unsupported)
+	})
+
+	_, err := ValidateReplayFormat("gemini")
+	assert.Error(t, err)
+}
@@ -33,6 +33,12 @@ func GetAssetURL(asset *model.Asset, publicURLs map[string]service.PublicURL) st
 // the base64-encoded data and its MIME type.
 // Returns empty strings on any error.
 func DownloadImageAsBase64(imageURL string) (base64Data string, mediaType string) {
+	return downloadImageAsBase64(imageURL, 0)
+}
+
+// downloadImageAsBase64 is DownloadImageAsBase64 giving up on images larger than maxBytes; zero
+// means no limit.
+func downloadImageAsBase64(imageURL string, maxBytes int64) (base64Data string, mediaType string) {
 	resp, err := httpClient.Get(imageURL)
 	if err != nil {
 		return "", ""
@@ -43,8 +49,12 @@ func DownloadImageAsBase64(imageURL string) (base64Data string, mediaType string
 		return "", ""
 	}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
+	body := io.Reader(resp.Body)
+	if maxBytes > 0 {
+		body = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil || (maxBytes > 0 && int64(len(data)) > maxBytes) {
 		return "", ""
 	}
 
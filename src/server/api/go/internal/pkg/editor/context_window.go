@@ -0,0 +1,322 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/tokenizer"
+	"gorm.io/datatypes"
+)
+
+// Context-window strategies accepted by SelectContext
+const (
+	// ContextStrategyRecent keeps the newest messages that fit the budget.
+	ContextStrategyRecent = "recent"
+	// ContextStrategySummarizeOlder keeps recent messages verbatim and replaces
+	// the dropped older ones with a single placeholder message.
+	ContextStrategySummarizeOlder = "summarize-older"
+	// ContextStrategySystemPinned always keeps system and pinned messages, even
+	// past the budget, then fills what remains with the newest other messages.
+	ContextStrategySystemPinned = "system-pinned"
+)
+
+// MetaKeyContextPlaceholder marks the synthetic message standing in for omitted history.
+const MetaKeyContextPlaceholder = "context_placeholder"
+
+// MetaKeyContextSummary marks the synthetic message carrying the session summary.
+const MetaKeyContextSummary = "context_summary"
+
+// MetaKeyContextSystemPrompt marks the synthetic message carrying a default system prompt.
+const MetaKeyContextSystemPrompt = "context_system_prompt"
+
+// ErrUnknownContextStrategy is returned for strategy names outside the known set
+var ErrUnknownContextStrategy = errors.New("unknown context strategy")
+
+var contextStrategies = map[string]bool{
+	ContextStrategyRecent:         true,
+	ContextStrategySummarizeOlder: true,
+	ContextStrategySystemPinned:   true,
+}
+
+// ValidateContextStrategy reports ErrUnknownContextStrategy if name is not a known strategy
+func ValidateContextStrategy(name string) error {
+	if !contextStrategies[name] {
+		return fmt.Errorf("%w: %s", ErrUnknownContextStrategy, name)
+	}
+	return nil
+}
+
+// ContextSelection is the result of fitting messages into a token budget
+type ContextSelection struct {
+	// Messages are the selected messages in chronological order
+	Messages []model.Message
+	// Dropped is the number of input messages left out
+	Dropped int
+	// Tokens is the token total of Messages
+	Tokens int
+	// Summarized reports whether Messages starts with the session summary, after the system
+	// prompt when there is one
+	Summarized bool
+	// SystemPrompted reports whether Messages starts with an injected system prompt
+	SystemPrompted bool
+}
+
+// SelectContextWithSystemPrompt is SelectContextWithSummary with prompt placed before everything
+// else. The prompt is charged against maxTokens first; when it does not fit on its own it is left
+// out. A nil prompt makes this plain SelectContextWithSummary.
+func SelectContextWithSystemPrompt(messages []model.Message, prompt *model.Message, summary string, maxTokens int, strategy string) (*ContextSelection, error) {
+	if prompt == nil {
+		return SelectContextWithSummary(messages, summary, maxTokens, strategy)
+	}
+	if err := ValidateContextStrategy(strategy); err != nil {
+		return nil, err
+	}
+	n, err := messageTokens(*prompt)
+	if err != nil {
+		return nil, err
+	}
+	if n >= maxTokens {
+		return SelectContextWithSummary(messages, summary, maxTokens, strategy)
+	}
+
+	out, err := SelectContextWithSummary(messages, summary, maxTokens-n, strategy)
+	if err != nil {
+		return nil, err
+	}
+	out.Messages = append([]model.Message{*prompt}, out.Messages...)
+	out.Tokens += n
+	out.SystemPrompted = true
+	return out, nil
+}
+
+// SystemPromptMessage builds the synthetic system message that carries a default system prompt.
+// It is not stored; MetaKeyContextSystemPrompt tells it apart from the session's own messages.
+func SystemPromptMessage(messages []model.Message, prompt string) model.Message {
+	msg := model.Message{
+		Role: model.RoleUser,
+		Meta: datatypes.NewJSONType(map[string]any{
+			model.MsgMetaOriginalRole:  "system",
+			MetaKeyContextSystemPrompt: true,
+		}),
+		Parts: []model.Part{{
+			Type: model.PartTypeText,
+			Text: prompt,
+		}},
+	}
+	if len(messages) > 0 {
+		msg.SessionID = messages[0].SessionID
+		msg.CreatedAt = messages[0].CreatedAt
+	}
+	return msg
+}
+
+// HasSystemMessage reports whether any of messages was sent with the system role.
+func HasSystemMessage(messages []model.Message) bool {
+	for _, m := range messages {
+		if isSystemMessage(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectContextWithSummary is SelectContext for messages that follow a session summary. The
+// summary is prepended as a system message and charged against maxTokens first; when it does
+// not fit on its own it is left out and the selection is plain SelectContext.
+func SelectContextWithSummary(messages []model.Message, summary string, maxTokens int, strategy string) (*ContextSelection, error) {
+	if summary == "" {
+		return SelectContext(messages, maxTokens, strategy)
+	}
+	if err := ValidateContextStrategy(strategy); err != nil {
+		return nil, err
+	}
+	summaryMsg := summaryMessage(messages, summary)
+	n, err := messageTokens(summaryMsg)
+	if err != nil {
+		return nil, err
+	}
+	if n >= maxTokens {
+		return SelectContext(messages, maxTokens, strategy)
+	}
+
+	out, err := SelectContext(messages, maxTokens-n, strategy)
+	if err != nil {
+		return nil, err
+	}
+	out.Messages = append([]model.Message{summaryMsg}, out.Messages...)
+	out.Tokens += n
+	out.Summarized = true
+	return out, nil
+}
+
+// SelectContext picks the messages to send to an LLM within maxTokens.
+// messages must be in chronological order. Token math uses each message's
+// stored TokenCount, counting parts only for messages stored without one.
+// A tool-result message is dropped when the message with its tool call was.
+func SelectContext(messages []model.Message, maxTokens int, strategy string) (*ContextSelection, error) {
+	if err := ValidateContextStrategy(strategy); err != nil {
+		return nil, err
+	}
+	if maxTokens <= 0 {
+		return nil, fmt.Errorf("max_tokens must be > 0, got %d", maxTokens)
+	}
+
+	counts := make([]int, len(messages))
+	total := 0
+	for i, m := range messages {
+		n, err := messageTokens(m)
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = n
+		total += n
+	}
+
+	var keep, pinned map[int]bool
+	switch strategy {
+	case ContextStrategyRecent:
+		keep = fitRecent(counts, maxTokens, nil)
+	case ContextStrategySummarizeOlder:
+		if total <= maxTokens {
+			keep = fitRecent(counts, maxTokens, nil)
+			break
+		}
+		// Reserve room for the widest placeholder before filling the budget.
+		reserve, err := messageTokens(olderPlaceholder(messages, len(messages)))
+		if err != nil {
+			return nil, err
+		}
+		keep = fitRecent(counts, maxTokens-reserve, nil)
+	case ContextStrategySystemPinned:
+		pinned = map[int]bool{}
+		budget := maxTokens
+		for i, m := range messages {
+			if isSystemMessage(m) || m.Pinned {
+				pinned[i] = true
+				budget -= counts[i]
+			}
+		}
+		keep = fitRecent(counts, budget, pinned)
+		for i := range pinned {
+			keep[i] = true
+		}
+	}
+	dropOrphanToolResults(messages, keep)
+	// A pinned tool result stays even when its call was dropped.
+	for i := range pinned {
+		keep[i] = true
+	}
+
+	out := &ContextSelection{Messages: make([]model.Message, 0, len(keep)+1)}
+	out.Dropped = len(messages) - len(keep)
+	if strategy == ContextStrategySummarizeOlder && out.Dropped > 0 {
+		placeholder := olderPlaceholder(messages, out.Dropped)
+		n, err := messageTokens(placeholder)
+		if err != nil {
+			return nil, err
+		}
+		out.Messages = append(out.Messages, placeholder)
+		out.Tokens += n
+	}
+	for i, m := range messages {
+		if keep[i] {
+			out.Messages = append(out.Messages, m)
+			out.Tokens += counts[i]
+		}
+	}
+	return out, nil
+}
+
+// fitRecent walks from the newest message and keeps messages until one no longer fits.
+// Indexes in skip are neither kept nor charged against the budget.
+func fitRecent(counts []int, budget int, skip map[int]bool) map[int]bool {
+	keep := map[int]bool{}
+	for i := len(counts) - 1; i >= 0; i-- {
+		if skip[i] {
+			continue
+		}
+		if counts[i] > budget {
+			break
+		}
+		budget -= counts[i]
+		keep[i] = true
+	}
+	return keep
+}
+
+// dropOrphanToolResults removes kept tool-result-only messages whose tool calls were not kept.
+func dropOrphanToolResults(messages []model.Message, keep map[int]bool) {
+	keptCalls := map[string]bool{}
+	for i := range keep {
+		for _, p := range messages[i].Parts {
+			if p.Type == model.PartTypeToolCall && p.ID() != "" {
+				keptCalls[p.ID()] = true
+			}
+		}
+	}
+	for i := range keep {
+		orphan := len(messages[i].Parts) > 0
+		for _, p := range messages[i].Parts {
+			if p.Type != model.PartTypeToolResult || keptCalls[p.ToolCallID()] {
+				orphan = false
+				break
+			}
+		}
+		if orphan {
+			delete(keep, i)
+		}
+	}
+}
+
+func messageTokens(m model.Message) (int, error) {
+	if m.TokenEncoding != "" {
+		return m.TokenCount, nil
+	}
+	return tokenizer.CountPartsTokens(m.Parts, tokenizer.DefaultEncoding)
+}
+
+func isSystemMessage(m model.Message) bool {
+	role, _ := m.Meta.Data()[model.MsgMetaOriginalRole].(string)
+	return role == "system"
+}
+
+// olderPlaceholder builds the system message that stands in for n omitted messages
+func olderPlaceholder(messages []model.Message, n int) model.Message {
+	msg := model.Message{
+		Role: model.RoleUser,
+		Meta: datatypes.NewJSONType(map[string]any{
+			model.MsgMetaOriginalRole: "system",
+			MetaKeyContextPlaceholder: true,
+		}),
+		Parts: []model.Part{{
+			Type: model.PartTypeText,
+			Text: fmt.Sprintf("[%d earlier messages omitted]", n),
+		}},
+	}
+	if len(messages) > 0 {
+		msg.SessionID = messages[0].SessionID
+		msg.CreatedAt = messages[0].CreatedAt
+	}
+	return msg
+}
+
+// summaryMessage builds the system message that carries the session summary
+func summaryMessage(messages []model.Message, summary string) model.Message {
+	msg := model.Message{
+		Role: model.RoleUser,
+		Meta: datatypes.NewJSONType(map[string]any{
+			model.MsgMetaOriginalRole: "system",
+			MetaKeyContextSummary:     true,
+		}),
+		Parts: []model.Part{{
+			Type: model.PartTypeText,
+			Text: "Summary of the earlier conversation:\n" + summary,
+		}},
+	}
+	if len(messages) > 0 {
+		msg.SessionID = messages[0].SessionID
+		msg.CreatedAt = messages[0].CreatedAt
+	}
+	return msg
+}
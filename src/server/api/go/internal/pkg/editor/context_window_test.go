@@ -0,0 +1,242 @@
+package editor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+// countedMessage builds a message with a precomputed token count
+func countedMessage(role string, tokens int, parts ...model.Part) model.Message {
+	return model.Message{
+		ID:            uuid.New(),
+		Role:          role,
+		Parts:         parts,
+		TokenCount:    tokens,
+		TokenEncoding: "o200k_base",
+	}
+}
+
+func systemMessage(tokens int) model.Message {
+	m := countedMessage(model.RoleUser, tokens, model.Part{Type: model.PartTypeText, Text: "be nice"})
+	m.Meta = datatypes.NewJSONType(map[string]any{model.MsgMetaOriginalRole: "system"})
+	return m
+}
+
+func TestSelectContext_Validation(t *testing.T) {
+	_, err := SelectContext(nil, 100, "oldest")
+	assert.ErrorIs(t, err, ErrUnknownContextStrategy)
+
+	_, err = SelectContext(nil, 0, ContextStrategyRecent)
+	assert.Error(t, err)
+
+	out, err := SelectContext(nil, 100, ContextStrategyRecent)
+	require.NoError(t, err)
+	assert.Empty(t, out.Messages)
+	assert.Equal(t, 0, out.Dropped)
+}
+
+func TestSelectContext_Recent(t *testing.T) {
+	msgs := []model.Message{
+		countedMessage(model.RoleUser, 50),
+		countedMessage(model.RoleAssistant, 30),
+		countedMessage(model.RoleUser, 20),
+		countedMessage(model.RoleAssistant, 40),
+	}
+
+	out, err := SelectContext(msgs, 65, ContextStrategyRecent)
+	require.NoError(t, err)
+	require.Len(t, out.Messages, 2)
+	assert.Equal(t, msgs[2].ID, out.Messages[0].ID)
+	assert.Equal(t, msgs[3].ID, out.Messages[1].ID)
+	assert.Equal(t, 2, out.Dropped)
+	assert.Equal(t, 60, out.Tokens)
+
+	t.Run("stops at first message that does not fit", func(t *testing.T) {
+		out, err := SelectContext(msgs, 95, ContextStrategyRecent)
+		require.NoError(t, err)
+		assert.Len(t, out.Messages, 3)
+		assert.Equal(t, 1, out.Dropped)
+	})
+}
+
+func TestSelectContext_SummarizeOlder(t *testing.T) {
+	msgs := []model.Message{
+		countedMessage(model.RoleUser, 500),
+		countedMessage(model.RoleAssistant, 500),
+		countedMessage(model.RoleUser, 20),
+	}
+
+	t.Run("no placeholder when everything fits", func(t *testing.T) {
+		out, err := SelectContext(msgs, 2000, ContextStrategySummarizeOlder)
+		require.NoError(t, err)
+		assert.Len(t, out.Messages, 3)
+		assert.Equal(t, 0, out.Dropped)
+	})
+
+	t.Run("placeholder replaces dropped history", func(t *testing.T) {
+		out, err := SelectContext(msgs, 100, ContextStrategySummarizeOlder)
+		require.NoError(t, err)
+		require.Len(t, out.Messages, 2)
+		assert.Equal(t, 2, out.Dropped)
+
+		ph := out.Messages[0]
+		assert.Equal(t, uuid.Nil, ph.ID)
+		assert.Equal(t, true, ph.Meta.Data()[MetaKeyContextPlaceholder])
+		assert.Equal(t, "system", ph.Meta.Data()[model.MsgMetaOriginalRole])
+		assert.Contains(t, ph.Parts[0].Text, "2 earlier messages omitted")
+		assert.Equal(t, msgs[2].ID, out.Messages[1].ID)
+		assert.LessOrEqual(t, out.Tokens, 100)
+	})
+}
+
+func TestSelectContext_SystemPinned(t *testing.T) {
+	msgs := []model.Message{
+		systemMessage(40),
+		countedMessage(model.RoleUser, 30),
+		countedMessage(model.RoleAssistant, 30),
+		countedMessage(model.RoleUser, 20),
+	}
+
+	out, err := SelectContext(msgs, 100, ContextStrategySystemPinned)
+	require.NoError(t, err)
+	require.Len(t, out.Messages, 3)
+	assert.Equal(t, msgs[0].ID, out.Messages[0].ID)
+	assert.Equal(t, msgs[2].ID, out.Messages[1].ID)
+	assert.Equal(t, msgs[3].ID, out.Messages[2].ID)
+	assert.Equal(t, 1, out.Dropped)
+
+	t.Run("system messages kept even over budget", func(t *testing.T) {
+		out, err := SelectContext(msgs, 10, ContextStrategySystemPinned)
+		require.NoError(t, err)
+		require.Len(t, out.Messages, 1)
+		assert.Equal(t, msgs[0].ID, out.Messages[0].ID)
+		assert.Equal(t, 3, out.Dropped)
+	})
+
+	t.Run("pinned messages kept even over budget", func(t *testing.T) {
+		pinned := countedMessage(model.RoleUser, 60)
+		pinned.Pinned = true
+		result := countedMessage(model.RoleUser, 5, model.Part{Type: model.PartTypeToolResult, Text: "ok", Meta: map[string]any{model.MetaKeyToolCallID: "call_gone"}})
+		result.Pinned = true
+		msgs := []model.Message{pinned, result, countedMessage(model.RoleAssistant, 30), countedMessage(model.RoleUser, 20)}
+
+		out, err := SelectContext(msgs, 50, ContextStrategySystemPinned)
+		require.NoError(t, err)
+		require.Len(t, out.Messages, 2)
+		assert.Equal(t, pinned.ID, out.Messages[0].ID)
+		assert.Equal(t, result.ID, out.Messages[1].ID, "a pinned orphan tool result is kept")
+		assert.Equal(t, 65, out.Tokens)
+
+		out, err = SelectContext(msgs, 100, ContextStrategyRecent)
+		require.NoError(t, err)
+		assert.NotContains(t, out.Messages, pinned, "other strategies ignore pins")
+	})
+}
+
+func TestSelectContext_DropsOrphanToolResults(t *testing.T) {
+	call := model.Part{Type: model.PartTypeToolCall, Meta: map[string]any{model.MetaKeyID: "call_1", model.MetaKeyName: "search"}}
+	result := model.Part{Type: model.PartTypeToolResult, Text: "ok", Meta: map[string]any{model.MetaKeyToolCallID: "call_1"}}
+	msgs := []model.Message{
+		countedMessage(model.RoleAssistant, 50, call),
+		countedMessage(model.RoleUser, 10, result),
+		countedMessage(model.RoleAssistant, 10, model.Part{Type: model.PartTypeText, Text: "done"}),
+	}
+
+	out, err := SelectContext(msgs, 30, ContextStrategyRecent)
+	require.NoError(t, err)
+	require.Len(t, out.Messages, 1)
+	assert.Equal(t, msgs[2].ID, out.Messages[0].ID)
+	assert.Equal(t, 2, out.Dropped)
+}
+
+func TestSelectContext_CountsUncountedMessages(t *testing.T) {
+	initTokenizer(t)
+
+	legacy := model.Message{ID: uuid.New(), Role: model.RoleUser, Parts: []model.Part{{Type: model.PartTypeText, Text: "hello world"}}}
+	out, err := SelectContext([]model.Message{legacy}, 100, ContextStrategyRecent)
+	require.NoError(t, err)
+	assert.Len(t, out.Messages, 1)
+	assert.Greater(t, out.Tokens, 0)
+}
+
+func TestSelectContextWithSummary(t *testing.T) {
+	msgs := []model.Message{
+		countedMessage(model.RoleUser, 30),
+		countedMessage(model.RoleAssistant, 40),
+	}
+	summaryTokens, err := messageTokens(summaryMessage(msgs, "user asked about pricing"))
+	require.NoError(t, err)
+
+	t.Run("summary is prepended and charged first", func(t *testing.T) {
+		out, err := SelectContextWithSummary(msgs, "user asked about pricing", summaryTokens+45, ContextStrategyRecent)
+		require.NoError(t, err)
+		require.Len(t, out.Messages, 2)
+		assert.True(t, out.Summarized)
+		assert.Equal(t, true, out.Messages[0].Meta.Data()[MetaKeyContextSummary])
+		assert.True(t, isSystemMessage(out.Messages[0]))
+		assert.Equal(t, msgs[1].ID, out.Messages[1].ID)
+		assert.Equal(t, summaryTokens+40, out.Tokens)
+	})
+
+	t.Run("summary that does not fit is left out", func(t *testing.T) {
+		out, err := SelectContextWithSummary(msgs, "user asked about pricing", summaryTokens, ContextStrategyRecent)
+		require.NoError(t, err)
+		assert.False(t, out.Summarized)
+		assert.Empty(t, out.Messages)
+	})
+
+	t.Run("no summary", func(t *testing.T) {
+		out, err := SelectContextWithSummary(msgs, "", 100, ContextStrategyRecent)
+		require.NoError(t, err)
+		assert.False(t, out.Summarized)
+		assert.Len(t, out.Messages, 2)
+	})
+}
+
+func TestSelectContextWithSystemPrompt(t *testing.T) {
+	msgs := []model.Message{
+		countedMessage(model.RoleUser, 30),
+		countedMessage(model.RoleAssistant, 40),
+	}
+	prompt := SystemPromptMessage(msgs, "You are a support agent.")
+	promptTokens, err := messageTokens(prompt)
+	require.NoError(t, err)
+	summaryTokens, err := messageTokens(summaryMessage(msgs, "user asked about pricing"))
+	require.NoError(t, err)
+
+	t.Run("prompt comes before the summary", func(t *testing.T) {
+		out, err := SelectContextWithSystemPrompt(msgs, &prompt, "user asked about pricing", promptTokens+summaryTokens+45, ContextStrategyRecent)
+		require.NoError(t, err)
+		require.Len(t, out.Messages, 3)
+		assert.True(t, out.SystemPrompted)
+		assert.True(t, out.Summarized)
+		assert.Equal(t, true, out.Messages[0].Meta.Data()[MetaKeyContextSystemPrompt])
+		assert.True(t, isSystemMessage(out.Messages[0]))
+		assert.Equal(t, true, out.Messages[1].Meta.Data()[MetaKeyContextSummary])
+		assert.Equal(t, msgs[1].ID, out.Messages[2].ID)
+		assert.Equal(t, promptTokens+summaryTokens+40, out.Tokens)
+	})
+
+	t.Run("prompt that does not fit is left out", func(t *testing.T) {
+		out, err := SelectContextWithSystemPrompt(msgs, &prompt, "", promptTokens, ContextStrategyRecent)
+		require.NoError(t, err)
+		assert.False(t, out.SystemPrompted)
+	})
+
+	t.Run("no prompt", func(t *testing.T) {
+		out, err := SelectContextWithSystemPrompt(msgs, nil, "", 100, ContextStrategyRecent)
+		require.NoError(t, err)
+		assert.False(t, out.SystemPrompted)
+		assert.Len(t, out.Messages, 2)
+	})
+}
+
+func TestHasSystemMessage(t *testing.T) {
+	assert.False(t, HasSystemMessage([]model.Message{countedMessage(model.RoleUser, 1)}))
+	assert.True(t, HasSystemMessage([]model.Message{countedMessage(model.RoleUser, 1), systemMessage(1)}))
+}
@@ -0,0 +1,94 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// DuplicateRun is a message and the consecutive copies of it below it in the tree.
+type DuplicateRun struct {
+	SurvivorID   uuid.UUID   `json:"survivor_id"`
+	DuplicateIDs []uuid.UUID `json:"duplicate_ids"`
+}
+
+// FindConsecutiveDuplicates finds messages that repeat their parent: the same role and the same
+// text parts, in order. Such copies are what a client retrying a request leaves behind. Only
+// messages made of text and asset parts qualify, and a message needs at least one text part, so
+// tool calls, tool results and streaming messages are never merged; asset parts may differ.
+// A chain of copies collapses into its topmost message. messages must be in storage order and
+// carry their parts; runs follow the order of their survivors, duplicates in storage order.
+func FindConsecutiveDuplicates(messages []model.Message) []DuplicateRun {
+	byID := make(map[uuid.UUID]int, len(messages))
+	keys := make([]string, len(messages))
+	for i, m := range messages {
+		byID[m.ID] = i
+		keys[i] = duplicateKey(m)
+	}
+
+	// copyOf[i] is the index of the parent message i repeats, -1 when it repeats nothing.
+	copyOf := make([]int, len(messages))
+	for i, m := range messages {
+		copyOf[i] = -1
+		if keys[i] == "" || m.ParentID == nil {
+			continue
+		}
+		if p, ok := byID[*m.ParentID]; ok && keys[p] == keys[i] {
+			copyOf[i] = p
+		}
+	}
+
+	runs := map[int]*DuplicateRun{}
+	var order []int
+	for i := range messages {
+		if copyOf[i] < 0 {
+			continue
+		}
+		survivor := i
+		for steps := 0; copyOf[survivor] >= 0 && steps < len(messages); steps++ {
+			survivor = copyOf[survivor]
+		}
+		if copyOf[survivor] >= 0 {
+			continue // cycle in the parent chain
+		}
+		run, ok := runs[survivor]
+		if !ok {
+			run = &DuplicateRun{SurvivorID: messages[survivor].ID}
+			runs[survivor] = run
+			order = append(order, survivor)
+		}
+		run.DuplicateIDs = append(run.DuplicateIDs, messages[i].ID)
+	}
+
+	// A run is created when its first duplicate is met, which may come before the survivor.
+	slices.Sort(order)
+	out := make([]DuplicateRun, 0, len(order))
+	for _, i := range order {
+		out = append(out, *runs[i])
+	}
+	return out
+}
+
+// duplicateKey identifies the content compared between a message and its parent, or returns ""
+// when the message cannot be merged.
+func duplicateKey(m model.Message) string {
+	if m.Streaming {
+		return ""
+	}
+	var texts []string
+	for _, p := range m.Parts {
+		switch {
+		case p.Type == model.PartTypeText:
+			texts = append(texts, p.Text)
+		case p.Asset != nil:
+		default:
+			return ""
+		}
+	}
+	if len(texts) == 0 {
+		return ""
+	}
+	return m.Role + "\x00" + strings.Join(texts, "\x00")
+}
@@ -0,0 +1,69 @@
+package editor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindConsecutiveDuplicates(t *testing.T) {
+	text := func(role, s string) model.Message {
+		return model.Message{Role: role, Parts: []model.Part{model.NewTextPart(s)}}
+	}
+
+	t.Run("a chain of copies collapses into the first", func(t *testing.T) {
+		msgs := chain(
+			text(model.RoleUser, "hi"),
+			text(model.RoleUser, "hi"),
+			text(model.RoleUser, "hi"),
+			text(model.RoleAssistant, "hello"),
+		)
+		runs := FindConsecutiveDuplicates(msgs)
+		require.Len(t, runs, 1)
+		assert.Equal(t, msgs[0].ID, runs[0].SurvivorID)
+		assert.Equal(t, []uuid.UUID{msgs[1].ID, msgs[2].ID}, runs[0].DuplicateIDs)
+	})
+
+	t.Run("role and text must match", func(t *testing.T) {
+		assert.Empty(t, FindConsecutiveDuplicates(chain(text(model.RoleUser, "hi"), text(model.RoleAssistant, "hi"))))
+		assert.Empty(t, FindConsecutiveDuplicates(chain(text(model.RoleUser, "hi"), text(model.RoleUser, "hi again"))))
+	})
+
+	t.Run("asset parts may differ", func(t *testing.T) {
+		withImage := text(model.RoleUser, "look")
+		withImage.Parts = append(withImage.Parts, model.Part{Type: model.PartTypeImage, Asset: &model.Asset{SHA256: "img"}})
+		runs := FindConsecutiveDuplicates(chain(text(model.RoleUser, "look"), withImage))
+		assert.Len(t, runs, 1)
+	})
+
+	t.Run("tool calls and streaming messages are never merged", func(t *testing.T) {
+		call := model.Message{Role: model.RoleAssistant, Parts: []model.Part{model.NewTextPart("checking"), toolCall("a")}}
+		assert.Empty(t, FindConsecutiveDuplicates(chain(call, call)))
+
+		streaming := text(model.RoleAssistant, "partial")
+		streaming.Streaming = true
+		assert.Empty(t, FindConsecutiveDuplicates(chain(text(model.RoleAssistant, "partial"), streaming)))
+
+		assert.Empty(t, FindConsecutiveDuplicates(chain(model.Message{Role: model.RoleUser}, model.Message{Role: model.RoleUser})))
+	})
+
+	t.Run("siblings are not consecutive", func(t *testing.T) {
+		msgs := chain(text(model.RoleUser, "root"), text(model.RoleAssistant, "a"))
+		sibling := text(model.RoleAssistant, "a")
+		sibling.ID = uuid.New()
+		sibling.ParentID = &msgs[0].ID
+		assert.Empty(t, FindConsecutiveDuplicates(append(msgs, sibling)))
+	})
+
+	t.Run("runs follow survivor order when a copy is stored first", func(t *testing.T) {
+		msgs := chain(text(model.RoleUser, "a"), text(model.RoleUser, "a"), text(model.RoleUser, "b"), text(model.RoleUser, "b"))
+		reordered := []model.Message{msgs[3], msgs[1], msgs[0], msgs[2]}
+		runs := FindConsecutiveDuplicates(reordered)
+		require.Len(t, runs, 2)
+		assert.Equal(t, msgs[0].ID, runs[0].SurvivorID)
+		assert.Equal(t, msgs[2].ID, runs[1].SurvivorID)
+	})
+}
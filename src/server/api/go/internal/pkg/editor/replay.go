@@ -0,0 +1,37 @@
+package editor
+
+import "github.com/memodb-io/Acontext/internal/modules/model"
+
+// ReplayTurn is one recorded assistant completion: the context the assistant was given and the
+// messages it answered with.
+type ReplayTurn struct {
+	Index  int             `json:"index"`
+	Input  []model.Message `json:"input"`
+	Output []model.Message `json:"output"`
+}
+
+// ReplayTurns splits a branch, root first, into the turns an evaluation harness can re-run. Each
+// run of consecutive assistant messages is the output of one turn, and every message before it,
+// tool calls and tool results included, is that turn's input. Messages after the last assistant
+// run have no recorded output and belong to no turn. Input and Output share messages' backing
+// array, so the turns must not be modified.
+func ReplayTurns(messages []model.Message) []ReplayTurn {
+	turns := []ReplayTurn{}
+	for i := 0; i < len(messages); {
+		if messages[i].Role != model.RoleAssistant {
+			i++
+			continue
+		}
+		end := i + 1
+		for end < len(messages) && messages[end].Role == model.RoleAssistant {
+			end++
+		}
+		turns = append(turns, ReplayTurn{
+			Index:  len(turns),
+			Input:  messages[:i:i],
+			Output: messages[i:end:end],
+		})
+		i = end
+	}
+	return turns
+}
@@ -0,0 +1,59 @@
+package editor
+
+import (
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayTurns(t *testing.T) {
+	t.Run("tool calls and results stay in order", func(t *testing.T) {
+		msgs := chain(
+			model.Message{Role: model.RoleUser, Parts: []model.Part{model.NewTextPart("weather?")}},
+			model.Message{Role: model.RoleAssistant, Parts: []model.Part{toolCall("a")}},
+			model.Message{Role: model.RoleUser, Parts: []model.Part{toolResult("a")}},
+			model.Message{Role: model.RoleAssistant, Parts: []model.Part{model.NewTextPart("sunny")}},
+		)
+		turns := ReplayTurns(msgs)
+		require.Len(t, turns, 2)
+
+		assert.Equal(t, 0, turns[0].Index)
+		assert.Equal(t, msgs[:1], turns[0].Input)
+		assert.Equal(t, msgs[1:2], turns[0].Output)
+
+		assert.Equal(t, 1, turns[1].Index)
+		assert.Equal(t, msgs[:3], turns[1].Input)
+		assert.Equal(t, msgs[3:], turns[1].Output)
+	})
+
+	t.Run("consecutive assistant messages form one output", func(t *testing.T) {
+		msgs := chain(
+			model.Message{Role: model.RoleUser},
+			model.Message{Role: model.RoleAssistant},
+			model.Message{Role: model.RoleAssistant},
+		)
+		turns := ReplayTurns(msgs)
+		require.Len(t, turns, 1)
+		assert.Len(t, turns[0].Input, 1)
+		assert.Len(t, turns[0].Output, 2)
+	})
+
+	t.Run("leading assistant has empty input", func(t *testing.T) {
+		turns := ReplayTurns(chain(model.Message{Role: model.RoleAssistant}))
+		require.Len(t, turns, 1)
+		assert.Empty(t, turns[0].Input)
+	})
+
+	t.Run("trailing messages without a reply belong to no turn", func(t *testing.T) {
+		msgs := chain(
+			model.Message{Role: model.RoleUser},
+			model.Message{Role: model.RoleAssistant},
+			model.Message{Role: model.RoleUser},
+		)
+		assert.Len(t, ReplayTurns(msgs), 1)
+		assert.Empty(t, ReplayTurns(msgs[2:]))
+		assert.NotNil(t, ReplayTurns(nil))
+	})
+}
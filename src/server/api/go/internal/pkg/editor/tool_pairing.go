@@ -0,0 +1,128 @@
+package editor
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// Tool pairing issue types
+const (
+	IssueOrphanedToolCall   = "orphaned_tool_call"   // A tool-call no downstream tool-result answers
+	IssueOrphanedToolResult = "orphaned_tool_result" // A tool-result without an upstream tool-call
+	IssueMissingToolCallID  = "missing_tool_call_id" // A tool-call or tool-result without its correlation ID
+	IssueDuplicateToolCall  = "duplicate_tool_call"  // A tool-call reusing an ID already called upstream
+)
+
+// ToolPairingIssue describes one tool-call or tool-result part that breaks pairing.
+type ToolPairingIssue struct {
+	Type       string    `json:"type"`
+	MessageID  uuid.UUID `json:"message_id"`
+	PartIndex  int       `json:"part_index"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	Detail     string    `json:"detail"`
+}
+
+// ValidateToolPairing checks that every tool-call part is answered by a tool-result part further
+// down the same branch, and that every tool-result answers such a call. A call is correlated by
+// its model.MetaKeyID and a result by its model.MetaKeyToolCallID. "Downstream" follows ParentID:
+// a result answers a call in its own message (at a later part) or in one of its ancestors, so a
+// result on a sibling branch does not count. messages must be in storage order. Issues follow
+// part order, with orphaned calls listed last.
+func ValidateToolPairing(messages []model.Message) []ToolPairingIssue {
+	type callSite struct {
+		msgIdx   int
+		partIdx  int
+		answered bool
+	}
+
+	byID := make(map[uuid.UUID]int, len(messages))
+	for i, m := range messages {
+		byID[m.ID] = i
+	}
+	// calls[messageIdx][toolCallID] is the call site of that ID within the message
+	calls := make([]map[string]*callSite, len(messages))
+	var ordered []*callSite
+
+	// upstreamCall finds the nearest call with id at or above message i, before part p.
+	upstreamCall := func(i, p int, id string) *callSite {
+		seen := make(map[int]bool)
+		for !seen[i] {
+			seen[i] = true
+			if c, ok := calls[i][id]; ok && (c.msgIdx != i || c.partIdx < p) {
+				return c
+			}
+			parent := messages[i].ParentID
+			if parent == nil {
+				return nil
+			}
+			next, ok := byID[*parent]
+			if !ok {
+				return nil
+			}
+			i, p = next, len(messages[next].Parts)
+		}
+		return nil // cycle in the parent chain
+	}
+
+	var issues []ToolPairingIssue
+	for i, m := range messages {
+		for p, part := range m.Parts {
+			switch part.Type {
+			case model.PartTypeToolCall:
+				id := part.ID()
+				if id == "" {
+					issues = append(issues, ToolPairingIssue{
+						Type: IssueMissingToolCallID, MessageID: m.ID, PartIndex: p,
+						Detail: fmt.Sprintf("tool-call has no %q", model.MetaKeyID),
+					})
+					continue
+				}
+				if upstreamCall(i, p, id) != nil {
+					issues = append(issues, ToolPairingIssue{
+						Type: IssueDuplicateToolCall, MessageID: m.ID, PartIndex: p, ToolCallID: id,
+						Detail: "tool-call ID was already used upstream",
+					})
+					continue
+				}
+				if calls[i] == nil {
+					calls[i] = make(map[string]*callSite)
+				}
+				c := &callSite{msgIdx: i, partIdx: p}
+				calls[i][id] = c
+				ordered = append(ordered, c)
+			case model.PartTypeToolResult:
+				id := part.ToolCallID()
+				if id == "" {
+					issues = append(issues, ToolPairingIssue{
+						Type: IssueMissingToolCallID, MessageID: m.ID, PartIndex: p,
+						Detail: fmt.Sprintf("tool-result has no %q", model.MetaKeyToolCallID),
+					})
+					continue
+				}
+				c := upstreamCall(i, p, id)
+				if c == nil {
+					issues = append(issues, ToolPairingIssue{
+						Type: IssueOrphanedToolResult, MessageID: m.ID, PartIndex: p, ToolCallID: id,
+						Detail: "no upstream tool-call has this ID",
+					})
+					continue
+				}
+				c.answered = true
+			}
+		}
+	}
+
+	for _, c := range ordered {
+		if c.answered {
+			continue
+		}
+		m := messages[c.msgIdx]
+		issues = append(issues, ToolPairingIssue{
+			Type: IssueOrphanedToolCall, MessageID: m.ID, PartIndex: c.partIdx, ToolCallID: m.Parts[c.partIdx].ID(),
+			Detail: "no downstream tool-result answers this call",
+		})
+	}
+	return issues
+}
@@ -0,0 +1,85 @@
+package editor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func toolCall(id string) model.Part {
+	return model.Part{Type: model.PartTypeToolCall, Meta: map[string]interface{}{model.MetaKeyID: id, model.MetaKeyName: "search"}}
+}
+
+func toolResult(id string) model.Part {
+	return model.Part{Type: model.PartTypeToolResult, Text: "ok", Meta: map[string]interface{}{model.MetaKeyToolCallID: id}}
+}
+
+// chain links messages parent to child in slice order.
+func chain(msgs ...model.Message) []model.Message {
+	for i := range msgs {
+		msgs[i].ID = uuid.New()
+		if i > 0 {
+			parent := msgs[i-1].ID
+			msgs[i].ParentID = &parent
+		}
+	}
+	return msgs
+}
+
+func TestValidateToolPairing(t *testing.T) {
+	t.Run("paired calls are valid", func(t *testing.T) {
+		msgs := chain(
+			model.Message{Role: model.RoleAssistant, Parts: []model.Part{toolCall("a"), toolCall("b")}},
+			model.Message{Role: model.RoleUser, Parts: []model.Part{toolResult("b"), toolResult("a")}},
+		)
+		assert.Empty(t, ValidateToolPairing(msgs))
+	})
+
+	t.Run("result in the same message after the call is valid", func(t *testing.T) {
+		msgs := chain(model.Message{Role: model.RoleAssistant, Parts: []model.Part{toolCall("a"), toolResult("a")}})
+		assert.Empty(t, ValidateToolPairing(msgs))
+	})
+
+	t.Run("flags orphaned calls and results", func(t *testing.T) {
+		msgs := chain(
+			model.Message{Role: model.RoleUser, Parts: []model.Part{toolResult("early")}},
+			model.Message{Role: model.RoleAssistant, Parts: []model.Part{toolCall("a"), toolCall("early")}},
+			model.Message{Role: model.RoleUser, Parts: []model.Part{toolResult("a")}},
+		)
+		issues := ValidateToolPairing(msgs)
+		assert.Equal(t, []ToolPairingIssue{
+			{Type: IssueOrphanedToolResult, MessageID: msgs[0].ID, PartIndex: 0, ToolCallID: "early", Detail: "no upstream tool-call has this ID"},
+			{Type: IssueOrphanedToolCall, MessageID: msgs[1].ID, PartIndex: 1, ToolCallID: "early", Detail: "no downstream tool-result answers this call"},
+		}, issues)
+	})
+
+	t.Run("results on a sibling branch do not count", func(t *testing.T) {
+		msgs := chain(
+			model.Message{Role: model.RoleUser, Parts: []model.Part{{Type: model.PartTypeText, Text: "hi"}}},
+			model.Message{Role: model.RoleAssistant, Parts: []model.Part{toolCall("a")}},
+		)
+		sibling := model.Message{ID: uuid.New(), ParentID: &msgs[0].ID, Role: model.RoleUser, Parts: []model.Part{toolResult("a")}}
+		msgs = append(msgs, sibling)
+
+		issues := ValidateToolPairing(msgs)
+		assert.Len(t, issues, 2)
+		assert.Equal(t, IssueOrphanedToolResult, issues[0].Type)
+		assert.Equal(t, sibling.ID, issues[0].MessageID)
+		assert.Equal(t, IssueOrphanedToolCall, issues[1].Type)
+	})
+
+	t.Run("flags missing and duplicate IDs", func(t *testing.T) {
+		msgs := chain(
+			model.Message{Role: model.RoleAssistant, Parts: []model.Part{{Type: model.PartTypeToolCall}, toolCall("a")}},
+			model.Message{Role: model.RoleUser, Parts: []model.Part{{Type: model.PartTypeToolResult, Text: "x"}, toolResult("a")}},
+			model.Message{Role: model.RoleAssistant, Parts: []model.Part{toolCall("a")}},
+		)
+		var types []string
+		for _, issue := range ValidateToolPairing(msgs) {
+			types = append(types, issue.Type)
+		}
+		assert.Equal(t, []string{IssueMissingToolCallID, IssueMissingToolCallID, IssueDuplicateToolCall}, types)
+	})
+}
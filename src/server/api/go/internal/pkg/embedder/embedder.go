@@ -0,0 +1,57 @@
+package embedder
+
+import (
+	"context"
+	"fmt"
+
+	openai "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// Embedder turns texts into embedding vectors.
+type Embedder interface {
+	// Embed returns one vector per text, in the order of texts.
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+}
+
+// OpenAI is an Embedder for the OpenAI embeddings API and services compatible with it.
+type OpenAI struct {
+	client openai.Client
+}
+
+// NewOpenAI returns an OpenAI embedder; an empty baseURL uses the OpenAI API.
+func NewOpenAI(apiKey, baseURL string, opts ...option.RequestOption) *OpenAI {
+	opts = append([]option.RequestOption{option.WithAPIKey(apiKey)}, opts...)
+	if baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+	return &OpenAI{client: openai.NewClient(opts...)}
+}
+
+func (o *OpenAI) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return [][]float32{}, nil
+	}
+	resp, err := o.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+		Model: model,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create embeddings: %w", err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("create embeddings: got %d vectors for %d texts", len(resp.Data), len(texts))
+	}
+	out := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || int(d.Index) >= len(texts) || out[d.Index] != nil {
+			return nil, fmt.Errorf("create embeddings: unexpected vector index %d", d.Index)
+		}
+		vec := make([]float32, len(d.Embedding))
+		for i, f := range d.Embedding {
+			vec[i] = float32(f)
+		}
+		out[d.Index] = vec
+	}
+	return out, nil
+}
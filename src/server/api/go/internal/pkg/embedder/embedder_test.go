@@ -0,0 +1,44 @@
+package embedder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAI_Embed(t *testing.T) {
+	var got struct {
+		Input []string `json:"input"`
+		Model string   `json:"model"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/embeddings", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Header().Set("Content-Type", "application/json")
+		// Vectors come back out of order; Embed sorts them by index.
+		_, _ = w.Write([]byte(`{"object":"list","model":"m","data":[
+			{"object":"embedding","index":1,"embedding":[0,1]},
+			{"object":"embedding","index":0,"embedding":[1,0.5]}
+		]}`))
+	}))
+	defer srv.Close()
+
+	e := NewOpenAI("sk-test", srv.URL)
+	vecs, err := e.Embed(context.Background(), "text-embedding-3-small", []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got.Input)
+	assert.Equal(t, "text-embedding-3-small", got.Model)
+	assert.Equal(t, [][]float32{{1, 0.5}, {0, 1}}, vecs)
+
+	t.Run("no texts makes no request", func(t *testing.T) {
+		vecs, err := NewOpenAI("sk-test", "http://127.0.0.1:0").Embed(context.Background(), "m", nil)
+		require.NoError(t, err)
+		assert.Empty(t, vecs)
+	})
+}
@@ -0,0 +1,35 @@
+package enricher
+
+import (
+	"context"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/mediameta"
+)
+
+// AssetMetadataName is the name of the AssetMetadata enricher. Unlike other enrichers, its
+// results are written to the part's Asset.Meta rather than under its name in the part's Meta.
+const AssetMetadataName = "asset_metadata"
+
+// AssetMetadata extracts the intrinsic metadata of media assets too large to be read while they
+// were uploaded; smaller ones are described on upload.
+type AssetMetadata struct {
+	extractors *mediameta.Registry
+	minBytes   int64
+}
+
+// NewAssetMetadata returns an AssetMetadata for the assets over minBytes bytes that extractors
+// can read.
+func NewAssetMetadata(extractors *mediameta.Registry, minBytes int64) *AssetMetadata {
+	return &AssetMetadata{extractors: extractors, minBytes: minBytes}
+}
+
+func (a *AssetMetadata) Name() string { return AssetMetadataName }
+
+func (a *AssetMetadata) Accepts(asset model.Asset) bool {
+	return asset.Meta == nil && asset.SizeB > a.minBytes && a.extractors.Accepts(asset.MIME)
+}
+
+func (a *AssetMetadata) Process(ctx context.Context, asset model.Asset, content []byte) (map[string]any, error) {
+	return a.extractors.Extract(ctx, asset.MIME, content)
+}
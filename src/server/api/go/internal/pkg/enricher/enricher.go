@@ -0,0 +1,70 @@
+package enricher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// Enricher derives metadata from a media asset, e.g. OCR text from an image, a transcript from
+// audio or a caption for a video. The map Process returns is stored in the part's Meta under Name.
+type Enricher interface {
+	// Name identifies the enricher and is the Part.Meta key its results are written to
+	// (see model.MetaKeyOCR, model.MetaKeyASR and model.MetaKeyCaption).
+	Name() string
+	// Accepts reports whether the enricher handles the asset, typically by its MIME type.
+	Accepts(asset model.Asset) bool
+	// Process runs the enrichment over the asset's content.
+	Process(ctx context.Context, asset model.Asset, content []byte) (map[string]any, error)
+}
+
+// Registry holds the enrichers available to the enrichment pipeline. It is safe for
+// concurrent use, so providers may be registered after the server has started.
+type Registry struct {
+	mu        sync.RWMutex
+	enrichers []Enricher
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds e to the registry. Names must be unique.
+func (r *Registry) Register(e Enricher) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.enrichers {
+		if existing.Name() == e.Name() {
+			return fmt.Errorf("enricher %q is already registered", e.Name())
+		}
+	}
+	r.enrichers = append(r.enrichers, e)
+	return nil
+}
+
+// Get returns the enricher registered under name.
+func (r *Registry) Get(name string) (Enricher, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.enrichers {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// For returns the enrichers that accept asset, in registration order.
+func (r *Registry) For(asset model.Asset) []Enricher {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []Enricher
+	for _, e := range r.enrichers {
+		if e.Accepts(asset) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package enricher
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/pkg/mediameta"
+	"github.com/stretchr/testify/assert"
+)
+
+type prefixEnricher struct {
+	name   string
+	prefix string
+}
+
+func (e prefixEnricher) Name() string { return e.name }
+
+func (e prefixEnricher) Accepts(asset model.Asset) bool {
+	return strings.HasPrefix(asset.MIME, e.prefix)
+}
+
+func (e prefixEnricher) Process(ctx context.Context, asset model.Asset, content []byte) (map[string]any, error) {
+	return map[string]any{"bytes": len(content)}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.Register(prefixEnricher{name: model.MetaKeyOCR, prefix: "image/"}))
+	assert.NoError(t, r.Register(prefixEnricher{name: model.MetaKeyCaption, prefix: "image/"}))
+	assert.NoError(t, r.Register(prefixEnricher{name: model.MetaKeyASR, prefix: "audio/"}))
+	assert.Error(t, r.Register(prefixEnricher{name: model.MetaKeyOCR, prefix: "video/"}), "names are unique")
+
+	e, ok := r.Get(model.MetaKeyASR)
+	assert.True(t, ok)
+	assert.Equal(t, model.MetaKeyASR, e.Name())
+	_, ok = r.Get("missing")
+	assert.False(t, ok)
+
+	var names []string
+	for _, e := range r.For(model.Asset{MIME: "image/png"}) {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{model.MetaKeyOCR, model.MetaKeyCaption}, names)
+	assert.Empty(t, r.For(model.Asset{MIME: "application/pdf"}))
+}
+
+func TestAssetMetadata(t *testing.T) {
+	e := NewAssetMetadata(mediameta.NewDefaultRegistry(), 1024)
+	assert.Equal(t, AssetMetadataName, e.Name())
+
+	assert.True(t, e.Accepts(model.Asset{MIME: "video/mp4", SizeB: 4096}))
+	assert.False(t, e.Accepts(model.Asset{MIME: "video/mp4", SizeB: 512}), "small assets are described on upload")
+	assert.False(t, e.Accepts(model.Asset{MIME: "application/pdf", SizeB: 4096}), "no extractor for the family")
+	assert.False(t, e.Accepts(model.Asset{MIME: "video/mp4", SizeB: 4096, Meta: map[string]any{}}), "already described")
+
+	_, err := e.Process(context.Background(), model.Asset{MIME: "audio/mpeg"}, []byte("ID3"))
+	assert.ErrorIs(t, err, mediameta.ErrUnsupported)
+}
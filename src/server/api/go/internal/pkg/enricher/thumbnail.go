@@ -0,0 +1,201 @@
+package enricher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register GIF decoding for image.Decode
+	"image/jpeg"
+	"image/png"
+	"slices"
+
+	"github.com/memodb-io/Acontext/internal/infra/blob"
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// thumbnailJPEGQuality is the JPEG quality thumbnails of JPEG images are encoded with.
+const thumbnailJPEGQuality = 80
+
+// DerivativeStore keeps assets generated from other assets, one per source and variant.
+type DerivativeStore interface {
+	// GetDerivative returns the asset stored as variant of source, or nil when there is none.
+	GetDerivative(ctx context.Context, source model.Asset, variant string) (*model.Asset, error)
+	// PutDerivative stores content as variant of source.
+	PutDerivative(ctx context.Context, source model.Asset, variant string, mime string, content []byte) (*model.Asset, error)
+}
+
+// Thumbnailer writes downscaled copies of JPEG, PNG and GIF images. Each configured size is the
+// edge of the box a thumbnail fits in; sizes an image already fits in are skipped. Thumbnails are
+// stored per source object, so an image uploaded again reuses the thumbnails of its first upload.
+type Thumbnailer struct {
+	store     DerivativeStore
+	sizes     []int
+	maxPixels int64
+}
+
+// NewThumbnailer returns a Thumbnailer for sizes; images over maxPixels pixels are rejected
+// before they are decoded, and maxPixels <= 0 disables the check.
+func NewThumbnailer(store DerivativeStore, sizes []int, maxPixels int64) *Thumbnailer {
+	sizes = slices.Clone(sizes)
+	slices.Sort(sizes)
+	return &Thumbnailer{store: store, sizes: slices.Compact(sizes), maxPixels: maxPixels}
+}
+
+func (t *Thumbnailer) Name() string { return model.MetaKeyThumbnails }
+
+func (t *Thumbnailer) Accepts(asset model.Asset) bool {
+	switch asset.MIME {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Process returns {"items": [model.Thumbnail...]}, smallest first.
+func (t *Thumbnailer) Process(ctx context.Context, asset model.Asset, content []byte) (map[string]any, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("decode image header: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, errors.New("image has no pixels")
+	}
+	if t.maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > t.maxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels exceeds the thumbnail limit of %d pixels", cfg.Width, cfg.Height, t.maxPixels)
+	}
+
+	mime := "image/png"
+	if asset.MIME == "image/jpeg" {
+		mime = "image/jpeg"
+	}
+
+	items := []model.Thumbnail{}
+	var src *image.NRGBA
+	for _, size := range t.sizes {
+		if size <= 0 || (cfg.Width <= size && cfg.Height <= size) {
+			continue
+		}
+		w, h := fitBox(cfg.Width, cfg.Height, size)
+		variant := fmt.Sprintf("thumb_%d", size)
+
+		stored, err := t.store.GetDerivative(ctx, asset, variant)
+		if err != nil {
+			return nil, fmt.Errorf("look up %s: %w", variant, err)
+		}
+		if stored == nil {
+			if src == nil {
+				if src, err = decodeNRGBA(content); err != nil {
+					return nil, err
+				}
+			}
+			data, err := encodeImage(downscale(src, w, h), mime)
+			if err != nil {
+				return nil, fmt.Errorf("encode %s: %w", variant, err)
+			}
+			if stored, err = t.store.PutDerivative(ctx, asset, variant, mime, data); err != nil {
+				return nil, fmt.Errorf("store %s: %w", variant, err)
+			}
+		}
+		items = append(items, model.Thumbnail{Size: size, Width: w, Height: h, Asset: *stored})
+	}
+	return map[string]any{"items": items}, nil
+}
+
+// fitBox scales w x h to fit in a size x size box, keeping the aspect ratio.
+func fitBox(w, h, size int) (int, int) {
+	if w >= h {
+		return size, max(1, (h*size+w/2)/w)
+	}
+	return max(1, (w*size+h/2)/h), size
+}
+
+func decodeNRGBA(content []byte) (*image.NRGBA, error) {
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n, nil
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst, nil
+}
+
+// downscale resizes src to w x h by averaging the source pixels each target pixel covers.
+func downscale(src *image.NRGBA, w, h int) *image.NRGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					// Weight colour by alpha so transparent pixels do not darken the average.
+					pa := uint64(p[3])
+					r += uint64(p[0]) * pa
+					g += uint64(p[1]) * pa
+					b += uint64(p[2]) * pa
+					a += pa
+					n++
+				}
+			}
+			o := dst.Pix[y*dst.Stride+x*4:]
+			if a > 0 {
+				o[0], o[1], o[2] = uint8(r/a), uint8(g/a), uint8(b/a)
+			}
+			o[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func encodeImage(img image.Image, mime string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if mime == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// S3DerivativeStore keeps derivatives in S3 under blob.DerivedKeyPrefix of their source object,
+// so they are deleted together with it.
+type S3DerivativeStore struct {
+	s3 *blob.S3Deps
+}
+
+func NewS3DerivativeStore(s3 *blob.S3Deps) *S3DerivativeStore {
+	return &S3DerivativeStore{s3: s3}
+}
+
+func (s *S3DerivativeStore) GetDerivative(ctx context.Context, source model.Asset, variant string) (*model.Asset, error) {
+	asset, err := s.s3.StatObject(ctx, blob.DerivedKeyPrefix(source.S3Key)+variant)
+	if errors.Is(err, blob.ErrObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	asset.DerivedFromID = source.SHA256
+	return asset, nil
+}
+
+func (s *S3DerivativeStore) PutDerivative(ctx context.Context, source model.Asset, variant string, mime string, content []byte) (*model.Asset, error) {
+	asset, err := s.s3.UploadFileDirect(ctx, blob.DerivedKeyPrefix(source.S3Key)+variant, content, mime, nil)
+	if err != nil {
+		return nil, err
+	}
+	asset.DerivedFromID = source.SHA256
+	return asset, nil
+}
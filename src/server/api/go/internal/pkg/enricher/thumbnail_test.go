@@ -0,0 +1,86 @@
+package enricher
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memDerivativeStore struct {
+	objects map[string][]byte
+	puts    int
+}
+
+func (s *memDerivativeStore) GetDerivative(ctx context.Context, source model.Asset, variant string) (*model.Asset, error) {
+	content, ok := s.objects[source.S3Key+"/"+variant]
+	if !ok {
+		return nil, nil
+	}
+	return &model.Asset{S3Key: source.S3Key + "/" + variant, MIME: "image/png", SizeB: int64(len(content)), DerivedFromID: source.SHA256}, nil
+}
+
+func (s *memDerivativeStore) PutDerivative(ctx context.Context, source model.Asset, variant string, mime string, content []byte) (*model.Asset, error) {
+	s.puts++
+	s.objects[source.S3Key+"/"+variant] = content
+	return &model.Asset{S3Key: source.S3Key + "/" + variant, MIME: mime, SizeB: int64(len(content)), DerivedFromID: source.SHA256}, nil
+}
+
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestThumbnailer_Process(t *testing.T) {
+	store := &memDerivativeStore{objects: map[string][]byte{}}
+	th := NewThumbnailer(store, []int{1000, 100, 100}, 0)
+	source := model.Asset{S3Key: "assets/p/photo.png", SHA256: "abc", MIME: "image/png"}
+	content := encodeTestPNG(t, 400, 200)
+
+	out, err := th.Process(context.Background(), source, content)
+	require.NoError(t, err)
+	items := out["items"].([]model.Thumbnail)
+	require.Len(t, items, 1, "sizes the image already fits in are skipped")
+	assert.Equal(t, 100, items[0].Size)
+	assert.Equal(t, 100, items[0].Width)
+	assert.Equal(t, 50, items[0].Height)
+	assert.Equal(t, "abc", items[0].Asset.DerivedFromID)
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(store.objects["assets/p/photo.png/thumb_100"]))
+	require.NoError(t, err)
+	assert.Equal(t, image.Config{ColorModel: cfg.ColorModel, Width: 100, Height: 50}, cfg)
+
+	_, err = th.Process(context.Background(), source, content)
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.puts, "stored thumbnails are reused")
+}
+
+func TestThumbnailer_Accepts(t *testing.T) {
+	th := NewThumbnailer(&memDerivativeStore{}, []int{128}, 0)
+	assert.True(t, th.Accepts(model.Asset{MIME: "image/jpeg"}))
+	assert.False(t, th.Accepts(model.Asset{MIME: "image/svg+xml"}))
+	assert.False(t, th.Accepts(model.Asset{MIME: "application/pdf"}))
+}
+
+func TestThumbnailer_MaxPixels(t *testing.T) {
+	store := &memDerivativeStore{objects: map[string][]byte{}}
+	th := NewThumbnailer(store, []int{16}, 100)
+
+	_, err := th.Process(context.Background(), model.Asset{S3Key: "k", MIME: "image/png"}, encodeTestPNG(t, 20, 20))
+	assert.ErrorContains(t, err, "exceeds the thumbnail limit")
+	assert.Zero(t, store.puts)
+}
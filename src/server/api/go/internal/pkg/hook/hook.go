@@ -0,0 +1,74 @@
+package hook
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// MessageHook runs custom logic, e.g. moderation, PII redaction or logging, around the
+// creation of a message.
+type MessageHook interface {
+	// BeforeCreate runs before the message is validated and stored, and may edit its parts
+	// and meta; it should not add or drop file parts. An error rejects the message.
+	BeforeCreate(msg *model.Message) error
+	// AfterCreate runs once the message is stored, with its ID and timestamps set.
+	AfterCreate(msg *model.Message)
+}
+
+// RejectedError is returned by Chain.BeforeCreate when a hook rejects a message.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("message rejected: %v", e.Err)
+}
+
+func (e *RejectedError) Unwrap() error { return e.Err }
+
+// Chain runs message hooks in registration order. It is safe for concurrent use, so hooks
+// may be registered after the server has started. A nil Chain runs no hooks.
+type Chain struct {
+	mu    sync.RWMutex
+	hooks []MessageHook
+}
+
+func NewChain() *Chain {
+	return &Chain{}
+}
+
+// Register appends h to the chain.
+func (c *Chain) Register(h MessageHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, h)
+}
+
+func (c *Chain) snapshot() []MessageHook {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hooks
+}
+
+// BeforeCreate runs the BeforeCreate hooks, stopping at the first one that fails. The
+// failure is returned as a *RejectedError wrapping the hook's error.
+func (c *Chain) BeforeCreate(msg *model.Message) error {
+	for _, h := range c.snapshot() {
+		if err := h.BeforeCreate(msg); err != nil {
+			return &RejectedError{Err: err}
+		}
+	}
+	return nil
+}
+
+// AfterCreate runs the AfterCreate hooks.
+func (c *Chain) AfterCreate(msg *model.Message) {
+	for _, h := range c.snapshot() {
+		h.AfterCreate(msg)
+	}
+}
@@ -0,0 +1,72 @@
+package hook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHook struct {
+	name   string
+	calls  *[]string
+	reject error
+}
+
+func (h recordingHook) BeforeCreate(msg *model.Message) error {
+	*h.calls = append(*h.calls, "before:"+h.name)
+	return h.reject
+}
+
+func (h recordingHook) AfterCreate(msg *model.Message) {
+	*h.calls = append(*h.calls, "after:"+h.name)
+}
+
+func TestChain_RunsInRegistrationOrder(t *testing.T) {
+	var calls []string
+	c := NewChain()
+	c.Register(recordingHook{name: "a", calls: &calls})
+	c.Register(recordingHook{name: "b", calls: &calls})
+
+	msg := &model.Message{}
+	require.NoError(t, c.BeforeCreate(msg))
+	c.AfterCreate(msg)
+	assert.Equal(t, []string{"before:a", "before:b", "after:a", "after:b"}, calls)
+}
+
+func TestChain_BeforeCreateRejects(t *testing.T) {
+	var calls []string
+	errBlocked := errors.New("blocked by moderation")
+	c := NewChain()
+	c.Register(recordingHook{name: "moderation", calls: &calls, reject: errBlocked})
+	c.Register(recordingHook{name: "logging", calls: &calls})
+
+	err := c.BeforeCreate(&model.Message{})
+	var rejected *RejectedError
+	require.True(t, errors.As(err, &rejected))
+	assert.ErrorIs(t, err, errBlocked)
+	assert.Equal(t, []string{"before:moderation"}, calls, "later hooks are skipped")
+}
+
+func TestChain_NilRunsNothing(t *testing.T) {
+	var c *Chain
+	assert.NoError(t, c.BeforeCreate(&model.Message{}))
+	c.AfterCreate(&model.Message{})
+}
+
+func TestPIIRedactor(t *testing.T) {
+	msg := &model.Message{Parts: []model.Part{
+		model.NewTextPart("Mail jane.doe+work@example.co.uk or call +1 (555) 123-4567."),
+		model.NewTextPart("UK office: 020 7946 0958, intl +44 20 7946 0958"),
+		model.NewTextPart("Shipped on 2024-01-15, order 4521, version 1.2.3"),
+		{Type: model.PartTypeToolResult, Text: "jane@example.com"},
+	}}
+	require.NoError(t, NewPIIRedactor().BeforeCreate(msg))
+
+	assert.Equal(t, "Mail [REDACTED_EMAIL] or call [REDACTED_PHONE].", msg.Parts[0].Text)
+	assert.Equal(t, "UK office: [REDACTED_PHONE], intl [REDACTED_PHONE]", msg.Parts[1].Text)
+	assert.Equal(t, "Shipped on 2024-01-15, order 4521, version 1.2.3", msg.Parts[2].Text)
+	assert.Equal(t, "jane@example.com", msg.Parts[3].Text, "only text parts are scrubbed")
+}
@@ -0,0 +1,66 @@
+package hook
+
+import (
+	"regexp"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// Placeholders PIIRedactor replaces matches with.
+const (
+	RedactedEmail = "[REDACTED_EMAIL]"
+	RedactedPhone = "[REDACTED_PHONE]"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// Phone numbers: an optional country code, then digits in groups separated by spaces,
+	// dots, dashes or parentheses, e.g. +1 (555) 123-4567 or 020 7946 0958. Matches are
+	// checked by looksLikePhone.
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?(?:\(\d{1,4}\)[\s.\-]?)?\d{2,4}(?:[\s.\-]\d{2,4}){1,4}\b|\+?\b\d{7,15}\b`)
+)
+
+// PIIRedactor is a MessageHook that replaces email addresses and phone numbers in text
+// parts with RedactedEmail and RedactedPhone.
+type PIIRedactor struct{}
+
+func NewPIIRedactor() PIIRedactor {
+	return PIIRedactor{}
+}
+
+func (PIIRedactor) BeforeCreate(msg *model.Message) error {
+	for i := range msg.Parts {
+		if msg.Parts[i].Type == model.PartTypeText && msg.Parts[i].Text != "" {
+			msg.Parts[i].Text = RedactPII(msg.Parts[i].Text)
+		}
+	}
+	return nil
+}
+
+func (PIIRedactor) AfterCreate(*model.Message) {}
+
+// RedactPII replaces the email addresses and phone numbers in s.
+func RedactPII(s string) string {
+	s = emailPattern.ReplaceAllString(s, RedactedEmail)
+	return phonePattern.ReplaceAllStringFunc(s, func(m string) string {
+		if !looksLikePhone(m) {
+			return m
+		}
+		return RedactedPhone
+	})
+}
+
+// looksLikePhone requires 10 to 15 digits, or 7 with a leading +, so dates such as
+// 2024-01-15 and short numbers are left alone.
+func looksLikePhone(m string) bool {
+	n := 0
+	for _, r := range m {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	if n > 15 {
+		return false
+	}
+	return n >= 10 || (n >= 7 && m[0] == '+')
+}
@@ -0,0 +1,363 @@
+// Package jsonpatch applies JSON Patch documents (RFC 6902) to JSON values.
+//
+// All six operations are supported: add, remove, replace, move, copy and test. Paths are JSON
+// Pointers (RFC 6901), and "-" addresses the position after the last element of an array. A
+// patch is applied atomically: when any operation fails, the document is left unchanged.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned (wrapped) for patches that are malformed or cannot be applied
+	// to the document, such as a path that does not exist.
+	ErrInvalidPatch = errors.New("invalid JSON patch")
+	// ErrTestFailed is returned (wrapped) when a test operation finds a different value.
+	ErrTestFailed = errors.New("JSON patch test failed")
+)
+
+// Operation is one operation of a patch document. Value is nil when the member is absent, which
+// add, replace and test reject; an explicit null is kept as the raw "null".
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Decode parses a patch document, which must be a JSON array of operations.
+func Decode(patch []byte) ([]Operation, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	if ops == nil {
+		return nil, fmt.Errorf("%w: patch must be an array of operations", ErrInvalidPatch)
+	}
+	return ops, nil
+}
+
+// Check inspects an operation before ApplyChecked applies it. doc is the document as the
+// operations before it left it, decoded with objects as map[string]any, arrays as []any and
+// numbers as json.Number; it must not be modified. An error stops the patch and is returned as is.
+type Check func(doc any, op Operation) error
+
+// Apply applies the patch document patch to the JSON document doc and returns the result.
+func Apply(doc, patch []byte) ([]byte, error) {
+	return ApplyChecked(doc, patch, nil)
+}
+
+// ApplyChecked is Apply with check called before each operation, so a caller can refuse an
+// operation by what it addresses when it runs rather than in the original document.
+func ApplyChecked(doc, patch []byte, check Check) ([]byte, error) {
+	ops, err := Decode(patch)
+	if err != nil {
+		return nil, err
+	}
+	root, err := decodeValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("decode document: %w", err)
+	}
+	for i, op := range ops {
+		if check != nil {
+			if err := check(root, op); err != nil {
+				return nil, err
+			}
+		}
+		if root, err = apply(root, op); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func decodeValue(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	// Numbers are kept as written so unrelated values survive the round trip exactly.
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func apply(root any, op Operation) (any, error) {
+	path, err := ParsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %s requires a value", ErrInvalidPatch, op.Op)
+		}
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: value: %v", ErrInvalidPatch, err)
+		}
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			if _, err := get(root, path); err != nil || len(path) == 0 {
+				return value, err
+			}
+			if root, _, err = remove(root, path); err != nil {
+				return nil, err
+			}
+			return add(root, path, value)
+		default:
+			current, err := get(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !equal(current, value) {
+				return nil, ErrTestFailed
+			}
+			return root, nil
+		}
+	case "remove":
+		root, _, err = remove(root, path)
+		return root, err
+	case "move", "copy":
+		from, err := ParsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move %q into itself", ErrInvalidPatch, op.From)
+			}
+			root, value, err := remove(root, from)
+			if err != nil {
+				return nil, err
+			}
+			return add(root, path, value)
+		}
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, deepCopy(value))
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer splits a JSON Pointer into its unescaped reference tokens; "" is the whole document.
+func ParsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex resolves token against an array of n elements. With forInsert, "-" and n address
+// the position after the last element.
+func arrayIndex(token string, n int, forInsert bool) (int, error) {
+	if token == "-" && forInsert {
+		return n, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.ContainsAny(token, "+-") {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	limit := n - 1
+	if forInsert {
+		limit = n
+	}
+	if i > limit {
+		return 0, fmt.Errorf("%w: array index %d out of range", ErrInvalidPatch, i)
+	}
+	return i, nil
+}
+
+func get(root any, path []string) (any, error) {
+	cur := root
+	for _, token := range path {
+		switch node := cur.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q not found", ErrInvalidPatch, token)
+			}
+			cur = v
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			cur = node[i]
+		default:
+			return nil, fmt.Errorf("%w: cannot descend into a scalar at %q", ErrInvalidPatch, token)
+		}
+	}
+	return cur, nil
+}
+
+// add sets the value at path, inserting it into an array, and returns the new root.
+func add(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(root, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("%w: cannot add to a scalar", ErrInvalidPatch)
+		}
+	})
+}
+
+// remove deletes the value at path and returns the new root and the removed value.
+func remove(root any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+	}
+	var removed any
+	root, err := update(root, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q not found", ErrInvalidPatch, token)
+			}
+			removed = v
+			delete(node, token)
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i:i], node[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: cannot remove from a scalar", ErrInvalidPatch)
+		}
+	})
+	return root, removed, err
+}
+
+// update walks to the parent of path, replaces it with what change returns for the last token,
+// and returns the new root. Arrays may be reallocated by change, so every ancestor is rewritten.
+func update(root any, path []string, change func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return change(root, path[0])
+	}
+	parent, err := get(root, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err := update(parent, path[1:], change)
+	if err != nil {
+		return nil, err
+	}
+	switch node := root.(type) {
+	case map[string]any:
+		node[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(node), false)
+		node[i] = child
+	}
+	return root, nil
+}
+
+func equal(a, b any) bool {
+	if an, ok := a.(json.Number); ok {
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+func deepCopy(v any) any {
+	switch node := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(node))
+		for k, w := range node {
+			out[k] = deepCopy(w)
+		}
+		return out
+	case []any:
+		out := make([]any, len(node))
+		for i, w := range node {
+			out[i] = deepCopy(w)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,84 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	doc := `[{"type":"text","text":"hello"},{"type":"image","meta":{"a/b":1,"m~n":2}}]`
+
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"replace member", `[{"op":"replace","path":"/0/text","value":"bye"}]`, `[{"type":"text","text":"bye"},{"type":"image","meta":{"a/b":1,"m~n":2}}]`},
+		{"remove element", `[{"op":"remove","path":"/0"}]`, `[{"type":"image","meta":{"a/b":1,"m~n":2}}]`},
+		{"append element", `[{"op":"add","path":"/-","value":{"type":"text","text":"more"}}]`, `[{"type":"text","text":"hello"},{"type":"image","meta":{"a/b":1,"m~n":2}},{"type":"text","text":"more"}]`},
+		{"insert element", `[{"op":"add","path":"/0","value":{"type":"text","text":"first"}}]`, `[{"type":"text","text":"first"},{"type":"text","text":"hello"},{"type":"image","meta":{"a/b":1,"m~n":2}}]`},
+		{"escaped tokens", `[{"op":"remove","path":"/1/meta/a~1b"},{"op":"replace","path":"/1/meta/m~0n","value":3}]`, `[{"type":"text","text":"hello"},{"type":"image","meta":{"m~n":3}}]`},
+		{"move element", `[{"op":"move","from":"/0","path":"/-"}]`, `[{"type":"image","meta":{"a/b":1,"m~n":2}},{"type":"text","text":"hello"}]`},
+		{"copy member", `[{"op":"copy","from":"/0/text","path":"/1/text"}]`, `[{"type":"text","text":"hello"},{"type":"image","meta":{"a/b":1,"m~n":2},"text":"hello"}]`},
+		{"passing test", `[{"op":"test","path":"/1/meta","value":{"m~n":2.0,"a/b":1}},{"op":"remove","path":"/1"}]`, `[{"type":"text","text":"hello"}]`},
+		{"replace whole document", `[{"op":"replace","path":"","value":[]}]`, `[]`},
+		{"empty patch", `[]`, doc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(doc), []byte(tt.patch))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestApply_Errors(t *testing.T) {
+	doc := `[{"type":"text","text":"hello"}]`
+
+	tests := []struct {
+		name  string
+		patch string
+		want  error
+	}{
+		{"not an array", `{"op":"remove","path":"/0"}`, ErrInvalidPatch},
+		{"null patch", `null`, ErrInvalidPatch},
+		{"unknown op", `[{"op":"merge","path":"/0"}]`, ErrInvalidPatch},
+		{"missing value", `[{"op":"add","path":"/0/text"}]`, ErrInvalidPatch},
+		{"relative path", `[{"op":"remove","path":"0"}]`, ErrInvalidPatch},
+		{"index out of range", `[{"op":"remove","path":"/1"}]`, ErrInvalidPatch},
+		{"insert past the end", `[{"op":"add","path":"/2","value":{}}]`, ErrInvalidPatch},
+		{"leading zero index", `[{"op":"replace","path":"/00","value":{}}]`, ErrInvalidPatch},
+		{"missing member", `[{"op":"replace","path":"/0/meta","value":{}}]`, ErrInvalidPatch},
+		{"missing parent", `[{"op":"add","path":"/0/meta/key","value":1}]`, ErrInvalidPatch},
+		{"move into itself", `[{"op":"move","from":"/0","path":"/0/text"}]`, ErrInvalidPatch},
+		{"remove whole document", `[{"op":"remove","path":""}]`, ErrInvalidPatch},
+		{"failing test", `[{"op":"test","path":"/0/text","value":"bye"}]`, ErrTestFailed},
+		{"failing test after edits", `[{"op":"remove","path":"/0"},{"op":"test","path":"","value":[{}]}]`, ErrTestFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Apply([]byte(doc), []byte(tt.patch))
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestApplyChecked(t *testing.T) {
+	doc := `["a","b"]`
+	errStop := errors.New("stop")
+
+	var seen []any
+	_, err := ApplyChecked([]byte(doc), []byte(`[{"op":"remove","path":"/0"},{"op":"remove","path":"/0"}]`), func(doc any, op Operation) error {
+		seen = append(seen, doc)
+		if len(seen) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, []any{[]any{"a", "b"}, []any{"b"}}, seen, "each check sees the document as the earlier operations left it")
+}
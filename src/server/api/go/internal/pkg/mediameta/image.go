@@ -0,0 +1,28 @@
+package mediameta
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF for image.DecodeConfig
+	_ "image/jpeg" // register JPEG for image.DecodeConfig
+	_ "image/png"  // register PNG for image.DecodeConfig
+)
+
+// extractImage reads the dimensions and format of an image from its header, without decoding
+// the pixels.
+func extractImage(_ context.Context, _ string, content []byte) (map[string]any, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		if err == image.ErrFormat {
+			return nil, ErrUnsupported
+		}
+		return nil, fmt.Errorf("read image header: %w", err)
+	}
+	return map[string]any{
+		KeyWidth:  cfg.Width,
+		KeyHeight: cfg.Height,
+		KeyCodec:  format,
+	}, nil
+}
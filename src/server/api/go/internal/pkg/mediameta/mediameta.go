@@ -0,0 +1,91 @@
+// Package mediameta reads the intrinsic metadata of media assets, such as the dimensions of an
+// image or the duration and codec of a recording, so clients can lay media out before they
+// download it. Extractors are registered per MIME family.
+package mediameta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Keys of the metadata extractors return. Extractors set the keys that apply to the media they
+// read and may add keys of their own.
+const (
+	KeyWidth      = "width"
+	KeyHeight     = "height"
+	KeyDurationMs = "duration_ms"
+	KeyCodec      = "codec"
+	// KeyAudioCodec is the codec of the audio track of a video; KeyCodec holds the video codec.
+	KeyAudioCodec = "audio_codec"
+	KeySampleRate = "sample_rate"
+	KeyChannels   = "channels"
+)
+
+// ErrUnsupported is returned for media no extractor can read.
+var ErrUnsupported = errors.New("unsupported media format")
+
+// Extractor reads the metadata of the media of one MIME family.
+type Extractor interface {
+	Extract(ctx context.Context, mimeType string, content []byte) (map[string]any, error)
+}
+
+// ExtractorFunc adapts a function to an Extractor.
+type ExtractorFunc func(ctx context.Context, mimeType string, content []byte) (map[string]any, error)
+
+func (f ExtractorFunc) Extract(ctx context.Context, mimeType string, content []byte) (map[string]any, error) {
+	return f(ctx, mimeType, content)
+}
+
+// Registry holds the extractor of each MIME family, the part of a MIME type before the slash
+// ("image", "audio", "video"). It is safe for concurrent use.
+type Registry struct {
+	mu         sync.RWMutex
+	extractors map[string]Extractor
+}
+
+func NewRegistry() *Registry {
+	return &Registry{extractors: map[string]Extractor{}}
+}
+
+// NewDefaultRegistry returns a registry with the built-in extractors: JPEG, PNG and GIF images,
+// WAV audio, and MP4, M4A and QuickTime audio and video.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register("image", ExtractorFunc(extractImage))
+	r.Register("audio", ExtractorFunc(extractRecording))
+	r.Register("video", ExtractorFunc(extractRecording))
+	return r
+}
+
+// Register sets the extractor of family, replacing the one registered before.
+func (r *Registry) Register(family string, x Extractor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.extractors[strings.ToLower(family)] = x
+}
+
+// Accepts reports whether an extractor is registered for the family of mimeType.
+func (r *Registry) Accepts(mimeType string) bool {
+	_, ok := r.get(mimeType)
+	return ok
+}
+
+// Extract reads the metadata of content with the extractor of mimeType's family.
+func (r *Registry) Extract(ctx context.Context, mimeType string, content []byte) (map[string]any, error) {
+	x, ok := r.get(mimeType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, mimeType)
+	}
+	return x.Extract(ctx, mimeType, content)
+}
+
+func (r *Registry) get(mimeType string) (Extractor, bool) {
+	family, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(mimeType)), "/")
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	x, ok := r.extractors[family]
+	return x, ok
+}
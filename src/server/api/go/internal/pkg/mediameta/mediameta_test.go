@@ -0,0 +1,145 @@
+package mediameta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func box(typ string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u32(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// mp4Track builds a trak box with a handler and a single sample entry; width and height only
+// matter for video tracks.
+func mp4Track(handler, codec string, width, height uint32) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], width<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], height<<16)
+	hdlr := append(u32(0, 0), handler...)
+	hdlr = append(hdlr, make([]byte, 12)...)
+	stsd := append(u32(0, 1), box(codec, make([]byte, 8))...)
+	return box("trak",
+		box("tkhd", tkhd),
+		box("mdia", box("hdlr", hdlr), box("minf", box("stbl", box("stsd", stsd)))),
+	)
+}
+
+func mp4(tracks ...[]byte) []byte {
+	// mvhd version 0: flags, creation and modification time, timescale 1000, duration 90.5s
+	mvhd := append(u32(0, 0, 0, 1000, 90500), make([]byte, 80)...)
+	return append(box("ftyp", []byte("isom"), u32(0x200)), box("moov", append([][]byte{box("mvhd", mvhd)}, tracks...)...)...)
+}
+
+func wav(sampleRate uint32, channels uint16, seconds uint32) []byte {
+	le := binary.LittleEndian
+	fmtChunk := le.AppendUint16(nil, 1)
+	fmtChunk = le.AppendUint16(fmtChunk, channels)
+	fmtChunk = le.AppendUint32(fmtChunk, sampleRate)
+	byteRate := sampleRate * uint32(channels) * 2
+	fmtChunk = le.AppendUint32(fmtChunk, byteRate)
+	fmtChunk = le.AppendUint16(fmtChunk, channels*2)
+	fmtChunk = le.AppendUint16(fmtChunk, 16)
+
+	b := []byte("RIFF\x00\x00\x00\x00WAVE")
+	b = append(le.AppendUint32(append(b, "fmt "...), uint32(len(fmtChunk))), fmtChunk...)
+	b = le.AppendUint32(append(b, "data"...), byteRate*seconds)
+	return append(b, make([]byte, byteRate*seconds)...)
+}
+
+func TestRegistry_Extract(t *testing.T) {
+	ctx := context.Background()
+	reg := NewDefaultRegistry()
+
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 640, 480))))
+
+	tests := []struct {
+		name    string
+		mime    string
+		content []byte
+		want    map[string]any
+	}{
+		{
+			name:    "png",
+			mime:    "image/png",
+			content: img.Bytes(),
+			want:    map[string]any{KeyWidth: 640, KeyHeight: 480, KeyCodec: "png"},
+		},
+		{
+			name:    "wav",
+			mime:    "audio/wav",
+			content: wav(8000, 2, 3),
+			want:    map[string]any{KeyCodec: "pcm", KeySampleRate: 8000, KeyChannels: 2, KeyDurationMs: int64(3000)},
+		},
+		{
+			name:    "mp4 video",
+			mime:    "video/mp4",
+			content: mp4(mp4Track("soun", "mp4a", 0, 0), mp4Track("vide", "avc1", 1920, 1080)),
+			want: map[string]any{
+				KeyWidth: 1920, KeyHeight: 1080, KeyDurationMs: int64(90500), KeyCodec: "avc1", KeyAudioCodec: "mp4a",
+			},
+		},
+		{
+			name:    "m4a audio",
+			mime:    "audio/mp4",
+			content: mp4(mp4Track("soun", "mp4a", 0, 0)),
+			want:    map[string]any{KeyDurationMs: int64(90500), KeyCodec: "mp4a"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.True(t, reg.Accepts(tc.mime))
+			got, err := reg.Extract(ctx, tc.mime, tc.content)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRegistry_ExtractFails(t *testing.T) {
+	ctx := context.Background()
+	reg := NewDefaultRegistry()
+
+	assert.False(t, reg.Accepts("application/pdf"))
+	_, err := reg.Extract(ctx, "application/pdf", []byte("%PDF-1.7"))
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = reg.Extract(ctx, "audio/mpeg", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"))
+	assert.ErrorIs(t, err, ErrUnsupported, "a container without an extractor")
+
+	full := mp4(mp4Track("vide", "avc1", 1920, 1080))
+	_, err = reg.Extract(ctx, "video/mp4", full[:len(full)-40])
+	assert.Error(t, err, "a truncated movie box")
+
+	_, err = reg.Extract(ctx, "image/png", []byte("\x89PNG\r\n\x1a\n"))
+	assert.Error(t, err)
+}
+
+func TestRegistry_Register(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register("Model", ExtractorFunc(func(_ context.Context, mimeType string, _ []byte) (map[string]any, error) {
+		return map[string]any{"format": mimeType}, nil
+	}))
+
+	got, err := reg.Extract(context.Background(), "model/gltf+json", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"format": "model/gltf+json"}, got)
+	assert.False(t, reg.Accepts("image/png"))
+}
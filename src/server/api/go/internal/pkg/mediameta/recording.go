@@ -0,0 +1,238 @@
+package mediameta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errTruncated = errors.New("truncated media")
+
+// extractRecording reads the duration and codecs of audio and video by their container, sniffed
+// from the content: WAV, or ISO base media (MP4, M4A, QuickTime).
+func extractRecording(_ context.Context, _ string, content []byte) (map[string]any, error) {
+	switch {
+	case len(content) >= 12 && string(content[0:4]) == "RIFF" && string(content[8:12]) == "WAVE":
+		return extractWAV(content[12:])
+	case len(content) >= 8 && (string(content[4:8]) == "ftyp" || string(content[4:8]) == "moov"):
+		return extractISOBMFF(content)
+	}
+	return nil, ErrUnsupported
+}
+
+// wavCodecs names the WAVE format tags seen in practice.
+var wavCodecs = map[uint16]string{
+	0x0001: "pcm",
+	0x0003: "pcm_float",
+	0x0006: "alaw",
+	0x0007: "mulaw",
+	0xfffe: "pcm", // WAVE_FORMAT_EXTENSIBLE; the subformat is almost always PCM
+}
+
+// extractWAV reads the fmt and data chunks of a RIFF WAVE file, given the chunks after the header.
+func extractWAV(chunks []byte) (map[string]any, error) {
+	var format, channels uint16
+	var sampleRate, byteRate uint32
+	var seenFmt bool
+	for len(chunks) >= 8 {
+		id := string(chunks[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunks[4:8]))
+		body := chunks[8:]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, errTruncated
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			sampleRate = binary.LittleEndian.Uint32(body[4:8])
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+			seenFmt = true
+		case "data":
+			if !seenFmt {
+				return nil, errors.New("wav data chunk precedes its fmt chunk")
+			}
+			codec, ok := wavCodecs[format]
+			if !ok {
+				codec = fmt.Sprintf("wav_0x%04x", format)
+			}
+			meta := map[string]any{
+				KeyCodec:      codec,
+				KeySampleRate: int(sampleRate),
+				KeyChannels:   int(channels),
+			}
+			if byteRate > 0 {
+				meta[KeyDurationMs] = size * 1000 / int64(byteRate)
+			}
+			return meta, nil
+		}
+		// Chunks are padded to an even size.
+		next := 8 + size + size%2
+		if next > int64(len(chunks)) {
+			break
+		}
+		chunks = chunks[next:]
+	}
+	return nil, errTruncated
+}
+
+type bmffBox struct {
+	typ  string
+	body []byte
+}
+
+// readBoxes splits b into the ISO base media boxes it holds.
+func readBoxes(b []byte) ([]bmffBox, error) {
+	var boxes []bmffBox
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errTruncated
+		}
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0: // the box extends to the end of the file
+			size = uint64(len(b))
+		case 1: // a 64-bit size follows the type
+			if len(b) < 16 {
+				return nil, errTruncated
+			}
+			size, header = binary.BigEndian.Uint64(b[8:16]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return nil, errTruncated
+		}
+		boxes = append(boxes, bmffBox{typ: typ, body: b[header:size]})
+		b = b[size:]
+	}
+	return boxes, nil
+}
+
+// findBox returns the body of the first box at path, each element a box type nested in the
+// previous one.
+func findBox(b []byte, path ...string) ([]byte, bool) {
+	for _, typ := range path {
+		boxes, err := readBoxes(b)
+		if err != nil {
+			return nil, false
+		}
+		found := false
+		for _, box := range boxes {
+			if box.typ == typ {
+				b, found = box.body, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// extractISOBMFF reads the duration of the movie header and, per track, the codec of its first
+// sample entry and the dimensions of video tracks.
+func extractISOBMFF(content []byte) (map[string]any, error) {
+	moov, ok := findBox(content, "moov")
+	if !ok {
+		return nil, errors.New("no movie box; the file may be fragmented or truncated")
+	}
+	meta := map[string]any{}
+	if mvhd, ok := findBox(moov, "mvhd"); ok {
+		if ms, ok := movieDurationMs(mvhd); ok {
+			meta[KeyDurationMs] = ms
+		}
+	}
+
+	boxes, err := readBoxes(moov)
+	if err != nil {
+		return nil, err
+	}
+	var videoCodec, audioCodec string
+	for _, box := range boxes {
+		if box.typ != "trak" {
+			continue
+		}
+		hdlr, ok := findBox(box.body, "mdia", "hdlr")
+		if !ok || len(hdlr) < 12 {
+			continue
+		}
+		stsd, _ := findBox(box.body, "mdia", "minf", "stbl", "stsd")
+		codec := sampleEntryType(stsd)
+		switch string(hdlr[8:12]) {
+		case "vide":
+			if videoCodec != "" {
+				continue
+			}
+			videoCodec = codec
+			if tkhd, ok := findBox(box.body, "tkhd"); ok {
+				if w, h, ok := trackDimensions(tkhd); ok {
+					meta[KeyWidth], meta[KeyHeight] = w, h
+				}
+			}
+		case "soun":
+			if audioCodec == "" {
+				audioCodec = codec
+			}
+		}
+	}
+	switch {
+	case videoCodec != "":
+		meta[KeyCodec] = videoCodec
+		if audioCodec != "" {
+			meta[KeyAudioCodec] = audioCodec
+		}
+	case audioCodec != "":
+		meta[KeyCodec] = audioCodec
+	}
+	if len(meta) == 0 {
+		return nil, errors.New("no duration or tracks in the movie box")
+	}
+	return meta, nil
+}
+
+// movieDurationMs reads the duration of an mvhd box in milliseconds.
+func movieDurationMs(mvhd []byte) (int64, bool) {
+	var timescale uint32
+	var duration uint64
+	switch {
+	case len(mvhd) >= 20 && mvhd[0] == 0:
+		timescale = binary.BigEndian.Uint32(mvhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	case len(mvhd) >= 32 && mvhd[0] == 1:
+		timescale = binary.BigEndian.Uint32(mvhd[20:24])
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	default:
+		return 0, false
+	}
+	if timescale == 0 || duration == 0 || duration == 1<<64-1 || duration == 1<<32-1 {
+		// Zero and all ones mean the duration is unknown.
+		return 0, false
+	}
+	return int64(duration * 1000 / uint64(timescale)), true
+}
+
+// trackDimensions reads the presentation size of a tkhd box, stored as 16.16 fixed point.
+func trackDimensions(tkhd []byte) (int, int, bool) {
+	offset := 76
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		offset = 88
+	}
+	if len(tkhd) < offset+8 {
+		return 0, 0, false
+	}
+	w := int(binary.BigEndian.Uint32(tkhd[offset:offset+4]) >> 16)
+	h := int(binary.BigEndian.Uint32(tkhd[offset+4:offset+8]) >> 16)
+	return w, h, w > 0 && h > 0
+}
+
+// sampleEntryType returns the format of the first entry of an stsd box, e.g. "avc1" or "mp4a".
+func sampleEntryType(stsd []byte) string {
+	if len(stsd) < 16 {
+		return ""
+	}
+	return string(bytes.TrimRight(stsd[12:16], " \x00"))
+}
@@ -0,0 +1,25 @@
+package metrics
+
+// Default is the registry served on /metrics of the metrics listener (App.MetricsPort).
+var Default = NewRegistry()
+
+// Labels are limited to small fixed sets; per-project or per-session values would give every
+// session its own series.
+var (
+	// MessagesCreated counts stored messages by role.
+	MessagesCreated = Default.NewCounterVec("acontext_messages_created_total", "Messages created, by role.", "role")
+	// AssetBytesUploaded counts the bytes of assets written to object storage, by source.
+	AssetBytesUploaded = Default.NewCounterVec("acontext_asset_uploaded_bytes_total", "Bytes of assets uploaded to object storage, by source.", "source")
+	// MessageCreateSeconds observes how long storing a message takes.
+	MessageCreateSeconds = Default.NewHistogram("acontext_message_create_duration_seconds", "Latency of message creation requests.", DefBuckets)
+	// SSESubscribers is the number of open message stream subscriptions.
+	SSESubscribers = Default.NewGauge("acontext_sse_subscribers", "Open server-sent event subscriptions to message streams.")
+	// PendingEnrichmentJobs reads the number of enrichment jobs waiting to run.
+	PendingEnrichmentJobs = Default.NewGaugeFunc("acontext_enrichment_jobs_pending", "Part enrichment jobs waiting to run.")
+)
+
+// Asset upload sources for AssetBytesUploaded.
+const (
+	AssetSourceMessage = "message"
+	AssetSourceUpload  = "upload"
+)
@@ -0,0 +1,316 @@
+// Package metrics keeps counters, gauges and histograms in memory and serves them in the
+// Prometheus text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// labelSep joins label values into series keys; it cannot occur in valid UTF-8.
+const labelSep = "\xff"
+
+type collector interface {
+	metricName() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the collectors created through it and renders them in name order.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds c, panicking on a duplicate name the way Prometheus' MustRegister does: metrics
+// are declared once at start-up, so a clash is a programming error.
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[c.metricName()]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", c.metricName()))
+	}
+	r.collectors[c.metricName()] = c
+}
+
+// WriteText writes every metric in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) metricName() string { return d.name }
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "), d.name, d.kind)
+}
+
+// key joins values into a series key after checking they match the declared labels.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, labelSep)
+}
+
+// labelPairs renders the labels of the series with key, plus extra pairs, as {a="x",b="y"}.
+func (d desc) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, labelSep) {
+			pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 updated without locks.
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (f *atomicFloat) Add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64)  { f.bits.Store(math.Float64bits(v)) }
+func (f *atomicFloat) Load() float64  { return math.Float64frombits(f.bits.Load()) }
+func (f *atomicFloat) String() string { return formatFloat(f.Load()) }
+
+// CounterVec is a family of monotonically increasing counters partitioned by labels. A counter
+// declared without labels has a single series.
+type CounterVec struct {
+	desc
+	mu     sync.RWMutex
+	series map[string]*atomicFloat
+}
+
+// NewCounterVec registers a counter family. Label values should come from a small fixed set;
+// every distinct combination is kept for the life of the process.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, kind: "counter", labels: labels}, series: make(map[string]*atomicFloat)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) get(values []string) *atomicFloat {
+	key := c.key(values)
+	c.mu.RLock()
+	s, ok := c.series[key]
+	c.mu.RUnlock()
+	if ok {
+		return s
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok = c.series[key]; !ok {
+		s = &atomicFloat{}
+		c.series[key] = s
+	}
+	return s
+}
+
+// Add increases the series of labelValues by v; negative values are ignored.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.get(labelValues).Add(v)
+}
+
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Value returns the current value of the series of labelValues.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if s, ok := c.series[key]; ok {
+		return s.Load()
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), c.series[key])
+	}
+}
+
+// Gauge is a single value that can go up and down.
+type Gauge struct {
+	desc
+	value atomicFloat
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, kind: "gauge"}}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64)  { g.value.Set(v) }
+func (g *Gauge) Add(v float64)  { g.value.Add(v) }
+func (g *Gauge) Inc()           { g.value.Add(1) }
+func (g *Gauge) Dec()           { g.value.Add(-1) }
+func (g *Gauge) Value() float64 { return g.value.Load() }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, &g.value)
+}
+
+// GaugeFunc is a gauge whose value is read from a function at scrape time. It reports nothing
+// until a function is bound, and nothing for a scrape where the function fails.
+type GaugeFunc struct {
+	desc
+	fn atomic.Pointer[func() (float64, error)]
+}
+
+func (r *Registry) NewGaugeFunc(name, help string) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, kind: "gauge"}}
+	r.register(g)
+	return g
+}
+
+// Bind sets the function the gauge reads; it replaces any function bound before.
+func (g *GaugeFunc) Bind(fn func() (float64, error)) { g.fn.Store(&fn) }
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	fn := g.fn.Load()
+	if fn == nil {
+		return
+	}
+	v, err := (*fn)()
+	if err != nil {
+		return
+	}
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(v))
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	desc
+	upper  []float64
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds; a +Inf bucket is implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	upper := slices.Clone(buckets)
+	slices.Sort(upper)
+	h := &Histogram{desc: desc{name: name, help: help, kind: "histogram"}, upper: upper, counts: make([]uint64, len(upper))}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i, _ := slices.BinarySearch(h.upper, v); i < len(h.upper) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// Count returns how many values have been observed.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs("", "le", formatFloat(upper)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs("", "le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("test_messages_total", "Messages.", "role")
+	gauge := r.NewGauge("test_subscribers", "Subscribers.")
+	hist := r.NewHistogram("test_latency_seconds", "Latency.", []float64{1, 0.1})
+	pending := r.NewGaugeFunc("test_pending", "Pending.")
+
+	counter.Inc("user")
+	counter.Add(2, "assistant")
+	counter.Add(-5, "assistant")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	hist.Observe(0.05)
+	hist.Observe(0.1)
+	hist.Observe(3)
+
+	var out strings.Builder
+	require.NoError(t, r.WriteText(&out))
+	assert.Equal(t, `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 2
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 3.15
+test_latency_seconds_count 3
+# HELP test_messages_total Messages.
+# TYPE test_messages_total counter
+test_messages_total{role="assistant"} 2
+test_messages_total{role="user"} 1
+# HELP test_subscribers Subscribers.
+# TYPE test_subscribers gauge
+test_subscribers 1
+`, out.String(), "an unbound gauge func is left out")
+
+	pending.Bind(func() (float64, error) { return 7, nil })
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	assert.Contains(t, rec.Body.String(), "test_pending 7\n")
+
+	pending.Bind(func() (float64, error) { return 0, errors.New("db down") })
+	out.Reset()
+	require.NoError(t, r.WriteText(&out))
+	assert.NotContains(t, out.String(), "test_pending")
+}
+
+func TestCounterVec_LabelChecks(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test.", "role")
+	c.Inc(`a"b`)
+	assert.Equal(t, float64(1), c.Value(`a"b`))
+
+	var out strings.Builder
+	require.NoError(t, r.WriteText(&out))
+	assert.Contains(t, out.String(), `test_total{role="a\"b"} 1`)
+
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { r.NewCounterVec("test_total", "Again.") })
+}
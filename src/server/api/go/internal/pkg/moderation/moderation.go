@@ -0,0 +1,53 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// Result is a moderation verdict on a message.
+type Result struct {
+	Flagged bool
+	Reason  string
+}
+
+// Provider checks new messages, e.g. by calling a hosted moderation API. Flagged messages are
+// still stored; the verdict only marks them.
+type Provider interface {
+	Moderate(ctx context.Context, role string, parts []model.Part) (Result, error)
+}
+
+// KeywordProvider flags messages whose text parts contain any of its terms, compared
+// case-insensitively.
+type KeywordProvider struct {
+	terms []string
+}
+
+// NewKeywordProvider returns a KeywordProvider for terms; blank terms are ignored.
+func NewKeywordProvider(terms []string) *KeywordProvider {
+	p := &KeywordProvider{}
+	for _, t := range terms {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			p.terms = append(p.terms, t)
+		}
+	}
+	return p
+}
+
+func (p *KeywordProvider) Moderate(ctx context.Context, role string, parts []model.Part) (Result, error) {
+	for _, part := range parts {
+		if part.Type != model.PartTypeText {
+			continue
+		}
+		text := strings.ToLower(part.Text)
+		for _, t := range p.terms {
+			if strings.Contains(text, t) {
+				return Result{Flagged: true, Reason: fmt.Sprintf("contains blocked term %q", t)}, nil
+			}
+		}
+	}
+	return Result{}, nil
+}
@@ -0,0 +1,27 @@
+package moderation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywordProvider(t *testing.T) {
+	p := NewKeywordProvider([]string{" Secret Plan ", ""})
+
+	res, err := p.Moderate(context.Background(), model.RoleUser, []model.Part{
+		model.NewTextPart("nothing to see"),
+		model.NewTextPart("here is the SECRET plan"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Flagged: true, Reason: `contains blocked term "secret plan"`}, res)
+
+	res, err = p.Moderate(context.Background(), model.RoleUser, []model.Part{
+		{Type: model.PartTypeToolResult, Text: "secret plan"},
+	})
+	require.NoError(t, err)
+	assert.False(t, res.Flagged, "only text parts are checked")
+}
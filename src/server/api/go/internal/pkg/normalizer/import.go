@@ -0,0 +1,162 @@
+package normalizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/memodb-io/Acontext/internal/modules/service"
+)
+
+// anthropicImportBlockTypes are the content block types ParseConversation maps to parts
+var anthropicImportBlockTypes = map[string]bool{
+	"text":              true,
+	"image":             true,
+	"tool_use":          true,
+	"tool_result":       true,
+	"document":          true,
+	"thinking":          true,
+	"redacted_thinking": true,
+}
+
+// ValidateImportFormat checks if format can be used with ParseConversation
+func ValidateImportFormat(format string) (model.MessageFormat, error) {
+	switch mf := model.MessageFormat(format); mf {
+	case model.FormatAnthropic:
+		return mf, nil
+	default:
+		return "", fmt.Errorf("invalid import format: %s, supported formats: anthropic", format)
+	}
+}
+
+// ParseConversation converts a whole provider conversation into messages ready for ImportSession.
+func ParseConversation(format model.MessageFormat, body []byte) ([]service.ImportMessageIn, error) {
+	switch format {
+	case model.FormatAnthropic:
+		return parseAnthropicConversation(body)
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+}
+
+// parseAnthropicConversation reads a Messages API request body ({"system": ..., "messages": [...]}).
+// A system prompt becomes a leading user message tagged with original_role "system".
+func parseAnthropicConversation(body []byte) ([]service.ImportMessageIn, error) {
+	var req struct {
+		System   json.RawMessage   `json:"system"`
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic conversation: %w", err)
+	}
+	if len(req.Messages) == 0 {
+		return nil, errors.New("messages: at least one message is required")
+	}
+
+	out := make([]service.ImportMessageIn, 0, len(req.Messages)+1)
+
+	system, err := parseAnthropicSystem(req.System)
+	if err != nil {
+		return nil, err
+	}
+	if len(system) > 0 {
+		out = append(out, service.ImportMessageIn{
+			Role:  model.RoleUser,
+			Parts: system,
+			Meta: map[string]interface{}{
+				model.MsgMetaSourceFormat: "anthropic",
+				model.MsgMetaOriginalRole: "system",
+			},
+		})
+	}
+
+	normalizer := &AnthropicNormalizer{}
+	for i, raw := range req.Messages {
+		if err := checkAnthropicBlocks(i, raw); err != nil {
+			return nil, err
+		}
+		role, parts, meta, err := normalizer.Normalize(raw)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("messages[%d]: content is empty", i)
+		}
+		for j := range parts {
+			if err := parts[j].Validate(); err != nil {
+				return nil, fmt.Errorf("messages[%d].content[%d]: %w", i, j, err)
+			}
+		}
+		out = append(out, service.ImportMessageIn{Role: role, Parts: parts, Meta: meta})
+	}
+	return out, nil
+}
+
+// checkAnthropicBlocks rejects roles and content block types the normalizer would drop
+func checkAnthropicBlocks(i int, raw json.RawMessage) error {
+	var msg struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return fmt.Errorf("messages[%d]: failed to unmarshal Anthropic message: %w", i, err)
+	}
+	if msg.Role != model.RoleUser && msg.Role != model.RoleAssistant {
+		return fmt.Errorf("messages[%d].role: invalid role %q (only 'user' and 'assistant' are supported)", i, msg.Role)
+	}
+
+	content := bytes.TrimSpace(msg.Content)
+	if len(content) == 0 || content[0] != '[' {
+		// String content is a single text block.
+		return nil
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return fmt.Errorf("messages[%d].content: %w", i, err)
+	}
+	for j, b := range blocks {
+		if !anthropicImportBlockTypes[b.Type] {
+			return fmt.Errorf("messages[%d].content[%d]: unsupported content block type %q", i, j, b.Type)
+		}
+	}
+	return nil
+}
+
+// parseAnthropicSystem accepts a system prompt given as a string or as a list of text blocks
+func parseAnthropicSystem(raw json.RawMessage) ([]service.PartIn, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if text == "" {
+			return nil, nil
+		}
+		return []service.PartIn{{Type: model.PartTypeText, Text: text}}, nil
+	}
+
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, errors.New("system: must be a string or a list of text blocks")
+	}
+	parts := make([]service.PartIn, 0, len(blocks))
+	for j, b := range blocks {
+		if b.Type != "text" {
+			return nil, fmt.Errorf("system[%d]: unsupported content block type %q", j, b.Type)
+		}
+		if b.Text == "" {
+			continue
+		}
+		parts = append(parts, service.PartIn{Type: model.PartTypeText, Text: b.Text})
+	}
+	return parts, nil
+}
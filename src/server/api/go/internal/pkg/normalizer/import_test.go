@@ -0,0 +1,105 @@
+package normalizer
+
+import (
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConversation_Anthropic(t *testing.T) {
+	t.Run("maps blocks and system prompt", func(t *testing.T) {
+		body := []byte(`{
+			"system": [{"type": "text", "text": "Be brief."}],
+			"messages": [
+				{"role": "user", "content": [
+					{"type": "text", "text": "What is in this picture?"},
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGVsbG8="}},
+					{"type": "image", "source": {"type": "url", "url": "https://example.com/cat.png"}}
+				]},
+				{"role": "assistant", "content": [
+					{"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {"q": "cat"}}
+				]},
+				{"role": "user", "content": [
+					{"type": "tool_result", "tool_use_id": "toolu_1", "content": "a cat"}
+				]},
+				{"role": "assistant", "content": "It is a cat."}
+			]
+		}`)
+
+		msgs, err := ParseConversation(model.FormatAnthropic, body)
+		require.NoError(t, err)
+		require.Len(t, msgs, 5)
+
+		assert.Equal(t, model.RoleUser, msgs[0].Role)
+		assert.Equal(t, "system", msgs[0].Meta[model.MsgMetaOriginalRole])
+		assert.Equal(t, "Be brief.", msgs[0].Parts[0].Text)
+
+		require.Len(t, msgs[1].Parts, 3)
+		assert.Equal(t, model.PartTypeImage, msgs[1].Parts[1].Type)
+		assert.Equal(t, "base64", msgs[1].Parts[1].Meta[model.MetaKeySourceType])
+		assert.Equal(t, "https://example.com/cat.png", msgs[1].Parts[2].Meta[model.MetaKeyURL])
+
+		assert.Equal(t, model.PartTypeToolCall, msgs[2].Parts[0].Type)
+		assert.Equal(t, "lookup", msgs[2].Parts[0].Meta[model.MetaKeyName])
+		assert.Equal(t, model.PartTypeToolResult, msgs[3].Parts[0].Type)
+		assert.Equal(t, "toolu_1", msgs[3].Parts[0].Meta[model.MetaKeyToolCallID])
+
+		assert.Equal(t, model.RoleAssistant, msgs[4].Role)
+		assert.Equal(t, "It is a cat.", msgs[4].Parts[0].Text)
+	})
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "unknown block type",
+			body:    `{"messages":[{"role":"user","content":[{"type":"text","text":"hi"},{"type":"search_result"}]}]}`,
+			wantErr: `messages[0].content[1]: unsupported content block type "search_result"`,
+		},
+		{
+			name:    "invalid role",
+			body:    `{"messages":[{"role":"user","content":"hi"},{"role":"system","content":"x"}]}`,
+			wantErr: `messages[1].role: invalid role "system"`,
+		},
+		{
+			name:    "no messages",
+			body:    `{"messages":[]}`,
+			wantErr: "at least one message",
+		},
+		{
+			name:    "non-text system block",
+			body:    `{"system":[{"type":"image"}],"messages":[{"role":"user","content":"hi"}]}`,
+			wantErr: `system[0]: unsupported content block type "image"`,
+		},
+		{
+			name:    "empty text",
+			body:    `{"messages":[{"role":"user","content":[{"type":"text","text":""}]}]}`,
+			wantErr: "messages[0].content[0]",
+		},
+		{
+			name:    "malformed body",
+			body:    `{"messages":`,
+			wantErr: "failed to unmarshal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConversation(model.FormatAnthropic, []byte(tt.body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateImportFormat(t *testing.T) {
+	f, err := ValidateImportFormat("anthropic")
+	require.NoError(t, err)
+	assert.Equal(t, model.FormatAnthropic, f)
+
+	_, err = ValidateImportFormat("openai")
+	assert.Error(t, err)
+}
@@ -11,6 +11,10 @@ import (
 	"github.com/google/uuid"
 )
 
+// ErrInvalidCursor is returned (wrapped) by DecodeCursor for any cursor that
+// cannot be decoded, so callers can map it to a client error.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 func EncodeCursor(t time.Time, id uuid.UUID) string {
 	raw := fmt.Sprintf("%d|%s", t.UTC().UnixNano(), id.String())
 	return base64.RawURLEncoding.EncodeToString([]byte(raw))
@@ -18,23 +22,55 @@ func EncodeCursor(t time.Time, id uuid.UUID) string {
 
 func DecodeCursor(s string) (time.Time, uuid.UUID, error) {
 	if s == "" {
-		return time.Time{}, uuid.Nil, errors.New("empty cursor")
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: empty cursor", ErrInvalidCursor)
 	}
 	b, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		return time.Time{}, uuid.Nil, err
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
 	}
 	parts := strings.Split(string(b), "|")
 	if len(parts) != 2 {
-		return time.Time{}, uuid.Nil, errors.New("bad cursor")
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: bad cursor", ErrInvalidCursor)
 	}
 	ns, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		return time.Time{}, uuid.Nil, err
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
 	}
 	id, err := uuid.Parse(parts[1])
 	if err != nil {
-		return time.Time{}, uuid.Nil, err
+		return time.Time{}, uuid.Nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
 	}
 	return time.Unix(0, ns).UTC(), id, nil
 }
+
+// seqCursorTag leads seq cursors so a (created_at, id) cursor is never read as one.
+const seqCursorTag = "seq"
+
+// EncodeSeqCursor encodes a (seq, id) position for lists ordered by a sequence number.
+func EncodeSeqCursor(seq int64, id uuid.UUID) string {
+	raw := fmt.Sprintf("%s|%d|%s", seqCursorTag, seq, id.String())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeSeqCursor(s string) (int64, uuid.UUID, error) {
+	if s == "" {
+		return 0, uuid.Nil, fmt.Errorf("%w: empty cursor", ErrInvalidCursor)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, uuid.Nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	parts := strings.Split(string(b), "|")
+	if len(parts) != 3 || parts[0] != seqCursorTag {
+		return 0, uuid.Nil, fmt.Errorf("%w: bad cursor", ErrInvalidCursor)
+	}
+	seq, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, uuid.Nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		return 0, uuid.Nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return seq, id, nil
+}
@@ -1,6 +1,7 @@
 package paging
 
 import (
+	"encoding/base64"
 	"testing"
 	"time"
 
@@ -107,6 +108,7 @@ func TestDecodeCursor(t *testing.T) {
 				if tt.errMsg != "" {
 					assert.Contains(t, err.Error(), tt.errMsg)
 				}
+				assert.ErrorIs(t, err, ErrInvalidCursor)
 				assert.Equal(t, time.Time{}, decodedTime)
 				assert.Equal(t, uuid.Nil, decodedID)
 			} else {
@@ -236,3 +238,26 @@ func TestCursor_URLSafe(t *testing.T) {
 		assert.NotContains(t, cursor, "=") // RawURLEncoding does not include padding characters
 	})
 }
+
+func TestSeqCursor(t *testing.T) {
+	id := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+
+	t.Run("roundtrip", func(t *testing.T) {
+		seq, gotID, err := DecodeSeqCursor(EncodeSeqCursor(42, id))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(42), seq)
+		assert.Equal(t, id, gotID)
+	})
+
+	t.Run("time cursors are rejected", func(t *testing.T) {
+		_, _, err := DecodeSeqCursor(EncodeCursor(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), id))
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("malformed input", func(t *testing.T) {
+		for _, in := range []string{"", "!!!", base64.RawURLEncoding.EncodeToString([]byte("seq|x|" + id.String())), base64.RawURLEncoding.EncodeToString([]byte("seq|1|bad"))} {
+			_, _, err := DecodeSeqCursor(in)
+			assert.ErrorIs(t, err, ErrInvalidCursor, in)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package summarizer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// DefaultMaxCharsPerMessage caps how much of each message the extractive summarizer keeps.
+const DefaultMaxCharsPerMessage = 200
+
+// Summarizer folds messages into a session's rolling summary.
+type Summarizer interface {
+	// Summarize returns a summary covering previous, the summary so far (empty on the first
+	// run), followed by messages in chronological order.
+	Summarize(ctx context.Context, previous string, messages []model.Message) (string, error)
+}
+
+// Extractive is a Summarizer that needs no model: it keeps one line per message holding the
+// start of its text, and notes tool calls, tool results and attachments by kind.
+type Extractive struct {
+	MaxCharsPerMessage int
+}
+
+func NewExtractive(maxCharsPerMessage int) *Extractive {
+	if maxCharsPerMessage <= 0 {
+		maxCharsPerMessage = DefaultMaxCharsPerMessage
+	}
+	return &Extractive{MaxCharsPerMessage: maxCharsPerMessage}
+}
+
+func (e *Extractive) Summarize(ctx context.Context, previous string, messages []model.Message) (string, error) {
+	var b strings.Builder
+	b.WriteString(previous)
+	for _, m := range messages {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		line := e.summarizeMessage(m)
+		if line == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s: %s", displayRole(m), line)
+	}
+	return b.String(), nil
+}
+
+func (e *Extractive) summarizeMessage(m model.Message) string {
+	var pieces []string
+	for _, p := range m.Parts {
+		switch p.Type {
+		case model.PartTypeText:
+			if text := strings.Join(strings.Fields(p.Text), " "); text != "" {
+				pieces = append(pieces, text)
+			}
+		case model.PartTypeThinking:
+			// Reasoning is not carried into the summary.
+		case model.PartTypeToolCall:
+			name, _ := p.Meta[model.MetaKeyName].(string)
+			pieces = append(pieces, fmt.Sprintf("[called %s]", name))
+		case model.PartTypeToolResult:
+			pieces = append(pieces, "[tool result]")
+		default:
+			pieces = append(pieces, fmt.Sprintf("[%s]", p.Type))
+		}
+	}
+	return truncate(strings.Join(pieces, " "), e.MaxCharsPerMessage)
+}
+
+// displayRole prefers the provider role recorded on store, e.g. system, over the stored one.
+func displayRole(m model.Message) string {
+	if role, ok := m.Meta.Data()[model.MsgMetaOriginalRole].(string); ok && role != "" {
+		return role
+	}
+	return m.Role
+}
+
+func truncate(s string, maxChars int) string {
+	r := []rune(s)
+	if len(r) <= maxChars {
+		return s
+	}
+	return string(r[:maxChars]) + "…"
+}
@@ -0,0 +1,54 @@
+package summarizer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestExtractive_Summarize(t *testing.T) {
+	ctx := context.Background()
+	msgs := []model.Message{
+		{
+			Role:  model.RoleUser,
+			Meta:  datatypes.NewJSONType(map[string]any{model.MsgMetaOriginalRole: "system"}),
+			Parts: []model.Part{{Type: model.PartTypeText, Text: "You are   a helpful\nassistant."}},
+		},
+		{Role: model.RoleUser, Parts: []model.Part{{Type: model.PartTypeText, Text: strings.Repeat("a", 30)}}},
+		{Role: model.RoleAssistant, Parts: []model.Part{
+			{Type: model.PartTypeThinking, Text: "let me look it up"},
+			{Type: model.PartTypeToolCall, Meta: map[string]any{model.MetaKeyName: "search"}},
+		}},
+		{Role: model.RoleUser, Parts: []model.Part{{Type: model.PartTypeToolResult, Text: "42"}}},
+		{Role: model.RoleAssistant, Parts: []model.Part{{Type: model.PartTypeThinking, Text: "only thoughts"}}},
+	}
+
+	t.Run("one line per message", func(t *testing.T) {
+		got, err := NewExtractive(20).Summarize(ctx, "", msgs)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Join([]string{
+			"system: You are a helpful as…",
+			"user: " + strings.Repeat("a", 20) + "…",
+			"assistant: [called search]",
+			"user: [tool result]",
+		}, "\n"), got)
+	})
+
+	t.Run("appends to the previous summary", func(t *testing.T) {
+		got, err := NewExtractive(0).Summarize(ctx, "user: hi", msgs[3:4])
+		require.NoError(t, err)
+		assert.Equal(t, "user: hi\nuser: [tool result]", got)
+	})
+
+	t.Run("honours cancellation", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := NewExtractive(0).Summarize(cancelled, "", msgs)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
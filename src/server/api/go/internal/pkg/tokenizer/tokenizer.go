@@ -3,6 +3,7 @@ package tokenizer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -12,11 +13,20 @@ import (
 	"go.uber.org/zap"
 )
 
+// DefaultEncoding is the encoding used when callers do not name one.
+const DefaultEncoding = string(tokenizer.O200kBase)
+
+// ErrUnsupportedEncoding is returned for encoding names tiktoken does not know.
+var ErrUnsupportedEncoding = errors.New("unsupported tokenizer encoding")
+
 var (
 	// Global codec instance
 	codec   tokenizer.Codec
 	once    sync.Once
 	initErr error
+
+	// Codecs for non-default encodings, loaded on first use
+	codecs sync.Map
 )
 
 // Init initializes the tokenizer
@@ -52,6 +62,61 @@ func CountTokens(text string) (int, error) {
 	return count, nil
 }
 
+// codecFor returns the codec for the named encoding; empty means DefaultEncoding.
+func codecFor(encoding string) (tokenizer.Codec, error) {
+	if encoding == "" {
+		encoding = DefaultEncoding
+	}
+	if encoding == DefaultEncoding && codec != nil {
+		return codec, nil
+	}
+	if c, ok := codecs.Load(encoding); ok {
+		return c.(tokenizer.Codec), nil
+	}
+	enc, err := tokenizer.Get(tokenizer.Encoding(encoding))
+	if err != nil {
+		if errors.Is(err, tokenizer.ErrEncodingNotSupported) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+		}
+		return nil, fmt.Errorf("failed to get tokenizer %s: %w", encoding, err)
+	}
+	c, _ := codecs.LoadOrStore(encoding, enc)
+	return c.(tokenizer.Codec), nil
+}
+
+// ValidateEncoding reports ErrUnsupportedEncoding if encoding cannot be loaded
+func ValidateEncoding(encoding string) error {
+	_, err := codecFor(encoding)
+	return err
+}
+
+// CountTokensWithEncoding counts the number of tokens in text using the named encoding (e.g. "cl100k_base")
+func CountTokensWithEncoding(encoding, text string) (int, error) {
+	c, err := codecFor(encoding)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := c.Count(text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+
+	return count, nil
+}
+
+// CountPartsTokens counts tokens for the text and tool-call content of parts using the named encoding
+func CountPartsTokens(parts []model.Part, encoding string) (int, error) {
+	content, err := ExtractTextAndToolContent(parts)
+	if err != nil {
+		return 0, err
+	}
+	if content == "" {
+		return 0, nil
+	}
+	return CountTokensWithEncoding(encoding, content)
+}
+
 // ExtractTextAndToolContent extracts text and tool-call content from message parts
 func ExtractTextAndToolContent(parts []model.Part) (string, error) {
 	var content strings.Builder
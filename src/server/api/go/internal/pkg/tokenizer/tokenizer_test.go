@@ -0,0 +1,31 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountTokensWithEncoding(t *testing.T) {
+	for _, enc := range []string{"", "o200k_base", "cl100k_base"} {
+		n, err := CountTokensWithEncoding(enc, "hello world")
+		require.NoError(t, err, enc)
+		assert.Equal(t, 2, n, enc)
+	}
+
+	_, err := CountTokensWithEncoding("nope", "hello")
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+	assert.ErrorIs(t, ValidateEncoding("nope"), ErrUnsupportedEncoding)
+}
+
+func TestCountPartsTokens(t *testing.T) {
+	n, err := CountPartsTokens([]model.Part{{Type: model.PartTypeImage}}, "")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = CountPartsTokens([]model.Part{{Type: model.PartTypeText, Text: "hello world"}}, "cl100k_base")
+	require.NoError(t, err)
+	assert.Greater(t, n, 0)
+}
@@ -0,0 +1,149 @@
+package toolschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+)
+
+// CallError lists the violations of one tool-call part of a message.
+type CallError struct {
+	Index      int         `json:"index"`
+	Tool       string      `json:"tool"`
+	Violations []Violation `json:"violations"`
+}
+
+// ArgumentsError lists every tool-call part of a message whose arguments fail their schema.
+type ArgumentsError struct {
+	Calls []CallError
+}
+
+func (e *ArgumentsError) Error() string {
+	msgs := make([]string, 0, len(e.Calls))
+	for _, c := range e.Calls {
+		for _, v := range c.Violations {
+			path := v.Path
+			if path == "" {
+				path = "/"
+			}
+			msgs = append(msgs, fmt.Sprintf("parts[%d] %s %s: %s", c.Index, c.Tool, path, v.Message))
+		}
+	}
+	return "invalid tool arguments: " + strings.Join(msgs, "; ")
+}
+
+// Registry holds the argument schemas of known tools. It is safe for concurrent use, so schemas
+// may be registered after the server has started.
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+}
+
+func NewRegistry() *Registry {
+	return &Registry{schemas: make(map[string]*Schema)}
+}
+
+// RegisterToolSchema compiles schema and registers it for the tool called name, replacing any
+// schema registered before.
+func (r *Registry) RegisterToolSchema(name string, schema json.RawMessage) error {
+	if name == "" {
+		return fmt.Errorf("%w: tool name is empty", ErrInvalidSchema)
+	}
+	s, err := Compile(schema)
+	if err != nil {
+		return fmt.Errorf("tool %q: %w", name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[name] = s
+	return nil
+}
+
+// LoadDir registers every <tool>.json file in dir under the tool name taken from its file name.
+func (r *Registry) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := r.RegisterToolSchema(strings.TrimSuffix(filepath.Base(path), ".json"), data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the schema registered for the tool called name.
+func (r *Registry) Lookup(name string) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.schemas[name]
+	return s, ok
+}
+
+// ValidateParts checks the arguments of every tool-call part whose tool has a schema and reports
+// all failures as an *ArgumentsError. Arguments are usually a JSON string but may already be
+// decoded; a string that is not JSON is a violation. It returns the names of called tools that
+// have no schema, which pass unchecked. A nil Registry validates nothing.
+func (r *Registry) ValidateParts(parts []model.Part) (unregistered []string, err error) {
+	if r == nil {
+		return nil, nil
+	}
+	var invalid []CallError
+	for i, p := range parts {
+		if p.Type != model.PartTypeToolCall {
+			continue
+		}
+		name := p.Name()
+		s, ok := r.Lookup(name)
+		if !ok {
+			unregistered = append(unregistered, name)
+			continue
+		}
+		args, verr := decodeArguments(p.Meta[model.MetaKeyArguments])
+		violations := []Violation{}
+		if verr != nil {
+			violations = append(violations, *verr)
+		} else {
+			violations = s.Validate(args)
+		}
+		if len(violations) > 0 {
+			invalid = append(invalid, CallError{Index: i, Tool: name, Violations: violations})
+		}
+	}
+	if len(invalid) > 0 {
+		return unregistered, &ArgumentsError{Calls: invalid}
+	}
+	return unregistered, nil
+}
+
+// decodeArguments turns tool-call arguments into the form encoding/json decodes JSON into. An
+// empty string, which some providers send for calls without parameters, is an empty object.
+func decodeArguments(raw any) (any, *Violation) {
+	var data []byte
+	if s, ok := raw.(string); ok {
+		if strings.TrimSpace(s) == "" {
+			s = "{}"
+		}
+		data = []byte(s)
+	} else {
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, &Violation{Message: "arguments are not JSON: " + err.Error()}
+		}
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, &Violation{Message: "arguments are not valid JSON: " + err.Error()}
+	}
+	return v, nil
+}
@@ -0,0 +1,85 @@
+package toolschema
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/memodb-io/Acontext/internal/modules/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const searchSchema = `{"type":"object","properties":{"query":{"type":"string"}},"required":["query"]}`
+
+func TestRegistry_ValidateParts(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.RegisterToolSchema("search", json.RawMessage(searchSchema)))
+
+	parts := []model.Part{
+		model.NewTextPart("looking it up"),
+		model.NewToolCallPart("call_1", "search", `{"query":"go generics"}`),
+		model.NewToolCallPart("call_2", "search", `{"q":"go generics"}`),
+		model.NewToolCallPart("call_3", "search", `{"query":`),
+		model.NewToolCallPart("call_4", "calculator", `{"expr":"1+1"}`),
+		{Type: model.PartTypeToolCall, Meta: map[string]any{model.MetaKeyName: "search", model.MetaKeyArguments: map[string]any{"query": 42}}},
+	}
+
+	unregistered, err := r.ValidateParts(parts)
+	assert.Equal(t, []string{"calculator"}, unregistered)
+
+	var invalid *ArgumentsError
+	require.True(t, errors.As(err, &invalid))
+	require.Len(t, invalid.Calls, 3)
+	assert.Equal(t, CallError{Index: 2, Tool: "search", Violations: []Violation{{Path: "", Message: `missing required property "query"`}}}, invalid.Calls[0])
+	assert.Equal(t, 3, invalid.Calls[1].Index)
+	assert.Contains(t, invalid.Calls[1].Violations[0].Message, "arguments are not valid JSON")
+	assert.Equal(t, CallError{Index: 5, Tool: "search", Violations: []Violation{{Path: "/query", Message: "expected string, got integer"}}}, invalid.Calls[2])
+	assert.Contains(t, err.Error(), `parts[2] search /: missing required property "query"`)
+}
+
+func TestRegistry_ValidateParts_EmptyArguments(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.RegisterToolSchema("now", json.RawMessage(`{"type":"object","additionalProperties":false}`)))
+
+	_, err := r.ValidateParts([]model.Part{model.NewToolCallPart("call_1", "now", "")})
+	assert.NoError(t, err)
+}
+
+func TestRegistry_NilValidatesNothing(t *testing.T) {
+	var r *Registry
+	unregistered, err := r.ValidateParts([]model.Part{model.NewToolCallPart("call_1", "search", `{}`)})
+	assert.NoError(t, err)
+	assert.Empty(t, unregistered)
+}
+
+func TestRegistry_RegisterToolSchema(t *testing.T) {
+	r := NewRegistry()
+	assert.ErrorIs(t, r.RegisterToolSchema("", json.RawMessage(searchSchema)), ErrInvalidSchema)
+	assert.ErrorIs(t, r.RegisterToolSchema("search", json.RawMessage(`{"type":"query"}`)), ErrInvalidSchema)
+	_, ok := r.Lookup("search")
+	assert.False(t, ok)
+
+	require.NoError(t, r.RegisterToolSchema("search", json.RawMessage(searchSchema)))
+	require.NoError(t, r.RegisterToolSchema("search", json.RawMessage(`true`)))
+	_, err := r.ValidateParts([]model.Part{model.NewToolCallPart("call_1", "search", `{}`)})
+	assert.NoError(t, err, "a later registration replaces the schema")
+}
+
+func TestRegistry_LoadDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "search.json"), []byte(searchSchema), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a schema"), 0o644))
+
+	r := NewRegistry()
+	require.NoError(t, r.LoadDir(dir))
+	_, ok := r.Lookup("search")
+	assert.True(t, ok)
+	_, ok = r.Lookup("README")
+	assert.False(t, ok)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"type":1}`), 0o644))
+	assert.ErrorIs(t, NewRegistry().LoadDir(dir), ErrInvalidSchema)
+}
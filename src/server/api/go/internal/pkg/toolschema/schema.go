@@ -0,0 +1,458 @@
+// Package toolschema validates tool-call arguments against JSON Schemas registered per tool name.
+//
+// Schemas use a subset of JSON Schema (draft 2020-12) covering what tool definitions of the
+// OpenAI, Anthropic and Gemini APIs use: type, enum, const, object keywords (properties,
+// required, additionalProperties, minProperties, maxProperties), array keywords (items,
+// minItems, maxItems, uniqueItems), string keywords (minLength, maxLength, pattern), numeric
+// keywords (minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf) and the allOf,
+// anyOf, oneOf and not combinators. Annotations such as description or format are ignored;
+// keywords that need references or conditional evaluation are rejected at compile time.
+package toolschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidSchema is returned for schemas that are malformed or use unsupported keywords.
+var ErrInvalidSchema = errors.New("invalid tool schema")
+
+// unsupportedKeywords are validation keywords outside the supported subset. Accepting them
+// silently would let arguments through that the schema author meant to reject.
+var unsupportedKeywords = []string{
+	"$ref", "$dynamicRef", "patternProperties", "propertyNames", "dependentRequired",
+	"dependentSchemas", "dependencies", "if", "then", "else", "prefixItems", "contains",
+	"unevaluatedProperties", "unevaluatedItems",
+}
+
+var jsonTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+// Violation is one way a value fails a schema. Path is a JSON Pointer to the offending value,
+// "" for the value itself.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Schema is a compiled schema.
+type Schema struct {
+	// always is set for the boolean schemas true and false.
+	always *bool
+
+	types []string
+	enum  []any
+	cnst  *any
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+
+	items       *Schema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+}
+
+// Compile parses a JSON Schema document.
+func Compile(raw json.RawMessage) (*Schema, error) {
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	return compile(doc, "")
+}
+
+func compile(doc any, path string) (*Schema, error) {
+	fail := func(format string, args ...any) error {
+		at := path
+		if at == "" {
+			at = "/"
+		}
+		return fmt.Errorf("%w: %s: %s", ErrInvalidSchema, at, fmt.Sprintf(format, args...))
+	}
+
+	if b, ok := doc.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fail("a schema must be an object or a boolean")
+	}
+	for _, k := range unsupportedKeywords {
+		if _, ok := obj[k]; ok {
+			return nil, fail("keyword %q is not supported", k)
+		}
+	}
+
+	s := &Schema{}
+	var err error
+	if v, ok := obj["type"]; ok {
+		switch t := v.(type) {
+		case string:
+			s.types = []string{t}
+		case []any:
+			for _, e := range t {
+				name, ok := e.(string)
+				if !ok {
+					return nil, fail("type must be a string or an array of strings")
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return nil, fail("type must be a string or an array of strings")
+		}
+		for _, t := range s.types {
+			if !jsonTypes[t] {
+				return nil, fail("unknown type %q", t)
+			}
+		}
+	}
+	if v, ok := obj["enum"]; ok {
+		if s.enum, ok = v.([]any); !ok {
+			return nil, fail("enum must be an array")
+		}
+	}
+	if v, ok := obj["const"]; ok {
+		s.cnst = &v
+	}
+
+	if v, ok := obj["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, fail("properties must be an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compile(sub, path+"/properties/"+escapePointer(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := obj["required"]; ok {
+		list, ok := v.([]any)
+		if !ok {
+			return nil, fail("required must be an array of strings")
+		}
+		for _, e := range list {
+			name, ok := e.(string)
+			if !ok {
+				return nil, fail("required must be an array of strings")
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if v, ok := obj["additionalProperties"]; ok {
+		if s.additionalProperties, err = compile(v, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := obj["items"]; ok {
+		if s.items, err = compile(v, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := obj["uniqueItems"]; ok {
+		if s.uniqueItems, ok = v.(bool); !ok {
+			return nil, fail("uniqueItems must be a boolean")
+		}
+	}
+
+	counts := map[string]**int{
+		"minProperties": &s.minProperties, "maxProperties": &s.maxProperties,
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	}
+	for k, dst := range counts {
+		v, ok := obj[k]
+		if !ok {
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return nil, fail("%s must be a non-negative integer", k)
+		}
+		i := int(n)
+		*dst = &i
+	}
+	bounds := map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf": &s.multipleOf,
+	}
+	for k, dst := range bounds {
+		v, ok := obj[k]
+		if !ok {
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok {
+			return nil, fail("%s must be a number", k)
+		}
+		*dst = &n
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, fail("multipleOf must be greater than 0")
+	}
+	if v, ok := obj["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, fail("pattern must be a string")
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fail("pattern: %v", err)
+		}
+	}
+
+	lists := map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf}
+	for k, dst := range lists {
+		v, ok := obj[k]
+		if !ok {
+			continue
+		}
+		subs, ok := v.([]any)
+		if !ok || len(subs) == 0 {
+			return nil, fail("%s must be a non-empty array", k)
+		}
+		for i, sub := range subs {
+			c, err := compile(sub, path+"/"+k+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, c)
+		}
+	}
+	if v, ok := obj["not"]; ok {
+		if s.not, err = compile(v, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Validate checks a decoded JSON value, as produced by encoding/json, against the schema and
+// returns every violation found, ordered by path.
+func (s *Schema) Validate(v any) []Violation {
+	var out []Violation
+	s.validate(v, "", &out)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func (s *Schema) validate(v any, path string, out *[]Violation) {
+	add := func(format string, args ...any) {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			add("no value is allowed here")
+		}
+		return
+	}
+	if len(s.types) > 0 && !matchesType(v, s.types) {
+		add("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("value must be one of %s", compactJSON(s.enum))
+		}
+	}
+	if s.cnst != nil && !reflect.DeepEqual(*s.cnst, v) {
+		add("value must be %s", compactJSON(*s.cnst))
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		s.validateObject(val, path, out)
+	case []any:
+		s.validateArray(val, path, out)
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.minLength != nil && n < *s.minLength {
+			add("string is shorter than %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			add("string is longer than %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			add("string does not match pattern %q", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && val < *s.minimum {
+			add("value must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && val > *s.maximum {
+			add("value must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && val <= *s.exclusiveMinimum {
+			add("value must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && val >= *s.exclusiveMaximum {
+			add("value must be < %v", *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil {
+			if q := val / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				add("value must be a multiple of %v", *s.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, out)
+	}
+	if len(s.anyOf) > 0 && countMatches(s.anyOf, v) == 0 {
+		add("value does not match any schema of anyOf")
+	}
+	if len(s.oneOf) > 0 {
+		if n := countMatches(s.oneOf, v); n != 1 {
+			add("value must match exactly one schema of oneOf, matched %d", n)
+		}
+	}
+	if s.not != nil && len(s.not.Validate(v)) == 0 {
+		add("value must not match the schema of not")
+	}
+}
+
+func (s *Schema) validateObject(obj map[string]any, path string, out *[]Violation) {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("missing required property %q", name)})
+		}
+	}
+	if s.minProperties != nil && len(obj) < *s.minProperties {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("object has fewer than %d properties", *s.minProperties)})
+	}
+	if s.maxProperties != nil && len(obj) > *s.maxProperties {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("object has more than %d properties", *s.maxProperties)})
+	}
+	for name, val := range obj {
+		at := path + "/" + escapePointer(name)
+		if sub, ok := s.properties[name]; ok {
+			sub.validate(val, at, out)
+			continue
+		}
+		if s.additionalProperties == nil {
+			continue
+		}
+		if a := s.additionalProperties.always; a != nil && !*a {
+			*out = append(*out, Violation{Path: at, Message: "additional property is not allowed"})
+			continue
+		}
+		s.additionalProperties.validate(val, at, out)
+	}
+}
+
+func (s *Schema) validateArray(arr []any, path string, out *[]Violation) {
+	if s.minItems != nil && len(arr) < *s.minItems {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("array has fewer than %d items", *s.minItems)})
+	}
+	if s.maxItems != nil && len(arr) > *s.maxItems {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("array has more than %d items", *s.maxItems)})
+	}
+	if s.uniqueItems {
+	outer:
+		for i := range arr {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("items %d and %d are equal", j, i)})
+					break outer
+				}
+			}
+		}
+	}
+	if s.items != nil {
+		for i, item := range arr {
+			s.items.validate(item, path+"/"+strconv.Itoa(i), out)
+		}
+	}
+}
+
+func countMatches(schemas []*Schema, v any) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(sub.Validate(v)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func matchesType(v any, types []string) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON type of a decoded value; whole numbers are "integer".
+func typeOf(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) && !math.IsInf(val, 0) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
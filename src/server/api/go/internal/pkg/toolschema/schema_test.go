@@ -0,0 +1,146 @@
+package toolschema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validate(t *testing.T, schema, value string) []Violation {
+	t.Helper()
+	s, err := Compile(json.RawMessage(schema))
+	require.NoError(t, err)
+	var v any
+	require.NoError(t, json.Unmarshal([]byte(value), &v))
+	return s.Validate(v)
+}
+
+func TestSchema_Validate(t *testing.T) {
+	weather := `{
+		"type": "object",
+		"properties": {
+			"city": {"type": "string", "minLength": 1},
+			"unit": {"type": "string", "enum": ["celsius", "fahrenheit"]},
+			"days": {"type": "integer", "minimum": 1, "maximum": 14}
+		},
+		"required": ["city"],
+		"additionalProperties": false
+	}`
+
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []Violation
+	}{
+		{name: "valid object", schema: weather, value: `{"city":"Paris","unit":"celsius","days":3}`},
+		{
+			name:   "missing required and extra property",
+			schema: weather,
+			value:  `{"town":"Paris"}`,
+			want: []Violation{
+				{Path: "", Message: `missing required property "city"`},
+				{Path: "/town", Message: "additional property is not allowed"},
+			},
+		},
+		{
+			name:   "nested violations",
+			schema: weather,
+			value:  `{"city":"","unit":"kelvin","days":2.5}`,
+			want: []Violation{
+				{Path: "/city", Message: "string is shorter than 1 characters"},
+				{Path: "/days", Message: "expected integer, got number"},
+				{Path: "/unit", Message: `value must be one of ["celsius","fahrenheit"]`},
+			},
+		},
+		{
+			name:   "range",
+			schema: weather,
+			value:  `{"city":"Oslo","days":30}`,
+			want:   []Violation{{Path: "/days", Message: "value must be <= 14"}},
+		},
+		{
+			name:   "wrong top-level type",
+			schema: weather,
+			value:  `["Paris"]`,
+			want:   []Violation{{Path: "", Message: "expected object, got array"}},
+		},
+		{
+			name:   "array items",
+			schema: `{"type":"array","items":{"type":"string","pattern":"^[a-z]+$"},"minItems":1,"uniqueItems":true}`,
+			value:  `["a","B","a"]`,
+			want: []Violation{
+				{Path: "", Message: "items 0 and 2 are equal"},
+				{Path: "/1", Message: `string does not match pattern "^[a-z]+$"`},
+			},
+		},
+		{
+			name:   "nullable type list",
+			schema: `{"type":["string","null"]}`,
+			value:  `null`,
+		},
+		{
+			name:   "integer is a number",
+			schema: `{"type":"number","exclusiveMinimum":0,"multipleOf":0.5}`,
+			value:  `2`,
+		},
+		{
+			name:   "oneOf",
+			schema: `{"oneOf":[{"type":"string"},{"type":"string","maxLength":3}]}`,
+			value:  `"ab"`,
+			want:   []Violation{{Path: "", Message: "value must match exactly one schema of oneOf, matched 2"}},
+		},
+		{
+			name:   "anyOf and not",
+			schema: `{"anyOf":[{"type":"string"},{"type":"integer"}],"not":{"const":"x"}}`,
+			value:  `"x"`,
+			want:   []Violation{{Path: "", Message: "value must not match the schema of not"}},
+		},
+		{
+			name:   "additionalProperties schema",
+			schema: `{"type":"object","additionalProperties":{"type":"boolean"}}`,
+			value:  `{"a/b":1}`,
+			want:   []Violation{{Path: "/a~1b", Message: "expected boolean, got integer"}},
+		},
+		{
+			name:   "annotations are ignored",
+			schema: `{"type":"string","description":"a date","format":"date","default":"x"}`,
+			value:  `"not a date"`,
+		},
+		{name: "true schema", schema: `true`, value: `{"anything":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, validate(t, tt.schema, tt.value))
+		})
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{name: "not JSON", schema: `{`},
+		{name: "not an object", schema: `"string"`},
+		{name: "unknown type", schema: `{"type":"text"}`},
+		{name: "bad required", schema: `{"required":"city"}`},
+		{name: "negative count", schema: `{"minLength":-1}`},
+		{name: "bad pattern", schema: `{"pattern":"("}`},
+		{name: "zero multipleOf", schema: `{"multipleOf":0}`},
+		{name: "empty anyOf", schema: `{"anyOf":[]}`},
+		{name: "nested invalid", schema: `{"properties":{"a":{"type":1}}}`},
+		{name: "unsupported ref", schema: `{"properties":{"a":{"$ref":"#/$defs/a"}}}`},
+		{name: "unsupported conditional", schema: `{"if":{"type":"string"},"then":{"minLength":1}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(json.RawMessage(tt.schema))
+			assert.ErrorIs(t, err, ErrInvalidSchema)
+		})
+	}
+}
@@ -1,12 +1,63 @@
 package mime
 
 import (
+	"errors"
+	"fmt"
+	stdmime "mime"
 	"path/filepath"
 	"strings"
 
 	"github.com/gabriel-vasile/mimetype"
 )
 
+// ErrInvalid is returned by Normalize for strings that are not a usable MIME type.
+var ErrInvalid = errors.New("invalid MIME type")
+
+// octetStream is the type detection falls back to for content it does not recognize.
+const octetStream = "application/octet-stream"
+
+// topLevelTypes are the registered top-level media types; anything else is rejected.
+var topLevelTypes = map[string]bool{
+	"application": true,
+	"audio":       true,
+	"font":        true,
+	"image":       true,
+	"message":     true,
+	"model":       true,
+	"multipart":   true,
+	"text":        true,
+	"video":       true,
+}
+
+// aliases maps non-standard spellings clients send to the canonical type detection reports.
+var aliases = map[string]string{
+	"image/jpg":                "image/jpeg",
+	"image/pjpeg":              "image/jpeg",
+	"image/x-png":              "image/png",
+	"image/x-ms-bmp":           "image/bmp",
+	"image/tif":                "image/tiff",
+	"image/vnd.microsoft.icon": "image/x-icon",
+	"audio/mp3":                "audio/mpeg",
+	"audio/x-mp3":              "audio/mpeg",
+	"audio/mpeg3":              "audio/mpeg",
+	"audio/x-mpeg":             "audio/mpeg",
+	"audio/x-wav":              "audio/wav",
+	"audio/wave":               "audio/wav",
+	"audio/vnd.wave":           "audio/wav",
+	"audio/x-flac":             "audio/flac",
+	"audio/x-aac":              "audio/aac",
+	"audio/m4a":                "audio/x-m4a",
+	"application/x-pdf":        "application/pdf",
+	"text/json":                "application/json",
+	"application/x-json":       "application/json",
+	"text/x-markdown":          "text/markdown",
+	"application/x-yaml":       "text/yaml",
+	"application/yaml":         "text/yaml",
+	"text/x-yaml":              "text/yaml",
+	"application/javascript":   "text/javascript",
+	"application/x-javascript": "text/javascript",
+}
+
 // extMimeMap maps file extensions to more specific MIME types for text-based files.
 // Used when content-based detection returns "text/plain" but extension suggests a specific format.
 var extMimeMap = map[string]string{
@@ -62,3 +113,70 @@ func DetectMimeType(content []byte, filename string) string {
 	}
 	return contentType
 }
+
+// Normalize returns the canonical form of a declared MIME type: the lowercased type/subtype
+// without parameters, with common aliases such as image/jpg resolved. Strings that do not parse,
+// use a wildcard or an unregistered top-level type return an error wrapping ErrInvalid.
+func Normalize(raw string) (string, error) {
+	mediaType, _, err := stdmime.ParseMediaType(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w %q", ErrInvalid, raw)
+	}
+	typ, subtype, ok := strings.Cut(mediaType, "/")
+	if !ok || subtype == "" || typ == "*" || subtype == "*" || !topLevelTypes[typ] {
+		return "", fmt.Errorf("%w %q", ErrInvalid, raw)
+	}
+	if canonical, ok := aliases[mediaType]; ok {
+		return canonical, nil
+	}
+	return mediaType, nil
+}
+
+// IsGeneric reports whether a detected type says no more than "binary" or "text", so a more
+// specific declared type should be kept in its place.
+func IsGeneric(detected string) bool {
+	t, _ := Normalize(detected)
+	return t == "" || t == octetStream || t == "text/plain"
+}
+
+// Compatible reports whether content detected as detected may be declared as declared.
+// Detection cannot tell every format apart, so besides equal types it accepts a declared type
+// that is an ancestor or descendant of the detected one in the detection tree (application/zip
+// for a .docx, application/json for text), any declared type when detection found nothing
+// specific, the same container declared as audio or video (audio/mp4 for video/mp4), and a
+// textual type detection does not know for plain text. Both arguments may carry parameters.
+func Compatible(declared, detected string) bool {
+	d, _ := Normalize(declared)
+	t, _ := Normalize(detected)
+	if d == t || t == "" || t == octetStream || d == octetStream {
+		return true
+	}
+	if isAncestor(d, t) || isAncestor(t, d) {
+		return true
+	}
+
+	dFamily, dSubtype, _ := strings.Cut(d, "/")
+	tFamily, tSubtype, _ := strings.Cut(t, "/")
+	if dSubtype == tSubtype && (dFamily == "audio" || dFamily == "video") && (tFamily == "audio" || tFamily == "video") {
+		return true
+	}
+	if t == "text/plain" {
+		switch dFamily {
+		case "image", "audio", "video", "font":
+			return false
+		}
+		// A type detection knows would have been detected; one it does not know may be text.
+		return mimetype.Lookup(d) == nil
+	}
+	return false
+}
+
+// isAncestor reports whether ancestor is of or one of its parents in the detection tree.
+func isAncestor(ancestor, of string) bool {
+	for n := mimetype.Lookup(of); n != nil; n = n.Parent() {
+		if n.Is(ancestor) {
+			return true
+		}
+	}
+	return false
+}
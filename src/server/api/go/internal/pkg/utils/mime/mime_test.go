@@ -0,0 +1,77 @@
+package mime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+	jpegHeader = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	pdfHeader  = []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"image/jpeg", "image/jpeg"},
+		{"image/jpg", "image/jpeg"},
+		{"IMAGE/JPG", "image/jpeg"},
+		{" Image/PNG ; charset=binary", "image/png"},
+		{"audio/mp3", "audio/mpeg"},
+		{"audio/x-wav", "audio/wav"},
+		{"text/markdown; charset=utf-8", "text/markdown"},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw)
+		require.NoError(t, err, tt.raw)
+		assert.Equal(t, tt.want, got, tt.raw)
+	}
+
+	for _, raw := range []string{"", "jpeg", "image/", "image/*", "*/*", "picture/jpeg", "image/jpeg/extra", "image/jp eg"} {
+		_, err := Normalize(raw)
+		assert.ErrorIs(t, err, ErrInvalid, raw)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	detect := func(content []byte, name string) string { return DetectMimeType(content, name) }
+
+	tests := []struct {
+		name     string
+		declared string
+		detected string
+		want     bool
+	}{
+		{"same type", "image/png", detect(pngHeader, "a.png"), true},
+		{"alias of detected", "image/jpg", detect(jpegHeader, "a.jpg"), true},
+		{"image declared as another image", "image/png", detect(jpegHeader, "a.png"), false},
+		{"image declared as pdf", "application/pdf", detect(pngHeader, "a.pdf"), false},
+		{"pdf", "application/pdf", detect(pdfHeader, "a.pdf"), true},
+		{"unknown binary", "video/mp4", "application/octet-stream", true},
+		{"declared as binary", "application/octet-stream", detect(pngHeader, "a.bin"), true},
+		{"markdown is text", "text/markdown", detect([]byte("# Title\n\nbody"), "a.txt"), true},
+		{"json is text", "application/json", "text/plain; charset=utf-8", true},
+		{"text declared as image", "image/png", detect([]byte("just some text"), "a.png"), false},
+		{"text declared as pdf", "application/pdf", detect([]byte("just some text"), "a.pdf"), false},
+		{"container as audio or video", "audio/mp4", "video/mp4", true},
+		{"parent of detected", "application/zip", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Compatible(tt.declared, tt.detected), "%s vs %s", tt.declared, tt.detected)
+		})
+	}
+}
+
+func TestIsGeneric(t *testing.T) {
+	assert.True(t, IsGeneric("application/octet-stream"))
+	assert.True(t, IsGeneric("text/plain; charset=utf-8"))
+	assert.True(t, IsGeneric(""))
+	assert.False(t, IsGeneric("image/png"))
+}
@@ -10,6 +10,7 @@ import (
 
 	_ "github.com/memodb-io/Acontext/docs"
 	"github.com/memodb-io/Acontext/internal/config"
+	"github.com/memodb-io/Acontext/internal/infra/ratelimit"
 	"github.com/memodb-io/Acontext/internal/middleware"
 	"github.com/memodb-io/Acontext/internal/modules/handler"
 	"github.com/memodb-io/Acontext/internal/modules/serializer"
@@ -18,22 +19,29 @@ import (
 )
 
 type RouterDeps struct {
-	Config               *config.Config
-	DB                   *gorm.DB
-	Redis                *redis.Client
-	Log                  *zap.Logger
-	SessionHandler       *handler.SessionHandler
-	DiskHandler          *handler.DiskHandler
-	ArtifactHandler      *handler.ArtifactHandler
-	TaskHandler          *handler.TaskHandler
-	AgentSkillsHandler   *handler.AgentSkillsHandler
-	UserHandler          *handler.UserHandler
-	SandboxHandler       *handler.SandboxHandler
-	LearningSpaceHandler *handler.LearningSpaceHandler
-	SessionEventHandler  *handler.SessionEventHandler
-	ProjectHandler       *handler.ProjectHandler
-	MaterialHandler      *handler.MaterialHandler
-	ProjectAuthOverride  gin.HandlerFunc // If set, used instead of default ProjectAuth for /api/v1
+	Config                  *config.Config
+	DB                      *gorm.DB
+	Redis                   *redis.Client
+	Log                     *zap.Logger
+	SessionHandler          *handler.SessionHandler
+	DiskHandler             *handler.DiskHandler
+	ArtifactHandler         *handler.ArtifactHandler
+	TaskHandler             *handler.TaskHandler
+	AgentSkillsHandler      *handler.AgentSkillsHandler
+	UserHandler             *handler.UserHandler
+	SandboxHandler          *handler.SandboxHandler
+	LearningSpaceHandler    *handler.LearningSpaceHandler
+	SessionEventHandler     *handler.SessionEventHandler
+	MessageEmbeddingHandler *handler.MessageEmbeddingHandler
+	EnrichmentHandler       *handler.EnrichmentHandler
+	MessageStreamHandler    *handler.MessageStreamHandler
+	ProjectHandler          *handler.ProjectHandler
+	MaterialHandler         *handler.MaterialHandler
+	AssetUploadHandler      *handler.AssetUploadHandler
+	AssetContentHandler     *handler.AssetContentHandler
+	HealthHandler           *handler.HealthHandler
+	MessageLimiter          ratelimit.Limiter // Shared with gRPC; if nil, one is built from Config
+	ProjectAuthOverride     gin.HandlerFunc   // If set, used instead of default ProjectAuth for /api/v1
 }
 
 func NewRouter(d RouterDeps) *gin.Engine {
@@ -54,6 +62,8 @@ func NewRouter(d RouterDeps) *gin.Engine {
 
 	// health
 	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, serializer.Response{Msg: "ok"}) })
+	r.GET("/healthz", d.HealthHandler.Healthz)
+	r.GET("/readyz", d.HealthHandler.Readyz)
 
 	// swagger
 	r.GET("/swagger", func(c *gin.Context) {
@@ -75,28 +85,95 @@ func NewRouter(d RouterDeps) *gin.Engine {
 		// ping endpoint
 		v1.GET("/ping", func(c *gin.Context) { c.JSON(http.StatusOK, serializer.Response{Msg: "pong"}) })
 
+		limiter := d.MessageLimiter
+		if limiter == nil {
+			limiter = ratelimit.New(d.Config.RateLimit.Backend, d.Redis, d.Log)
+		}
+		messageRateLimit := middleware.MessageRateLimit(d.Config, limiter, d.Log)
+
 		session := v1.Group("/session")
 		{
 			session.GET("", d.SessionHandler.GetSessions)
+			session.GET("/search", d.SessionHandler.SearchMessages)
+			session.GET("/search/history", d.SessionHandler.SearchHistory)
+			session.POST("/search/similar", d.MessageEmbeddingHandler.SearchSimilar)
+			session.POST("/embeddings/reindex", d.MessageEmbeddingHandler.ReindexEmbeddings)
+			session.GET("/embeddings/reindex/:reindex_id", d.MessageEmbeddingHandler.GetEmbeddingReindex)
 			session.POST("", d.SessionHandler.CreateSession)
+			session.POST("/import", d.SessionHandler.ImportSession)
+			session.POST("/import/bundle", d.SessionHandler.ImportSessionBundle)
 			session.DELETE("/:session_id", d.SessionHandler.DeleteSession)
 
 			session.PUT("/:session_id/configs", d.SessionHandler.UpdateConfigs)
 			session.PATCH("/:session_id/configs", d.SessionHandler.PatchConfigs)
 			session.GET("/:session_id/configs", d.SessionHandler.GetConfigs)
+			session.PUT("/:session_id/metadata", d.SessionHandler.SetMetadata)
+			session.PATCH("/:session_id/metadata", d.SessionHandler.PatchMetadata)
+			session.PATCH("/:session_id/tags", d.SessionHandler.UpdateTags)
+			session.PUT("/:session_id/allowed_part_types", d.SessionHandler.SetAllowedPartTypes)
 
-			session.POST("/:session_id/messages", d.SessionHandler.StoreMessage)
+			session.POST("/:session_id/messages", messageRateLimit, d.SessionHandler.StoreMessage)
 			session.GET("/:session_id/messages", d.SessionHandler.GetMessages)
+			session.POST("/:session_id/messages/stream", messageRateLimit, d.SessionHandler.StartStreamingMessage)
+			session.POST("/:session_id/messages/:message_id/append", d.SessionHandler.AppendMessagePart)
+			session.POST("/:session_id/messages/:message_id/finalize", d.SessionHandler.FinalizeMessage)
 			session.PATCH("/:session_id/messages/:message_id/meta", d.SessionHandler.PatchMessageMeta)
+			session.GET("/:session_id/messages/:message_id/thread", d.SessionHandler.GetMessageThread)
+			session.PUT("/:session_id/messages/:message_id/parent", d.SessionHandler.ReparentMessage)
+			session.GET("/:session_id/diff", d.SessionHandler.DiffBranches)
+			session.GET("/:session_id/validate", d.SessionHandler.ValidateSession)
+			session.GET("/:session_id/tree/validate", d.SessionHandler.ValidateTree)
+			session.POST("/:session_id/tree/repair", d.SessionHandler.RepairTree)
+			session.POST("/:session_id/dedupe", d.SessionHandler.DedupeSession)
+			session.DELETE("/:session_id/messages", d.SessionHandler.DeleteMessages)
+			session.DELETE("/:session_id/messages/:message_id", d.SessionHandler.DeleteMessage)
+			session.POST("/:session_id/messages/:message_id/restore", d.SessionHandler.RestoreMessage)
+			session.POST("/:session_id/messages/:message_id/pin", d.SessionHandler.PinMessage)
+			session.DELETE("/:session_id/messages/:message_id/pin", d.SessionHandler.UnpinMessage)
+			session.GET("/:session_id/pinned", d.SessionHandler.GetPinnedMessages)
+			session.PUT("/:session_id/messages/:message_id/flag", d.SessionHandler.SetMessageFlag)
+			session.GET("/:session_id/messages/:message_id/flag/audits", d.SessionHandler.GetMessageFlagAudits)
+			session.POST("/:session_id/messages/:message_id/annotations", d.SessionHandler.AddMessageAnnotation)
+			session.GET("/:session_id/messages/:message_id/annotations", d.SessionHandler.GetMessageAnnotations)
+			session.DELETE("/:session_id/messages/:message_id/annotations/:annotation_id", d.SessionHandler.DeleteMessageAnnotation)
+			session.GET("/:session_id/annotations/reactions", d.SessionHandler.GetReactionCounts)
+			session.PUT("/:session_id/messages/:message_id/parts", d.SessionHandler.UpdateMessageParts)
+			session.PATCH("/:session_id/messages/:message_id/parts", d.SessionHandler.PatchMessageParts)
+			session.GET("/:session_id/messages/:message_id/revisions", d.SessionHandler.GetMessageRevisions)
+			session.GET("/:session_id/messages/:message_id/parts", d.SessionHandler.GetMessageParts)
+			session.POST("/:session_id/messages/:message_id/assets", d.SessionHandler.AttachAssets)
+			session.DELETE("/:session_id/messages/:message_id/assets", d.SessionHandler.DetachAssets)
+			session.PUT("/:session_id/messages/:message_id/embedding", d.MessageEmbeddingHandler.UpsertEmbedding)
+			session.GET("/:session_id/messages/:message_id/enrichments", d.EnrichmentHandler.GetEnrichmentStatus)
+			session.POST("/:session_id/messages/:message_id/enrichments/retry", d.EnrichmentHandler.RetryEnrichment)
 
 			session.GET("/:session_id/asset/download", d.SessionHandler.DownloadSessionAsset)
 			session.POST("/:session_id/flush", d.SessionHandler.SessionFlush)
 
 			session.GET("/:session_id/token_counts", d.SessionHandler.GetTokenCounts)
+			session.GET("/:session_id/tokens", d.SessionHandler.GetSessionTokens)
+			session.GET("/:session_id/usage", d.SessionHandler.GetStorageUsage)
+
+			session.GET("/:session_id/stream", d.MessageStreamHandler.StreamSession)
+			session.GET("/:session_id/export", d.SessionHandler.ExportSession)
+			session.GET("/:session_id/bundle", d.SessionHandler.ExportSessionBundle)
+			session.GET("/:session_id/replay", d.SessionHandler.ReplaySession)
 
 			session.GET("/:session_id/observing_status", d.SessionHandler.GetSessionObservingStatus)
 
 			session.POST("/:session_id/copy", d.SessionHandler.CopySession)
+			session.POST("/:session_id/fork", d.SessionHandler.ForkSession)
+			session.POST("/:session_id/clone", d.SessionHandler.CloneSessionShallow)
+			session.PUT("/:session_id/template", d.SessionHandler.SetTemplate)
+			session.POST("/:session_id/instantiate", d.SessionHandler.InstantiateTemplate)
+			session.POST("/:session_id/archive", d.SessionHandler.ArchiveSession)
+			session.POST("/:session_id/restore", d.SessionHandler.RestoreSession)
+			session.POST("/:session_id/finalize", d.SessionHandler.FinalizeSession)
+			session.GET("/:session_id/immutability/overrides", d.SessionHandler.GetImmutabilityOverrides)
+			session.POST("/:session_id/merge", d.SessionHandler.MergeSessions)
+			session.POST("/:session_id/lock", d.SessionHandler.AcquireSessionLock)
+			session.GET("/:session_id/lock", d.SessionHandler.GetSessionLock)
+			session.DELETE("/:session_id/lock", d.SessionHandler.ReleaseSessionLock)
 
 			session.POST("/:session_id/events", d.SessionEventHandler.AddEvent)
 			session.GET("/:session_id/events", d.SessionEventHandler.GetEvents)
@@ -107,6 +184,13 @@ func NewRouter(d RouterDeps) *gin.Engine {
 			}
 		}
 
+		asset := v1.Group("/asset")
+		{
+			asset.POST("/upload", d.AssetUploadHandler.CreatePresignedUpload)
+			asset.POST("/upload/:upload_id/confirm", d.AssetUploadHandler.ConfirmAsset)
+			asset.GET("/:asset_id/content", d.AssetContentHandler.GetAssetContent)
+		}
+
 		disk := v1.Group("/disk")
 		{
 			disk.GET("", d.DiskHandler.ListDisks)
@@ -157,10 +241,16 @@ func NewRouter(d RouterDeps) *gin.Engine {
 
 		project := v1.Group("/project")
 		{
+			// Managing the project takes its secret key; project keys only use it.
+			secretKey := middleware.RequireSecretKey()
 			project.GET("/configs", d.ProjectHandler.GetConfigs)
-			project.PATCH("/configs", d.ProjectHandler.PatchConfigs)
-			project.POST("/encrypt", d.ProjectHandler.EncryptProject)
-			project.POST("/decrypt", d.ProjectHandler.DecryptProject)
+			project.PATCH("/configs", secretKey, d.ProjectHandler.PatchConfigs)
+			project.POST("/encrypt", secretKey, d.ProjectHandler.EncryptProject)
+			project.POST("/decrypt", secretKey, d.ProjectHandler.DecryptProject)
+			project.POST("/keys", secretKey, d.ProjectHandler.CreateProjectKey)
+			project.GET("/keys", secretKey, d.ProjectHandler.ListProjectKeys)
+			project.DELETE("/keys/:key_id", secretKey, d.ProjectHandler.DeleteProjectKey)
+			project.GET("/export", d.SessionHandler.ExportProject)
 		}
 
 		learningSpaces := v1.Group("/learning_spaces")
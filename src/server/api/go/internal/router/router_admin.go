@@ -34,12 +34,18 @@ func NewAdminRouter(d AdminRouterDeps) *gin.Engine {
 		admin.GET("/project/:project_id/usages", d.AdminHandler.AnalyzeProjectUsages)
 		admin.GET("/project/:project_id/statistics", d.AdminHandler.AnalyzeProjectStatistics)
 		admin.GET("/project/:project_id/metrics", d.AdminHandler.AnalyzeProjectMetrics)
+
+		admin.POST("/asset/gc", d.AdminHandler.CollectOrphanedAssets)
+		admin.POST("/session/stats/check", d.AdminHandler.CheckSessionStats)
+
+		admin.GET("/jobs/dead", d.AdminHandler.ListDeadJobs)
+		admin.POST("/jobs/:job_id/retry", d.AdminHandler.RetryDeadJob)
 	}
 
 	// Admin project encryption routes - protected by ProjectAuth (Bearer API key)
 	adminProject := r.Group("/admin/v1")
 	{
-		adminProject.Use(middleware.ProjectAuth(d.Config, d.DB, d.Redis))
+		adminProject.Use(middleware.ProjectAuth(d.Config, d.DB, d.Redis), middleware.RequireSecretKey())
 
 		adminProject.POST("/project/encrypt", d.AdminHandler.EncryptProject)
 		adminProject.POST("/project/decrypt", d.AdminHandler.DecryptProject)
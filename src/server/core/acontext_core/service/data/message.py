@@ -1,4 +1,6 @@
 import asyncio
+import base64
+import gzip
 import json
 from typing import List
 from sqlalchemy import select, func
@@ -14,6 +16,22 @@ from ...infra.s3 import S3_CLIENT
 from ...env import LOG
 
 
+# Part meta key the API moves the gzip-compressed, base64-encoded text of large parts into.
+# Matches model.MetaKeyTextGzip in the Go API.
+META_KEY_TEXT_GZIP = "text_gzip"
+
+
+def _decompress_part(pj: dict) -> dict:
+    """Restore the text of a part the API stored compressed."""
+    meta = pj.get("meta") or {}
+    encoded = meta.get(META_KEY_TEXT_GZIP)
+    if not isinstance(encoded, str):
+        return pj
+    meta = {k: v for k, v in meta.items() if k != META_KEY_TEXT_GZIP}
+    text = gzip.decompress(base64.b64decode(encoded)).decode("utf-8")
+    return {**pj, "text": text, "meta": meta or None}
+
+
 async def _fetch_message_parts(
     parts_meta: dict, user_kek: bytes | None = None
 ) -> Result[List[Part]]:
@@ -39,7 +57,7 @@ async def _fetch_message_parts(
         parts_json = json.loads(parts_json_bytes.decode("utf-8"))
         assert isinstance(parts_json, list), "Parts Json must be a list"
         try:
-            parts = [Part(**pj) for pj in parts_json]
+            parts = [Part(**_decompress_part(pj)) for pj in parts_json]
         except ValidationError as e:
             return Result.reject(f"Failed to validate parts {parts_json}: {e}")
         return Result.resolve(parts)